@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Acquisitions record how and when each book entered the collection: the
+// purchase order and vendor it was bought through and what it cost, or
+// that it was donated, exchanged or transferred. A book has at most one.
+// A donated book's acquisition can name the donation it came in.
+// Reports total them per vendor and per fiscal year, which starts in the
+// FISCAL_YEAR_START month and is named by the calendar year it ends in.
+
+// How a book was acquired
+const (
+	acquiredPurchase = "purchase"
+	acquiredDonation = "donation"
+	acquiredExchange = "exchange"
+	acquiredTransfer = "transfer"
+)
+
+var acquisitionMethods = setOf(acquiredPurchase, acquiredDonation, acquiredExchange, acquiredTransfer)
+
+// Acquisition is how and when a book entered the collection
+type Acquisition struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	BookID        uint   `json:"book_id" gorm:"uniqueIndex;not null"`
+	Method        string `json:"method" gorm:"not null;default:purchase"`
+	PurchaseOrder string `json:"purchase_order,omitempty" gorm:"index"`
+	Vendor        string `json:"vendor,omitempty" gorm:"index"`
+	DonationID    *uint  `json:"donation_id,omitempty" gorm:"index"`
+	// In the minor unit of the ISO 4217 currency
+	CostCents int64  `json:"cost_cents,omitempty"`
+	Currency  string `json:"currency,omitempty"`
+	// The date the book arrived, as YYYY-MM-DD
+	AcquiredOn string    `json:"acquired_on" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AcquisitionRequest is the body of POST and PUT /acquisitions
+type AcquisitionRequest struct {
+	BookID        uint   `json:"book_id"`
+	Method        string `json:"method"`
+	PurchaseOrder string `json:"purchase_order"`
+	Vendor        string `json:"vendor"`
+	DonationID    *uint  `json:"donation_id"`
+	CostCents     int64  `json:"cost_cents"`
+	Currency      string `json:"currency"`
+	AcquiredOn    string `json:"acquired_on"`
+}
+
+// VendorAcquisitions is a vendor's line in the vendor report
+type VendorAcquisitions struct {
+	Vendor string `json:"vendor"`
+	Books  int64  `json:"books"`
+	// What the books cost, per currency
+	Totals         []CurrencyValue `json:"totals"`
+	LastAcquiredOn string          `json:"last_acquired_on"`
+}
+
+// FiscalYearAcquisitions is a fiscal year's line in the fiscal year report
+type FiscalYearAcquisitions struct {
+	FiscalYear int    `json:"fiscal_year"`
+	StartsOn   string `json:"starts_on"`
+	EndsOn     string `json:"ends_on"`
+	Books      int64  `json:"books"`
+	// Books acquired each way, such as purchase
+	Methods map[string]int64 `json:"methods"`
+	// What the books cost, per currency
+	Totals []CurrencyValue `json:"totals"`
+}
+
+// The first and last day of a fiscal year
+func fiscalYearBounds(year int) (string, string) {
+	start := cfg.fiscalYearStart()
+	first := time.Date(year, start, 1, 0, 0, 0, 0, time.UTC)
+	if start != time.January {
+		first = first.AddDate(-1, 0, 0)
+	}
+	return first.Format(time.DateOnly), first.AddDate(1, 0, -1).Format(time.DateOnly)
+}
+
+// The fiscal year of an acquired_on date, as SQL
+func fiscalYearSQL() (string, []interface{}) {
+	start := int(cfg.fiscalYearStart())
+	if start == 1 {
+		return "CAST(substr(acquired_on, 1, 4) AS INTEGER)", nil
+	}
+	return "CAST(substr(acquired_on, 1, 4) AS INTEGER) + (CAST(substr(acquired_on, 6, 2) AS INTEGER) >= ?)", []interface{}{start}
+}
+
+func validateAcquisition(req *AcquisitionRequest) []FieldError {
+	var errs []FieldError
+	if req.BookID == 0 {
+		errs = append(errs, FieldError{Field: "book_id", Message: "is required"})
+	}
+	req.Method = strings.ToLower(strings.TrimSpace(req.Method))
+	if req.Method == "" {
+		req.Method = acquiredPurchase
+	}
+	if !acquisitionMethods[req.Method] {
+		errs = append(errs, FieldError{Field: "method", Message: "must be purchase, donation, exchange or transfer"})
+	}
+	req.PurchaseOrder = strings.TrimSpace(req.PurchaseOrder)
+	req.Vendor = strings.TrimSpace(req.Vendor)
+	if req.Vendor == "" && req.Method == acquiredPurchase {
+		errs = append(errs, FieldError{Field: "vendor", Message: "is required for a purchase"})
+	}
+	if req.DonationID != nil && req.Method != acquiredDonation {
+		errs = append(errs, FieldError{Field: "donation_id", Message: "is only for a donation"})
+	}
+	if req.CostCents < 0 {
+		errs = append(errs, FieldError{Field: "cost_cents", Message: "must not be negative"})
+	}
+	req.Currency = normalizeCurrency(req.Currency)
+	if req.Currency != "" && !validCurrency(req.Currency) {
+		errs = append(errs, FieldError{Field: "currency", Message: "must be an ISO 4217 code, such as USD"})
+	} else if req.Currency == "" && req.CostCents > 0 {
+		errs = append(errs, FieldError{Field: "currency", Message: "is required with a cost"})
+	}
+	// Today when not given
+	req.AcquiredOn = strings.TrimSpace(req.AcquiredOn)
+	if req.AcquiredOn == "" {
+		req.AcquiredOn = time.Now().UTC().Format(time.DateOnly)
+	} else if _, err := time.Parse(time.DateOnly, req.AcquiredOn); err != nil {
+		errs = append(errs, FieldError{Field: "acquired_on", Message: "must be a date, such as 2026-03-14"})
+	}
+	return errs
+}
+
+// List acquisitions, most recent first. ?vendor=, ?method=, ?book_id= and
+// ?fiscal_year= narrow the list.
+func getAcquisitions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, ok := acquisitionsQuery(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	if vendor := q.Get("vendor"); vendor != "" {
+		query = query.Where("vendor = ?", vendor)
+	}
+	if method := q.Get("method"); method != "" {
+		query = query.Where("method = ?", strings.ToLower(method))
+	}
+	if v := q.Get("book_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+			return
+		}
+		query = query.Where("book_id = ?", id)
+	}
+	var acquisitions []Acquisition
+	if err := query.Order("acquired_on DESC, id DESC").Find(&acquisitions).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list acquisitions")
+		return
+	}
+	writeList(w, r, acquisitions)
+}
+
+// The acquisitions of the books in the request's library, in the
+// ?fiscal_year= when it's given
+func acquisitionsQuery(w http.ResponseWriter, r *http.Request) (*gorm.DB, bool) {
+	query := db.WithContext(r.Context()).Model(&Acquisition{}).Where("book_id IN (?)", libraryBookIDs(r.Context()))
+	if v := r.URL.Query().Get("fiscal_year"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil || year < 1 || year > 9999 {
+			writeError(w, r, http.StatusBadRequest, "invalid_fiscal_year", "fiscal_year must be a year")
+			return nil, false
+		}
+		first, last := fiscalYearBounds(year)
+		query = query.Where("acquired_on BETWEEN ? AND ?", first, last)
+	}
+	return query, true
+}
+
+// Whether the book is in the context's catalog, the trash included
+func acquirableBook(ctx context.Context, id uint) (bool, error) {
+	var count int64
+	err := db.WithContext(ctx).Unscoped().Model(&Book{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// Returned when a book already has an acquisition
+var errAcquisitionExists = errors.New("book already has an acquisition")
+
+// Record how a book was acquired. A cost is copied to the book's purchase
+// price when it doesn't have one yet.
+func createAcquisition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req AcquisitionRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	errs, err := applyDonation(&req)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record acquisition")
+		return
+	}
+	errs = append(errs, validateAcquisition(&req)...)
+	if req.BookID != 0 {
+		found, err := acquirableBook(r.Context(), req.BookID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record acquisition")
+			return
+		}
+		if !found {
+			errs = append(errs, FieldError{Field: "book_id", Message: "is not a book in the catalog"})
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	acquisition := Acquisition{
+		BookID: req.BookID, Method: req.Method, PurchaseOrder: req.PurchaseOrder, Vendor: req.Vendor, DonationID: req.DonationID,
+		CostCents: req.CostCents, Currency: req.Currency, AcquiredOn: req.AcquiredOn,
+	}
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Acquisition{}).Where("book_id = ?", req.BookID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errAcquisitionExists
+		}
+		if err := tx.Create(&acquisition).Error; err != nil {
+			return err
+		}
+		if acquisition.CostCents == 0 {
+			return nil
+		}
+		var book Book
+		if err := tx.Unscoped().First(&book, acquisition.BookID).Error; err != nil {
+			return err
+		}
+		if book.PurchasePriceCents != 0 {
+			return nil
+		}
+		return tx.Model(&book).Updates(map[string]interface{}{
+			"purchase_price_cents": acquisition.CostCents, "purchase_currency": acquisition.Currency,
+		}).Error
+	})
+	if errors.Is(err, errAcquisitionExists) {
+		writeError(w, r, http.StatusConflict, "acquisition_exists", "The book already has an acquisition record")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record acquisition")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(acquisition.ID), 10))
+	writeJSON(w, http.StatusCreated, acquisition)
+}
+
+// Load the acquisition named in the URL
+func loadAcquisition(w http.ResponseWriter, r *http.Request) (*Acquisition, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_acquisition_id", "Invalid acquisition ID")
+		return nil, false
+	}
+	var acquisition Acquisition
+	if err := db.Where("book_id IN (?)", libraryBookIDs(r.Context())).First(&acquisition, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "acquisition_not_found", "Acquisition not found")
+		return nil, false
+	}
+	return &acquisition, true
+}
+
+// Get an acquisition by ID
+func getAcquisition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if acquisition, ok := loadAcquisition(w, r); ok {
+		writeJSON(w, http.StatusOK, acquisition)
+	}
+}
+
+// Correct an acquisition. It stays with its book; the book's purchase
+// price is left alone.
+func updateAcquisition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	acquisition, ok := loadAcquisition(w, r)
+	if !ok {
+		return
+	}
+	var req AcquisitionRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if req.BookID == 0 {
+		req.BookID = acquisition.BookID
+	}
+	errs, err := applyDonation(&req)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update acquisition")
+		return
+	}
+	errs = append(errs, validateAcquisition(&req)...)
+	if req.BookID != acquisition.BookID {
+		errs = append(errs, FieldError{Field: "book_id", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	acquisition.Method, acquisition.PurchaseOrder, acquisition.Vendor = req.Method, req.PurchaseOrder, req.Vendor
+	acquisition.DonationID, acquisition.CostCents, acquisition.Currency, acquisition.AcquiredOn = req.DonationID, req.CostCents, req.Currency, req.AcquiredOn
+	err = db.Select("Method", "PurchaseOrder", "Vendor", "DonationID", "CostCents", "Currency", "AcquiredOn", "UpdatedAt").Updates(acquisition).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update acquisition")
+		return
+	}
+	writeJSON(w, http.StatusOK, acquisition)
+}
+
+// Delete an acquisition record
+func deleteAcquisition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	acquisition, ok := loadAcquisition(w, r)
+	if !ok {
+		return
+	}
+	if err := db.Delete(&Acquisition{}, acquisition.ID).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete acquisition")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Books acquired per vendor, with what they cost. ?fiscal_year= limits it
+// to one year.
+func getVendorReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, ok := acquisitionsQuery(w, r)
+	if !ok {
+		return
+	}
+	var rows []struct {
+		Vendor         string
+		Currency       string
+		Books          int64
+		TotalCents     int64
+		LastAcquiredOn string
+	}
+	err := query.
+		Select("vendor, currency, COUNT(*) AS books, SUM(cost_cents) AS total_cents, MAX(acquired_on) AS last_acquired_on").
+		Where("vendor <> ''").
+		Group("vendor, currency").Order("vendor, currency").
+		Scan(&rows).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to compute the vendor report")
+		return
+	}
+	var vendors []VendorAcquisitions
+	for _, row := range rows {
+		if len(vendors) == 0 || vendors[len(vendors)-1].Vendor != row.Vendor {
+			vendors = append(vendors, VendorAcquisitions{Vendor: row.Vendor, Totals: []CurrencyValue{}})
+		}
+		v := &vendors[len(vendors)-1]
+		v.Books += row.Books
+		if row.LastAcquiredOn > v.LastAcquiredOn {
+			v.LastAcquiredOn = row.LastAcquiredOn
+		}
+		if row.Currency != "" {
+			v.Totals = append(v.Totals, CurrencyValue{Currency: row.Currency, TotalCents: row.TotalCents, Books: row.Books})
+		}
+	}
+	writeList(w, r, vendors)
+}
+
+// Books acquired per fiscal year, most recent first, by method and with
+// what they cost
+func getFiscalYearReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, ok := acquisitionsQuery(w, r)
+	if !ok {
+		return
+	}
+	fiscalYear, args := fiscalYearSQL()
+	var rows []struct {
+		FiscalYear int
+		Method     string
+		Currency   string
+		Books      int64
+		TotalCents int64
+	}
+	err := query.
+		Select("("+fiscalYear+") AS fiscal_year, method, currency, COUNT(*) AS books, SUM(cost_cents) AS total_cents", args...).
+		Group("fiscal_year, method, currency").
+		Scan(&rows).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to compute the fiscal year report")
+		return
+	}
+	byYear := map[int]*FiscalYearAcquisitions{}
+	totals := map[int]map[string]*CurrencyValue{}
+	for _, row := range rows {
+		y := byYear[row.FiscalYear]
+		if y == nil {
+			first, last := fiscalYearBounds(row.FiscalYear)
+			y = &FiscalYearAcquisitions{FiscalYear: row.FiscalYear, StartsOn: first, EndsOn: last, Methods: map[string]int64{}}
+			byYear[row.FiscalYear] = y
+			totals[row.FiscalYear] = map[string]*CurrencyValue{}
+		}
+		y.Books += row.Books
+		y.Methods[row.Method] += row.Books
+		if row.Currency == "" {
+			continue
+		}
+		t := totals[row.FiscalYear][row.Currency]
+		if t == nil {
+			t = &CurrencyValue{Currency: row.Currency}
+			totals[row.FiscalYear][row.Currency] = t
+		}
+		t.Books += row.Books
+		t.TotalCents += row.TotalCents
+	}
+	years := make([]FiscalYearAcquisitions, 0, len(byYear))
+	for year, y := range byYear {
+		y.Totals = []CurrencyValue{}
+		for _, t := range totals[year] {
+			y.Totals = append(y.Totals, *t)
+		}
+		sort.Slice(y.Totals, func(i, j int) bool { return y.Totals[i].Currency < y.Totals[j].Currency })
+		years = append(years, *y)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].FiscalYear > years[j].FiscalYear })
+	writeList(w, r, years)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFiscalYearBounds(t *testing.T) {
+	saved := cfg.FiscalYearStart
+	defer func() { cfg.FiscalYearStart = saved }()
+
+	for start, want := range map[int][2]string{
+		1:  {"2026-01-01", "2026-12-31"},
+		7:  {"2025-07-01", "2026-06-30"},
+		13: {"2026-01-01", "2026-12-31"},
+	} {
+		cfg.FiscalYearStart = start
+		if first, last := fiscalYearBounds(2026); first != want[0] || last != want[1] {
+			t.Errorf("Start %d: expected %v, got %s to %s", start, want, first, last)
+		}
+	}
+}
+
+func TestAcquisitionCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+
+	for _, body := range []string{
+		`{"book_id":999,"vendor":"Baker & Taylor"}`,
+		fmt.Sprintf(`{"book_id":%d}`, dune.ID),
+		fmt.Sprintf(`{"book_id":%d,"vendor":"Baker & Taylor","cost_cents":1999}`, dune.ID),
+		fmt.Sprintf(`{"book_id":%d,"vendor":"Baker & Taylor","acquired_on":"14/03/2026"}`, dune.ID),
+		fmt.Sprintf(`{"book_id":%d,"method":"theft"}`, dune.ID),
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/acquisitions", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d: %s", body, response.Code, response.Body.String())
+		}
+	}
+
+	response := webhookRequest(t, router, "POST", "/api/v1/acquisitions",
+		fmt.Sprintf(`{"book_id":%d,"vendor":"Baker & Taylor","purchase_order":"PO-1042","cost_cents":1999,"currency":"usd","acquired_on":"2026-03-14"}`, dune.ID))
+	var acquisition Acquisition
+	json.Unmarshal(response.Body.Bytes(), &acquisition)
+	if response.Code != http.StatusCreated || acquisition.Method != acquiredPurchase || acquisition.Currency != "USD" {
+		t.Fatalf("Expected the purchase recorded, got %d: %s", response.Code, response.Body.String())
+	}
+	db.First(&dune, dune.ID)
+	if dune.PurchasePriceCents != 1999 || dune.PurchaseCurrency != "USD" {
+		t.Errorf("Expected the cost copied to the book, got %d %s", dune.PurchasePriceCents, dune.PurchaseCurrency)
+	}
+	response = webhookRequest(t, router, "POST", "/api/v1/acquisitions", fmt.Sprintf(`{"book_id":%d,"method":"donation"}`, dune.ID))
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a second acquisition, got %d", response.Code)
+	}
+
+	path := fmt.Sprintf("/api/v1/acquisitions/%d", acquisition.ID)
+	response = webhookRequest(t, router, "PUT", path, `{"vendor":"Ingram","cost_cents":1899,"currency":"USD","acquired_on":"2026-03-15"}`)
+	acquisition = Acquisition{}
+	json.Unmarshal(response.Body.Bytes(), &acquisition)
+	if response.Code != http.StatusOK || acquisition.Vendor != "Ingram" || acquisition.PurchaseOrder != "" || acquisition.BookID != dune.ID {
+		t.Errorf("Expected the acquisition replaced, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "PUT", path, `{"book_id":999,"vendor":"Ingram"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 moving the acquisition to another book, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/acquisitions?vendor=Ingram", "")
+	var list []Acquisition
+	json.Unmarshal(response.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != acquisition.ID {
+		t.Errorf("Expected the acquisition listed, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete, got %d", response.Code)
+	}
+}
+
+func TestAcquisitionReports(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	saved := cfg.FiscalYearStart
+	cfg.FiscalYearStart = 7
+	defer func() { cfg.FiscalYearStart = saved }()
+
+	isbns := []string{"9780441013593", "9780441172696", "9780141439587", "9780441569595", "9780132350884"}
+	for i, body := range []string{
+		`"vendor":"Baker & Taylor","cost_cents":1999,"currency":"USD","acquired_on":"2025-06-30"`,
+		`"vendor":"Baker & Taylor","cost_cents":1500,"currency":"USD","acquired_on":"2025-07-01"`,
+		`"vendor":"Baker & Taylor","cost_cents":899,"currency":"GBP","acquired_on":"2026-02-01"`,
+		`"vendor":"Ingram","cost_cents":2500,"currency":"USD","acquired_on":"2026-06-30"`,
+		`"method":"donation","acquired_on":"2026-07-01"`,
+	} {
+		book := Book{Title: fmt.Sprint("Book ", i), Author: "A", ISBN: isbns[i]}
+		db.Create(&book)
+		response := webhookRequest(t, router, "POST", "/api/v1/acquisitions", fmt.Sprintf(`{"book_id":%d,%s}`, book.ID, body))
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+		}
+	}
+
+	response := webhookRequest(t, router, "GET", "/api/v1/acquisitions/reports/vendors", "")
+	var vendors []VendorAcquisitions
+	json.Unmarshal(response.Body.Bytes(), &vendors)
+	if len(vendors) != 2 || vendors[0].Vendor != "Baker & Taylor" || vendors[0].Books != 3 || vendors[0].LastAcquiredOn != "2026-02-01" ||
+		fmt.Sprint(vendors[0].Totals) != "[{GBP 899 1} {USD 3499 2}]" || fmt.Sprint(vendors[1].Totals) != "[{USD 2500 1}]" {
+		t.Errorf("Unexpected vendor report %s", response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", "/api/v1/acquisitions/reports/vendors?fiscal_year=2026", "")
+	vendors = nil
+	json.Unmarshal(response.Body.Bytes(), &vendors)
+	if len(vendors) != 2 || vendors[0].Books != 2 || fmt.Sprint(vendors[0].Totals) != "[{GBP 899 1} {USD 1500 1}]" {
+		t.Errorf("Unexpected vendor report for 2026 %s", response.Body.String())
+	}
+
+	// July to June, named by the year it ends in
+	response = webhookRequest(t, router, "GET", "/api/v1/acquisitions/reports/fiscal-years", "")
+	var years []FiscalYearAcquisitions
+	json.Unmarshal(response.Body.Bytes(), &years)
+	if len(years) != 3 {
+		t.Fatalf("Expected three fiscal years, got %s", response.Body.String())
+	}
+	if y := years[0]; y.FiscalYear != 2027 || y.Books != 1 || y.Methods["donation"] != 1 || len(y.Totals) != 0 {
+		t.Errorf("Unexpected fiscal year %+v", y)
+	}
+	if y := years[1]; y.FiscalYear != 2026 || y.StartsOn != "2025-07-01" || y.EndsOn != "2026-06-30" || y.Books != 3 ||
+		fmt.Sprint(y.Totals) != "[{GBP 899 1} {USD 4000 2}]" {
+		t.Errorf("Unexpected fiscal year %+v", y)
+	}
+	if y := years[2]; y.FiscalYear != 2025 || y.Books != 1 {
+		t.Errorf("Unexpected fiscal year %+v", y)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/acquisitions?fiscal_year=next", ""); response.Code != http.StatusBadRequest ||
+		!strings.Contains(response.Body.String(), "fiscal_year") {
+		t.Errorf("Expected status 400 for a bad fiscal year, got %d", response.Code)
+	}
+}
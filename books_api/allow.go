@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Methods routes are registered for, in the order Allow lists them
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// The methods router has a route for at the request's path, none if the
+// path isn't routed. OPTIONS is allowed on every routed path.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := r.WithContext(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if allowed == nil {
+		return nil
+	}
+	return append(allowed, "OPTIONS")
+}
+
+// Answer requests no route matched. To a routed path with a method it has
+// no route for, OPTIONS, as sent by CORS preflights, succeeds and lists
+// the methods the path takes, and anything else is a 405. Both send them
+// in Allow, so every route gets OPTIONS without registering it. Other
+// paths are not found. mux reports a method mismatch inside a subrouter
+// as not found, so both cases come here and the methods are probed.
+func unmatchedHandler(router *mux.Router) http.Handler {
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := allowedMethods(router, r)
+		if methods == nil {
+			writeError(w, r, http.StatusNotFound, "not_found", "404 page not found")
+			return
+		}
+		allowed := strings.Join(methods, ", ")
+		w.Header().Set("Allow", allowed)
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", allowed)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}))
+	// Route middleware doesn't run for unmatched methods, so set the
+	// version here for the error's format
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, v := range apiVersions {
+			if strings.HasPrefix(r.URL.Path, v.Prefix+"/") {
+				v.middleware(handler).ServeHTTP(w, r)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
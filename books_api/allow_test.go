@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutomaticOptions(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	for path, want := range map[string]string{
+		"/api/v1/books":           "GET, HEAD, POST, DELETE, OPTIONS",
+		"/api/v1/books/1":         "GET, PUT, PATCH, DELETE, OPTIONS",
+		"/api/v2/books/1/reviews": "GET, OPTIONS",
+		"/api/v1/authors":         "GET, POST, OPTIONS",
+		"/health":                 "GET, OPTIONS",
+	} {
+		response := send("OPTIONS", path)
+		if response.Code != http.StatusOK || response.Header().Get("Allow") != want {
+			t.Errorf("OPTIONS %s: expected 200 with Allow %q, got %d with %q", path, want, response.Code, response.Header().Get("Allow"))
+		}
+		if got := response.Header().Get("Access-Control-Allow-Methods"); got != want {
+			t.Errorf("OPTIONS %s: expected Access-Control-Allow-Methods %q, got %q", path, want, got)
+		}
+		if response.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("OPTIONS %s: expected CORS headers", path)
+		}
+	}
+
+	response := send("PUT", "/api/v1/authors")
+	if response.Code != http.StatusMethodNotAllowed || response.Header().Get("Allow") != "GET, POST, OPTIONS" {
+		t.Errorf("Expected 405 with Allow, got %d with %q", response.Code, response.Header().Get("Allow"))
+	}
+	response = send("POST", "/api/v2/books/1/reviews")
+	if response.Code != http.StatusMethodNotAllowed || response.Header().Get("Content-Type") != problemType || response.Header().Get("API-Version") != "v2" {
+		t.Errorf("Expected a v2 problem, got %d: %s", response.Code, response.Body.String())
+	}
+
+	if response := send("OPTIONS", "/api/v1/nothing"); response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unrouted path, got %d", response.Code)
+	}
+}
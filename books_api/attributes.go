@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Limits on a book's custom attributes
+const (
+	maxBookAttributes      = 50
+	maxAttributeValueBytes = 500
+)
+
+// Attribute names are lowercase identifiers, such as donated_by, so they
+// can name a JSON path and a query parameter as they are
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Query parameters filtering by an attribute are ?attr.<name>=
+const attributeParamPrefix = "attr."
+
+// Validate a book's attributes, dropping an empty set so it isn't stored
+// as {}
+func validateAttributes(book *Book) []FieldError {
+	if len(book.Attributes) == 0 {
+		book.Attributes = nil
+		return nil
+	}
+	var errs []FieldError
+	if len(book.Attributes) > maxBookAttributes {
+		errs = append(errs, FieldError{Field: "attributes", Message: fmt.Sprintf("must have at most %d entries", maxBookAttributes)})
+	}
+	names := make([]string, 0, len(book.Attributes))
+	for name := range book.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !attributeNamePattern.MatchString(name) {
+			errs = append(errs, FieldError{Field: "attributes." + name, Message: "must be named with lowercase letters, digits and underscores, starting with a letter"})
+		} else if len(book.Attributes[name]) > maxAttributeValueBytes {
+			errs = append(errs, FieldError{Field: "attributes." + name, Message: fmt.Sprintf("must be at most %d bytes", maxAttributeValueBytes)})
+		}
+	}
+	return errs
+}
+
+// Read the ?attr.<name>= filters of a listing
+func parseAttributeFilters(q url.Values) (map[string]string, error) {
+	var attrs map[string]string
+	for param, values := range q {
+		name, ok := strings.CutPrefix(param, attributeParamPrefix)
+		if !ok {
+			continue
+		}
+		if !attributeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s doesn't name an attribute", param)
+		}
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		attrs[name] = values[0]
+	}
+	return attrs, nil
+}
+
+// Keep the books whose attributes have each of attrs' values exactly
+func applyAttributeFilters(query *gorm.DB, attrs map[string]string) *gorm.DB {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query = query.Where("json_extract(attributes, ?) = ?", "$."+name, attrs[name])
+	}
+	return query
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	book := Book{Title: "T", Author: "A", ISBN: "9780441013593", Attributes: map[string]string{}}
+	if errs := validateBook(&book); len(errs) != 0 || book.Attributes != nil {
+		t.Errorf("Expected empty attributes dropped, got %v and %v", book.Attributes, errs)
+	}
+	book.Attributes = map[string]string{"donated_by": "Smith", "Shelf Note": "x", "cost": strings.Repeat("9", maxAttributeValueBytes+1)}
+	errs := validateBook(&book)
+	if len(errs) != 2 || errs[0].Field != "attributes.Shelf Note" || errs[1].Field != "attributes.cost" {
+		t.Errorf("Expected errors for the bad name and the long value, got %v", errs)
+	}
+}
+
+func TestBookAttributes(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","attributes":{"donated_by":"Smith","shelf_note":"Top shelf"}}`)
+	var dune Book
+	json.Unmarshal(response.Body.Bytes(), &dune)
+	if response.Code != http.StatusCreated || dune.Attributes["donated_by"] != "Smith" {
+		t.Fatalf("Expected the attributes stored, got %d: %s", response.Code, response.Body.String())
+	}
+	webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","attributes":{"donated_by":"Jones"}}`)
+	webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Neuromancer","author":"William Gibson","isbn":"9780441569595"}`)
+
+	for query, want := range map[string]string{
+		"attr.donated_by=Smith":                        "Dune",
+		"attr.donated_by=smith":                        "",
+		"attr.donated_by=Jones&author=austen":          "Emma",
+		"attr.donated_by=Smith&attr.shelf_note=Bottom": "",
+	} {
+		response := webhookRequest(t, router, "GET", "/api/v1/books?"+query, "")
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if got := strings.Join(bookTitles(books), ","); response.Code != http.StatusOK || got != want {
+			t.Errorf("Expected %q for %s, got %d: %q", want, query, response.Code, got)
+		}
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/books?attr.Donated-By=Smith", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad attribute name, got %d", response.Code)
+	}
+
+	// A merge patch merges into the attributes, and null removes one
+	path := fmt.Sprintf("/api/v1/books/%d", dune.ID)
+	req, _ := http.NewRequest("PATCH", path, strings.NewReader(`{"attributes":{"shelf_note":null,"condition":"good"}}`))
+	req.Header.Set("Content-Type", mergePatchType)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	dune = Book{}
+	json.Unmarshal(response.Body.Bytes(), &dune)
+	if want := map[string]string{"donated_by": "Smith", "condition": "good"}; response.Code != http.StatusOK || fmt.Sprint(dune.Attributes) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %d: %s", want, response.Code, response.Body.String())
+	}
+
+	// PUT replaces them
+	response = webhookRequest(t, router, "PUT", path, `{"attributes":{"condition":"worn"}}`)
+	dune = Book{}
+	json.Unmarshal(response.Body.Bytes(), &dune)
+	if response.Code != http.StatusOK || len(dune.Attributes) != 1 || dune.Attributes["condition"] != "worn" {
+		t.Errorf("Expected the attributes replaced, got %d: %s", response.Code, response.Body.String())
+	}
+}
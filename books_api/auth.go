@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Roles, least privileged first. Each role includes the ones before it.
+const (
+	roleReader    = "reader"
+	roleLibrarian = "librarian"
+	roleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{roleReader: 1, roleLibrarian: 2, roleAdmin: 3}
+
+// Principal is an authenticated user
+type Principal struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Roles    []string `json:"roles"`
+	Backend  string   `json:"backend"`
+}
+
+// HasRole reports whether the principal holds role or a higher one
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if roleRank[r] >= roleRank[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks a username and password against an identity store
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Principal, error)
+}
+
+// Returned for a wrong username or password
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Returned when the credentials are valid but map to no role
+var errNoRole = errors.New("no role assigned")
+
+// Active password authenticator, chosen by initAuth. nil disables password
+// login.
+var authenticator Authenticator
+
+// Whether any auth backend is enabled. Without one every route is open.
+func authEnabled() bool {
+	return authenticator != nil || samlSP != nil
+}
+
+// Key signing session tokens
+var authKey []byte
+
+func initAuth() {
+	switch cfg.AuthBackend {
+	case "ldap":
+		authenticator = newLDAPAuthenticator(cfg.LDAP)
+	case "saml":
+		sp, err := newSAMLServiceProvider(cfg.SAML)
+		if err != nil {
+			log.Fatalf("Configuring SAML: %v", err)
+		}
+		samlSP = sp
+	case "", "none":
+		authenticator = nil
+	default:
+		log.Fatalf("Unknown AUTH_BACKEND %q", cfg.AuthBackend)
+	}
+
+	authKey = []byte(cfg.AuthSecret)
+	if len(authKey) == 0 {
+		authKey = make([]byte, 32)
+		rand.Read(authKey)
+		if authEnabled() {
+			log.Println("AUTH_SECRET not set, sessions will not survive a restart")
+		}
+	}
+}
+
+// Group-to-role mapping, parsed from "role=group;role=group"
+type roleMapping struct {
+	role  string
+	group string
+}
+
+// Parse "role=group;role=group". Groups may contain "=", as DNs do, so only
+// the first one separates the role.
+func parseRoleMappings(value string) ([]roleMapping, error) {
+	var mappings []roleMapping
+	for _, item := range strings.Split(value, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		role, group, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, errors.New("expected role=group, got " + item)
+		}
+		role = strings.TrimSpace(role)
+		if roleRank[role] == 0 {
+			return nil, errors.New("unknown role " + role)
+		}
+		mappings = append(mappings, roleMapping{role: role, group: strings.TrimSpace(group)})
+	}
+	return mappings, nil
+}
+
+// Roles granted by a user's groups, or defaultRole when none match. A
+// mapping matches a group by its full DN or by its first RDN value, so
+// "admin=Library Admins" matches "CN=Library Admins,OU=Groups,DC=uni,DC=edu".
+func mapRoles(groups []string, mappings []roleMapping, defaultRole string) []string {
+	var roles []string
+	seen := map[string]bool{}
+	for _, m := range mappings {
+		for _, g := range groups {
+			if !seen[m.role] && groupMatches(g, m.group) {
+				roles = append(roles, m.role)
+				seen[m.role] = true
+			}
+		}
+	}
+	if len(roles) == 0 && roleRank[defaultRole] > 0 {
+		roles = []string{defaultRole}
+	}
+	return roles
+}
+
+func groupMatches(group, want string) bool {
+	if strings.EqualFold(normalizeDN(group), normalizeDN(want)) {
+		return true
+	}
+	first, _, _ := strings.Cut(group, ",")
+	_, name, ok := strings.Cut(first, "=")
+	return ok && strings.EqualFold(strings.TrimSpace(name), want)
+}
+
+// Drop the optional spaces around RDN separators
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		attr, value, _ := strings.Cut(p, "=")
+		parts[i] = strings.TrimSpace(attr) + "=" + strings.TrimSpace(value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Signed session token contents
+type tokenClaims struct {
+	Principal
+	Expires int64 `json:"exp"`
+}
+
+// Issue a session token for p: base64 claims and an HMAC over them
+func issueToken(p *Principal, ttl time.Duration) (string, time.Time) {
+	expires := time.Now().Add(ttl)
+	claims, _ := json.Marshal(tokenClaims{Principal: *p, Expires: expires.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + signToken(payload), expires
+}
+
+func signToken(payload string) string {
+	mac := hmac.New(sha256.New, authKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify a session token and return its principal
+func parseToken(token string) (*Principal, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signToken(payload))) {
+		return nil, errors.New("invalid token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, errors.New("token expired")
+	}
+	return &claims.Principal, nil
+}
+
+type principalKey struct{}
+
+// Principal of an authenticated request, or nil
+func principalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Attach the principal named by a bearer token. Requests without a token
+// pass through anonymously; a bad token is rejected.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !authEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := parseToken(strings.TrimSpace(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, r, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// Require role on the routes it wraps. Without an auth backend every
+// request is allowed, as before authentication existed.
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authEnabled() || r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p := principalFrom(r)
+			if p == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, http.StatusUnauthorized, "authentication_required", "Authentication required")
+				return
+			}
+			if !p.HasRole(role) {
+				writeError(w, r, http.StatusForbidden, "forbidden", "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Require librarian for changes to the catalog, its authors, publishers
+// and series; reads stay public
+func catalogWriteMiddleware(next http.Handler) http.Handler {
+	guarded := requireRole(roleLibrarian)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := apiPath(r)
+		writes := r.Method != "GET" && r.Method != "HEAD"
+		if writes && (strings.HasPrefix(path, "/books") || strings.HasPrefix(path, "/authors") ||
+			strings.HasPrefix(path, "/publishers") || strings.HasPrefix(path, "/series") || strings.HasPrefix(path, "/import")) {
+			guarded.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LoginRequest is the body of POST /auth/login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse carries a session token for the Authorization header
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      Principal `json:"user"`
+}
+
+// Exchange a username and password for a session token
+func login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if authenticator == nil {
+		msg := "Authentication is not enabled"
+		if samlSP != nil {
+			msg = "Password login is not enabled, sign in at /api/v1/auth/saml/login"
+		}
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", msg)
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+
+	p, err := authenticator.Authenticate(r.Context(), strings.TrimSpace(req.Username), req.Password)
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "Invalid username or password")
+		return
+	case errors.Is(err, errNoRole):
+		writeError(w, r, http.StatusForbidden, "no_role", "No role is assigned to this account")
+		return
+	case err != nil:
+		log.Printf("Authenticating %q failed: %v", req.Username, err)
+		writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Authentication service unavailable")
+		return
+	}
+
+	if err := runAuthHooks(r.Context(), p); err != nil {
+		writeAuthHookError(w, r, p.Username, err)
+		return
+	}
+
+	token, expires := issueToken(p, cfg.AuthTokenTTL)
+	writeJSON(w, http.StatusOK, LoginResponse{Token: token, ExpiresAt: expires, User: *p})
+}
+
+// The authenticated user
+func getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	p := principalFrom(r)
+	if p == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, "authentication_required", "Authentication required")
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
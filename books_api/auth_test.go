@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Authenticator accepting fixed passwords
+type stubAuthenticator map[string]*Principal
+
+func (s stubAuthenticator) Authenticate(ctx context.Context, username, password string) (*Principal, error) {
+	p, ok := s[username+":"+password]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	return p, nil
+}
+
+func withAuth(t *testing.T, a Authenticator) {
+	old, oldKey := authenticator, authKey
+	authenticator, authKey = a, []byte("test-secret")
+	t.Cleanup(func() { authenticator, authKey = old, oldKey })
+}
+
+func loginAs(t *testing.T, router http.Handler, username, password string) string {
+	body, _ := json.Marshal(LoginRequest{Username: username, Password: password})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Login as %s: expected status 200, got %d: %s", username, response.Code, response.Body.String())
+	}
+	var res LoginResponse
+	json.Unmarshal(response.Body.Bytes(), &res)
+	return res.Token
+}
+
+func TestLoginAndRoles(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"ann:pw":  {Username: "ann", Roles: []string{roleAdmin}},
+		"rita:pw": {Username: "rita", Roles: []string{roleReader}},
+	})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(`{"username":"ann","password":"wrong"}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong password, got %d", response.Code)
+	}
+
+	admin := loginAs(t, router, "ann", "pw")
+	reader := loginAs(t, router, "rita", "pw")
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/api/v1/admin/scheduled-jobs", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/admin/scheduled-jobs", reader, http.StatusForbidden},
+		{"GET", "/api/v1/admin/scheduled-jobs", admin, http.StatusOK},
+		{"GET", "/api/v1/admin/scheduled-jobs", "bogus.token", http.StatusUnauthorized},
+		{"GET", "/api/v1/books", "", http.StatusOK},
+		{"POST", "/api/v1/books", reader, http.StatusForbidden},
+		{"DELETE", "/api/v1/books/1", "", http.StatusUnauthorized},
+		{"DELETE", "/api/v1/books/1", admin, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.want, response.Code)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+reader)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var me Principal
+	json.Unmarshal(response.Body.Bytes(), &me)
+	if response.Code != http.StatusOK || me.Username != "rita" {
+		t.Errorf("Expected rita from /auth/me, got %d %+v", response.Code, me)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	withAuth(t, nil)
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/scheduled-jobs", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected admin routes open without an auth backend, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(`{}`))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 for login without an auth backend, got %d", response.Code)
+	}
+}
+
+func TestMapRoles(t *testing.T) {
+	mappings, err := parseRoleMappings("admin=cn=Library Admins,ou=Groups,dc=uni,dc=edu; librarian=Library Staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		groups []string
+		want   []string
+	}{
+		{[]string{"CN=Library Admins, OU=Groups, DC=uni, DC=edu"}, []string{roleAdmin}},
+		{[]string{"CN=Library Staff,OU=Groups,DC=uni,DC=edu", "CN=Students,DC=uni,DC=edu"}, []string{roleLibrarian}},
+		{[]string{"CN=Students,DC=uni,DC=edu"}, []string{roleReader}},
+	}
+	for _, tt := range tests {
+		if got := mapRoles(tt.groups, mappings, roleReader); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mapRoles(%q) = %q, want %q", tt.groups, got, tt.want)
+		}
+	}
+	if got := mapRoles(nil, mappings, "none"); len(got) != 0 {
+		t.Errorf("Expected no roles with default role none, got %q", got)
+	}
+	if _, err := parseRoleMappings("owner=cn=x"); err == nil {
+		t.Error("Expected an error for an unknown role")
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Author is a person books are by. Books keep the author's name in their
+// author field, which filters, sorting and search use, and link to the
+// author by author_id; writing a book finds or creates its author by
+// name, and renaming an author renames it on every book.
+type Author struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"uniqueIndex;not null"`
+	Bio       string    `json:"bio,omitempty"`
+	BookCount int64     `json:"book_count" gorm:"->;-:migration"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuthorRequest is the body of POST and PUT /authors
+type AuthorRequest struct {
+	Name string `json:"name"`
+	Bio  string `json:"bio"`
+}
+
+// Authors with the number of books by them, by name
+func authorsWithCounts(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Model(&Author{}).
+		Select("authors.*, COUNT(books.id) AS book_count").
+		Scopes(joinBooks("books.author_id = authors.id AND books.deleted_at IS NULL")).
+		Group("authors.id")
+}
+
+// List the catalog's authors alphabetically, for filter menus that
+// shouldn't have to download every book. Only authors with books in the
+// catalog are listed unless ?include_empty=true.
+func getAuthors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	authors, err := listAuthors(r.Context(), r.URL.Query().Get("include_empty") == "true")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list authors")
+		return
+	}
+	writeList(w, r, authors)
+}
+
+// The catalog's authors with their book counts, by name. Authors without
+// a book outside the trash are left out unless includeEmpty.
+func listAuthors(ctx context.Context, includeEmpty bool) ([]Author, error) {
+	query := authorsWithCounts(ctx)
+	if !includeEmpty {
+		query = query.Having("COUNT(books.id) > 0")
+	}
+	var authors []Author
+	err := query.Order("authors.name").Scan(&authors).Error
+	return authors, err
+}
+
+// Link a book to the author named in its author field, creating the
+// author the first time the name is used. An author the book leaves is
+// removed if it has no other books. Runs in the book's write transaction.
+func resolveBookAuthor(tx *gorm.DB, b *Book) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	var before Book
+	if b.ID != 0 {
+		if err := tx.Unscoped().Select("author_id").Limit(1).Find(&before, b.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	b.AuthorID = nil
+	if b.Author != "" {
+		var author Author
+		if err := tx.Where(Author{Name: b.Author}).FirstOrCreate(&author).Error; err != nil {
+			return err
+		}
+		b.AuthorID = &author.ID
+	}
+	if before.AuthorID != nil && (b.AuthorID == nil || *b.AuthorID != *before.AuthorID) {
+		return pruneAuthor(tx, *before.AuthorID, b.ID)
+	}
+	return nil
+}
+
+// Remove an author once no book but bookID links to it, live or in the
+// trash, so reassigning or purging books leaves no empty authors behind
+func pruneAuthor(tx *gorm.DB, authorID, bookID uint) error {
+	var books int64
+	if err := tx.Unscoped().Model(&Book{}).Where("author_id = ? AND id <> ?", authorID, bookID).Count(&books).Error; err != nil {
+		return err
+	}
+	if books > 0 {
+		return nil
+	}
+	return tx.Delete(&Author{}, authorID).Error
+}
+
+// Create authors for the names books already have and link the books to
+// them, for databases from before authors had a table. Does nothing once
+// every book is linked.
+func backfillAuthors() {
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO authors (name, created_at, updated_at)
+			SELECT DISTINCT author, ?, ? FROM books
+			WHERE author <> '' AND author_id IS NULL AND author NOT IN (SELECT name FROM authors)`, now, now).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE books SET author_id = (SELECT id FROM authors WHERE authors.name = books.author)
+			WHERE author <> '' AND author_id IS NULL`).Error
+	})
+	if err != nil {
+		log.Fatal("Failed to backfill authors:", err)
+	}
+}
+
+func validateAuthor(req *AuthorRequest) []FieldError {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return []FieldError{{Field: "name", Message: "is required"}}
+	}
+	return nil
+}
+
+// Whether another author already has the name
+func authorNameTaken(name string, id uint) bool {
+	var count int64
+	db.Model(&Author{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+	return count > 0
+}
+
+// Create an author
+func createAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req AuthorRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateAuthor(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if authorNameTaken(req.Name, 0) {
+		writeError(w, r, http.StatusConflict, "author_exists", "An author with this name already exists")
+		return
+	}
+	author := Author{Name: req.Name, Bio: req.Bio}
+	if err := db.Create(&author).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create author")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(author.ID), 10))
+	writeJSON(w, http.StatusCreated, author)
+}
+
+// Load the author named in the URL, with its book count
+func loadAuthor(w http.ResponseWriter, r *http.Request) (*Author, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_author_id", "Invalid author ID")
+		return nil, false
+	}
+	var author Author
+	if err := authorsWithCounts(r.Context()).Where("authors.id = ?", id).Take(&author).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "author_not_found", "Author not found")
+		return nil, false
+	}
+	return &author, true
+}
+
+// Get an author by ID
+func getAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if author, ok := loadAuthor(w, r); ok {
+		writeJSON(w, http.StatusOK, author)
+	}
+}
+
+// List an author's books by title
+func getAuthorBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	author, ok := loadAuthor(w, r)
+	if !ok {
+		return
+	}
+	var books []Book
+	db.WithContext(r.Context()).Preload("Tags").Where("author_id = ?", author.ID).Order("title, id").Find(&books)
+	writeList(w, r, books)
+}
+
+// Replace an author's name and bio. A new name is written to every book
+// by the author, through the usual book hooks, in one transaction.
+func updateAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	author, ok := loadAuthor(w, r)
+	if !ok {
+		return
+	}
+	var req AuthorRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateAuthor(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if authorNameTaken(req.Name, author.ID) {
+		writeError(w, r, http.StatusConflict, "author_exists", "An author with this name already exists")
+		return
+	}
+
+	renamed := req.Name != author.Name
+	author.Name, author.Bio = req.Name, req.Bio
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("Name", "Bio", "UpdatedAt").Updates(author).Error; err != nil {
+			return err
+		}
+		if !renamed {
+			return nil
+		}
+		var books []Book
+		if err := tx.Unscoped().Where("author_id = ?", author.ID).Find(&books).Error; err != nil {
+			return err
+		}
+		for i := range books {
+			books[i].Author = author.Name
+			if err := tx.Unscoped().Omit("Tags").Save(&books[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update author")
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, author)
+}
+
+// Returned when an author still has books
+var errAuthorHasBooks = errors.New("author has books")
+
+// Delete an author with no books, including books in the trash
+func deleteAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	author, ok := loadAuthor(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var books int64
+		if err := tx.Unscoped().Model(&Book{}).Where("author_id = ?", author.ID).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return errAuthorHasBooks
+		}
+		return tx.Delete(&Author{}, author.ID).Error
+	})
+	if errors.Is(err, errAuthorHasBooks) {
+		writeError(w, r, http.StatusConflict, "author_has_books", "Reassign or delete the author's books first")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete author")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
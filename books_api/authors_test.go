@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Authors' book counts by name
+func authorCounts(authors []Author) map[string]int64 {
+	counts := map[string]int64{}
+	for _, a := range authors {
+		counts[a.Name] = a.BookCount
+	}
+	return counts
+}
+
+func TestGetAuthors(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233"})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/authors", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	var authors []Author
+	json.Unmarshal(response.Body.Bytes(), &authors)
+	want := map[string]int64{"Frank Herbert": 2, "Jane Austen": 1}
+	if response.Code != http.StatusOK || len(authors) != 2 || authors[0].Name != "Frank Herbert" || !reflect.DeepEqual(authorCounts(authors), want) {
+		t.Errorf("Expected %+v, got %d: %s", want, response.Code, response.Body.String())
+	}
+}
+
+func TestBooksLinkToAuthors(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	messiah := Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233"}
+	db.Create(&dune)
+	db.Create(&messiah)
+	if dune.AuthorID == nil || messiah.AuthorID == nil || *dune.AuthorID != *messiah.AuthorID {
+		t.Fatalf("Expected both books linked to one author, got %v and %v", dune.AuthorID, messiah.AuthorID)
+	}
+
+	// Changing a book's author moves it to that author
+	messiah.Author = "Brian Herbert"
+	db.Save(&messiah)
+	var stored Book
+	db.First(&stored, messiah.ID)
+	if stored.AuthorID == nil || *stored.AuthorID == *dune.AuthorID {
+		t.Errorf("Expected the book moved to a new author, got %v", stored.AuthorID)
+	}
+	var count int64
+	db.Model(&Author{}).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 authors, got %d", count)
+	}
+
+	// An author is removed when their last book leaves
+	dune.Author = "Brian Herbert"
+	db.Save(&dune)
+	var names []string
+	db.Model(&Author{}).Order("name").Pluck("name", &names)
+	if !reflect.DeepEqual(names, []string{"Brian Herbert"}) {
+		t.Errorf("Expected only Brian Herbert left, got %v", names)
+	}
+}
+
+func TestEmptyAuthorsHidden(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Author{Name: "Ursula K. Le Guin"})
+	book := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	db.Create(&book)
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Delete(&book)
+
+	names := func(path string) []string {
+		var authors []Author
+		json.Unmarshal(webhookRequest(t, router, "GET", path, "").Body.Bytes(), &authors)
+		var names []string
+		for _, a := range authors {
+			names = append(names, a.Name)
+		}
+		return names
+	}
+	if got := names("/api/v1/authors"); !reflect.DeepEqual(got, []string{"Frank Herbert"}) {
+		t.Errorf("Expected only authors with books, got %v", got)
+	}
+	if got := names("/api/v1/authors?include_empty=true"); len(got) != 3 {
+		t.Errorf("Expected every author, got %v", got)
+	}
+
+	// Purging the last book removes its author
+	db.Model(&book).Unscoped().Update("deleted_at", time.Now().Add(-2*cfg.TrashRetention))
+	if err := purgeTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := names("/api/v1/authors?include_empty=true"); !reflect.DeepEqual(got, []string{"Frank Herbert", "Ursula K. Le Guin"}) {
+		t.Errorf("Expected Jane Austen removed with the last book, got %v", got)
+	}
+}
+
+func TestBackfillAuthors(t *testing.T) {
+	clearDB()
+	// Books written before authors had a table
+	db.Session(&gorm.Session{SkipHooks: true}).Create(&[]Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+	})
+
+	backfillAuthors()
+	backfillAuthors()
+
+	authors, _ := listAuthors(context.Background(), false)
+	want := map[string]int64{"Frank Herbert": 2, "Jane Austen": 1}
+	if !reflect.DeepEqual(authorCounts(authors), want) {
+		t.Errorf("Expected %+v, got %+v", want, authors)
+	}
+	var unlinked int64
+	db.Model(&Book{}).Where("author_id IS NULL").Count(&unlinked)
+	if unlinked != 0 {
+		t.Errorf("Expected every book linked, %d are not", unlinked)
+	}
+}
+
+func TestAuthorCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/authors", `{"name":"Ursula K. Le Guin","bio":"Wrote Earthsea."}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Author
+	json.Unmarshal(response.Body.Bytes(), &created)
+	path := fmt.Sprintf("/api/v1/authors/%d", created.ID)
+	if loc := response.Header().Get("Location"); loc != path {
+		t.Errorf("Expected Location %s, got %s", path, loc)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/authors", `{"name":"Ursula K. Le Guin"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate name, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/authors", `{"name":" "}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a name, got %d", response.Code)
+	}
+
+	// A book by the same name joins the existing author
+	book := Book{Title: "A Wizard of Earthsea", Author: "Ursula K. Le Guin", ISBN: "9780547773742"}
+	db.Create(&book)
+	if book.AuthorID == nil || *book.AuthorID != created.ID {
+		t.Errorf("Expected the book linked to author %d, got %v", created.ID, book.AuthorID)
+	}
+	response = webhookRequest(t, router, "GET", path, "")
+	var got Author
+	json.Unmarshal(response.Body.Bytes(), &got)
+	if response.Code != http.StatusOK || got.BookCount != 1 || got.Bio != "Wrote Earthsea." {
+		t.Errorf("Unexpected author %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", path+"/books", "")
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 1 || books[0].ID != book.ID {
+		t.Errorf("Expected the author's book, got %s", response.Body.String())
+	}
+
+	// Renaming renames the author's books, and records the change
+	response = webhookRequest(t, router, "PUT", path, `{"name":"Ursula Le Guin"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var renamed Book
+	db.First(&renamed, book.ID)
+	if renamed.Author != "Ursula Le Guin" || *renamed.AuthorID != created.ID {
+		t.Errorf("Expected the book renamed, got %+v", renamed)
+	}
+	var changes int64
+	db.Model(&BookChange{}).Where("book_id = ? AND type = ?", book.ID, eventBookUpdated).Count(&changes)
+	if changes != 1 {
+		t.Errorf("Expected the rename recorded as a book update, got %d", changes)
+	}
+
+	// Authors with books, even deleted ones, can't be removed
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", response.Code)
+	}
+	db.Delete(&book)
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 with a book in the trash, got %d", response.Code)
+	}
+	db.Unscoped().Delete(&book)
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/authors/abc", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestAuthorWritesNeedLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"reader:pw": {Username: "reader", Roles: []string{roleReader}}})
+	router := setupRouter()
+
+	if response := webhookRequest(t, router, "GET", "/api/v1/authors", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected reads to stay public, got %d", response.Code)
+	}
+	req, _ := http.NewRequest("POST", "/api/v1/authors", strings.NewReader(`{"name":"Jane Austen"}`))
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, router, "reader", "pw"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a reader, got %d", response.Code)
+	}
+}
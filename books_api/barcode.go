@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// Largest photo accepted for barcode decoding
+const maxBarcodeImageBytes = 10 << 20
+
+// Photos are scaled down to this width before decoding; phone cameras
+// produce far more pixels than a barcode needs
+const barcodeDecodeWidth = 2000
+
+var (
+	errNoBarcode    = errors.New("no barcode found in image")
+	errNotISBNCode  = errors.New("barcode is not an ISBN")
+	errBadImageData = errors.New("unreadable image")
+	errImageTooBig  = errors.New("image has too many pixels")
+	errBadBarcode   = errors.New("not an EAN-13, UPC-A or ISBN")
+)
+
+// Decode the EAN-13 barcode in a photo and return it as an ISBN-13
+func decodeISBNBarcode(data []byte) (string, error) {
+	// A small file can claim huge dimensions, so check them before
+	// decoding allocates the pixels
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", errBadImageData
+	}
+	if conf.Width*conf.Height > maxCoverPixels {
+		return "", errImageTooBig
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", errBadImageData
+	}
+	if img.Bounds().Dx() > barcodeDecodeWidth {
+		img = resizeToWidth(img, barcodeDecodeWidth)
+	}
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", errBadImageData
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	result, err := oned.NewEAN13Reader().Decode(bmp, hints)
+	if err != nil {
+		return "", errNoBarcode
+	}
+
+	// Bookland EAN prefixes
+	code := result.GetText()
+	if !strings.HasPrefix(code, "978") && !strings.HasPrefix(code, "979") {
+		return code, errNotISBNCode
+	}
+	return code, nil
+}
+
+// Read the uploaded photo from a multipart "image" field or the raw body
+func readBarcodeImage(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBarcodeImageBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// BarcodeLookup is the book a scanned ISBN matched, from the catalog or
+// the metadata provider named by Source
+type BarcodeLookup struct {
+	Book   Book   `json:"book"`
+	ISBN   string `json:"isbn"`
+	Source string `json:"source"`
+}
+
+// Decode an ISBN barcode from a photo and return the matching book, or one
+// prefilled from the metadata provider when it isn't in the catalog
+func lookupBarcodeImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	data, err := readBarcodeImage(w, r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Expected an image up to 10 MB in the body or an \"image\" form field")
+		return
+	}
+
+	isbn, err := decodeISBNBarcode(data)
+	switch {
+	case errors.Is(err, errBadImageData):
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Image must be a JPEG, PNG, GIF or WebP")
+		return
+	case errors.Is(err, errImageTooBig):
+		writeError(w, r, http.StatusRequestEntityTooLarge, "too_large", "Image is too large to decode")
+		return
+	case errors.Is(err, errNoBarcode):
+		writeError(w, r, http.StatusUnprocessableEntity, "barcode_not_found", "No barcode found in image")
+		return
+	case errors.Is(err, errNotISBNCode):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Barcode is not an ISBN", "barcode": isbn})
+		return
+	}
+
+	var book Book
+	if err := db.WithContext(r.Context()).Preload("Tags").Where("isbn = ?", isbn).First(&book).Error; err == nil {
+		writeJSON(w, http.StatusOK, BarcodeLookup{ISBN: isbn, Source: "catalog", Book: book})
+		return
+	}
+
+	if metadataProvider == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"isbn": isbn, "error": "Book not found"})
+		return
+	}
+	// Only a preview, so unlike enrichBook this links no publisher, which
+	// could add one to the catalog
+	meta, err := metadataProvider.LookupISBN(r.Context(), isbn)
+	if err != nil {
+		if errors.Is(err, ErrMetadataNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"isbn": isbn, "error": "Book not found"})
+			return
+		}
+		writeMetadataError(w, r, err)
+		return
+	}
+	book = Book{ISBN: isbn}
+	applyMetadata(&book, meta, false)
+	writeJSON(w, http.StatusOK, BarcodeLookup{ISBN: isbn, Source: metadataProvider.Name(), Book: book})
+}
+
+// ScanRequest is the body of POST /books/scan: what a barcode scanner read
+type ScanRequest struct {
+	Barcode string `json:"barcode"`
+}
+
+// The ISBN-13 a scanned barcode value encodes. A book's back cover
+// carries a Bookland EAN-13 (978 or 979), which scanners may read with
+// its 2- or 5-digit price add-on appended. A UPC-A, as on mass-market
+// paperbacks, identifies the publisher's product rather than the book and
+// has no ISBN. A typed ISBN-10 is accepted too.
+func barcodeISBN(code string) (string, error) {
+	code = strings.ToUpper(cleanISBN(code))
+	if len(code) == 15 || len(code) == 18 {
+		code = code[:13]
+	}
+	switch {
+	case len(code) == 12 && validISBN("0"+code):
+		// The check digit of a UPC-A is that of the EAN-13 with a leading 0
+		return code, errNotISBNCode
+	case !validISBN(code):
+		return code, errBadBarcode
+	case len(code) == 13 && !strings.HasPrefix(code, "978") && !strings.HasPrefix(code, "979"):
+		return code, errNotISBNCode
+	}
+	return toISBN13(code), nil
+}
+
+// Create a book from a scanned barcode in one call, for shelving with a
+// handheld scanner: the barcode is resolved to an ISBN and the book filled
+// in from the metadata provider
+func scanBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req ScanRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if strings.TrimSpace(req.Barcode) == "" {
+		writeValidationErrors(w, r, []FieldError{{Field: "barcode", Message: "is required"}})
+		return
+	}
+	isbn, err := barcodeISBN(req.Barcode)
+	switch {
+	case errors.Is(err, errBadBarcode):
+		writeValidationErrors(w, r, []FieldError{{Field: "barcode", Message: "is not a valid EAN-13, UPC-A or ISBN"}})
+		return
+	case errors.Is(err, errNotISBNCode):
+		writeError(w, r, http.StatusUnprocessableEntity, "barcode_not_isbn", "Barcode is not an ISBN")
+		return
+	}
+	if metadataProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Metadata enrichment is not configured")
+		return
+	}
+	// Checked before the lookup so a second scan of a shelved book costs
+	// no provider call; the create's own check still catches the trash
+	if _, err := findBookByISBN(r.Context(), isbn); err == nil {
+		writeError(w, r, http.StatusConflict, "isbn_exists", "A book with this ISBN already exists")
+		return
+	}
+
+	book := Book{ISBN: isbn}
+	if _, err := enrichBook(r.Context(), &book, false); err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if err := db.WithContext(r.Context()).Create(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create book")
+		}
+		return
+	}
+	notifyBookAdded(&book)
+
+	setBookETag(w, book.ID)
+	w.Header().Set("Location", fmt.Sprintf("%s/books/%d", versionFrom(r).Prefix, book.ID))
+	writeJSON(w, http.StatusCreated, book)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// Render an EAN-13 barcode as a PNG with a white margin, like a photo crop
+func testBarcodePNG(t *testing.T, code string) []byte {
+	matrix, err := oned.NewEAN13Writer().Encode(code, gozxing.BarcodeFormat_EAN_13, 380, 160, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewGray(image.Rect(0, 0, 460, 220))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if matrix.Get(x, y) {
+				img.SetGray(x+40, y+30, color.Gray{})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func postBarcode(router http.Handler, body []byte, contentType string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/api/v1/lookup/barcode-image", bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestBarcodeMatchesCatalog(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+
+	// Send the photo as a form upload, the way a browser file input does
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("image", "scan.png")
+	part.Write(testBarcodePNG(t, "9780441013593"))
+	mw.Close()
+
+	response := postBarcode(router, body.Bytes(), mw.FormDataContentType())
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var result struct {
+		ISBN   string `json:"isbn"`
+		Source string `json:"source"`
+		Book   Book   `json:"book"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.ISBN != "9780441013593" || result.Source != "catalog" || result.Book.ID != 1 {
+		t.Errorf("Unexpected lookup result %+v", result)
+	}
+}
+
+func TestBarcodeEnrichesUnknownBook(t *testing.T) {
+	clearDB()
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	}})
+	router := setupRouter()
+
+	response := postBarcode(router, testBarcodePNG(t, "9780132350884"), "image/png")
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var result struct {
+		Source string `json:"source"`
+		Book   Book   `json:"book"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Source != "fake" || result.Book.ID != 0 || result.Book.Title != "Clean Code" || result.Book.ISBN != "9780132350884" {
+		t.Errorf("Expected an unsaved, prefilled book, got %+v", result)
+	}
+
+	response = postBarcode(router, testBarcodePNG(t, "9781234567897"), "image/png")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown ISBN, got %d", response.Code)
+	}
+}
+
+func TestBarcodeRejectsBadImages(t *testing.T) {
+	router := setupRouter()
+
+	if response := postBarcode(router, testPNG(50, 50), "image/png"); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 without a barcode, got %d", response.Code)
+	}
+	// A grocery EAN-13, not a Bookland one
+	if response := postBarcode(router, testBarcodePNG(t, "4006381333931"), "image/png"); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a non-ISBN barcode, got %d", response.Code)
+	}
+	if response := postBarcode(router, []byte("not an image"), "text/plain"); response.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415, got %d", response.Code)
+	}
+	if response := postBarcode(router, hugePNGHeader(100_000, 100_000), "image/png"); response.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for an image claiming 10 gigapixels, got %d", response.Code)
+	}
+}
+
+// The signature and header chunk of a PNG claiming the dimensions, with
+// no pixel data behind them
+func hugePNGHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 0 // 8-bit grayscale
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestBarcodeISBN(t *testing.T) {
+	for code, want := range map[string]string{
+		"9780132350884":      "9780132350884",
+		"978013235088451999": "9780132350884",
+		"979100000001590":    "9791000000015",
+		"0-13-235088-2":      "9780132350884",
+	} {
+		if got, err := barcodeISBN(code); err != nil || got != want {
+			t.Errorf("barcodeISBN(%s) = %s, %v, want %s", code, got, err, want)
+		}
+	}
+	for code, want := range map[string]error{
+		"036000291452":  errNotISBNCode,
+		"4006381333931": errNotISBNCode,
+		"9780132350885": errBadBarcode,
+		"036000291453":  errBadBarcode,
+		"hello":         errBadBarcode,
+	} {
+		if _, err := barcodeISBN(code); err != want {
+			t.Errorf("barcodeISBN(%s): expected %v, got %v", code, want, err)
+		}
+	}
+}
+
+func TestScanBook(t *testing.T) {
+	clearDB()
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	}})
+	router := setupRouter()
+	scan := func(barcode string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/v1/books/scan", bytes.NewBufferString(`{"barcode":"`+barcode+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	response := scan("978013235088451999")
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusCreated || book.ID == 0 || book.Title != "Clean Code" || book.ISBN != "9780132350884" {
+		t.Fatalf("Expected the book created, got %d: %s", response.Code, response.Body.String())
+	}
+	if response.Header().Get("Location") != "/api/v1/books/1" {
+		t.Errorf("Expected a Location header, got %q", response.Header().Get("Location"))
+	}
+
+	for barcode, status := range map[string]int{
+		"9780132350884": http.StatusConflict,
+		"9781234567897": http.StatusNotFound,
+		"036000291452":  http.StatusUnprocessableEntity,
+		"12345":         http.StatusBadRequest,
+		"":              http.StatusBadRequest,
+	} {
+		if response := scan(barcode); response.Code != status {
+			t.Errorf("Barcode %q: expected status %d, got %d: %s", barcode, status, response.Code, response.Body.String())
+		}
+	}
+
+	useProvider(t, nil)
+	if response := scan("9780441013593"); response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 without a provider, got %d", response.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlobInfo describes a stored object
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// BlobStore persists opaque files such as cover images and exports.
+// Keys are slash-separated paths like "covers/12/original".
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+
+	// Presigned URLs let the frontend transfer files directly
+	PresignPut(key, contentType string, ttl time.Duration) (string, error)
+	PresignGet(key string, ttl time.Duration) (string, error)
+}
+
+// Returned when a key does not exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Configured blob store
+var blobStore BlobStore
+
+// Initialize the blob store from configuration
+func initBlobStore() {
+	switch cfg.BlobStore {
+	case "s3":
+		blobStore = newS3BlobStore(cfg.S3)
+	case "", "local":
+		key := []byte(cfg.BlobSigningKey)
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			rand.Read(key)
+			log.Println("BLOB_SIGNING_KEY not set, presigned URLs will not survive a restart")
+		}
+		blobStore = newLocalBlobStore(cfg.BlobDir, cfg.BlobPublicURL, key)
+	default:
+		log.Fatalf("Unknown BLOB_STORE %q", cfg.BlobStore)
+	}
+}
+
+// Reject keys that could escape the store root
+func validBlobKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// localBlobStore keeps blobs on disk. Presigned URLs point back at the API,
+// which verifies an HMAC signature before serving or accepting the file.
+type localBlobStore struct {
+	root    string
+	baseURL string
+	key     []byte
+}
+
+func newLocalBlobStore(root, baseURL string, key []byte) *localBlobStore {
+	return &localBlobStore{root: root, baseURL: strings.TrimRight(baseURL, "/"), key: key}
+}
+
+// Sidecar written next to every blob
+type localBlobMeta struct {
+	ContentType string `json:"content_type"`
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so readers never see partial data
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	meta, _ := json.Marshal(localBlobMeta{ContentType: contentType})
+	if err := os.WriteFile(p+".meta", meta, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localBlobStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BlobInfo{Key: key, Size: fi.Size(), ContentType: "application/octet-stream"}
+	var meta localBlobMeta
+	if data, err := os.ReadFile(p + ".meta"); err == nil && json.Unmarshal(data, &meta) == nil && meta.ContentType != "" {
+		info.ContentType = meta.ContentType
+	}
+	return info, nil
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _ := s.path(key)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	os.Remove(p + ".meta")
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) sign(method, key, contentType string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", method, key, contentType, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *localBlobStore) presign(method, key, contentType string, ttl time.Duration) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{
+		"expires": {strconv.FormatInt(expires, 10)},
+		"sig":     {s.sign(method, key, contentType, expires)},
+	}
+	return s.baseURL + "/blobs/" + key + "?" + q.Encode(), nil
+}
+
+func (s *localBlobStore) PresignPut(key, contentType string, ttl time.Duration) (string, error) {
+	return s.presign("PUT", key, contentType, ttl)
+}
+
+func (s *localBlobStore) PresignGet(key string, ttl time.Duration) (string, error) {
+	return s.presign("GET", key, "", ttl)
+}
+
+// Serve presigned GET and PUT requests for the local store
+func (s *localBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/blobs/")
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !validBlobKey(key) {
+		writeError(w, r, http.StatusBadRequest, "invalid_blob_url", "Invalid blob URL")
+		return
+	}
+	if time.Now().Unix() > expires {
+		writeError(w, r, http.StatusForbidden, "invalid_signature", "URL has expired")
+		return
+	}
+
+	contentType := ""
+	if r.Method == "PUT" {
+		contentType = r.Header.Get("Content-Type")
+	}
+	want := s.sign(r.Method, key, contentType, expires)
+	if !hmac.Equal([]byte(want), []byte(r.URL.Query().Get("sig"))) {
+		writeError(w, r, http.StatusForbidden, "invalid_signature", "Invalid signature")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		body, info, err := s.Get(r.Context(), key)
+		if errors.Is(err, ErrBlobNotFound) {
+			writeError(w, r, http.StatusNotFound, "not_found", "Not found")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read blob")
+			return
+		}
+		defer body.Close()
+		w.Header().Set("Content-Type", info.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		io.Copy(w, body)
+	case "PUT":
+		body := http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		if err := s.Put(r.Context(), key, body, r.ContentLength, contentType); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to store blob")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config selects a bucket on AWS S3 or an S3-compatible server like MinIO
+type S3Config struct {
+	Endpoint        string // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool // bucket in the path rather than the host, needed for MinIO
+}
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+// s3BlobStore implements BlobStore with AWS Signature Version 4 requests
+type s3BlobStore struct {
+	cfg    S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func newS3BlobStore(c S3Config) *s3BlobStore {
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	return &s3BlobStore{cfg: c, client: &http.Client{Timeout: 30 * time.Second}, now: time.Now}
+}
+
+// Object URL for a key, honoring path- or virtual-hosted-style addressing
+func (s *s3BlobStore) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u, nil
+}
+
+func (s *s3BlobStore) signingKey(date string) []byte {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	k := mac([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	k = mac(k, s.cfg.Region)
+	k = mac(k, "s3")
+	return mac(k, "aws4_request")
+}
+
+func (s *s3BlobStore) scope(date string) string {
+	return date + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// Compute the SigV4 signature for a request. headers must be lower-cased
+// and include host.
+func (s *s3BlobStore) signature(method string, u *url.URL, query url.Values, headers map[string]string, payloadHash string, t time.Time) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		s3EncodePath(u.Path),
+		s3CanonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := t.Format("20060102")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3TimeFormat),
+		s.scope(date),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, s.signingKey(date))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil)), signedHeaders
+}
+
+// Send a signed request with Authorization header credentials
+func (s *s3BlobStore) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	t := s.now().UTC()
+
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": s3UnsignedPayload,
+		"x-amz-date":           t.Format(s3TimeFormat),
+	}
+	if contentType != "" {
+		headers["content-type"] = contentType
+	}
+	sig, signed := s.signature(method, u, nil, headers, s3UnsignedPayload, t)
+
+	req, err := http.NewRequestWithContext(ctx, method, s3URLString(u, ""), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, v := range headers {
+		if name != "host" {
+			req.Header.Set(name, v)
+		}
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.cfg.AccessKeyID, s.scope(t.Format("20060102")), signed, sig))
+
+	return s.client.Do(req)
+}
+
+func s3Error(op, key string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s: status %d: %s", op, key, resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	// S3 needs a Content-Length, so buffer bodies of unknown size
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	resp, err := s.do(ctx, "PUT", key, r, size, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", key, resp)
+	}
+	return nil
+}
+
+func blobInfoFromResponse(key string, resp *http.Response) *BlobInfo {
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return &BlobInfo{Key: key, Size: size, ContentType: resp.Header.Get("Content-Type")}
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	resp, err := s.do(ctx, "GET", key, nil, 0, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, blobInfoFromResponse(key, resp), nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil, ErrBlobNotFound
+	}
+	defer resp.Body.Close()
+	return nil, nil, s3Error("get", key, resp)
+}
+
+func (s *s3BlobStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	resp, err := s.do(ctx, "HEAD", key, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return blobInfoFromResponse(key, resp), nil
+	case http.StatusNotFound:
+		return nil, ErrBlobNotFound
+	}
+	return nil, s3Error("head", key, resp)
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error("delete", key, resp)
+	}
+	return nil
+}
+
+// Build a query-string authenticated URL
+func (s *s3BlobStore) presign(method, key, contentType string, ttl time.Duration) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	t := s.now().UTC()
+
+	headers := map[string]string{"host": u.Host}
+	if contentType != "" {
+		headers["content-type"] = contentType
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	q := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.cfg.AccessKeyID + "/" + s.scope(t.Format("20060102"))},
+		"X-Amz-Date":          {t.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {strings.Join(names, ";")},
+	}
+	sig, _ := s.signature(method, u, q, headers, s3UnsignedPayload, t)
+	q.Set("X-Amz-Signature", sig)
+
+	return s3URLString(u, s3CanonicalQuery(q)), nil
+}
+
+func (s *s3BlobStore) PresignPut(key, contentType string, ttl time.Duration) (string, error) {
+	return s.presign("PUT", key, contentType, ttl)
+}
+
+func (s *s3BlobStore) PresignGet(key string, ttl time.Duration) (string, error) {
+	return s.presign("GET", key, "", ttl)
+}
+
+// Render a URL using the same path encoding that was signed
+func s3URLString(u *url.URL, query string) string {
+	out := u.Scheme + "://" + u.Host + s3EncodePath(u.Path)
+	if query != "" {
+		out += "?" + query
+	}
+	return out
+}
+
+// URI-encode per SigV4: everything except unreserved characters
+func s3Encode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EncodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3Encode(p, true)
+}
+
+// Sorted, encoded query string
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Encode(k, false)+"="+s3Encode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
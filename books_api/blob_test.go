@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Install a local blob store rooted in a temp dir for one test
+func useLocalBlobStore(t *testing.T) *localBlobStore {
+	store := newLocalBlobStore(t.TempDir(), "", []byte("test-signing-key"))
+	saved := blobStore
+	blobStore = store
+	t.Cleanup(func() {
+		// Let queued cover jobs finish before the store goes away
+		jobs.Wait()
+		blobStore = saved
+	})
+	return store
+}
+
+func TestLocalBlobStoreRoundTrip(t *testing.T) {
+	store := useLocalBlobStore(t)
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "exports/books.csv", strings.NewReader("id,title\n"), -1, "text/csv"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	body, info, err := store.Get(ctx, "exports/books.csv")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "id,title\n" || info.ContentType != "text/csv" || info.Size != 9 {
+		t.Errorf("Unexpected blob %q %+v", data, info)
+	}
+
+	if err := store.Delete(ctx, "exports/books.csv"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Stat(ctx, "exports/books.csv"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound after delete, got %v", err)
+	}
+}
+
+func TestValidBlobKey(t *testing.T) {
+	for _, key := range []string{"", "/abs", "../etc/passwd", "covers/../../x", "a//b", `a\b`} {
+		if validBlobKey(key) {
+			t.Errorf("Expected %q to be rejected", key)
+		}
+	}
+	if !validBlobKey("covers/1/original") {
+		t.Error("Expected covers/1/original to be accepted")
+	}
+}
+
+func TestLocalBlobPresignedTransfer(t *testing.T) {
+	store := useLocalBlobStore(t)
+	router := setupRouter()
+
+	putURL, _ := store.PresignPut("covers/7/original", "image/png", time.Minute)
+	req, _ := http.NewRequest("PUT", putURL, strings.NewReader("png-bytes"))
+	req.Header.Set("Content-Type", "image/png")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected presigned PUT to succeed, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// The signature covers the content type
+	req, _ = http.NewRequest("PUT", putURL, strings.NewReader("evil"))
+	req.Header.Set("Content-Type", "text/html")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a mismatched content type, got %d", response.Code)
+	}
+
+	getURL, _ := store.PresignGet("covers/7/original", time.Minute)
+	req, _ = http.NewRequest("GET", getURL, nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Body.String() != "png-bytes" || response.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Unexpected presigned GET response %d %q", response.Code, response.Body.String())
+	}
+}
+
+func TestLocalBlobPresignedURLExpires(t *testing.T) {
+	store := useLocalBlobStore(t)
+	router := setupRouter()
+
+	getURL, _ := store.PresignGet("covers/7/original", -time.Minute)
+	req, _ := http.NewRequest("GET", getURL, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an expired URL, got %d", response.Code)
+	}
+
+	u, _ := url.Parse(getURL)
+	q := u.Query()
+	q.Set("expires", "9999999999")
+	u.RawQuery = q.Encode()
+	req, _ = http.NewRequest("GET", u.String(), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a tampered expiry, got %d", response.Code)
+	}
+}
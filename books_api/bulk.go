@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Largest number of books one bulk request may carry, and how many rows
+// each INSERT writes
+const (
+	maxBulkBooks    = 1000
+	bulkInsertBatch = 100
+)
+
+// Bulk item outcomes. Skipped items were valid but not written, because
+// another item of an all-or-nothing request failed.
+const (
+	bulkCreated = "created"
+	bulkUpdated = "updated"
+	bulkSkipped = "skipped"
+	bulkError   = "error"
+)
+
+// BulkResult is the outcome for one item of a bulk request, by its
+// position in the request
+type BulkResult struct {
+	Index  int          `json:"index"`
+	Status string       `json:"status"`
+	Book   *Book        `json:"book,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+	// Set when an item failed for a reason with an error code of its own,
+	// such as isbn_in_trash
+	Code string `json:"code,omitempty"`
+}
+
+// BulkReport summarizes a bulk request
+type BulkReport struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+func (rep *BulkReport) add(res BulkResult) {
+	switch res.Status {
+	case bulkCreated, bulkUpdated:
+		rep.Succeeded++
+	case bulkError:
+		rep.Failed++
+	}
+	rep.Results = append(rep.Results, res)
+}
+
+// Decode a JSON array of 1 to maxBulkBooks items
+func decodeBulkBody[T any](w http.ResponseWriter, r *http.Request) ([]T, bool) {
+	var items []T
+	if err := decodeBody(r, &items); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Body must be a JSON array")
+		return nil, false
+	}
+	if len(items) == 0 || len(items) > maxBulkBooks {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("Send between 1 and %d items", maxBulkBooks))
+		return nil, false
+	}
+	return items, true
+}
+
+// Create many books at once. Each book is validated on its own; the valid
+// ones are inserted together in one transaction and the rest are reported
+// with their field errors.
+func bulkCreateBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	books, ok := decodeBulkBody[Book](w, r)
+	if !ok {
+		return
+	}
+
+	// Validation converts ISBNs to ISBN-13, so it comes first
+	fieldErrs := make([][]FieldError, len(books))
+	isbns := make([]string, 0, len(books))
+	for i := range books {
+		books[i].ID = 0
+		fieldErrs[i] = validateBook(&books[i])
+		isbns = append(isbns, books[i].ISBN)
+	}
+
+	// ISBNs are unique, so check them against the catalog, the trash
+	// included, and each other up front rather than failing the whole insert
+	var existing []Book
+	db.WithContext(r.Context()).Unscoped().Select("id", "isbn", "deleted_at").Where("isbn IN ?", isbns).Find(&existing)
+	taken := map[string]bool{}
+	trashed := map[string]uint{}
+	for _, b := range existing {
+		if b.DeletedAt.Valid {
+			trashed[b.ISBN] = b.ID
+		} else {
+			taken[b.ISBN] = true
+		}
+	}
+
+	results := make([]BulkResult, len(books))
+	var valid []*Book
+	var validIndex []int
+	for i := range books {
+		b := &books[i]
+		results[i] = BulkResult{Index: i, Status: bulkError}
+		errs := fieldErrs[i]
+		if b.ISBN != "" && taken[b.ISBN] {
+			errs = append(errs, FieldError{Field: "isbn", Message: "already exists"})
+		} else if id, ok := trashed[b.ISBN]; ok && b.ISBN != "" {
+			errs = append(errs, FieldError{Field: "isbn", Message: fmt.Sprintf("belongs to deleted book %d; restore it instead", id)})
+			results[i].Code = "isbn_in_trash"
+		}
+		if len(errs) > 0 {
+			results[i].Errors = errs
+			continue
+		}
+		taken[b.ISBN] = true
+		valid = append(valid, b)
+		validIndex = append(validIndex, i)
+	}
+
+	if len(valid) > 0 {
+		err := db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+			return tx.CreateInBatches(valid, bulkInsertBatch).Error
+		})
+		if err != nil {
+			if !writeHookError(w, r, err) {
+				writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create books")
+			}
+			return
+		}
+	}
+	for n, i := range validIndex {
+		results[i] = BulkResult{Index: i, Status: bulkCreated, Book: valid[n]}
+	}
+
+	var report BulkReport
+	for _, res := range results {
+		report.add(res)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Update many books at once, all or nothing. Each item is an object with
+// the book's id and the fields to change, applied like a merge patch: a
+// field left out is unchanged and null clears it. If any item fails, no
+// book is written and the report says why.
+func bulkUpdateBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	items, ok := decodeBulkBody[json.RawMessage](w, r)
+	if !ok {
+		return
+	}
+
+	// Read every item's ID first, to load the books in one query
+	patches := make([]map[string]interface{}, len(items))
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		decodeJSONNumbers(item, &patches[i])
+		if n, ok := patches[i]["id"].(json.Number); ok {
+			if id, err := strconv.ParseUint(n.String(), 10, 0); err == nil {
+				ids[i] = uint(id)
+			}
+		}
+	}
+	var books []Book
+	db.WithContext(r.Context()).Where("id IN ?", ids).Find(&books)
+	byID := make(map[uint]*Book, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+
+	results := make([]BulkResult, len(items))
+	seen := map[uint]bool{}
+	failed := false
+	for i, patch := range patches {
+		results[i] = BulkResult{Index: i, Status: bulkError}
+		book := byID[ids[i]]
+		switch {
+		case patch == nil:
+			results[i].Errors = []FieldError{{Field: "", Message: "must be a JSON object"}}
+		case ids[i] == 0:
+			results[i].Errors = []FieldError{{Field: "id", Message: "must be a book ID"}}
+		case book == nil:
+			results[i].Errors = []FieldError{{Field: "id", Message: "book not found"}}
+		case seen[ids[i]]:
+			results[i].Errors = []FieldError{{Field: "id", Message: "is listed more than once"}}
+		}
+		if results[i].Errors != nil {
+			failed = true
+			continue
+		}
+		seen[ids[i]] = true
+
+		delete(patch, "id")
+		oldISBN := book.ISBN
+		doc, errs := parseBookDocument(mergePatch(newBookDocument(book).value(), patch).(map[string]interface{}))
+		if len(errs) == 0 {
+			doc.applyTo(book)
+			errs = validateBook(book)
+		}
+		if len(errs) == 0 && book.ISBN != oldISBN {
+			var taken int64
+			db.WithContext(r.Context()).Model(&Book{}).Where("isbn = ? AND id <> ?", book.ISBN, book.ID).Count(&taken)
+			if taken > 0 {
+				errs = append(errs, FieldError{Field: "isbn", Message: "already exists"})
+			}
+		}
+		if len(errs) > 0 {
+			results[i].Errors = errs
+			failed = true
+			continue
+		}
+		results[i] = BulkResult{Index: i, Status: bulkUpdated, Book: book}
+	}
+
+	var report BulkReport
+	if failed {
+		for _, res := range results {
+			if res.Status == bulkUpdated {
+				res = BulkResult{Index: res.Index, Status: bulkSkipped}
+			}
+			report.add(res)
+		}
+		writeJSON(w, http.StatusBadRequest, report)
+		return
+	}
+
+	err := db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		for _, res := range results {
+			if err := tx.Save(res.Book).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update books")
+		}
+		return
+	}
+	for _, res := range results {
+		report.add(res)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// BulkDeleteResult lists the books a bulk delete removed
+type BulkDeleteResult struct {
+	Deleted int    `json:"deleted"`
+	IDs     []uint `json:"ids"`
+}
+
+// Parse ?ids=1,2,3
+func parseBookIDs(v string) ([]uint, error) {
+	var ids []uint
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 0)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid book ID %q", part)
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(ids) > maxBulkBooks {
+		return nil, fmt.Errorf("at most %d IDs can be given at once", maxBulkBooks)
+	}
+	return ids, nil
+}
+
+// BookBatch is the response to GET /books?ids=: the books in the order
+// they were asked for, and the IDs that matched no book
+type BookBatch struct {
+	Items   []Book `json:"items"`
+	Missing []uint `json:"missing"`
+}
+
+// A BookBatch cut down to the requested fields
+type sparseBookBatch struct {
+	Items   interface{} `json:"items"`
+	Missing []uint      `json:"missing"`
+}
+
+// Get exactly the books named by ?ids=, in one round trip, for clients
+// hydrating a shortlist. Filters and sort don't apply.
+func getBookBatch(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseBookIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	fields, err := parseBookFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_fields", "Invalid fields: "+err.Error())
+		return
+	}
+
+	var found []Book
+	if len(ids) > 0 {
+		if err := db.WithContext(r.Context()).Preload("Tags").Where("id IN ?", ids).Find(&found).Error; err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get books")
+			return
+		}
+	}
+	byID := make(map[uint]Book, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+	batch := BookBatch{Items: []Book{}, Missing: []uint{}}
+	for _, id := range ids {
+		if b, ok := byID[id]; ok {
+			batch.Items = append(batch.Items, b)
+		} else {
+			batch.Missing = append(batch.Missing, id)
+		}
+	}
+
+	total := int64(len(batch.Items))
+	meta := ListMeta{Total: &total, Page: 1, Missing: batch.Missing}
+	if env, ok := enveloped(r, fields.projectList(batch.Items), meta).(Envelope); ok {
+		writeJSONConditional(w, r, env)
+		return
+	}
+	if fields != nil {
+		writeJSONConditional(w, r, sparseBookBatch{Items: fields.projectList(batch.Items), Missing: batch.Missing})
+		return
+	}
+	writeJSONConditional(w, r, batch)
+}
+
+// Delete the books named by ?ids= and matching the listing filters, all
+// or none. IDs that don't exist are ignored.
+func bulkDeleteBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	ids, err := parseBookIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	filter, err := parseBookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+	// An empty selection would delete the whole catalog
+	if len(ids) == 0 && filter.empty() {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Pass ids or a filter to choose the books to delete")
+		return
+	}
+
+	var books []Book
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		scope := filter.apply(tx)
+		if len(ids) > 0 {
+			scope = scope.Where("id IN ?", ids)
+		}
+		if err := scope.Order("id").Find(&books).Error; err != nil {
+			return err
+		}
+		for i := range books {
+			if err := deleteBookTx(tx, &books[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete books")
+		}
+		return
+	}
+
+	result := BulkDeleteResult{Deleted: len(books), IDs: []uint{}}
+	for _, book := range books {
+		result.IDs = append(result.IDs, book.ID)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
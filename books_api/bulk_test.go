@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func bulkRequest(t *testing.T, router http.Handler, method, path, body string) (*httptest.ResponseRecorder, BulkReport) {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var report BulkReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	return response, report
+}
+
+func TestBulkCreateBooks(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	router := setupRouter()
+
+	body := `[
+		{"title": "Neuromancer", "author": "William Gibson", "isbn": "9780441569595", "year": 1984},
+		{"title": "", "author": "Nobody", "isbn": "9780000000001"},
+		{"title": "Dune again", "author": "Frank Herbert", "isbn": "0441013597"},
+		{"title": "Count Zero", "author": "William Gibson", "isbn": "9780441117734"},
+		{"title": "Count Zero twice", "author": "William Gibson", "isbn": "978-0-441-11773-4"}
+	]`
+	response, report := bulkRequest(t, router, "POST", "/api/v1/books/bulk", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	if report.Succeeded != 2 || report.Failed != 3 || len(report.Results) != 5 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	wantStatus := []string{bulkCreated, bulkError, bulkError, bulkCreated, bulkError}
+	for i, res := range report.Results {
+		if res.Index != i || res.Status != wantStatus[i] {
+			t.Errorf("Unexpected result %d: %+v", i, res)
+		}
+	}
+	if report.Results[0].Book == nil || report.Results[0].Book.ID == 0 {
+		t.Errorf("Expected the created book with its ID, got %+v", report.Results[0])
+	}
+	if errs := report.Results[2].Errors; len(errs) != 1 || errs[0].Field != "isbn" || errs[0].Message != "already exists" {
+		t.Errorf("Expected a duplicate ISBN error, got %+v", errs)
+	}
+
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 3 {
+		t.Errorf("Expected 3 books, got %d", count)
+	}
+
+	for _, body := range []string{`{"title": "Dune"}`, `[]`, "[" + strings.Repeat(`{},`, maxBulkBooks) + "{}]"} {
+		if response, _ := bulkRequest(t, router, "POST", "/api/v1/books/bulk", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", response.Code)
+		}
+	}
+}
+
+func TestBulkCreateTrashedISBN(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	db.Delete(&dune)
+	router := setupRouter()
+
+	body := `[
+		{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441013593"},
+		{"title": "Neuromancer", "author": "William Gibson", "isbn": "9780441569595"}
+	]`
+	response, report := bulkRequest(t, router, "POST", "/api/v1/books/bulk", body)
+	if response.Code != http.StatusOK || report.Succeeded != 1 || report.Failed != 1 {
+		t.Fatalf("Expected one created and one failed, got %d: %s", response.Code, response.Body.String())
+	}
+	if res := report.Results[0]; res.Code != "isbn_in_trash" || len(res.Errors) != 1 || res.Errors[0].Field != "isbn" {
+		t.Errorf("Expected isbn_in_trash on the trashed ISBN, got %+v", res)
+	}
+	if res := report.Results[1]; res.Status != bulkCreated || res.Code != "" {
+		t.Errorf("Expected the other book created, got %+v", res)
+	}
+}
+
+func TestBulkUpdateBooks(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965}
+	neuro := Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Description: "Cyberpunk"}
+	db.Create(&dune)
+	db.Create(&neuro)
+	router := setupRouter()
+
+	// One bad item and nothing is written
+	body := fmt.Sprintf(`[
+		{"id": %d, "year": 1966},
+		{"id": %d, "title": ""},
+		{"id": 99999, "title": "Ghost"},
+		{"title": "No ID"},
+		{"id": %d, "isbn": "9780441569595"}
+	]`, dune.ID, neuro.ID, dune.ID)
+	response, report := bulkRequest(t, router, "PUT", "/api/v1/books/bulk", body)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d: %s", response.Code, response.Body.String())
+	}
+	wantStatus := []string{bulkSkipped, bulkError, bulkError, bulkError, bulkError}
+	for i, res := range report.Results {
+		if res.Index != i || res.Status != wantStatus[i] {
+			t.Errorf("Unexpected result %d: %+v", i, res)
+		}
+	}
+	if report.Succeeded != 0 || report.Failed != 4 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if errs := report.Results[2].Errors; len(errs) != 1 || errs[0].Message != "book not found" {
+		t.Errorf("Expected a missing book error, got %+v", errs)
+	}
+	if errs := report.Results[4].Errors; len(errs) != 1 || errs[0].Message != "is listed more than once" {
+		t.Errorf("Expected a repeated ID error, got %+v", errs)
+	}
+	var stored Book
+	db.First(&stored, dune.ID)
+	if stored.Year != 1965 {
+		t.Errorf("Expected no change after a failed batch, got year %d", stored.Year)
+	}
+
+	// Merge semantics: named fields change, null clears, the rest is kept
+	body = fmt.Sprintf(`[
+		{"id": %d, "year": 1966, "price_cents": 0},
+		{"id": %d, "description": null, "title": "Neuromancer (Sprawl 1)"}
+	]`, dune.ID, neuro.ID)
+	response, report = bulkRequest(t, router, "PUT", "/api/v1/books/bulk", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	if report.Succeeded != 2 || report.Failed != 0 || report.Results[1].Status != bulkUpdated {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	db.First(&stored, dune.ID)
+	if stored.Year != 1966 || stored.Title != "Dune" {
+		t.Errorf("Unexpected Dune after update: %+v", stored)
+	}
+	stored = Book{}
+	db.First(&stored, neuro.ID)
+	if stored.Description != "" || stored.Title != "Neuromancer (Sprawl 1)" || stored.Author != "William Gibson" {
+		t.Errorf("Unexpected Neuromancer after update: %+v", stored)
+	}
+
+	// A taken ISBN is caught before writing
+	body = fmt.Sprintf(`[{"id": %d, "isbn": "9780441569595"}]`, dune.ID)
+	response, report = bulkRequest(t, router, "PUT", "/api/v1/books/bulk", body)
+	if response.Code != http.StatusBadRequest || len(report.Results) != 1 || report.Results[0].Errors[0].Field != "isbn" {
+		t.Errorf("Expected a duplicate ISBN error, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestBulkDeleteBooks(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984},
+		{Title: "Count Zero", Author: "William Gibson", ISBN: "9780441117734", Year: 1986},
+		{Title: "Mona Lisa Overdrive", Author: "William Gibson", ISBN: "9780553281743", Year: 1988},
+	} {
+		db.Create(&b)
+	}
+	db.Create(&Review{BookID: 1, Rating: 5})
+	router := setupRouter()
+
+	// A refused book keeps the whole selection
+	usePlugin(t, &Plugin{Name: "keep-dune", BeforeDelete: func(tx *gorm.DB, b *Book) error {
+		if b.Title == "Dune" {
+			return &HookError{Status: http.StatusForbidden, Message: "Dune stays"}
+		}
+		return nil
+	}})
+	req, _ := http.NewRequest("DELETE", "/api/v1/books?author=e", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	plugins = nil
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if response.Code != http.StatusForbidden || count != 4 {
+		t.Fatalf("Expected the delete to be refused as a whole, got %d with %d books left", response.Code, count)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/v1/books?ids=1,2,99", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var result BulkDeleteResult
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if response.Code != http.StatusOK || result.Deleted != 2 || len(result.IDs) != 2 || result.IDs[1] != 2 {
+		t.Fatalf("Expected books 1 and 2 deleted, got %d: %s", response.Code, response.Body.String())
+	}
+	// Reviews stay with the book in the trash until it is purged
+	var reviews int64
+	db.Model(&Review{}).Count(&reviews)
+	if reviews != 1 {
+		t.Errorf("Expected the review kept for a restore, got %d", reviews)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/v1/books?author=gibson&year_max=1986", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Deleted != 1 || result.IDs[0] != 3 {
+		t.Errorf("Expected the filter to select book 3, got %s", response.Body.String())
+	}
+
+	for _, query := range []string{"", "?ids=1,x", "?ids=0", "?year_min=abc"} {
+		req, _ := http.NewRequest("DELETE", "/api/v1/books"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, response.Code)
+		}
+	}
+	db.Model(&Book{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected one book left, got %d", count)
+	}
+}
+
+func TestGetBookBatch(t *testing.T) {
+	clearDB()
+	var ids []uint
+	for _, title := range []string{"Dune", "Emma", "Ulysses"} {
+		b := Book{Title: title, Author: "Someone", ISBN: "isbn-" + title}
+		db.Create(&b)
+		ids = append(ids, b.ID)
+	}
+	router := setupRouter()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// Request order is kept, filters are ignored and unknown IDs reported
+	response := get(fmt.Sprintf("ids=%d,99,%d&author=nobody", ids[2], ids[0]))
+	var batch BookBatch
+	json.Unmarshal(response.Body.Bytes(), &batch)
+	if got := bookTitles(batch.Items); fmt.Sprint(got) != "[Ulysses Dune]" || fmt.Sprint(batch.Missing) != "[99]" {
+		t.Errorf("Expected [Ulysses Dune] missing [99], got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = get(fmt.Sprintf("ids=%d&fields=title", ids[1]))
+	if body := strings.TrimSpace(response.Body.String()); body != `{"items":[{"title":"Emma"}],"missing":[]}` {
+		t.Errorf("Expected only titles, got %s", body)
+	}
+
+	if response := get("ids=1,x"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad ID, got %d", response.Code)
+	}
+}
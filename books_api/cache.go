@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// localCache is a small in-process TTL cache. A zero TTL disables it.
+type localCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]cacheItem
+}
+
+type cacheItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newLocalCache(ttl time.Duration) *localCache {
+	return &localCache{ttl: ttl, items: make(map[string]cacheItem)}
+}
+
+func (c *localCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expires) {
+		delete(c.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+func (c *localCache) Set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = cacheItem{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *localCache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+}
+
+func (c *localCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]cacheItem)
+}
+
+// Cache of book responses, keyed by bookCacheKey
+var bookCache = newLocalCache(cfg.CacheTTL)
+
+func bookCacheKey(id uint) string {
+	return fmt.Sprintf("book:%d", id)
+}
+
+// Drop keys from this instance's cache and every other instance's. With no
+// keys the whole cache is flushed.
+func invalidateCache(keys ...string) {
+	applyInvalidation(keys)
+	if cacheBus != nil {
+		cacheBus.Publish(keys)
+	}
+}
+
+func applyInvalidation(keys []string) {
+	if len(keys) == 0 {
+		bookCache.Flush()
+		return
+	}
+	bookCache.Delete(keys...)
+}
+
+// Invalidate cached books once a write has committed. Writes that don't
+// name specific books, such as bulk updates, flush the whole cache.
+func registerCacheCallbacks(db *gorm.DB) {
+	invalidate := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "books" {
+			return
+		}
+		var keys []string
+		for _, id := range statementIDs(tx) {
+			keys = append(keys, bookCacheKey(id))
+		}
+		invalidateCache(keys...)
+	}
+
+	const after = "gorm:commit_or_rollback_transaction"
+	db.Callback().Create().After(after).Register("cache:invalidate_create", invalidate)
+	db.Callback().Update().After(after).Register("cache:invalidate_update", invalidate)
+	db.Callback().Delete().After(after).Register("cache:invalidate_delete", invalidate)
+}
+
+// Primary keys of the records a statement wrote, or nil when they can't all
+// be identified
+func statementIDs(tx *gorm.DB) []uint {
+	field := tx.Statement.Schema.PrioritizedPrimaryField
+	rv := reflect.Indirect(tx.Statement.ReflectValue)
+	if field == nil || !rv.IsValid() {
+		return nil
+	}
+
+	var rows []reflect.Value
+	switch rv.Kind() {
+	case reflect.Struct:
+		rows = append(rows, rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, reflect.Indirect(rv.Index(i)))
+		}
+	}
+
+	var ids []uint
+	for _, row := range rows {
+		value, zero := field.ValueOf(tx.Statement.Context, row)
+		id, ok := value.(uint)
+		if zero || !ok {
+			return nil
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Pub/sub channel that connects every instance's cache
+var cacheBus *redisInvalidator
+
+// redisInvalidator broadcasts invalidations over Redis pub/sub and applies
+// the ones sent by other instances
+type redisInvalidator struct {
+	client  *redis.Client
+	channel string
+	origin  string
+}
+
+// Message sent on the invalidation channel
+type invalidationMessage struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+func initCacheBus() {
+	if cfg.RedisURL == "" {
+		return
+	}
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
+	}
+	cacheBus = newRedisInvalidator(redis.NewClient(opts), cfg.CacheChannel)
+	go cacheBus.Listen(context.Background())
+}
+
+func newRedisInvalidator(client *redis.Client, channel string) *redisInvalidator {
+	return &redisInvalidator{client: client, channel: channel, origin: randomID()}
+}
+
+// Announce invalidated keys to the other instances
+func (b *redisInvalidator) Publish(keys []string) {
+	msg, _ := json.Marshal(invalidationMessage{Origin: b.origin, Keys: keys})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := b.client.Publish(ctx, b.channel, msg).Err(); err != nil {
+		log.Printf("Publishing cache invalidation failed: %v", err)
+	}
+}
+
+// Apply invalidations from other instances until ctx is cancelled. The
+// client resubscribes after a dropped connection; anything missed in the
+// meantime expires with the cache TTL.
+func (b *redisInvalidator) Listen(ctx context.Context) {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-messages:
+			if !ok {
+				return
+			}
+			var msg invalidationMessage
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Printf("Ignoring malformed cache invalidation: %v", err)
+				continue
+			}
+			if msg.Origin != b.origin {
+				applyInvalidation(msg.Keys)
+			}
+		}
+	}
+}
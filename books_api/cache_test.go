@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLocalCacheExpires(t *testing.T) {
+	c := newLocalCache(20 * time.Millisecond)
+	c.Set("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Expected cached value, got %v %v", v, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expected entry to expire")
+	}
+
+	disabled := newLocalCache(0)
+	disabled.Set("a", 1)
+	if _, ok := disabled.Get("a"); ok {
+		t.Error("Expected a zero TTL to disable caching")
+	}
+}
+
+// Fetch a book's title through the API
+func fetchTitle(t *testing.T, router http.Handler, id string) string {
+	req, _ := http.NewRequest("GET", "/api/v1/books/"+id, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	return book.Title
+}
+
+func TestWritesInvalidateCachedBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	book := Book{Title: "First", Author: "Author", ISBN: "1234567890123"}
+	db.Create(&book)
+
+	if title := fetchTitle(t, router, "1"); title != "First" {
+		t.Fatalf("Expected First, got %q", title)
+	}
+	if _, ok := bookCache.Get(bookCacheKey(1)); !ok {
+		t.Fatal("Expected the book to be cached")
+	}
+
+	// Any write path, not just the API, drops the cached copy
+	book.Title = "Second"
+	db.Save(&book)
+	if title := fetchTitle(t, router, "1"); title != "Second" {
+		t.Errorf("Expected Second after save, got %q", title)
+	}
+
+	// Bulk updates can't name their books, so they flush everything
+	db.Model(&Book{}).Where("id = ?", 1).Update("title", "Third")
+	if title := fetchTitle(t, router, "1"); title != "Third" {
+		t.Errorf("Expected Third after bulk update, got %q", title)
+	}
+}
+
+func TestRedisInvalidationAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	newClient := func() *redis.Client { return redis.NewClient(&redis.Options{Addr: server.Addr()}) }
+
+	// Two instances share this process's cache; each only acts on the
+	// other's messages
+	local := newRedisInvalidator(newClient(), "books:invalidate")
+	remote := newRedisInvalidator(newClient(), "books:invalidate")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go local.Listen(ctx)
+
+	// Wait for the subscription before publishing
+	deadline := time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	bookCache.Set(bookCacheKey(7), Book{Title: "Stale"})
+	remote.Publish([]string{bookCacheKey(7)})
+
+	for time.Now().Before(deadline) {
+		if _, ok := bookCache.Get(bookCacheKey(7)); !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the remote invalidation to drop the cached book")
+}
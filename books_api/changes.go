@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Change feed page sizes
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// BookChange records that a book was created, updated or deleted. Its ID
+// is the change's sequence number, which sync clients keep as their
+// checkpoint. Changes are written in the same transaction as the book,
+// by the hooks that record domain events, whether or not events are
+// published anywhere.
+type BookChange struct {
+	ID     uint `gorm:"primaryKey"`
+	BookID uint `gorm:"index;not null"`
+	// The library whose catalog the book is in, so each library's sync
+	// clients only see their own changes
+	LibraryID uint   `gorm:"index;not null;default:0"`
+	Type      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// BookChangeEntry is the latest change to one book since the checkpoint
+type BookChangeEntry struct {
+	Seq    uint   `json:"seq"`
+	Type   string `json:"type"`
+	BookID uint   `json:"book_id"`
+	// The book as it is now; absent for deletions
+	Book *Book `json:"book,omitempty"`
+}
+
+// ChangeFeed is a page of changes after a checkpoint
+type ChangeFeed struct {
+	Changes []BookChangeEntry `json:"changes"`
+	// Pass as ?since= for the next page, or to sync again later
+	Next    string `json:"next"`
+	HasMore bool   `json:"has_more"`
+}
+
+// Record a change to b within tx
+func recordBookChange(tx *gorm.DB, typ string, b *Book) error {
+	change := BookChange{BookID: b.ID, LibraryID: b.LibraryID, Type: typ}
+	if id, ok := libraryFrom(tx.Statement.Context); ok {
+		change.LibraryID = id
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&change).Error
+}
+
+// Parse ?since=: a sequence number from an earlier response, or an
+// RFC 3339 time, which starts after the changes made up to then
+func parseChangesSince(v string) (uint, bool) {
+	if v == "" {
+		return 0, true
+	}
+	if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return uint(n), true
+	}
+	at, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return 0, false
+	}
+	// SQLite compares times as text, so match the zone they're stored in
+	var seq uint
+	db.Model(&BookChange{}).Where("created_at <= ?", at.Local()).Select("COALESCE(MAX(id), 0)").Scan(&seq)
+	return seq, true
+}
+
+// Books created, updated or deleted after a checkpoint, for clients that
+// keep an offline copy of the catalog. Each book appears once, with its
+// latest change: as it is now, or as a deletion.
+func getBookChanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	since, ok := parseChangesSince(r.URL.Query().Get("since"))
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "since must be a sequence number or an RFC 3339 time")
+		return
+	}
+	limit := defaultChangesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangesLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	// The latest change per book, and whether any of them created it
+	var latest []struct {
+		BookID  uint
+		Seq     uint
+		Created bool
+	}
+	query := db.Model(&BookChange{})
+	if library, ok := libraryFrom(r.Context()); ok {
+		query = query.Where("library_id = ?", library)
+	}
+	err := query.
+		Select("book_id, MAX(id) AS seq, MAX(CASE WHEN type = ? THEN 1 ELSE 0 END) AS created", eventBookCreated).
+		Where("id > ?", since).
+		Group("book_id").Order("seq").Limit(limit + 1).
+		Scan(&latest).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read changes")
+		return
+	}
+	feed := ChangeFeed{Changes: []BookChangeEntry{}, Next: strconv.FormatUint(uint64(since), 10)}
+	if len(latest) > limit {
+		latest, feed.HasMore = latest[:limit], true
+	}
+	if len(latest) == 0 {
+		writeJSON(w, http.StatusOK, feed)
+		return
+	}
+
+	seqs := make([]uint, len(latest))
+	ids := make([]uint, len(latest))
+	for i, l := range latest {
+		seqs[i], ids[i] = l.Seq, l.BookID
+	}
+	var changes []BookChange
+	var books []Book
+	if err := db.Where("id IN ?", seqs).Find(&changes).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read changes")
+		return
+	}
+	if err := db.WithContext(r.Context()).Preload("Tags").Where("id IN ?", ids).Find(&books).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read changes")
+		return
+	}
+	types := map[uint]string{}
+	for _, c := range changes {
+		types[c.ID] = c.Type
+	}
+	byID := map[uint]*Book{}
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+
+	for _, l := range latest {
+		entry := BookChangeEntry{Seq: l.Seq, BookID: l.BookID, Type: "updated"}
+		switch book := byID[l.BookID]; {
+		case types[l.Seq] == eventBookDeleted || book == nil:
+			entry.Type = "deleted"
+		case l.Created:
+			entry.Type, entry.Book = "created", book
+		default:
+			entry.Book = book
+		}
+		feed.Changes = append(feed.Changes, entry)
+	}
+	feed.Next = strconv.FormatUint(uint64(latest[len(latest)-1].Seq), 10)
+	writeJSON(w, http.StatusOK, feed)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func getChangeFeed(t *testing.T, router http.Handler, query string) ChangeFeed {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/api/v1/books/changes"+query, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for %s, got %d: %s", query, response.Code, response.Body.String())
+	}
+	var feed ChangeFeed
+	json.Unmarshal(response.Body.Bytes(), &feed)
+	return feed
+}
+
+func TestBookChanges(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	db.Create(&dune)
+	db.Create(&emma)
+	checkpoint := getChangeFeed(t, router, "").Next
+	if checkpoint != "2" {
+		t.Fatalf("Expected a checkpoint after both creates, got %q", checkpoint)
+	}
+
+	beloved := Book{Title: "Beloved", Author: "Toni Morrison", ISBN: "9781400033416"}
+	db.Create(&beloved)
+	dune.Year = 1965
+	db.Save(&dune)
+	db.Save(&dune)
+	db.Delete(&emma)
+
+	// One entry per book, in the order of its latest change
+	feed := getChangeFeed(t, router, "?since="+checkpoint)
+	want := []struct {
+		id  uint
+		typ string
+	}{{beloved.ID, "created"}, {dune.ID, "updated"}, {emma.ID, "deleted"}}
+	if len(feed.Changes) != len(want) || feed.HasMore || feed.Next != "6" {
+		t.Fatalf("Unexpected feed %+v", feed)
+	}
+	for i, w := range want {
+		c := feed.Changes[i]
+		if c.BookID != w.id || c.Type != w.typ || (c.Book == nil) != (w.typ == "deleted") {
+			t.Errorf("Change %d: expected %s of book %d, got %+v", i, w.typ, w.id, c)
+		}
+	}
+	if feed.Changes[1].Book.Year != 1965 {
+		t.Errorf("Expected the book as it is now, got %+v", feed.Changes[1].Book)
+	}
+
+	// Pages continue from next
+	page := getChangeFeed(t, router, "?since="+checkpoint+"&limit=2")
+	if len(page.Changes) != 2 || !page.HasMore {
+		t.Fatalf("Expected a full first page, got %+v", page)
+	}
+	page = getChangeFeed(t, router, "?since="+page.Next+"&limit=2")
+	if len(page.Changes) != 1 || page.HasMore || page.Changes[0].Type != "deleted" {
+		t.Errorf("Expected the deletion on the last page, got %+v", page)
+	}
+	if page := getChangeFeed(t, router, "?since="+page.Next); len(page.Changes) != 0 || page.Next != "6" {
+		t.Errorf("Expected no changes after the last checkpoint, got %+v", page)
+	}
+}
+
+func TestBookChangesSinceTime(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Model(&BookChange{}).Where("id = 1").Update("created_at", time.Now().Add(-time.Hour))
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+
+	since := url.QueryEscape(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	feed := getChangeFeed(t, router, "?since="+since)
+	if len(feed.Changes) != 1 || feed.Changes[0].Book.Title != "Emma" {
+		t.Errorf("Expected only the change after the time, got %+v", feed)
+	}
+
+	for _, query := range []string{"?since=yesterday", "?limit=0", "?limit=1001"} {
+		req, _ := http.NewRequest("GET", "/api/v1/books/changes"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, response.Code)
+		}
+	}
+}
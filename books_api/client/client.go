@@ -0,0 +1,169 @@
+// Package client is a Go client for the Books API's operational endpoints,
+// used by booksctl.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls a running Books API
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	// Session token sent as a bearer token, needed when the API has an
+	// auth backend
+	Token string
+}
+
+// New returns a client for the API at baseURL, such as
+// "http://localhost:8080"
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is a non-2xx response from the API
+type Error struct {
+	Status int
+	Body   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.Status, strings.TrimSpace(e.Body))
+}
+
+// ScheduledJob is a maintenance job with its schedule and latest run
+type ScheduledJob struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Schedule    string        `json:"schedule"`
+	NextRun     *time.Time    `json:"next_run"`
+	LastRun     *ScheduledRun `json:"last_run"`
+}
+
+// ScheduledRun is one execution of a scheduled job
+type ScheduledRun struct {
+	ID         uint       `json:"id"`
+	Job        string     `json:"job"`
+	Trigger    string     `json:"trigger"`
+	Instance   string     `json:"instance"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Finished reports whether the run has stopped
+func (r *ScheduledRun) Finished() bool {
+	return r.Status != "running"
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &Error{Status: resp.StatusCode, Body: string(data)}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Health returns the API's health status
+func (c *Client) Health(ctx context.Context) (string, error) {
+	var h struct {
+		Status string `json:"status"`
+	}
+	err := c.do(ctx, "GET", "/health", nil, &h)
+	return h.Status, err
+}
+
+// ScheduledJobs lists every scheduled job
+func (c *Client) ScheduledJobs(ctx context.Context) ([]ScheduledJob, error) {
+	var jobs []ScheduledJob
+	err := c.do(ctx, "GET", "/api/v1/admin/scheduled-jobs", nil, &jobs)
+	return jobs, err
+}
+
+// RunJob starts a scheduled job now
+func (c *Client) RunJob(ctx context.Context, name string) (*ScheduledRun, error) {
+	var run ScheduledRun
+	err := c.do(ctx, "POST", "/api/v1/admin/scheduled-jobs/"+url.PathEscape(name)+"/run", nil, &run)
+	return &run, err
+}
+
+// JobRuns returns a job's run history, newest first
+func (c *Client) JobRuns(ctx context.Context, name string) ([]ScheduledRun, error) {
+	var runs []ScheduledRun
+	err := c.do(ctx, "GET", "/api/v1/admin/scheduled-jobs/"+url.PathEscape(name)+"/runs", nil, &runs)
+	return runs, err
+}
+
+// WaitForRun polls until the run finishes or ctx is done
+func (c *Client) WaitForRun(ctx context.Context, run *ScheduledRun, interval time.Duration) (*ScheduledRun, error) {
+	for {
+		runs, err := c.JobRuns(ctx, run.Job)
+		if err != nil {
+			return nil, err
+		}
+		for i := range runs {
+			if runs[i].ID == run.ID && runs[i].Finished() {
+				return &runs[i], nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Dashboard returns the admin dashboard document
+func (c *Client) Dashboard(ctx context.Context) (map[string]interface{}, error) {
+	var d map[string]interface{}
+	err := c.do(ctx, "GET", "/api/v1/admin/dashboard", nil, &d)
+	return d, err
+}
+
+// Reindex starts rebuilding the search index
+func (c *Client) Reindex(ctx context.Context) error {
+	return c.do(ctx, "POST", "/api/v1/admin/search/reindex", nil, nil)
+}
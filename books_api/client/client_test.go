@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitForRun(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/admin/scheduled-jobs/backup/runs" {
+			http.NotFound(w, r)
+			return
+		}
+		polls++
+		status := "running"
+		if polls > 1 {
+			status = "succeeded"
+		}
+		w.Write([]byte(`[{"id":2,"job":"backup","status":"` + status + `"},{"id":1,"job":"backup","status":"failed"}]`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	run, err := c.WaitForRun(context.Background(), &ScheduledRun{ID: 2, Job: "backup"}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != "succeeded" || polls != 2 {
+		t.Errorf("Expected to poll until the run succeeded, got %s after %d polls", run.Status, polls)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Job is already running", http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).RunJob(context.Background(), "backup")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Fatalf("Expected a 409 API error, got %v", err)
+	}
+	if apiErr.Error() != "HTTP 409: Job is already running" {
+		t.Errorf("Unexpected message %q", apiErr.Error())
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Token = "abc.def"
+	if _, err := c.Health(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer abc.def" {
+		t.Errorf("Expected the token as a bearer token, got %q", auth)
+	}
+}
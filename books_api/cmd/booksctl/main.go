@@ -0,0 +1,186 @@
+// Command booksctl runs routine admin tasks against a running Books API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"books_api/client"
+)
+
+const usage = `usage: booksctl [-url URL] [-token TOKEN] <command>
+
+Commands:
+  health               Check the API is up
+  dashboard            Print the admin dashboard as JSON
+  jobs                 List scheduled jobs
+  jobs run NAME        Start a scheduled job now
+  jobs runs NAME       Show a job's run history
+  backup [-wait]       Back up the database
+  reindex              Rebuild the search index
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("booksctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	baseURL := fs.String("url", envOr("BOOKS_API_URL", "http://localhost:8080"), "API base URL")
+	token := fs.String("token", os.Getenv("BOOKS_API_TOKEN"), "session token from POST /api/v1/auth/login")
+	timeout := fs.Duration("timeout", 5*time.Minute, "give up after this long")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(*baseURL)
+	c.Token = *token
+
+	err := dispatch(ctx, c, fs.Args(), stdout)
+	if err == errUsage {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "booksctl:", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = fmt.Errorf("usage")
+
+func dispatch(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	switch args[0] {
+	case "health":
+		status, err := c.Health(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, status)
+		return nil
+
+	case "dashboard":
+		d, err := c.Dashboard(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(out, d)
+
+	case "jobs":
+		switch {
+		case len(args) == 1:
+			jobs, err := c.ScheduledJobs(ctx)
+			if err != nil {
+				return err
+			}
+			printJobs(out, jobs)
+			return nil
+		case len(args) == 3 && args[1] == "run":
+			r, err := c.RunJob(ctx, args[2])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Started %s run %d\n", r.Job, r.ID)
+			return nil
+		case len(args) == 3 && args[1] == "runs":
+			runs, err := c.JobRuns(ctx, args[2])
+			if err != nil {
+				return err
+			}
+			printRuns(out, runs)
+			return nil
+		}
+		return errUsage
+
+	case "backup":
+		fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+		wait := fs.Bool("wait", false, "wait for the backup to finish")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		r, err := c.RunJob(ctx, "backup")
+		if err != nil {
+			return err
+		}
+		if !*wait {
+			fmt.Fprintf(out, "Started backup run %d\n", r.ID)
+			return nil
+		}
+		if r, err = c.WaitForRun(ctx, r, time.Second); err != nil {
+			return err
+		}
+		if r.Status != "succeeded" {
+			return fmt.Errorf("backup %s: %s", r.Status, r.Error)
+		}
+		fmt.Fprintf(out, "Backup run %d succeeded\n", r.ID)
+		return nil
+
+	case "reindex":
+		if err := c.Reindex(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Reindex started")
+		return nil
+	}
+	return errUsage
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printJobs(out io.Writer, jobs []client.ScheduledJob) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSCHEDULE\tNEXT RUN\tLAST RUN")
+	for _, j := range jobs {
+		schedule, next, last := "-", "-", "-"
+		if j.Schedule != "" {
+			schedule = j.Schedule
+		}
+		if j.NextRun != nil {
+			next = j.NextRun.Local().Format(time.RFC3339)
+		}
+		if j.LastRun != nil {
+			last = j.LastRun.Status + " " + j.LastRun.StartedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", j.Name, schedule, next, last)
+	}
+	tw.Flush()
+}
+
+func printRuns(out io.Writer, runs []client.ScheduledRun) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tTRIGGER\tSTARTED\tDURATION\tERROR")
+	for _, r := range runs {
+		duration := "-"
+		if r.FinishedAt != nil {
+			duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Status, r.Trigger, r.StartedAt.Local().Format(time.RFC3339), duration, r.Error)
+	}
+	tw.Flush()
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackupWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v1/admin/scheduled-jobs/backup/run":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":7,"job":"backup","status":"running"}`))
+		case "GET /api/v1/admin/scheduled-jobs/backup/runs":
+			w.Write([]byte(`[{"id":7,"job":"backup","status":"failed","error":"disk full"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-url", srv.URL, "backup", "-wait"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit 1 for a failed backup, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "backup failed: disk full") {
+		t.Errorf("Unexpected error output %q", stderr.String())
+	}
+}
+
+func TestJobsList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name":"backup","schedule":"0 3 * * *"},{"name":"search-reindex"}]`))
+	}))
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-url", srv.URL, "jobs"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "backup") || !strings.Contains(lines[1], "0 3 * * *") {
+		t.Errorf("Unexpected job table:\n%s", stdout.String())
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"users", "create"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit 2, got %d", code)
+	}
+}
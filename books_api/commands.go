@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Run a command-line subcommand instead of the server, returning the exit
+// status
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	usage := func() int {
+		fmt.Fprintln(stderr, "usage: books_api [gen ts-client|gen openapi] [-o file]")
+		return 2
+	}
+	if len(args) < 2 || args[0] != "gen" {
+		return usage()
+	}
+
+	var generate func() ([]byte, error)
+	switch args[1] {
+	case "ts-client":
+		generate = func() ([]byte, error) {
+			return []byte(generateTSClient(buildOpenAPISpec())), nil
+		}
+	case "openapi":
+		generate = func() ([]byte, error) {
+			data, err := json.MarshalIndent(buildOpenAPISpec(), "", "  ")
+			return append(data, '\n'), err
+		}
+	default:
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("gen "+args[1], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args[2:]); err != nil {
+		return 2
+	}
+
+	data, err := generate()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *out == "" {
+		stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
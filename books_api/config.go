@@ -0,0 +1,348 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds runtime settings read from the environment
+type Config struct {
+	DBPath string
+
+	// Accepted publication year range. MaxYear of 0 means "next year",
+	// so forthcoming titles can be catalogued ahead of release.
+	MinYear int
+	MaxYear int
+
+	// Honor X-HTTP-Method-Override and _method on POST requests. Off by
+	// default, since it lets a cross-site form POST reach DELETE routes.
+	MethodOverride bool
+
+	// Address of the gRPC server, or "none"
+	GRPCAddr string
+
+	// External metadata lookups
+	MetadataProvider string
+	MetadataTimeout  time.Duration
+	MetadataCacheTTL time.Duration
+	OpenLibraryURL   string
+	AutoEnrich       bool
+
+	// Pause between lookups in bulk refresh jobs
+	MetadataRefreshDelay time.Duration
+
+	GoogleBooksURL    string
+	GoogleBooksAPIKey string
+
+	// Library catalog searched over SRU, with its CQL index names
+	SRUURL        string
+	SRUISBNIndex  string
+	SRUTitleIndex string
+
+	// File storage for covers and exports
+	BlobStore      string
+	BlobDir        string
+	BlobPublicURL  string
+	BlobSigningKey string
+	PresignTTL     time.Duration
+	S3             S3Config
+
+	// Background job workers
+	JobWorkers   int
+	JobQueueSize int
+	JobTimeout   time.Duration
+
+	// Outgoing email
+	Mailer   string
+	MailFrom string
+	MailDir  string
+	SMTP     SMTPConfig
+
+	// Domain event publishing
+	EventBroker        string
+	NATSURL            string
+	KafkaBrokers       string
+	EventSubjectPrefix string
+	EventPollInterval  time.Duration
+	EventRetention     time.Duration
+
+	// Outgoing webhook deliveries
+	WebhookPollInterval time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetryBase    time.Duration
+
+	// Local response cache, shared invalidation over Redis
+	CacheTTL     time.Duration
+	RedisURL     string
+	CacheChannel string
+
+	// Search backend
+	SearchBackend       string
+	ElasticsearchURL    string
+	ElasticsearchIndex  string
+	ElasticsearchAPIKey string
+
+	// How often "also read" scores are recomputed
+	RecommendationsInterval time.Duration
+
+	// Scheduled jobs, as "name=schedule;name=schedule"
+	ScheduledJobs   string
+	ScheduleLockTTL time.Duration
+	BackupDir       string
+	BackupKeep      int
+	// How long deleted books can be restored before trash-purge removes them
+	TrashRetention time.Duration
+
+	// Lending: how long a loan lasts, and how often it can be renewed
+	LoanPeriod  time.Duration
+	MaxRenewals int
+	// Fines for late returns: charged per day late, waived within the
+	// grace period, and capped per loan (0 for no cap)
+	FineDailyCents int64
+	FineGrace      time.Duration
+	FineMaxCents   int64
+	// How long before a loan falls due loan-reminders emails the member
+	LoanReminder time.Duration
+
+	// Where books can be shelved, from BOOK_LOCATIONS
+	Locations []Location
+
+	// The month, 1 to 12, the library's fiscal year starts in, for
+	// acquisitions reports
+	FiscalYearStart int
+
+	// SQLite replication: none, litestream or litefs
+	Replication          string
+	LitestreamMetricsURL string
+	LiteFSDir            string
+
+	// Authentication: none, ldap or saml
+	AuthBackend  string
+	AuthSecret   string
+	AuthTokenTTL time.Duration
+	LDAP         LDAPConfig
+	SAML         SAMLConfig
+
+	// Chat notifications, each for a comma-separated list of events
+	SlackWebhookURL     string
+	SlackNotifyEvents   string
+	DiscordWebhookURL   string
+	DiscordNotifyEvents string
+
+	// Storefront payments: none or stripe
+	PaymentProvider   string
+	StoreCurrency     string
+	CheckoutReturnURL string
+	Stripe            StripeConfig
+}
+
+// Active configuration
+var cfg = loadConfig()
+
+// Load configuration from environment variables
+func loadConfig() Config {
+	return Config{
+		DBPath:  envString("DB_PATH", "books.db"),
+		MinYear: envInt("BOOKS_MIN_YEAR", 1450),
+		MaxYear: envInt("BOOKS_MAX_YEAR", 0),
+
+		MethodOverride: envBool("BOOKS_METHOD_OVERRIDE", false),
+
+		GRPCAddr: envString("GRPC_ADDR", "none"),
+
+		MetadataProvider: envString("METADATA_PROVIDER", "openlibrary"),
+		MetadataTimeout:  envDuration("METADATA_TIMEOUT", 5*time.Second),
+		MetadataCacheTTL: envDuration("METADATA_CACHE_TTL", 24*time.Hour),
+		OpenLibraryURL:   envString("OPENLIBRARY_URL", "https://openlibrary.org"),
+		AutoEnrich:       envBool("BOOKS_AUTO_ENRICH", false),
+
+		MetadataRefreshDelay: envDuration("METADATA_REFRESH_DELAY", 250*time.Millisecond),
+
+		GoogleBooksURL:    envString("GOOGLE_BOOKS_URL", "https://www.googleapis.com/books/v1"),
+		GoogleBooksAPIKey: os.Getenv("GOOGLE_BOOKS_API_KEY"),
+
+		SRUURL:        envString("SRU_URL", "http://lx2.loc.gov:210/lcdb"),
+		SRUISBNIndex:  envString("SRU_ISBN_INDEX", "bath.isbn"),
+		SRUTitleIndex: envString("SRU_TITLE_INDEX", "dc.title"),
+
+		BlobStore:      envString("BLOB_STORE", "local"),
+		BlobDir:        envString("BLOB_DIR", "blobs"),
+		BlobPublicURL:  os.Getenv("BLOB_PUBLIC_URL"),
+		BlobSigningKey: os.Getenv("BLOB_SIGNING_KEY"),
+		PresignTTL:     envDuration("BLOB_PRESIGN_TTL", 15*time.Minute),
+		S3: S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          envString("S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       envBool("S3_PATH_STYLE", false),
+		},
+
+		JobWorkers:   envInt("JOB_WORKERS", 2),
+		JobQueueSize: envInt("JOB_QUEUE_SIZE", 100),
+		JobTimeout:   envDuration("JOB_TIMEOUT", 2*time.Minute),
+
+		Mailer:   envString("MAILER", "none"),
+		MailFrom: envString("MAIL_FROM", "Books API <no-reply@localhost>"),
+		MailDir:  envString("MAIL_DIR", "mail"),
+		SMTP: SMTPConfig{
+			Host:     envString("SMTP_HOST", "localhost"),
+			Port:     envInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		},
+
+		EventBroker:        envString("EVENT_BROKER", "none"),
+		NATSURL:            envString("NATS_URL", "nats://localhost:4222"),
+		KafkaBrokers:       envString("KAFKA_BROKERS", "localhost:9092"),
+		EventSubjectPrefix: envString("EVENT_SUBJECT_PREFIX", "books"),
+		EventPollInterval:  envDuration("EVENT_POLL_INTERVAL", time.Second),
+		EventRetention:     envDuration("EVENT_RETENTION", 7*24*time.Hour),
+
+		WebhookPollInterval: envDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		WebhookMaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetryBase:    envDuration("WEBHOOK_RETRY_BASE", 30*time.Second),
+
+		CacheTTL:     envDuration("CACHE_TTL", 30*time.Second),
+		RedisURL:     os.Getenv("REDIS_URL"),
+		CacheChannel: envString("CACHE_CHANNEL", "books:invalidate"),
+
+		SearchBackend:       envString("SEARCH_BACKEND", "sql"),
+		ElasticsearchURL:    envString("ELASTICSEARCH_URL", "http://localhost:9200"),
+		ElasticsearchIndex:  envString("ELASTICSEARCH_INDEX", "books"),
+		ElasticsearchAPIKey: os.Getenv("ELASTICSEARCH_API_KEY"),
+
+		RecommendationsInterval: envDuration("RECOMMENDATIONS_INTERVAL", time.Hour),
+
+		ScheduledJobs:   os.Getenv("SCHEDULED_JOBS"),
+		ScheduleLockTTL: envDuration("SCHEDULE_LOCK_TTL", time.Hour),
+		BackupDir:       envString("BACKUP_DIR", "backups"),
+		BackupKeep:      envInt("BACKUP_KEEP", 7),
+		TrashRetention:  envDuration("TRASH_RETENTION", 30*24*time.Hour),
+
+		LoanPeriod:     envDuration("LOAN_PERIOD", 14*24*time.Hour),
+		MaxRenewals:    envInt("MAX_RENEWALS", 2),
+		FineDailyCents: int64(envInt("FINE_DAILY_CENTS", 25)),
+		FineGrace:      envDuration("FINE_GRACE_PERIOD", 24*time.Hour),
+		FineMaxCents:   int64(envInt("FINE_MAX_CENTS", 1000)),
+		LoanReminder:   envDuration("LOAN_REMINDER_BEFORE", 48*time.Hour),
+
+		Locations: parseLocations(os.Getenv("BOOK_LOCATIONS")),
+
+		FiscalYearStart: envInt("FISCAL_YEAR_START", 1),
+
+		Replication:          envString("REPLICATION", "none"),
+		LitestreamMetricsURL: os.Getenv("LITESTREAM_METRICS_URL"),
+		LiteFSDir:            os.Getenv("LITEFS_DIR"),
+
+		AuthBackend:  envString("AUTH_BACKEND", "none"),
+		AuthSecret:   os.Getenv("AUTH_SECRET"),
+		AuthTokenTTL: envDuration("AUTH_TOKEN_TTL", 12*time.Hour),
+		LDAP: LDAPConfig{
+			URL:                envString("LDAP_URL", "ldap://localhost:389"),
+			StartTLS:           envBool("LDAP_START_TLS", false),
+			InsecureSkipVerify: envBool("LDAP_SKIP_VERIFY", false),
+			BindDN:             os.Getenv("LDAP_BIND_DN"),
+			BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:             os.Getenv("LDAP_BASE_DN"),
+			UserFilter:         envString("LDAP_USER_FILTER", "(uid=%s)"),
+			GroupAttr:          envString("LDAP_GROUP_ATTR", "memberOf"),
+			RoleGroups:         os.Getenv("LDAP_ROLE_GROUPS"),
+			DefaultRole:        envString("LDAP_DEFAULT_ROLE", roleReader),
+			Timeout:            envDuration("LDAP_TIMEOUT", 10*time.Second),
+		},
+		SAML: SAMLConfig{
+			BaseURL:        envString("SAML_BASE_URL", "http://localhost:8080"),
+			EntityID:       os.Getenv("SAML_ENTITY_ID"),
+			IdPMetadataURL: os.Getenv("SAML_IDP_METADATA_URL"),
+			IdPSSOURL:      os.Getenv("SAML_IDP_SSO_URL"),
+			IdPCertFile:    os.Getenv("SAML_IDP_CERT_FILE"),
+			RoleAttribute:  envString("SAML_ROLE_ATTRIBUTE", "groups"),
+			RoleGroups:     os.Getenv("SAML_ROLE_GROUPS"),
+			DefaultRole:    envString("SAML_DEFAULT_ROLE", roleReader),
+			RedirectURL:    envString("SAML_REDIRECT_URL", "http://localhost:3000/books_demo.html"),
+			ClockSkew:      envDuration("SAML_CLOCK_SKEW", 2*time.Minute),
+		},
+
+		SlackWebhookURL:     os.Getenv("SLACK_WEBHOOK_URL"),
+		SlackNotifyEvents:   os.Getenv("SLACK_NOTIFY_EVENTS"),
+		DiscordWebhookURL:   os.Getenv("DISCORD_WEBHOOK_URL"),
+		DiscordNotifyEvents: os.Getenv("DISCORD_NOTIFY_EVENTS"),
+
+		PaymentProvider:   envString("PAYMENT_PROVIDER", "none"),
+		StoreCurrency:     envString("STORE_CURRENCY", "usd"),
+		CheckoutReturnURL: envString("CHECKOUT_RETURN_URL", "http://localhost:3000/books_demo.html"),
+		Stripe: StripeConfig{
+			APIURL:        envString("STRIPE_API_URL", "https://api.stripe.com"),
+			SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+			WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		},
+	}
+}
+
+// Inclusive year bounds used by book validation
+func (c Config) yearRange() (int, int) {
+	max := c.MaxYear
+	if max == 0 {
+		max = time.Now().Year() + 1
+	}
+	return c.MinYear, max
+}
+
+// The month the fiscal year starts in, January when FISCAL_YEAR_START
+// isn't a month
+func (c Config) fiscalYearStart() time.Month {
+	if c.FiscalYearStart < 1 || c.FiscalYearStart > 12 {
+		return time.January
+	}
+	return time.Month(c.FiscalYearStart)
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return n
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return b
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Largest file accepted for upload
+const maxUploadBytes = 5 << 20
+
+// Image types accepted as covers
+var coverContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Blob key of a book's original cover upload
+func coverKey(id uint) string {
+	return fmt.Sprintf("covers/%d/original", id)
+}
+
+// Path the API serves a stored cover from
+func coverPath(id uint) string {
+	return fmt.Sprintf("/api/v1/books/%d/cover", id)
+}
+
+// Load the book named by the {id} route variable, writing an error on failure
+func loadBook(w http.ResponseWriter, r *http.Request) (*Book, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return nil, false
+	}
+
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return nil, false
+	}
+	return &book, true
+}
+
+// Record a stored cover on the book and queue its renditions. The old
+// cover's renditions are removed first, so ?size= serves the new original
+// until new ones exist, even if generating them fails.
+func attachCover(ctx context.Context, book *Book) error {
+	if err := deleteCoverVariants(ctx, book.ID); err != nil {
+		return err
+	}
+	book.CoverKey = coverKey(book.ID)
+	book.CoverURL = coverPath(book.ID)
+	db.Save(book)
+	enqueueCoverThumbnails(book.ID)
+	return nil
+}
+
+// Delete a book's original cover and all renditions
+func deleteCoverBlobs(ctx context.Context, id uint) error {
+	if err := blobStore.Delete(ctx, coverKey(id)); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+	return deleteCoverVariants(ctx, id)
+}
+
+// Delete a book's cover renditions
+func deleteCoverVariants(ctx context.Context, id uint) error {
+	for _, size := range coverSizes {
+		if err := blobStore.Delete(ctx, coverVariantKey(id, size.name)); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upload a cover image in the request body
+func uploadCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadBytes))
+	if err != nil {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "too_large", "Cover image is too large")
+		return
+	}
+
+	// Trust the bytes over the declared type
+	contentType := http.DetectContentType(data)
+	if !coverContentTypes[contentType] {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Cover must be a JPEG, PNG, GIF or WebP image")
+		return
+	}
+
+	if err := blobStore.Put(r.Context(), coverKey(book.ID), bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		log.Printf("Storing cover for book %d failed: %v", book.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to store cover")
+		return
+	}
+
+	if err := attachCover(r.Context(), book); err != nil {
+		log.Printf("Replacing cover renditions for book %d failed: %v", book.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to store cover")
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+// Serve a book's cover, redirecting to external cover URLs. ?size=sm|md|lg
+// selects a rendition, falling back to the original until it is generated.
+func getCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	size := r.URL.Query().Get("size")
+	if size != "" && size != "original" && !validCoverSize(size) {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "size must be one of sm, md, lg or original")
+		return
+	}
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+
+	if book.CoverKey == "" {
+		if book.CoverURL != "" {
+			http.Redirect(w, r, book.CoverURL, http.StatusFound)
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "cover_not_found", "Book has no cover")
+		return
+	}
+
+	key := book.CoverKey
+	if validCoverSize(size) {
+		key = coverVariantKey(book.ID, size)
+	}
+	body, info, err := blobStore.Get(r.Context(), key)
+	if errors.Is(err, ErrBlobNotFound) && key != book.CoverKey {
+		body, info, err = blobStore.Get(r.Context(), book.CoverKey)
+	}
+	if errors.Is(err, ErrBlobNotFound) {
+		writeError(w, r, http.StatusNotFound, "cover_not_found", "Book has no cover")
+		return
+	}
+	if err != nil {
+		log.Printf("Reading cover for book %d failed: %v", book.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read cover")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	io.Copy(w, body)
+}
+
+// Remove a book's stored cover
+func deleteCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+
+	if book.CoverKey != "" {
+		if err := deleteCoverBlobs(r.Context(), book.ID); err != nil {
+			log.Printf("Deleting cover for book %d failed: %v", book.ID, err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete cover")
+			return
+		}
+	}
+
+	book.CoverKey = ""
+	book.CoverURL = ""
+	db.Save(book)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CoverUploadRequest is the body of POST /books/{id}/cover/upload-url
+type CoverUploadRequest struct {
+	ContentType string `json:"content_type"`
+}
+
+// CoverUpload tells the frontend how to upload a cover directly
+type CoverUpload struct {
+	ExpiresAt time.Time         `json:"expires_at"`
+	Headers   map[string]string `json:"headers"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+}
+
+// Issue a presigned URL the frontend can PUT a cover to directly
+func coverUploadURL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+
+	var body CoverUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(body.ContentType)
+	if !coverContentTypes[contentType] {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "content_type must be a JPEG, PNG, GIF or WebP image type")
+		return
+	}
+
+	u, err := blobStore.PresignPut(coverKey(book.ID), contentType, cfg.PresignTTL)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create upload URL")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CoverUpload{
+		URL:       u,
+		Method:    "PUT",
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(cfg.PresignTTL).UTC(),
+	})
+}
+
+// Attach a cover uploaded through a presigned URL
+func completeCoverUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := blobStore.Stat(r.Context(), coverKey(book.ID))
+	if errors.Is(err, ErrBlobNotFound) {
+		writeError(w, r, http.StatusConflict, "upload_missing", "No uploaded cover found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read cover")
+		return
+	}
+	if !coverContentTypes[info.ContentType] || info.Size > maxUploadBytes {
+		blobStore.Delete(r.Context(), info.Key)
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Uploaded cover is not an accepted image")
+		return
+	}
+
+	if err := attachCover(r.Context(), book); err != nil {
+		log.Printf("Replacing cover renditions for book %d failed: %v", book.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to store cover")
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Encode a small solid-colour PNG
+func testPNG(w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{200, 40, 40, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestUploadAndServeCover(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Covered", Author: "Author", ISBN: "1234567890555"})
+
+	img := testPNG(4, 4)
+	req, _ := http.NewRequest("PUT", "/api/v1/books/1/cover", bytes.NewReader(img))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if book.CoverURL != "/api/v1/books/1/cover" {
+		t.Errorf("Expected cover_url to point at the API, got %q", book.CoverURL)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/books/1/cover", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if !bytes.Equal(response.Body.Bytes(), img) || response.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Unexpected cover response %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/v1/books/1/cover", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/books/1/cover", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", response.Code)
+	}
+}
+
+func TestUploadCoverRejectsNonImages(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Covered", Author: "Author", ISBN: "1234567890555"})
+
+	req, _ := http.NewRequest("PUT", "/api/v1/books/1/cover", strings.NewReader("<html>not an image</html>"))
+	req.Header.Set("Content-Type", "image/png")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415, got %d", response.Code)
+	}
+}
+
+func TestExternalCoverRedirects(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Remote", Author: "Author", ISBN: "1234567890556", CoverURL: "https://covers.example/l.jpg"})
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/1/cover", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusFound || response.Header().Get("Location") != "https://covers.example/l.jpg" {
+		t.Errorf("Expected redirect to external cover, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+}
+
+func TestPresignedCoverUpload(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Direct", Author: "Author", ISBN: "1234567890557"})
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/1/cover/upload-url", strings.NewReader(`{"content_type": "image/png"}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var presigned struct {
+		URL     string            `json:"url"`
+		Method  string            `json:"method"`
+		Headers map[string]string `json:"headers"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &presigned)
+
+	// Completing before the upload lands is a conflict
+	req, _ = http.NewRequest("POST", "/api/v1/books/1/cover/complete", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 before upload, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest(presigned.Method, presigned.URL, bytes.NewReader(testPNG(2, 2)))
+	req.Header.Set("Content-Type", presigned.Headers["Content-Type"])
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Direct upload failed: %d %s", response.Code, response.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/books/1/cover/complete", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200 on complete, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var stored Book
+	db.First(&stored, 1)
+	if stored.CoverKey != "covers/1/original" {
+		t.Errorf("Expected cover key to be recorded, got %q", stored.CoverKey)
+	}
+}
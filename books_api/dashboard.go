@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dashboard tuning
+const (
+	// Items in the recent activity feed
+	dashboardActivity = 20
+	// Books in the most borrowed list
+	dashboardTopBooks = 10
+	// Default and largest reader growth window, in days
+	defaultGrowthDays = 30
+	maxGrowthDays     = 365
+	// How far back request error rates look
+	requestStatsWindow = time.Hour
+)
+
+// requestStats counts responses per minute over a sliding window
+type requestStats struct {
+	mu      sync.Mutex
+	buckets map[int64]*statusCounts
+}
+
+type statusCounts struct {
+	Requests     int `json:"requests"`
+	ClientErrors int `json:"client_errors"`
+	ServerErrors int `json:"server_errors"`
+}
+
+// Counters for every response the API writes
+var apiStats = &requestStats{buckets: make(map[int64]*statusCounts)}
+
+func (s *requestStats) Record(status int, at time.Time) {
+	minute := at.Unix() / 60
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.buckets[minute]
+	if c == nil {
+		c = &statusCounts{}
+		s.buckets[minute] = c
+		// Drop buckets that have left the window
+		for m := range s.buckets {
+			if m <= minute-int64(requestStatsWindow/time.Minute) {
+				delete(s.buckets, m)
+			}
+		}
+	}
+	c.Requests++
+	switch {
+	case status >= 500:
+		c.ServerErrors++
+	case status >= 400:
+		c.ClientErrors++
+	}
+}
+
+// Totals for the window ending at now
+func (s *requestStats) Snapshot(now time.Time) statusCounts {
+	since := now.Add(-requestStatsWindow).Unix() / 60
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total statusCounts
+	for m, c := range s.buckets {
+		if m > since {
+			total.Requests += c.Requests
+			total.ClientErrors += c.ClientErrors
+			total.ServerErrors += c.ServerErrors
+		}
+	}
+	return total
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Let http.ResponseController reach the connection, for WebSocket
+// upgrades
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Count API responses by status class for the dashboard
+func requestStatsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		apiStats.Record(rec.status, time.Now())
+	})
+}
+
+// Dashboard is everything the admin home screen shows
+type Dashboard struct {
+	GeneratedAt    time.Time         `json:"generated_at"`
+	Totals         DashboardTotals   `json:"totals"`
+	RecentActivity []ActivityItem    `json:"recent_activity"`
+	TopBorrowed    []BorrowedBook    `json:"top_borrowed"`
+	Errors         DashboardErrors   `json:"errors"`
+	Storage        DashboardStorage  `json:"storage"`
+	ReaderGrowth   []ReaderGrowthDay `json:"reader_growth"`
+}
+
+type DashboardTotals struct {
+	Books        int64 `json:"books"`
+	Reviews      int64 `json:"reviews"`
+	Readers      int64 `json:"readers"`
+	Interactions int64 `json:"interactions"`
+}
+
+// ActivityItem is one entry in the recent activity feed
+type ActivityItem struct {
+	Type    string    `json:"type"`
+	At      time.Time `json:"at"`
+	BookID  uint      `json:"book_id,omitempty"`
+	UserID  uint      `json:"user_id,omitempty"`
+	Summary string    `json:"summary"`
+}
+
+type BorrowedBook struct {
+	BookID uint   `json:"book_id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Loans  int    `json:"loans"`
+}
+
+type DashboardErrors struct {
+	Window string `json:"window"`
+	statusCounts
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type DashboardStorage struct {
+	DatabaseBytes int64 `json:"database_bytes"`
+	Covers        int64 `json:"covers"`
+	// Only known for the local blob store
+	BlobBytes *int64 `json:"blob_bytes"`
+	BlobFiles *int   `json:"blob_files"`
+}
+
+// ReaderGrowthDay counts readers first seen on a day
+type ReaderGrowthDay struct {
+	Date       string `json:"date"`
+	NewReaders int    `json:"new_readers"`
+	Total      int    `json:"total"`
+}
+
+// Aggregate data for the admin home screen
+func getDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	days := defaultGrowthDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxGrowthDays {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "days must be between 1 and 365")
+			return
+		}
+		days = n
+	}
+
+	now := time.Now()
+	d := Dashboard{GeneratedAt: now.UTC()}
+
+	db.Model(&Book{}).Count(&d.Totals.Books)
+	db.Model(&Review{}).Count(&d.Totals.Reviews)
+	db.Model(&Interaction{}).Count(&d.Totals.Interactions)
+	db.Model(&Interaction{}).Distinct("user_id").Count(&d.Totals.Readers)
+
+	d.RecentActivity = recentActivity(dashboardActivity)
+
+	db.Model(&Interaction{}).
+		Select("books.id AS book_id, books.title, books.author, COUNT(*) AS loans").
+		Joins("JOIN books ON books.id = interactions.book_id").
+		Where("interactions.kind = ? AND books.deleted_at IS NULL", "loan").
+		Group("books.id").Order("loans DESC, books.id").
+		Limit(dashboardTopBooks).Scan(&d.TopBorrowed)
+	d.TopBorrowed = listOf(d.TopBorrowed)
+
+	counts := apiStats.Snapshot(now)
+	d.Errors = DashboardErrors{Window: requestStatsWindow.String(), statusCounts: counts}
+	if counts.Requests > 0 {
+		d.Errors.ErrorRate = float64(counts.ClientErrors+counts.ServerErrors) / float64(counts.Requests)
+	}
+
+	d.Storage = storageUsage()
+	d.ReaderGrowth = readerGrowth(now, days)
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+// Latest interactions, reviews and refresh jobs, newest first
+func recentActivity(limit int) []ActivityItem {
+	var items []ActivityItem
+
+	var interactions []Interaction
+	db.Order("created_at DESC, id DESC").Limit(limit).Find(&interactions)
+	for _, in := range interactions {
+		items = append(items, ActivityItem{
+			Type: "interaction." + in.Kind, At: in.CreatedAt, BookID: in.BookID, UserID: in.UserID,
+			Summary: fmt.Sprintf("Reader %d %s book %d", in.UserID, interactionVerb(in.Kind), in.BookID),
+		})
+	}
+
+	var reviews []Review
+	db.Order("created_at DESC, id DESC").Limit(limit).Find(&reviews)
+	for _, rv := range reviews {
+		items = append(items, ActivityItem{
+			Type: "review", At: rv.CreatedAt, BookID: rv.BookID,
+			Summary: fmt.Sprintf("Review of book %d rated %g", rv.BookID, rv.Rating),
+		})
+	}
+
+	var refreshes []RefreshJob
+	db.Order("created_at DESC, id DESC").Limit(limit).Find(&refreshes)
+	for _, j := range refreshes {
+		items = append(items, ActivityItem{
+			Type: "metadata_refresh", At: j.CreatedAt,
+			Summary: fmt.Sprintf("Metadata refresh %d %s", j.ID, j.Status),
+		})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].At.After(items[j].At) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return listOf(items)
+}
+
+func interactionVerb(kind string) string {
+	switch kind {
+	case "loan":
+		return "borrowed"
+	case "shelf":
+		return "shelved"
+	case "favorite":
+		return "favorited"
+	}
+	return kind
+}
+
+// Database size, covers stored and, for the local store, blob usage
+func storageUsage() DashboardStorage {
+	var s DashboardStorage
+	var pages, pageSize int64
+	db.Raw("PRAGMA page_count").Scan(&pages)
+	db.Raw("PRAGMA page_size").Scan(&pageSize)
+	s.DatabaseBytes = pages * pageSize
+	db.Model(&Book{}).Where("cover_key <> ''").Count(&s.Covers)
+
+	if local, ok := blobStore.(*localBlobStore); ok {
+		var bytes int64
+		var files int
+		filepath.WalkDir(local.root, func(path string, e fs.DirEntry, err error) error {
+			if err != nil || e.IsDir() {
+				return nil
+			}
+			if info, err := e.Info(); err == nil {
+				bytes += info.Size()
+				files++
+			}
+			return nil
+		})
+		if _, err := os.Stat(local.root); err == nil {
+			s.BlobBytes, s.BlobFiles = &bytes, &files
+		}
+	}
+	return s
+}
+
+// New readers per day over the last days, by their first interaction
+func readerGrowth(now time.Time, days int) []ReaderGrowthDay {
+	start := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+
+	var rows []Interaction
+	db.Select("user_id, created_at").Find(&rows)
+	firstSeen := make(map[uint]time.Time)
+	for _, in := range rows {
+		if t, ok := firstSeen[in.UserID]; !ok || in.CreatedAt.Before(t) {
+			firstSeen[in.UserID] = in.CreatedAt
+		}
+	}
+
+	total := 0
+	perDay := make(map[string]int)
+	for _, t := range firstSeen {
+		if t.Before(start) {
+			total++
+			continue
+		}
+		perDay[t.UTC().Format("2006-01-02")]++
+	}
+
+	growth := make([]ReaderGrowthDay, 0, days)
+	for i := 0; i < days; i++ {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		total += perDay[date]
+		growth = append(growth, ReaderGrowthDay{Date: date, NewReaders: perDay[date], Total: total})
+	}
+	return growth
+}
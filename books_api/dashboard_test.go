@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+	} {
+		db.Create(&b)
+	}
+	for _, in := range []Interaction{
+		{UserID: 1, BookID: 2, Kind: "loan"},
+		{UserID: 2, BookID: 2, Kind: "loan"},
+		{UserID: 2, BookID: 1, Kind: "loan"},
+		{UserID: 3, BookID: 1, Kind: "favorite", CreatedAt: time.Now().AddDate(0, 0, -60)},
+	} {
+		db.Create(&in)
+	}
+	db.Create(&Review{BookID: 1, Rating: 4.5})
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/dashboard?days=7", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var d Dashboard
+	json.Unmarshal(response.Body.Bytes(), &d)
+	if d.Totals.Books != 2 || d.Totals.Readers != 3 || d.Totals.Reviews != 1 {
+		t.Errorf("Unexpected totals %+v", d.Totals)
+	}
+	if len(d.TopBorrowed) != 2 || d.TopBorrowed[0].Title != "Emma" || d.TopBorrowed[0].Loans != 2 {
+		t.Errorf("Unexpected top borrowed %+v", d.TopBorrowed)
+	}
+	if len(d.RecentActivity) != 5 || d.RecentActivity[len(d.RecentActivity)-1].UserID != 3 {
+		t.Errorf("Expected activity newest first ending with the old favorite, got %+v", d.RecentActivity)
+	}
+	if d.Storage.DatabaseBytes == 0 {
+		t.Error("Expected the database size to be reported")
+	}
+
+	// Reader 3 predates the window and is counted in the starting total
+	if len(d.ReaderGrowth) != 7 {
+		t.Fatalf("Expected 7 days of growth, got %d", len(d.ReaderGrowth))
+	}
+	if last := d.ReaderGrowth[6]; last.NewReaders != 2 || last.Total != 3 {
+		t.Errorf("Unexpected growth for today %+v", last)
+	}
+}
+
+func TestDashboardErrorRate(t *testing.T) {
+	stats := &requestStats{buckets: make(map[int64]*statusCounts)}
+	now := time.Now()
+	stats.Record(http.StatusOK, now)
+	stats.Record(http.StatusNotFound, now)
+	stats.Record(http.StatusBadGateway, now.Add(-time.Minute))
+	stats.Record(http.StatusInternalServerError, now.Add(-2*time.Hour))
+
+	c := stats.Snapshot(now)
+	if c.Requests != 3 || c.ClientErrors != 1 || c.ServerErrors != 1 {
+		t.Errorf("Unexpected counts %+v", c)
+	}
+}
+
+func TestDashboardInvalidDays(t *testing.T) {
+	router := setupRouter()
+	req, _ := http.NewRequest("GET", "/api/v1/admin/dashboard?days=0", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Donations. A donor gives books in donations, batches received together,
+// and each donated book's acquisition names the donation it came in. Once
+// a donation's thank-you letter is sent, it is marked acknowledged; the
+// acknowledgment export has what the letters for the others need.
+
+// Donor is a person or organization that gives the library books
+type Donor struct {
+	ID    uint   `json:"id" gorm:"primaryKey"`
+	Name  string `json:"name" gorm:"not null;index"`
+	Email string `json:"email,omitempty"`
+	// Postal address for acknowledgment letters, lines separated by \n
+	Address   string    `json:"address,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DonorRequest is the body of POST and PUT /donors
+type DonorRequest struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Address string `json:"address"`
+}
+
+// Donation is a batch of books a donor gave at once
+type Donation struct {
+	ID      uint `json:"id" gorm:"primaryKey"`
+	DonorID uint `json:"donor_id" gorm:"not null;index"`
+	// The date the books arrived, as YYYY-MM-DD
+	ReceivedOn     string     `json:"received_on" gorm:"not null"`
+	Notes          string     `json:"notes,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty" gorm:"index"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// Books whose acquisitions name the donation
+	BookCount int64 `json:"book_count" gorm:"->;-:migration"`
+}
+
+// DonationRequest is the body of POST and PUT /donations
+type DonationRequest struct {
+	DonorID    uint   `json:"donor_id"`
+	ReceivedOn string `json:"received_on"`
+	Notes      string `json:"notes"`
+}
+
+// Acknowledgment is what a donation's thank-you letter needs
+type Acknowledgment struct {
+	DonationID uint               `json:"donation_id"`
+	DonorID    uint               `json:"donor_id"`
+	Name       string             `json:"name"`
+	Email      string             `json:"email,omitempty"`
+	Address    string             `json:"address,omitempty"`
+	ReceivedOn string             `json:"received_on"`
+	Books      []AcknowledgedBook `json:"books" gorm:"-"`
+}
+
+// AcknowledgedBook is a book thanked for in an acknowledgment letter
+type AcknowledgedBook struct {
+	BookID uint   `json:"book_id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	ISBN   string `json:"isbn"`
+}
+
+var acknowledgmentCSVHeader = []string{"donation_id", "donor_id", "name", "email", "address", "received_on", "book_count", "books"}
+
+// List the donors by name
+func getDonors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var donors []Donor
+	if err := db.Order("name, id").Find(&donors).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list donors")
+		return
+	}
+	writeList(w, r, donors)
+}
+
+func validateDonor(req *DonorRequest) []FieldError {
+	var errs []FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" {
+		if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+			errs = append(errs, FieldError{Field: "email", Message: "must be an email address"})
+		}
+	}
+	req.Address = strings.TrimSpace(req.Address)
+	return errs
+}
+
+// Add a donor
+func createDonor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req DonorRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateDonor(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	donor := Donor{Name: req.Name, Email: req.Email, Address: req.Address}
+	if err := db.Create(&donor).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create donor")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(donor.ID), 10))
+	writeJSON(w, http.StatusCreated, donor)
+}
+
+// Load the donor named in the URL
+func loadDonor(w http.ResponseWriter, r *http.Request) (*Donor, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_donor_id", "Invalid donor ID")
+		return nil, false
+	}
+	var donor Donor
+	if err := db.First(&donor, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "donor_not_found", "Donor not found")
+		return nil, false
+	}
+	return &donor, true
+}
+
+// Get a donor by ID
+func getDonor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if donor, ok := loadDonor(w, r); ok {
+		writeJSON(w, http.StatusOK, donor)
+	}
+}
+
+// Replace a donor's details
+func updateDonor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donor, ok := loadDonor(w, r)
+	if !ok {
+		return
+	}
+	var req DonorRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateDonor(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	donor.Name, donor.Email, donor.Address = req.Name, req.Email, req.Address
+	if err := db.Select("Name", "Email", "Address", "UpdatedAt").Updates(donor).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update donor")
+		return
+	}
+	writeJSON(w, http.StatusOK, donor)
+}
+
+// Returned when a donor or donation is still referred to
+var (
+	errDonorHasDonations = errors.New("donor has donations")
+	errDonationHasBooks  = errors.New("donation has books")
+)
+
+// Delete a donor with no donations recorded
+func deleteDonor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donor, ok := loadDonor(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Donation{}).Where("donor_id = ?", donor.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errDonorHasDonations
+		}
+		return tx.Delete(&Donor{}, donor.ID).Error
+	})
+	switch {
+	case errors.Is(err, errDonorHasDonations):
+		writeError(w, r, http.StatusConflict, "donor_has_donations", "The donor still has donations")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete donor")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// The books a donor gave, in the request's library, by title
+func getDonorBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donor, ok := loadDonor(w, r)
+	if !ok {
+		return
+	}
+	donations := db.Model(&Donation{}).Select("id").Where("donor_id = ?", donor.ID)
+	var books []Book
+	err := db.WithContext(r.Context()).Preload("Tags").
+		Where("id IN (?)", db.Model(&Acquisition{}).Select("book_id").Where("donation_id IN (?)", donations)).
+		Order("title, id").Find(&books).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list books")
+		return
+	}
+	writeList(w, r, books)
+}
+
+// Donations with the number of books each brought into the context's
+// library
+func donationsWithCounts(ctx context.Context) *gorm.DB {
+	return db.Model(&Donation{}).
+		Select("donations.*, COUNT(acquisitions.id) AS book_count").
+		Joins("LEFT JOIN acquisitions ON acquisitions.donation_id = donations.id AND acquisitions.book_id IN (?)", libraryBookIDs(ctx)).
+		Group("donations.id")
+}
+
+// List donations, most recent first. ?donor_id= narrows the list to one
+// donor's, and ?acknowledged=false to those still to be thanked.
+func getDonations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := donationsWithCounts(r.Context())
+	q := r.URL.Query()
+	if v := q.Get("donor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_donor_id", "Invalid donor ID")
+			return
+		}
+		query = query.Where("donations.donor_id = ?", id)
+	}
+	if v := q.Get("acknowledged"); v != "" {
+		acknowledged, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "acknowledged must be true or false")
+			return
+		}
+		if acknowledged {
+			query = query.Where("donations.acknowledged_at IS NOT NULL")
+		} else {
+			query = query.Where("donations.acknowledged_at IS NULL")
+		}
+	}
+	var donations []Donation
+	if err := query.Order("donations.received_on DESC, donations.id DESC").Scan(&donations).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list donations")
+		return
+	}
+	writeList(w, r, donations)
+}
+
+func validateDonation(req *DonationRequest) []FieldError {
+	var errs []FieldError
+	if req.DonorID == 0 {
+		errs = append(errs, FieldError{Field: "donor_id", Message: "is required"})
+	} else if err := db.First(&Donor{}, req.DonorID).Error; err != nil {
+		errs = append(errs, FieldError{Field: "donor_id", Message: "is not a donor"})
+	}
+	// Today when not given
+	req.ReceivedOn = strings.TrimSpace(req.ReceivedOn)
+	if req.ReceivedOn == "" {
+		req.ReceivedOn = time.Now().UTC().Format(time.DateOnly)
+	} else if _, err := time.Parse(time.DateOnly, req.ReceivedOn); err != nil {
+		errs = append(errs, FieldError{Field: "received_on", Message: "must be a date, such as 2026-03-14"})
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	return errs
+}
+
+// Record a donation
+func createDonation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req DonationRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateDonation(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	donation := Donation{DonorID: req.DonorID, ReceivedOn: req.ReceivedOn, Notes: req.Notes}
+	if err := db.Create(&donation).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create donation")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(donation.ID), 10))
+	writeJSON(w, http.StatusCreated, donation)
+}
+
+// Load the donation named in the URL, with its book count
+func loadDonation(w http.ResponseWriter, r *http.Request) (*Donation, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_donation_id", "Invalid donation ID")
+		return nil, false
+	}
+	var donation Donation
+	if err := donationsWithCounts(r.Context()).Where("donations.id = ?", id).Take(&donation).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "donation_not_found", "Donation not found")
+		return nil, false
+	}
+	return &donation, true
+}
+
+// Get a donation by ID
+func getDonation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if donation, ok := loadDonation(w, r); ok {
+		writeJSON(w, http.StatusOK, donation)
+	}
+}
+
+// Replace a donation's donor, date and notes
+func updateDonation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donation, ok := loadDonation(w, r)
+	if !ok {
+		return
+	}
+	var req DonationRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateDonation(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	donation.DonorID, donation.ReceivedOn, donation.Notes = req.DonorID, req.ReceivedOn, req.Notes
+	if err := db.Select("DonorID", "ReceivedOn", "Notes", "UpdatedAt").Updates(donation).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update donation")
+		return
+	}
+	writeJSON(w, http.StatusOK, donation)
+}
+
+// Delete a donation no book's acquisition names
+func deleteDonation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donation, ok := loadDonation(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Counted across every library, not just the request's
+		var count int64
+		if err := tx.Model(&Acquisition{}).Where("donation_id = ?", donation.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errDonationHasBooks
+		}
+		return tx.Delete(&Donation{}, donation.ID).Error
+	})
+	switch {
+	case errors.Is(err, errDonationHasBooks):
+		writeError(w, r, http.StatusConflict, "donation_has_books", "Books were acquired in the donation")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete donation")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Mark a donation's thank-you letter sent, so the acknowledgment export
+// leaves it out
+func acknowledgeDonation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	donation, ok := loadDonation(w, r)
+	if !ok {
+		return
+	}
+	if donation.AcknowledgedAt == nil {
+		now := time.Now().UTC()
+		if err := db.Model(&Donation{}).Where("id = ?", donation.ID).Update("acknowledged_at", now).Error; err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to acknowledge donation")
+			return
+		}
+		donation.AcknowledgedAt = &now
+	}
+	writeJSON(w, http.StatusOK, donation)
+}
+
+// The letter data for donations not yet acknowledged, oldest first: as
+// JSON, or as CSV for a mail merge when the request accepts text/csv
+func getAcknowledgments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	letters, err := pendingAcknowledgments(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to export acknowledgments")
+		return
+	}
+	if negotiateFormat(r.Header.Get("Accept")) != csvType {
+		w.Header().Set("Content-Type", "application/json")
+		writeList(w, r, letters)
+		return
+	}
+
+	w.Header().Set("Content-Type", csvType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="acknowledgments.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(acknowledgmentCSVHeader)
+	for _, l := range letters {
+		books := make([]string, len(l.Books))
+		for i, b := range l.Books {
+			books[i] = b.Title + " by " + b.Author
+		}
+		out.Write([]string{
+			strconv.FormatUint(uint64(l.DonationID), 10),
+			strconv.FormatUint(uint64(l.DonorID), 10),
+			csvText(l.Name),
+			csvText(l.Email),
+			csvText(l.Address),
+			l.ReceivedOn,
+			strconv.Itoa(len(l.Books)),
+			csvText(strings.Join(books, "; ")),
+		})
+	}
+	out.Flush()
+}
+
+// Acknowledgments for the donations not yet acknowledged, each with the
+// books it brought into the context's library
+func pendingAcknowledgments(ctx context.Context) ([]Acknowledgment, error) {
+	var letters []Acknowledgment
+	err := db.Model(&Donation{}).
+		Select("donations.id AS donation_id, donors.id AS donor_id, donors.name, donors.email, donors.address, donations.received_on").
+		Joins("JOIN donors ON donors.id = donations.donor_id").
+		Where("donations.acknowledged_at IS NULL").
+		Order("donations.received_on, donations.id").
+		Scan(&letters).Error
+	if err != nil || len(letters) == 0 {
+		return listOf(letters), err
+	}
+
+	ids := make([]uint, len(letters))
+	for i, l := range letters {
+		ids[i] = l.DonationID
+	}
+	var rows []struct {
+		DonationID uint
+		AcknowledgedBook
+	}
+	err = db.WithContext(ctx).Model(&Book{}).
+		Select("acquisitions.donation_id, books.id AS book_id, books.title, books.author, books.isbn").
+		Joins("JOIN acquisitions ON acquisitions.book_id = books.id").
+		Where("acquisitions.donation_id IN ?", ids).
+		Order("books.title, books.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	byDonation := map[uint][]AcknowledgedBook{}
+	for _, row := range rows {
+		byDonation[row.DonationID] = append(byDonation[row.DonationID], row.AcknowledgedBook)
+	}
+	for i := range letters {
+		letters[i].Books = listOf(byDonation[letters[i].DonationID])
+	}
+	return letters, nil
+}
+
+// Check the donation an acquisition names, if any. A book given in a
+// donation was donated, on the day the donation arrived unless the
+// request says otherwise.
+func applyDonation(req *AcquisitionRequest) ([]FieldError, error) {
+	if req.DonationID == nil {
+		return nil, nil
+	}
+	var donation Donation
+	err := db.Limit(1).Find(&donation, *req.DonationID).Error
+	switch {
+	case err != nil:
+		return nil, err
+	case donation.ID == 0:
+		return []FieldError{{Field: "donation_id", Message: "is not a donation"}}, nil
+	}
+	if strings.TrimSpace(req.Method) == "" {
+		req.Method = acquiredDonation
+	}
+	if strings.TrimSpace(req.AcquiredOn) == "" {
+		req.AcquiredOn = donation.ReceivedOn
+	}
+	return nil, nil
+}
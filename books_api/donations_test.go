@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDonorCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	if response := webhookRequest(t, router, "POST", "/api/v1/donors", `{"name":" ","email":"nope"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+	response := webhookRequest(t, router, "POST", "/api/v1/donors", `{"name":"Ada Smith","email":"ada@example.com","address":"1 Main St\nSpringfield"}`)
+	var donor Donor
+	json.Unmarshal(response.Body.Bytes(), &donor)
+	if response.Code != http.StatusCreated || donor.Address != "1 Main St\nSpringfield" {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	path := fmt.Sprintf("/api/v1/donors/%d", donor.ID)
+	response = webhookRequest(t, router, "PUT", path, `{"name":"Ada Smith-Jones"}`)
+	donor = Donor{}
+	json.Unmarshal(response.Body.Bytes(), &donor)
+	if response.Code != http.StatusOK || donor.Name != "Ada Smith-Jones" || donor.Email != "" {
+		t.Errorf("Expected the donor replaced, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "POST", "/api/v1/donations", fmt.Sprintf(`{"donor_id":%d,"received_on":"2026-03-14"}`, donor.ID))
+	var donation Donation
+	json.Unmarshal(response.Body.Bytes(), &donation)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 deleting a donor with donations, got %d", response.Code)
+	}
+	webhookRequest(t, router, "DELETE", fmt.Sprintf("/api/v1/donations/%d", donation.ID), "")
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+}
+
+func TestDonatedBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	donor := Donor{Name: "Ada Smith", Address: "1 Main St"}
+	db.Create(&donor)
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	bought := Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"}
+	for _, b := range []*Book{&dune, &emma, &bought} {
+		db.Create(b)
+	}
+
+	response := webhookRequest(t, router, "POST", "/api/v1/donations", fmt.Sprintf(`{"donor_id":%d,"received_on":"2026-03-14","notes":"Two boxes"}`, donor.ID))
+	var donation Donation
+	json.Unmarshal(response.Body.Bytes(), &donation)
+	if response := webhookRequest(t, router, "POST", "/api/v1/donations", `{"donor_id":999}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown donor, got %d", response.Code)
+	}
+
+	// A donation's books take its method and date
+	for _, b := range []Book{emma, dune} {
+		response := webhookRequest(t, router, "POST", "/api/v1/acquisitions", fmt.Sprintf(`{"book_id":%d,"donation_id":%d}`, b.ID, donation.ID))
+		var acquisition Acquisition
+		json.Unmarshal(response.Body.Bytes(), &acquisition)
+		if response.Code != http.StatusCreated || acquisition.Method != acquiredDonation || acquisition.AcquiredOn != "2026-03-14" {
+			t.Fatalf("Expected a donation acquisition, got %d: %s", response.Code, response.Body.String())
+		}
+	}
+	for _, body := range []string{
+		fmt.Sprintf(`{"book_id":%d,"donation_id":999}`, bought.ID),
+		fmt.Sprintf(`{"book_id":%d,"vendor":"Ingram","method":"purchase","donation_id":%d}`, bought.ID, donation.ID),
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/acquisitions", body); response.Code != http.StatusBadRequest ||
+			!strings.Contains(response.Body.String(), "donation_id") {
+			t.Errorf("Expected a donation_id error for %s, got %d: %s", body, response.Code, response.Body.String())
+		}
+	}
+	webhookRequest(t, router, "POST", "/api/v1/acquisitions", fmt.Sprintf(`{"book_id":%d,"vendor":"Ingram"}`, bought.ID))
+
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/donors/%d/books", donor.ID), "")
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := strings.Join(bookTitles(books), ","); got != "Dune,Emma" {
+		t.Errorf("Expected the donated books, got %q", got)
+	}
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/donations/%d", donation.ID), "")
+	json.Unmarshal(response.Body.Bytes(), &donation)
+	if donation.BookCount != 2 {
+		t.Errorf("Expected 2 books in the donation, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", fmt.Sprintf("/api/v1/donations/%d", donation.ID), ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 deleting a donation with books, got %d", response.Code)
+	}
+}
+
+func TestDonationAcknowledgments(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	donor := Donor{Name: "=Ada Smith", Email: "ada@example.com", Address: "1 Main St"}
+	db.Create(&donor)
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	first := Donation{DonorID: donor.ID, ReceivedOn: "2026-03-14"}
+	second := Donation{DonorID: donor.ID, ReceivedOn: "2026-04-01"}
+	db.Create(&first)
+	db.Create(&second)
+	db.Create(&Acquisition{BookID: dune.ID, Method: acquiredDonation, DonationID: &first.ID, AcquiredOn: "2026-03-14"})
+
+	response := webhookRequest(t, router, "GET", "/api/v1/donations/acknowledgments", "")
+	var letters []Acknowledgment
+	json.Unmarshal(response.Body.Bytes(), &letters)
+	if len(letters) != 2 || letters[0].DonationID != first.ID || len(letters[0].Books) != 1 || letters[0].Books[0].Title != "Dune" ||
+		letters[0].Address != "1 Main St" || len(letters[1].Books) != 0 {
+		t.Fatalf("Unexpected acknowledgments %s", response.Body.String())
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/donations/acknowledgments", nil)
+	req.Header.Set("Accept", "text/csv")
+	csvResponse := httptest.NewRecorder()
+	router.ServeHTTP(csvResponse, req)
+	records, err := csv.NewReader(csvResponse.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected a header and two rows, got %v: %v", records, err)
+	}
+	if row := records[1]; row[2] != "'=Ada Smith" || row[5] != "2026-03-14" || row[6] != "1" || row[7] != "Dune by Frank Herbert" {
+		t.Errorf("Unexpected row %q", row)
+	}
+
+	response = webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/donations/%d/acknowledge", first.ID), "")
+	var acknowledged Donation
+	json.Unmarshal(response.Body.Bytes(), &acknowledged)
+	if response.Code != http.StatusOK || acknowledged.AcknowledgedAt == nil {
+		t.Errorf("Expected the donation acknowledged, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", "/api/v1/donations/acknowledgments", "")
+	letters = nil
+	json.Unmarshal(response.Body.Bytes(), &letters)
+	if len(letters) != 1 || letters[0].DonationID != second.ID {
+		t.Errorf("Expected only the second donation left, got %s", response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", "/api/v1/donations?acknowledged=true", "")
+	var donations []Donation
+	json.Unmarshal(response.Body.Bytes(), &donations)
+	if len(donations) != 1 || donations[0].ID != first.ID || donations[0].BookCount != 1 {
+		t.Errorf("Expected the acknowledged donation, got %s", response.Body.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportDatabase(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	old := exportBatch
+	exportBatch = 2
+	defer func() { exportBatch = old }()
+
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Tags: []Tag{{Name: "sf"}}},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+		{Title: "Beloved", Author: "Toni Morrison", ISBN: "9781400033416"},
+	} {
+		db.Create(&b)
+	}
+	db.Create(&Review{BookID: 1, Rating: 5, Body: "Spice"})
+	db.Create(&Order{Status: orderPending, Username: "ann", Currency: "usd", Items: []OrderItem{{BookID: 1, Title: "Dune", Quantity: 1}}})
+
+	req, _ := http.NewRequest("GET", "/api/v1/export", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != ndjsonType || response.Header().Get("X-Export-Schema-Version") != "1" {
+		t.Fatalf("Unexpected response %d %v", response.Code, response.Header())
+	}
+
+	var types []string
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(response.Body.String()))
+	for scanner.Scan() {
+		var rec struct {
+			Type string                 `json:"type"`
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		types = append(types, rec.Type)
+		records = append(records, rec.Data)
+	}
+	if got := strings.Join(types, ","); got != "export,book,book,book,review,order" {
+		t.Fatalf("Unexpected records %s", got)
+	}
+	if records[0]["schema_version"] != float64(exportSchemaVersion) {
+		t.Errorf("Expected the schema version first, got %v", records[0])
+	}
+	if records[1]["title"] != "Dune" || records[1]["created_at"] == nil || len(records[1]["tags"].([]interface{})) != 1 {
+		t.Errorf("Expected the book with its tags and creation time, got %v", records[1])
+	}
+	if records[5]["username"] != "ann" || len(records[5]["items"].([]interface{})) != 1 {
+		t.Errorf("Expected the order with its customer and items, got %v", records[5])
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/export?format=json", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var array []ExportRecord
+	if err := json.Unmarshal(response.Body.Bytes(), &array); err != nil || len(array) != 6 || array[5].Type != "order" {
+		t.Errorf("Expected a JSON array of six records, got %v: %s", err, response.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/export?format=xml", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", response.Code)
+	}
+}
+
+func TestExportDatabaseNeedsAdmin(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"lib:pw": {Username: "lib", Roles: []string{roleLibrarian}}})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/export", nil)
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, router, "lib", "pw"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a librarian, got %d", response.Code)
+	}
+}
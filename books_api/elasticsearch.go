@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint name for the search indexer's position in the outbox
+const searchCheckpoint = "search-index"
+
+// Active search index, nil when searching SQL
+var searchIndex *esIndex
+
+func initSearchIndex() {
+	switch cfg.SearchBackend {
+	case "elasticsearch", "opensearch":
+		searchIndex = newESIndex(cfg.ElasticsearchURL, cfg.ElasticsearchIndex, cfg.ElasticsearchAPIKey)
+	case "", "sql":
+		searchIndex = nil
+	default:
+		log.Fatalf("Unknown SEARCH_BACKEND %q", cfg.SearchBackend)
+	}
+}
+
+// Start keeping the index in sync, backfilling it first if it is new
+func startSearchIndexer(ctx context.Context) {
+	created, err := searchIndex.EnsureIndex(ctx)
+	if err != nil {
+		log.Printf("Creating search index failed, searches will fail until it exists: %v", err)
+	}
+	if created {
+		searchIndex.reindexing.Lock()
+		go func() {
+			defer searchIndex.reindexing.Unlock()
+			n, err := searchIndex.Backfill(ctx)
+			if err != nil {
+				log.Printf("Search backfill failed after %d books: %v", n, err)
+				return
+			}
+			log.Printf("Search backfill finished, %d books indexed", n)
+		}()
+	}
+	go searchIndex.Run(ctx)
+}
+
+// esIndex mirrors books into an Elasticsearch or OpenSearch index through
+// the REST API
+type esIndex struct {
+	baseURL string
+	index   string
+	apiKey  string
+	client  *http.Client
+
+	// Held while a backfill runs
+	reindexing sync.Mutex
+}
+
+func newESIndex(baseURL, index, apiKey string) *esIndex {
+	return &esIndex{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		index:   index,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Indexed form of a book
+type esBook struct {
+	ID          uint     `json:"id"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	ISBN        string   `json:"isbn"`
+	Year        int      `json:"year,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func newESBook(b *Book) esBook {
+	doc := esBook{ID: b.ID, Title: b.Title, Author: b.Author, ISBN: b.ISBN, Year: b.Year, Description: b.Description}
+	for _, t := range b.Tags {
+		doc.Tags = append(doc.Tags, t.Name)
+	}
+	return doc
+}
+
+// One action in a bulk request. A nil doc deletes.
+type esOp struct {
+	id  uint
+	doc *esBook
+}
+
+var esMappings = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"id":          map[string]string{"type": "long"},
+			"title":       map[string]string{"type": "text"},
+			"author":      map[string]string{"type": "text"},
+			"isbn":        map[string]string{"type": "keyword"},
+			"year":        map[string]string{"type": "integer"},
+			"description": map[string]string{"type": "text"},
+			"tags":        map[string]string{"type": "keyword"},
+		},
+	},
+}
+
+func (ix *esIndex) do(ctx context.Context, method, path, contentType string, body []byte, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, ix.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ix.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+ix.apiKey)
+	}
+
+	resp, err := ix.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// Create the index if it doesn't exist. Reports whether it was created.
+func (ix *esIndex) EnsureIndex(ctx context.Context) (bool, error) {
+	status, err := ix.do(ctx, "HEAD", "/"+ix.index, "", nil, nil)
+	if status == http.StatusOK {
+		return false, nil
+	}
+	if status != http.StatusNotFound {
+		return false, err
+	}
+	body, _ := json.Marshal(esMappings)
+	if _, err := ix.do(ctx, "PUT", "/"+ix.index, "application/json", body, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Apply index and delete actions in one request
+func (ix *esIndex) Bulk(ctx context.Context, ops []esOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, op := range ops {
+		meta := map[string]string{"_index": ix.index, "_id": strconv.FormatUint(uint64(op.id), 10)}
+		if op.doc == nil {
+			enc.Encode(map[string]interface{}{"delete": meta})
+			continue
+		}
+		enc.Encode(map[string]interface{}{"index": meta})
+		enc.Encode(op.doc)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if _, err := ix.do(ctx, "POST", "/_bulk", "application/x-ndjson", body.Bytes(), &result); err != nil {
+		return err
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			for action, r := range item {
+				if len(r.Error) > 0 {
+					return fmt.Errorf("bulk %s of %s failed: %s", action, r.ID, r.Error)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Return the IDs of matching books, best match first
+func (ix *esIndex) Search(ctx context.Context, q string, limit, offset int) ([]uint, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"from":    offset,
+		"size":    limit,
+		"_source": false,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     q,
+				"fields":    []string{"title^3", "author^2", "isbn", "tags", "description"},
+				"fuzziness": "AUTO",
+			},
+		},
+	})
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if _, err := ix.do(ctx, "POST", "/"+ix.index+"/_search", "application/json", body, &result); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		if id, err := strconv.ParseUint(hit.ID, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids, nil
+}
+
+// Index every book in the database. Events recorded while the backfill
+// runs are applied afterwards by Sync, so nothing is missed.
+func (ix *esIndex) Backfill(ctx context.Context) (int, error) {
+	var position uint
+	db.Model(&OutboxEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&position)
+
+	indexed := 0
+	var lastID uint
+	for {
+		var books []Book
+		if err := db.Preload("Tags").Where("id > ?", lastID).Order("id").Limit(500).Find(&books).Error; err != nil {
+			return indexed, err
+		}
+		if len(books) == 0 {
+			break
+		}
+		ops := make([]esOp, len(books))
+		for i := range books {
+			doc := newESBook(&books[i])
+			ops[i] = esOp{id: books[i].ID, doc: &doc}
+		}
+		if err := ix.Bulk(ctx, ops); err != nil {
+			return indexed, err
+		}
+		indexed += len(books)
+		lastID = books[len(books)-1].ID
+	}
+
+	if loadCheckpoint(searchCheckpoint) < position {
+		return indexed, saveCheckpoint(db, searchCheckpoint, position)
+	}
+	return indexed, nil
+}
+
+// Apply book events recorded since the last checkpoint. Returns how many
+// events were consumed.
+func (ix *esIndex) Sync(ctx context.Context, batch int) (int, error) {
+	position := loadCheckpoint(searchCheckpoint)
+	var events []OutboxEvent
+	if err := db.Where("id > ?", position).Order("id").Limit(batch).Find(&events).Error; err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	var ops []esOp
+	for _, e := range events {
+		if !strings.HasPrefix(e.Type, "book.") {
+			continue
+		}
+		var book Book
+		if err := json.Unmarshal([]byte(e.Payload), &book); err != nil {
+			log.Printf("Skipping unreadable event %d: %v", e.ID, err)
+			continue
+		}
+		if e.Type == eventBookDeleted {
+			ops = append(ops, esOp{id: book.ID})
+			continue
+		}
+		// Payloads don't always carry tags, so index the current row
+		var current Book
+		if err := db.Preload("Tags").First(&current, book.ID).Error; err != nil {
+			continue
+		}
+		doc := newESBook(&current)
+		ops = append(ops, esOp{id: current.ID, doc: &doc})
+	}
+
+	if err := ix.Bulk(ctx, ops); err != nil {
+		return 0, err
+	}
+	return len(events), saveCheckpoint(db, searchCheckpoint, events[len(events)-1].ID)
+}
+
+// Follow the outbox until ctx is cancelled
+func (ix *esIndex) Run(ctx context.Context) {
+	ticker := time.NewTicker(cfg.EventPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := ix.Sync(ctx, 500)
+			if err != nil {
+				log.Printf("Search indexer: %v", err)
+				break
+			}
+			if n < 500 {
+				break
+			}
+		}
+
+		// Without a broker nothing else marks events done, so trim consumed ones
+		if eventPublisher == nil && cfg.EventRetention > 0 {
+			db.Where("id <= ? AND created_at < ?", loadCheckpoint(searchCheckpoint), time.Now().Add(-cfg.EventRetention)).Delete(&OutboxEvent{})
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Weak entity tag for a JSON response: a hash of the encoded value, so
+// any change to the resource, or to the representation asked for,
+// changes the tag
+func jsonETag(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// Whether an If-None-Match or If-Match header lists etag. Tags compare
+// weakly, ignoring the W/ prefix, and * matches any.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Write v as a 200 JSON response with its ETag, or just 304 Not Modified
+// when the client's If-None-Match says it already has this version
+func writeJSONConditional(w http.ResponseWriter, r *http.Request, v interface{}) {
+	etag := jsonETag(v)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// The ETag GET /books/{id} sends for the book's full representation
+func bookETag(id uint) (string, error) {
+	etag, _, err := bookETagVersion(id)
+	return etag, err
+}
+
+// The book's ETag and the version it was computed from
+func bookETagVersion(id uint) (string, uint, error) {
+	var book Book
+	if err := db.Preload("Tags").First(&book, id).Error; err != nil {
+		return "", 0, err
+	}
+	return jsonETag(&book), book.Version, nil
+}
+
+// Check a write's If-Match against the book's current ETag. A mismatch
+// means the client edited a stale copy, so answer 412 with the current
+// tag and report false. Writes without If-Match always go ahead.
+//
+// A match only holds for the version it was checked against: the write
+// of book compares and sets that version in its own transaction, so a
+// write that lands between the check and the save makes it fail with 412
+// too, rather than being overwritten.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, book *Book) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	etag, version, err := bookETagVersion(book.ID)
+	if err == nil && etagMatches(ifMatch, etag) {
+		book.ifMatchVersion = version
+		return true
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	writeError(w, r, http.StatusPreconditionFailed, "precondition_failed", "Book has changed since it was read")
+	return false
+}
+
+// Move a book's version on as it is written, in the write's transaction.
+// A write that checked If-Match only goes ahead if the version is still
+// the one it checked.
+func bumpBookVersion(tx *gorm.DB, b *Book) error {
+	// Raw SQL, so the update callbacks don't take it for a bulk write
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	sql, args := "UPDATE books SET version = version + 1 WHERE id = ?", []interface{}{b.ID}
+	if b.ifMatchVersion != 0 {
+		sql, args = sql+" AND version = ?", append(args, b.ifMatchVersion)
+	}
+	res := tx.Exec(sql, args...)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 && b.ifMatchVersion != 0 {
+		return &HookError{Status: http.StatusPreconditionFailed, Code: "precondition_failed", Message: "Book has changed since it was read"}
+	}
+	b.ifMatchVersion = 0
+	return tx.Raw("SELECT version FROM books WHERE id = ?", b.ID).Scan(&b.Version).Error
+}
+
+// Send the book's new ETag after a write, so the client can chain edits
+func setBookETag(w http.ResponseWriter, id uint) {
+	if etag, err := bookETag(id); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	etag := `W/"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"xyz", W/"abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	clearDB()
+	book := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&book)
+	router := setupRouter()
+	path := fmt.Sprintf("/api/v1/books/%d", book.ID)
+
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	first := get(path, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	if response := get(path, etag); response.Code != http.StatusNotModified || response.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304, got %d: %s", response.Code, response.Body.String())
+	}
+	if tag := get(path+"?fields=title", "").Header().Get("ETag"); tag == etag {
+		t.Errorf("Expected a different ETag for a sparse representation")
+	}
+
+	// Changing the book changes its tag
+	db.Model(&book).Update("year", 1965)
+	bookCache.Flush()
+	if response := get(path, etag); response.Code != http.StatusOK || response.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag after a change, got %d", response.Code)
+	}
+
+	list := get("/api/v1/books", "")
+	if response := get("/api/v1/books", list.Header().Get("ETag")); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an unchanged listing, got %d", response.Code)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	clearDB()
+	book := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&book)
+	router := setupRouter()
+	path := fmt.Sprintf("/api/v1/books/%d", book.ID)
+
+	send := func(method, contentType, body, ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	req, _ := http.NewRequest("GET", path, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	etag := response.Header().Get("ETag")
+
+	// Two clients edit the same copy: the first wins, the second is refused
+	first := send("PUT", "", `{"year": 1965}`, etag)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected the first edit to succeed, got %d: %s", first.Code, first.Body.String())
+	}
+	newETag := first.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag after the edit, got %q", newETag)
+	}
+	second := send("PATCH", mergePatchType, `{"title": "Dune Messiah"}`, etag)
+	if second.Code != http.StatusPreconditionFailed || second.Header().Get("ETag") != newETag {
+		t.Errorf("Expected 412 with the current ETag, got %d %q", second.Code, second.Header().Get("ETag"))
+	}
+	if response := send("DELETE", "", "", etag); response.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a stale delete to get 412, got %d", response.Code)
+	}
+
+	// The edit's ETag matches what GET sends
+	if response := send("PATCH", mergePatchType, `{"title": "Dune (1965)"}`, newETag); response.Code != http.StatusOK {
+		t.Errorf("Expected the chained edit to succeed, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := send("DELETE", "", "", "*"); response.Code != http.StatusNoContent {
+		t.Errorf("Expected If-Match * to delete, got %d", response.Code)
+	}
+}
+
+func TestIfMatchComparesAndSets(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+
+	// Two writers pass the If-Match check against the same version...
+	var first, second Book
+	db.First(&first, 1)
+	db.First(&second, 1)
+	first.ifMatchVersion, second.ifMatchVersion = first.Version, second.Version
+
+	// ...but only the first to save wins
+	first.Year = 1965
+	if err := db.Save(&first).Error; err != nil {
+		t.Fatalf("Expected the first write to succeed, got %v", err)
+	}
+	second.Title = "Dune Messiah"
+	var he *HookError
+	if err := db.Save(&second).Error; !errors.As(err, &he) || he.Status != http.StatusPreconditionFailed {
+		t.Fatalf("Expected the second write refused with 412, got %v", err)
+	}
+	var stored Book
+	db.First(&stored, 1)
+	if stored.Title != "Dune" || stored.Year != 1965 || stored.Version != first.Version {
+		t.Errorf("Expected the first write kept, got %+v", stored)
+	}
+
+	// Writes without If-Match still move the version on
+	stored.Year = 1966
+	db.Save(&stored)
+	if stored.Version != first.Version+1 {
+		t.Errorf("Expected version %d, got %d", first.Version+1, stored.Version)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type publishedEvent struct {
+	subject, key string
+	data         []byte
+}
+
+// In-memory publisher that can be made to fail
+type fakePublisher struct {
+	events []publishedEvent
+	err    error
+}
+
+func (p *fakePublisher) Name() string { return "fake" }
+func (p *fakePublisher) Close() error { return nil }
+
+func (p *fakePublisher) Publish(ctx context.Context, subject, key string, data []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, publishedEvent{subject, key, data})
+	return nil
+}
+
+// Install a publisher for one test
+func usePublisher(t *testing.T, p EventPublisher) {
+	saved := eventPublisher
+	eventPublisher = p
+	t.Cleanup(func() { eventPublisher = saved })
+}
+
+func TestBookChangesRecordEvents(t *testing.T) {
+	clearDB()
+	pub := &fakePublisher{}
+	usePublisher(t, pub)
+	router := setupRouter()
+
+	body := []byte(`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","year":1965}`)
+	req, _ := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("PUT", "/api/v1/books/1", bytes.NewBufferString(`{"year":1966}`))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("DELETE", "/api/v1/books/1", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var outbox []OutboxEvent
+	db.Order("id").Find(&outbox)
+	var types []string
+	for _, e := range outbox {
+		types = append(types, e.Type)
+	}
+	if strings.Join(types, ",") != "book.created,book.updated,book.deleted" {
+		t.Fatalf("Unexpected outbox events %v", types)
+	}
+
+	n, err := relayOutbox(context.Background(), pub, 100)
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 events relayed, got %d (%v)", n, err)
+	}
+	if pub.events[0].subject != "books.book.created" || pub.events[0].key != "book:1" {
+		t.Errorf("Unexpected first event %s %s", pub.events[0].subject, pub.events[0].key)
+	}
+	var event Event
+	json.Unmarshal(pub.events[1].data, &event)
+	var book Book
+	json.Unmarshal(event.Data, &book)
+	if event.Type != "book.updated" || book.Year != 1966 {
+		t.Errorf("Unexpected update event %+v", event)
+	}
+
+	// Published events are not sent again
+	if n, _ := relayOutbox(context.Background(), pub, 100); n != 0 {
+		t.Errorf("Expected nothing left to relay, got %d", n)
+	}
+}
+
+func TestCheckoutRecordsEvent(t *testing.T) {
+	clearDB()
+	usePublisher(t, &fakePublisher{})
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	addMember(t, "card-1001")
+
+	response := webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID), `{"borrower":"card-1001"}`)
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var event OutboxEvent
+	if err := db.Where("type = ?", eventLoanCheckedOut).First(&event).Error; err != nil {
+		t.Fatalf("Expected a loan.checked_out event: %v", err)
+	}
+	var recorded Loan
+	json.Unmarshal([]byte(event.Payload), &recorded)
+	if event.Key != fmt.Sprintf("loan:%d", loan.ID) || recorded.BookID != dune.ID || recorded.Borrower != "card-1001" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
+
+func TestNoEventsWithoutBroker(t *testing.T) {
+	clearDB()
+	usePublisher(t, nil)
+
+	db.Create(&Book{Title: "Quiet", Author: "Author", ISBN: "1234567890123"})
+	var count int64
+	db.Model(&OutboxEvent{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no outbox events, got %d", count)
+	}
+}
+
+func TestRelayStopsAtFailure(t *testing.T) {
+	clearDB()
+	pub := &fakePublisher{err: errors.New("broker down")}
+	usePublisher(t, pub)
+
+	db.Create(&Book{Title: "One", Author: "Author", ISBN: "1234567890001"})
+	db.Create(&Book{Title: "Two", Author: "Author", ISBN: "1234567890002"})
+
+	if _, err := relayOutbox(context.Background(), pub, 100); err == nil {
+		t.Fatal("Expected relay to fail")
+	}
+	var first OutboxEvent
+	db.Order("id").First(&first)
+	if first.Attempts != 1 || first.LastError != "broker down" || first.PublishedAt != nil {
+		t.Errorf("Unexpected failed event state %+v", first)
+	}
+
+	// Once the broker recovers, events go out in their original order
+	pub.err = nil
+	if n, err := relayOutbox(context.Background(), pub, 100); err != nil || n != 2 {
+		t.Fatalf("Expected 2 events relayed, got %d (%v)", n, err)
+	}
+	if !strings.Contains(string(pub.events[0].data), `"title":"One"`) {
+		t.Errorf("Expected the first book's event first, got %s", pub.events[0].data)
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		r := bufio.NewReader(conn)
+		var pub string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := r.ReadString('\n')
+				pub = line + payload
+			case line == "PING\r\n":
+				fmt.Fprint(conn, "PONG\r\n")
+				got <- pub
+			}
+		}
+	}()
+
+	p := newNATSPublisher("nats://" + ln.Addr().String())
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Publish(ctx, "books.book.created", "book:1", []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if pub := <-got; pub != "PUB books.book.created 8\r\n{\"id\":1}\r\n" {
+		t.Errorf("Unexpected publish %q", pub)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func fetchCSV(t *testing.T, router http.Handler, path, accept string) (*httptest.ResponseRecorder, [][]string) {
+	req, _ := http.NewRequest("GET", path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	records, err := csv.NewReader(strings.NewReader(response.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV from %s: %v", path, err)
+	}
+	return response, records
+}
+
+func TestExportBooksCSV(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Description: `Spice, "sand" and worms`}
+	db.Create(&dune)
+	db.Model(&dune).Association("Tags").Append(&Tag{Name: "sci-fi"}, &Tag{Name: "classic"})
+	db.Create(&Book{Title: "=HYPERLINK(\"http://evil\")", Author: "Mallory", ISBN: "9780000000001", Year: 2001})
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984})
+	router := setupRouter()
+
+	// Small batches so the export pages through the catalog
+	old := exportBatch
+	exportBatch = 2
+	t.Cleanup(func() { exportBatch = old })
+
+	response, records := fetchCSV(t, router, "/api/v1/books/export.csv?sort=-year", "")
+	if response.Code != http.StatusOK || !strings.HasPrefix(response.Header().Get("Content-Type"), csvType) {
+		t.Fatalf("Expected a CSV response, got %d %q", response.Code, response.Header().Get("Content-Type"))
+	}
+	if cd := response.Header().Get("Content-Disposition"); cd != `attachment; filename="books.csv"` {
+		t.Errorf("Expected an attachment, got %q", cd)
+	}
+	if len(records) != 4 || fmt.Sprint(records[0]) != fmt.Sprint(bookCSVHeader) {
+		t.Fatalf("Expected a header and 3 books, got %v", records)
+	}
+	var titles []string
+	for _, rec := range records[1:] {
+		titles = append(titles, rec[1])
+	}
+	if want := `['=HYPERLINK("http://evil") Neuromancer Dune]`; fmt.Sprint(titles) != want {
+		t.Errorf("Expected %s, got %v", want, titles)
+	}
+	last := records[3]
+	if last[5] != `Spice, "sand" and worms` || last[8] != "sci-fi, classic" && last[8] != "classic, sci-fi" {
+		t.Errorf("Unexpected Dune record %q", last)
+	}
+
+	// The listing serves the same export by Accept, with its filters
+	response, records = fetchCSV(t, router, "/api/v1/books?author=gibson", csvType)
+	if response.Code != http.StatusOK || len(records) != 2 || records[1][1] != "Neuromancer" {
+		t.Errorf("Expected Neuromancer only, got %d %v", response.Code, records)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/export.csv?sort=price", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid sort, got %d", response.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Buckets returned per facet, most common values first
+const maxFacetBuckets = 20
+
+// Facets the books listing can count, by ?facets= name
+var bookFacetNames = []string{"author", "year", "tag"}
+
+// Facets maps each requested facet to its buckets
+type Facets map[string][]FacetBucket
+
+// FacetBucket is how many of the filtered books have a value. Values are
+// strings for every facet, so a year bucket's value is "1965".
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// A v1 listing with facets. The plain array has nowhere to put them, so
+// the books move under items, as in a cursor page.
+type facetedBookList struct {
+	Items  interface{} `json:"items"`
+	Facets Facets      `json:"facets"`
+}
+
+// Parse ?facets=, a comma-separated list of facet names: "author,year"
+func parseBookFacets(s string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" || seen[name] {
+			continue
+		}
+		found := false
+		for _, n := range bookFacetNames {
+			found = found || n == name
+		}
+		if !found {
+			return nil, fmt.Errorf("cannot count %q, expected author, year or tag", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Count the values of each named facet among the books the filter
+// matches. Books without an author or year are left out of those facets.
+func bookFacets(ctx context.Context, filter bookFilter, names []string) (Facets, error) {
+	facets := Facets{}
+	for _, name := range names {
+		var query *gorm.DB
+		switch name {
+		case "author":
+			query = filter.apply(db.WithContext(ctx).Model(&Book{})).Select("author AS value, COUNT(*) AS count").
+				Where("author <> ''").Group("author")
+		case "year":
+			query = filter.apply(db.WithContext(ctx).Model(&Book{})).Select("year AS value, COUNT(*) AS count").
+				Where("year <> 0").Group("year")
+		case "tag":
+			matching := filter.apply(db.WithContext(ctx).Model(&Book{})).Select("id")
+			query = db.WithContext(ctx).Table("book_tags").Select("tags.name AS value, COUNT(*) AS count").
+				Joins("JOIN tags ON tags.id = book_tags.tag_id").
+				Where("book_tags.book_id IN (?)", matching).Group("tags.name")
+		}
+		var buckets []FacetBucket
+		if err := query.Order("count DESC, value").Limit(maxFacetBuckets).Scan(&buckets).Error; err != nil {
+			return nil, err
+		}
+		facets[name] = listOf(buckets)
+	}
+	return facets, nil
+}
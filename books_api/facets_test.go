@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func seedFacetBooks() {
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Tags: []Tag{{Name: "sf"}}},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696", Year: 1969, Tags: []Tag{{ID: 1, Name: "sf"}}},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984, Tags: []Tag{{ID: 1, Name: "sf"}, {Name: "cyberpunk"}}},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+	} {
+		db.Create(&b)
+	}
+}
+
+func getFacets(t *testing.T, router http.Handler, path string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", path, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var body map[string]json.RawMessage
+	json.Unmarshal(response.Body.Bytes(), &body)
+	return response, body
+}
+
+func TestBookFacets(t *testing.T) {
+	clearDB()
+	seedFacetBooks()
+	router := setupRouter()
+
+	// Counts cover the filtered books only
+	response, body := getFacets(t, router, "/api/v1/books?year_min=1900&facets=author,year,tag")
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var items []Book
+	var facets Facets
+	json.Unmarshal(body["items"], &items)
+	json.Unmarshal(body["facets"], &facets)
+	if len(items) != 3 {
+		t.Errorf("Expected three books under items, got %s", response.Body.String())
+	}
+	want := Facets{
+		"author": {{"Frank Herbert", 2}, {"William Gibson", 1}},
+		"year":   {{"1965", 1}, {"1969", 1}, {"1984", 1}},
+		"tag":    {{"sf", 3}, {"cyberpunk", 1}},
+	}
+	for name, buckets := range want {
+		if len(facets[name]) != len(buckets) {
+			t.Errorf("%s: expected %v, got %v", name, buckets, facets[name])
+			continue
+		}
+		for i, b := range buckets {
+			if facets[name][i] != b {
+				t.Errorf("%s: expected %v, got %v", name, buckets, facets[name])
+				break
+			}
+		}
+	}
+
+	// Without facets the v1 listing stays a plain array
+	response, _ = getFacets(t, router, "/api/v1/books")
+	if err := json.Unmarshal(response.Body.Bytes(), &items); err != nil || len(items) != 4 {
+		t.Errorf("Expected a plain array, got %s", response.Body.String())
+	}
+
+	if response, _ := getFacets(t, router, "/api/v1/books?facets=isbn"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown facet, got %d", response.Code)
+	}
+}
+
+func TestBookFacetsInPagesAndEnvelopes(t *testing.T) {
+	clearDB()
+	seedFacetBooks()
+	router := setupRouter()
+
+	_, body := getFacets(t, router, "/api/v1/books?limit=1&facets=author")
+	var facets Facets
+	json.Unmarshal(body["facets"], &facets)
+	if len(facets["author"]) != 3 || body["next_cursor"] == nil {
+		t.Errorf("Expected facets over every page in a cursor page, got %v", body)
+	}
+
+	_, body = getFacets(t, router, "/api/v2/books?author=herbert&facets=tag")
+	var meta ListMeta
+	json.Unmarshal(body["meta"], &meta)
+	if len(meta.Facets["tag"]) != 1 || meta.Facets["tag"][0] != (FacetBucket{"sf", 2}) {
+		t.Errorf("Expected facets in the envelope's meta, got %s", body["meta"])
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Atom (RFC 4287) feed of new arrivals, for feed readers and portals that
+// subscribe to what the library has added
+
+const atomType = "application/atom+xml"
+
+// Entries in the feed, unless ?limit= asks for fewer or more
+const (
+	defaultFeedEntries = 20
+	maxFeedEntries     = 100
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Author     atomPerson     `xml:"author"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Scheme and host the client reached, for absolute links
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Atom's date format
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Build the feed for books, newest first. Books catalogued before creation
+// times were kept are dated at the Unix epoch.
+func buildBooksFeed(origin string, books []Book) *atomFeed {
+	self := origin + "/feeds/books.atom"
+	feed := &atomFeed{
+		ID:    self,
+		Title: "Books API: new arrivals",
+		Links: []atomLink{
+			{Rel: "self", Type: atomType, Href: self},
+			{Rel: "alternate", Type: "application/json", Href: origin + apiPrefix + "/books"},
+		},
+		Updated: atomTime(time.Unix(0, 0)),
+	}
+	for i, b := range books {
+		created := b.CreatedAt
+		if created.IsZero() {
+			created = time.Unix(0, 0)
+		}
+		if i == 0 {
+			feed.Updated = atomTime(created)
+		}
+		href := fmt.Sprintf("%s%s/books/%d", origin, apiPrefix, b.ID)
+		entry := atomEntry{
+			ID:        href,
+			Title:     b.Title,
+			Updated:   atomTime(created),
+			Published: atomTime(created),
+			Author:    atomPerson{Name: b.Author},
+			Summary:   b.Description,
+			Links:     []atomLink{{Rel: "alternate", Type: "application/json", Href: href}},
+		}
+		for _, t := range b.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t.Name})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// Serve the most recently added books as an Atom feed, with an ETag so
+// readers polling it get 304 until something is added
+func getBooksFeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	limit := defaultFeedEntries
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFeedEntries {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("limit must be between 1 and %d", maxFeedEntries))
+			return
+		}
+		limit = n
+	}
+
+	var books []Book
+	if err := db.WithContext(r.Context()).Preload("Tags").Order("id DESC").Limit(limit).Find(&books).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load books")
+		return
+	}
+
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	if err := xml.NewEncoder(&body).Encode(buildBooksFeed(requestOrigin(r), books)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to encode feed")
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := fmt.Sprintf(`W/"%x"`, sum[:16])
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", atomType+"; charset=utf-8")
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBooksFeed(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815})
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965,
+		Description: "Spice & sand", Tags: []Tag{{Name: "sf"}}}
+	db.Create(&dune)
+
+	req, _ := http.NewRequest("GET", "/feeds/books.atom", nil)
+	req.Host = "books.example.com"
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK || !strings.HasPrefix(response.Header().Get("Content-Type"), atomType) {
+		t.Fatalf("Expected an Atom feed, got %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(response.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.ID != "http://books.example.com/feeds/books.atom" || len(feed.Entries) != 2 {
+		t.Fatalf("Unexpected feed %+v", feed)
+	}
+	first := feed.Entries[0]
+	if first.Title != "Dune" || first.Author.Name != "Frank Herbert" || first.Summary != "Spice & sand" {
+		t.Errorf("Expected the newest book first, got %+v", first)
+	}
+	if first.ID != "http://books.example.com/api/v1/books/2" || len(first.Links) != 1 || first.Links[0].Href != first.ID {
+		t.Errorf("Expected links to the book, got %+v", first)
+	}
+	if len(first.Categories) != 1 || first.Categories[0].Term != "sf" {
+		t.Errorf("Expected the book's tags as categories, got %+v", first.Categories)
+	}
+	if updated, err := time.Parse(time.RFC3339, feed.Updated); err != nil || time.Since(updated) > time.Minute || feed.Updated != first.Updated {
+		t.Errorf("Expected the feed updated with its newest entry, got %q", feed.Updated)
+	}
+
+	// Readers polling with the ETag get 304 until a book is added
+	req, _ = http.NewRequest("GET", "/feeds/books.atom", nil)
+	req.Host = "books.example.com"
+	req.Header.Set("If-None-Match", response.Header().Get("ETag"))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/feeds/books.atom?limit=1", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	feed = atomFeed{}
+	xml.Unmarshal(response.Body.Bytes(), &feed)
+	if len(feed.Entries) != 1 {
+		t.Errorf("Expected one entry, got %d", len(feed.Entries))
+	}
+
+	req, _ = http.NewRequest("GET", "/feeds/books.atom?limit=0", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad limit, got %d", response.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Book fields a client can pick with ?fields=, mapped to their columns.
+// Tags aren't a column and are preloaded instead.
+var bookFieldColumns = map[string]string{
+	"id":                   "id",
+	"title":                "title",
+	"author":               "author",
+	"isbn":                 "isbn",
+	"year":                 "year",
+	"description":          "description",
+	"cover_url":            "cover_url",
+	"price_cents":          "price_cents",
+	"language":             "language",
+	"page_count":           "page_count",
+	"format":               "format",
+	"status":               "status",
+	"attributes":           "attributes",
+	"purchase_price_cents": "purchase_price_cents",
+	"purchase_currency":    "purchase_currency",
+	"location":             "location",
+	"call_number":          "call_number",
+	"average_rating":       "average_rating",
+	"review_count":         "review_count",
+	"created_at":           "created_at",
+	"updated_at":           "updated_at",
+	"tags":                 "",
+}
+
+// bookFields is a ?fields= list. Nil means every field.
+type bookFields []string
+
+// Parse ?fields=, a comma-separated list of field names: "id,title"
+func parseBookFields(s string) (bookFields, error) {
+	var fields bookFields
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || seen[part] {
+			continue
+		}
+		if _, ok := bookFieldColumns[part]; !ok {
+			return nil, fmt.Errorf("unknown field %q", part)
+		}
+		seen[part] = true
+		fields = append(fields, part)
+	}
+	return fields, nil
+}
+
+func (f bookFields) has(name string) bool {
+	for _, field := range f {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Narrow query to the columns the fields need. The ID and the sort keys
+// are always read, for preloading tags and building cursors.
+func (f bookFields) selectColumns(query *gorm.DB, keys []bookSortKey) *gorm.DB {
+	if f == nil {
+		return query
+	}
+	columns := []string{"id"}
+	for _, field := range f {
+		if col := bookFieldColumns[field]; col != "" && col != "id" {
+			columns = append(columns, col)
+		}
+	}
+	for _, k := range keys {
+		if !f.has(k.Field) && k.column() != "id" {
+			columns = append(columns, k.column())
+		}
+	}
+	query = query.Select(columns)
+	if f.has("tags") {
+		query = query.Preload("Tags")
+	}
+	return query
+}
+
+func bookFieldValue(b *Book, field string) interface{} {
+	switch field {
+	case "id":
+		return b.ID
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "isbn":
+		return b.ISBN
+	case "year":
+		return b.Year
+	case "description":
+		return b.Description
+	case "cover_url":
+		return b.CoverURL
+	case "price_cents":
+		return b.PriceCents
+	case "language":
+		return b.Language
+	case "page_count":
+		return b.PageCount
+	case "format":
+		return b.Format
+	case "status":
+		return b.Status
+	case "attributes":
+		return b.Attributes
+	case "purchase_price_cents":
+		return b.PurchasePriceCents
+	case "purchase_currency":
+		return b.PurchaseCurrency
+	case "location":
+		return b.Location
+	case "call_number":
+		return b.CallNumber
+	case "average_rating":
+		return b.AverageRating
+	case "review_count":
+		return b.ReviewCount
+	case "created_at":
+		return b.CreatedAt
+	case "updated_at":
+		return b.UpdatedAt
+	}
+	return listOf(b.Tags)
+}
+
+// The book with only the requested fields, each present even when empty
+func (f bookFields) project(b *Book) interface{} {
+	if f == nil {
+		return b
+	}
+	obj := make(map[string]interface{}, len(f))
+	for _, field := range f {
+		obj[field] = bookFieldValue(b, field)
+	}
+	return obj
+}
+
+func (f bookFields) projectList(books []Book) interface{} {
+	if f == nil {
+		return listOf(books)
+	}
+	items := make([]interface{}, len(books))
+	for i := range books {
+		items[i] = f.project(&books[i])
+	}
+	return items
+}
+
+// A BookPage cut down to the requested fields
+type sparseBookPage struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+	Facets     Facets      `json:"facets,omitempty"`
+}
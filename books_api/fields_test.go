@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func fetchSparse(t *testing.T, router http.Handler, path string) (*httptest.ResponseRecorder, interface{}) {
+	req, _ := http.NewRequest("GET", path, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var v interface{}
+	json.Unmarshal(response.Body.Bytes(), &v)
+	return response, v
+}
+
+func objectKeys(v interface{}) []string {
+	var keys []string
+	for k := range v.(map[string]interface{}) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Description: "Spice"}
+	db.Create(&dune)
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984})
+	router := setupRouter()
+
+	response, v := fetchSparse(t, router, "/api/v1/books?fields=id,title,price_cents&sort=-year")
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+	items := v.([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 books, got %v", v)
+	}
+	if keys := objectKeys(items[0]); fmt.Sprint(keys) != "[id price_cents title]" {
+		t.Errorf("Expected only the requested keys, got %v", keys)
+	}
+	if items[0].(map[string]interface{})["title"] != "Neuromancer" {
+		t.Errorf("Expected the sort to apply, got %v", items)
+	}
+
+	// Sort keys the client didn't ask for still drive the cursor
+	response, v = fetchSparse(t, router, "/api/v1/books?fields=title&sort=-year&limit=1")
+	page := v.(map[string]interface{})
+	cursor, _ := page["next_cursor"].(string)
+	if cursor == "" {
+		t.Fatalf("Expected a next cursor, got %s", response.Body.String())
+	}
+	_, v = fetchSparse(t, router, "/api/v1/books?fields=title&sort=-year&limit=1&cursor="+url.QueryEscape(cursor))
+	items = v.(map[string]interface{})["items"].([]interface{})
+	if len(items) != 1 || fmt.Sprint(items[0]) != "map[title:Dune]" {
+		t.Errorf("Expected Dune on the second page, got %v", items)
+	}
+
+	// Detail, from the database and then from the cache
+	for i := 0; i < 2; i++ {
+		_, v = fetchSparse(t, router, fmt.Sprintf("/api/v1/books/%d?fields=title,description,tags", dune.ID))
+		if keys := objectKeys(v); fmt.Sprint(keys) != "[description tags title]" {
+			t.Errorf("Expected only the requested keys, got %v", v)
+		}
+	}
+
+	for _, path := range []string{"/api/v1/books?fields=title,cover_key", fmt.Sprintf("/api/v1/books/%d?fields=secret", dune.ID)} {
+		if response, _ := fetchSparse(t, router, path); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", path, response.Code)
+		}
+	}
+}
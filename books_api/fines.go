@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Fines for late returns. Returning a loan after its due date charges
+// FINE_DAILY_CENTS for every day or part of a day it was late, up to
+// FINE_MAX_CENTS, unless it came back within FINE_GRACE_PERIOD. Each
+// charge is a Fine the desk records payments against.
+
+// Fine is the charge for one late return
+type Fine struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	LoanID      uint       `json:"loan_id" gorm:"uniqueIndex;not null"`
+	Borrower    string     `json:"borrower" gorm:"index;not null"`
+	DaysLate    int        `json:"days_late"`
+	AmountCents int64      `json:"amount_cents"`
+	PaidCents   int64      `json:"paid_cents"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+}
+
+// What is still owed on the fine
+func (f *Fine) OutstandingCents() int64 {
+	return f.AmountCents - f.PaidCents
+}
+
+// MemberFines is a member's fines, newest first, with what they owe
+type MemberFines struct {
+	MemberID         uint   `json:"member_id"`
+	Borrower         string `json:"borrower"`
+	OutstandingCents int64  `json:"outstanding_cents"`
+	Fines            []Fine `json:"fines"`
+}
+
+// FinePayment is the body of POST /fines/{id}/payments
+type FinePayment struct {
+	AmountCents int64 `json:"amount_cents"`
+}
+
+// The fine for returning a loan at returnedAt, or nil when it isn't owed
+func fineFor(loan *Loan, returnedAt time.Time) *Fine {
+	late := returnedAt.Sub(loan.DueAt)
+	if late <= 0 || late <= cfg.FineGrace || cfg.FineDailyCents <= 0 {
+		return nil
+	}
+	days := int((late + 24*time.Hour - 1) / (24 * time.Hour))
+	amount := int64(days) * cfg.FineDailyCents
+	if cfg.FineMaxCents > 0 && amount > cfg.FineMaxCents {
+		amount = cfg.FineMaxCents
+	}
+	return &Fine{LoanID: loan.ID, Borrower: loan.Borrower, DaysLate: days, AmountCents: amount}
+}
+
+// List a member's fines
+func getMemberFines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	member, ok := loadMember(w, r)
+	if !ok {
+		return
+	}
+	result := MemberFines{MemberID: member.ID, Borrower: member.MembershipNumber}
+	if err := db.Where("borrower = ?", result.Borrower).Order("created_at DESC, id DESC").Find(&result.Fines).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list fines")
+		return
+	}
+	for i := range result.Fines {
+		result.OutstandingCents += result.Fines[i].OutstandingCents()
+	}
+	result.Fines = listOf(result.Fines)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Returned when a payment is more than the fine's outstanding amount
+var errOverpayment = errors.New("payment exceeds the outstanding amount")
+
+// Record a payment towards a fine. Paying the rest of it marks it paid.
+func payFine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_fine_id", "Invalid fine ID")
+		return
+	}
+	var payment FinePayment
+	if err := decodeBody(r, &payment); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if payment.AmountCents <= 0 {
+		writeValidationErrors(w, r, []FieldError{{Field: "amount_cents", Message: "must be positive"}})
+		return
+	}
+
+	// Added in SQL, so payments at the same time can't overpay together
+	var fine Fine
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Fine{}).Where("id = ? AND amount_cents - paid_cents >= ?", id, payment.AmountCents).
+			Update("paid_cents", gorm.Expr("paid_cents + ?", payment.AmountCents))
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.First(&fine, id).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return errOverpayment
+		}
+		if fine.OutstandingCents() == 0 {
+			now := time.Now().UTC()
+			fine.PaidAt = &now
+			return tx.Model(&fine).Update("paid_at", now).Error
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, r, http.StatusNotFound, "fine_not_found", "Fine not found")
+	case errors.Is(err, errOverpayment):
+		writeError(w, r, http.StatusConflict, "fine_overpaid", "The payment is more than the "+
+			strconv.FormatInt(fine.OutstandingCents(), 10)+" cents outstanding")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record payment")
+	default:
+		writeJSON(w, http.StatusOK, fine)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFineFor(t *testing.T) {
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	loan := &Loan{ID: 7, Borrower: "card-1001", DueAt: due}
+	tests := []struct {
+		returned time.Time
+		days     int
+		amount   int64
+	}{
+		{due.Add(-time.Hour), 0, 0},
+		{due.Add(cfg.FineGrace), 0, 0},
+		{due.Add(cfg.FineGrace + time.Minute), 2, 2 * cfg.FineDailyCents},
+		{due.Add(72 * time.Hour), 3, 3 * cfg.FineDailyCents},
+		{due.Add(365 * 24 * time.Hour), 365, cfg.FineMaxCents},
+	}
+	for _, test := range tests {
+		fine := fineFor(loan, test.returned)
+		if test.days == 0 {
+			if fine != nil {
+				t.Errorf("Expected no fine returned at %v, got %+v", test.returned, fine)
+			}
+			continue
+		}
+		if fine == nil || fine.DaysLate != test.days || fine.AmountCents != test.amount || fine.LoanID != 7 || fine.Borrower != "card-1001" {
+			t.Errorf("Expected %d days and %d cents returned at %v, got %+v", test.days, test.amount, test.returned, fine)
+		}
+	}
+}
+
+func TestLateReturnFinesAndPayments(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	member := addMember(t, "card-1001")
+	late := Loan{BookID: dune.ID, Borrower: "card-1001", CheckedOutAt: time.Now().Add(-30 * 24 * time.Hour), DueAt: time.Now().Add(-3*24*time.Hour - time.Hour)}
+	db.Create(&late)
+
+	response := webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/loans/%d/return", late.ID), "")
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if response.Code != http.StatusOK || loan.Fine == nil || loan.Fine.DaysLate != 4 || loan.Fine.AmountCents != 4*cfg.FineDailyCents {
+		t.Fatalf("Expected a 4 day fine, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// On time returns aren't fined
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	db.Create(&emma)
+	onTime := Loan{BookID: emma.ID, Borrower: "card-1001", CheckedOutAt: time.Now(), DueAt: time.Now().Add(time.Hour)}
+	db.Create(&onTime)
+	response = webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/loans/%d/return", onTime.ID), "")
+	var returned map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &returned)
+	if _, ok := returned["fine"]; ok {
+		t.Errorf("Expected no fine for an on time return, got %s", response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/members/%d/fines", member.ID), "")
+	var fines MemberFines
+	json.Unmarshal(response.Body.Bytes(), &fines)
+	if response.Code != http.StatusOK || len(fines.Fines) != 1 || fines.MemberID != member.ID || fines.OutstandingCents != 4*cfg.FineDailyCents {
+		t.Fatalf("Expected one outstanding fine, got %d: %s", response.Code, response.Body.String())
+	}
+
+	payments := fmt.Sprintf("/api/v1/fines/%d/payments", fines.Fines[0].ID)
+	response = webhookRequest(t, router, "POST", payments, `{"amount_cents":30}`)
+	var fine Fine
+	json.Unmarshal(response.Body.Bytes(), &fine)
+	if response.Code != http.StatusOK || fine.PaidCents != 30 || fine.PaidAt != nil {
+		t.Errorf("Expected a part payment, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", payments, `{"amount_cents":1000}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for an overpayment, got %d", response.Code)
+	}
+	for _, body := range []string{`{"amount_cents":0}`, `{"amount_cents":-5}`} {
+		if response := webhookRequest(t, router, "POST", payments, body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+	response = webhookRequest(t, router, "POST", payments, fmt.Sprintf(`{"amount_cents":%d}`, 4*cfg.FineDailyCents-30))
+	json.Unmarshal(response.Body.Bytes(), &fine)
+	if response.Code != http.StatusOK || fine.OutstandingCents() != 0 || fine.PaidAt == nil {
+		t.Errorf("Expected the fine paid off, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/fines/999/payments", `{"amount_cents":10}`); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/members/%d/fines", member.ID), "")
+	json.Unmarshal(response.Body.Bytes(), &fines)
+	if fines.OutstandingCents != 0 {
+		t.Errorf("Expected nothing outstanding, got %s", response.Body.String())
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// Whether the books_fts index is available for SQL search. FTS5 is only
+// compiled into the SQLite driver with the sqlite_fts5 build tag; without
+// it search falls back to LIKE.
+var ftsEnabled bool
+
+// External-content FTS5 index over the books table, kept in sync by
+// triggers so every write path is covered without application code
+var ftsSchema = []string{
+	`CREATE VIRTUAL TABLE books_fts USING fts5(
+		title, author, isbn, description,
+		content='books', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+		INSERT INTO books_fts(rowid, title, author, isbn, description)
+		VALUES (new.id, new.title, new.author, new.isbn, new.description);
+	END`,
+	`CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, isbn, description)
+		VALUES ('delete', old.id, old.title, old.author, old.isbn, old.description);
+	END`,
+	`CREATE TRIGGER books_fts_update AFTER UPDATE ON books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, isbn, description)
+		VALUES ('delete', old.id, old.title, old.author, old.isbn, old.description);
+		INSERT INTO books_fts(rowid, title, author, isbn, description)
+		VALUES (new.id, new.title, new.author, new.isbn, new.description);
+	END`,
+	// Index the books written before the index existed
+	`INSERT INTO books_fts(books_fts) VALUES ('rebuild')`,
+}
+
+// Create the full-text index on first start, if the driver supports FTS5
+func initFTS() {
+	var exists int64
+	db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'books_fts'").Scan(&exists)
+	if exists > 0 {
+		ftsEnabled = true
+		return
+	}
+
+	tx := db.Begin()
+	for _, stmt := range ftsSchema {
+		if err := tx.Exec(stmt).Error; err != nil {
+			tx.Rollback()
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				log.Printf("SQLite FTS5 is not available, search uses LIKE matching")
+			} else {
+				log.Printf("Creating the full-text index failed, search uses LIKE matching: %v", err)
+			}
+			ftsEnabled = false
+			return
+		}
+	}
+	ftsEnabled = tx.Commit().Error == nil
+}
+
+// Turn free text into an FTS5 query: every word must match, each as a
+// quoted prefix so the query syntax in user input is taken literally.
+// ISBNs match with or without hyphens.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		if strings.Trim(word, "0123456789-Xx") == "" && strings.ContainsAny(word, "0123456789") {
+			word = cleanISBN(word)
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search through the full-text index, best match first. Title and ISBN
+// matches outrank author matches, which outrank the description.
+func ftsSearchBooks(ctx context.Context, q string, limit, offset int) ([]Book, error) {
+	var books []Book
+	err := db.WithContext(ctx).Model(&Book{}).Select("books.*").
+		Joins("JOIN books_fts ON books_fts.rowid = books.id").
+		Where("books_fts MATCH ?", ftsQuery(q)).
+		Order("bm25(books_fts, 10.0, 5.0, 10.0, 1.0)").
+		Limit(limit).Offset(offset).
+		Find(&books).Error
+	return books, err
+}
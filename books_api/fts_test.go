@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"dune":                 `"dune"*`,
+		`frank "herbert`:       `"frank"* """herbert"*`,
+		"978-0-441-01359-3":    `"9780441013593"*`,
+		"dune AND NOT messiah": `"dune"* "AND"* "NOT"* "messiah"*`,
+	}
+	for q, want := range cases {
+		if got := ftsQuery(q); got != want {
+			t.Errorf("ftsQuery(%q) = %s, expected %s", q, got, want)
+		}
+	}
+}
+
+func TestFTSSearch(t *testing.T) {
+	if !ftsEnabled {
+		t.Skip("SQLite FTS5 is not compiled in; run with -tags sqlite_fts5")
+	}
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Children of Dune", Author: "Frank Herbert", ISBN: "9780441104024"})
+	db.Create(&Book{Title: "The Road to Dune", Author: "Brian Herbert", ISBN: "9780765353696", Description: "Dune dune dune"})
+	db.Create(&Book{Title: "Arrakis", Author: "Frank Herbert", ISBN: "9780441013593", Description: "A sequel to Dune"})
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"})
+
+	// Title matches rank above description matches
+	if titles := searchTitles(t, router, "q=dune"); len(titles) != 3 || titles[2] != "Arrakis" {
+		t.Errorf("Unexpected ranking %v", titles)
+	}
+	if titles := searchTitles(t, router, "q=978-0441569595"); strings.Join(titles, ",") != "Neuromancer" {
+		t.Errorf("Expected a hyphenated ISBN to match, got %v", titles)
+	}
+	if titles := searchTitles(t, router, "q=neur"); strings.Join(titles, ",") != "Neuromancer" {
+		t.Errorf("Expected a prefix to match, got %v", titles)
+	}
+
+	// The index follows updates and deletes
+	db.Model(&Book{ID: 4}).Update("title", "Count Zero")
+	db.Delete(&Book{}, 1)
+	if titles := searchTitles(t, router, "q=count"); strings.Join(titles, ",") != "Count Zero" {
+		t.Errorf("Expected the updated title to be indexed, got %v", titles)
+	}
+	if titles := searchTitles(t, router, "q=children"); len(titles) != 0 {
+		t.Errorf("Expected the deleted book to be gone, got %v", titles)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Largest export file we accept
+const maxImportBytes = 10 << 20
+
+// Supported export formats
+const (
+	formatGoodreads  = "goodreads"
+	formatStoryGraph = "storygraph"
+)
+
+// Import row outcomes
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionSkip   = "skip"
+	actionError  = "error"
+)
+
+// ImportRow is one parsed export row and what the import does with it
+type ImportRow struct {
+	Row     int      `json:"row"`
+	Title   string   `json:"title"`
+	Author  string   `json:"author"`
+	ISBN    string   `json:"isbn"`
+	Year    int      `json:"year"`
+	Tags    []string `json:"tags"`
+	Rating  float64  `json:"rating,omitempty"`
+	Review  string   `json:"review,omitempty"`
+	Action  string   `json:"action"`
+	Message string   `json:"message,omitempty"`
+
+	existing *Book
+	// The whole book, for catalog imports
+	book *Book
+}
+
+// ImportReport summarizes an import or its dry run
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Format  string      `json:"format"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Errors  int         `json:"errors"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// Column lookup by normalized header name
+type csvHeader map[string]int
+
+func newCSVHeader(record []string) csvHeader {
+	h := csvHeader{}
+	for i, name := range record {
+		// Strip a UTF-8 BOM from the first column
+		name = strings.TrimPrefix(name, "\ufeff")
+		h[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	return h
+}
+
+func (h csvHeader) has(name string) bool {
+	_, ok := h[name]
+	return ok
+}
+
+// Return the first non-empty value among the named columns
+func (h csvHeader) get(record []string, names ...string) string {
+	for _, name := range names {
+		if i, ok := h[name]; ok && i < len(record) {
+			if v := strings.TrimSpace(record[i]); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// Detect the export format from its header row
+func detectImportFormat(h csvHeader) (string, error) {
+	switch {
+	case h.has("my rating") || h.has("bookshelves"):
+		return formatGoodreads, nil
+	case h.has("star rating") || h.has("isbn/uid"):
+		return formatStoryGraph, nil
+	}
+	return "", errors.New("unrecognized export format, expected a Goodreads or StoryGraph CSV")
+}
+
+// Goodreads wraps identifiers in a spreadsheet formula: ="0132350882"
+func unwrapSpreadsheetValue(v string) string {
+	v = strings.TrimPrefix(v, "=")
+	return strings.Trim(v, `"`)
+}
+
+// Split a comma-separated shelf or tag list into normalized tag names
+func splitTags(lists ...string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, list := range lists {
+		for _, t := range strings.Split(list, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t != "" && !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// Parse an export into rows. Row numbers count the header as row 1.
+func parseLibraryExport(r io.Reader) (string, []ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	first, err := reader.Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("reading header: %w", err)
+	}
+	header := newCSVHeader(first)
+	format, err := detectImportFormat(header)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("row %d: %w", line, err)
+		}
+
+		row := ImportRow{Row: line}
+		switch format {
+		case formatGoodreads:
+			row.Title = header.get(record, "title")
+			row.Author = header.get(record, "author")
+			row.ISBN = unwrapSpreadsheetValue(header.get(record, "isbn13"))
+			if row.ISBN == "" {
+				row.ISBN = unwrapSpreadsheetValue(header.get(record, "isbn"))
+			}
+			row.Year, _ = strconv.Atoi(header.get(record, "year published", "original publication year"))
+			row.Tags = splitTags(header.get(record, "bookshelves"), header.get(record, "exclusive shelf"))
+			row.Rating, _ = strconv.ParseFloat(header.get(record, "my rating"), 64)
+			row.Review = header.get(record, "my review")
+		case formatStoryGraph:
+			row.Title = header.get(record, "title")
+			row.Author = header.get(record, "authors")
+			row.ISBN = header.get(record, "isbn/uid")
+			row.Tags = splitTags(header.get(record, "tags"), header.get(record, "read status"))
+			row.Rating, _ = strconv.ParseFloat(header.get(record, "star rating"), 64)
+			row.Review = header.get(record, "review")
+		}
+		row.ISBN = cleanISBN(row.ISBN)
+		rows = append(rows, row)
+	}
+
+	return format, rows, nil
+}
+
+// Decide what to do with every row without writing anything
+func planImport(ctx context.Context, rows []ImportRow) {
+	seen := map[string]bool{}
+	for i := range rows {
+		row := &rows[i]
+
+		book := Book{Title: row.Title, Author: row.Author, ISBN: row.ISBN, Year: row.Year}
+		if errs := validateBook(&book); len(errs) > 0 {
+			row.Action = actionError
+			row.Message = fmt.Sprintf("%s %s", errs[0].Field, errs[0].Message)
+			continue
+		}
+		if row.Rating < 0 || row.Rating > 5 {
+			row.Action = actionError
+			row.Message = "rating must be between 0 and 5"
+			continue
+		}
+		if seen[row.ISBN] {
+			row.Action = actionSkip
+			row.Message = "duplicate ISBN in file"
+			continue
+		}
+		seen[row.ISBN] = true
+
+		var existing Book
+		if err := db.WithContext(ctx).Where("isbn = ?", row.ISBN).First(&existing).Error; err == nil {
+			row.Action = actionUpdate
+			row.Message = "book exists, adding tags and review"
+			row.existing = &existing
+		} else {
+			row.Action = actionCreate
+		}
+	}
+}
+
+// Tag a book with the named tags, creating any that don't exist yet
+func appendTags(tx *gorm.DB, book *Book, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tag := Tag{Name: name}
+		if err := tx.Where(Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	return tx.Model(book).Association("Tags").Append(tags)
+}
+
+// Write planned rows in a single transaction
+func applyImport(tx *gorm.DB, rows []ImportRow, source string) error {
+	for i := range rows {
+		row := &rows[i]
+
+		var book *Book
+		switch row.Action {
+		case actionCreate:
+			book = &Book{Title: row.Title, Author: row.Author, ISBN: row.ISBN, Year: row.Year}
+			if err := tx.Create(book).Error; err != nil {
+				return fmt.Errorf("row %d: %w", row.Row, err)
+			}
+		case actionUpdate:
+			book = row.existing
+		default:
+			continue
+		}
+
+		if err := appendTags(tx, book, row.Tags); err != nil {
+			return fmt.Errorf("row %d: %w", row.Row, err)
+		}
+
+		if row.Rating > 0 || row.Review != "" {
+			review := Review{BookID: book.ID, Rating: row.Rating, Body: row.Review, Source: source}
+			if err := tx.Create(&review).Error; err != nil {
+				return fmt.Errorf("row %d: %w", row.Row, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Open the uploaded export, either a multipart "file" field or the raw body
+func importBody(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, errors.New("multipart upload must include a file field")
+	}
+	return file, nil
+}
+
+// Import a Goodreads or StoryGraph library export
+func importGoodreads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	body, err := importBody(w, r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	format, rows, err := parseLibraryExport(body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid CSV: "+err.Error())
+		return
+	}
+
+	planImport(r.Context(), rows)
+	runImport(w, r, format, rows, func(tx *gorm.DB) error {
+		return applyImport(tx, rows, format)
+	})
+}
+
+// Report planned rows and, unless this is a dry run, apply them in one
+// transaction
+func runImport(w http.ResponseWriter, r *http.Request, format string, rows []ImportRow, apply func(tx *gorm.DB) error) {
+	report := ImportReport{
+		DryRun: r.URL.Query().Get("dry_run") == "true",
+		Format: format,
+		Rows:   listOf(rows),
+	}
+	for _, row := range rows {
+		switch row.Action {
+		case actionCreate:
+			report.Created++
+		case actionUpdate:
+			report.Updated++
+		case actionSkip:
+			report.Skipped++
+		case actionError:
+			report.Errors++
+		}
+	}
+
+	if !report.DryRun {
+		if err := db.WithContext(r.Context()).Transaction(apply); err != nil {
+			status := http.StatusInternalServerError
+			var he *HookError
+			if errors.As(err, &he) {
+				status = http.StatusBadRequest
+			}
+			writeError(w, r, status, "import_failed", "Import failed: "+err.Error())
+			return
+		}
+		notifyImportDone(&report)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const goodreadsFixture = `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies
+3735293,Clean Code,Robert C. Martin,"Martin, Robert C.",,"=""0132350882""","=""9780132350884""",4,4.37,Prentice Hall,Paperback,464,2008,2007,2020/01/02,2019/12/01,"programming, favorites","programming (#1), favorites (#2)",read,Changed how I write functions.,,,1,0
+4099,The Pragmatic Programmer,David Thomas,"Thomas, David",Andrew Hunt,"=""020161622X""","=""9780201616224""",0,4.33,Addison-Wesley,Paperback,352,1999,1999,,2019/12/01,,,to-read,,,,0,0
+1,No Identifier,Someone,"Someone",,"=""""","=""""",3,4.0,,,,2001,2001,,2019/12/01,,,read,,,,0,0
+3735293,Clean Code,Robert C. Martin,"Martin, Robert C.",,"=""0132350882""","=""9780132350884""",5,4.37,,,,2008,2007,,,,,read,,,,1,0
+`
+
+const storyGraphFixture = `Title,Authors,Contributors,ISBN/UID,Format,Read Status,Date Added,Last Date Read,Dates Read,Read Count,Moods,Pace,Character- or Plot-Driven?,Strong Character Development?,Loveable Characters?,Diverse Characters?,Flawed Characters?,Star Rating,Review,Content Warnings,Content Warning Description,Tags,Owned?
+Refactoring,Martin Fowler,,9780201485677,paperback,read,2021/03/04,2021/04/01,2021/03/05-2021/04/01,1,informative,medium,,,,,,4.25,Classic.,,,"programming",Yes
+`
+
+func postImport(t *testing.T, query, body string) (*httptest.ResponseRecorder, ImportReport) {
+	router := setupRouter()
+	req, _ := http.NewRequest("POST", "/api/v1/import/goodreads"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	var report ImportReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	return response, report
+}
+
+func TestImportGoodreadsDryRun(t *testing.T) {
+	clearDB()
+
+	response, report := postImport(t, "?dry_run=true", goodreadsFixture)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	if !report.DryRun || report.Format != formatGoodreads {
+		t.Errorf("Unexpected report header %+v", report)
+	}
+	if report.Created != 2 || report.Errors != 1 || report.Skipped != 1 {
+		t.Errorf("Expected 2 created, 1 error, 1 skipped, got %+v", report)
+	}
+	if got := report.Rows[0].Tags; strings.Join(got, ",") != "programming,favorites,read" {
+		t.Errorf("Unexpected tags %v", got)
+	}
+
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Dry run must not write, found %d books", count)
+	}
+}
+
+func TestImportGoodreads(t *testing.T) {
+	clearDB()
+
+	response, report := postImport(t, "", goodreadsFixture)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	if report.DryRun || report.Created != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	var book Book
+	if err := db.Preload("Tags").Where("isbn = ?", "9780132350884").First(&book).Error; err != nil {
+		t.Fatalf("Expected imported book: %v", err)
+	}
+	if book.Year != 2008 || len(book.Tags) != 3 {
+		t.Errorf("Unexpected imported book %+v", book)
+	}
+
+	var reviews []Review
+	db.Find(&reviews)
+	if len(reviews) != 1 || reviews[0].Rating != 4 || reviews[0].Source != formatGoodreads {
+		t.Errorf("Expected one 4-star review, got %+v", reviews)
+	}
+
+	// Unrated, unreviewed books get no review
+	var pragmatic Book
+	db.Where("isbn = ?", "9780201616224").First(&pragmatic)
+	var n int64
+	db.Model(&Review{}).Where("book_id = ?", pragmatic.ID).Count(&n)
+	if n != 0 {
+		t.Errorf("Expected no review for an unrated book, got %d", n)
+	}
+}
+
+func TestImportStoryGraphUpdatesExisting(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Refactoring", Author: "Martin Fowler", ISBN: "9780201485677", Year: 1999})
+
+	_, report := postImport(t, "", storyGraphFixture)
+	if report.Format != formatStoryGraph || report.Updated != 1 {
+		t.Fatalf("Expected one updated StoryGraph row, got %+v", report)
+	}
+
+	var book Book
+	db.Preload("Tags").Where("isbn = ?", "9780201485677").First(&book)
+	if len(book.Tags) != 2 {
+		t.Errorf("Expected programming and read tags, got %+v", book.Tags)
+	}
+
+	router := setupRouter()
+	req, _ := http.NewRequest("GET", "/api/v1/books/1/reviews", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	var reviews []Review
+	json.Unmarshal(response.Body.Bytes(), &reviews)
+	if len(reviews) != 1 || reviews[0].Rating != 4.25 || reviews[0].Body != "Classic." {
+		t.Errorf("Unexpected reviews %+v", reviews)
+	}
+}
+
+func TestImportGoodreadsMultipart(t *testing.T) {
+	clearDB()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "goodreads_library_export.csv")
+	fw.Write([]byte(goodreadsFixture))
+	mw.Close()
+
+	router := setupRouter()
+	req, _ := http.NewRequest("POST", "/api/v1/import/goodreads?dry_run=true", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestImportRejectsUnknownFormat(t *testing.T) {
+	response, _ := postImport(t, "", "name,size\nfoo,1\n")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Largest page the Google Books API will return
+const googleBooksMaxResults = 40
+
+// googleBooksClient talks to the Google Books volumes API. It also
+// satisfies MetadataProvider so it can back enrichment.
+type googleBooksClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+// Shared client, configured in initMetadata
+var googleBooks *googleBooksClient
+
+func newGoogleBooksClient(baseURL, apiKey string, timeout time.Duration) *googleBooksClient {
+	return &googleBooksClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// GoogleVolume is a search result normalized to our field names
+type GoogleVolume struct {
+	VolumeID    string `json:"volume_id"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	ISBN        string `json:"isbn"`
+	Year        int    `json:"year"`
+	Description string `json:"description"`
+	CoverURL    string `json:"cover_url"`
+	Publisher   string `json:"publisher,omitempty"`
+	PageCount   int    `json:"page_count,omitempty"`
+	Language    string `json:"language,omitempty"`
+}
+
+// GoogleBooksResults is a page of Google Books search results
+type GoogleBooksResults struct {
+	Items []GoogleVolume `json:"items"`
+	Total int            `json:"total"`
+}
+
+// Wire format of a volume resource
+type googleVolumeResource struct {
+	ID         string `json:"id"`
+	VolumeInfo struct {
+		Title               string   `json:"title"`
+		Subtitle            string   `json:"subtitle"`
+		Authors             []string `json:"authors"`
+		PublishedDate       string   `json:"publishedDate"`
+		Description         string   `json:"description"`
+		Publisher           string   `json:"publisher"`
+		PageCount           int      `json:"pageCount"`
+		Language            string   `json:"language"`
+		IndustryIdentifiers []struct {
+			Type       string `json:"type"`
+			Identifier string `json:"identifier"`
+		} `json:"industryIdentifiers"`
+		ImageLinks struct {
+			SmallThumbnail string `json:"smallThumbnail"`
+			Thumbnail      string `json:"thumbnail"`
+		} `json:"imageLinks"`
+	} `json:"volumeInfo"`
+}
+
+func (v googleVolumeResource) normalize() GoogleVolume {
+	info := v.VolumeInfo
+	out := GoogleVolume{
+		VolumeID:    v.ID,
+		Title:       info.Title,
+		Author:      strings.Join(info.Authors, ", "),
+		Year:        parseYear(info.PublishedDate),
+		Description: info.Description,
+		CoverURL:    info.ImageLinks.Thumbnail,
+		Publisher:   info.Publisher,
+		PageCount:   info.PageCount,
+	}
+	// Google tags some volumes with codes like "zh-CN" or "und"
+	if lang := normalizeLanguage(info.Language); validLanguage(lang) {
+		out.Language = lang
+	}
+	if info.Subtitle != "" {
+		out.Title += ": " + info.Subtitle
+	}
+	if out.CoverURL == "" {
+		out.CoverURL = info.ImageLinks.SmallThumbnail
+	}
+	// Google still hands out plain http image links
+	out.CoverURL = strings.Replace(out.CoverURL, "http://", "https://", 1)
+
+	// Prefer ISBN-13 over ISBN-10
+	for _, id := range info.IndustryIdentifiers {
+		switch id.Type {
+		case "ISBN_13":
+			out.ISBN = id.Identifier
+		case "ISBN_10":
+			if out.ISBN == "" {
+				out.ISBN = id.Identifier
+			}
+		}
+	}
+	return out
+}
+
+func (g *googleBooksClient) Name() string {
+	return "googlebooks"
+}
+
+// Issue a GET against the API and decode the JSON response into v
+func (g *googleBooksClient) get(ctx context.Context, path string, q url.Values, v interface{}) error {
+	if wait := g.backoff(); wait > 0 {
+		return &RateLimitError{Provider: g.Name(), RetryAfter: wait}
+	}
+
+	if q == nil {
+		q = url.Values{}
+	}
+	if g.apiKey != "" {
+		q.Set("key", g.apiKey)
+	}
+	u := g.baseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("googlebooks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait := parseRetryAfter(resp.Header.Get("Retry-After"), time.Minute)
+		g.block(wait)
+		return &RateLimitError{Provider: g.Name(), RetryAfter: wait}
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrMetadataNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("googlebooks: unexpected status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("googlebooks: decoding response: %w", err)
+	}
+	return nil
+}
+
+// Search volumes by free-text query
+func (g *googleBooksClient) Search(ctx context.Context, query string, start, max int) ([]GoogleVolume, int, error) {
+	q := url.Values{
+		"q":          {query},
+		"startIndex": {strconv.Itoa(start)},
+		"maxResults": {strconv.Itoa(max)},
+		"printType":  {"books"},
+	}
+
+	var result struct {
+		TotalItems int                    `json:"totalItems"`
+		Items      []googleVolumeResource `json:"items"`
+	}
+	if err := g.get(ctx, "/volumes", q, &result); err != nil {
+		return nil, 0, err
+	}
+
+	volumes := make([]GoogleVolume, 0, len(result.Items))
+	for _, item := range result.Items {
+		volumes = append(volumes, item.normalize())
+	}
+	return volumes, result.TotalItems, nil
+}
+
+// Fetch a single volume by its Google ID
+func (g *googleBooksClient) Volume(ctx context.Context, id string) (*GoogleVolume, error) {
+	var v googleVolumeResource
+	if err := g.get(ctx, "/volumes/"+url.PathEscape(id), nil, &v); err != nil {
+		return nil, err
+	}
+	out := v.normalize()
+	return &out, nil
+}
+
+func (g *googleBooksClient) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	volumes, _, err := g.Search(ctx, "isbn:"+isbn, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) == 0 {
+		return nil, ErrMetadataNotFound
+	}
+	v := volumes[0]
+	return &BookMetadata{
+		Title:       v.Title,
+		Author:      v.Author,
+		Year:        v.Year,
+		Description: v.Description,
+		CoverURL:    v.CoverURL,
+		Publisher:   v.Publisher,
+	}, nil
+}
+
+func (g *googleBooksClient) backoff() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return time.Until(g.blockedUntil)
+}
+
+func (g *googleBooksClient) block(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.blockedUntil = time.Now().Add(d)
+}
+
+// Proxy a Google Books search
+func searchGoogleBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query parameter q is required")
+		return
+	}
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	if start < 0 {
+		start = 0
+	}
+	max, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if max <= 0 || max > googleBooksMaxResults {
+		max = 10
+	}
+
+	volumes, total, err := googleBooks.Search(r.Context(), query, start, max)
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, GoogleBooksResults{Total: total, Items: listOf(volumes)})
+}
+
+// Look up an ISBN on Google Books and return the volume in the book
+// field names, for prefilling an add-book form. Nothing is saved.
+func lookupGoogleBooksISBN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	isbn := strings.ToUpper(cleanISBN(r.URL.Query().Get("isbn")))
+	if isbn == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query parameter isbn is required")
+		return
+	}
+	if !validISBN(isbn) {
+		writeError(w, r, http.StatusBadRequest, "invalid_isbn", "Invalid ISBN")
+		return
+	}
+
+	volumes, _, err := googleBooks.Search(r.Context(), "isbn:"+isbn, 0, 1)
+	if err == nil && len(volumes) == 0 {
+		err = ErrMetadataNotFound
+	}
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	volume := volumes[0]
+	// The form gets the ISBN as the catalog will store it
+	volume.ISBN = toISBN13(isbn)
+	if min, max := cfg.yearRange(); volume.Year < min || volume.Year > max {
+		volume.Year = 0
+	}
+	writeJSON(w, http.StatusOK, volume)
+}
+
+// Create a local book from a Google Books volume
+func createBookFromGoogle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	volume, err := googleBooks.Volume(r.Context(), mux.Vars(r)["volumeId"])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+
+	book := Book{
+		Title:       volume.Title,
+		Author:      volume.Author,
+		ISBN:        volume.ISBN,
+		Description: volume.Description,
+		CoverURL:    volume.CoverURL,
+		PageCount:   volume.PageCount,
+		Language:    volume.Language,
+	}
+	// Drop implausible dates rather than refusing the import
+	if min, max := cfg.yearRange(); volume.Year >= min && volume.Year <= max {
+		book.Year = volume.Year
+	}
+
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	var existing Book
+	if err := db.WithContext(r.Context()).Where("isbn = ?", book.ISBN).First(&existing).Error; err == nil {
+		writeError(w, r, http.StatusConflict, "isbn_exists", "A book with this ISBN already exists")
+		return
+	}
+
+	if err := db.WithContext(r.Context()).Create(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create book")
+		}
+		return
+	}
+	notifyBookAdded(&book)
+
+	writeJSON(w, http.StatusCreated, book)
+}
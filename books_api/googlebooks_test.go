@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const googleVolumeFixture = `{
+  "id": "hjEFCAAAQBAJ",
+  "volumeInfo": {
+    "title": "Clean Code",
+    "subtitle": "A Handbook of Agile Software Craftsmanship",
+    "authors": ["Robert C. Martin"],
+    "publishedDate": "2008-08-01",
+    "description": "Even bad code can function.",
+    "publisher": "Prentice Hall",
+    "pageCount": 464,
+    "language": "en",
+    "industryIdentifiers": [
+      {"type": "ISBN_10", "identifier": "0132350882"},
+      {"type": "ISBN_13", "identifier": "9780132350884"}
+    ],
+    "imageLinks": {"thumbnail": "http://books.google.com/thumb.jpg"}
+  }
+}`
+
+// Point the shared Google Books client at a fake API for one test
+func useGoogleBooksServer(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	saved := googleBooks
+	googleBooks = newGoogleBooksClient(srv.URL, "test-key", time.Second)
+	t.Cleanup(func() {
+		googleBooks = saved
+		srv.Close()
+	})
+}
+
+func TestSearchGoogleBooks(t *testing.T) {
+	router := setupRouter()
+	useGoogleBooksServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/volumes" || r.URL.Query().Get("q") != "clean code" {
+			t.Errorf("Unexpected upstream request %s", r.URL)
+		}
+		if r.URL.Query().Get("key") != "test-key" {
+			t.Error("Expected API key to be forwarded")
+		}
+		w.Write([]byte(`{"totalItems": 1, "items": [` + googleVolumeFixture + `]}`))
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/external/google-books?q=clean+code", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+
+	var body struct {
+		Total int            `json:"total"`
+		Items []GoogleVolume `json:"items"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if body.Total != 1 || len(body.Items) != 1 {
+		t.Fatalf("Expected one result, got %+v", body)
+	}
+	v := body.Items[0]
+	if v.ISBN != "9780132350884" || v.Year != 2008 || v.CoverURL != "https://books.google.com/thumb.jpg" {
+		t.Errorf("Unexpected normalized volume %+v", v)
+	}
+}
+
+func TestSearchGoogleBooksRequiresQuery(t *testing.T) {
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/external/google-books", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestSearchGoogleBooksEmptyResults(t *testing.T) {
+	router := setupRouter()
+	useGoogleBooksServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"totalItems": 0}`))
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/external/google-books?q=zzzz", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if want := `{"items":[],"total":0}`; response.Body.String() != want+"\n" {
+		t.Errorf("Expected %s, got %s", want, response.Body.String())
+	}
+}
+
+func TestLookupISBN(t *testing.T) {
+	router := setupRouter()
+	useGoogleBooksServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "isbn:9780132350884" && r.URL.Query().Get("q") != "isbn:0132350882" {
+			w.Write([]byte(`{"totalItems": 0}`))
+			return
+		}
+		w.Write([]byte(`{"totalItems": 1, "items": [` + googleVolumeFixture + `]}`))
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/lookup?isbn=0-13-235088-2", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var volume GoogleVolume
+	json.Unmarshal(response.Body.Bytes(), &volume)
+	if response.Code != http.StatusOK || volume.ISBN != "9780132350884" || volume.Year != 2008 || volume.Publisher != "Prentice Hall" ||
+		volume.PageCount != 464 || volume.Language != "en" {
+		t.Errorf("Expected the volume as a book, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for isbn, status := range map[string]int{"": http.StatusBadRequest, "9780132350885": http.StatusBadRequest, "9780441013593": http.StatusNotFound} {
+		req, _ := http.NewRequest("GET", "/api/v1/lookup?isbn="+isbn, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != status {
+			t.Errorf("ISBN %q: expected status %d, got %d", isbn, status, response.Code)
+		}
+	}
+}
+
+func TestCreateBookFromGoogle(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	useGoogleBooksServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/volumes/hjEFCAAAQBAJ" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(googleVolumeFixture))
+	})
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/from-google/hjEFCAAAQBAJ", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var created Book
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.ID == 0 || created.ISBN != "9780132350884" || created.Author != "Robert C. Martin" || created.PageCount != 464 {
+		t.Errorf("Unexpected created book %+v", created)
+	}
+
+	// Importing the same volume twice conflicts on ISBN
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 on re-import, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/books/from-google/missing", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown volume, got %d", response.Code)
+	}
+}
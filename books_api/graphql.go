@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A small GraphQL (https://spec.graphql.org) executor for the book schema
+// in graphql_books.go: queries and mutations with arguments, variables,
+// aliases, fragments and @include/@skip, and introspection through
+// __schema and __type (graphql_introspection.go). Subscriptions aren't
+// supported.
+
+// gqlDocument is a parsed request document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	Type       string // "query" or "mutation"
+	Name       string
+	Defaults   map[string]interface{} // variable defaults
+	Selections []gqlSelection
+}
+
+type gqlFragment struct {
+	Selections []gqlSelection
+}
+
+// gqlSelection is a field, a ...Fragment spread or an inline fragment
+type gqlSelection struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Directives map[string]map[string]interface{}
+	Selections []gqlSelection
+	Spread     string // fragment name, for spreads
+	Inline     bool   // for inline fragments, whose Selections apply
+}
+
+// gqlVariable is a $name in an argument value, resolved at execution
+type gqlVariable string
+
+// gqlEnum is an unquoted enum value, such as ASC
+type gqlEnum string
+
+// GraphQLError is one entry of a response's errors. Extensions carry the
+// code problem details would, and a validation failure's fields.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *GraphQLError) Error() string { return e.Message }
+
+func gqlErrorf(format string, args ...interface{}) *GraphQLError {
+	return &GraphQLError{Message: fmt.Sprintf(format, args...)}
+}
+
+// gqlObject is a response object, keeping its fields in selection order
+type gqlObject []gqlMember
+
+type gqlMember struct {
+	Key   string
+	Value interface{}
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.Key)
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Lexing
+
+type gqlToken struct {
+	kind  byte // 'n' name, 'i' int, 'f' float, 's' string, 'p' punctuator, 0 end
+	value string
+}
+
+func gqlLex(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{'p', "..."})
+			i += 3
+		case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+			tokens = append(tokens, gqlToken{'p', string(c)})
+			i++
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, gqlToken{'n', src[i:j]})
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			kind := byte('i')
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || strings.IndexByte(".eE+-", src[j]) >= 0) {
+				if strings.IndexByte(".eE", src[j]) >= 0 {
+					kind = 'f'
+				}
+				j++
+			}
+			tokens = append(tokens, gqlToken{kind, src[i:j]})
+			i = j
+		case c == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				return nil, gqlErrorf("Syntax error: block strings are not supported")
+			}
+			s, n, err := gqlLexString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, gqlToken{'s', s})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, gqlErrorf("Syntax error: unexpected character %q", r)
+		}
+	}
+	return append(tokens, gqlToken{}), nil
+}
+
+// Read the quoted string at the start of src, returning its value and
+// length
+func gqlLexString(src string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, gqlErrorf("Syntax error: unterminated string")
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, gqlErrorf("Syntax error: unterminated string")
+			}
+			i++
+			switch esc := src[i]; esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, gqlErrorf("Syntax error: invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(src[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, gqlErrorf("Syntax error: invalid unicode escape")
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			default:
+				return "", 0, gqlErrorf("Syntax error: invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, gqlErrorf("Syntax error: unterminated string")
+}
+
+// Parsing
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+func (p *gqlParser) peek() gqlToken { return p.tokens[p.pos] }
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != 0 {
+		p.pos++
+	}
+	return t
+}
+
+// Whether the next token is the punctuator s, consuming it if so
+func (p *gqlParser) skip(s string) bool {
+	if t := p.peek(); t.kind == 'p' && t.value == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(s string) error {
+	if !p.skip(s) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.peek().kind != 'n' {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func (p *gqlParser) unexpected() *GraphQLError {
+	t := p.peek()
+	if t.kind == 0 {
+		return gqlErrorf("Syntax error: unexpected end of document")
+	}
+	return gqlErrorf("Syntax error: unexpected %q", t.value)
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := &gqlDocument{Fragments: map[string]*gqlFragment{}}
+	for p.peek().kind != 0 {
+		t := p.peek()
+		switch {
+		case t.kind == 'p' && t.value == "{":
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &gqlOperation{Type: "query", Selections: sels})
+		case t.kind == 'n' && (t.value == "query" || t.value == "mutation" || t.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case t.kind == 'n' && t.value == "fragment":
+			p.next()
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if on, err := p.name(); err != nil || on != "on" {
+				return nil, gqlErrorf("Syntax error: expected \"on\" after fragment %s", name)
+			}
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments[name] = &gqlFragment{Selections: sels}
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, gqlErrorf("Document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{Type: p.next().value, Defaults: map[string]interface{}{}}
+	if p.peek().kind == 'n' {
+		op.Name = p.next().value
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if err := p.typeRef(); err != nil {
+				return nil, err
+			}
+			if p.skip("=") {
+				v, err := p.value(true)
+				if err != nil {
+					return nil, err
+				}
+				op.Defaults[name] = v
+			}
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = sels
+	return op, nil
+}
+
+// Skip a variable's type, such as [String!]!. Values are checked when the
+// schema reads its arguments.
+func (p *gqlParser) typeRef() error {
+	if p.skip("[") {
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	p.skip("!")
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.skip("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, gqlErrorf("Syntax error: empty selection set")
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+	if p.skip("...") {
+		if t := p.peek(); t.kind == 'n' && t.value != "on" {
+			sel.Spread = p.next().value
+			sel.Directives, err = p.directives()
+			return sel, err
+		}
+		if t := p.peek(); t.kind == 'n' && t.value == "on" {
+			p.next()
+			if _, err := p.name(); err != nil {
+				return sel, err
+			}
+		}
+		sel.Inline = true
+		if sel.Directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.Selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.Name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.skip(":") {
+		sel.Alias = sel.Name
+		if sel.Name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.Args, err = p.arguments(); err != nil {
+		return sel, err
+	}
+	if sel.Directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if t := p.peek(); t.kind == 'p' && t.value == "{" {
+		sel.Selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if !p.skip("(") {
+		return args, nil
+	}
+	for !p.skip(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func (p *gqlParser) directives() (map[string]map[string]interface{}, error) {
+	var dirs map[string]map[string]interface{}
+	for p.skip("@") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		if dirs == nil {
+			dirs = map[string]map[string]interface{}{}
+		}
+		dirs[name] = args
+	}
+	return dirs, nil
+}
+
+// Parse a value: a literal, a list, an input object or, unless const, a
+// $variable
+func (p *gqlParser) value(isConst bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case 'i':
+		n, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, gqlErrorf("Syntax error: invalid number %s", t.value)
+		}
+		return n, nil
+	case 'f':
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, gqlErrorf("Syntax error: invalid number %s", t.value)
+		}
+		return f, nil
+	case 's':
+		return t.value, nil
+	case 'n':
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(t.value), nil
+	case 'p':
+		switch t.value {
+		case "$":
+			if isConst {
+				break
+			}
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.skip("]") {
+				v, err := p.value(isConst)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			obj := map[string]interface{}{}
+			for !p.skip("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(isConst); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+	}
+	if t.kind != 0 {
+		p.pos--
+	}
+	return nil, p.unexpected()
+}
+
+// Execution
+
+// Replace the variables in an argument value with their values
+func gqlResolveValue(v interface{}, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVariable:
+		return vars[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = gqlResolveValue(item, vars)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = gqlResolveValue(item, vars)
+		}
+		return out
+	}
+	return v
+}
+
+// The operation a request names, or its only one
+func (doc *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, gqlErrorf("operationName is required for a document with several operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, gqlErrorf("Unknown operation %q", name)
+}
+
+// The fields a selection set selects, with fragments expanded and
+// @include/@skip applied
+func (doc *gqlDocument) fields(sels []gqlSelection, vars map[string]interface{}, seen map[string]bool) ([]gqlSelection, error) {
+	var fields []gqlSelection
+	for _, sel := range sels {
+		if skip, ok := sel.Directives["skip"]; ok && gqlResolveValue(skip["if"], vars) == true {
+			continue
+		}
+		if include, ok := sel.Directives["include"]; ok && gqlResolveValue(include["if"], vars) != true {
+			continue
+		}
+		switch {
+		case sel.Spread != "":
+			frag, ok := doc.Fragments[sel.Spread]
+			if !ok {
+				return nil, gqlErrorf("Unknown fragment %q", sel.Spread)
+			}
+			if seen[sel.Spread] {
+				return nil, gqlErrorf("Fragment %q spreads itself", sel.Spread)
+			}
+			seen[sel.Spread] = true
+			more, err := doc.fields(frag.Selections, vars, seen)
+			delete(seen, sel.Spread)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, more...)
+		case sel.Inline:
+			more, err := doc.fields(sel.Selections, vars, seen)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, more...)
+		default:
+			fields = append(fields, sel)
+		}
+	}
+	return fields, nil
+}
+
+// The key a field's value is reported under
+func (sel gqlSelection) key() string {
+	if sel.Alias != "" {
+		return sel.Alias
+	}
+	return sel.Name
+}
+
+// gqlField is a field of an object type in the schema. Type names the
+// object type of its value, or of its items for lists, and is empty for
+// scalars. Resolve reads the field from its parent object.
+type gqlField struct {
+	Type    string
+	Args    []string
+	Resolve func(ctx *gqlContext, parent interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// gqlType is an object type: its fields by name
+type gqlType map[string]gqlField
+
+// gqlContext carries one request's execution
+type gqlContext struct {
+	r      *http.Request
+	doc    *gqlDocument
+	vars   map[string]interface{}
+	errors []*GraphQLError
+}
+
+// GraphQLResponse is the body of every GraphQL response
+type GraphQLResponse struct {
+	Data   interface{}     `json:"data,omitempty"`
+	Errors []*GraphQLError `json:"errors,omitempty"`
+}
+
+// Check the operation's selections against schema before running any of
+// it, so an invalid document fails whole rather than halfway through a
+// mutation
+func (ctx *gqlContext) validate(schema map[string]gqlType, typeName string, sels []gqlSelection) error {
+	fields, err := ctx.doc.fields(sels, ctx.vars, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	for _, sel := range fields {
+		if sel.Name == "__typename" {
+			continue
+		}
+		field, ok := schema[typeName][sel.Name]
+		if !ok {
+			return gqlErrorf("Cannot query field %q on type %q", sel.Name, typeName)
+		}
+		for name := range sel.Args {
+			if !containsString(field.Args, name) {
+				return gqlErrorf("Unknown argument %q on field %q", name, sel.Name)
+			}
+		}
+		switch {
+		case field.Type == "" && sel.Selections != nil:
+			return gqlErrorf("Field %q is a scalar and has no selections", sel.Name)
+		case field.Type != "" && sel.Selections == nil:
+			return gqlErrorf("Field %q of type %q must have a selection of subfields", sel.Name, field.Type)
+		case field.Type != "":
+			if err := ctx.validate(schema, field.Type, sel.Selections); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve the selections on parent, an object of type typeName. A field
+// that fails is null and reports an error at its path; the rest still
+// resolve.
+func (ctx *gqlContext) execute(schema map[string]gqlType, typeName string, parent interface{}, sels []gqlSelection, path []interface{}) gqlObject {
+	fields, _ := ctx.doc.fields(sels, ctx.vars, map[string]bool{})
+	var out gqlObject
+	for _, sel := range fields {
+		fieldPath := append(append([]interface{}{}, path...), sel.key())
+		if sel.Name == "__typename" {
+			out = append(out, gqlMember{sel.key(), typeName})
+			continue
+		}
+		field := schema[typeName][sel.Name]
+		args := gqlResolveValue(sel.Args, ctx.vars).(map[string]interface{})
+		value, err := field.Resolve(ctx, parent, args)
+		if err != nil {
+			ctx.fail(err, fieldPath)
+			out = append(out, gqlMember{sel.key(), nil})
+			continue
+		}
+		out = append(out, gqlMember{sel.key(), ctx.complete(schema, field.Type, value, sel.Selections, fieldPath)})
+	}
+	return out
+}
+
+// Complete a resolved value: objects and lists of them resolve their
+// selections, scalars are returned as they are
+func (ctx *gqlContext) complete(schema map[string]gqlType, typeName string, value interface{}, sels []gqlSelection, path []interface{}) interface{} {
+	if typeName == "" || value == nil {
+		return value
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			item := v.Index(i)
+			if item.Kind() != reflect.Ptr && item.CanAddr() {
+				item = item.Addr()
+			}
+			items[i] = ctx.complete(schema, typeName, item.Interface(), sels, append(append([]interface{}{}, path...), i))
+		}
+		return items
+	}
+	return ctx.execute(schema, typeName, value, sels, path)
+}
+
+// Record a field's error at path
+func (ctx *gqlContext) fail(err error, path []interface{}) {
+	var ge *GraphQLError
+	if !errors.As(err, &ge) {
+		ge = &GraphQLError{Message: err.Error()}
+	}
+	e := *ge
+	e.Path = path
+	ctx.errors = append(ctx.errors, &e)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Argument readers. Literals parse to int64 and float64, and JSON
+// variables decode to json.Number.
+
+func gqlArgInt(args map[string]interface{}, name string) (int, bool, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return 0, false, nil
+	case int64:
+		return int(v), true, nil
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			return n, true, nil
+		}
+	}
+	return 0, false, gqlErrorf("Argument %q must be an Int", name)
+}
+
+func gqlArgString(args map[string]interface{}, name string) (string, bool, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	}
+	return "", false, gqlErrorf("Argument %q must be a String", name)
+}
+
+// An ID argument, given as a string or an integer
+func gqlArgID(args map[string]interface{}, name string) (uint, error) {
+	var s string
+	switch v := args[name].(type) {
+	case nil:
+		return 0, gqlErrorf("Argument %q is required", name)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case json.Number:
+		s = v.String()
+	}
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, gqlErrorf("Argument %q must be an ID", name)
+	}
+	return uint(id), nil
+}
+
+// Run a request's document against schema. Syntax and validation errors
+// are returned; field errors are in the response.
+func executeGraphQL(r *http.Request, schema map[string]gqlType, query, operationName string, vars map[string]interface{}) (*GraphQLResponse, error) {
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	op, err := doc.operation(operationName)
+	if err != nil {
+		return nil, err
+	}
+	root := map[string]string{"query": "Query", "mutation": "Mutation"}[op.Type]
+	if root == "" {
+		return nil, gqlErrorf("%s operations are not supported", op.Type)
+	}
+	if r.Method == "GET" && op.Type != "query" {
+		return nil, gqlErrorf("Mutations must be sent with POST")
+	}
+
+	ctx := &gqlContext{r: r, doc: doc, vars: map[string]interface{}{}}
+	for name, v := range op.Defaults {
+		ctx.vars[name] = gqlResolveValue(v, nil)
+	}
+	for name, v := range vars {
+		ctx.vars[name] = v
+	}
+	if err := ctx.validate(schema, root, op.Selections); err != nil {
+		return nil, err
+	}
+	data := ctx.execute(schema, root, nil, op.Selections, nil)
+	return &GraphQLResponse{Data: data, Errors: ctx.errors}, nil
+}
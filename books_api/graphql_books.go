@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Most a GraphQL request body may hold
+const maxGraphQLBytes = 1 << 20
+
+// Scalar fields of Book in the schema
+var gqlBookFields = map[string]func(b *Book) interface{}{
+	"id":            func(b *Book) interface{} { return gqlID(b.ID) },
+	"title":         func(b *Book) interface{} { return b.Title },
+	"author":        func(b *Book) interface{} { return b.Author },
+	"isbn":          func(b *Book) interface{} { return b.ISBN },
+	"year":          func(b *Book) interface{} { return b.Year },
+	"description":   func(b *Book) interface{} { return b.Description },
+	"coverUrl":      func(b *Book) interface{} { return b.CoverURL },
+	"priceCents":    func(b *Book) interface{} { return b.PriceCents },
+	"averageRating": func(b *Book) interface{} { return b.AverageRating },
+	"reviewCount":   func(b *Book) interface{} { return b.ReviewCount },
+}
+
+// Fields of BookInput, mapped to the JSON names of Book
+var gqlBookInputFields = map[string]string{
+	"title":       "title",
+	"author":      "author",
+	"isbn":        "isbn",
+	"year":        "year",
+	"description": "description",
+	"coverUrl":    "cover_url",
+	"priceCents":  "price_cents",
+}
+
+// The schema /graphql serves, which introspection reports.
+// TestGraphQLSchemaMatchesSDL checks bookSchema against it.
+const bookSDL = `
+type Query {
+  book(id: ID!): Book
+  "A page of books, filtered and sorted like GET /api/v1/books"
+  books(author: String, title: String, yearMin: Int, yearMax: Int,
+        q: String, sort: String, first: Int, after: String): BookConnection!
+  "Authors with books in the catalog, by name"
+  authors: [Author!]!
+}
+
+type Mutation {
+  createBook(input: BookInput!): Book!
+  "Merges input into the book: given fields change and null clears one"
+  updateBook(id: ID!, input: BookInput!): Book!
+  "Moves the book to the trash, returning its ID"
+  deleteBook(id: ID!): ID!
+}
+
+type Book {
+  id: ID!
+  title: String!
+  author: String!
+  isbn: String!
+  year: Int!
+  description: String!
+  coverUrl: String!
+  priceCents: Int!
+  "The mean of the book's ratings, 0 when it has none"
+  averageRating: Float!
+  reviewCount: Int!
+  tags: [Tag!]!
+}
+
+type Tag {
+  id: ID!
+  name: String!
+}
+
+type BookConnection {
+  items: [Book!]!
+  totalCount: Int!
+  nextCursor: String
+  prevCursor: String
+}
+
+type Author {
+  id: ID!
+  name: String!
+  bio: String!
+  bookCount: Int!
+}
+
+input BookInput {
+  title: String
+  author: String
+  isbn: String
+  year: Int
+  description: String
+  coverUrl: String
+  priceCents: Int
+}
+`
+
+var bookSchema = withIntrospection(map[string]gqlType{
+	"Query": {
+		"book":    {Type: "Book", Args: []string{"id"}, Resolve: resolveBook},
+		"books":   {Type: "BookConnection", Args: []string{"author", "title", "yearMin", "yearMax", "q", "sort", "first", "after"}, Resolve: resolveBooks},
+		"authors": {Type: "Author", Resolve: resolveAuthors},
+	},
+	"Mutation": {
+		"createBook": {Type: "Book", Args: []string{"input"}, Resolve: resolveCreateBook},
+		"updateBook": {Type: "Book", Args: []string{"id", "input"}, Resolve: resolveUpdateBook},
+		"deleteBook": {Args: []string{"id"}, Resolve: resolveDeleteBook},
+	},
+	"Book": gqlBookType(),
+	"Tag": {
+		"id":   gqlRead(func(t *Tag) interface{} { return gqlID(t.ID) }),
+		"name": gqlRead(func(t *Tag) interface{} { return t.Name }),
+	},
+	"BookConnection": {
+		"items":      gqlObjects("Book", func(c *BookConnection) interface{} { return listOf(c.Items) }),
+		"totalCount": gqlRead(func(c *BookConnection) interface{} { return c.TotalCount }),
+		"nextCursor": gqlRead(func(c *BookConnection) interface{} { return gqlOptional(c.NextCursor) }),
+		"prevCursor": gqlRead(func(c *BookConnection) interface{} { return gqlOptional(c.PrevCursor) }),
+	},
+	"Author": {
+		"id":        gqlRead(func(a *Author) interface{} { return gqlID(a.ID) }),
+		"name":      gqlRead(func(a *Author) interface{} { return a.Name }),
+		"bio":       gqlRead(func(a *Author) interface{} { return a.Bio }),
+		"bookCount": gqlRead(func(a *Author) interface{} { return a.BookCount }),
+	},
+}, bookSDL)
+
+func gqlBookType() gqlType {
+	t := gqlType{
+		"tags": gqlObjects("Tag", func(b *Book) interface{} { return listOf(b.Tags) }),
+	}
+	for name, read := range gqlBookFields {
+		t[name] = gqlRead(read)
+	}
+	return t
+}
+
+// A scalar field read from its parent, a *T
+func gqlRead[T any](read func(*T) interface{}) gqlField {
+	return gqlObjects("", read)
+}
+
+// A field of object type typeName, or a list of them, read from its
+// parent, a *T
+func gqlObjects[T any](typeName string, read func(*T) interface{}) gqlField {
+	return gqlField{Type: typeName, Resolve: func(_ *gqlContext, parent interface{}, _ map[string]interface{}) (interface{}, error) {
+		return read(parent.(*T)), nil
+	}}
+}
+
+// IDs are strings in GraphQL
+func gqlID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// s, or null when empty
+func gqlOptional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// An error with the code problem details would carry
+func gqlCodeError(code, message string) *GraphQLError {
+	return &GraphQLError{Message: message, Extensions: map[string]interface{}{"code": code}}
+}
+
+func resolveBook(ctx *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	id, err := gqlArgID(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := db.WithContext(ctx.r.Context()).Preload("Tags").First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, gqlCodeError("internal_error", "Failed to read book")
+	}
+	return &book, nil
+}
+
+// List a page of books, with the filters, sort and cursors of the REST
+// listing: first is its limit and after its cursor
+func resolveBooks(ctx *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	q := url.Values{}
+	for arg, param := range map[string]string{"author": "author", "title": "title", "q": "q", "sort": "sort", "after": "cursor"} {
+		v, ok, err := gqlArgString(args, arg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			q.Set(param, v)
+		}
+	}
+	for arg, param := range map[string]string{"yearMin": "year_min", "yearMax": "year_max"} {
+		v, ok, err := gqlArgInt(args, arg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			q.Set(param, strconv.Itoa(v))
+		}
+	}
+	limit, ok, err := gqlArgInt(args, "first")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		limit = defaultPageSize
+	}
+	if limit < 1 || limit > maxPageSize {
+		return nil, gqlCodeError("invalid_parameter", "first must be between 1 and 100")
+	}
+
+	conn, err := listBookPage(ctx.r.Context(), q, limit)
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return nil, gqlCodeError(ce.Code, ce.Message)
+	}
+	if err != nil {
+		return nil, gqlCodeError("internal_error", "Failed to list books")
+	}
+	return conn, nil
+}
+
+func resolveAuthors(ctx *gqlContext, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+	authors, err := listAuthors(ctx.r.Context(), false)
+	if err != nil {
+		return nil, gqlCodeError("internal_error", "Failed to list authors")
+	}
+	return listOf(authors), nil
+}
+
+// Mutations change the catalog, so need librarian like the REST writes
+func gqlRequireLibrarian(ctx *gqlContext) error {
+	if !authEnabled() {
+		return nil
+	}
+	p := principalFrom(ctx.r)
+	if p == nil {
+		return gqlCodeError("authentication_required", "Authentication required")
+	}
+	if !p.HasRole(roleLibrarian) {
+		return gqlCodeError("forbidden", "Forbidden")
+	}
+	return nil
+}
+
+// Merge a BookInput into a book's fields as a merge patch: given fields
+// replace the book's and null clears them
+func applyBookInput(book *Book, args map[string]interface{}) error {
+	input, ok := args["input"].(map[string]interface{})
+	if !ok {
+		return gqlErrorf("Argument %q must be a BookInput", "input")
+	}
+	patch := map[string]interface{}{}
+	for name, v := range input {
+		field, ok := gqlBookInputFields[name]
+		if !ok {
+			return gqlErrorf("BookInput has no field %q", name)
+		}
+		if n, ok := v.(int64); ok {
+			v = json.Number(strconv.FormatInt(n, 10))
+		}
+		patch[field] = v
+	}
+
+	data, _ := json.Marshal(book)
+	var doc interface{}
+	decodeJSONNumbers(data, &doc)
+	data, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return gqlErrorf("Invalid BookInput")
+	}
+	id := book.ID
+	var merged Book
+	if err := decodeJSONNumbers(data, &merged); err != nil {
+		return gqlErrorf("Invalid BookInput: a field has the wrong type")
+	}
+	*book = merged
+	book.ID = id
+	return nil
+}
+
+// The error a failed write reports: a hook's refusal with its code, or
+// fallback
+func gqlWriteError(err error, fallback string) *GraphQLError {
+	var he *HookError
+	if !errors.As(err, &he) {
+		return gqlCodeError("internal_error", fallback)
+	}
+	if len(he.Fields) > 0 {
+		return gqlValidationError(he.Fields)
+	}
+	code := he.Code
+	if code == "" {
+		code = "rejected"
+	}
+	return gqlCodeError(code, he.Message)
+}
+
+func gqlValidationError(errs []FieldError) *GraphQLError {
+	e := gqlCodeError("validation_failed", (&HookError{Fields: errs}).Error())
+	e.Extensions["fields"] = errs
+	return e
+}
+
+func resolveCreateBook(ctx *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	if err := gqlRequireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := applyBookInput(&book, args); err != nil {
+		return nil, err
+	}
+	if errs := validateBook(&book); len(errs) > 0 {
+		return nil, gqlValidationError(errs)
+	}
+	if err := db.WithContext(ctx.r.Context()).Create(&book).Error; err != nil {
+		return nil, gqlWriteError(err, "Failed to create book")
+	}
+	notifyBookAdded(&book)
+	return &book, nil
+}
+
+func resolveUpdateBook(ctx *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	if err := gqlRequireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	id, err := gqlArgID(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := db.WithContext(ctx.r.Context()).Preload("Tags").First(&book, id).Error; err != nil {
+		return nil, gqlCodeError("book_not_found", "Book not found")
+	}
+	tags := book.Tags
+	if err := applyBookInput(&book, args); err != nil {
+		return nil, err
+	}
+	book.Tags = nil
+	if errs := validateBook(&book); len(errs) > 0 {
+		return nil, gqlValidationError(errs)
+	}
+	if err := db.WithContext(ctx.r.Context()).Save(&book).Error; err != nil {
+		return nil, gqlWriteError(err, "Failed to update book")
+	}
+	book.Tags = tags
+	return &book, nil
+}
+
+func resolveDeleteBook(ctx *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	if err := gqlRequireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	id, err := gqlArgID(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := db.WithContext(ctx.r.Context()).First(&book, id).Error; err != nil {
+		return nil, gqlCodeError("book_not_found", "Book not found")
+	}
+	err = db.WithContext(ctx.r.Context()).Transaction(func(tx *gorm.DB) error {
+		return deleteBookTx(tx, &book)
+	})
+	if err != nil {
+		return nil, gqlWriteError(err, "Failed to delete book")
+	}
+	return gqlID(book.ID), nil
+}
+
+// graphQLRequest is the JSON body of a POST to /graphql
+type graphQLRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+// Serve GraphQL over HTTP: a GET with ?query=&variables=&operationName=
+// for queries, or a POST with a JSON body of the same, or the bare
+// document as application/graphql. Requests that can't run at all are a
+// 400; field errors are reported beside the data with a 200.
+func serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req graphQLRequest
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			req.Variables = json.RawMessage(v)
+		}
+	} else {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBytes))
+		if err != nil {
+			writeGraphQLError(w, gqlErrorf("Request body is too large"))
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/graphql" {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			writeGraphQLError(w, gqlErrorf("Request body must be a JSON object with a query"))
+			return
+		}
+	}
+	if req.Query == "" {
+		writeGraphQLError(w, gqlErrorf("Missing query"))
+		return
+	}
+	var vars map[string]interface{}
+	if len(req.Variables) > 0 {
+		if err := decodeJSONNumbers(req.Variables, &vars); err != nil {
+			writeGraphQLError(w, gqlErrorf("variables must be a JSON object"))
+			return
+		}
+	}
+
+	resp, err := executeGraphQL(r, bookSchema, req.Query, req.OperationName, vars)
+	if err != nil {
+		var ge *GraphQLError
+		errors.As(err, &ge)
+		writeGraphQLError(w, ge)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Answer a request that couldn't run with its error and no data
+func writeGraphQLError(w http.ResponseWriter, err *GraphQLError) {
+	writeJSON(w, http.StatusBadRequest, GraphQLResponse{Errors: []*GraphQLError{err}})
+}
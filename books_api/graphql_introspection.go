@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Introspection (https://spec.graphql.org/October2021/#sec-Introspection)
+// for GraphiQL, Apollo and code generators. The types a schema reports come
+// from its SDL, parsed once at startup; the executor's resolvers are
+// checked against the same SDL by TestGraphQLSchemaMatchesSDL.
+
+// The introspection types themselves, as the spec defines them
+const introspectionSDL = `
+type __Schema {
+  description: String
+  types: [__Type!]!
+  queryType: __Type!
+  mutationType: __Type
+  subscriptionType: __Type
+  directives: [__Directive!]!
+}
+
+type __Type {
+  kind: __TypeKind!
+  name: String
+  description: String
+  specifiedByURL: String
+  fields(includeDeprecated: Boolean = false): [__Field!]
+  interfaces: [__Type!]
+  possibleTypes: [__Type!]
+  enumValues(includeDeprecated: Boolean = false): [__EnumValue!]
+  inputFields(includeDeprecated: Boolean = false): [__InputValue!]
+  ofType: __Type
+  isOneOf: Boolean
+}
+
+enum __TypeKind { SCALAR OBJECT INTERFACE UNION ENUM INPUT_OBJECT LIST NON_NULL }
+
+type __Field {
+  name: String!
+  description: String
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  type: __Type!
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __InputValue {
+  name: String!
+  description: String
+  type: __Type!
+  defaultValue: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __EnumValue {
+  name: String!
+  description: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __Directive {
+  name: String!
+  description: String
+  locations: [__DirectiveLocation!]!
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  isRepeatable: Boolean!
+}
+
+enum __DirectiveLocation {
+  QUERY MUTATION SUBSCRIPTION FIELD FRAGMENT_DEFINITION FRAGMENT_SPREAD
+  INLINE_FRAGMENT VARIABLE_DEFINITION SCHEMA SCALAR OBJECT FIELD_DEFINITION
+  ARGUMENT_DEFINITION INTERFACE UNION ENUM ENUM_VALUE INPUT_OBJECT
+  INPUT_FIELD_DEFINITION
+}
+
+"Leaves this field out when the argument is true"
+directive @skip(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+
+"Includes this field only when the argument is true"
+directive @include(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+`
+
+// The built-in scalars, added to a schema when its SDL uses them
+var gqlBuiltinScalars = []string{"ID", "String", "Int", "Float", "Boolean"}
+
+// gqlSchemaDef is a schema as introspection reports it
+type gqlSchemaDef struct {
+	Types      []*gqlTypeDef
+	Directives []*gqlDirectiveDef
+	byName     map[string]*gqlTypeDef
+}
+
+// gqlTypeDef is a named type, or a LIST or NON_NULL wrapping OfType
+type gqlTypeDef struct {
+	Kind        string
+	Name        string
+	Description string
+	Fields      []*gqlFieldDef
+	InputFields []*gqlInputValueDef
+	EnumValues  []*gqlEnumValueDef
+	OfType      *gqlTypeDef
+}
+
+type gqlFieldDef struct {
+	Name        string
+	Description string
+	Args        []*gqlInputValueDef
+	Type        *gqlTypeDef
+}
+
+type gqlInputValueDef struct {
+	Name         string
+	Description  string
+	Type         *gqlTypeDef
+	DefaultValue *string
+}
+
+type gqlEnumValueDef struct {
+	Name        string
+	Description string
+}
+
+type gqlDirectiveDef struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []*gqlInputValueDef
+}
+
+// The named type under any LIST and NON_NULL wrappers
+func (t *gqlTypeDef) named() *gqlTypeDef {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t
+}
+
+func (t *gqlTypeDef) field(name string) *gqlFieldDef {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Parsing SDL: type, input, enum and scalar definitions, directives, and
+// descriptions in quotes. Interfaces, unions and extensions aren't used.
+
+func parseGraphQLSDL(src string) (*gqlSchemaDef, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	schema := &gqlSchemaDef{byName: map[string]*gqlTypeDef{}}
+	for p.peek().kind != 0 {
+		description := p.description()
+		keyword, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if keyword == "directive" {
+			d, err := p.directiveDef()
+			if err != nil {
+				return nil, err
+			}
+			d.Description = description
+			schema.Directives = append(schema.Directives, d)
+			continue
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t := &gqlTypeDef{Name: name, Description: description}
+		switch keyword {
+		case "type":
+			t.Kind = "OBJECT"
+			err = p.block(func() error {
+				f, err := p.fieldDef()
+				t.Fields = append(t.Fields, f)
+				return err
+			})
+		case "input":
+			t.Kind = "INPUT_OBJECT"
+			err = p.block(func() error {
+				v, err := p.inputValueDef()
+				t.InputFields = append(t.InputFields, v)
+				return err
+			})
+		case "enum":
+			t.Kind = "ENUM"
+			err = p.block(func() error {
+				v := &gqlEnumValueDef{Description: p.description()}
+				name, err := p.name()
+				v.Name = name
+				t.EnumValues = append(t.EnumValues, v)
+				return err
+			})
+		case "scalar":
+			t.Kind = "SCALAR"
+		default:
+			return nil, gqlErrorf("Syntax error: unsupported definition %q", keyword)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if schema.byName[name] != nil {
+			return nil, gqlErrorf("Type %q is defined twice", name)
+		}
+		schema.Types = append(schema.Types, t)
+		schema.byName[name] = t
+	}
+	return schema, schema.link()
+}
+
+// A quoted description, or "" when the next token isn't one
+func (p *gqlParser) description() string {
+	if p.peek().kind == 's' {
+		return p.next().value
+	}
+	return ""
+}
+
+// Call item for each definition between braces
+func (p *gqlParser) block(item func() error) error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.skip("}") {
+		if err := item(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gqlParser) fieldDef() (*gqlFieldDef, error) {
+	f := &gqlFieldDef{Description: p.description()}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Args, err = p.argumentDefs(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f.Type, err = p.typeDef()
+	return f, err
+}
+
+func (p *gqlParser) argumentDefs() ([]*gqlInputValueDef, error) {
+	args := []*gqlInputValueDef{}
+	if !p.skip("(") {
+		return args, nil
+	}
+	for !p.skip(")") {
+		v, err := p.inputValueDef()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
+func (p *gqlParser) inputValueDef() (*gqlInputValueDef, error) {
+	v := &gqlInputValueDef{Description: p.description()}
+	var err error
+	if v.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if v.Type, err = p.typeDef(); err != nil {
+		return nil, err
+	}
+	if p.skip("=") {
+		value, err := p.value(true)
+		if err != nil {
+			return nil, err
+		}
+		s := gqlFormatValue(value)
+		v.DefaultValue = &s
+	}
+	return v, nil
+}
+
+// A type reference such as [Tag!]!. Named types are placeholders until
+// link replaces them with their definitions.
+func (p *gqlParser) typeDef() (*gqlTypeDef, error) {
+	var t *gqlTypeDef
+	if p.skip("[") {
+		of, err := p.typeDef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &gqlTypeDef{Kind: "LIST", OfType: of}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t = &gqlTypeDef{Name: name}
+	}
+	if p.skip("!") {
+		t = &gqlTypeDef{Kind: "NON_NULL", OfType: t}
+	}
+	return t, nil
+}
+
+func (p *gqlParser) directiveDef() (*gqlDirectiveDef, error) {
+	if err := p.expect("@"); err != nil {
+		return nil, err
+	}
+	d := &gqlDirectiveDef{}
+	var err error
+	if d.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if d.Args, err = p.argumentDefs(); err != nil {
+		return nil, err
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return nil, gqlErrorf("Syntax error: expected \"on\" after directive @%s", d.Name)
+	}
+	for {
+		p.skip("|")
+		location, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d.Locations = append(d.Locations, location)
+		if t := p.peek(); t.kind != 'p' || t.value != "|" {
+			return d, nil
+		}
+	}
+}
+
+// A default value as GraphQL source, which is how introspection reports it
+func gqlFormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case gqlEnum:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// Replace the named type placeholders with their definitions, adding the
+// built-in scalars the schema uses
+func (s *gqlSchemaDef) link() error {
+	var resolve func(t *gqlTypeDef) (*gqlTypeDef, error)
+	resolve = func(t *gqlTypeDef) (*gqlTypeDef, error) {
+		if t.OfType != nil {
+			of, err := resolve(t.OfType)
+			return &gqlTypeDef{Kind: t.Kind, OfType: of}, err
+		}
+		if def, ok := s.byName[t.Name]; ok {
+			return def, nil
+		}
+		if !containsString(gqlBuiltinScalars, t.Name) {
+			return nil, gqlErrorf("Unknown type %q", t.Name)
+		}
+		def := &gqlTypeDef{Kind: "SCALAR", Name: t.Name}
+		s.Types = append(s.Types, def)
+		s.byName[t.Name] = def
+		return def, nil
+	}
+	resolveValues := func(values []*gqlInputValueDef) error {
+		for _, v := range values {
+			var err error
+			if v.Type, err = resolve(v.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Scalars are appended as they are found, so range over a copy
+	for _, t := range append([]*gqlTypeDef{}, s.Types...) {
+		for _, f := range t.Fields {
+			var err error
+			if f.Type, err = resolve(f.Type); err != nil {
+				return err
+			}
+			if err := resolveValues(f.Args); err != nil {
+				return err
+			}
+		}
+		if err := resolveValues(t.InputFields); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.Directives {
+		if err := resolveValues(d.Args); err != nil {
+			return err
+		}
+	}
+	if s.byName["Query"] == nil {
+		return gqlErrorf("Schema has no Query type")
+	}
+	return nil
+}
+
+// Execution: the introspection types as object types of the executor
+
+// A list, or null when the type kind doesn't have one
+func gqlListFor[T any](t *gqlTypeDef, kind string, list []T) interface{} {
+	if t.Kind != kind {
+		return nil
+	}
+	return listOf(list)
+}
+
+var introspectionTypes = map[string]gqlType{
+	"__Schema": {
+		"description":      gqlRead(func(s *gqlSchemaDef) interface{} { return nil }),
+		"types":            gqlObjects("__Type", func(s *gqlSchemaDef) interface{} { return s.Types }),
+		"queryType":        gqlObjects("__Type", func(s *gqlSchemaDef) interface{} { return s.byName["Query"] }),
+		"mutationType":     gqlObjects("__Type", func(s *gqlSchemaDef) interface{} { return s.byName["Mutation"] }),
+		"subscriptionType": gqlObjects("__Type", func(s *gqlSchemaDef) interface{} { return nil }),
+		"directives":       gqlObjects("__Directive", func(s *gqlSchemaDef) interface{} { return s.Directives }),
+	},
+	"__Type": {
+		"kind":           gqlRead(func(t *gqlTypeDef) interface{} { return t.Kind }),
+		"name":           gqlRead(func(t *gqlTypeDef) interface{} { return gqlOptional(t.Name) }),
+		"description":    gqlRead(func(t *gqlTypeDef) interface{} { return gqlOptional(t.Description) }),
+		"specifiedByURL": gqlRead(func(t *gqlTypeDef) interface{} { return nil }),
+		"fields":         gqlIntrospectionList("__Field", func(t *gqlTypeDef) interface{} { return gqlListFor(t, "OBJECT", t.Fields) }),
+		"interfaces": gqlObjects("__Type", func(t *gqlTypeDef) interface{} {
+			return gqlListFor(t, "OBJECT", []*gqlTypeDef{})
+		}),
+		"possibleTypes": gqlObjects("__Type", func(t *gqlTypeDef) interface{} { return nil }),
+		"enumValues":    gqlIntrospectionList("__EnumValue", func(t *gqlTypeDef) interface{} { return gqlListFor(t, "ENUM", t.EnumValues) }),
+		"inputFields":   gqlIntrospectionList("__InputValue", func(t *gqlTypeDef) interface{} { return gqlListFor(t, "INPUT_OBJECT", t.InputFields) }),
+		"ofType":        gqlObjects("__Type", func(t *gqlTypeDef) interface{} { return t.OfType }),
+		"isOneOf": gqlRead(func(t *gqlTypeDef) interface{} {
+			if t.Kind != "INPUT_OBJECT" {
+				return nil
+			}
+			return false
+		}),
+	},
+	"__Field": {
+		"name":              gqlRead(func(f *gqlFieldDef) interface{} { return f.Name }),
+		"description":       gqlRead(func(f *gqlFieldDef) interface{} { return gqlOptional(f.Description) }),
+		"args":              gqlIntrospectionList("__InputValue", func(f *gqlFieldDef) interface{} { return listOf(f.Args) }),
+		"type":              gqlObjects("__Type", func(f *gqlFieldDef) interface{} { return f.Type }),
+		"isDeprecated":      gqlRead(func(f *gqlFieldDef) interface{} { return false }),
+		"deprecationReason": gqlRead(func(f *gqlFieldDef) interface{} { return nil }),
+	},
+	"__InputValue": {
+		"name":        gqlRead(func(v *gqlInputValueDef) interface{} { return v.Name }),
+		"description": gqlRead(func(v *gqlInputValueDef) interface{} { return gqlOptional(v.Description) }),
+		"type":        gqlObjects("__Type", func(v *gqlInputValueDef) interface{} { return v.Type }),
+		"defaultValue": gqlRead(func(v *gqlInputValueDef) interface{} {
+			if v.DefaultValue == nil {
+				return nil
+			}
+			return *v.DefaultValue
+		}),
+		"isDeprecated":      gqlRead(func(v *gqlInputValueDef) interface{} { return false }),
+		"deprecationReason": gqlRead(func(v *gqlInputValueDef) interface{} { return nil }),
+	},
+	"__EnumValue": {
+		"name":              gqlRead(func(v *gqlEnumValueDef) interface{} { return v.Name }),
+		"description":       gqlRead(func(v *gqlEnumValueDef) interface{} { return gqlOptional(v.Description) }),
+		"isDeprecated":      gqlRead(func(v *gqlEnumValueDef) interface{} { return false }),
+		"deprecationReason": gqlRead(func(v *gqlEnumValueDef) interface{} { return nil }),
+	},
+	"__Directive": {
+		"name":         gqlRead(func(d *gqlDirectiveDef) interface{} { return d.Name }),
+		"description":  gqlRead(func(d *gqlDirectiveDef) interface{} { return gqlOptional(d.Description) }),
+		"locations":    gqlRead(func(d *gqlDirectiveDef) interface{} { return d.Locations }),
+		"args":         gqlIntrospectionList("__InputValue", func(d *gqlDirectiveDef) interface{} { return listOf(d.Args) }),
+		"isRepeatable": gqlRead(func(d *gqlDirectiveDef) interface{} { return false }),
+	},
+}
+
+// A list field taking includeDeprecated, which changes nothing as nothing
+// is deprecated
+func gqlIntrospectionList[T any](typeName string, read func(*T) interface{}) gqlField {
+	f := gqlObjects(typeName, read)
+	f.Args = []string{"includeDeprecated"}
+	return f
+}
+
+// Add introspection to a schema described by sdl: the __ types, and the
+// __schema and __type fields every query root has
+func withIntrospection(schema map[string]gqlType, sdl string) map[string]gqlType {
+	def, err := parseGraphQLSDL(sdl + introspectionSDL)
+	if err != nil {
+		panic(fmt.Sprintf("invalid GraphQL SDL: %v", err))
+	}
+	for name, t := range introspectionTypes {
+		schema[name] = t
+	}
+	schema["Query"]["__schema"] = gqlField{Type: "__Schema", Resolve: func(_ *gqlContext, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+		return def, nil
+	}}
+	schema["Query"]["__type"] = gqlField{Type: "__Type", Args: []string{"name"}, Resolve: func(_ *gqlContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+		name, ok, err := gqlArgString(args, "name")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, gqlErrorf("Argument %q is required", "name")
+		}
+		if t := def.byName[name]; t != nil {
+			return t, nil
+		}
+		return nil, nil
+	}}
+	return schema
+}
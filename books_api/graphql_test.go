@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postGraphQL(t *testing.T, query string, vars map[string]interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	setupRouter().ServeHTTP(response, req)
+	var result map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", response.Body.String(), err)
+	}
+	return response, result
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965}
+	db.Create(&dune)
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815})
+
+	query := `
+		query Catalog($id: ID!, $withYear: Boolean = false) {
+			dune: book(id: $id) { ...Basics year @include(if: $withYear) }
+			missing: book(id: "999") { title }
+			authors { name bookCount }
+		}
+		fragment Basics on Book { __typename title author }`
+	response, result := postGraphQL(t, query, map[string]interface{}{"id": dune.ID})
+	want := `{"data":{"dune":{"__typename":"Book","title":"Dune","author":"Frank Herbert"},"missing":null,` +
+		`"authors":[{"name":"Frank Herbert","bookCount":1},{"name":"Jane Austen","bookCount":1}]}}`
+	if response.Code != http.StatusOK || strings.TrimSpace(response.Body.String()) != want {
+		t.Errorf("Expected %s, got %d: %s (%v)", want, response.Code, response.Body.String(), result)
+	}
+}
+
+func TestGraphQLBooksPaging(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233", Year: 1969},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+	} {
+		db.Create(&b)
+	}
+
+	query := `query($after: String) {
+		books(author: "herbert", sort: "-year", first: 1, after: $after) { totalCount nextCursor items { title } }
+	}`
+	_, result := postGraphQL(t, query, nil)
+	books := result["data"].(map[string]interface{})["books"].(map[string]interface{})
+	items := books["items"].([]interface{})
+	if books["totalCount"] != float64(2) || len(items) != 1 || items[0].(map[string]interface{})["title"] != "Dune Messiah" {
+		t.Fatalf("Unexpected first page %v", result)
+	}
+
+	_, result = postGraphQL(t, query, map[string]interface{}{"after": books["nextCursor"]})
+	books = result["data"].(map[string]interface{})["books"].(map[string]interface{})
+	items = books["items"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["title"] != "Dune" || books["nextCursor"] != nil {
+		t.Errorf("Unexpected second page %v", result)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	clearDB()
+
+	create := `mutation($input: BookInput!) { createBook(input: $input) { id title year } }`
+	input := map[string]interface{}{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441013593", "year": 1965}
+	_, result := postGraphQL(t, create, map[string]interface{}{"input": input})
+	created, _ := result["data"].(map[string]interface{})["createBook"].(map[string]interface{})
+	if created == nil || created["title"] != "Dune" || created["year"] != float64(1965) {
+		t.Fatalf("Unexpected create result %v", result)
+	}
+	id := created["id"].(string)
+
+	update := `mutation($id: ID!) { updateBook(id: $id, input: {year: 1966, coverUrl: null}) { title year } }`
+	_, result = postGraphQL(t, update, map[string]interface{}{"id": id})
+	updated, _ := result["data"].(map[string]interface{})["updateBook"].(map[string]interface{})
+	if updated == nil || updated["title"] != "Dune" || updated["year"] != float64(1966) {
+		t.Errorf("Unexpected update result %v", result)
+	}
+
+	// Field errors leave the other fields' data
+	_, result = postGraphQL(t, `mutation { bad: createBook(input: {title: ""}) { id } }`, nil)
+	errs, _ := result["errors"].([]interface{})
+	if len(errs) != 1 || result["data"].(map[string]interface{})["bad"] != nil {
+		t.Fatalf("Expected one error, got %v", result)
+	}
+	ext := errs[0].(map[string]interface{})["extensions"].(map[string]interface{})
+	path := errs[0].(map[string]interface{})["path"].([]interface{})
+	if ext["code"] != "validation_failed" || ext["fields"] == nil || len(path) != 1 || path[0] != "bad" {
+		t.Errorf("Unexpected error %v", errs[0])
+	}
+
+	_, result = postGraphQL(t, `mutation($id: ID!) { deleteBook(id: $id) }`, map[string]interface{}{"id": id})
+	if result["data"].(map[string]interface{})["deleteBook"] != id {
+		t.Errorf("Unexpected delete result %v", result)
+	}
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the book to be deleted, %d left", count)
+	}
+}
+
+func TestGraphQLRequestErrors(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"syntax", `{ books { items { title }`, "Syntax error: unexpected end of document"},
+		{"unknown field", `{ books { items { pages } } }`, `Cannot query field "pages" on type "Book"`},
+		{"missing selection", `{ book(id: 1) }`, `Field "book" of type "Book" must have a selection of subfields`},
+		{"unknown argument", `{ books(limit: 5) { totalCount } }`, `Unknown argument "limit" on field "books"`},
+		{"mutation over GET", `mutation { deleteBook(id: 1) }`, "Mutations must be sent with POST"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/graphql?query="+url.QueryEscape(tt.query), nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+
+		var result GraphQLResponse
+		json.Unmarshal(response.Body.Bytes(), &result)
+		if response.Code != http.StatusBadRequest || result.Data != nil || len(result.Errors) != 1 || result.Errors[0].Message != tt.want {
+			t.Errorf("%s: expected 400 %q, got %d: %s", tt.name, tt.want, response.Code, response.Body.String())
+		}
+	}
+}
+
+func TestGraphQLIntrospection(t *testing.T) {
+	clearDB()
+
+	query := `{
+		__schema { queryType { name } mutationType { name } subscriptionType { name } directives { name } }
+		book: __type(name: "Book") {
+			kind
+			fields { name type { kind name ofType { kind name ofType { kind name ofType { name } } } } }
+		}
+		input: __type(name: "BookInput") { kind fields { name } inputFields { name defaultValue type { name } } }
+		missing: __type(name: "Shelf") { name }
+	}`
+	response, result := postGraphQL(t, query, nil)
+	if response.Code != http.StatusOK || result["errors"] != nil {
+		t.Fatalf("Expected 200 without errors, got %d: %s", response.Code, response.Body.String())
+	}
+	data := result["data"].(map[string]interface{})
+	schema, _ := json.Marshal(data["__schema"])
+	want := `{"directives":[{"name":"skip"},{"name":"include"}],"mutationType":{"name":"Mutation"},` +
+		`"queryType":{"name":"Query"},"subscriptionType":null}`
+	if string(schema) != want {
+		t.Errorf("Expected __schema %s, got %s", want, schema)
+	}
+	if data["missing"] != nil {
+		t.Errorf("Expected null for an unknown type, got %v", data["missing"])
+	}
+
+	book := data["book"].(map[string]interface{})
+	fields := map[string]string{}
+	for _, f := range book["fields"].([]interface{}) {
+		f := f.(map[string]interface{})
+		typ, _ := json.Marshal(f["type"])
+		fields[f["name"].(string)] = string(typ)
+	}
+	if book["kind"] != "OBJECT" || len(fields) != 11 {
+		t.Errorf("Expected Book to be an object of 11 fields, got %v", book)
+	}
+	if want := `{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"String","ofType":null}}`; fields["title"] != want {
+		t.Errorf("Expected title to be %s, got %s", want, fields["title"])
+	}
+	if want := `{"kind":"NON_NULL","name":null,"ofType":{"kind":"LIST","name":null,"ofType":{"kind":"NON_NULL","name":null,"ofType":{"name":"Tag"}}}}`; fields["tags"] != want {
+		t.Errorf("Expected tags to be %s, got %s", want, fields["tags"])
+	}
+
+	input := data["input"].(map[string]interface{})
+	if input["kind"] != "INPUT_OBJECT" || input["fields"] != nil || len(input["inputFields"].([]interface{})) != len(gqlBookInputFields) {
+		t.Errorf("Expected BookInput to be an input object with %d fields, got %v", len(gqlBookInputFields), input)
+	}
+}
+
+// The query GraphiQL and most code generators send
+func TestGraphQLFullIntrospectionQuery(t *testing.T) {
+	clearDB()
+
+	query := `
+		query IntrospectionQuery {
+			__schema {
+				description
+				queryType { name } mutationType { name } subscriptionType { name }
+				types { ...FullType }
+				directives { name description isRepeatable locations args(includeDeprecated: true) { ...InputValue } }
+			}
+		}
+		fragment FullType on __Type {
+			kind name description specifiedByURL isOneOf
+			fields(includeDeprecated: true) { name description args(includeDeprecated: true) { ...InputValue } type { ...TypeRef } isDeprecated deprecationReason }
+			inputFields(includeDeprecated: true) { ...InputValue }
+			interfaces { ...TypeRef }
+			enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+			possibleTypes { ...TypeRef }
+		}
+		fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue isDeprecated deprecationReason }
+		fragment TypeRef on __Type { kind name ofType { kind name ofType { kind name ofType { kind name } } } }`
+	response, result := postGraphQL(t, query, nil)
+	if response.Code != http.StatusOK || result["errors"] != nil {
+		t.Fatalf("Expected 200 without errors, got %d: %s", response.Code, response.Body.String())
+	}
+
+	kinds := map[string]string{}
+	schema := result["data"].(map[string]interface{})["__schema"].(map[string]interface{})
+	for _, typ := range schema["types"].([]interface{}) {
+		typ := typ.(map[string]interface{})
+		kinds[typ["name"].(string)] = typ["kind"].(string)
+	}
+	for name, kind := range map[string]string{
+		"Query": "OBJECT", "BookInput": "INPUT_OBJECT", "ID": "SCALAR", "Boolean": "SCALAR",
+		"__Type": "OBJECT", "__TypeKind": "ENUM",
+	} {
+		if kinds[name] != kind {
+			t.Errorf("Expected %s to be a %s type, got %q", name, kind, kinds[name])
+		}
+	}
+}
+
+// The resolvers and the SDL introspection reports describe the same fields
+func TestGraphQLSchemaMatchesSDL(t *testing.T) {
+	def, err := parseGraphQLSDL(bookSDL + introspectionSDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for typeName, fields := range bookSchema {
+		typ := def.byName[typeName]
+		if typ == nil {
+			t.Errorf("Type %s isn't in the SDL", typeName)
+			continue
+		}
+		for name, field := range fields {
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+			f := typ.field(name)
+			if f == nil {
+				t.Errorf("Field %s.%s isn't in the SDL", typeName, name)
+				continue
+			}
+			var args []string
+			for _, arg := range f.Args {
+				args = append(args, arg.Name)
+			}
+			if strings.Join(args, ",") != strings.Join(field.Args, ",") {
+				t.Errorf("Expected %s.%s to take (%v) as in the SDL, got (%v)", typeName, name, args, field.Args)
+			}
+			want := f.Type.named()
+			if (want.Kind == "OBJECT") != (field.Type != "") || want.Kind == "OBJECT" && want.Name != field.Type {
+				t.Errorf("Expected %s.%s to be of type %s, got %q", typeName, name, want.Name, field.Type)
+			}
+		}
+		if len(typ.Fields) != len(fields)-countPrefixed(fields, "__") {
+			t.Errorf("Expected type %s to resolve all %d fields of the SDL", typeName, len(typ.Fields))
+		}
+	}
+
+	input := def.byName["BookInput"]
+	if len(input.InputFields) != len(gqlBookInputFields) {
+		t.Errorf("Expected BookInput to have the %d fields of gqlBookInputFields", len(gqlBookInputFields))
+	}
+	for _, f := range input.InputFields {
+		if _, ok := gqlBookInputFields[f.Name]; !ok {
+			t.Errorf("BookInput.%s isn't in gqlBookInputFields", f.Name)
+		}
+	}
+}
+
+func countPrefixed(fields gqlType, prefix string) int {
+	n := 0
+	for name := range fields {
+		if strings.HasPrefix(name, prefix) {
+			n++
+		}
+	}
+	return n
+}
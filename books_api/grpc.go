@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// gRPC (https://grpc.io/docs/what-is-grpc/) for internal services, over
+// HTTP/2 without TLS on its own port: the BooksService of
+// proto/books.proto, sharing the listing and validation of the REST API.
+
+const grpcContentType = "application/grpc"
+
+// Largest request message accepted, gRPC's default
+const maxGRPCMessageBytes = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcStatus is a failed call's status. ErrorCode is the code REST
+// reports for the same error, sent as error-code metadata.
+type grpcStatus struct {
+	Code      int
+	Message   string
+	ErrorCode string
+}
+
+func (s *grpcStatus) Error() string { return s.Message }
+
+// The gRPC status code for an HTTP status, as gRPC maps them
+func grpcCodeFor(httpStatus int) int {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusConflict:
+		return grpcAlreadyExists
+	case http.StatusPreconditionFailed:
+		return grpcFailedPrecondition
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusInternalServerError:
+		return grpcInternal
+	}
+	return grpcUnknown
+}
+
+// A BooksService method: decode the request message, run it and encode
+// the response
+type grpcMethod func(r *http.Request, req []byte) ([]byte, error)
+
+var booksServiceMethods = map[string]grpcMethod{
+	"/books.v1.BooksService/ListBooks":  grpcListBooks,
+	"/books.v1.BooksService/GetBook":    grpcGetBook,
+	"/books.v1.BooksService/CreateBook": grpcCreateBook,
+	"/books.v1.BooksService/UpdateBook": grpcUpdateBook,
+	"/books.v1.BooksService/DeleteBook": grpcDeleteBook,
+}
+
+// Serve gRPC on addr until the listener fails
+func listenGRPC(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("gRPC server starting on %s\n", addr)
+	return newGRPCServer().Serve(ln)
+}
+
+// An HTTP server speaking only cleartext HTTP/2, as gRPC clients connect
+// with prior knowledge
+func newGRPCServer() *http.Server {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{Handler: http.HandlerFunc(serveGRPC), Protocols: &protocols}
+}
+
+// Answer a unary gRPC call: one length-prefixed message each way, the
+// status in trailers
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+		http.Error(w, "Content-Type must be application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	method, ok := booksServiceMethods[r.URL.Path]
+	if !ok {
+		writeGRPCStatus(w, &grpcStatus{Code: grpcUnimplemented, Message: "Unknown method " + r.URL.Path})
+		return
+	}
+
+	p, err := grpcPrincipal(r)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+	if p != nil {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+	}
+	library, err := resolveLibrary(r.Header.Get(libraryHeader))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeGRPCStatus(w, &grpcStatus{Code: grpcNotFound, Message: "Library not found", ErrorCode: "library_not_found"})
+		return
+	} else if err != nil {
+		writeGRPCStatus(w, &grpcStatus{Code: grpcInternal, Message: "Failed to read library", ErrorCode: "internal_error"})
+		return
+	}
+	r = r.WithContext(withLibrary(r.Context(), library))
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+	resp, err := method(r, req)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", grpcContentType)
+	w.WriteHeader(http.StatusOK)
+	frame := make([]byte, 5, 5+len(resp))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+	w.Write(append(frame, resp...))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(grpcOK))
+}
+
+// Read a call's one request message
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "Missing request message"}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcStatus{Code: grpcUnimplemented, Message: "Compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessageBytes {
+		return nil, &grpcStatus{Code: grpcResourceExhausted, Message: "Request message is too large"}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "Truncated request message"}
+	}
+	return msg, nil
+}
+
+// Answer a failed call with only headers, which carry the status
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	var s *grpcStatus
+	if !errors.As(err, &s) {
+		s = &grpcStatus{Code: grpcInternal, Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(s.Code))
+	w.Header().Set("Grpc-Message", grpcEncodeMessage(s.Message))
+	if s.ErrorCode != "" {
+		w.Header().Set("Error-Code", s.ErrorCode)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Percent-encode a status message as grpc-message requires
+func grpcEncodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// The principal of a call's "authorization: Bearer" metadata, or nil
+func grpcPrincipal(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !authEnabled() {
+		return nil, nil
+	}
+	p, err := parseToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil, &grpcStatus{Code: grpcUnauthenticated, Message: "Invalid or expired token", ErrorCode: "invalid_token"}
+	}
+	return p, nil
+}
+
+// Writes need librarian when authentication is on, as over REST
+func grpcRequireLibrarian(r *http.Request) error {
+	if !authEnabled() {
+		return nil
+	}
+	p := principalFrom(r)
+	if p == nil {
+		return &grpcStatus{Code: grpcUnauthenticated, Message: "Authentication required", ErrorCode: "authentication_required"}
+	}
+	if !p.HasRole(roleLibrarian) {
+		return &grpcStatus{Code: grpcPermissionDenied, Message: "Forbidden", ErrorCode: "forbidden"}
+	}
+	return nil
+}
+
+// The status of a failed write: a hook's refusal, or an internal error
+func grpcWriteError(err error, fallback string) error {
+	var he *HookError
+	if !errors.As(err, &he) {
+		return &grpcStatus{Code: grpcInternal, Message: fallback, ErrorCode: "internal_error"}
+	}
+	if len(he.Fields) > 0 {
+		return grpcValidationError(he.Fields)
+	}
+	code := he.Code
+	if code == "" {
+		code = "rejected"
+	}
+	return &grpcStatus{Code: grpcCodeFor(he.Status), Message: he.Message, ErrorCode: code}
+}
+
+func grpcValidationError(errs []FieldError) error {
+	return &grpcStatus{Code: grpcInvalidArgument, Message: (&HookError{Fields: errs}).Error(), ErrorCode: "validation_failed"}
+}
+
+var errGRPCInvalidMessage = &grpcStatus{Code: grpcInvalidArgument, Message: "Invalid request message"}
+
+// Messages
+
+// Book fields by proto field name, with their numbers. Tags are read only.
+var protoBookFields = map[string]struct {
+	number int
+	copy   func(dst, src *Book)
+}{
+	"title":       {2, func(dst, src *Book) { dst.Title = src.Title }},
+	"author":      {3, func(dst, src *Book) { dst.Author = src.Author }},
+	"isbn":        {4, func(dst, src *Book) { dst.ISBN = src.ISBN }},
+	"year":        {5, func(dst, src *Book) { dst.Year = src.Year }},
+	"description": {6, func(dst, src *Book) { dst.Description = src.Description }},
+	"cover_url":   {7, func(dst, src *Book) { dst.CoverURL = src.CoverURL }},
+	"price_cents": {8, func(dst, src *Book) { dst.PriceCents = src.PriceCents }},
+}
+
+func encodeProtoBook(b *Book) []byte {
+	var e protoEncoder
+	e.uint(1, uint64(b.ID))
+	e.string(2, b.Title)
+	e.string(3, b.Author)
+	e.string(4, b.ISBN)
+	e.int(5, int64(b.Year))
+	e.string(6, b.Description)
+	e.string(7, b.CoverURL)
+	e.int(8, b.PriceCents)
+	for _, t := range b.Tags {
+		var te protoEncoder
+		te.uint(1, uint64(t.ID))
+		te.string(2, t.Name)
+		e.bytes(9, te.b)
+	}
+	return e.b
+}
+
+// Decode a Book message, returning the names of the fields it sets. As
+// proto3 leaves out zero values, those are the fields that aren't zero.
+func decodeProtoBook(data []byte) (Book, []string, error) {
+	var b Book
+	var set []string
+	err := decodeProto(data, func(f protoField) error {
+		if f.Number == 9 {
+			return nil
+		}
+		text := f.WireType == protoBytes
+		switch {
+		case f.Number == 1 && !text:
+			b.ID = uint(f.Value)
+		case f.Number == 2 && text:
+			b.Title = f.string()
+		case f.Number == 3 && text:
+			b.Author = f.string()
+		case f.Number == 4 && text:
+			b.ISBN = f.string()
+		case f.Number == 5 && !text:
+			b.Year = int(int32(f.int()))
+		case f.Number == 6 && text:
+			b.Description = f.string()
+		case f.Number == 7 && text:
+			b.CoverURL = f.string()
+		case f.Number == 8 && !text:
+			b.PriceCents = f.int()
+		case f.Number <= 9:
+			return errGRPCInvalidMessage
+		default:
+			return nil
+		}
+		for name, field := range protoBookFields {
+			if field.number == f.Number {
+				set = append(set, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return b, nil, errGRPCInvalidMessage
+	}
+	return b, set, nil
+}
+
+// Decode a request holding only an id in field 1
+func decodeProtoID(data []byte) (uint, error) {
+	var id uint64
+	err := decodeProto(data, func(f protoField) error {
+		if f.Number == 1 {
+			if f.WireType != protoVarint {
+				return errInvalidProto
+			}
+			id = f.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errGRPCInvalidMessage
+	}
+	if id == 0 {
+		return 0, &grpcStatus{Code: grpcInvalidArgument, Message: "id is required", ErrorCode: "invalid_book_id"}
+	}
+	return uint(id), nil
+}
+
+// Methods
+
+func grpcListBooks(r *http.Request, data []byte) ([]byte, error) {
+	q := url.Values{}
+	params := map[int]string{1: "author", 2: "title", 3: "year_min", 4: "year_max", 5: "q", 6: "sort", 8: "cursor"}
+	pageSize := int64(0)
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.Number == 7 && f.WireType == protoVarint:
+			pageSize = int64(int32(f.int()))
+		case (f.Number == 3 || f.Number == 4) && f.WireType == protoVarint:
+			q.Set(params[f.Number], strconv.Itoa(int(int32(f.int()))))
+		case params[f.Number] != "" && f.WireType == protoBytes:
+			q.Set(params[f.Number], f.string())
+		case f.Number <= 8:
+			return errInvalidProto
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errGRPCInvalidMessage
+	}
+	limit := defaultPageSize
+	if pageSize != 0 {
+		if pageSize < 1 || pageSize > maxPageSize {
+			return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "page_size must be between 1 and 100", ErrorCode: "invalid_parameter"}
+		}
+		limit = int(pageSize)
+	}
+
+	conn, err := listBookPage(r.Context(), q, limit)
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: ce.Message, ErrorCode: ce.Code}
+	}
+	if err != nil {
+		return nil, &grpcStatus{Code: grpcInternal, Message: "Failed to list books", ErrorCode: "internal_error"}
+	}
+	var e protoEncoder
+	for i := range conn.Items {
+		e.bytes(1, encodeProtoBook(&conn.Items[i]))
+	}
+	e.string(2, conn.NextCursor)
+	e.string(3, conn.PrevCursor)
+	e.int(4, conn.TotalCount)
+	return e.b, nil
+}
+
+func grpcGetBook(r *http.Request, data []byte) ([]byte, error) {
+	id, err := decodeProtoID(data)
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := db.WithContext(r.Context()).Preload("Tags").First(&book, id).Error; err != nil {
+		return nil, &grpcStatus{Code: grpcNotFound, Message: "Book not found", ErrorCode: "book_not_found"}
+	}
+	return encodeProtoBook(&book), nil
+}
+
+// Decode the book of a Create or UpdateBookRequest, field 1, with the
+// update mask of field 2
+func decodeProtoBookRequest(data []byte) (Book, []string, []string, error) {
+	var book Book
+	var set, mask []string
+	err := decodeProto(data, func(f protoField) error {
+		if f.Number > 2 {
+			return nil
+		}
+		if f.WireType != protoBytes {
+			return errInvalidProto
+		}
+		if f.Number == 2 {
+			mask = append(mask, f.string())
+			return nil
+		}
+		var err error
+		book, set, err = decodeProtoBook(f.Data)
+		return err
+	})
+	if err != nil {
+		return book, nil, nil, errGRPCInvalidMessage
+	}
+	return book, set, mask, nil
+}
+
+func grpcCreateBook(r *http.Request, data []byte) ([]byte, error) {
+	if err := grpcRequireLibrarian(r); err != nil {
+		return nil, err
+	}
+	book, _, _, err := decodeProtoBookRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	book.ID = 0
+	if errs := validateBook(&book); len(errs) > 0 {
+		return nil, grpcValidationError(errs)
+	}
+	if err := db.WithContext(r.Context()).Create(&book).Error; err != nil {
+		return nil, grpcWriteError(err, "Failed to create book")
+	}
+	notifyBookAdded(&book)
+	return encodeProtoBook(&book), nil
+}
+
+// Replace the fields named by update_mask, or without one the fields the
+// request sets
+func grpcUpdateBook(r *http.Request, data []byte) ([]byte, error) {
+	if err := grpcRequireLibrarian(r); err != nil {
+		return nil, err
+	}
+	changes, set, mask, err := decodeProtoBookRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if changes.ID == 0 {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "book.id is required", ErrorCode: "invalid_book_id"}
+	}
+	if mask == nil {
+		mask = set
+	}
+
+	var book Book
+	if err := db.WithContext(r.Context()).Preload("Tags").First(&book, changes.ID).Error; err != nil {
+		return nil, &grpcStatus{Code: grpcNotFound, Message: "Book not found", ErrorCode: "book_not_found"}
+	}
+	for _, name := range mask {
+		field, ok := protoBookFields[name]
+		if !ok {
+			return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "update_mask names unknown field " + name, ErrorCode: "invalid_parameter"}
+		}
+		field.copy(&book, &changes)
+	}
+	if errs := validateBook(&book); len(errs) > 0 {
+		return nil, grpcValidationError(errs)
+	}
+	tags := book.Tags
+	book.Tags = nil
+	if err := db.WithContext(r.Context()).Save(&book).Error; err != nil {
+		return nil, grpcWriteError(err, "Failed to update book")
+	}
+	book.Tags = tags
+	return encodeProtoBook(&book), nil
+}
+
+func grpcDeleteBook(r *http.Request, data []byte) ([]byte, error) {
+	if err := grpcRequireLibrarian(r); err != nil {
+		return nil, err
+	}
+	id, err := decodeProtoID(data)
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		return nil, &grpcStatus{Code: grpcNotFound, Message: "Book not found", ErrorCode: "book_not_found"}
+	}
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		return deleteBookTx(tx, &book)
+	})
+	if err != nil {
+		return nil, grpcWriteError(err, "Failed to delete book")
+	}
+	return nil, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// Start the gRPC server on a free port, returning its URL and a cleartext
+// HTTP/2 client for it
+func startGRPCServer(t *testing.T) (string, *http.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newGRPCServer()
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	return "http://" + ln.Addr().String(), client
+}
+
+// Make a unary call, returning the response message and the call's status
+func grpcCall(t *testing.T, client *http.Client, base, method string, msg []byte, token string) ([]byte, int, string) {
+	t.Helper()
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	req, _ := http.NewRequest("POST", base+"/books.v1.BooksService/"+method, bytes.NewReader(append(frame, msg...)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	// A failed call sends its status in headers only
+	status := resp.Header.Get("Grpc-Status")
+	message := resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		t.Fatalf("Missing grpc-status in %v / %v", resp.Header, resp.Trailer)
+	}
+	if len(body) >= 5 {
+		body = body[5:]
+	}
+	return body, code, message
+}
+
+func decodeTestBooks(t *testing.T, data []byte) ([]Book, string, int64) {
+	t.Helper()
+	var books []Book
+	var next string
+	var total int64
+	err := decodeProto(data, func(f protoField) error {
+		switch f.Number {
+		case 1:
+			b, _, err := decodeProtoBook(f.Data)
+			books = append(books, b)
+			return err
+		case 2:
+			next = f.string()
+		case 4:
+			total = f.int()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return books, next, total
+}
+
+func TestGRPCListAndGetBooks(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233", Year: 1969},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+	} {
+		db.Create(&b)
+	}
+	base, client := startGRPCServer(t)
+
+	var req protoEncoder
+	req.string(1, "herbert")
+	req.string(6, "-year")
+	req.int(7, 1)
+	resp, code, msg := grpcCall(t, client, base, "ListBooks", req.b, "")
+	books, next, total := decodeTestBooks(t, resp)
+	if code != grpcOK || total != 2 || len(books) != 1 || books[0].Title != "Dune Messiah" || next == "" {
+		t.Fatalf("Unexpected first page: %d %q, %+v, %q, %d", code, msg, books, next, total)
+	}
+
+	req.string(8, next)
+	resp, _, _ = grpcCall(t, client, base, "ListBooks", req.b, "")
+	books, next, _ = decodeTestBooks(t, resp)
+	if len(books) != 1 || books[0].Title != "Dune" || books[0].Year != 1965 || next != "" {
+		t.Errorf("Unexpected second page: %+v, %q", books, next)
+	}
+
+	var get protoEncoder
+	get.uint(1, uint64(books[0].ID))
+	resp, code, _ = grpcCall(t, client, base, "GetBook", get.b, "")
+	book, _, _ := decodeProtoBook(resp)
+	if code != grpcOK || book.ISBN != "9780441013593" {
+		t.Errorf("Unexpected book: %d %+v", code, book)
+	}
+
+	get = protoEncoder{}
+	get.uint(1, 999)
+	if _, code, msg := grpcCall(t, client, base, "GetBook", get.b, ""); code != grpcNotFound || msg != "Book not found" {
+		t.Errorf("Expected NOT_FOUND, got %d %q", code, msg)
+	}
+	var bad protoEncoder
+	bad.string(6, "pages")
+	if _, code, _ := grpcCall(t, client, base, "ListBooks", bad.b, ""); code != grpcInvalidArgument {
+		t.Errorf("Expected INVALID_ARGUMENT for a bad sort, got %d", code)
+	}
+	if _, code, _ := grpcCall(t, client, base, "ShelveBook", nil, ""); code != grpcUnimplemented {
+		t.Errorf("Expected UNIMPLEMENTED for an unknown method, got %d", code)
+	}
+}
+
+func TestGRPCWrites(t *testing.T) {
+	clearDB()
+	base, client := startGRPCServer(t)
+
+	book := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, CoverURL: "https://example.com/dune.jpg"}
+	var create protoEncoder
+	create.bytes(1, encodeProtoBook(&book))
+	resp, code, msg := grpcCall(t, client, base, "CreateBook", create.b, "")
+	created, _, _ := decodeProtoBook(resp)
+	if code != grpcOK || created.ID == 0 || created.Title != "Dune" {
+		t.Fatalf("Unexpected create: %d %q %+v", code, msg, created)
+	}
+
+	// Without a mask only set fields change; a masked field is replaced
+	// even with its zero value
+	var update protoEncoder
+	update.bytes(1, encodeProtoBook(&Book{ID: created.ID, Year: 1966}))
+	update.string(2, "year")
+	update.string(2, "cover_url")
+	resp, code, msg = grpcCall(t, client, base, "UpdateBook", update.b, "")
+	updated, _, _ := decodeProtoBook(resp)
+	if code != grpcOK || updated.Title != "Dune" || updated.Year != 1966 || updated.CoverURL != "" {
+		t.Errorf("Unexpected update: %d %q %+v", code, msg, updated)
+	}
+
+	var invalid protoEncoder
+	invalid.bytes(1, encodeProtoBook(&Book{Title: "No author"}))
+	if _, code, msg := grpcCall(t, client, base, "CreateBook", invalid.b, ""); code != grpcInvalidArgument || msg == "" {
+		t.Errorf("Expected INVALID_ARGUMENT, got %d %q", code, msg)
+	}
+
+	var del protoEncoder
+	del.uint(1, uint64(created.ID))
+	if _, code, _ := grpcCall(t, client, base, "DeleteBook", del.b, ""); code != grpcOK {
+		t.Errorf("Expected the delete to succeed, got %d", code)
+	}
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the book to be deleted, %d left", count)
+	}
+}
+
+func TestGRPCWritesNeedLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"ann:pw": {Username: "ann", Roles: []string{roleReader}},
+		"lib:pw": {Username: "lib", Roles: []string{roleLibrarian}},
+	})
+	router := setupRouter()
+	base, client := startGRPCServer(t)
+
+	var create protoEncoder
+	create.bytes(1, encodeProtoBook(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965}))
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"", grpcUnauthenticated},
+		{"not-a-token", grpcUnauthenticated},
+		{loginAs(t, router, "ann", "pw"), grpcPermissionDenied},
+		{loginAs(t, router, "lib", "pw"), grpcOK},
+	} {
+		if _, code, msg := grpcCall(t, client, base, "CreateBook", create.b, tt.token); code != tt.want {
+			t.Errorf("Expected status %d, got %d %q", tt.want, code, msg)
+		}
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	if got := grpcEncodeMessage("50% off: café\n"); got != "50%25 off: caf%C3%A9%0A" {
+		t.Errorf("Unexpected encoding %q", got)
+	}
+}
+
+// Fields of a message in proto/books.proto, by name: their number and
+// whether they're length-delimited on the wire
+func protoMessageFields(t *testing.T, message string) map[string]protoField {
+	t.Helper()
+	data, err := os.ReadFile("proto/books.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldRe := regexp.MustCompile(`^\s*(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);`)
+	fields := map[string]protoField{}
+	in := false
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "message "+message+" {"):
+			in = true
+		case in && strings.HasPrefix(line, "}"):
+			return fields
+		case in:
+			if m := fieldRe.FindStringSubmatch(line); m != nil {
+				number, _ := strconv.Atoi(m[4])
+				wire := protoVarint
+				if m[1] != "" || m[2] == "string" || m[2] == "bytes" || unicode.IsUpper(rune(m[2][0])) {
+					wire = protoBytes
+				}
+				fields[m[3]] = protoField{Number: number, WireType: wire}
+			}
+		}
+	}
+	t.Fatalf("No message %s in books.proto", message)
+	return nil
+}
+
+// The encoder is written by hand, so check it against the schema clients
+// generate their code from
+func TestProtoBookMatchesSchema(t *testing.T) {
+	schema := protoMessageFields(t, "Book")
+	book := Book{ID: 7, Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965,
+		Description: "Spice", CoverURL: "https://example.com/dune.jpg", PriceCents: 999, Tags: []Tag{{ID: 1, Name: "sf"}}}
+
+	encoded := map[int]int{}
+	decodeProto(encodeProtoBook(&book), func(f protoField) error {
+		encoded[f.Number] = f.WireType
+		return nil
+	})
+	if len(encoded) != len(schema) {
+		t.Errorf("The encoder writes %d fields, books.proto has %d", len(encoded), len(schema))
+	}
+	for name, f := range schema {
+		if wire, ok := encoded[f.Number]; !ok || wire != f.WireType {
+			t.Errorf("Book.%s = %d: encoded with wire type %d, want %d (present: %v)", name, f.Number, wire, f.WireType, ok)
+		}
+	}
+	for name, f := range protoBookFields {
+		if schema[name].Number != f.number {
+			t.Errorf("Update mask field %s is number %d, books.proto says %d", name, f.number, schema[name].Number)
+		}
+	}
+
+	decoded, _, err := decodeProtoBook(encodeProtoBook(&book))
+	book.Tags = nil
+	if err != nil || !reflect.DeepEqual(decoded, book) {
+		t.Errorf("Expected %+v back, got %+v, %v", book, decoded, err)
+	}
+}
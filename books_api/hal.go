@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Media type of the HAL (https://stateless.group/hal_specification.html)
+// representation
+const halType = "application/hal+json"
+
+// halLinks are a resource's _links, by relation
+type halLinks map[string]halLink
+
+type halLink struct {
+	Href string `json:"href"`
+}
+
+// rawMember is an object member with its value left encoded
+type rawMember struct {
+	key   string
+	value json.RawMessage
+}
+
+// The members of a JSON object, in order; false if data isn't an object
+func rawObject(data []byte) ([]rawMember, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	var members []rawMember
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		members = append(members, rawMember{key.(string), value})
+	}
+	return members, true
+}
+
+// Write an object with links as its first member, then members
+func writeHALObject(b *bytes.Buffer, links halLinks, members []rawMember) {
+	data, _ := json.Marshal(links)
+	b.WriteString(`{"_links":`)
+	b.Write(data)
+	for _, m := range members {
+		key, _ := json.Marshal(m.key)
+		b.WriteByte(',')
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(m.value)
+	}
+	b.WriteByte('}')
+}
+
+// Rewrite a handler's response as HAL. Books gain self and collection
+// links; arrays and pages become objects embedding their items, with
+// self, first, next and prev links. Other objects get a self link, and
+// errors and anything that isn't JSON pass through unchanged.
+func writeHAL(w http.ResponseWriter, r *http.Request, res *bufferedResponse) {
+	data := bytes.TrimSpace(res.body.Bytes())
+	if res.status >= 400 || len(data) == 0 || !json.Valid(data) {
+		w.WriteHeader(res.status)
+		w.Write(res.body.Bytes())
+		return
+	}
+
+	var out bytes.Buffer
+	self := halLinks{"self": {r.URL.RequestURI()}}
+	typ := resourceType(r.URL.Path)
+	prefix := versionFrom(r).Prefix
+	if data[0] == '[' {
+		var items []json.RawMessage
+		json.Unmarshal(data, &items)
+		writeHALObject(&out, self, []rawMember{{"_embedded", halEmbedded(prefix, typ, items)}})
+	} else if members, ok := rawObject(data); ok {
+		var items []json.RawMessage
+		var rest []rawMember
+		links := self
+		for _, m := range members {
+			switch m.key {
+			case "items":
+				json.Unmarshal(m.value, &items)
+				continue
+			case "next_cursor", "prev_cursor":
+				var cursor string
+				json.Unmarshal(m.value, &cursor)
+				links[m.key[:4]] = halLink{pageURL(r, cursor)}
+			case "id":
+				if typ == "books" {
+					links = halBookLinks(prefix, m.value)
+				}
+			}
+			rest = append(rest, m)
+		}
+		if items != nil {
+			links["first"] = halLink{pageURL(r, "")}
+			rest = append([]rawMember{{"_embedded", halEmbedded(prefix, typ, items)}}, rest...)
+		}
+		writeHALObject(&out, links, rest)
+	} else {
+		w.WriteHeader(res.status)
+		w.Write(res.body.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Type", halType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(res.status)
+	w.Write(out.Bytes())
+	w.Write([]byte("\n"))
+}
+
+func halBookLinks(prefix string, id json.RawMessage) halLinks {
+	return halLinks{
+		"self":       {prefix + "/books/" + string(id)},
+		"collection": {prefix + "/books"},
+	}
+}
+
+// The _embedded member for a collection's items, with each book's links
+func halEmbedded(prefix, typ string, items []json.RawMessage) json.RawMessage {
+	var b bytes.Buffer
+	b.WriteString(`{"` + typ + `":[`)
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		members, ok := rawObject(item)
+		if !ok || typ != "books" {
+			b.Write(item)
+			continue
+		}
+		links := halLinks{}
+		for _, m := range members {
+			if m.key == "id" {
+				links = halBookLinks(prefix, m.value)
+			}
+		}
+		writeHALObject(&b, links, members)
+	}
+	b.WriteString(`]}`)
+	return b.Bytes()
+}
+
+// The request's URL at another cursor, or the first page without one
+func pageURL(r *http.Request, cursor string) string {
+	q := r.URL.Query()
+	q.Del("cursor")
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.RequestURI()
+}
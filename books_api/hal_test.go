@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHALBooks(t *testing.T) {
+	clearDB()
+	for i := 1; i <= 3; i++ {
+		db.Create(&Book{Title: fmt.Sprintf("Book %d", i), Author: "Author", ISBN: fmt.Sprintf("978000000000%d", i)})
+	}
+	router := setupRouter()
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", halType)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var doc map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &doc)
+		return response, doc
+	}
+	href := func(doc map[string]interface{}, rel string) string {
+		links, _ := doc["_links"].(map[string]interface{})
+		link, _ := links[rel].(map[string]interface{})
+		s, _ := link["href"].(string)
+		return s
+	}
+
+	var book Book
+	db.Where("title = ?", "Book 1").First(&book)
+	response, doc := get(fmt.Sprintf("/api/v1/books/%d", book.ID))
+	if response.Header().Get("Content-Type") != halType || !strings.HasPrefix(response.Body.String(), `{"_links":`) {
+		t.Fatalf("Expected a HAL book, got %q: %s", response.Header().Get("Content-Type"), response.Body.String())
+	}
+	if href(doc, "self") != fmt.Sprintf("/api/v1/books/%d", book.ID) || href(doc, "collection") != "/api/v1/books" || doc["title"] != "Book 1" {
+		t.Errorf("Unexpected HAL book %s", response.Body.String())
+	}
+
+	response, doc = get("/api/v1/books?limit=1")
+	embedded, _ := doc["_embedded"].(map[string]interface{})
+	books, _ := embedded["books"].([]interface{})
+	if len(books) != 1 || href(books[0].(map[string]interface{}), "self") != fmt.Sprintf("/api/v1/books/%d", book.ID) {
+		t.Fatalf("Expected the page's books embedded with links, got %s", response.Body.String())
+	}
+	if href(doc, "self") != "/api/v1/books?limit=1" || href(doc, "first") != "/api/v1/books?limit=1" || href(doc, "prev") != "" {
+		t.Errorf("Unexpected first page links %s", response.Body.String())
+	}
+
+	// Following next, then prev, comes back to the first page
+	next, _ := url.Parse(href(doc, "next"))
+	response, doc = get(next.RequestURI())
+	embedded, _ = doc["_embedded"].(map[string]interface{})
+	if books, _ := embedded["books"].([]interface{}); len(books) != 1 || books[0].(map[string]interface{})["title"] != "Book 2" {
+		t.Fatalf("Unexpected second page %s", response.Body.String())
+	}
+	response, doc = get(href(doc, "prev"))
+	embedded, _ = doc["_embedded"].(map[string]interface{})
+	if books, _ := embedded["books"].([]interface{}); len(books) != 1 || books[0].(map[string]interface{})["title"] != "Book 1" {
+		t.Errorf("Expected prev to lead back to the first page, got %s", response.Body.String())
+	}
+
+	// Errors pass through as they are
+	if response, _ := get("/api/v1/books/999999"); response.Code != http.StatusNotFound || response.Header().Get("Content-Type") == halType {
+		t.Errorf("Expected a plain 404, got %d %q", response.Code, response.Header().Get("Content-Type"))
+	}
+}
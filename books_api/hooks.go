@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// BookHook sees a book as it is written. It runs inside the write
+// transaction: tx can read or write other rows, and an error rolls the
+// whole write back. Before hooks may also change the book.
+type BookHook func(tx *gorm.DB, b *Book) error
+
+// SearchHook sees the results of a search and returns the ones to send,
+// in order. It runs after paging, so filtering can shorten a page.
+type SearchHook func(r *http.Request, query string, books []Book) ([]Book, error)
+
+// AuthHook runs after a user signs in, with either a password or SAML,
+// before a session token is issued. It may change the principal, for
+// example to grant a role, or refuse the sign-in by returning an error.
+type AuthHook func(ctx context.Context, p *Principal) error
+
+// Plugin bundles the hooks for one piece of custom business logic. Plugins
+// are compiled in: add a file to this package that calls RegisterPlugin
+// from an init function. Unset hooks are skipped.
+type Plugin struct {
+	Name string
+
+	BeforeCreate BookHook
+	AfterCreate  BookHook
+	BeforeUpdate BookHook
+	AfterUpdate  BookHook
+	BeforeDelete BookHook
+	AfterDelete  BookHook
+
+	OnSearch SearchHook
+	OnAuth   AuthHook
+}
+
+// Registered plugins, in registration order
+var plugins []*Plugin
+
+// RegisterPlugin adds p to the hooks run by the API. It panics if the name
+// is empty or taken, since that is a programming error.
+func RegisterPlugin(p *Plugin) {
+	if p.Name == "" {
+		panic("plugin name is required")
+	}
+	for _, existing := range plugins {
+		if existing.Name == p.Name {
+			panic(fmt.Sprintf("plugin %q is already registered", p.Name))
+		}
+	}
+	plugins = append(plugins, p)
+}
+
+func initPlugins() {
+	if len(plugins) == 0 {
+		return
+	}
+	names := make([]string, len(plugins))
+	for i, p := range plugins {
+		names[i] = p.Name
+	}
+	log.Printf("Loaded plugins: %s", strings.Join(names, ", "))
+}
+
+// HookError refuses an operation with a client error instead of a 500.
+// Fields, when set, are reported like validation errors with status 400.
+// Code is the problem code clients see, "rejected" when empty.
+type HookError struct {
+	Status  int
+	Message string
+	Code    string
+	Fields  []FieldError
+}
+
+func (e *HookError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RejectField refuses a write because of one field's value
+func RejectField(field, message string) error {
+	return &HookError{
+		Status:  http.StatusBadRequest,
+		Message: "Validation failed",
+		Fields:  []FieldError{{Field: field, Message: message}},
+	}
+}
+
+// Write the response for a HookError in err's chain, reporting whether
+// there was one
+func writeHookError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var he *HookError
+	if !errors.As(err, &he) {
+		return false
+	}
+	if len(he.Fields) > 0 {
+		writeValidationErrors(w, r, he.Fields)
+	} else {
+		code := he.Code
+		if code == "" {
+			code = "rejected"
+		}
+		writeError(w, r, he.Status, code, he.Message)
+	}
+	return true
+}
+
+// Book hook stages
+const (
+	hookBeforeCreate = iota
+	hookAfterCreate
+	hookBeforeUpdate
+	hookAfterUpdate
+	hookBeforeDelete
+	hookAfterDelete
+)
+
+func (p *Plugin) bookHook(stage int) BookHook {
+	switch stage {
+	case hookBeforeCreate:
+		return p.BeforeCreate
+	case hookAfterCreate:
+		return p.AfterCreate
+	case hookBeforeUpdate:
+		return p.BeforeUpdate
+	case hookAfterUpdate:
+		return p.AfterUpdate
+	case hookBeforeDelete:
+		return p.BeforeDelete
+	case hookAfterDelete:
+		return p.AfterDelete
+	}
+	return nil
+}
+
+// Run every plugin's hook for a stage, stopping at the first error
+func runBookHooks(tx *gorm.DB, stage int, b *Book) error {
+	for _, p := range plugins {
+		if hook := p.bookHook(stage); hook != nil {
+			if err := hook(tx, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runSearchHooks(r *http.Request, query string, books []Book) ([]Book, error) {
+	for _, p := range plugins {
+		if p.OnSearch == nil {
+			continue
+		}
+		var err error
+		if books, err = p.OnSearch(r, query, books); err != nil {
+			return nil, err
+		}
+	}
+	return books, nil
+}
+
+func runAuthHooks(ctx context.Context, p *Principal) error {
+	for _, plugin := range plugins {
+		if plugin.OnAuth != nil {
+			if err := plugin.OnAuth(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write the response for a sign-in refused by an auth hook
+func writeAuthHookError(w http.ResponseWriter, r *http.Request, username string, err error) {
+	if writeHookError(w, r, err) {
+		return
+	}
+	log.Printf("Sign-in hook for %q failed: %v", username, err)
+	writeError(w, r, http.StatusInternalServerError, "internal_error", "Sign-in failed")
+}
+
+// GORM calls these for every code path that writes a book, like the
+// after hooks in events.go. Bulk writes through Model(&Book{}) carry no
+// book, so update and delete hooks skip them. The author is linked after
+// plugins run, so it follows any name they set.
+
+func (b *Book) BeforeCreate(tx *gorm.DB) error {
+	if err := runBookHooks(tx, hookBeforeCreate, b); err != nil {
+		return err
+	}
+	if err := checkISBNFree(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookPublisher(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookSeries(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookStatus(tx, b); err != nil {
+		return err
+	}
+	if err := resolveBookAuthor(tx, b); err != nil {
+		return err
+	}
+	return resolveBookWork(tx, b)
+}
+
+func (b *Book) BeforeUpdate(tx *gorm.DB) error {
+	if b.ID == 0 {
+		return nil
+	}
+	if err := runBookHooks(tx, hookBeforeUpdate, b); err != nil {
+		return err
+	}
+	if err := checkISBNFree(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookPublisher(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookSeries(tx, b); err != nil {
+		return err
+	}
+	if err := checkBookStatus(tx, b); err != nil {
+		return err
+	}
+	if err := resolveBookAuthor(tx, b); err != nil {
+		return err
+	}
+	if err := resolveBookWork(tx, b); err != nil {
+		return err
+	}
+	if err := bumpBookVersion(tx, b); err != nil {
+		return err
+	}
+	return recordBookRevision(tx, b)
+}
+
+func (b *Book) BeforeDelete(tx *gorm.DB) error {
+	if b.ID == 0 {
+		return nil
+	}
+	if err := runBookHooks(tx, hookBeforeDelete, b); err != nil {
+		return err
+	}
+	return bumpBookVersion(tx, b)
+}
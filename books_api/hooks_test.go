@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Register a plugin for one test
+func usePlugin(t *testing.T, p *Plugin) {
+	saved := plugins
+	plugins = nil
+	RegisterPlugin(p)
+	t.Cleanup(func() { plugins = saved })
+}
+
+func TestBookHooks(t *testing.T) {
+	clearDB()
+	var deleted []string
+	usePlugin(t, &Plugin{
+		Name: "policy",
+		BeforeCreate: func(tx *gorm.DB, b *Book) error {
+			if !strings.HasPrefix(b.ISBN, "978") {
+				return RejectField("isbn", "must start with 978")
+			}
+			b.Title = strings.TrimSpace(b.Title)
+			return nil
+		},
+		BeforeDelete: func(tx *gorm.DB, b *Book) error {
+			if b.PriceCents > 0 {
+				return &HookError{Status: http.StatusConflict, Message: "Books on sale are kept"}
+			}
+			return nil
+		},
+		AfterDelete: func(tx *gorm.DB, b *Book) error {
+			deleted = append(deleted, b.Title)
+			return nil
+		},
+	})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/books", bytes.NewBufferString(`{"title":"Dune","author":"Frank Herbert","isbn":"9791000000015"}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "must start with 978") {
+		t.Fatalf("Expected the hook to refuse the ISBN, got %d: %s", response.Code, response.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/books", bytes.NewBufferString(`{"title":" Dune ","author":"Frank Herbert","isbn":"9780441013593"}`))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusCreated || book.Title != "Dune" {
+		t.Fatalf("Expected the hook to tidy the title, got %d: %s", response.Code, response.Body.String())
+	}
+
+	db.Create(&Review{BookID: book.ID, Rating: 5})
+	db.Model(&book).Update("price_cents", 1299)
+	req, _ = http.NewRequest("DELETE", "/api/v1/books/1", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d", response.Code)
+	}
+	var count int64
+	db.Model(&Review{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the refused delete to roll back, got %d reviews", count)
+	}
+
+	db.Model(&book).Update("price_cents", 0)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNoContent || len(deleted) != 1 || deleted[0] != "Dune" {
+		t.Errorf("Expected the delete and its after hook, got %d %v", response.Code, deleted)
+	}
+}
+
+func TestSearchHook(t *testing.T) {
+	clearDB()
+	usePlugin(t, &Plugin{
+		Name: "hide-restricted",
+		OnSearch: func(r *http.Request, query string, books []Book) ([]Book, error) {
+			var visible []Book
+			for _, b := range books {
+				if !strings.Contains(b.Description, "restricted") {
+					visible = append(visible, b)
+				}
+			}
+			return visible, nil
+		},
+	})
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696", Description: "restricted"})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/search?q=dune", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if response.Code != http.StatusOK || len(books) != 1 || books[0].Title != "Dune" {
+		t.Errorf("Expected only the unrestricted book, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestAuthHook(t *testing.T) {
+	withAuth(t, stubAuthenticator{
+		"ann:pw": {Username: "ann", Email: "ann@library.example", Roles: []string{roleReader}},
+		"eve:pw": {Username: "eve", Email: "eve@elsewhere.example", Roles: []string{roleReader}},
+	})
+	usePlugin(t, &Plugin{
+		Name: "staff-domain",
+		OnAuth: func(ctx context.Context, p *Principal) error {
+			if !strings.HasSuffix(p.Email, "@library.example") {
+				return &HookError{Status: http.StatusForbidden, Message: "Only library staff can sign in"}
+			}
+			p.Roles = []string{roleLibrarian}
+			return nil
+		},
+	})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(`{"username":"eve","password":"pw"}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(`{"username":"ann","password":"pw"}`))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var login LoginResponse
+	json.Unmarshal(response.Body.Bytes(), &login)
+	if response.Code != http.StatusOK || len(login.User.Roles) != 1 || login.User.Roles[0] != roleLibrarian {
+		t.Errorf("Expected the hook to grant librarian, got %d: %s", response.Code, response.Body.String())
+	}
+}
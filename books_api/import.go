@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Catalog import: books from a CSV in the export's columns, a JSON array
+// of books, or a database export. Rows are matched to the catalog by ISBN;
+// new ones are created and existing ones updated with the fields the row
+// gives.
+
+// Catalog import formats
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// Existing books are looked up this many ISBNs at a time
+const importLookupBatch = 500
+
+// Tags in a JSON import, as names or as the API's tag objects
+type importTags []string
+
+func (t *importTags) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, r := range raw {
+		var name string
+		if json.Unmarshal(r, &name) != nil {
+			var tag Tag
+			if err := json.Unmarshal(r, &tag); err != nil {
+				return err
+			}
+			name = tag.Name
+		}
+		*t = append(*t, name)
+	}
+	return nil
+}
+
+// A book in a JSON import
+type importBook struct {
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	ISBN        string     `json:"isbn"`
+	Year        int        `json:"year"`
+	Description string     `json:"description"`
+	CoverURL    string     `json:"cover_url"`
+	PriceCents  int64      `json:"price_cents"`
+	Tags        importTags `json:"tags"`
+}
+
+func (b *importBook) row(n int) ImportRow {
+	return ImportRow{
+		Row: n, Title: b.Title, Author: b.Author, ISBN: cleanISBN(b.ISBN), Year: b.Year,
+		Tags: splitTags(strings.Join(b.Tags, ",")),
+		book: &Book{
+			Title: b.Title, Author: b.Author, ISBN: cleanISBN(b.ISBN), Year: b.Year,
+			Description: b.Description, CoverURL: b.CoverURL, PriceCents: b.PriceCents,
+		},
+	}
+}
+
+// Undo csvText's quoting of cells that look like formulas
+func csvUnquote(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// Parse a CSV with the columns of the books export. Only title, author
+// and isbn are required; id is ignored, since IDs differ between
+// environments. Row numbers count the header as row 1.
+func parseCatalogCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	first, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	header := newCSVHeader(first)
+	for _, name := range []string{"title", "author", "isbn"} {
+		if !header.has(name) {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", line, err)
+		}
+		get := func(name string) string { return csvUnquote(header.get(record, name)) }
+		b := importBook{
+			Title: get("title"), Author: get("author"), ISBN: get("isbn"),
+			Description: get("description"), CoverURL: get("cover_url"),
+		}
+		var problem string
+		if v := get("year"); v != "" {
+			if b.Year, err = strconv.Atoi(v); err != nil {
+				problem = "year must be a number"
+			}
+		}
+		if v := get("price_cents"); v != "" {
+			if b.PriceCents, err = strconv.ParseInt(v, 10, 64); err != nil {
+				problem = "price_cents must be a number"
+			}
+		}
+		b.Tags = strings.Split(get("tags"), ",")
+		row := b.row(line)
+		if problem != "" {
+			row.Action, row.Message = actionError, problem
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Parse a JSON array of books, or a database export as NDJSON or an
+// array, whose book records are imported and other records ignored
+func parseCatalogJSON(data []byte) ([]ImportRow, error) {
+	var items []json.RawMessage
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, maxImportBytes)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				items = append(items, json.RawMessage(bytes.Clone(line)))
+			}
+		}
+	}
+
+	var rows []ImportRow
+	for i, item := range items {
+		var rec struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if json.Unmarshal(item, &rec) == nil && rec.Type != "" && rec.Data != nil {
+			if rec.Type != "book" {
+				continue
+			}
+			item = rec.Data
+		}
+		var b importBook
+		if err := json.Unmarshal(item, &b); err != nil {
+			rows = append(rows, ImportRow{Row: i + 1, Action: actionError, Message: "invalid book: " + err.Error()})
+			continue
+		}
+		rows = append(rows, b.row(i+1))
+	}
+	return rows, nil
+}
+
+// Decide what to do with every row without writing anything: create new
+// ISBNs, update existing books that change, skip the rest
+func planCatalogImport(ctx context.Context, rows []ImportRow) {
+	var isbns []string
+	for _, row := range rows {
+		if row.Action == "" && row.ISBN != "" {
+			isbns = append(isbns, row.ISBN)
+		}
+	}
+	existing := map[string]*Book{}
+	for start := 0; start < len(isbns); start += importLookupBatch {
+		var books []Book
+		db.WithContext(ctx).Preload("Tags").Where("isbn IN ?", isbns[start:min(start+importLookupBatch, len(isbns))]).Find(&books)
+		for i := range books {
+			existing[books[i].ISBN] = &books[i]
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := range rows {
+		row := &rows[i]
+		if row.Action != "" {
+			continue
+		}
+		if row.ISBN != "" && seen[row.ISBN] {
+			row.Action, row.Message = actionSkip, "duplicate ISBN in file"
+			continue
+		}
+		seen[row.ISBN] = true
+
+		old, ok := existing[row.ISBN]
+		changed := false
+		if ok {
+			merged := *old
+			changed = mergeBookUpdate(&merged, row.book)
+			*row.book = merged
+		}
+		if errs := validateBook(row.book); len(errs) > 0 {
+			row.Action, row.Message = actionError, fmt.Sprintf("%s %s", errs[0].Field, errs[0].Message)
+			continue
+		}
+		if !ok {
+			row.Action = actionCreate
+			continue
+		}
+
+		has := map[string]bool{}
+		for _, t := range old.Tags {
+			has[t.Name] = true
+		}
+		for _, t := range row.Tags {
+			changed = changed || !has[t]
+		}
+		if changed {
+			row.Action = actionUpdate
+		} else {
+			row.Action, row.Message = actionSkip, "book exists and is unchanged"
+		}
+	}
+}
+
+// Write planned rows
+func applyCatalogImport(tx *gorm.DB, rows []ImportRow) error {
+	for i := range rows {
+		row := &rows[i]
+		var err error
+		switch row.Action {
+		case actionCreate:
+			err = tx.Omit("Tags").Create(row.book).Error
+		case actionUpdate:
+			err = tx.Omit("Tags").Save(row.book).Error
+		default:
+			continue
+		}
+		if err == nil {
+			err = appendTags(tx, row.book, row.Tags)
+		}
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row.Row, err)
+		}
+	}
+	return nil
+}
+
+// Import books from an uploaded CSV or JSON file. The format is taken
+// from the content: JSON starts with [ or {.
+func importCatalog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	body, err := importBody(w, r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "invalid_body", "Upload is too large")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Failed to read the upload")
+		return
+	}
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\ufeff")))
+
+	format := formatCSV
+	var rows []ImportRow
+	if len(data) > 0 && (data[0] == '[' || data[0] == '{') {
+		format = formatJSON
+		rows, err = parseCatalogJSON(data)
+	} else {
+		rows, err = parseCatalogCSV(bytes.NewReader(data))
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid %s: %v", strings.ToUpper(format), err))
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "The upload has no books")
+		return
+	}
+
+	planCatalogImport(r.Context(), rows)
+	runImport(w, r, format, rows, func(tx *gorm.DB) error {
+		return applyCatalogImport(tx, rows)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const catalogCSVFixture = `id,title,author,isbn,year,description,cover_url,price_cents,tags
+7,Dune,Frank Herbert,978-0-441-01359-3,1965,'-Spice-,,1999,"sf, classics"
+8,Emma,Jane Austen,9780141439587,1815,,,,
+9,Dune again,Frank Herbert,9780441013593,1965,,,,
+10,No ISBN,Someone,,2001,,,,
+11,Bad Year,Someone,9780132350884,soon,,,,
+`
+
+func postCatalogImport(t *testing.T, query, contentType, body string) (*httptest.ResponseRecorder, ImportReport) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", "/api/v1/import"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+	setupRouter().ServeHTTP(response, req)
+
+	var report ImportReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	return response, report
+}
+
+func TestImportCatalogCSV(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815})
+
+	response, report := postCatalogImport(t, "", csvType, catalogCSVFixture)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	if report.Format != formatCSV || report.Created != 1 || report.Updated != 0 || report.Skipped != 2 || report.Errors != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	want := []string{actionCreate, actionSkip, actionSkip, actionError, actionError}
+	for i, row := range report.Rows {
+		if row.Action != want[i] || row.Row != i+2 {
+			t.Errorf("Row %d: expected %s, got %s (%s)", row.Row, want[i], row.Action, row.Message)
+		}
+	}
+	if report.Rows[4].Message != "year must be a number" {
+		t.Errorf("Unexpected message %q", report.Rows[4].Message)
+	}
+
+	var dune Book
+	db.Preload("Tags").Where("isbn = ?", "9780441013593").First(&dune)
+	if dune.Description != "-Spice-" || dune.PriceCents != 1999 || len(dune.Tags) != 2 {
+		t.Errorf("Expected the book with every column, got %+v", dune)
+	}
+}
+
+func TestImportCatalogJSONUpdates(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Tags: []Tag{{Name: "sf"}}})
+
+	body := `[
+		{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441013593", "description": "Spice", "tags": ["sf"]},
+		{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439587", "tags": [{"name": "classics"}]},
+		{"title": 42}
+	]`
+	_, report := postCatalogImport(t, "?dry_run=true", "application/json", body)
+	if !report.DryRun || report.Format != formatJSON || report.Created != 1 || report.Updated != 1 || report.Errors != 1 {
+		t.Fatalf("Unexpected dry run %+v", report)
+	}
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected a dry run to change nothing, found %d books", count)
+	}
+
+	postCatalogImport(t, "", "application/json", body)
+	var dune Book
+	db.Where("isbn = ?", "9780441013593").First(&dune)
+	if dune.Description != "Spice" || dune.Year != 1965 {
+		t.Errorf("Expected the description added and the year kept, got %+v", dune)
+	}
+
+	// The same file again changes nothing
+	_, report = postCatalogImport(t, "", "application/json", body)
+	if report.Created != 0 || report.Updated != 0 || report.Skipped != 2 {
+		t.Errorf("Expected unchanged books skipped, got %+v", report)
+	}
+}
+
+func TestImportCatalogFromExport(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Tags: []Tag{{Name: "sf"}}})
+	db.Create(&Review{BookID: 1, Rating: 5})
+
+	req, _ := http.NewRequest("GET", "/api/v1/export", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	dump := response.Body.String()
+	clearDB()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "books.ndjson")
+	part.Write([]byte(dump))
+	form.Close()
+	response, report := postCatalogImport(t, "", form.FormDataContentType(), body.String())
+	if response.Code != http.StatusOK || report.Created != 1 || len(report.Rows) != 1 {
+		t.Fatalf("Expected the exported book imported, got %d: %s", response.Code, response.Body.String())
+	}
+	var dune Book
+	db.Preload("Tags").First(&dune)
+	if dune.Title != "Dune" || len(dune.Tags) != 1 || dune.Tags[0].Name != "sf" {
+		t.Errorf("Unexpected book %+v", dune)
+	}
+}
+
+func TestImportCatalogRejectsBadFiles(t *testing.T) {
+	clearDB()
+	for _, body := range []string{"", "name,isbn\nDune,9780441013593\n", "[1,", "id,title,author,isbn\n"} {
+		if response, _ := postCatalogImport(t, "", csvType, body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", body, response.Code)
+		}
+	}
+}
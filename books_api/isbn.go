@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Whether isbn, without separators, is an ISBN-10 or ISBN-13 with a
+// correct check digit
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			digit := int(c - '0')
+			if i == 9 && c == 'X' {
+				digit = 10
+			} else if c < '0' || c > '9' {
+				return false
+			}
+			sum += (10 - i) * digit
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			if i%2 == 1 {
+				sum += 3 * int(c-'0')
+			} else {
+				sum += int(c - '0')
+			}
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// The forms a valid ISBN may be catalogued under: itself and, for an
+// ISBN-10 or a 978 ISBN-13, the other length
+func isbnForms(isbn string) []string {
+	if isbn13 := toISBN13(isbn); isbn13 != isbn {
+		return []string{isbn, isbn13}
+	}
+	if isbn10, ok := toISBN10(isbn); ok {
+		return []string{isbn, isbn10}
+	}
+	return []string{isbn}
+}
+
+// The ISBN-13 of a valid ISBN, the form books are stored under. An
+// ISBN-10 gains the 978 prefix and a new check digit.
+func toISBN13(isbn string) string {
+	if len(isbn) != 10 {
+		return isbn
+	}
+	isbn13 := "978" + isbn[:9]
+	return isbn13 + isbn13CheckDigit(isbn13)
+}
+
+// The ISBN-10 of a valid ISBN-13, which only those under 978 have
+func toISBN10(isbn string) (string, bool) {
+	if len(isbn) != 13 || !strings.HasPrefix(isbn, "978") {
+		return "", false
+	}
+	return isbn[3:12] + isbn10CheckDigit(isbn[3:12]), true
+}
+
+func isbn13CheckDigit(first12 string) string {
+	sum := 0
+	for i, c := range first12 {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(c-'0')
+	}
+	return string(rune('0' + (10-sum%10)%10))
+}
+
+func isbn10CheckDigit(first9 string) string {
+	sum := 0
+	for i, c := range first9 {
+		sum += (10 - i) * int(c-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return "X"
+	}
+	return string(rune('0' + check))
+}
+
+// Get the book with an ISBN, typed with or without dashes, as ISBN-10 or
+// ISBN-13. Stored ISBNs are compared without their separators too.
+func getBookByISBN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	isbn := strings.ToUpper(cleanISBN(mux.Vars(r)["isbn"]))
+	if !validISBN(isbn) {
+		writeError(w, r, http.StatusBadRequest, "invalid_isbn", "Invalid ISBN")
+		return
+	}
+
+	book, err := findBookByISBN(r.Context(), isbn)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+	writeJSONConditional(w, r, book)
+}
+
+// The book catalogued under any form of a valid, cleaned ISBN, in the
+// context's library
+func findBookByISBN(ctx context.Context, isbn string) (*Book, error) {
+	var book Book
+	err := db.WithContext(ctx).Preload("Tags").
+		Where("UPPER(REPLACE(REPLACE(isbn, '-', ''), ' ', '')) IN ?", isbnForms(isbn)).
+		First(&book).Error
+	return &book, err
+}
+
+// Refuse to write a book under an ISBN another book holds. Deleted books
+// keep their ISBN until the trash is purged and GORM's scoped queries
+// can't see them, so this looks in the trash too: restoring that book is
+// the way to get it back. Runs in the book's write transaction.
+func checkISBNFree(tx *gorm.DB, b *Book) error {
+	var owner Book
+	err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Where("isbn = ? AND id <> ?", b.ISBN, b.ID).Limit(1).Find(&owner).Error
+	switch {
+	case err != nil:
+		return err
+	case owner.ID != 0 && owner.DeletedAt.Valid:
+		return &HookError{
+			Status:  http.StatusConflict,
+			Code:    "isbn_in_trash",
+			Message: fmt.Sprintf("The ISBN belongs to deleted book %d; restore it instead", owner.ID),
+		}
+	case owner.ID != 0:
+		return &HookError{Status: http.StatusConflict, Code: "isbn_exists", Message: "A book with this ISBN already exists"}
+	}
+	return nil
+}
+
+// The deleted book catalogued under any form of a valid, cleaned ISBN, in
+// the context's library
+func findTrashedBookByISBN(ctx context.Context, isbn string) (*Book, error) {
+	var book Book
+	err := trashedBooks().WithContext(ctx).Preload("Tags").
+		Where("UPPER(REPLACE(REPLACE(isbn, '-', ''), ' ', '')) IN ?", isbnForms(isbn)).
+		First(&book).Error
+	return &book, err
+}
+
+// Create the book with an ISBN, or update it if the catalog has it, for
+// sync jobs that can't know which. Answers 201 for a new book and 200 for
+// an update. Like PUT /books/{id}, an update only changes the fields the
+// body sets, and honors If-Match. A book in the trash is restored and
+// updated, so a sync can bring back a book deleted by mistake.
+func upsertBookByISBN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	isbn := strings.ToUpper(cleanISBN(mux.Vars(r)["isbn"]))
+	if !validISBN(isbn) {
+		writeError(w, r, http.StatusBadRequest, "invalid_isbn", "Invalid ISBN")
+		return
+	}
+	var update Book
+	if err := decodeBody(r, &update); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if update.ISBN != "" && !slices.Contains(isbnForms(isbn), strings.ToUpper(cleanISBN(update.ISBN))) {
+		writeError(w, r, http.StatusBadRequest, "isbn_mismatch", "Body ISBN does not match the URL")
+		return
+	}
+
+	book, err := findBookByISBN(r.Context(), isbn)
+	if err != nil {
+		book, err = findTrashedBookByISBN(r.Context(), isbn)
+	}
+	created := err != nil
+	restored := !created && book.DeletedAt.Valid
+	if created {
+		book = &update
+		book.ID = 0
+		if book.ISBN == "" {
+			book.ISBN = isbn
+		}
+	} else {
+		if !restored && !checkIfMatch(w, r, book) {
+			return
+		}
+		book.DeletedAt = gorm.DeletedAt{}
+		// The body's ISBN is the book's own, perhaps in its other form
+		update.ISBN = ""
+		mergeBookUpdate(book, &update)
+	}
+
+	if errs := validateBook(book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	switch {
+	case created:
+		err = db.WithContext(r.Context()).Create(book).Error
+	case restored:
+		err = db.WithContext(r.Context()).Unscoped().Omit("Tags").Save(book).Error
+	default:
+		err = db.WithContext(r.Context()).Omit("Tags").Save(book).Error
+	}
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to save book")
+		}
+		return
+	}
+
+	setBookETag(w, book.ID)
+	if created {
+		notifyBookAdded(book)
+		w.Header().Set("Location", fmt.Sprintf("%s/books/%d", versionFrom(r).Prefix, book.ID))
+		writeJSON(w, http.StatusCreated, book)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+// Store the ISBNs of books from before ISBN-10s were converted as
+// ISBN-13s without separators. A book whose ISBN isn't valid, or whose
+// ISBN-13 another book holds, is left as it is. Does nothing once every
+// ISBN is stored that way.
+func backfillISBN13() {
+	var books []Book
+	if err := db.Unscoped().Select("id", "isbn").Where("LENGTH(isbn) <> 13 OR isbn LIKE '%-%' OR isbn LIKE '% %'").Find(&books).Error; err != nil {
+		log.Fatal("Failed to backfill ISBN-13s:", err)
+	}
+	for _, b := range books {
+		isbn := strings.ToUpper(cleanISBN(b.ISBN))
+		if !validISBN(isbn) {
+			continue
+		}
+		if err := db.Unscoped().Model(&b).UpdateColumn("isbn", toISBN13(isbn)).Error; err != nil {
+			log.Printf("Failed to store book %d's ISBN as ISBN-13: %v", b.ID, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidISBN(t *testing.T) {
+	for isbn, want := range map[string]bool{
+		"9780441013593": true,
+		"0441013597":    true,
+		"080442957X":    true,
+		"9780441013594": false,
+		"0441013596":    false,
+		"97804410135":   false,
+		"978044101359A": false,
+		"X441013597":    false,
+	} {
+		if got := validISBN(isbn); got != want {
+			t.Errorf("validISBN(%s) = %v, want %v", isbn, got, want)
+		}
+	}
+}
+
+func TestISBNConversion(t *testing.T) {
+	for isbn10, isbn13 := range map[string]string{
+		"0441013597": "9780441013593",
+		"080442957X": "9780804429573",
+		"0141439580": "9780141439587",
+	} {
+		if got := toISBN13(isbn10); got != isbn13 {
+			t.Errorf("toISBN13(%s) = %s, want %s", isbn10, got, isbn13)
+		}
+		if got, ok := toISBN10(isbn13); !ok || got != isbn10 {
+			t.Errorf("toISBN10(%s) = %s, want %s", isbn13, got, isbn10)
+		}
+	}
+	if _, ok := toISBN10("9791000000015"); ok {
+		t.Errorf("Expected no ISBN-10 for a 979 ISBN-13")
+	}
+	if got := toISBN13("9791000000015"); got != "9791000000015" {
+		t.Errorf("Expected an ISBN-13 unchanged, got %s", got)
+	}
+}
+
+func TestBackfillISBN13(t *testing.T) {
+	clearDB()
+	for _, isbn := range []string{"0-441-01359-7", "9780141439587", "not an isbn"} {
+		db.Create(&Book{Title: isbn, Author: "A", ISBN: isbn})
+	}
+
+	backfillISBN13()
+	var isbns []string
+	db.Model(&Book{}).Order("id").Pluck("isbn", &isbns)
+	if strings.Join(isbns, ",") != "9780441013593,9780141439587,not an isbn" {
+		t.Errorf("Expected valid ISBNs stored as ISBN-13s, got %v", isbns)
+	}
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-01359-3"}
+	db.Create(&dune)
+	router := setupRouter()
+
+	for _, tc := range []struct {
+		isbn   string
+		status int
+	}{
+		{"9780441013593", http.StatusOK},
+		{"978-0-441-01359-3", http.StatusOK},
+		{"0-441-01359-7", http.StatusOK},
+		{"9780141439587", http.StatusNotFound},
+		{"9780441013594", http.StatusBadRequest},
+		{"dune", http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/books/isbn/"+tc.isbn, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+
+		if response.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.isbn, tc.status, response.Code, response.Body.String())
+			continue
+		}
+		if tc.status == http.StatusOK {
+			var got Book
+			json.Unmarshal(response.Body.Bytes(), &got)
+			if got.ID != dune.ID {
+				t.Errorf("%s: expected book %d, got %s", tc.isbn, dune.ID, response.Body.String())
+			}
+		}
+	}
+}
+
+func TestUpsertBookByISBN(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	put := func(isbn, body string, header ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/api/v1/books/isbn/"+isbn, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	response := put("978-0-441-01359-3", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Book
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.ISBN != "9780441013593" || response.Header().Get("Location") != "/api/v1/books/1" || response.Header().Get("ETag") == "" {
+		t.Errorf("Unexpected create %+v %v", created, response.Header())
+	}
+
+	// The ISBN-10 finds the same book; only the fields sent change
+	response = put("0441013597", `{"description":"Spice"}`)
+	var updated Book
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.ID != created.ID || updated.Description != "Spice" || updated.Year != 1965 || updated.ISBN != "9780441013593" {
+		t.Errorf("Expected the book updated, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = put("9780441013593", `{"description":"Spice"}`); response.Code != http.StatusOK {
+		t.Errorf("Expected a repeat to succeed, got %d", response.Code)
+	}
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected one book, got %d", count)
+	}
+
+	for _, tc := range []struct {
+		isbn, body string
+		header     []string
+		status     int
+	}{
+		{"9780441013594", `{"title":"Dune","author":"Frank Herbert"}`, nil, http.StatusBadRequest},
+		{"9780441013593", `{"isbn":"9780141439587"}`, nil, http.StatusBadRequest},
+		{"9780141439587", `{"title":"Emma"}`, nil, http.StatusBadRequest},
+		{"9780441013593", `{"year":1966}`, []string{"If-Match", `W/"stale"`}, http.StatusPreconditionFailed},
+	} {
+		if response := put(tc.isbn, tc.body, tc.header...); response.Code != tc.status {
+			t.Errorf("PUT %s %s: expected %d, got %d", tc.isbn, tc.body, tc.status, response.Code)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Background work item
+type job struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// jobQueue runs jobs on a fixed pool of worker goroutines
+type jobQueue struct {
+	ch      chan job
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	timeout time.Duration
+}
+
+// Shared queue, started in main
+var jobs *jobQueue
+
+func newJobQueue(workers, size int, timeout time.Duration) *jobQueue {
+	q := &jobQueue{ch: make(chan job, size), timeout: timeout}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	defer q.workers.Done()
+	for j := range q.ch {
+		q.runJob(j)
+	}
+}
+
+func (q *jobQueue) runJob(j job) {
+	defer q.pending.Done()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Job %s panicked: %v", j.name, p)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+	if err := j.run(ctx); err != nil {
+		log.Printf("Job %s failed: %v", j.name, err)
+	}
+}
+
+// Queue a job. Returns false, dropping the job, when the queue is full.
+func (q *jobQueue) Enqueue(name string, run func(ctx context.Context) error) bool {
+	q.pending.Add(1)
+	select {
+	case q.ch <- job{name: name, run: run}:
+		return true
+	default:
+		q.pending.Done()
+		log.Printf("Job queue full, dropping %s", name)
+		return false
+	}
+}
+
+// Block until every queued job has finished
+func (q *jobQueue) Wait() {
+	q.pending.Wait()
+}
+
+// Stop accepting jobs and wait for workers to drain the queue
+func (q *jobQueue) Close() {
+	close(q.ch)
+	q.workers.Wait()
+}
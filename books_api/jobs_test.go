@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobQueueRunsJobs(t *testing.T) {
+	q := newJobQueue(2, 10, time.Second)
+	defer q.Close()
+
+	var ran int32
+	for i := 0; i < 5; i++ {
+		q.Enqueue("count", func(ctx context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			return nil
+		})
+	}
+	q.Enqueue("fails", func(ctx context.Context) error { return errors.New("boom") })
+	q.Enqueue("panics", func(ctx context.Context) error { panic("boom") })
+	q.Wait()
+
+	if ran != 5 {
+		t.Errorf("Expected 5 jobs to run, got %d", ran)
+	}
+}
+
+func TestJobQueueDropsWhenFull(t *testing.T) {
+	q := newJobQueue(1, 1, time.Second)
+	defer q.Close()
+
+	// Hold the only worker so the buffer fills up
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.Enqueue("block", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	if !q.Enqueue("buffered", func(ctx context.Context) error { return nil }) {
+		t.Error("Expected the first queued job to be accepted")
+	}
+	if q.Enqueue("dropped", func(ctx context.Context) error { return nil }) {
+		t.Error("Expected a job to be dropped when the queue is full")
+	}
+	close(release)
+	q.Wait()
+}
+
+func TestJobQueueTimesOut(t *testing.T) {
+	q := newJobQueue(1, 1, 10*time.Millisecond)
+	defer q.Close()
+
+	var err error
+	q.Enqueue("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		err = ctx.Err()
+		return err
+	})
+	q.Wait()
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the job context to time out, got %v", err)
+	}
+}
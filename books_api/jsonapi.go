@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media type of the JSON:API (https://jsonapi.org) representation
+const jsonAPIType = "application/vnd.api+json"
+
+// jsonAPIDocument is a JSON:API top-level document
+type jsonAPIDocument struct {
+	Data     interface{}            `json:"data,omitempty"`
+	Errors   []jsonAPIError         `json:"errors,omitempty"`
+	Meta     interface{}            `json:"meta,omitempty"`
+	Links    map[string]string      `json:"links,omitempty"`
+	Included []jsonAPIResource      `json:"included,omitempty"`
+	JSONAPI  map[string]interface{} `json:"jsonapi"`
+}
+
+type jsonAPIResource struct {
+	Type          string                            `json:"type"`
+	ID            string                            `json:"id"`
+	Attributes    map[string]interface{}            `json:"attributes,omitempty"`
+	Relationships map[string]map[string]interface{} `json:"relationships,omitempty"`
+	Links         map[string]string                 `json:"links,omitempty"`
+}
+
+type jsonAPIError struct {
+	Status string            `json:"status"`
+	Code   string            `json:"code,omitempty"`
+	Title  string            `json:"title"`
+	Detail string            `json:"detail,omitempty"`
+	Source map[string]string `json:"source,omitempty"`
+}
+
+// Ask handlers for the id JSON:API needs when the client picks fields the
+// JSON:API way, with fields[books]=title,author
+func jsonAPIQuery(r *http.Request) {
+	q := r.URL.Query()
+	if fields, ok := q["fields[books]"]; ok {
+		q.Del("fields[books]")
+		q.Set("fields", "id,"+strings.Join(fields, ","))
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+// Rewrite a handler's response as a JSON:API document. Objects with an id
+// become resources, pages and arrays of them collections, and anything
+// else, such as a bulk report, goes in meta. Error responses, JSON or
+// plain text, become an errors list.
+func writeJSONAPI(w http.ResponseWriter, r *http.Request, res *bufferedResponse) {
+	doc := jsonAPIDocument{JSONAPI: map[string]interface{}{"version": "1.1"}}
+	var body interface{}
+	decodeJSONNumbers(res.body.Bytes(), &body)
+
+	if res.status >= 400 {
+		doc.Errors = jsonAPIErrors(res.status, body, strings.TrimSpace(res.body.String()))
+	} else {
+		doc.Links = map[string]string{"self": r.URL.RequestURI()}
+		typ := resourceType(r.URL.Path)
+		prefix := versionFrom(r).Prefix
+		included := map[string]bool{}
+		switch v := body.(type) {
+		case map[string]interface{}:
+			if items, ok := v["items"].([]interface{}); ok {
+				doc.Data = jsonAPICollection(&doc, included, prefix, typ, items)
+				for _, rel := range []string{"next", "prev"} {
+					if cursor, ok := v[rel+"_cursor"].(string); ok {
+						doc.Links[rel] = pageURL(r, cursor)
+					}
+				}
+			} else if _, ok := v["id"]; ok {
+				doc.Data = jsonAPIResourceFrom(&doc, included, prefix, typ, v)
+			} else {
+				doc.Meta = v
+			}
+		case []interface{}:
+			doc.Data = jsonAPICollection(&doc, included, prefix, typ, v)
+		default:
+			doc.Meta = map[string]interface{}{"value": v}
+		}
+	}
+
+	// A 204 stays empty
+	if res.body.Len() == 0 && res.status < 400 {
+		w.WriteHeader(res.status)
+		return
+	}
+	w.Header().Set("Content-Type", jsonAPIType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(res.status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+func jsonAPICollection(doc *jsonAPIDocument, included map[string]bool, prefix, typ string, items []interface{}) []jsonAPIResource {
+	data := []jsonAPIResource{}
+	for _, item := range items {
+		if obj, ok := item.(map[string]interface{}); ok {
+			data = append(data, jsonAPIResourceFrom(doc, included, prefix, typ, obj))
+		}
+	}
+	return data
+}
+
+// A resource from an object, with its tags as a relationship to included
+// tag resources
+func jsonAPIResourceFrom(doc *jsonAPIDocument, included map[string]bool, prefix, typ string, obj map[string]interface{}) jsonAPIResource {
+	res := jsonAPIResource{Type: typ, ID: jsonAPIID(obj["id"]), Attributes: map[string]interface{}{}}
+	for k, v := range obj {
+		if k != "id" && !(typ == "books" && k == "tags") {
+			res.Attributes[k] = v
+		}
+	}
+	if typ == "books" {
+		res.Links = map[string]string{"self": prefix + "/books/" + res.ID}
+		if tags, ok := obj["tags"].([]interface{}); ok {
+			refs := []map[string]string{}
+			for _, t := range tags {
+				tag, _ := t.(map[string]interface{})
+				ref := map[string]string{"type": "tags", "id": jsonAPIID(tag["id"])}
+				refs = append(refs, ref)
+				if !included[ref["id"]] {
+					included[ref["id"]] = true
+					doc.Included = append(doc.Included, jsonAPIResource{
+						Type: "tags", ID: ref["id"], Attributes: map[string]interface{}{"name": tag["name"]},
+					})
+				}
+			}
+			res.Relationships = map[string]map[string]interface{}{"tags": {"data": refs}}
+		}
+	}
+	return res
+}
+
+func jsonAPIID(v interface{}) string {
+	switch id := v.(type) {
+	case json.Number:
+		return id.String()
+	case string:
+		return id
+	}
+	return ""
+}
+
+// Errors for a failed response: one per field for validation errors,
+// otherwise one carrying the message. Problem details keep their code and
+// detail.
+func jsonAPIErrors(status int, body interface{}, text string) []jsonAPIError {
+	code := strconv.Itoa(status)
+	obj, _ := body.(map[string]interface{})
+	problemCode, _ := obj["code"].(string)
+	detail, _ := obj["detail"].(string)
+	title, _ := obj["error"].(string)
+	if title == "" {
+		title, _ = obj["title"].(string)
+	}
+	if title == "" {
+		title = text
+		if obj != nil || title == "" {
+			title = http.StatusText(status)
+		}
+	}
+	var errs []jsonAPIError
+	fields, _ := obj["fields"].([]interface{})
+	for _, f := range fields {
+		fe, _ := f.(map[string]interface{})
+		field, _ := fe["field"].(string)
+		message, _ := fe["message"].(string)
+		errs = append(errs, jsonAPIError{
+			Status: code, Code: problemCode, Title: title, Detail: strings.TrimSpace(field + " " + message),
+			Source: map[string]string{"pointer": "/data/attributes/" + field},
+		})
+	}
+	if len(errs) == 0 {
+		errs = append(errs, jsonAPIError{Status: code, Code: problemCode, Title: title, Detail: detail})
+	}
+	return errs
+}
+
+// Unwrap a JSON:API request document to the attributes handlers decode:
+// {"data": {"type": "books", "attributes": {...}}}, or a list of them for
+// bulk requests
+func decodeJSONAPIBody(body []byte, v interface{}) error {
+	var doc struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return err
+	}
+	type resource struct {
+		ID         string                     `json:"id"`
+		Attributes map[string]json.RawMessage `json:"attributes"`
+	}
+	attributes := func(r resource) map[string]json.RawMessage {
+		if r.Attributes == nil {
+			r.Attributes = map[string]json.RawMessage{}
+		}
+		if id, err := strconv.ParseUint(r.ID, 10, 0); err == nil {
+			r.Attributes["id"] = json.RawMessage(strconv.FormatUint(id, 10))
+		}
+		return r.Attributes
+	}
+
+	var unwrapped interface{}
+	if trimmed := bytes.TrimSpace(doc.Data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var list []resource
+		if err := json.Unmarshal(doc.Data, &list); err != nil {
+			return err
+		}
+		items := make([]map[string]json.RawMessage, len(list))
+		for i, r := range list {
+			items[i] = attributes(r)
+		}
+		unwrapped = items
+	} else {
+		var one resource
+		if err := json.Unmarshal(doc.Data, &one); err != nil {
+			return err
+		}
+		unwrapped = attributes(one)
+	}
+	data, _ := json.Marshal(unwrapped)
+	return json.Unmarshal(data, v)
+}
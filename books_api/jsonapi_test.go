@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONAPIBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	send := func(method, path, body string) (*httptest.ResponseRecorder, jsonAPIDocument) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", jsonAPIType)
+		req.Header.Set("Content-Type", jsonAPIType)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var doc jsonAPIDocument
+		json.Unmarshal(response.Body.Bytes(), &doc)
+		return response, doc
+	}
+
+	created, doc := send("POST", "/api/v1/books", `{"data": {"type": "books", "attributes":
+		{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441013593", "year": 1965, "tags": [{"name": "sci-fi"}]}}}`)
+	if created.Code != http.StatusCreated || created.Header().Get("Content-Type") != jsonAPIType {
+		t.Fatalf("Expected a 201 JSON:API response, got %d %q: %s", created.Code, created.Header().Get("Content-Type"), created.Body.String())
+	}
+	data, _ := json.Marshal(doc.Data)
+	var book jsonAPIResource
+	json.Unmarshal(data, &book)
+	if book.Type != "books" || book.ID == "" || book.Attributes["title"] != "Dune" || book.Links["self"] != "/api/v1/books/"+book.ID {
+		t.Fatalf("Unexpected resource %s", created.Body.String())
+	}
+	if _, ok := book.Attributes["id"]; ok {
+		t.Errorf("Expected the id outside the attributes, got %v", book.Attributes)
+	}
+	if len(doc.Included) != 1 || doc.Included[0].Type != "tags" || doc.Included[0].Attributes["name"] != "sci-fi" {
+		t.Errorf("Expected the tag to be included, got %s", created.Body.String())
+	}
+
+	list, doc := send("GET", "/api/v1/books?fields[books]=title&limit=1", "")
+	if list.Code != http.StatusOK || !strings.Contains(list.Body.String(), `"data":[{"type":"books","id":"`+book.ID+`","attributes":{"title":"Dune"}`) {
+		t.Errorf("Expected a sparse collection, got %d: %s", list.Code, list.Body.String())
+	}
+	if doc.Links["self"] == "" || doc.JSONAPI["version"] != "1.1" {
+		t.Errorf("Expected the top-level links and jsonapi members, got %s", list.Body.String())
+	}
+
+	invalid, doc := send("POST", "/api/v1/books", `{"data": {"type": "books", "attributes": {"author": "Nobody"}}}`)
+	if invalid.Code != http.StatusBadRequest || len(doc.Errors) == 0 || doc.Errors[0].Source["pointer"] != "/data/attributes/title" {
+		t.Errorf("Expected validation errors with pointers, got %d: %s", invalid.Code, invalid.Body.String())
+	}
+	missing, doc := send("GET", "/api/v1/books/999999", "")
+	if missing.Code != http.StatusNotFound || len(doc.Errors) != 1 || doc.Errors[0].Status != "404" || doc.Errors[0].Title != "Book not found" {
+		t.Errorf("Expected a 404 error object, got %d: %s", missing.Code, missing.Body.String())
+	}
+
+	if deleted, _ := send("DELETE", fmt.Sprintf("/api/v1/books/%s", book.ID), ""); deleted.Code != http.StatusNoContent || deleted.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media type of an RFC 6902 JSON Patch
+const jsonPatchType = "application/json-patch+json"
+
+// jsonPatchOp is one operation of a JSON Patch document
+type jsonPatchOp struct {
+	Op   string  `json:"op"`
+	Path *string `json:"path"`
+	From *string `json:"from"`
+	// Left empty when the member is missing, and "null" for a JSON null
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatchError reports the operation a patch failed at. Conflict means
+// the patch was well formed but doesn't fit the document, such as a
+// failed test or a path that doesn't exist.
+type jsonPatchError struct {
+	Index    int
+	Message  string
+	Conflict bool
+}
+
+func (e *jsonPatchError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Message)
+}
+
+// Apply every operation of a JSON Patch to doc in order, stopping at the
+// first failure. The caller only keeps the result if all of them apply.
+func applyJSONPatch(doc interface{}, body []byte) (interface{}, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, &jsonPatchError{Message: "patch must be an array of operations"}
+	}
+	for i, op := range ops {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			if pe, ok := err.(*jsonPatchError); ok {
+				pe.Index = i
+			}
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (op jsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, &jsonPatchError{Message: "missing path"}
+	}
+	path, err := parseJSONPointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, &jsonPatchError{Message: op.Op + " needs a value"}
+		}
+		if err := decodeJSONNumbers(op.Value, &value); err != nil {
+			return nil, &jsonPatchError{Message: "invalid value"}
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, &jsonPatchError{Message: op.Op + " needs a from path"}
+		}
+		from, err := parseJSONPointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = jsonPointerGet(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, &jsonPatchError{Message: "cannot move a value into itself"}
+			}
+			if doc, err = jsonPointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = copyJSON(value)
+		}
+	case "remove":
+	default:
+		return nil, &jsonPatchError{Message: fmt.Sprintf("unknown op %q", op.Op)}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return jsonPointerAdd(doc, path, value)
+	case "remove":
+		return jsonPointerRemove(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if _, err := jsonPointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, err = jsonPointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, path, value)
+	}
+
+	// test
+	current, err := jsonPointerGet(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	if !equalJSON(current, value) {
+		return nil, &jsonPatchError{Message: "test failed at " + *op.Path, Conflict: true}
+	}
+	return doc, nil
+}
+
+// Split an RFC 6901 JSON Pointer into unescaped reference tokens
+func parseJSONPointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, &jsonPatchError{Message: fmt.Sprintf("invalid path %q", p)}
+	}
+	tokens := strings.Split(p[1:], "/")
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for i, t := range tokens {
+		tokens[i] = unescape.Replace(t)
+	}
+	return tokens, nil
+}
+
+func pathNotFound(tokens []string) error {
+	return &jsonPatchError{Message: "no value at /" + strings.Join(tokens, "/"), Conflict: true}
+}
+
+// Array index for a token. With forAdd, the index may be one past the
+// end, and "-" means the end.
+func jsonArrayIndex(token string, length int, forAdd bool) (int, bool) {
+	if forAdd && token == "-" {
+		return length, true
+	}
+	// Leading zeros and signs aren't valid indexes
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.Trim(token, "0123456789") != "" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > length || (i == length && !forAdd) {
+		return 0, false
+	}
+	return i, true
+}
+
+func jsonPointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	node := doc
+	for i, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, pathNotFound(tokens[:i+1])
+			}
+			node = child
+		case []interface{}:
+			idx, ok := jsonArrayIndex(token, len(n), false)
+			if !ok {
+				return nil, pathNotFound(tokens[:i+1])
+			}
+			node = n[idx]
+		default:
+			return nil, pathNotFound(tokens[:i+1])
+		}
+	}
+	return node, nil
+}
+
+// Call fn on the container holding the last token, storing back the
+// container it returns, since inserting into an array makes a new slice
+func jsonPointerUpdate(doc interface{}, tokens []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	child, err := jsonPointerGet(doc, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jsonPointerUpdate(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		n[tokens[0]] = updated
+	case []interface{}:
+		idx, _ := jsonArrayIndex(tokens[0], len(n), false)
+		n[idx] = updated
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return jsonPointerUpdate(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[key] = value
+			return n, nil
+		case []interface{}:
+			idx, ok := jsonArrayIndex(key, len(n), true)
+			if !ok {
+				return nil, pathNotFound(tokens)
+			}
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+			return n, nil
+		}
+		return nil, pathNotFound(tokens)
+	})
+}
+
+func jsonPointerRemove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, &jsonPatchError{Message: "cannot remove the whole document"}
+	}
+	return jsonPointerUpdate(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := n[key]; !ok {
+				return nil, pathNotFound(tokens)
+			}
+			delete(n, key)
+			return n, nil
+		case []interface{}:
+			idx, ok := jsonArrayIndex(key, len(n), false)
+			if !ok {
+				return nil, pathNotFound(tokens)
+			}
+			return append(n[:idx:idx], n[idx+1:]...), nil
+		}
+		return nil, pathNotFound(tokens)
+	})
+}
+
+func copyJSON(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			c[k] = copyJSON(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(n))
+		for i, child := range n {
+			c[i] = copyJSON(child)
+		}
+		return c
+	}
+	return v
+}
+
+// JSON equality as RFC 6902 defines it for test: numbers compare by
+// value, so 1965 equals 1965.0
+func equalJSON(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equalJSON(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalJSON(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
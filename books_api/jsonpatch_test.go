@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	// Examples from RFC 6902, appendix A
+	cases := []struct{ doc, patch, want string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+	}
+	for _, c := range cases {
+		var doc, want interface{}
+		decodeJSONNumbers([]byte(c.doc), &doc)
+		decodeJSONNumbers([]byte(c.want), &want)
+		got, err := applyJSONPatch(doc, []byte(c.patch))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Patching %s with %s: expected %s, got %v %v", c.doc, c.patch, c.want, got, err)
+		}
+	}
+
+	failures := []struct {
+		doc, patch string
+		conflict   bool
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, true},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, true},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, true},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, true},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/01","value":1}]`, true},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, false},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`, false},
+		{`{"foo":"bar"}`, `[{"op":"remove"}]`, false},
+		{`{"foo":"bar"}`, `{"op":"remove","path":"/foo"}`, false},
+	}
+	for _, f := range failures {
+		var doc interface{}
+		json.Unmarshal([]byte(f.doc), &doc)
+		_, err := applyJSONPatch(doc, []byte(f.patch))
+		pe, ok := err.(*jsonPatchError)
+		if !ok || pe.Conflict != f.conflict {
+			t.Errorf("Expected %s to fail with conflict=%v, got %v", f.patch, f.conflict, err)
+		}
+	}
+}
+
+func TestJSONPatchBook(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965})
+	router := setupRouter()
+
+	patch := `[
+		{"op": "test", "path": "/year", "value": 1965},
+		{"op": "replace", "path": "/year", "value": 1966},
+		{"op": "copy", "from": "/title", "path": "/description"},
+		{"op": "add", "path": "/price_cents", "value": 999}
+	]`
+	response := patchRequest(router, "/api/v1/books/1", jsonPatchType, patch)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var book Book
+	db.First(&book, 1)
+	if book.Year != 1966 || book.Description != "Dune" || book.PriceCents != 999 {
+		t.Errorf("Unexpected book %+v", book)
+	}
+
+	// The same patch again fails its test, and nothing is applied
+	response = patchRequest(router, "/api/v1/books/1", jsonPatchType, `[{"op": "replace", "path": "/title", "value": "Dune!"}, {"op": "test", "path": "/year", "value": 1965}]`)
+	if response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "operation 1: test failed at /year") {
+		t.Errorf("Expected status 409, got %d: %s", response.Code, response.Body.String())
+	}
+	db.First(&book, 1)
+	if book.Title != "Dune" {
+		t.Errorf("Expected a failed patch to change nothing, got %+v", book)
+	}
+
+	checks := []struct {
+		patch  string
+		status int
+	}{
+		{`[{"op": "remove", "path": "/author"}]`, http.StatusBadRequest},
+		{`[{"op": "add", "path": "/tags", "value": []}]`, http.StatusBadRequest},
+		{`[{"op": "move", "path": "/title"}]`, http.StatusBadRequest},
+	}
+	for _, c := range checks {
+		if response := patchRequest(router, "/api/v1/books/1", jsonPatchType, c.patch); response.Code != c.status {
+			t.Errorf("Expected status %d for %s, got %d: %s", c.status, c.patch, response.Code, response.Body.String())
+		}
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAPConfig selects a directory server and how users and groups are found
+type LDAPConfig struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// Service account used to find users; empty binds anonymously
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// Filter locating a user, with %s for the escaped username.
+	// Active Directory: (&(objectClass=user)(sAMAccountName=%s))
+	UserFilter string
+	// Attribute listing the user's groups
+	GroupAttr string
+	// Role mappings, as "role=group;role=group"
+	RoleGroups string
+	// Role for users in no mapped group; "none" refuses them
+	DefaultRole string
+	Timeout     time.Duration
+}
+
+// ldapAuthenticator implements Authenticator with a search-then-bind
+// against LDAP or Active Directory
+type ldapAuthenticator struct {
+	cfg      LDAPConfig
+	mappings []roleMapping
+}
+
+func newLDAPAuthenticator(c LDAPConfig) *ldapAuthenticator {
+	mappings, err := parseRoleMappings(c.RoleGroups)
+	if err != nil {
+		log.Fatalf("Invalid LDAP_ROLE_GROUPS: %v", err)
+	}
+	if _, err := compileLDAPFilter(strings.ReplaceAll(c.UserFilter, "%s", "x")); err != nil {
+		log.Fatalf("Invalid LDAP_USER_FILTER: %v", err)
+	}
+	return &ldapAuthenticator{cfg: c, mappings: mappings}
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, username, password string) (*Principal, error) {
+	// An empty password is an unauthenticated bind, which servers accept
+	if username == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	conn, err := dialLDAP(ctx, a.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service bind: %w", err)
+		}
+	}
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "%s", ldapEscape(username))
+	entries, err := conn.Search(a.cfg.BaseDN, filter, []string{a.cfg.GroupAttr, "mail", "displayName", "cn"})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, errInvalidCredentials
+	}
+	user := entries[0]
+	if err := conn.Bind(user.DN, password); err != nil {
+		return nil, err
+	}
+
+	roles := mapRoles(user.Values(a.cfg.GroupAttr), a.mappings, a.cfg.DefaultRole)
+	if len(roles) == 0 {
+		return nil, errNoRole
+	}
+	name := user.Value("displayName")
+	if name == "" {
+		name = user.Value("cn")
+	}
+	return &Principal{Username: username, Name: name, Email: user.Value("mail"), Roles: roles, Backend: "ldap"}, nil
+}
+
+// Escape a value for use inside a search filter (RFC 4515)
+func ldapEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// BER identifiers used by LDAP
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+)
+
+// LDAP result codes the client handles specially
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+// OID of the StartTLS extended operation
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Encode one BER element
+func berTLV(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+func berInt(tag byte, n int) []byte {
+	content := []byte{byte(n)}
+	for n >>= 8; n != 0 && n != -1; n >>= 8 {
+		content = append([]byte{byte(n)}, content...)
+	}
+	// Keep positive values from reading as negative
+	if n == 0 && content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berTLV(tag, content)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+func berSeq(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, p := range parts {
+		content = append(content, p...)
+	}
+	return berTLV(tag, content)
+}
+
+// berPacket is a decoded BER element. Constructed elements have children.
+type berPacket struct {
+	tag      byte
+	value    []byte
+	children []*berPacket
+}
+
+func (p *berPacket) constructed() bool {
+	return p.tag&0x20 != 0
+}
+
+func (p *berPacket) int() int {
+	n := 0
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+func (p *berPacket) child(i int) *berPacket {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return &berPacket{}
+}
+
+// Read one BER element from r
+func berRead(r io.Reader) (*berPacket, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	length := int(head[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("ber: unsupported length")
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+
+	p := &berPacket{tag: head[0], value: content}
+	if p.constructed() {
+		rest := bytes.NewReader(content)
+		for rest.Len() > 0 {
+			child, err := berRead(rest)
+			if err != nil {
+				return nil, err
+			}
+			p.children = append(p.children, child)
+		}
+	}
+	return p, nil
+}
+
+// Compile an RFC 4515 filter string to BER. Supports &, |, !, equality,
+// presence, substrings, >= and <=.
+func compileLDAPFilter(filter string) ([]byte, error) {
+	out, rest, err := compileFilterAt(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected %q after filter", rest)
+	}
+	return out, nil
+}
+
+func compileFilterAt(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", errors.New("unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if s[0] == '|' {
+			tag = 0xa1
+		}
+		s = s[1:]
+		var parts [][]byte
+		for strings.HasPrefix(s, "(") {
+			part, rest, err := compileFilterAt(s)
+			if err != nil {
+				return nil, "", err
+			}
+			parts, s = append(parts, part), rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", errors.New("unterminated filter")
+		}
+		return berSeq(tag, parts...), s[1:], nil
+	case '!':
+		part, rest, err := compileFilterAt(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", errors.New("unterminated filter")
+		}
+		return berSeq(0xa2, part), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", fmt.Errorf("invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	switch {
+	case strings.HasSuffix(attr, ">"):
+		v, err := ldapUnescape(value)
+		return berSeq(0xa5, berString(berOctetString, attr[:len(attr)-1]), berString(berOctetString, v)), rest, err
+	case strings.HasSuffix(attr, "<"):
+		v, err := ldapUnescape(value)
+		return berSeq(0xa6, berString(berOctetString, attr[:len(attr)-1]), berString(berOctetString, v)), rest, err
+	case value == "*":
+		return berString(0x87, attr), rest, nil
+	case strings.Contains(value, "*"):
+		pieces := strings.Split(value, "*")
+		var subs [][]byte
+		for i, piece := range pieces {
+			if piece == "" {
+				continue
+			}
+			v, err := ldapUnescape(piece)
+			if err != nil {
+				return nil, "", err
+			}
+			tag := byte(0x81) // any
+			if i == 0 {
+				tag = 0x80 // initial
+			} else if i == len(pieces)-1 {
+				tag = 0x82 // final
+			}
+			subs = append(subs, berString(tag, v))
+		}
+		return berSeq(0xa4, berString(berOctetString, attr), berSeq(berSequence, subs...)), rest, nil
+	}
+	v, err := ldapUnescape(value)
+	return berSeq(0xa3, berString(berOctetString, attr), berString(berOctetString, v)), rest, err
+}
+
+// Decode \XX escapes in a filter value
+func ldapUnescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// ldapEntry is a search result
+type ldapEntry struct {
+	DN    string
+	attrs map[string][]string
+}
+
+// Values of an attribute; names are case-insensitive
+func (e *ldapEntry) Values(attr string) []string {
+	return e.attrs[strings.ToLower(attr)]
+}
+
+func (e *ldapEntry) Value(attr string) string {
+	if v := e.Values(attr); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// ldapError is a non-success LDAP result
+type ldapError struct {
+	Code    int
+	Message string
+}
+
+func (e *ldapError) Error() string {
+	return fmt.Sprintf("ldap result %d: %s", e.Code, e.Message)
+}
+
+// ldapConn is a minimal LDAPv3 client connection
+type ldapConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int
+}
+
+// Connect to the configured server, upgrading with StartTLS if asked
+func dialLDAP(ctx context.Context, c LDAPConfig) (*ldapConn, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: c.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap url: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	l := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if c.StartTLS && u.Scheme == "ldap" {
+		if err := l.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(deadline)
+	}
+	return l, nil
+}
+
+func (l *ldapConn) send(op []byte) (int, error) {
+	l.msgID++
+	_, err := l.conn.Write(berSeq(berSequence, berInt(berInteger, l.msgID), op))
+	return l.msgID, err
+}
+
+// Read the next response to message id, skipping unrelated ones
+func (l *ldapConn) read(id int) (*berPacket, error) {
+	for {
+		msg, err := berRead(l.r)
+		if err != nil {
+			return nil, err
+		}
+		if msg.child(0).int() == id {
+			return msg.child(1), nil
+		}
+	}
+}
+
+// Error for an LDAPResult, nil on success
+func ldapResultError(op *berPacket) error {
+	code := op.child(0).int()
+	if code == ldapSuccess {
+		return nil
+	}
+	if code == ldapInvalidCredentials {
+		return errInvalidCredentials
+	}
+	return &ldapError{Code: code, Message: string(op.child(2).value)}
+}
+
+// Simple bind
+func (l *ldapConn) Bind(dn, password string) error {
+	id, err := l.send(berSeq(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(0x80, password)))
+	if err != nil {
+		return err
+	}
+	op, err := l.read(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to bind", op.tag)
+	}
+	return ldapResultError(op)
+}
+
+// Subtree search returning the entries found
+func (l *ldapConn) Search(base, filter string, attrs []string) ([]ldapEntry, error) {
+	f, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, berString(berOctetString, a))
+	}
+	id, err := l.send(berSeq(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, 2), // wholeSubtree
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 2),    // two entries are enough to know it's ambiguous
+		berInt(berInteger, 0),
+		berTLV(berBoolean, []byte{0}),
+		f,
+		berSeq(berSequence, attrList...)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := l.read(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			e := ldapEntry{DN: string(op.child(0).value), attrs: map[string][]string{}}
+			for _, attr := range op.child(1).children {
+				name := strings.ToLower(string(attr.child(0).value))
+				for _, v := range attr.child(1).children {
+					e.attrs[name] = append(e.attrs[name], string(v.value))
+				}
+			}
+			entries = append(entries, e)
+		case ldapSearchReference:
+		case ldapSearchDone:
+			err := ldapResultError(op)
+			// Size limit exceeded still means more than one match
+			var le *ldapError
+			if errors.As(err, &le) && le.Code == 4 {
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", op.tag)
+		}
+	}
+}
+
+func (l *ldapConn) startTLS(c *tls.Config) error {
+	id, err := l.send(berSeq(ldapExtendedRequest, berString(0x80, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := l.read(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapExtendedResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to StartTLS", op.tag)
+	}
+	if err := ldapResultError(op); err != nil {
+		return fmt.Errorf("starttls: %w", err)
+	}
+	tlsConn := tls.Client(l.conn, c)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	l.conn, l.r = tlsConn, bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Unbind and close the connection
+func (l *ldapConn) Close() error {
+	l.send(berTLV(ldapUnbindRequest, nil))
+	return l.conn.Close()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Directory served by the fake LDAP server: DN to password and attributes
+type fakeLDAPUser struct {
+	dn       string
+	uid      string
+	password string
+	attrs    map[string][]string
+}
+
+// Serve a minimal LDAP server on a local port. It answers binds and
+// equality searches on uid, which is all the authenticator sends.
+func startFakeLDAP(t *testing.T, users []fakeLDAPUser) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeLDAP(conn, users)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+func serveFakeLDAP(conn net.Conn, users []fakeLDAPUser) {
+	defer conn.Close()
+	reply := func(id int, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(berSeq(berSequence, berInt(berInteger, id), op))
+		}
+	}
+	result := func(tag byte, code int) []byte {
+		return berSeq(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+	}
+
+	for {
+		msg, err := berRead(conn)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		switch op.tag {
+		case ldapBindRequest:
+			dn, password := string(op.child(1).value), string(op.child(2).value)
+			code := ldapInvalidCredentials
+			if dn == "cn=svc,dc=test" && password == "svc" {
+				code = ldapSuccess
+			}
+			for _, u := range users {
+				if u.dn == dn && u.password == password {
+					code = ldapSuccess
+				}
+			}
+			reply(id, result(ldapBindResponse, code))
+		case ldapSearchRequest:
+			filter := op.child(6)
+			var ops [][]byte
+			for _, u := range users {
+				if filter.tag != 0xa3 || string(filter.child(0).value) != "uid" || string(filter.child(1).value) != u.uid {
+					continue
+				}
+				var attrs [][]byte
+				for name, values := range u.attrs {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, berString(berOctetString, v))
+					}
+					attrs = append(attrs, berSeq(berSequence, berString(berOctetString, name), berSeq(berSet, vals...)))
+				}
+				ops = append(ops, berSeq(ldapSearchEntry, berString(berOctetString, u.dn), berSeq(berSequence, attrs...)))
+			}
+			reply(id, append(ops, result(ldapSearchDone, ldapSuccess))...)
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	url := startFakeLDAP(t, []fakeLDAPUser{
+		{
+			dn: "uid=ann,ou=people,dc=test", uid: "ann", password: "secret",
+			attrs: map[string][]string{
+				"memberOf":    {"cn=staff,ou=groups,dc=test", "cn=readers,ou=groups,dc=test"},
+				"displayName": {"Ann Archivist"},
+				"mail":        {"ann@example.edu"},
+			},
+		},
+		{dn: "uid=bob,ou=people,dc=test", uid: "bob", password: "secret"},
+	})
+	a := newLDAPAuthenticator(LDAPConfig{
+		URL:          url,
+		BindDN:       "cn=svc,dc=test",
+		BindPassword: "svc",
+		BaseDN:       "dc=test",
+		UserFilter:   "(uid=%s)",
+		GroupAttr:    "memberOf",
+		RoleGroups:   "librarian=cn=staff,ou=groups,dc=test",
+		DefaultRole:  "none",
+		Timeout:      5 * time.Second,
+	})
+
+	p, err := a.Authenticate(context.Background(), "ann", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	want := &Principal{Username: "ann", Name: "Ann Archivist", Email: "ann@example.edu", Roles: []string{roleLibrarian}, Backend: "ldap"}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Expected %+v, got %+v", want, p)
+	}
+
+	for _, tt := range []struct {
+		username, password string
+		want               error
+	}{
+		{"ann", "wrong", errInvalidCredentials},
+		{"ann", "", errInvalidCredentials},
+		{"nobody", "secret", errInvalidCredentials},
+		{"bob", "secret", errNoRole},
+	} {
+		if _, err := a.Authenticate(context.Background(), tt.username, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Authenticate(%q, %q): expected %v, got %v", tt.username, tt.password, tt.want, err)
+		}
+	}
+}
+
+func TestCompileLDAPFilter(t *testing.T) {
+	got, err := compileLDAPFilter("(&(objectClass=user)(sAMAccountName=" + ldapEscape("a*(b)") + "))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := berSeq(0xa0,
+		berSeq(0xa3, berString(berOctetString, "objectClass"), berString(berOctetString, "user")),
+		berSeq(0xa3, berString(berOctetString, "sAMAccountName"), berString(berOctetString, "a*(b)")))
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %x, got %x", want, got)
+	}
+
+	for _, bad := range []string{"uid=x", "(uid=x", "(&(uid=x)", "(uid=x))", "(=x)"} {
+		if _, err := compileLDAPFilter(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Library is a branch with a catalog of its own in a deployment shared by
+// several. A request picks one by sending its code in the X-Library
+// header, and without one works on the main catalog. Statements on books
+// made with the request's context only see and write the books of that
+// library, so branches can't read or change each other's catalogs.
+// Authors, publishers, series, works and members are shared, but their
+// book counts and book lists only include the library's books.
+type Library struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Code      string    `json:"code" gorm:"uniqueIndex;not null"`
+	Name      string    `json:"name" gorm:"not null"`
+	BookCount int64     `json:"book_count" gorm:"->;-:migration"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LibraryRequest is the body of POST and PUT /libraries. A library's code
+// is fixed once created, so PUT only reads the name.
+type LibraryRequest struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// Header naming the library a request works on
+const libraryHeader = "X-Library"
+
+// Library codes are short lowercase slugs, such as "north-branch"
+var libraryCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+type libraryKey struct{}
+
+// The library whose catalog a context's statements on books are scoped
+// to, if any. Contexts of background work have none and see every library.
+func libraryFrom(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(libraryKey{}).(uint)
+	return id, ok
+}
+
+func withLibrary(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, libraryKey{}, id)
+}
+
+// The ID of the library with a code; 0, the main catalog, for no code
+func resolveLibrary(code string) (uint, error) {
+	if code == "" {
+		return 0, nil
+	}
+	var library Library
+	if err := db.Select("id").Where("code = ?", strings.ToLower(code)).Take(&library).Error; err != nil {
+		return 0, err
+	}
+	return library.ID, nil
+}
+
+// Scope every request to the library in its X-Library header, or to the
+// main catalog. Responses vary by the header, so caches keep libraries'
+// copies apart.
+func libraryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", libraryHeader)
+		id, err := resolveLibrary(r.Header.Get(libraryHeader))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, r, http.StatusNotFound, "library_not_found", "Library not found")
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read library")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withLibrary(r.Context(), id)))
+	})
+}
+
+// Keep statements on books to the library in their context: reads,
+// updates and deletes only match its books, and created books join its
+// catalog
+func registerLibraryCallbacks(db *gorm.DB) {
+	library := func(tx *gorm.DB) (uint, bool) {
+		if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "books" {
+			return 0, false
+		}
+		return libraryFrom(tx.Statement.Context)
+	}
+	scope := func(tx *gorm.DB) {
+		if id, ok := library(tx); ok {
+			tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "library_id"}, Value: id},
+			}})
+		}
+	}
+	assign := func(tx *gorm.DB) {
+		if id, ok := library(tx); ok {
+			tx.Statement.SetColumn("LibraryID", id, true)
+		}
+	}
+
+	db.Callback().Query().Before("gorm:query").Register("library:scope_query", scope)
+	db.Callback().Row().Before("gorm:row").Register("library:scope_row", scope)
+	db.Callback().Update().Before("gorm:update").Register("library:scope_update", scope)
+	db.Callback().Delete().Before("gorm:delete").Register("library:scope_delete", scope)
+	db.Callback().Create().Before("gorm:create").Register("library:assign", assign)
+}
+
+// Join the books of the statement's library onto another table, on a
+// condition, for the book counts of authors, publishers and so on
+func joinBooks(on string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if id, ok := libraryFrom(tx.Statement.Context); ok {
+			return tx.Joins("LEFT JOIN books ON "+on+" AND books.library_id = ?", id)
+		}
+		return tx.Joins("LEFT JOIN books ON " + on)
+	}
+}
+
+// The IDs of the books in the context's library, the trash included, as a
+// subquery for tables that reference books
+func libraryBookIDs(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Unscoped().Model(&Book{}).Select("id")
+}
+
+// Libraries with the number of books outside the trash in their catalogs
+func librariesWithCounts() *gorm.DB {
+	return db.Model(&Library{}).
+		Select("libraries.*, COUNT(books.id) AS book_count").
+		Joins("LEFT JOIN books ON books.library_id = libraries.id AND books.deleted_at IS NULL").
+		Group("libraries.id")
+}
+
+// List the libraries by code, so a client can offer a choice of branch
+func getLibraries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var libraries []Library
+	if err := librariesWithCounts().Order("libraries.code").Scan(&libraries).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list libraries")
+		return
+	}
+	writeList(w, r, libraries)
+}
+
+func validateLibrary(req *LibraryRequest) []FieldError {
+	var errs []FieldError
+	req.Code = strings.ToLower(strings.TrimSpace(req.Code))
+	if !libraryCodePattern.MatchString(req.Code) {
+		errs = append(errs, FieldError{Field: "code", Message: "must be 1 to 32 lowercase letters, digits or dashes"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	return errs
+}
+
+// Add a library with an empty catalog
+func createLibrary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req LibraryRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateLibrary(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	var count int64
+	db.Model(&Library{}).Where("code = ?", req.Code).Count(&count)
+	if count > 0 {
+		writeError(w, r, http.StatusConflict, "library_exists", "A library with this code already exists")
+		return
+	}
+	library := Library{Code: req.Code, Name: req.Name}
+	if err := db.Create(&library).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create library")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+library.Code)
+	writeJSON(w, http.StatusCreated, library)
+}
+
+// Load the library named in the URL by code, with its book count
+func loadLibrary(w http.ResponseWriter, r *http.Request) (*Library, bool) {
+	var library Library
+	code := strings.ToLower(mux.Vars(r)["code"])
+	if err := librariesWithCounts().Where("libraries.code = ?", code).Take(&library).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "library_not_found", "Library not found")
+		return nil, false
+	}
+	return &library, true
+}
+
+// Get a library by code
+func getLibrary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if library, ok := loadLibrary(w, r); ok {
+		writeJSON(w, http.StatusOK, library)
+	}
+}
+
+// Rename a library
+func updateLibrary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	library, ok := loadLibrary(w, r)
+	if !ok {
+		return
+	}
+	var req LibraryRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	req.Code = library.Code
+	if errs := validateLibrary(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	library.Name = req.Name
+	if err := db.Select("Name", "UpdatedAt").Updates(library).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update library")
+		return
+	}
+	writeJSON(w, http.StatusOK, library)
+}
+
+// Returned when a library's catalog still has books
+var errLibraryHasBooks = errors.New("library has books")
+
+// Delete a library whose catalog is empty, the trash included
+func deleteLibrary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	library, ok := loadLibrary(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var books int64
+		if err := tx.Unscoped().Model(&Book{}).Where("library_id = ?", library.ID).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return errLibraryHasBooks
+		}
+		return tx.Delete(&Library{}, library.ID).Error
+	})
+	if errors.Is(err, errLibraryHasBooks) {
+		writeError(w, r, http.StatusConflict, "library_has_books", "Delete or purge the library's books first")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete library")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A request to the router on a library's catalog; "" for the main one
+func libraryRequest(t *testing.T, router http.Handler, library, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if library != "" {
+		req.Header.Set(libraryHeader, library)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestLibraryCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/libraries", `{"code":" North ","name":"North Branch"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Library
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.Code != "north" || response.Header().Get("Location") != "/api/v1/libraries/north" {
+		t.Errorf("Expected the code lower-cased in the body and Location, got %s and %s", response.Body.String(), response.Header().Get("Location"))
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/libraries", `{"code":"north","name":"Again"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate code, got %d", response.Code)
+	}
+	for _, body := range []string{`{"code":"north branch","name":"N"}`, `{"code":"-north","name":"N"}`, `{"code":"south"}`} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/libraries", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+
+	response = webhookRequest(t, router, "PUT", "/api/v1/libraries/north", `{"code":"elsewhere","name":"Northside"}`)
+	var updated Library
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.Name != "Northside" || updated.Code != "north" {
+		t.Errorf("Expected only the name changed, got %d: %s", response.Code, response.Body.String())
+	}
+
+	libraryRequest(t, router, "north", "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593"}`)
+	response = webhookRequest(t, router, "GET", "/api/v1/libraries", "")
+	var libraries []Library
+	json.Unmarshal(response.Body.Bytes(), &libraries)
+	if len(libraries) != 1 || libraries[0].BookCount != 1 {
+		t.Errorf("Expected north with 1 book, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", "/api/v1/libraries/north", ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while the catalog has books, got %d", response.Code)
+	}
+	libraryRequest(t, router, "north", "DELETE", "/api/v1/books/1", "")
+	if response := webhookRequest(t, router, "DELETE", "/api/v1/libraries/north", ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while the trash has books, got %d", response.Code)
+	}
+	db.Unscoped().Delete(&Book{}, 1)
+	if response := webhookRequest(t, router, "DELETE", "/api/v1/libraries/north", ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/libraries/north", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+}
+
+func TestLibraryCatalogsAreSeparate(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Library{Code: "north", Name: "North Branch"})
+	db.Create(&Library{Code: "south", Name: "South Branch"})
+
+	ids := map[string]uint{}
+	for _, library := range []string{"", "north", "south"} {
+		response := libraryRequest(t, router, library, "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","year":1965}`)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 in %q, got %d: %s", library, response.Code, response.Body.String())
+		}
+		var book Book
+		json.Unmarshal(response.Body.Bytes(), &book)
+		ids[library] = book.ID
+	}
+	libraryRequest(t, router, "north", "POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"9780141439587"}`)
+	if response := libraryRequest(t, router, "north", "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"0441013597"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for an ISBN already in the library, got %d", response.Code)
+	}
+
+	southDune := fmt.Sprintf("/api/v1/books/%d", ids["south"])
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		if response := libraryRequest(t, router, "north", method, southDune, `{"title":"Taken"}`); response.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s of another library's book, got %d", method, response.Code)
+		}
+	}
+	if response := libraryRequest(t, router, "south", "GET", southDune, ""); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"Dune"`) {
+		t.Errorf("Expected south's Dune untouched, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for library, want := range map[string]string{"": "1", "north": "2", "south": "1"} {
+		response := libraryRequest(t, router, library, "GET", "/api/v1/books", "")
+		if got := response.Header().Get("X-Total-Count"); got != want {
+			t.Errorf("Expected %s books in %q, got %s", want, library, got)
+		}
+	}
+	for _, path := range []string{"/api/v1/books/search?q=emma", "/api/v1/books/isbn/9780141439587"} {
+		if response := libraryRequest(t, router, "south", "GET", path, ""); strings.Contains(response.Body.String(), "Emma") {
+			t.Errorf("Expected no Emma in south for %s, got %s", path, response.Body.String())
+		}
+		if response := libraryRequest(t, router, "north", "GET", path, ""); !strings.Contains(response.Body.String(), "Emma") {
+			t.Errorf("Expected Emma in north for %s, got %s", path, response.Body.String())
+		}
+	}
+
+	response := libraryRequest(t, router, "north", "GET", "/api/v1/stats", "")
+	var stats CatalogStats
+	json.Unmarshal(response.Body.Bytes(), &stats)
+	if stats.TotalBooks != 2 || stats.TotalAuthors != 2 {
+		t.Errorf("Expected north's figures, got %s", response.Body.String())
+	}
+	response = libraryRequest(t, router, "south", "GET", "/api/v1/authors", "")
+	var authors []Author
+	json.Unmarshal(response.Body.Bytes(), &authors)
+	if len(authors) != 1 || authors[0].Name != "Frank Herbert" || authors[0].BookCount != 1 {
+		t.Errorf("Expected only south's author with 1 book, got %s", response.Body.String())
+	}
+
+	if response := libraryRequest(t, router, "west", "GET", "/api/v1/books", ""); response.Code != http.StatusNotFound || !strings.Contains(response.Body.String(), "Library not found") {
+		t.Errorf("Expected 404 for an unknown library, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fields the books listing can be sorted by, mapped to their columns.
+// Only these names ever reach the ORDER BY clause.
+var bookSortColumns = map[string]string{
+	"title":       "title",
+	"author":      "author",
+	"year":        "year",
+	"rating":      "average_rating",
+	"call_number": "call_number",
+	"created_at":  "created_at",
+	"updated_at":  "updated_at",
+	"id":          "id",
+}
+
+// bookSortKey is one field of a ?sort= list
+type bookSortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Parse ?sort=, a comma-separated list of fields, each descending when
+// prefixed with "-": "-year,title"
+func parseBookSort(s string) ([]bookSortKey, error) {
+	var keys []bookSortKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key := bookSortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := bookSortColumns[key.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q, expected title, author, year, rating, call_number, created_at, updated_at or id", key.Field)
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("sort lists %q twice", key.Field)
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Sorted keys ending in id, so rows with equal values have a stable order
+func withIDTiebreak(keys []bookSortKey) []bookSortKey {
+	for _, k := range keys {
+		if k.Field == "id" {
+			return keys
+		}
+	}
+	return append(keys, bookSortKey{Field: "id"})
+}
+
+func formatBookSort(keys []bookSortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (k bookSortKey) column() string {
+	return bookSortColumns[k.Field]
+}
+
+func (k bookSortKey) orderBy() clause.OrderByColumn {
+	return clause.OrderByColumn{Column: clause.Column{Name: k.column()}, Desc: k.Desc}
+}
+
+// The key's value for a book, as stored in cursors
+func (k bookSortKey) value(b *Book) interface{} {
+	switch k.Field {
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "year":
+		return b.Year
+	case "rating":
+		return b.AverageRating
+	case "created_at":
+		return b.CreatedAt
+	case "updated_at":
+		return b.UpdatedAt
+	}
+	return b.ID
+}
+
+// Whether the key sorts by a timestamp. Cursors carry timestamps as
+// RFC 3339 strings, which must be bound as times to compare with the
+// column.
+func (k bookSortKey) timestamp() bool {
+	return k.Field == "created_at" || k.Field == "updated_at"
+}
+
+func orderBooks(query *gorm.DB, keys []bookSortKey) *gorm.DB {
+	for _, k := range keys {
+		query = query.Order(k.orderBy())
+	}
+	return query
+}
+
+// bookFilter narrows the books listing: ?author=&title= match substrings,
+// ignoring case, ?year_min=&year_max= bound the year inclusively,
+// ?language= matches a language code, ?status= a status, ?location= a
+// shelf location, ?attr.<name>= an attribute's value exactly,
+// ?created_after= keeps books added since a time, and ?q= adds the terms
+// of a query (see parseBookQuery)
+type bookFilter struct {
+	Author       string
+	Title        string
+	YearMin      int
+	YearMax      int
+	Language     string
+	Status       string
+	Location     string
+	Attributes   map[string]string
+	CreatedAfter time.Time
+	Query        []bookQueryTerm
+}
+
+// Whether the filter matches every book
+func (f bookFilter) empty() bool {
+	return f.Author == "" && f.Title == "" && f.YearMin == 0 && f.YearMax == 0 && f.Language == "" && f.Status == "" && f.Location == "" && len(f.Attributes) == 0 && f.CreatedAfter.IsZero() && len(f.Query) == 0
+}
+
+func parseBookFilter(q url.Values) (bookFilter, error) {
+	f := bookFilter{
+		Author:   strings.TrimSpace(q.Get("author")),
+		Title:    strings.TrimSpace(q.Get("title")),
+		Language: normalizeLanguage(q.Get("language")),
+		Status:   strings.ToLower(strings.TrimSpace(q.Get("status"))),
+	}
+	if f.Language != "" && !validLanguage(f.Language) {
+		return f, fmt.Errorf("language %q is not an ISO 639-1 code", f.Language)
+	}
+	if f.Status != "" && !bookStatuses[f.Status] {
+		return f, fmt.Errorf("status %q is not a book status", f.Status)
+	}
+	if v := strings.TrimSpace(q.Get("location")); v != "" {
+		location, ok := findLocation(v)
+		if !ok {
+			return f, fmt.Errorf("location %q is not a configured location", v)
+		}
+		f.Location = location.Code
+	}
+	bounds := []struct {
+		name string
+		dst  *int
+	}{{"year_min", &f.YearMin}, {"year_max", &f.YearMax}}
+	for _, b := range bounds {
+		if v := q.Get(b.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an integer", b.name)
+			}
+			*b.dst = n
+		}
+	}
+	if f.YearMin != 0 && f.YearMax != 0 && f.YearMin > f.YearMax {
+		return f, errors.New("year_min must not be after year_max")
+	}
+	if v := q.Get("created_after"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return f, errors.New("created_after must be an RFC 3339 time")
+		}
+		f.CreatedAfter = t
+	}
+	var err error
+	if f.Attributes, err = parseAttributeFilters(q); err != nil {
+		return f, err
+	}
+	f.Query, err = parseBookQuery(q.Get("q"))
+	return f, err
+}
+
+// Compose the filter's conditions onto query. Values are always bound as
+// parameters.
+func (f bookFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Author != "" {
+		query = query.Where(`LOWER(author) LIKE ? ESCAPE '\'`, containsPattern(f.Author))
+	}
+	if f.Title != "" {
+		query = query.Where(`LOWER(title) LIKE ? ESCAPE '\'`, containsPattern(f.Title))
+	}
+	if f.YearMin != 0 {
+		query = query.Where("year >= ?", f.YearMin)
+	}
+	if f.YearMax != 0 {
+		query = query.Where("year <= ?", f.YearMax)
+	}
+	if f.Language != "" {
+		query = query.Where("language = ?", f.Language)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Location != "" {
+		query = query.Where("location = ?", f.Location)
+	}
+	query = applyAttributeFilters(query, f.Attributes)
+	if !f.CreatedAfter.IsZero() {
+		query = query.Where("created_at > ?", f.CreatedAfter)
+	}
+	for _, t := range f.Query {
+		query = t.apply(query)
+	}
+	return query
+}
+
+// LIKE pattern matching s anywhere, lowercased, with the wildcards in s
+// taken literally
+func containsPattern(s string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(s))
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBooksSort(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984},
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Count Zero", Author: "William Gibson", ISBN: "9780441117734", Year: 1986},
+		{Title: "Burning Chrome", Author: "William Gibson", ISBN: "9780060539825", Year: 1986},
+	} {
+		db.Create(&b)
+	}
+	router := setupRouter()
+	want := []string{"Burning Chrome", "Count Zero", "Neuromancer", "Dune"}
+
+	req, _ := http.NewRequest("GET", "/api/v1/books?sort=-year,title", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := bookTitles(books); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Cursor pages follow the same order
+	var paged []Book
+	page := fetchBookPage(t, router, "sort=-year,title&limit=1")
+	for {
+		paged = append(paged, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		page = fetchBookPage(t, router, "sort=-year,title&limit=1&cursor="+url.QueryEscape(page.NextCursor))
+	}
+	if got := bookTitles(paged); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected pages %v, got %v", want, got)
+	}
+
+	first := fetchBookPage(t, router, "sort=-year,title&limit=1")
+	for _, query := range []string{"sort=isbn", "sort=title,-title", "sort=title&limit=1&cursor=" + url.QueryEscape(first.NextCursor)} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, response.Code)
+		}
+	}
+}
+
+func bookTitles(books []Book) []string {
+	titles := make([]string, len(books))
+	for i, b := range books {
+		titles[i] = b.Title
+	}
+	return titles
+}
+
+func TestBooksFilter(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Refactoring", Author: "Martin Fowler", ISBN: "9780201485677", Year: 1999},
+		{Title: "Refactoring, 2nd Edition", Author: "Martin Fowler", ISBN: "9780134757599", Year: 2018},
+		{Title: "Patterns of Enterprise Application Architecture", Author: "Martin Fowler", ISBN: "9780321127426", Year: 2002},
+		{Title: "Working Effectively with Legacy Code", Author: "Michael Feathers", ISBN: "9780131177055", Year: 2004},
+		{Title: "100% Refactor", Author: "Fowler_Fan", ISBN: "9780000000001", Year: 1995},
+	} {
+		db.Create(&b)
+	}
+	router := setupRouter()
+
+	cases := map[string][]string{
+		"author=fowler&year_min=1990&year_max=2000&title=refactor": {"Refactoring", "100% Refactor"},
+		"author=Fowler&sort=-year":                                 {"Refactoring, 2nd Edition", "Patterns of Enterprise Application Architecture", "Refactoring", "100% Refactor"},
+		"year_min=2003":                                            {"Refactoring, 2nd Edition", "Working Effectively with Legacy Code"},
+		"title=100%25":                                             {"100% Refactor"},
+		"author=n_f":                                               {},
+	}
+	for query, want := range cases {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if got := bookTitles(books); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v for %s, got %v", want, query, got)
+		}
+	}
+
+	page := fetchBookPage(t, router, "author=fowler&limit=2")
+	page = fetchBookPage(t, router, "author=fowler&limit=2&cursor="+url.QueryEscape(page.NextCursor))
+	if got := bookTitles(page.Items); fmt.Sprint(got) != fmt.Sprint([]string{"Patterns of Enterprise Application Architecture", "100% Refactor"}) {
+		t.Errorf("Expected the filter to apply to cursor pages, got %v", got)
+	}
+
+	for _, query := range []string{"year_min=abc", "year_min=2000&year_max=1990"} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, response.Code)
+		}
+	}
+}
+
+func TestBooksFilterLanguage(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	for _, body := range []string{
+		`{"title":"Cien años de soledad","author":"Gabriel García Márquez","isbn":"9780307474728","language":"ES"}`,
+		`{"title":"One Hundred Years of Solitude","author":"Gabriel García Márquez","isbn":"9780060883287","language":"en"}`,
+		`{"title":"Rayuela","author":"Julio Cortázar","isbn":"9788437604572","language":"es"}`,
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/books", body); response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+		}
+	}
+
+	for query, want := range map[string][]string{
+		"language=es":              {"Cien años de soledad", "Rayuela"},
+		"language=EN":              {"One Hundred Years of Solitude"},
+		"language=fr":              {},
+		"language=es&author=julio": {"Rayuela"},
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if got := bookTitles(books); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v for %s, got %v", want, query, got)
+		}
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/books?language=spanish", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown language, got %d", response.Code)
+	}
+
+	// Updates keep the language unless they change it
+	var rayuela Book
+	db.Where("title = ?", "Rayuela").First(&rayuela)
+	path := fmt.Sprintf("/api/v1/books/%d", rayuela.ID)
+	webhookRequest(t, router, "PUT", path, `{"year":1963}`)
+	if response := webhookRequest(t, router, "PUT", path, `{"language":"klingon"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown language, got %d", response.Code)
+	}
+	db.First(&rayuela, rayuela.ID)
+	if rayuela.Language != "es" || rayuela.Year != 1963 {
+		t.Errorf("Expected the language kept, got %q", rayuela.Language)
+	}
+}
+
+func TestBooksByCreatedAt(t *testing.T) {
+	clearDB()
+	added := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, title := range []string{"Dune", "Emma", "Neuromancer", "Rayuela"} {
+		db.Create(&Book{Title: title, Author: "Author", ISBN: fmt.Sprintf("978000000000%d", i), CreatedAt: added.AddDate(0, 0, i)})
+	}
+	router := setupRouter()
+	want := []string{"Rayuela", "Neuromancer", "Emma", "Dune"}
+
+	req, _ := http.NewRequest("GET", "/api/v1/books?sort=-created_at", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := bookTitles(books); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(books) > 0 && (!books[0].CreatedAt.Equal(added.AddDate(0, 0, 3)) || books[0].UpdatedAt.IsZero()) {
+		t.Errorf("Expected the timestamps in the response, got %s", response.Body.String())
+	}
+
+	// Cursors carry the timestamps, both ways
+	var paged []Book
+	page := fetchBookPage(t, router, "sort=-created_at&limit=1")
+	for {
+		paged = append(paged, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		page = fetchBookPage(t, router, "sort=-created_at&limit=1&cursor="+url.QueryEscape(page.NextCursor))
+	}
+	if got := bookTitles(paged); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected pages %v, got %v", want, got)
+	}
+	page = fetchBookPage(t, router, "sort=-created_at&limit=1&cursor="+url.QueryEscape(page.PrevCursor))
+	if got := bookTitles(page.Items); fmt.Sprint(got) != "[Emma]" {
+		t.Errorf("Expected the page before Dune to be Emma, got %v", got)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/books?created_after=2026-03-02T12:00:00Z&sort=created_at", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	books = nil
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := bookTitles(books); fmt.Sprint(got) != "[Neuromancer Rayuela]" {
+		t.Errorf("Expected the books added after March 2nd, got %v", got)
+	}
+	for _, query := range []string{"created_after=yesterday", "sort=created_at&limit=1&cursor=" + url.QueryEscape(bookCursor{Sort: "created_at,id", After: []interface{}{"soon", 1.0}}.encode())} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, response.Code)
+		}
+	}
+}
+
+func TestBooksTotalCount(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984},
+		{Title: "Count Zero", Author: "William Gibson", ISBN: "9780441117734", Year: 1986},
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+	} {
+		db.Create(&b)
+	}
+	router := setupRouter()
+
+	tests := []struct {
+		method string
+		query  string
+		want   string
+	}{
+		{"GET", "", "3"},
+		{"GET", "author=gibson&limit=1", "2"},
+		{"HEAD", "", "3"},
+		{"HEAD", "year_max=1970", "1"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "/api/v1/books?"+tt.query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusOK {
+			t.Errorf("%s ?%s: expected status 200, got %d", tt.method, tt.query, response.Code)
+		}
+		if got := response.Header().Get("X-Total-Count"); got != tt.want {
+			t.Errorf("%s ?%s: expected X-Total-Count %s, got %q", tt.method, tt.query, tt.want, got)
+		}
+		if tt.method == "HEAD" && response.Body.Len() != 0 {
+			t.Errorf("%s ?%s: expected no body, got %s", tt.method, tt.query, response.Body.String())
+		}
+	}
+}
+
+func TestCountBooks(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984},
+		{Title: "Count Zero", Author: "William Gibson", ISBN: "9780441117734", Year: 1986},
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+	} {
+		db.Create(&b)
+	}
+	db.Where("title = ?", "Emma").Delete(&Book{})
+	router := setupRouter()
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		want       int64
+	}{
+		{"", http.StatusOK, 3},
+		{"include_deleted=true", http.StatusOK, 4},
+		{"include_deleted=maybe", http.StatusBadRequest, 0},
+		{"author=GIBSON", http.StatusOK, 2},
+		{"author=gibson&year_min=1985", http.StatusOK, 1},
+		{"title=nothing", http.StatusOK, 0},
+		{"year_min=soon", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/books/count?"+tt.query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != tt.wantStatus {
+			t.Errorf("?%s: expected status %d, got %d", tt.query, tt.wantStatus, response.Code)
+			continue
+		}
+		var got BookCount
+		json.Unmarshal(response.Body.Bytes(), &got)
+		if tt.wantStatus == http.StatusOK && got.Count != tt.want {
+			t.Errorf("?%s: expected count %d, got %d", tt.query, tt.want, got.Count)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Reading lists are named shelves of books, such as "Want to read", that
+// readers keep for themselves. A list belongs to the user who made it, but
+// anyone with its ID can read it, so lists can be shared by link.
+
+// Longest list name
+const maxListNameLength = 100
+
+// ReadingList is a named, ordered list of books
+type ReadingList struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	Name        string      `json:"name" gorm:"not null"`
+	Description string      `json:"description,omitempty"`
+	Username    string      `json:"-" gorm:"index"`
+	BookCount   int64       `json:"book_count" gorm:"->;-:migration"`
+	Books       []ListEntry `json:"books,omitempty" gorm:"-"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// ListEntry is a book on a reading list. Entries are listed by position;
+// books in the trash are left out.
+type ListEntry struct {
+	ListID   uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	BookID   uint      `json:"book_id" gorm:"primaryKey;autoIncrement:false"`
+	Position int       `json:"-" gorm:"not null"`
+	AddedAt  time.Time `json:"added_at"`
+	Title    string    `json:"title" gorm:"->;-:migration"`
+	Author   string    `json:"author" gorm:"->;-:migration"`
+}
+
+// ListRequest is the body of POST and PUT /lists
+type ListRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListEntryRequest is the body of POST /lists/{id}/books
+type ListEntryRequest struct {
+	BookID uint `json:"book_id"`
+}
+
+// ListOrder is the body of PUT /lists/{id}/books, the list's book IDs in
+// their new order
+type ListOrder struct {
+	BookIDs []uint `json:"book_ids"`
+}
+
+func validateList(req *ListRequest) []FieldError {
+	var errs []FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.Name == "":
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	case len(req.Name) > maxListNameLength:
+		errs = append(errs, FieldError{Field: "name", Message: "must be at most " + strconv.Itoa(maxListNameLength) + " characters"})
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	return errs
+}
+
+// Lists with the number of books outside the trash on them
+func listsWithCounts() *gorm.DB {
+	return db.Model(&ReadingList{}).
+		Select("reading_lists.*, COUNT(books.id) AS book_count").
+		Joins("LEFT JOIN list_entries ON list_entries.list_id = reading_lists.id").
+		Joins("LEFT JOIN books ON books.id = list_entries.book_id AND books.deleted_at IS NULL").
+		Group("reading_lists.id")
+}
+
+// A list's entries in order, with their books' titles and authors
+func listEntries(tx *gorm.DB, listID uint) ([]ListEntry, error) {
+	var entries []ListEntry
+	err := tx.Model(&ListEntry{}).
+		Select("list_entries.*, books.title, books.author").
+		Joins("JOIN books ON books.id = list_entries.book_id AND books.deleted_at IS NULL").
+		Where("list_entries.list_id = ?", listID).
+		Order("list_entries.position, list_entries.book_id").
+		Scan(&entries).Error
+	return entries, err
+}
+
+// List the signed-in user's reading lists by name. Without
+// authentication, every list.
+func getLists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := listsWithCounts().Order("reading_lists.name, reading_lists.id")
+	if p := principalFrom(r); p != nil {
+		query = query.Where("reading_lists.username = ?", p.Username)
+	}
+	var lists []ReadingList
+	if err := query.Scan(&lists).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list reading lists")
+		return
+	}
+	writeList(w, r, lists)
+}
+
+// Create a reading list for the signed-in user
+func createList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req ListRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateList(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	list := ReadingList{Name: req.Name, Description: req.Description}
+	if p := principalFrom(r); p != nil {
+		list.Username = p.Username
+	}
+	if err := db.Create(&list).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create reading list")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(list.ID), 10))
+	writeJSON(w, http.StatusCreated, list)
+}
+
+// Load the list named in the URL. Anyone may read a list; only its owner,
+// or staff, may change it.
+func loadList(w http.ResponseWriter, r *http.Request, write bool) (*ReadingList, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_list_id", "Invalid list ID")
+		return nil, false
+	}
+	var list ReadingList
+	if err := listsWithCounts().Where("reading_lists.id = ?", id).Take(&list).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "list_not_found", "Reading list not found")
+		return nil, false
+	}
+	if write && authEnabled() {
+		p := principalFrom(r)
+		if p == nil || (p.Username != list.Username && !p.HasRole(roleLibrarian)) {
+			writeError(w, r, http.StatusForbidden, "forbidden", "Only the list's owner can change it")
+			return nil, false
+		}
+	}
+	return &list, true
+}
+
+// Write a list with its books
+func writeListWithBooks(w http.ResponseWriter, r *http.Request, status int, list *ReadingList) {
+	entries, err := listEntries(db, list.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load reading list")
+		return
+	}
+	list.Books = listOf(entries)
+	list.BookCount = int64(len(entries))
+	writeJSON(w, status, list)
+}
+
+// Get a reading list with its books in order
+func getReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if list, ok := loadList(w, r, false); ok {
+		writeListWithBooks(w, r, http.StatusOK, list)
+	}
+}
+
+// Rename a reading list or change its description
+func updateList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, ok := loadList(w, r, true)
+	if !ok {
+		return
+	}
+	var req ListRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateList(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	list.Name, list.Description = req.Name, req.Description
+	if err := db.Select("Name", "Description", "UpdatedAt").Updates(list).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update reading list")
+		return
+	}
+	writeListWithBooks(w, r, http.StatusOK, list)
+}
+
+// Delete a reading list. The books stay in the catalog.
+func deleteList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, ok := loadList(w, r, true)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ?", list.ID).Delete(&ListEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ReadingList{}, list.ID).Error
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete reading list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returned when a book is already on the list
+var errAlreadyListed = errors.New("book is already on the list")
+
+// Add a book to the end of a list
+func addListBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, ok := loadList(w, r, true)
+	if !ok {
+		return
+	}
+	var req ListEntryRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if err := db.WithContext(r.Context()).First(&Book{}, req.BookID).Error; err != nil {
+		writeValidationErrors(w, r, []FieldError{{Field: "book_id", Message: "is not a book in the catalog"}})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&ListEntry{}).Where("list_id = ? AND book_id = ?", list.ID, req.BookID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errAlreadyListed
+		}
+		var last int
+		if err := tx.Model(&ListEntry{}).Where("list_id = ?", list.ID).Select("COALESCE(MAX(position), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&ListEntry{ListID: list.ID, BookID: req.BookID, Position: last + 1, AddedAt: time.Now().UTC()}).Error; err != nil {
+			return err
+		}
+		return tx.Model(list).Update("updated_at", time.Now()).Error
+	})
+	if errors.Is(err, errAlreadyListed) {
+		writeError(w, r, http.StatusConflict, "book_listed", "The book is already on the list")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to add book to reading list")
+		return
+	}
+	writeListWithBooks(w, r, http.StatusCreated, list)
+}
+
+// Take a book off a list
+func removeListBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, ok := loadList(w, r, true)
+	if !ok {
+		return
+	}
+	bookID, err := strconv.Atoi(mux.Vars(r)["book_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+	result := db.Where("list_id = ? AND book_id = ?", list.ID, bookID).Delete(&ListEntry{})
+	if result.Error != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to remove book from reading list")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "The book is not on the list")
+		return
+	}
+	db.Model(list).Update("updated_at", time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Put a list's books in a new order. The body must name every book shown
+// on the list exactly once; books in the trash keep their place at the end.
+func reorderList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, ok := loadList(w, r, true)
+	if !ok {
+		return
+	}
+	var req ListOrder
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	entries, err := listEntries(db, list.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load reading list")
+		return
+	}
+	shown := make(map[uint]bool, len(entries))
+	for _, e := range entries {
+		shown[e.BookID] = true
+	}
+	seen := make(map[uint]bool, len(req.BookIDs))
+	for _, id := range req.BookIDs {
+		if !shown[id] || seen[id] {
+			seen = nil
+			break
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(shown) {
+		writeValidationErrors(w, r, []FieldError{{Field: "book_ids", Message: "must list each book on the list once"}})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var all []ListEntry
+		if err := tx.Where("list_id = ?", list.ID).Order("position, book_id").Find(&all).Error; err != nil {
+			return err
+		}
+		order := append([]uint{}, req.BookIDs...)
+		for _, e := range all {
+			if !shown[e.BookID] {
+				order = append(order, e.BookID)
+			}
+		}
+		for i, id := range order {
+			if err := tx.Model(&ListEntry{}).Where("list_id = ? AND book_id = ?", list.ID, id).Update("position", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(list).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to reorder reading list")
+		return
+	}
+	writeListWithBooks(w, r, http.StatusOK, list)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Titles of a list's books, in order
+func listTitles(list ReadingList) []string {
+	var titles []string
+	for _, e := range list.Books {
+		titles = append(titles, e.Title)
+	}
+	return titles
+}
+
+func TestReadingLists(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	var books []Book
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"},
+	} {
+		db.Create(&b)
+		books = append(books, b)
+	}
+
+	response := webhookRequest(t, router, "POST", "/api/v1/lists", `{"name":" Want to read "}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var list ReadingList
+	json.Unmarshal(response.Body.Bytes(), &list)
+	path := fmt.Sprintf("/api/v1/lists/%d", list.ID)
+	if loc := response.Header().Get("Location"); loc != path || list.Name != "Want to read" {
+		t.Errorf("Expected the list at %s, got %s: %s", path, loc, response.Body.String())
+	}
+	for _, body := range []string{`{"name":""}`, `{"name":"` + strings.Repeat("x", maxListNameLength+1) + `"}`} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/lists", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+
+	for _, b := range books {
+		response := webhookRequest(t, router, "POST", path+"/books", fmt.Sprintf(`{"book_id":%d}`, b.ID))
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 adding %s, got %d: %s", b.Title, response.Code, response.Body.String())
+		}
+	}
+	if response := webhookRequest(t, router, "POST", path+"/books", fmt.Sprintf(`{"book_id":%d}`, books[0].ID)); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 adding a book twice, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", path+"/books", `{"book_id":999}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown book, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "PUT", path+"/books", fmt.Sprintf(`{"book_ids":[%d,%d,%d]}`, books[2].ID, books[0].ID, books[1].ID))
+	json.Unmarshal(response.Body.Bytes(), &list)
+	if got := strings.Join(listTitles(list), ","); response.Code != http.StatusOK || got != "Neuromancer,Dune,Emma" {
+		t.Errorf("Expected the list reordered, got %d: %s", response.Code, response.Body.String())
+	}
+	for _, ids := range []string{`[1,2]`, `[1,1,2,3]`, `[1,2,999]`} {
+		if response := webhookRequest(t, router, "PUT", path+"/books", `{"book_ids":`+ids+`}`); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 reordering to %s, got %d", ids, response.Code)
+		}
+	}
+
+	if response := webhookRequest(t, router, "DELETE", fmt.Sprintf("%s/books/%d", path, books[0].ID), ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "DELETE", fmt.Sprintf("%s/books/%d", path, books[0].ID), ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 removing a book twice, got %d", response.Code)
+	}
+
+	// Books in the trash drop out of the list, but keep their place
+	db.Delete(&books[1])
+	response = webhookRequest(t, router, "GET", path, "")
+	list = ReadingList{}
+	json.Unmarshal(response.Body.Bytes(), &list)
+	if got := strings.Join(listTitles(list), ","); got != "Neuromancer" || list.BookCount != 1 {
+		t.Errorf("Expected only Neuromancer, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "PUT", path+"/books", fmt.Sprintf(`{"book_ids":[%d]}`, books[2].ID)); response.Code != http.StatusOK {
+		t.Errorf("Expected a reorder of the books shown to succeed, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/lists", "")
+	var lists []ReadingList
+	json.Unmarshal(response.Body.Bytes(), &lists)
+	if len(lists) != 1 || lists[0].BookCount != 1 || lists[0].Books != nil {
+		t.Errorf("Expected the list with its count, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+}
+
+func TestReadingListsBelongToTheirOwners(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"ada:pw":   {Username: "ada", Roles: []string{roleReader}},
+		"grace:pw": {Username: "grace", Roles: []string{roleReader}},
+	})
+	router := setupRouter()
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+	ada, grace := loginAs(t, router, "ada", "pw"), loginAs(t, router, "grace", "pw")
+
+	if response := request("POST", "/api/v1/lists", "", `{"name":"Favorites"}`); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token, got %d", response.Code)
+	}
+	response := request("POST", "/api/v1/lists", ada, `{"name":"Favorites"}`)
+	var list ReadingList
+	json.Unmarshal(response.Body.Bytes(), &list)
+	path := fmt.Sprintf("/api/v1/lists/%d", list.ID)
+
+	// Shared by link, but only Ada can change it or see it among her lists
+	if response := request("GET", path, "", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected anyone to read the list, got %d", response.Code)
+	}
+	if response := request("PUT", path, grace, `{"name":"Mine now"}`); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another reader, got %d", response.Code)
+	}
+	response = request("GET", "/api/v1/lists", grace, "")
+	if strings.TrimSpace(response.Body.String()) != "[]" {
+		t.Errorf("Expected Grace to have no lists, got %s", response.Body.String())
+	}
+	if response := request("PUT", path, ada, `{"name":"Best"}`); response.Code != http.StatusOK {
+		t.Errorf("Expected the owner to rename the list, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Lending. The catalog holds one copy of each book, so a book is either on
+// the shelf or out on a single open loan; a unique index on open loans
+// keeps it that way even when two checkouts race.
+
+// Loan is a book lent to a borrower. It is open until ReturnedAt is set.
+type Loan struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	BookID       uint       `json:"book_id" gorm:"not null;index;uniqueIndex:idx_loans_open_book,where:returned_at IS NULL"`
+	Borrower     string     `json:"borrower" gorm:"not null;index"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at" gorm:"index"`
+	Renewals     int        `json:"renewals"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+	RemindedAt   *time.Time `json:"reminded_at,omitempty"`
+
+	// The fine charged when the loan was returned late
+	Fine *Fine `json:"fine,omitempty" gorm:"-"`
+}
+
+// OverdueLoan is an open loan past its due date, with the book's title
+// and author for the desk
+type OverdueLoan struct {
+	Loan
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	DaysOverdue int    `json:"days_overdue" gorm:"-"`
+}
+
+// CheckoutRequest is the body of POST /books/{id}/checkout
+type CheckoutRequest struct {
+	Borrower string     `json:"borrower"`
+	DueAt    *time.Time `json:"due_at,omitempty"`
+}
+
+// Returned when the book is already out, or can't be lent at all
+var (
+	errBookOnLoan      = errors.New("book is on loan")
+	errBookUnavailable = errors.New("book is not available")
+)
+
+// Lend a book to an active member, named by their membership number. The
+// loan is due after LOAN_PERIOD unless the request sets due_at.
+func checkoutBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+	var req CheckoutRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+
+	now := time.Now().UTC()
+	loan := Loan{BookID: book.ID, Borrower: strings.TrimSpace(req.Borrower), CheckedOutAt: now, DueAt: now.Add(cfg.LoanPeriod)}
+	var errs []FieldError
+	if loan.Borrower == "" {
+		errs = append(errs, FieldError{Field: "borrower", Message: "is required"})
+	}
+	if req.DueAt != nil {
+		if !req.DueAt.After(now) {
+			errs = append(errs, FieldError{Field: "due_at", Message: "must be in the future"})
+		}
+		loan.DueAt = req.DueAt.UTC()
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if _, err := borrowingMember(loan.Borrower); err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to check out book")
+		}
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var open int64
+		if err := tx.Model(&Loan{}).Where("book_id = ? AND returned_at IS NULL", book.ID).Count(&open).Error; err != nil {
+			return err
+		}
+		if open > 0 {
+			return errBookOnLoan
+		}
+		if book.Status != bookAvailable {
+			return errBookUnavailable
+		}
+		if err := tx.Create(&loan).Error; err != nil {
+			return err
+		}
+		if err := setLoanStatus(tx, &book, bookOnLoan); err != nil {
+			return err
+		}
+		return recordEvent(tx, eventLoanCheckedOut, fmt.Sprintf("loan:%d", loan.ID), loan)
+	})
+	if errors.Is(err, errBookOnLoan) {
+		writeError(w, r, http.StatusConflict, "book_on_loan", "The book is already on loan")
+		return
+	} else if errors.Is(err, errBookUnavailable) {
+		writeError(w, r, http.StatusConflict, "book_unavailable", "The book is "+book.Status)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to check out book")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/loans/%d", versionFrom(r).Prefix, loan.ID))
+	writeJSON(w, http.StatusCreated, loan)
+}
+
+// Load the loan named in the URL
+func loadLoan(w http.ResponseWriter, r *http.Request) (*Loan, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_loan_id", "Invalid loan ID")
+		return nil, false
+	}
+	var loan Loan
+	if err := db.Where("book_id IN (?)", libraryBookIDs(r.Context())).First(&loan, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "loan_not_found", "Loan not found")
+		return nil, false
+	}
+	return &loan, true
+}
+
+// Get a loan by ID
+func getLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if loan, ok := loadLoan(w, r); ok {
+		writeJSON(w, http.StatusOK, loan)
+	}
+}
+
+// Close a loan, putting the book back on the shelf and fining a late
+// return
+func returnLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	loan, ok := loadLoan(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(loan).Where("returned_at IS NULL").Update("returned_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errLoanReturned
+		}
+		// Back on the shelf, unless it was reported lost meanwhile
+		var book Book
+		if err := tx.Limit(1).Find(&book, loan.BookID).Error; err != nil {
+			return err
+		}
+		if book.Status == bookOnLoan {
+			if err := setLoanStatus(tx, &book, bookAvailable); err != nil {
+				return err
+			}
+		}
+		if loan.Fine = fineFor(loan, now); loan.Fine != nil {
+			return tx.Create(loan.Fine).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errLoanReturned) {
+		writeError(w, r, http.StatusConflict, "loan_returned", "The loan was already returned")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to return loan")
+		return
+	}
+	loan.ReturnedAt = &now
+	writeJSON(w, http.StatusOK, loan)
+}
+
+// Errors that stop a renewal
+var (
+	errLoanReturned = errors.New("loan was returned")
+	errRenewalLimit = errors.New("renewal limit reached")
+)
+
+// Extend an open loan by another LOAN_PERIOD, counted from its due date or
+// from now if it is already overdue, up to MAX_RENEWALS times
+func renewLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	loan, ok := loadLoan(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(loan, loan.ID).Error; err != nil {
+			return err
+		}
+		switch {
+		case loan.ReturnedAt != nil:
+			return errLoanReturned
+		case loan.Renewals >= cfg.MaxRenewals:
+			return errRenewalLimit
+		}
+		from := loan.DueAt
+		if now := time.Now().UTC(); now.After(from) {
+			from = now
+		}
+		// Compare and set, so two renewals at once count as two
+		result := tx.Model(&Loan{}).Where("id = ? AND renewals = ? AND returned_at IS NULL", loan.ID, loan.Renewals).
+			Updates(map[string]interface{}{"due_at": from.Add(cfg.LoanPeriod), "renewals": loan.Renewals + 1, "reminded_at": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRenewalLimit
+		}
+		loan.DueAt, loan.Renewals, loan.RemindedAt = from.Add(cfg.LoanPeriod), loan.Renewals+1, nil
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLoanReturned):
+		writeError(w, r, http.StatusConflict, "loan_returned", "The loan was already returned")
+	case errors.Is(err, errRenewalLimit):
+		writeError(w, r, http.StatusConflict, "renewal_limit", fmt.Sprintf("The loan can be renewed at most %d times", cfg.MaxRenewals))
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to renew loan")
+	default:
+		writeJSON(w, http.StatusOK, loan)
+	}
+}
+
+// Open loans past their due date, longest overdue first
+func getOverdueLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	now := time.Now().UTC()
+	var loans []OverdueLoan
+	err := db.Model(&Loan{}).
+		Select("loans.*, books.title, books.author").
+		Joins("JOIN books ON books.id = loans.book_id").
+		Where("loans.book_id IN (?)", libraryBookIDs(r.Context())).
+		Where("loans.returned_at IS NULL AND loans.due_at < ?", now).
+		Order("loans.due_at, loans.id").
+		Scan(&loans).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list overdue loans")
+		return
+	}
+	for i := range loans {
+		loans[i].DaysOverdue = int(now.Sub(loans[i].DueAt) / (24 * time.Hour))
+	}
+	writeList(w, r, loans)
+}
+
+// dueLoan is an open loan with what the reminder email needs
+type dueLoan struct {
+	ID     uint
+	DueAt  time.Time
+	Title  string
+	Author string
+	Name   string
+	Email  string
+}
+
+// Email members whose loans fall due within LOAN_REMINDER_BEFORE. Each
+// loan is reminded once per due date; renewing it sets a new one. A loan
+// is marked reminded only once its email is sent, so one dropped by a
+// full queue or a failed delivery is tried again on the next run.
+func sendLoanReminders(ctx context.Context) error {
+	if mailer == nil {
+		return errors.New("email is not enabled")
+	}
+	now := time.Now().UTC()
+	var loans []dueLoan
+	err := db.WithContext(ctx).Model(&Loan{}).
+		Select("loans.id, loans.due_at, books.title, books.author, members.name, members.email").
+		Joins("JOIN books ON books.id = loans.book_id").
+		Joins("JOIN members ON members.membership_number = loans.borrower").
+		Where("loans.returned_at IS NULL AND loans.reminded_at IS NULL AND members.email <> ''").
+		Where("loans.due_at > ? AND loans.due_at <= ?", now, now.Add(cfg.LoanReminder)).
+		Order("loans.due_at, loans.id").
+		Scan(&loans).Error
+	if err != nil {
+		return err
+	}
+	for i, loan := range loans {
+		id, due := loan.ID, loan.DueAt
+		data := LoanDueEmail{Name: loan.Name, Title: loan.Title, Author: loan.Author, DueDate: due}
+		err := queueEmailThen("loan_due", []string{loan.Email}, data, func(ctx context.Context) error {
+			// Unless renewed or returned meanwhile
+			return db.WithContext(ctx).Model(&Loan{}).Where("id = ? AND due_at = ? AND returned_at IS NULL", id, due).
+				Update("reminded_at", time.Now().UTC()).Error
+		})
+		if err != nil {
+			return fmt.Errorf("queued %d of %d loan reminders: %w", i, len(loans), err)
+		}
+	}
+	if len(loans) > 0 {
+		log.Printf("Queued %d loan reminders", len(loans))
+	}
+	return nil
+}
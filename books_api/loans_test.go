@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckoutAndReturn(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	addMember(t, "card-1001")
+	addMember(t, "card-1002")
+	checkout := fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID)
+
+	response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-1001"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.Borrower != "card-1001" || loan.ReturnedAt != nil || loan.DueAt.Sub(loan.CheckedOutAt) != cfg.LoanPeriod {
+		t.Errorf("Unexpected loan %s", response.Body.String())
+	}
+	path := fmt.Sprintf("/api/v1/loans/%d", loan.ID)
+	if loc := response.Header().Get("Location"); loc != path {
+		t.Errorf("Expected Location %s, got %s", path, loc)
+	}
+
+	// One copy, one open loan
+	if response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-1002"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a book on loan, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "POST", path+"/return", "")
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if response.Code != http.StatusOK || loan.ReturnedAt == nil {
+		t.Errorf("Expected the loan returned, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", path+"/return", ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 returning twice, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-1002"}`); response.Code != http.StatusCreated {
+		t.Errorf("Expected the returned book to be lendable, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+}
+
+func TestCheckoutValidation(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	addMember(t, "card-1001")
+	checkout := fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID)
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	for _, body := range []string{`{}`, `{"borrower":"card-1001","due_at":"` + past + `"}`} {
+		if response := webhookRequest(t, router, "POST", checkout, body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+	due := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+	response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-1001","due_at":"`+due.Format(time.RFC3339)+`"}`)
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if response.Code != http.StatusCreated || !loan.DueAt.Equal(due) {
+		t.Errorf("Expected the loan due %v, got %d: %s", due, response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/books/999/checkout", `{"borrower":"card-1001"}`); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+
+	// The index holds even if the check before it is skipped
+	if err := db.Create(&Loan{BookID: dune.ID, Borrower: "card-1002", CheckedOutAt: time.Now(), DueAt: time.Now()}).Error; err == nil {
+		t.Error("Expected a second open loan for the book to be refused")
+	}
+}
+
+func TestLoansNeedLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"reader:pw": {Username: "reader", Roles: []string{roleReader}}})
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	token := loginAs(t, router, "reader", "pw")
+
+	for _, path := range []string{fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID), "/api/v1/loans/1/return"} {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"borrower":"card-1001"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusForbidden {
+			t.Errorf("POST %s: expected status 403 for a reader, got %d", path, response.Code)
+		}
+	}
+}
+
+func TestRenewLoan(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	due := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	loan := Loan{BookID: dune.ID, Borrower: "card-1001", CheckedOutAt: time.Now(), DueAt: due}
+	db.Create(&loan)
+	path := fmt.Sprintf("/api/v1/loans/%d/renew", loan.ID)
+
+	// A renewal extends from the due date
+	response := webhookRequest(t, router, "POST", path, "")
+	var renewed Loan
+	json.Unmarshal(response.Body.Bytes(), &renewed)
+	if response.Code != http.StatusOK || renewed.Renewals != 1 || !renewed.DueAt.Equal(due.Add(cfg.LoanPeriod)) {
+		t.Errorf("Expected the loan due %v after one renewal, got %d: %s", due.Add(cfg.LoanPeriod), response.Code, response.Body.String())
+	}
+
+	// An overdue loan is extended from now instead
+	db.Model(&loan).Update("due_at", time.Now().Add(-72*time.Hour))
+	response = webhookRequest(t, router, "POST", path, "")
+	json.Unmarshal(response.Body.Bytes(), &renewed)
+	if response.Code != http.StatusOK || renewed.Renewals != 2 || renewed.DueAt.Before(time.Now().Add(cfg.LoanPeriod-time.Minute)) {
+		t.Errorf("Expected the overdue loan extended from now, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "POST", path, "")
+	if response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "at most 2 times") {
+		t.Errorf("Expected status 409 past MAX_RENEWALS, got %d: %s", response.Code, response.Body.String())
+	}
+	db.Model(&loan).Updates(map[string]interface{}{"renewals": 0, "returned_at": time.Now()})
+	if response := webhookRequest(t, router, "POST", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 renewing a returned loan, got %d", response.Code)
+	}
+}
+
+func TestOverdueLoans(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	now := time.Now()
+	var books []Book
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"},
+		{Title: "Persuasion", Author: "Jane Austen", ISBN: "9780141439686"},
+	} {
+		db.Create(&b)
+		books = append(books, b)
+	}
+	returned := now.Add(-time.Hour)
+	for _, loan := range []Loan{
+		{BookID: books[0].ID, Borrower: "card-1", DueAt: now.Add(-24 * time.Hour)},
+		{BookID: books[1].ID, Borrower: "card-2", DueAt: now.Add(-5 * 24 * time.Hour)},
+		{BookID: books[2].ID, Borrower: "card-3", DueAt: now.Add(24 * time.Hour)},
+		{BookID: books[3].ID, Borrower: "card-4", DueAt: now.Add(-48 * time.Hour), ReturnedAt: &returned},
+	} {
+		loan.CheckedOutAt = now.Add(-30 * 24 * time.Hour)
+		db.Create(&loan)
+	}
+
+	response := webhookRequest(t, router, "GET", "/api/v1/loans/overdue", "")
+	var overdue []OverdueLoan
+	json.Unmarshal(response.Body.Bytes(), &overdue)
+	if response.Code != http.StatusOK || len(overdue) != 2 {
+		t.Fatalf("Expected 2 overdue loans, got %d: %s", response.Code, response.Body.String())
+	}
+	if overdue[0].Title != "Emma" || overdue[0].DaysOverdue != 5 || overdue[1].Title != "Dune" || overdue[1].DaysOverdue != 1 {
+		t.Errorf("Expected Emma then Dune, longest overdue first, got %s", response.Body.String())
+	}
+}
+
+// failingMailer fails every delivery
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, e *Email) error {
+	return errors.New("relay refused")
+}
+
+func TestLoanReminders(t *testing.T) {
+	clearDB()
+	dir := t.TempDir()
+	useMailer(t, &fileMailer{dir: dir, from: "Library <library@example.com>"})
+	now := time.Now()
+	var books []Book
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"},
+	} {
+		db.Create(&b)
+		books = append(books, b)
+	}
+	ada := Member{Name: "Ada", Email: "ada@example.com", MembershipNumber: "card-1001", Status: memberActive}
+	db.Create(&ada)
+	addMember(t, "card-2002")
+	for _, loan := range []Loan{
+		{BookID: books[0].ID, Borrower: "card-1001", DueAt: now.Add(24 * time.Hour)},
+		{BookID: books[1].ID, Borrower: "card-1001", DueAt: now.Add(10 * 24 * time.Hour)},
+		{BookID: books[2].ID, Borrower: "card-2002", DueAt: now.Add(24 * time.Hour)},
+	} {
+		loan.CheckedOutAt = now.Add(-7 * 24 * time.Hour)
+		db.Create(&loan)
+	}
+
+	// A full queue or a failed delivery leaves the loan to be reminded on the next run
+	saved := jobs
+	jobs = newJobQueue(0, 0, time.Second)
+	if err := sendLoanReminders(context.Background()); err == nil {
+		t.Error("Expected an error when the queue is full")
+	}
+	jobs = saved
+	mailer = failingMailer{}
+	if err := sendLoanReminders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	jobs.Wait()
+	mailer = &fileMailer{dir: dir, from: "Library <library@example.com>"}
+	var unreminded int64
+	db.Model(&Loan{}).Where("reminded_at IS NULL").Count(&unreminded)
+	if unreminded != 3 {
+		t.Errorf("Expected no loan marked as reminded, got %d unreminded", unreminded)
+	}
+
+	// Only Dune is due soon to a member with an email, and only once
+	for i := 0; i < 2; i++ {
+		if err := sendLoanReminders(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		jobs.Wait()
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*-loan_due-*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one reminder, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "To: ada@example.com") || !strings.Contains(string(data), "\"Dune\" by Frank Herbert") {
+		t.Errorf("Unexpected reminder:\n%s", data)
+	}
+
+	// Renewing sets a new due date to be reminded of
+	var dune Loan
+	db.Where("book_id = ?", books[0].ID).First(&dune)
+	if dune.RemindedAt == nil {
+		t.Fatal("Expected the loan marked as reminded")
+	}
+	router := setupRouter()
+	response := webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/loans/%d/renew", dune.ID), "")
+	var renewed Loan
+	json.Unmarshal(response.Body.Bytes(), &renewed)
+	if response.Code != http.StatusOK || renewed.RemindedAt != nil {
+		t.Errorf("Expected the renewal to clear reminded_at, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Shelf locations. BOOK_LOCATIONS lists the places a book can be shelved,
+// as "code=name;code=name", such as "FIC=Adult fiction;REF=Reference". A
+// book's location is one of the codes, and its call number orders it on
+// the shelf, so staff reading a shelf can compare it with the catalog.
+
+// Location is a place books are shelved, with how many are there
+type Location struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	BookCount int64  `json:"book_count"`
+}
+
+// Location codes appear in URLs, so they are letters, digits, dots,
+// dashes and underscores
+var locationCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,31}$`)
+
+// Parse "code=name;code=name" locations. An entry without a name is named
+// by its code; one with a bad or repeated code is skipped.
+func parseLocations(value string) []Location {
+	var locations []Location
+	seen := map[string]bool{}
+	for _, item := range strings.Split(value, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		code, name, _ := strings.Cut(item, "=")
+		code, name = strings.TrimSpace(code), strings.TrimSpace(name)
+		if !locationCodePattern.MatchString(code) || seen[strings.ToLower(code)] {
+			log.Printf("Ignoring invalid BOOK_LOCATIONS entry %q", item)
+			continue
+		}
+		seen[strings.ToLower(code)] = true
+		if name == "" {
+			name = code
+		}
+		locations = append(locations, Location{Code: code, Name: name})
+	}
+	return locations
+}
+
+// The configured location with the code, in any case
+func findLocation(code string) (Location, bool) {
+	for _, l := range cfg.Locations {
+		if strings.EqualFold(l.Code, code) {
+			return l, true
+		}
+	}
+	return Location{}, false
+}
+
+// List the configured locations, in configuration order, with the number
+// of books at each
+func getLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var counts []struct {
+		Location string
+		Books    int64
+	}
+	err := db.WithContext(r.Context()).Model(&Book{}).
+		Select("location, COUNT(*) AS books").
+		Where("location <> ''").
+		Group("location").
+		Scan(&counts).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list locations")
+		return
+	}
+	byCode := map[string]int64{}
+	for _, c := range counts {
+		byCode[c.Location] = c.Books
+	}
+	locations := make([]Location, len(cfg.Locations))
+	for i, l := range cfg.Locations {
+		locations[i] = l
+		locations[i].BookCount = byCode[l.Code]
+	}
+	writeList(w, r, locations)
+}
+
+// The books at a location in shelf order: by call number, then title, with
+// books without a call number last. The listing filters, such as
+// ?status=available, narrow them.
+func getLocationBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	location, ok := findLocation(mux.Vars(r)["code"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "location_not_found", "Location not found")
+		return
+	}
+	filter, err := parseBookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+	filter.Location = location.Code
+	var books []Book
+	err = filter.apply(db.WithContext(r.Context())).Preload("Tags").
+		Order("call_number = '', call_number, title, id").
+		Find(&books).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list books")
+		return
+	}
+	writeList(w, r, books)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseLocations(t *testing.T) {
+	locations := parseLocations(" FIC = Adult fiction;REF;bad code=Nope;fic=Again;;J-PB=Picture books ")
+	if got := fmt.Sprint(locations); got != "[{FIC Adult fiction 0} {REF REF 0} {J-PB Picture books 0}]" {
+		t.Errorf("Unexpected locations %s", got)
+	}
+	if locations := parseLocations(""); len(locations) != 0 {
+		t.Errorf("Expected no locations, got %v", locations)
+	}
+}
+
+func TestBookLocations(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	saved := cfg.Locations
+	cfg.Locations = parseLocations("FIC=Adult fiction;REF=Reference;J-PB=Picture books")
+	defer func() { cfg.Locations = saved }()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/books",
+		`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","location":"Attic"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "location") {
+		t.Errorf("Expected a location error, got %d: %s", response.Code, response.Body.String())
+	}
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","location":" fic ","call_number":" FIC HER "}`,
+		`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","location":"FIC","call_number":"FIC AUS"}`,
+		`{"title":"Neuromancer","author":"William Gibson","isbn":"9780441569595","location":"FIC"}`,
+		`{"title":"Clean Code","author":"Robert Martin","isbn":"9780132350884","location":"REF","call_number":"005.1 MAR"}`,
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/books", body); response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+		}
+	}
+	var dune Book
+	db.Where("title = ?", "Dune").First(&dune)
+	if dune.Location != "FIC" || dune.CallNumber != "FIC HER" {
+		t.Errorf("Expected the configured code and a trimmed call number, got %q %q", dune.Location, dune.CallNumber)
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/locations", "")
+	var locations []Location
+	json.Unmarshal(response.Body.Bytes(), &locations)
+	if got := fmt.Sprint(locations); got != "[{FIC Adult fiction 3} {REF Reference 1} {J-PB Picture books 0}]" {
+		t.Errorf("Unexpected locations %s", got)
+	}
+
+	// Shelf order, with books without a call number last
+	response = webhookRequest(t, router, "GET", "/api/v1/locations/fic/books", "")
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := strings.Join(bookTitles(books), ","); got != "Emma,Dune,Neuromancer" {
+		t.Errorf("Expected the books in call number order, got %q", got)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/locations/Attic/books", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown location, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/books?location=REF", "")
+	books = nil
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if got := strings.Join(bookTitles(books), ","); got != "Clean Code" {
+		t.Errorf("Expected the reference books, got %q", got)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/books?location=Attic", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown location filter, got %d", response.Code)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Rendered email ready for delivery
+type Email struct {
+	To       []string
+	Subject  string
+	Body     string
+	Template string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(ctx context.Context, e *Email) error
+}
+
+// Active mailer, chosen by initMailer. nil disables email.
+var mailer Mailer
+
+// SMTP server settings
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func initMailer() {
+	switch cfg.Mailer {
+	case "smtp":
+		mailer = &smtpMailer{cfg: cfg.SMTP, from: cfg.MailFrom}
+	case "file":
+		mailer = &fileMailer{dir: cfg.MailDir, from: cfg.MailFrom}
+		log.Printf("Writing outgoing email to %s", cfg.MailDir)
+	case "", "none":
+		mailer = nil
+	default:
+		log.Fatalf("Unknown MAILER %q", cfg.Mailer)
+	}
+}
+
+// Email templates. Each defines a "subject" and a "body".
+var emailTemplates = map[string]*template.Template{
+	"loan_due": template.Must(template.New("loan_due").Parse(
+		`{{define "subject"}}"{{.Title}}" is due {{.DueDate.Format "Jan 2"}}{{end}}` +
+			`{{define "body"}}Hi {{.Name}},
+
+"{{.Title}}" by {{.Author}} is due back on {{.DueDate.Format "Monday, January 2, 2006"}}.
+Please return or renew it before then to avoid a late fee.
+{{end}}`)),
+	"reservation_available": template.Must(template.New("reservation_available").Parse(
+		`{{define "subject"}}"{{.Title}}" is ready for you{{end}}` +
+			`{{define "body"}}Hi {{.Name}},
+
+The book you reserved, "{{.Title}}" by {{.Author}}, is now available.
+We'll hold it for you until {{.HoldUntil.Format "Monday, January 2, 2006"}}.
+{{end}}`)),
+	"password_reset": template.Must(template.New("password_reset").Parse(
+		`{{define "subject"}}Reset your password{{end}}` +
+			`{{define "body"}}Hi {{.Name}},
+
+Someone asked to reset the password for your account. To choose a new one, open:
+
+{{.ResetURL}}
+
+This link expires in {{.ExpiresIn}}. If you didn't ask for a reset, you can ignore this email.
+{{end}}`)),
+}
+
+// Data for the loan_due template
+type LoanDueEmail struct {
+	Name    string
+	Title   string
+	Author  string
+	DueDate time.Time
+}
+
+// Data for the reservation_available template
+type ReservationAvailableEmail struct {
+	Name      string
+	Title     string
+	Author    string
+	HoldUntil time.Time
+}
+
+// Data for the password_reset template
+type PasswordResetEmail struct {
+	Name      string
+	ResetURL  string
+	ExpiresIn string
+}
+
+// Render a template into an email
+func renderEmail(name string, to []string, data interface{}) (*Email, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+	return &Email{To: to, Subject: subject.String(), Body: body.String(), Template: name}, nil
+}
+
+// Returned when the job queue has no room for an email
+var errEmailQueueFull = errors.New("job queue is full")
+
+// Render an email now and deliver it in the background. Rendering errors and
+// a full queue are returned; delivery failures are logged by the job queue.
+func queueEmail(name string, to []string, data interface{}) error {
+	return queueEmailThen(name, to, data, nil)
+}
+
+// queueEmail, calling sent from the job once the email is delivered
+func queueEmailThen(name string, to []string, data interface{}, sent func(ctx context.Context) error) error {
+	e, err := renderEmail(name, to, data)
+	if err != nil {
+		return err
+	}
+	if mailer == nil {
+		return nil
+	}
+	m := mailer
+	queued := jobs.Enqueue("email:"+name, func(ctx context.Context) error {
+		if err := m.Send(ctx, e); err != nil {
+			return err
+		}
+		if sent != nil {
+			return sent(ctx)
+		}
+		return nil
+	})
+	if !queued {
+		return errEmailQueueFull
+	}
+	return nil
+}
+
+// Build an RFC 5322 message
+func formatEmail(from string, e *Email) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@books-api>\r\n", randomID())
+	if e.Template != "" {
+		fmt.Fprintf(&b, "X-Template: %s\r\n", e.Template)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(e.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+func randomID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// smtpMailer delivers through an SMTP relay, using STARTTLS when offered
+type smtpMailer struct {
+	cfg  SMTPConfig
+	from string
+}
+
+func (m *smtpMailer) Send(ctx context.Context, e *Email) error {
+	sender, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	return smtp.SendMail(addr, auth, sender.Address, e.To, formatEmail(m.from, e))
+}
+
+// fileMailer writes each email to a .eml file, for development and tests
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m *fileMailer) Send(ctx context.Context, e *Email) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%s.eml", time.Now().UTC().Format("20060102T150405.000"), e.Template, randomID())
+	return os.WriteFile(filepath.Join(m.dir, name), formatEmail(m.from, e), 0o644)
+}
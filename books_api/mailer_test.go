@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Install a mailer for one test
+func useMailer(t *testing.T, m Mailer) {
+	saved := mailer
+	mailer = m
+	t.Cleanup(func() {
+		jobs.Wait()
+		mailer = saved
+	})
+}
+
+func TestRenderEmailTemplates(t *testing.T) {
+	due := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{"loan_due", LoanDueEmail{Name: "Ada", Title: "Dune", Author: "Frank Herbert", DueDate: due}, "due back on Friday, March 15, 2024"},
+		{"reservation_available", ReservationAvailableEmail{Name: "Ada", Title: "Dune", Author: "Frank Herbert", HoldUntil: due}, "until Friday, March 15, 2024"},
+		{"password_reset", PasswordResetEmail{Name: "Ada", ResetURL: "https://example.com/reset?t=abc", ExpiresIn: "1 hour"}, "https://example.com/reset?t=abc"},
+	}
+	for _, c := range cases {
+		e, err := renderEmail(c.name, []string{"ada@example.com"}, c.data)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if e.Subject == "" || !strings.Contains(e.Body, c.want) {
+			t.Errorf("%s: unexpected email %q / %q", c.name, e.Subject, e.Body)
+		}
+	}
+
+	if _, err := renderEmail("missing", nil, nil); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
+
+func TestFileMailerWritesEmails(t *testing.T) {
+	dir := t.TempDir()
+	useMailer(t, &fileMailer{dir: dir, from: "Library <library@example.com>"})
+
+	err := queueEmail("loan_due", []string{"ada@example.com"}, LoanDueEmail{Name: "Ada", Title: "Dune", DueDate: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.Wait()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*-loan_due-*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one email on disk, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, want := range []string{"To: ada@example.com\r\n", "Subject: \"Dune\" is due", "X-Template: loan_due\r\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected email to contain %q, got:\n%s", want, data)
+		}
+	}
+}
+
+// Accept one SMTP transaction and return the DATA section
+func fakeSMTPServer(t *testing.T) (int, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 fake ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					got <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 fake")
+			case cmd == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, got
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	port, got := fakeSMTPServer(t)
+	m := &smtpMailer{cfg: SMTPConfig{Host: "127.0.0.1", Port: port}, from: "Library <library@example.com>"}
+
+	due := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	e, _ := renderEmail("loan_due", []string{"ada@example.com"}, LoanDueEmail{Name: "Ada", Title: "Dune", Author: "Frank Herbert", DueDate: due})
+	if err := m.Send(context.Background(), e); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case data := <-got:
+		if !strings.Contains(data, "Subject: \"Dune\" is due Mar 15") || !strings.Contains(data, "Friday, March 15, 2024") {
+			t.Errorf("Unexpected message:\n%s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SMTP server received no message")
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"gorm.io/driver/sqlite"
@@ -15,36 +18,119 @@ import (
 
 // Book model
 type Book struct {
-	ID     uint   `json:"id" gorm:"primaryKey"`
-	Title  string `json:"title" gorm:"not null"`
-	Author string `json:"author" gorm:"not null"`
-	ISBN   string `json:"isbn" gorm:"unique;not null"`
-	Year   int    `json:"year"`
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Title       string `json:"title" gorm:"not null"`
+	Author      string `json:"author" gorm:"not null"`
+	AuthorID    *uint  `json:"author_id,omitempty" gorm:"index"`
+	PublisherID *uint  `json:"publisher_id,omitempty" gorm:"index"`
+	ISBN        string `json:"isbn" gorm:"not null;uniqueIndex:idx_books_library_isbn,priority:2"`
+	Year        int    `json:"year"`
+	Description string `json:"description"`
+	CoverURL    string `json:"cover_url"`
+	CoverKey    string `json:"-"`
+	PriceCents  int64  `json:"price_cents,omitempty"`
+	Language    string `json:"language,omitempty" gorm:"index"`
+	PageCount   int    `json:"page_count,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Status      string `json:"status" gorm:"not null;default:available;index"`
+	// What the library paid for the book, in the minor unit of its ISO 4217
+	// purchase currency, for insurance and acquisitions reporting
+	PurchasePriceCents int64  `json:"purchase_price_cents,omitempty"`
+	PurchaseCurrency   string `json:"purchase_currency,omitempty"`
+	// Where the book is shelved, one of the BOOK_LOCATIONS codes, and its
+	// call number there, such as 813.54 HER
+	Location   string `json:"location,omitempty" gorm:"index"`
+	CallNumber string `json:"call_number,omitempty"`
+	// Free-form metadata a library keeps beyond the catalog fields, such as
+	// donated_by
+	Attributes map[string]string `json:"attributes,omitempty" gorm:"serializer:json"`
+	Tags       []Tag             `json:"tags,omitempty" gorm:"many2many:book_tags"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt    `json:"-" gorm:"index"`
+	// Incremented by every write, for conditional writes to compare and set
+	Version uint `json:"-" gorm:"not null;default:1"`
+
+	// Kept up to date from the book's reviews; writes to the book ignore them
+	AverageRating float64 `json:"average_rating,omitempty" gorm:"->;not null;default:0"`
+	ReviewCount   int64   `json:"review_count,omitempty" gorm:"->;not null;default:0"`
+
+	// The series the book is part of and its volume number in it
+	SeriesID     *uint       `json:"series_id,omitempty" gorm:"index"`
+	SeriesVolume int         `json:"series_volume,omitempty"`
+	Series       *BookSeries `json:"series,omitempty" gorm:"-"`
+
+	// The work this book is an edition of, shared with its other editions
+	WorkID *uint `json:"work_id,omitempty" gorm:"index"`
+
+	// The library whose catalog holds the book; 0 for the main catalog.
+	// An ISBN is unique within a library, not across them.
+	LibraryID uint `json:"-" gorm:"not null;default:0;uniqueIndex:idx_books_library_isbn,priority:1"`
+
+	// The version a write's If-Match was checked against, if it had one
+	ifMatchVersion uint
 }
 
+// Tag model, a free-form label shared between books
+type Tag struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"unique;not null"`
+}
+
+// Review model, a reader's rating and optional text for a book
+type Review struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	BookID    uint      `json:"book_id" gorm:"index;not null"`
+	Rating    float64   `json:"rating"`
+	Body      string    `json:"body"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Models managed by AutoMigrate
+var models = []interface{}{&Book{}, &Tag{}, &Review{}, &OutboxEvent{}, &Checkpoint{}, &Interaction{}, &BookSimilarity{}, &RefreshJob{}, &RefreshConflict{}, &ScheduledRun{}, &JobLock{}, &SAMLRequest{}, &Order{}, &OrderItem{}, &Webhook{}, &WebhookDelivery{}, &BookChange{}, &Author{}, &Publisher{}, &Loan{}, &Fine{}, &Member{}, &ReadingList{}, &ListEntry{}, &Series{}, &Work{}, &BookRevision{}, &Library{}, &Acquisition{}, &Donor{}, &Donation{}}
+
 // Database instance
 var db *gorm.DB
 
 // Initialize database
 func initDB() {
 	var err error
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "books.db"
-	}
-
-	db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	db, err = gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
+	registerCacheCallbacks(db)
+	registerLiveCallbacks(db)
+	registerSeriesCallbacks(db)
+	registerLibraryCallbacks(db)
+	configureReplication(db)
 
 	// Migrate the schema
-	db.AutoMigrate(&Book{})
+	if err := db.AutoMigrate(models...); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+	backfillAuthors()
+	backfillRatings()
+	backfillWorks()
+	backfillUpdatedAt()
+	backfillISBN13()
+	backfillBookStatus()
+	initFTS()
+	initSuggestIndexes()
 
 	// Seed the database
 	seedDatabase()
 }
 
+// Date books from before updated_at was stored by when they were added.
+// Does nothing once every book has one.
+func backfillUpdatedAt() {
+	if err := db.Exec("UPDATE books SET updated_at = created_at WHERE updated_at IS NULL").Error; err != nil {
+		log.Fatal("Failed to backfill updated_at:", err)
+	}
+}
+
 // Seed database with sample data
 func seedDatabase() {
 	var count int64
@@ -73,9 +159,104 @@ func getBooks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	if r.URL.Query().Has("ids") {
+		getBookBatch(w, r)
+		return
+	}
+
+	keys, err := parseBookSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_sort", "Invalid sort: "+err.Error())
+		return
+	}
+	filter, err := parseBookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+	scope, ok := bookScope(w, r)
+	if !ok {
+		return
+	}
+	fields, err := parseBookFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_fields", "Invalid fields: "+err.Error())
+		return
+	}
+	facetNames, err := parseBookFacets(r.URL.Query().Get("facets"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_facets", "Invalid facets: "+err.Error())
+		return
+	}
+
+	// The total of the whole filtered collection, whatever the page. HEAD
+	// stops here, so clients can size the collection without reading it.
+	var total int64
+	if err := filter.apply(scope.Model(&Book{})).Count(&total).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to count books")
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if r.Method == "HEAD" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if negotiateFormat(r.Header.Get("Accept")) == csvType {
+		streamBooksCSV(w, filter.apply(scope), keys)
+		return
+	}
+
+	var facets Facets
+	if len(facetNames) > 0 {
+		if facets, err = bookFacets(r.Context(), filter, facetNames); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to count facets")
+			return
+		}
+	}
+
+	query := fields.selectColumns(filter.apply(scope), keys)
+	if wantsCursorPage(r) {
+		getBooksPage(w, r, query, keys, fields, total, facets)
+		return
+	}
+
+	// Always ordered, as the library condition can have the database read
+	// the books in index order rather than by id
 	var books []Book
-	db.Find(&books)
-	json.NewEncoder(w).Encode(books)
+	query = orderBooks(query, withIDTiebreak(keys))
+	query.Find(&books)
+	list := enveloped(r, fields.projectList(books), ListMeta{Total: &total, Page: 1, Facets: facets})
+	if _, ok := list.(Envelope); !ok && facets != nil {
+		list = facetedBookList{Items: list, Facets: facets}
+	}
+	writeJSONConditional(w, r, list)
+}
+
+// BookCount is the number of books matching a listing's filters
+type BookCount struct {
+	Count int64 `json:"count"`
+}
+
+// Count the books the listing would return, without reading them
+func countBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	filter, err := parseBookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+	scope, ok := bookScope(w, r)
+	if !ok {
+		return
+	}
+	var result BookCount
+	if err := filter.apply(scope.Model(&Book{})).Count(&result.Count).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to count books")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
 }
 
 // Get book by ID
@@ -86,17 +267,41 @@ func getBook(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
 		return
 	}
-
-	var book Book
-	if err := db.First(&book, id).Error; err != nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+	fields, err := parseBookFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_fields", "Invalid fields: "+err.Error())
+		return
+	}
+	scope, ok := bookScope(w, r)
+	if !ok {
 		return
 	}
 
-	json.NewEncoder(w).Encode(book)
+	// The whole book is cached, so fields are picked from it rather than
+	// selected in SQL. Only live books are cached.
+	key := bookCacheKey(uint(id))
+	var book Book
+	cached, hit := bookCache.Get(key)
+	if hit {
+		book, hit = cached.(Book)
+	}
+	if library, _ := libraryFrom(r.Context()); hit && book.LibraryID != library {
+		// Another library's book, which the query below won't find
+		hit = false
+	}
+	if !hit {
+		if err := scope.Preload("Tags").First(&book, id).Error; err != nil {
+			writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+			return
+		}
+		if !book.DeletedAt.Valid {
+			bookCache.Set(key, book)
+		}
+	}
+	writeJSONConditional(w, r, fields.project(&book))
 }
 
 // Create new book
@@ -105,25 +310,115 @@ func createBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	var book Book
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeBody(r, &book); err != nil {
+		writeInvalidBody(w, r)
 		return
 	}
 
-	if book.Title == "" || book.Author == "" || book.ISBN == "" {
-		http.Error(w, "Title, Author, and ISBN are required", http.StatusBadRequest)
+	// Optionally fill in missing fields from the metadata provider. A failed
+	// lookup never blocks the create; validation still applies afterwards.
+	if book.ISBN != "" && metadataProvider != nil && (cfg.AutoEnrich || r.URL.Query().Get("enrich") == "true") {
+		if _, err := enrichBook(r.Context(), &book, false); err != nil {
+			log.Printf("Auto-enrich for ISBN %s failed: %v", book.ISBN, err)
+		}
+	}
+
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
-	if err := db.Create(&book).Error; err != nil {
-		http.Error(w, "Failed to create book", http.StatusInternalServerError)
+	if err := db.WithContext(r.Context()).Create(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create book")
+		}
 		return
 	}
+	notifyBookAdded(&book)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(book)
 }
 
+// Copy the fields an update sets onto book, leaving those it leaves empty.
+// Reports whether anything changed.
+func mergeBookUpdate(book, update *Book) bool {
+	before := *book
+	if update.Title != "" {
+		book.Title = update.Title
+	}
+	if update.Author != "" {
+		book.Author = update.Author
+	}
+	if update.ISBN != "" {
+		book.ISBN = update.ISBN
+	}
+	if update.Year != 0 {
+		book.Year = update.Year
+	}
+	if update.Description != "" {
+		book.Description = update.Description
+	}
+	if update.CoverURL != "" {
+		book.CoverURL = update.CoverURL
+	}
+	if update.PriceCents != 0 {
+		book.PriceCents = update.PriceCents
+	}
+	if update.Language != "" {
+		book.Language = update.Language
+	}
+	if update.PageCount != 0 {
+		book.PageCount = update.PageCount
+	}
+	if update.Format != "" {
+		book.Format = update.Format
+	}
+	if update.Status != "" {
+		book.Status = update.Status
+	}
+	if update.PurchasePriceCents != 0 {
+		book.PurchasePriceCents = update.PurchasePriceCents
+	}
+	if update.PurchaseCurrency != "" {
+		book.PurchaseCurrency = update.PurchaseCurrency
+	}
+	if update.Location != "" {
+		book.Location = update.Location
+	}
+	if update.CallNumber != "" {
+		book.CallNumber = update.CallNumber
+	}
+	if update.Attributes != nil {
+		book.Attributes = update.Attributes
+	}
+	if update.PublisherID != nil {
+		book.PublisherID = update.PublisherID
+	}
+	if update.SeriesID != nil {
+		book.SeriesID = update.SeriesID
+	}
+	if update.SeriesVolume != 0 {
+		book.SeriesVolume = update.SeriesVolume
+	}
+	if update.WorkID != nil {
+		book.WorkID = update.WorkID
+	}
+	return book.Title != before.Title || book.Author != before.Author || book.ISBN != before.ISBN || book.Year != before.Year ||
+		book.Description != before.Description || book.CoverURL != before.CoverURL || book.PriceCents != before.PriceCents ||
+		book.Language != before.Language || book.PageCount != before.PageCount || book.Format != before.Format ||
+		book.Status != before.Status || book.PurchasePriceCents != before.PurchasePriceCents || book.PurchaseCurrency != before.PurchaseCurrency ||
+		book.Location != before.Location || book.CallNumber != before.CallNumber ||
+		!maps.Equal(book.Attributes, before.Attributes) ||
+		!equalIDs(book.PublisherID, before.PublisherID) || !equalIDs(book.SeriesID, before.SeriesID) || book.SeriesVolume != before.SeriesVolume ||
+		!equalIDs(book.WorkID, before.WorkID)
+}
+
+// Whether two optional IDs are both unset or the same
+func equalIDs(a, b *uint) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
 // Update book
 func updateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -132,37 +427,40 @@ func updateBook(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
 		return
 	}
 
 	var book Book
-	if err := db.First(&book, id).Error; err != nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
 		return
 	}
 
-	var updatedBook Book
-	if err := json.NewDecoder(r.Body).Decode(&updatedBook); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !checkIfMatch(w, r, &book) {
 		return
 	}
 
-	// Update fields
-	if updatedBook.Title != "" {
-		book.Title = updatedBook.Title
-	}
-	if updatedBook.Author != "" {
-		book.Author = updatedBook.Author
-	}
-	if updatedBook.ISBN != "" {
-		book.ISBN = updatedBook.ISBN
+	var updatedBook Book
+	if err := decodeBody(r, &updatedBook); err != nil {
+		writeInvalidBody(w, r)
+		return
 	}
-	if updatedBook.Year != 0 {
-		book.Year = updatedBook.Year
+
+	mergeBookUpdate(&book, &updatedBook)
+
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
 	}
 
-	db.Save(&book)
+	if err := db.WithContext(r.Context()).Save(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update book")
+		}
+		return
+	}
+	setBookETag(w, book.ID)
 	json.NewEncoder(w).Encode(book)
 }
 
@@ -174,64 +472,355 @@ func deleteBook(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
 		return
 	}
 
 	var book Book
-	if err := db.First(&book, id).Error; err != nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+
+	if !checkIfMatch(w, r, &book) {
 		return
 	}
 
-	db.Delete(&book)
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		return deleteBookTx(tx, &book)
+	})
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete book")
+		}
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Move a book to the trash. Its tags, reviews, interactions and cover
+// stay until the trash is purged, so restoring it brings them back.
+func deleteBookTx(tx *gorm.DB, book *Book) error {
+	return tx.Delete(book).Error
+}
+
+// Get reviews for a book
+func getBookReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+
+	var reviews []Review
+	db.Where("book_id = ?", book.ID).Order("id").Find(&reviews)
+	writeList(w, r, reviews)
+}
+
 // CORS middleware
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization, X-HTTP-Method-Override, If-Match, If-None-Match, X-Library")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, ETag, API-Version, Link")
 		next.ServeHTTP(w, r)
 	})
 }
 
-func main() {
-	// Initialize database
-	initDB()
-
-	// Setup routes
+// Setup routes
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.NotFoundHandler = unmatchedHandler(r)
+	r.MethodNotAllowedHandler = r.NotFoundHandler
 	r.Use(corsMiddleware)
+	r.Use(authMiddleware)
+	r.Use(libraryMiddleware)
+
+	// API routes, under each version's prefix
+	for _, v := range apiVersions {
+		api := r.PathPrefix(v.Prefix).Subrouter()
+		api.Use(v.middleware)
+		api.Use(catalogWriteMiddleware)
+		api.Use(negotiateMiddleware)
+		if v.routes != nil {
+			v.routes(api)
+		}
+		registerAPIRoutes(api)
+	}
 
-	// API routes
-	api := r.PathPrefix("/api/v1").Subrouter()
-	api.HandleFunc("/books", getBooks).Methods("GET")
-	api.HandleFunc("/books", createBook).Methods("POST")
-	api.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	}).Methods("OPTIONS")
-	api.HandleFunc("/books/{id}", getBook).Methods("GET")
-	api.HandleFunc("/books/{id}", updateBook).Methods("PUT")
-	api.HandleFunc("/books/{id}", deleteBook).Methods("DELETE")
-	api.HandleFunc("/books/{id}", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	}).Methods("OPTIONS")
+	// Presigned transfers for the local blob store
+	if local, ok := blobStore.(*localBlobStore); ok {
+		r.PathPrefix("/blobs/").Handler(local).Methods("GET", "PUT")
+	}
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		json.NewEncoder(w).Encode(HealthStatus{Status: "ok"})
 	}).Methods("GET")
+	r.HandleFunc("/readyz", getReadiness).Methods("GET")
+
+	// Feeds
+	r.HandleFunc("/feeds/books.atom", getBooksFeed).Methods("GET", "HEAD")
+
+	// GraphQL, for clients that pick their fields
+	r.HandleFunc("/graphql", serveGraphQL).Methods("GET", "POST")
+
+	// API description and docs
+	r.HandleFunc("/openapi.json", serveOpenAPISpec).Methods("GET")
+	r.HandleFunc("/docs", serveDocs).Methods("GET")
+
+	return r
+}
+
+// Register the routes every API version shares on its subrouter
+func registerAPIRoutes(api *mux.Router) {
+	api.HandleFunc("/books", getBooks).Methods("GET", "HEAD")
+	api.HandleFunc("/books", createBook).Methods("POST")
+	api.HandleFunc("/books", bulkDeleteBooks).Methods("DELETE")
+	api.HandleFunc("/books/search", searchBooks).Methods("GET")
+	api.HandleFunc("/books/count", countBooks).Methods("GET")
+	api.HandleFunc("/books/export.csv", exportBooksCSV).Methods("GET")
+	api.HandleFunc("/books/bulk", bulkCreateBooks).Methods("POST")
+	api.HandleFunc("/books/bulk", bulkUpdateBooks).Methods("PUT")
+	api.HandleFunc("/books/validate", validateBookRequest).Methods("POST")
+	api.HandleFunc("/books/scan", scanBook).Methods("POST")
+	api.HandleFunc("/books/isbn/{isbn}", getBookByISBN).Methods("GET")
+	api.HandleFunc("/books/isbn/{isbn}", upsertBookByISBN).Methods("PUT")
+	api.HandleFunc("/books/random", getRandomBooks).Methods("GET")
+	api.HandleFunc("/books/changes", getBookChanges).Methods("GET")
+	api.Handle("/books/trash", requireRole(roleLibrarian)(http.HandlerFunc(getTrash))).Methods("GET")
+	api.HandleFunc("/books/{id}", getBook).Methods("GET")
+	api.HandleFunc("/books/{id}", updateBook).Methods("PUT")
+	api.HandleFunc("/books/{id}", patchBook).Methods("PATCH")
+	api.HandleFunc("/books/{id}", deleteBook).Methods("DELETE")
+	api.HandleFunc("/books/{id}/enrich", enrichBookHandler).Methods("POST")
+	api.HandleFunc("/books/{id}/restore", restoreBook).Methods("POST")
+	api.HandleFunc("/books/{id}/reviews", getBookReviews).Methods("GET")
+	api.HandleFunc("/books/{id}/also-read", getAlsoRead).Methods("GET")
+	api.HandleFunc("/books/{id}/related", getRelatedBooks).Methods("GET")
+	api.HandleFunc("/books/{id}/editions", getBookEditions).Methods("GET")
+	api.HandleFunc("/books/{id}/revisions", getBookRevisions).Methods("GET")
+	api.HandleFunc("/books/{id}/revisions/{number}/rollback", rollbackBook).Methods("POST")
+	api.HandleFunc("/books/{id}/cover", getCover).Methods("GET")
+	api.HandleFunc("/books/{id}/cover", uploadCover).Methods("PUT")
+	api.HandleFunc("/books/{id}/cover", deleteCover).Methods("DELETE")
+	api.HandleFunc("/books/{id}/cover/upload-url", coverUploadURL).Methods("POST")
+	api.HandleFunc("/books/{id}/cover/complete", completeCoverUpload).Methods("POST")
+	api.HandleFunc("/books/{id}/checkout", checkoutBook).Methods("POST")
+	api.HandleFunc("/books/from-google/{volumeId}", createBookFromGoogle).Methods("POST")
+	api.HandleFunc("/books/from-sru/{isbn}", createBookFromSRU).Methods("POST")
+
+	api.HandleFunc("/authors", getAuthors).Methods("GET")
+	api.HandleFunc("/authors", createAuthor).Methods("POST")
+	api.HandleFunc("/authors/{id}", getAuthor).Methods("GET")
+	api.HandleFunc("/authors/{id}", updateAuthor).Methods("PUT")
+	api.HandleFunc("/authors/{id}", deleteAuthor).Methods("DELETE")
+	api.HandleFunc("/authors/{id}/books", getAuthorBooks).Methods("GET")
+	api.HandleFunc("/publishers", getPublishers).Methods("GET")
+	api.HandleFunc("/publishers", createPublisher).Methods("POST")
+	api.HandleFunc("/publishers/{id}", getPublisher).Methods("GET")
+	api.HandleFunc("/publishers/{id}", updatePublisher).Methods("PUT")
+	api.HandleFunc("/publishers/{id}", deletePublisher).Methods("DELETE")
+	api.HandleFunc("/publishers/{id}/books", getPublisherBooks).Methods("GET")
+	api.HandleFunc("/series", getSeriesList).Methods("GET")
+	api.HandleFunc("/series", createSeries).Methods("POST")
+	api.HandleFunc("/series/{id}", getSeries).Methods("GET")
+	api.HandleFunc("/series/{id}", updateSeries).Methods("PUT")
+	api.HandleFunc("/series/{id}", deleteSeries).Methods("DELETE")
+	api.HandleFunc("/series/{id}/books", getSeriesBooks).Methods("GET")
+	api.HandleFunc("/works", getWorks).Methods("GET")
+	api.HandleFunc("/works/{id}", getWork).Methods("GET")
+	api.HandleFunc("/libraries", getLibraries).Methods("GET")
+	api.Handle("/libraries", requireRole(roleAdmin)(http.HandlerFunc(createLibrary))).Methods("POST")
+	api.HandleFunc("/libraries/{code}", getLibrary).Methods("GET")
+	api.Handle("/libraries/{code}", requireRole(roleAdmin)(http.HandlerFunc(updateLibrary))).Methods("PUT")
+	api.Handle("/libraries/{code}", requireRole(roleAdmin)(http.HandlerFunc(deleteLibrary))).Methods("DELETE")
+	api.HandleFunc("/stats", getCatalogStats).Methods("GET")
+	api.HandleFunc("/suggest", getSuggestions).Methods("GET")
+	api.HandleFunc("/ws", serveLiveUpdates).Methods("GET")
+
+	// Readers, who record their own interactions
+	reader := requireRole(roleReader)
+	api.Handle("/users/{id}/interactions", reader(http.HandlerFunc(createInteraction))).Methods("POST")
+	api.HandleFunc("/users/{id}/recommendations", getUserRecommendations).Methods("GET")
+
+	// Reading lists, which anyone can read but only their owners change
+	lists := api.PathPrefix("/lists").Subrouter()
+	lists.Handle("", reader(http.HandlerFunc(getLists))).Methods("GET")
+	lists.Handle("", reader(http.HandlerFunc(createList))).Methods("POST")
+	lists.HandleFunc("/{id}", getReadingList).Methods("GET")
+	lists.Handle("/{id}", reader(http.HandlerFunc(updateList))).Methods("PUT")
+	lists.Handle("/{id}", reader(http.HandlerFunc(deleteList))).Methods("DELETE")
+	lists.Handle("/{id}/books", reader(http.HandlerFunc(addListBook))).Methods("POST")
+	lists.Handle("/{id}/books", reader(http.HandlerFunc(reorderList))).Methods("PUT")
+	lists.Handle("/{id}/books/{book_id}", reader(http.HandlerFunc(removeListBook))).Methods("DELETE")
+
+	// Lending, at the circulation desk
+	loans := api.PathPrefix("/loans").Subrouter()
+	loans.Use(requireRole(roleLibrarian))
+	loans.HandleFunc("/overdue", getOverdueLoans).Methods("GET")
+	loans.HandleFunc("/{id}", getLoan).Methods("GET")
+	loans.HandleFunc("/{id}/return", returnLoan).Methods("POST")
+	loans.HandleFunc("/{id}/renew", renewLoan).Methods("POST")
+	fines := api.PathPrefix("/fines").Subrouter()
+	fines.Use(requireRole(roleLibrarian))
+	fines.HandleFunc("/{id}/payments", payFine).Methods("POST")
+	members := api.PathPrefix("/members").Subrouter()
+	members.Use(requireRole(roleLibrarian))
+	members.HandleFunc("", getMembers).Methods("GET")
+	members.HandleFunc("", createMember).Methods("POST")
+	members.HandleFunc("/{id}", getMember).Methods("GET")
+	members.HandleFunc("/{id}", updateMember).Methods("PUT")
+	members.HandleFunc("/{id}", deleteMember).Methods("DELETE")
+	members.HandleFunc("/{id}/loans", getMemberLoans).Methods("GET")
+	members.HandleFunc("/{id}/fines", getMemberFines).Methods("GET")
+	api.HandleFunc("/locations", getLocations).Methods("GET")
+	api.HandleFunc("/locations/{code}/books", getLocationBooks).Methods("GET")
+	acquisitions := api.PathPrefix("/acquisitions").Subrouter()
+	acquisitions.Use(requireRole(roleLibrarian))
+	acquisitions.HandleFunc("", getAcquisitions).Methods("GET")
+	acquisitions.HandleFunc("", createAcquisition).Methods("POST")
+	acquisitions.HandleFunc("/reports/vendors", getVendorReport).Methods("GET")
+	acquisitions.HandleFunc("/reports/fiscal-years", getFiscalYearReport).Methods("GET")
+	acquisitions.HandleFunc("/{id}", getAcquisition).Methods("GET")
+	acquisitions.HandleFunc("/{id}", updateAcquisition).Methods("PUT")
+	acquisitions.HandleFunc("/{id}", deleteAcquisition).Methods("DELETE")
+	donors := api.PathPrefix("/donors").Subrouter()
+	donors.Use(requireRole(roleLibrarian))
+	donors.HandleFunc("", getDonors).Methods("GET")
+	donors.HandleFunc("", createDonor).Methods("POST")
+	donors.HandleFunc("/{id}", getDonor).Methods("GET")
+	donors.HandleFunc("/{id}", updateDonor).Methods("PUT")
+	donors.HandleFunc("/{id}", deleteDonor).Methods("DELETE")
+	donors.HandleFunc("/{id}/books", getDonorBooks).Methods("GET")
+	donations := api.PathPrefix("/donations").Subrouter()
+	donations.Use(requireRole(roleLibrarian))
+	donations.HandleFunc("", getDonations).Methods("GET")
+	donations.HandleFunc("", createDonation).Methods("POST")
+	donations.HandleFunc("/acknowledgments", getAcknowledgments).Methods("GET")
+	donations.HandleFunc("/{id}", getDonation).Methods("GET")
+	donations.HandleFunc("/{id}", updateDonation).Methods("PUT")
+	donations.HandleFunc("/{id}", deleteDonation).Methods("DELETE")
+	donations.HandleFunc("/{id}/acknowledge", acknowledgeDonation).Methods("POST")
+
+	// Library imports
+	api.HandleFunc("/import", importCatalog).Methods("POST")
+	api.HandleFunc("/import/goodreads", importGoodreads).Methods("POST")
+
+	// Full export, for backups and migrations
+	api.Handle("/export", requireRole(roleAdmin)(http.HandlerFunc(exportDatabase))).Methods("GET")
+
+	// Storefront. With authentication enabled, buying needs an account.
+	orders := api.PathPrefix("/orders").Subrouter()
+	orders.Use(requireRole(roleReader))
+	orders.HandleFunc("", createOrder).Methods("POST")
+	orders.HandleFunc("/{id}", getOrder).Methods("GET")
+	orders.HandleFunc("/{id}/checkout", checkoutOrder).Methods("POST")
+	orders.HandleFunc("/{id}/cancel", cancelOrder).Methods("POST")
+	api.HandleFunc("/payments/webhook", paymentWebhook).Methods("POST")
+
+	// Outgoing webhooks
+	webhooks := api.PathPrefix("/webhooks").Subrouter()
+	webhooks.Use(requireRole(roleAdmin))
+	webhooks.HandleFunc("", createWebhook).Methods("POST")
+	webhooks.HandleFunc("", getWebhooks).Methods("GET")
+	webhooks.HandleFunc("/{id}", getWebhook).Methods("GET")
+	webhooks.HandleFunc("/{id}", updateWebhook).Methods("PUT")
+	webhooks.HandleFunc("/{id}", deleteWebhook).Methods("DELETE")
+	webhooks.HandleFunc("/{id}/deliveries", getWebhookDeliveries).Methods("GET")
+
+	// Sessions
+	api.HandleFunc("/auth/login", login).Methods("POST")
+	api.HandleFunc("/auth/me", getCurrentUser).Methods("GET")
+	api.HandleFunc("/auth/saml/metadata", getSAMLMetadata).Methods("GET")
+	api.HandleFunc("/auth/saml/login", startSAMLLogin).Methods("GET")
+	api.HandleFunc("/auth/saml/acs", samlACS).Methods("POST")
+
+	// Administration
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(requireRole(roleAdmin))
+	admin.HandleFunc("/dashboard", getDashboard).Methods("GET")
+	admin.HandleFunc("/scheduled-jobs", getScheduledJobs).Methods("GET")
+	admin.HandleFunc("/scheduled-jobs/{name}/runs", getScheduledRuns).Methods("GET")
+	admin.HandleFunc("/scheduled-jobs/{name}/run", triggerScheduledJob).Methods("POST")
+	admin.HandleFunc("/orders", getOrders).Methods("GET")
+	admin.HandleFunc("/orders/{id}/fulfill", fulfillOrder).Methods("POST")
+	admin.HandleFunc("/search/reindex", reindexSearch).Methods("POST")
+	admin.HandleFunc("/metadata-refresh", createRefreshJob).Methods("POST")
+	admin.HandleFunc("/metadata-refresh", getRefreshJobs).Methods("GET")
+	admin.HandleFunc("/metadata-refresh/{id}", getRefreshJob).Methods("GET")
+	admin.HandleFunc("/metadata-refresh/{id}/conflicts", getRefreshConflicts).Methods("GET")
+	admin.HandleFunc("/metadata-refresh/{id}/resume", resumeRefreshJob).Methods("POST")
+	admin.HandleFunc("/metadata-refresh/{id}/cancel", cancelRefreshJob).Methods("POST")
+
+	// Lookups
+	api.HandleFunc("/lookup", lookupGoogleBooksISBN).Methods("GET")
+	api.HandleFunc("/lookup/barcode-image", lookupBarcodeImage).Methods("POST")
+
+	// External catalog proxies
+	api.HandleFunc("/external/google-books", searchGoogleBooks).Methods("GET")
+	api.HandleFunc("/external/sru", searchSRU).Methods("GET")
+}
+
+// Wrap the router with middleware that must run before route matching
+func newHandler() http.Handler {
+	var h http.Handler = newRouter()
+	h = requestStatsMiddleware(h)
+	if cfg.MethodOverride {
+		h = methodOverrideMiddleware(h)
+	}
+	return h
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	// Initialize database
+	initDB()
+	initMetadata()
+	initBlobStore()
+	initMailer()
+	initNotifications()
+	initEvents()
+	initCacheBus()
+	initSearchIndex()
+	initScheduler()
+	initAuth()
+	initPayments()
+	initPlugins()
+
+	// Start background workers
+	jobs = newJobQueue(cfg.JobWorkers, cfg.JobQueueSize, cfg.JobTimeout)
+	if eventPublisher != nil {
+		go runOutboxRelay(context.Background(), eventPublisher)
+	}
+	if searchIndex != nil {
+		startSearchIndexer(context.Background())
+	}
+	resumeRefreshJobs()
+	go runScheduler(context.Background())
+	go runWebhookDispatcher(context.Background())
+
+	if cfg.GRPCAddr != "none" {
+		go func() {
+			log.Fatal(listenGRPC(cfg.GRPCAddr))
+		}()
+	}
 
 	fmt.Println("Books API server starting on 0.0.0.0:8080")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080", r))
+	log.Fatal(http.ListenAndServe("0.0.0.0:8080", newHandler()))
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Members are the library's patrons. Loans and fines name their borrower
+// by membership number, the number on the member's card, and only active
+// members can borrow.
+
+// Member statuses
+const (
+	memberActive    = "active"
+	memberSuspended = "suspended"
+	memberExpired   = "expired"
+)
+
+// Member is a patron who can borrow books. Username links the card to
+// the login that owns it, so readers can act for themselves.
+type Member struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	Name             string    `json:"name" gorm:"not null"`
+	Email            string    `json:"email,omitempty"`
+	MembershipNumber string    `json:"membership_number" gorm:"uniqueIndex;not null"`
+	Status           string    `json:"status" gorm:"not null;default:active"`
+	Username         string    `json:"username,omitempty" gorm:"index"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// MemberRequest is the body of POST and PUT /members
+type MemberRequest struct {
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	MembershipNumber string `json:"membership_number"`
+	Status           string `json:"status"`
+	Username         string `json:"username"`
+}
+
+// List the members by name
+func getMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := db.Order("name, id")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var members []Member
+	if err := query.Find(&members).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list members")
+		return
+	}
+	writeList(w, r, members)
+}
+
+func validateMember(req *MemberRequest) []FieldError {
+	var errs []FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" {
+		if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+			errs = append(errs, FieldError{Field: "email", Message: "must be an email address"})
+		}
+	}
+	req.MembershipNumber = strings.TrimSpace(req.MembershipNumber)
+	if req.MembershipNumber == "" {
+		errs = append(errs, FieldError{Field: "membership_number", Message: "is required"})
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+	switch req.Status {
+	case "":
+		req.Status = memberActive
+	case memberActive, memberSuspended, memberExpired:
+	default:
+		errs = append(errs, FieldError{Field: "status", Message: "must be active, suspended or expired"})
+	}
+	return errs
+}
+
+// Whether another member already has the membership number
+func membershipNumberTaken(number string, id uint) bool {
+	var count int64
+	db.Model(&Member{}).Where("membership_number = ? AND id <> ?", number, id).Count(&count)
+	return count > 0
+}
+
+// Whether another member's card belongs to the login
+func memberUsernameTaken(username string, id uint) bool {
+	if username == "" {
+		return false
+	}
+	var count int64
+	db.Model(&Member{}).Where("username = ? AND id <> ?", username, id).Count(&count)
+	return count > 0
+}
+
+// Whether the request may act for the member: staff for anyone, and a
+// reader for the member whose card their login owns. Without
+// authentication, anyone may.
+func canActForMember(r *http.Request, member *Member) bool {
+	if !authEnabled() {
+		return true
+	}
+	p := principalFrom(r)
+	return p != nil && (p.HasRole(roleLibrarian) || (member.Username != "" && p.Username == member.Username))
+}
+
+// Add a member
+func createMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req MemberRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateMember(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if membershipNumberTaken(req.MembershipNumber, 0) {
+		writeError(w, r, http.StatusConflict, "member_exists", "A member with this membership number already exists")
+		return
+	}
+	if memberUsernameTaken(req.Username, 0) {
+		writeError(w, r, http.StatusConflict, "member_exists", "A member with this username already exists")
+		return
+	}
+	member := Member{Name: req.Name, Email: req.Email, MembershipNumber: req.MembershipNumber, Status: req.Status, Username: req.Username}
+	if err := db.Create(&member).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create member")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(member.ID), 10))
+	writeJSON(w, http.StatusCreated, member)
+}
+
+// Load the member named in the URL
+func loadMember(w http.ResponseWriter, r *http.Request) (*Member, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_member_id", "Invalid member ID")
+		return nil, false
+	}
+	var member Member
+	if err := db.First(&member, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "member_not_found", "Member not found")
+		return nil, false
+	}
+	return &member, true
+}
+
+// Get a member by ID
+func getMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if member, ok := loadMember(w, r); ok {
+		writeJSON(w, http.StatusOK, member)
+	}
+}
+
+// List a member's loans, open ones first, then the most recent
+func getMemberLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	member, ok := loadMember(w, r)
+	if !ok {
+		return
+	}
+	var loans []Loan
+	err := db.Where("borrower = ?", member.MembershipNumber).
+		Order("returned_at IS NOT NULL, checked_out_at DESC, id DESC").
+		Find(&loans).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list loans")
+		return
+	}
+	writeList(w, r, loans)
+}
+
+// Replace a member's details. A new membership number carries over to the
+// member's loans and fines.
+func updateMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	member, ok := loadMember(w, r)
+	if !ok {
+		return
+	}
+	var req MemberRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateMember(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if membershipNumberTaken(req.MembershipNumber, member.ID) {
+		writeError(w, r, http.StatusConflict, "member_exists", "A member with this membership number already exists")
+		return
+	}
+	if memberUsernameTaken(req.Username, member.ID) {
+		writeError(w, r, http.StatusConflict, "member_exists", "A member with this username already exists")
+		return
+	}
+	old := member.MembershipNumber
+	member.Name, member.Email, member.MembershipNumber, member.Status, member.Username = req.Name, req.Email, req.MembershipNumber, req.Status, req.Username
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("Name", "Email", "MembershipNumber", "Status", "Username", "UpdatedAt").Updates(member).Error; err != nil {
+			return err
+		}
+		if old == member.MembershipNumber {
+			return nil
+		}
+		if err := tx.Model(&Loan{}).Where("borrower = ?", old).Update("borrower", member.MembershipNumber).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Fine{}).Where("borrower = ?", old).Update("borrower", member.MembershipNumber).Error
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update member")
+		return
+	}
+	writeJSON(w, http.StatusOK, member)
+}
+
+// Errors that stop a member being deleted
+var (
+	errMemberHasLoans = errors.New("member has open loans")
+	errMemberOwes     = errors.New("member owes fines")
+)
+
+// Delete a member with no books out and no fines outstanding
+func deleteMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	member, ok := loadMember(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Loan{}).Where("borrower = ? AND returned_at IS NULL", member.MembershipNumber).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errMemberHasLoans
+		}
+		if err := tx.Model(&Fine{}).Where("borrower = ? AND paid_cents < amount_cents", member.MembershipNumber).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errMemberOwes
+		}
+		return tx.Delete(&Member{}, member.ID).Error
+	})
+	switch {
+	case errors.Is(err, errMemberHasLoans):
+		writeError(w, r, http.StatusConflict, "member_has_loans", "The member still has books on loan")
+	case errors.Is(err, errMemberOwes):
+		writeError(w, r, http.StatusConflict, "member_owes_fines", "The member still owes fines")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete member")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Find the member a loan is made to, refusing numbers that aren't an
+// active member's
+func borrowingMember(number string) (*Member, error) {
+	var member Member
+	err := db.Where("membership_number = ?", number).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, RejectField("borrower", "is not a membership number")
+	} else if err != nil {
+		return nil, err
+	}
+	if member.Status != memberActive {
+		return nil, &HookError{Status: http.StatusConflict, Code: "member_inactive", Message: "The membership is " + member.Status}
+	}
+	return &member, nil
+}
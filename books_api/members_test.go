@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Add an active member with the membership number
+func addMember(t *testing.T, number string) Member {
+	t.Helper()
+	member := Member{Name: "Member " + number, MembershipNumber: number, Status: memberActive}
+	if err := db.Create(&member).Error; err != nil {
+		t.Fatalf("Failed to add member %s: %v", number, err)
+	}
+	return member
+}
+
+func TestMemberCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/members", `{"name":"Ada Lovelace","email":"ada@example.com","membership_number":" M-0001 ","username":"ada"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Member
+	json.Unmarshal(response.Body.Bytes(), &created)
+	path := fmt.Sprintf("/api/v1/members/%d", created.ID)
+	if loc := response.Header().Get("Location"); loc != path {
+		t.Errorf("Expected Location %s, got %s", path, loc)
+	}
+	if created.MembershipNumber != "M-0001" || created.Status != memberActive || created.Username != "ada" {
+		t.Errorf("Expected the number trimmed and the member active, got %+v", created)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/members", `{"name":"Someone","membership_number":"M-0001"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate membership number, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/members", `{"name":"Someone","membership_number":"M-0009","username":"ada"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a card already linked to the login, got %d", response.Code)
+	}
+	for _, body := range []string{
+		`{"membership_number":"M-0002"}`,
+		`{"name":"Bob"}`,
+		`{"name":"Bob","membership_number":"M-0002","email":"not an email"}`,
+		`{"name":"Bob","membership_number":"M-0002","status":"banned"}`,
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/members", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+
+	response = webhookRequest(t, router, "PUT", path, `{"name":"Ada King","membership_number":"M-0001","status":"Suspended"}`)
+	var updated Member
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.Name != "Ada King" || updated.Email != "" || updated.Status != memberSuspended {
+		t.Errorf("Expected the member replaced, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", "/api/v1/members?status=suspended", "")
+	var members []Member
+	json.Unmarshal(response.Body.Bytes(), &members)
+	if len(members) != 1 || members[0].ID != created.ID {
+		t.Errorf("Expected the suspended member, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/members/abc", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestLoansBelongToMembers(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	member := addMember(t, "card-1001")
+	suspended := addMember(t, "card-2002")
+	db.Model(&suspended).Update("status", memberSuspended)
+	checkout := fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID)
+
+	response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-9999"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "borrower") {
+		t.Errorf("Expected status 400 for an unknown member, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-2002"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a suspended member, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", checkout, `{"borrower":"card-1001"}`); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// Members with books out can't be deleted
+	path := fmt.Sprintf("/api/v1/members/%d", member.ID)
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 with a book on loan, got %d", response.Code)
+	}
+
+	// A new membership number follows the member's loans
+	webhookRequest(t, router, "PUT", path, `{"name":"Ada","membership_number":"card-3003"}`)
+	response = webhookRequest(t, router, "GET", path+"/loans", "")
+	var loans []Loan
+	json.Unmarshal(response.Body.Bytes(), &loans)
+	if len(loans) != 1 || loans[0].Borrower != "card-3003" || loans[0].BookID != dune.ID {
+		t.Errorf("Expected the member's loan, got %s", response.Body.String())
+	}
+
+	// Nor can members who owe fines
+	db.Model(&Loan{}).Where("id = ?", loans[0].ID).Update("returned_at", time.Now())
+	db.Create(&Fine{LoanID: loans[0].ID, Borrower: "card-3003", AmountCents: 50})
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 with a fine owed, got %d", response.Code)
+	}
+}
+
+func TestMembersNeedLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"reader:pw": {Username: "reader", Roles: []string{roleReader}}})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/members", nil)
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, router, "reader", "pw"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a reader, got %d", response.Code)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BookMetadata is bibliographic data returned by an external provider
+type BookMetadata struct {
+	Title       string
+	Author      string
+	Year        int
+	Description string
+	CoverURL    string
+	Publisher   string
+}
+
+// MetadataProvider looks up book metadata by ISBN
+type MetadataProvider interface {
+	Name() string
+	LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error)
+}
+
+// Returned when the provider has no record for an ISBN
+var ErrMetadataNotFound = errors.New("metadata not found")
+
+// RateLimitError is returned while a provider is throttling us
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Provider, e.RetryAfter)
+}
+
+// Configured provider, nil when enrichment is disabled
+var metadataProvider MetadataProvider
+
+// Initialize the metadata provider from configuration
+func initMetadata() {
+	googleBooks = newGoogleBooksClient(cfg.GoogleBooksURL, cfg.GoogleBooksAPIKey, cfg.MetadataTimeout)
+	sruCatalog = newSRUClient(cfg.SRUURL, cfg.SRUISBNIndex, cfg.SRUTitleIndex, cfg.MetadataTimeout)
+
+	var p MetadataProvider
+	switch cfg.MetadataProvider {
+	case "", "none":
+		return
+	case "openlibrary":
+		p = newOpenLibraryProvider(cfg.OpenLibraryURL, cfg.MetadataTimeout)
+	case "googlebooks":
+		p = googleBooks
+	case "sru":
+		p = sruCatalog
+	default:
+		log.Printf("Unknown METADATA_PROVIDER %q, enrichment disabled", cfg.MetadataProvider)
+		return
+	}
+	metadataProvider = newCachedProvider(p, cfg.MetadataCacheTTL)
+}
+
+// Strip the separators people commonly type into ISBNs
+func cleanISBN(isbn string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(isbn)
+}
+
+type cacheEntry struct {
+	meta    *BookMetadata
+	err     error
+	expires time.Time
+}
+
+// cachedProvider memoizes lookups, including misses, for a fixed TTL
+type cachedProvider struct {
+	next MetadataProvider
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCachedProvider(next MetadataProvider, ttl time.Duration) *cachedProvider {
+	return &cachedProvider{next: next, ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *cachedProvider) Name() string {
+	return c.next.Name()
+}
+
+func (c *cachedProvider) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	isbn = cleanISBN(isbn)
+
+	c.mu.Lock()
+	entry, ok := c.entries[isbn]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.meta, entry.err
+	}
+
+	meta, err := c.next.LookupISBN(ctx, isbn)
+	if err != nil && !errors.Is(err, ErrMetadataNotFound) {
+		// Don't cache transient failures
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[isbn] = cacheEntry{meta: meta, err: err, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return meta, err
+}
+
+// Copy metadata onto a book. Without overwrite only empty fields are
+// filled in. Reports whether anything changed.
+func applyMetadata(book *Book, meta *BookMetadata, overwrite bool) bool {
+	changed := false
+	set := func(dst *string, src string) {
+		if src != "" && src != *dst && (overwrite || *dst == "") {
+			*dst = src
+			changed = true
+		}
+	}
+
+	set(&book.Title, meta.Title)
+	set(&book.Author, meta.Author)
+	set(&book.Description, meta.Description)
+	set(&book.CoverURL, meta.CoverURL)
+
+	min, max := cfg.yearRange()
+	if meta.Year >= min && meta.Year <= max && meta.Year != book.Year && (overwrite || book.Year == 0) {
+		book.Year = meta.Year
+		changed = true
+	}
+
+	return changed
+}
+
+// Look up and apply metadata for a book using the configured provider
+func enrichBook(ctx context.Context, book *Book, overwrite bool) (bool, error) {
+	if metadataProvider == nil {
+		return false, errors.New("metadata enrichment is not configured")
+	}
+	meta, err := metadataProvider.LookupISBN(ctx, book.ISBN)
+	if err != nil {
+		return false, err
+	}
+	changed := applyMetadata(book, meta, overwrite)
+	if linked, err := applyPublisher(book, meta.Publisher, overwrite); err != nil {
+		// The rest of the metadata is still worth having
+		log.Printf("Linking publisher %q for ISBN %s failed: %v", meta.Publisher, book.ISBN, err)
+	} else if linked {
+		changed = true
+	}
+	return changed, nil
+}
+
+// Link a book to the publisher named in its metadata, adding the
+// publisher to the catalog the first time it is seen. Without overwrite
+// only a book with no publisher is linked. Reports whether the link
+// changed.
+func applyPublisher(book *Book, name string, overwrite bool) (bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || (book.PublisherID != nil && !overwrite) {
+		return false, nil
+	}
+	var publisher Publisher
+	if err := db.Where(Publisher{Name: name}).FirstOrCreate(&publisher).Error; err != nil {
+		return false, err
+	}
+	if book.PublisherID != nil && *book.PublisherID == publisher.ID {
+		return false, nil
+	}
+	book.PublisherID = &publisher.ID
+	return true, nil
+}
+
+// Write the response for a failed provider lookup
+func writeMetadataError(w http.ResponseWriter, r *http.Request, err error) {
+	var rle *RateLimitError
+	switch {
+	case errors.As(err, &rle):
+		w.Header().Set("Retry-After", strconv.Itoa(int(rle.RetryAfter.Seconds()+0.5)))
+		writeError(w, r, http.StatusServiceUnavailable, "rate_limited", "Metadata provider rate limit exceeded")
+	case errors.Is(err, ErrMetadataNotFound):
+		writeError(w, r, http.StatusNotFound, "metadata_not_found", "No metadata found")
+	default:
+		log.Printf("Metadata lookup failed: %v", err)
+		writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Metadata provider unavailable")
+	}
+}
+
+// Enrich a stored book from the metadata provider
+func enrichBookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if metadataProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Metadata enrichment is not configured")
+		return
+	}
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	changed, err := enrichBook(r.Context(), &book, overwrite)
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+
+	if changed {
+		if errs := validateBook(&book); len(errs) > 0 {
+			writeValidationErrors(w, r, errs)
+			return
+		}
+		db.WithContext(r.Context()).Save(&book)
+	}
+	writeJSON(w, http.StatusOK, book)
+}
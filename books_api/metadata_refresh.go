@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Refresh job states
+const (
+	refreshQueued    = "queued"
+	refreshRunning   = "running"
+	refreshCompleted = "completed"
+	refreshFailed    = "failed"
+	refreshCancelled = "cancelled"
+)
+
+// Conflict rules, applied per field
+const (
+	// Only fill empty fields; a differing value is recorded as a conflict
+	ruleFill = "fill"
+	// Replace the stored value whenever the provider has one
+	ruleOverwrite = "overwrite"
+	// Never change the field; a differing value is recorded as a conflict
+	ruleKeep = "keep"
+)
+
+// Fields a refresh can change
+var refreshFields = []string{"title", "author", "year", "description", "cover_url"}
+
+// Books a refresh job covers. Empty fields match everything.
+type RefreshFilter struct {
+	IDs     []uint `json:"ids,omitempty"`
+	Author  string `json:"author,omitempty"`
+	YearMin int    `json:"year_min,omitempty"`
+	YearMax int    `json:"year_max,omitempty"`
+	// Only books missing a description, cover or year
+	MissingOnly bool `json:"missing_only,omitempty"`
+}
+
+// RefreshJobRequest is the optional body of POST /admin/metadata-refresh:
+// the books to cover and, by field, fill, overwrite or keep
+type RefreshJobRequest struct {
+	Filter RefreshFilter     `json:"filter"`
+	Rules  map[string]string `json:"rules"`
+}
+
+// RefreshJob re-enriches a set of books from the metadata provider. Its
+// checkpoint (LastBookID) lets an interrupted job resume where it stopped.
+type RefreshJob struct {
+	ID         uint              `json:"id" gorm:"primaryKey"`
+	Status     string            `json:"status" gorm:"index"`
+	Filter     RefreshFilter     `json:"filter" gorm:"serializer:json"`
+	Rules      map[string]string `json:"rules" gorm:"serializer:json"`
+	Total      int               `json:"total"`
+	Processed  int               `json:"processed"`
+	Updated    int               `json:"updated"`
+	Unchanged  int               `json:"unchanged"`
+	NotFound   int               `json:"not_found"`
+	Failed     int               `json:"failed"`
+	Conflicts  int               `json:"conflicts"`
+	LastBookID uint              `json:"last_book_id"`
+	Error      string            `json:"error,omitempty"`
+	Progress   float64           `json:"progress" gorm:"-"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+}
+
+// RefreshConflict is a provider value a rule declined to apply
+type RefreshConflict struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	JobID    uint   `json:"job_id" gorm:"index"`
+	BookID   uint   `json:"book_id"`
+	Field    string `json:"field"`
+	Current  string `json:"current"`
+	Proposed string `json:"proposed"`
+}
+
+func (j *RefreshJob) AfterFind(tx *gorm.DB) error {
+	if j.Total > 0 {
+		j.Progress = float64(j.Processed) / float64(j.Total)
+	} else if j.Status == refreshCompleted {
+		j.Progress = 1
+	}
+	return nil
+}
+
+// The one job allowed to run at a time
+var refreshRunner struct {
+	sync.Mutex
+	jobID  uint
+	cancel context.CancelFunc
+}
+
+// Apply provider metadata to book under rules. Returns whether the book
+// changed and the conflicts it declined to resolve.
+func resolveMetadata(book *Book, meta *BookMetadata, rules map[string]string) (bool, []RefreshConflict) {
+	changed := false
+	var conflicts []RefreshConflict
+	resolve := func(field, current, proposed string, set func()) {
+		if proposed == "" || proposed == current {
+			return
+		}
+		rule := rules[field]
+		if rule == ruleOverwrite || (rule != ruleKeep && (current == "" || current == "0")) {
+			set()
+			changed = true
+			return
+		}
+		conflicts = append(conflicts, RefreshConflict{BookID: book.ID, Field: field, Current: current, Proposed: proposed})
+	}
+
+	resolve("title", book.Title, meta.Title, func() { book.Title = meta.Title })
+	resolve("author", book.Author, meta.Author, func() { book.Author = meta.Author })
+	resolve("description", book.Description, meta.Description, func() { book.Description = meta.Description })
+	if min, max := cfg.yearRange(); meta.Year >= min && meta.Year <= max {
+		resolve("year", strconv.Itoa(book.Year), strconv.Itoa(meta.Year), func() { book.Year = meta.Year })
+	}
+	// An uploaded cover always wins over an external link
+	if book.CoverKey == "" {
+		resolve("cover_url", book.CoverURL, meta.CoverURL, func() { book.CoverURL = meta.CoverURL })
+	}
+	return changed, conflicts
+}
+
+// Books matching the job's filter
+func refreshScope(f RefreshFilter) *gorm.DB {
+	q := db.Model(&Book{})
+	if len(f.IDs) > 0 {
+		q = q.Where("id IN ?", f.IDs)
+	}
+	if f.Author != "" {
+		q = q.Where("LOWER(author) LIKE LOWER(?)", "%"+f.Author+"%")
+	}
+	if f.YearMin != 0 {
+		q = q.Where("year >= ?", f.YearMin)
+	}
+	if f.YearMax != 0 {
+		q = q.Where("year <= ?", f.YearMax)
+	}
+	if f.MissingOnly {
+		q = q.Where("description = '' OR description IS NULL OR cover_url = '' OR cover_url IS NULL OR year = 0")
+	}
+	return q
+}
+
+// Start running a job in the background, unless another is running
+func startRefreshJob(job *RefreshJob) error {
+	refreshRunner.Lock()
+	defer refreshRunner.Unlock()
+	if refreshRunner.cancel != nil {
+		return fmt.Errorf("metadata refresh %d is already running", refreshRunner.jobID)
+	}
+
+	now := time.Now()
+	job.Status = refreshRunning
+	job.Error = ""
+	job.FinishedAt = nil
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	db.Save(job)
+
+	// The runner works on its own copy so callers can keep using job
+	run := *job
+	ctx, cancel := context.WithCancel(context.Background())
+	refreshRunner.jobID, refreshRunner.cancel = run.ID, cancel
+	go func() {
+		err := runRefreshJob(ctx, &run)
+		refreshRunner.Lock()
+		refreshRunner.jobID, refreshRunner.cancel = 0, nil
+		refreshRunner.Unlock()
+		cancel()
+
+		finished := time.Now()
+		run.FinishedAt = &finished
+		switch {
+		case errors.Is(err, context.Canceled):
+			run.Status = refreshCancelled
+		case err != nil:
+			run.Status = refreshFailed
+			run.Error = err.Error()
+			log.Printf("Metadata refresh %d failed: %v", run.ID, err)
+			notifyJobFailure(fmt.Sprintf("metadata refresh %d", run.ID), err)
+		default:
+			run.Status = refreshCompleted
+		}
+		db.Save(&run)
+	}()
+	return nil
+}
+
+// Work through the job's books from its checkpoint, saving progress after
+// every book
+func runRefreshJob(ctx context.Context, job *RefreshJob) error {
+	if metadataProvider == nil {
+		return errors.New("metadata enrichment is not configured")
+	}
+	for {
+		var books []Book
+		err := refreshScope(job.Filter).Where("id > ?", job.LastBookID).Order("id").Limit(50).Find(&books).Error
+		if err != nil {
+			return err
+		}
+		if len(books) == 0 {
+			return nil
+		}
+
+		for i := range books {
+			if err := refreshBook(ctx, job, &books[i]); err != nil {
+				return err
+			}
+			job.Processed++
+			job.LastBookID = books[i].ID
+			db.Save(job)
+
+			if cfg.MetadataRefreshDelay > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(cfg.MetadataRefreshDelay):
+				}
+			}
+		}
+	}
+}
+
+// Refresh one book. Only cancellation and database failures stop the job;
+// lookup problems are counted against the book and the job moves on.
+func refreshBook(ctx context.Context, job *RefreshJob, book *Book) error {
+	meta, err := metadataProvider.LookupISBN(ctx, book.ISBN)
+	for attempt := 0; attempt < 3; attempt++ {
+		var rle *RateLimitError
+		if !errors.As(err, &rle) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rle.RetryAfter):
+		}
+		meta, err = metadataProvider.LookupISBN(ctx, book.ISBN)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrMetadataNotFound) {
+		job.NotFound++
+		return nil
+	}
+	if err != nil {
+		log.Printf("Metadata refresh %d: book %d: %v", job.ID, book.ID, err)
+		job.Failed++
+		return nil
+	}
+
+	changed, conflicts := resolveMetadata(book, meta, job.Rules)
+	if changed && len(validateBook(book)) > 0 {
+		job.Failed++
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if changed {
+			if err := tx.Save(book).Error; err != nil {
+				return err
+			}
+			job.Updated++
+		} else {
+			job.Unchanged++
+		}
+		for i := range conflicts {
+			conflicts[i].JobID = job.ID
+		}
+		if len(conflicts) > 0 {
+			job.Conflicts += len(conflicts)
+			return tx.Create(&conflicts).Error
+		}
+		return nil
+	})
+}
+
+// Pick up jobs that were running when the process stopped
+func resumeRefreshJobs() {
+	var job RefreshJob
+	if err := db.Where("status = ?", refreshRunning).Order("id").First(&job).Error; err != nil {
+		return
+	}
+	log.Printf("Resuming metadata refresh %d after book %d", job.ID, job.LastBookID)
+	if err := startRefreshJob(&job); err != nil {
+		log.Printf("Resuming metadata refresh failed: %v", err)
+	}
+}
+
+// Load the job named by the {id} route variable, writing an error on failure
+func loadRefreshJob(w http.ResponseWriter, r *http.Request) (*RefreshJob, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_job_id", "Invalid job ID")
+		return nil, false
+	}
+	var job RefreshJob
+	if err := db.First(&job, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return nil, false
+	}
+	return &job, true
+}
+
+// Start a metadata refresh over all or some books
+func createRefreshJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if metadataProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Metadata enrichment is not configured")
+		return
+	}
+
+	var body RefreshJobRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+			return
+		}
+	}
+
+	rules := make(map[string]string, len(refreshFields))
+	for _, f := range refreshFields {
+		rules[f] = ruleFill
+	}
+	var errs []FieldError
+	for field, rule := range body.Rules {
+		if _, ok := rules[field]; !ok {
+			errs = append(errs, FieldError{Field: "rules." + field, Message: "is not a refreshable field"})
+			continue
+		}
+		if rule != ruleFill && rule != ruleOverwrite && rule != ruleKeep {
+			errs = append(errs, FieldError{Field: "rules." + field, Message: "must be fill, overwrite or keep"})
+			continue
+		}
+		rules[field] = rule
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	job := RefreshJob{Status: refreshQueued, Filter: body.Filter, Rules: rules}
+	var total int64
+	refreshScope(job.Filter).Count(&total)
+	job.Total = int(total)
+	db.Create(&job)
+
+	if err := startRefreshJob(&job); err != nil {
+		db.Delete(&job)
+		writeError(w, r, http.StatusConflict, "job_conflict", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// List refresh jobs, newest first
+func getRefreshJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var list []RefreshJob
+	db.Order("id DESC").Limit(50).Find(&list)
+	writeList(w, r, list)
+}
+
+// Get a refresh job with its progress
+func getRefreshJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := loadRefreshJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// List the conflicts a refresh job left for review
+func getRefreshConflicts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := loadRefreshJob(w, r)
+	if !ok {
+		return
+	}
+	var conflicts []RefreshConflict
+	db.Where("job_id = ?", job.ID).Order("book_id, field").Find(&conflicts)
+	writeList(w, r, conflicts)
+}
+
+// Continue a failed or cancelled job from its checkpoint
+func resumeRefreshJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := loadRefreshJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != refreshFailed && job.Status != refreshCancelled {
+		writeError(w, r, http.StatusConflict, "job_conflict", "Only failed or cancelled jobs can be resumed")
+		return
+	}
+	if metadataProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Metadata enrichment is not configured")
+		return
+	}
+	if err := startRefreshJob(job); err != nil {
+		writeError(w, r, http.StatusConflict, "job_conflict", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Stop a running job; it can be resumed later
+func cancelRefreshJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := loadRefreshJob(w, r)
+	if !ok {
+		return
+	}
+	refreshRunner.Lock()
+	defer refreshRunner.Unlock()
+	if refreshRunner.jobID != job.ID || refreshRunner.cancel == nil {
+		writeError(w, r, http.StatusConflict, "job_conflict", "Job is not running")
+		return
+	}
+	refreshRunner.cancel()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Run refresh jobs without pausing between lookups
+func noRefreshDelay(t *testing.T) {
+	saved := cfg.MetadataRefreshDelay
+	cfg.MetadataRefreshDelay = 0
+	t.Cleanup(func() { cfg.MetadataRefreshDelay = saved })
+}
+
+// Wait for a refresh job to stop running and return it
+func waitForRefresh(t *testing.T, id uint) RefreshJob {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var job RefreshJob
+		db.First(&job, id)
+		if job.Status != refreshRunning && job.Status != refreshQueued {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Refresh job %d still %s", id, job.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResolveMetadataRules(t *testing.T) {
+	book := &Book{ID: 1, Title: "Dune (pb)", Author: "", Year: 1965}
+	meta := &BookMetadata{Title: "Dune", Author: "Frank Herbert", Year: 1966, Description: "Desert planet"}
+
+	changed, conflicts := resolveMetadata(book, meta, map[string]string{"title": ruleKeep, "year": ruleFill, "description": ruleOverwrite})
+	if !changed || book.Author != "Frank Herbert" || book.Description != "Desert planet" {
+		t.Errorf("Expected empty fields to be filled, got %+v", book)
+	}
+	if book.Title != "Dune (pb)" || book.Year != 1965 {
+		t.Errorf("Expected kept and filled-only fields to stay, got %+v", book)
+	}
+	if len(conflicts) != 2 || conflicts[0].Field != "title" || conflicts[1].Proposed != "1966" {
+		t.Errorf("Unexpected conflicts %+v", conflicts)
+	}
+
+	_, conflicts = resolveMetadata(book, meta, map[string]string{"title": ruleOverwrite, "year": ruleOverwrite})
+	if book.Title != "Dune" || book.Year != 1966 || len(conflicts) != 0 {
+		t.Errorf("Expected overwrite to replace values, got %+v %+v", book, conflicts)
+	}
+}
+
+func TestMetadataRefreshJob(t *testing.T) {
+	clearDB()
+	noRefreshDelay(t)
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780441013593": {Title: "Dune", Author: "Frank Herbert", Year: 1965, Description: "Desert planet"},
+		"9780441569595": {Title: "Neuromancer", Author: "William Gibson", Year: 1984},
+	}})
+	router := setupRouter()
+	db.Create(&Book{Title: "DUNE", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984})
+	db.Create(&Book{Title: "Unknown", Author: "Nobody", ISBN: "9780000000002"})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815, Description: "Matchmaking", CoverURL: "https://example.com/emma.jpg"})
+
+	body := []byte(`{"filter":{"missing_only":true},"rules":{"year":"overwrite"}}`)
+	req, _ := http.NewRequest("POST", "/api/v1/admin/metadata-refresh", bytes.NewBuffer(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", response.Code, response.Body.String())
+	}
+	var started RefreshJob
+	json.Unmarshal(response.Body.Bytes(), &started)
+
+	job := waitForRefresh(t, started.ID)
+	if job.Status != refreshCompleted || job.Total != 3 || job.Processed != 3 {
+		t.Fatalf("Unexpected job %+v", job)
+	}
+	if job.Updated != 1 || job.Unchanged != 1 || job.NotFound != 1 || job.Conflicts != 1 || job.Progress != 1 {
+		t.Errorf("Unexpected counts %+v", job)
+	}
+
+	var dune Book
+	db.First(&dune, 1)
+	if dune.Title != "DUNE" || dune.Year != 1965 || dune.Description != "Desert planet" {
+		t.Errorf("Unexpected refreshed book %+v", dune)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/v1/admin/metadata-refresh/%d/conflicts", job.ID), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var conflicts []RefreshConflict
+	json.Unmarshal(response.Body.Bytes(), &conflicts)
+	if len(conflicts) != 1 || conflicts[0].Field != "title" || conflicts[0].Current != "DUNE" || conflicts[0].Proposed != "Dune" {
+		t.Errorf("Unexpected conflicts %+v", conflicts)
+	}
+}
+
+func TestMetadataRefreshResumesFromCheckpoint(t *testing.T) {
+	clearDB()
+	noRefreshDelay(t)
+	provider := &fakeProvider{books: map[string]*BookMetadata{
+		"9780441013593": {Description: "Desert planet"},
+		"9780441569595": {Description: "Cyberspace"},
+	}}
+	useProvider(t, provider)
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"})
+
+	// A job that failed after the first book
+	job := RefreshJob{Status: refreshFailed, Rules: map[string]string{}, Total: 2, Processed: 1, LastBookID: 1}
+	db.Create(&job)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/admin/metadata-refresh/%d/resume", job.ID), nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", response.Code, response.Body.String())
+	}
+
+	job = waitForRefresh(t, job.ID)
+	if job.Status != refreshCompleted || job.Processed != 2 || provider.calls != 1 {
+		t.Errorf("Expected only the remaining book to be refreshed, got %+v after %d lookups", job, provider.calls)
+	}
+
+	// Completed jobs can't be resumed or cancelled
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/v1/admin/metadata-refresh/%d/resume", job.ID), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", response.Code)
+	}
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/v1/admin/metadata-refresh/%d/cancel", job.ID), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", response.Code)
+	}
+}
+
+func TestMetadataRefreshValidatesRules(t *testing.T) {
+	useProvider(t, &fakeProvider{})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/admin/metadata-refresh", bytes.NewBufferString(`{"rules":{"isbn":"overwrite","title":"replace"}}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves canned metadata and counts lookups
+type fakeProvider struct {
+	books map[string]*BookMetadata
+	err   error
+	calls int
+}
+
+func (f *fakeProvider) Name() string { return "fake" }
+
+func (f *fakeProvider) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	meta, ok := f.books[isbn]
+	if !ok {
+		return nil, ErrMetadataNotFound
+	}
+	return meta, nil
+}
+
+func useProvider(t *testing.T, p MetadataProvider) {
+	saved := metadataProvider
+	metadataProvider = p
+	t.Cleanup(func() { metadataProvider = saved })
+}
+
+func TestCachedProviderMemoizesHitsAndMisses(t *testing.T) {
+	fake := &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code"},
+	}}
+	c := newCachedProvider(fake, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		c.LookupISBN(context.Background(), "978-0-13-235088-4")
+		c.LookupISBN(context.Background(), "9780000000000")
+	}
+	if fake.calls != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", fake.calls)
+	}
+}
+
+func TestCachedProviderSkipsTransientErrors(t *testing.T) {
+	fake := &fakeProvider{err: &RateLimitError{Provider: "fake", RetryAfter: time.Second}}
+	c := newCachedProvider(fake, time.Hour)
+
+	c.LookupISBN(context.Background(), "9780132350884")
+	c.LookupISBN(context.Background(), "9780132350884")
+	if fake.calls != 2 {
+		t.Errorf("Expected rate-limited lookups not to be cached, got %d calls", fake.calls)
+	}
+}
+
+func TestEnrichBookFillsMissingFields(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code", Author: "Robert C. Martin", Year: 2008, Description: "A handbook", CoverURL: "https://covers.example/l.jpg", Publisher: "Prentice Hall"},
+	}})
+
+	book := Book{Title: "My Title", Author: "Uncle Bob", ISBN: "9780132350884"}
+	db.Create(&book)
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/1/enrich", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+
+	var stored Book
+	db.First(&stored, 1)
+	if stored.Title != "My Title" || stored.Author != "Uncle Bob" {
+		t.Errorf("Expected existing title/author to be kept, got %q by %q", stored.Title, stored.Author)
+	}
+	if stored.Year != 2008 || stored.Description != "A handbook" || stored.CoverURL == "" {
+		t.Errorf("Expected missing fields to be filled, got %+v", stored)
+	}
+	var publisher Publisher
+	if stored.PublisherID == nil || db.First(&publisher, *stored.PublisherID).Error != nil || publisher.Name != "Prentice Hall" {
+		t.Errorf("Expected the book linked to its publisher, got %v", stored.PublisherID)
+	}
+
+	// A book with a publisher keeps it
+	other := Publisher{Name: "Pearson"}
+	db.Create(&other)
+	db.Model(&stored).Update("publisher_id", other.ID)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	db.First(&stored, 1)
+	if stored.PublisherID == nil || *stored.PublisherID != other.ID {
+		t.Errorf("Expected the existing publisher kept, got %v", stored.PublisherID)
+	}
+}
+
+func TestEnrichBookOverwrite(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code", Author: "Robert C. Martin"},
+	}})
+
+	book := Book{Title: "My Title", Author: "Uncle Bob", ISBN: "9780132350884"}
+	db.Create(&book)
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/1/enrich?overwrite=true", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	var enriched Book
+	json.Unmarshal(response.Body.Bytes(), &enriched)
+	if enriched.Title != "Clean Code" || enriched.Author != "Robert C. Martin" {
+		t.Errorf("Expected fields to be overwritten, got %q by %q", enriched.Title, enriched.Author)
+	}
+}
+
+func TestEnrichBookErrors(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "T", Author: "A", ISBN: "9780000000000"})
+
+	cases := []struct {
+		name     string
+		provider MetadataProvider
+		want     int
+	}{
+		{"disabled", nil, http.StatusNotImplemented},
+		{"not found", &fakeProvider{}, http.StatusNotFound},
+		{"rate limited", &fakeProvider{err: &RateLimitError{Provider: "fake", RetryAfter: 10 * time.Second}}, http.StatusServiceUnavailable},
+	}
+
+	for _, c := range cases {
+		useProvider(t, c.provider)
+		req, _ := http.NewRequest("POST", "/api/v1/books/1/enrich", nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != c.want {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.want, response.Code)
+		}
+	}
+}
+
+func TestCreateBookAutoEnrich(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	useProvider(t, &fakeProvider{books: map[string]*BookMetadata{
+		"9780132350884": {Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	}})
+
+	jsonData, _ := json.Marshal(Book{ISBN: "9780132350884"})
+	req, _ := http.NewRequest("POST", "/api/v1/books?enrich=true", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var created Book
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.Title != "Clean Code" || created.Year != 2008 {
+		t.Errorf("Expected enriched book, got %+v", created)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Header used by clients behind proxies that only pass GET and POST
+const methodOverrideHeader = "X-HTTP-Method-Override"
+
+// Methods a POST may be rewritten to
+var overridableMethods = map[string]bool{
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// Method override middleware. It must wrap the router rather than be
+// registered with r.Use, since mux matches routes on the original method.
+func methodOverrideMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		override := r.Header.Get(methodOverrideHeader)
+		if override == "" && isFormRequest(r) {
+			override = r.PostFormValue("_method")
+		}
+		if override == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := strings.ToUpper(strings.TrimSpace(override))
+		if !overridableMethods[method] {
+			writeError(w, r, http.StatusBadRequest, "invalid_method_override", "Unsupported method override")
+			return
+		}
+
+		log.Printf("Method override: POST %s as %s from %s", r.URL.Path, method, r.RemoteAddr)
+		r.Method = method
+		r.Header.Del(methodOverrideHeader)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Report whether the request body is an HTML form submission
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
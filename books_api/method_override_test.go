@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Honor method overrides for one test
+func useMethodOverride(t *testing.T) {
+	saved := cfg.MethodOverride
+	cfg.MethodOverride = true
+	t.Cleanup(func() { cfg.MethodOverride = saved })
+}
+
+func TestMethodOverrideOffByDefault(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	book := Book{Title: "Keep Me", Author: "Author", ISBN: "1234567890449", Year: 2020}
+	db.Create(&book)
+
+	// A cross-site form can POST _method=DELETE; it must not delete
+	form := url.Values{"_method": {"DELETE"}}
+	req, _ := http.NewRequest("POST", "/api/v1/books/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if err := db.First(&Book{}, 1).Error; err != nil {
+		t.Error("Expected the override ignored unless BOOKS_METHOD_OVERRIDE is set")
+	}
+}
+
+func TestMethodOverrideHeaderDelete(t *testing.T) {
+	clearDB()
+	useMethodOverride(t)
+	router := setupRouter()
+
+	book := Book{Title: "Override Me", Author: "Author", ISBN: "1234567890111", Year: 2020}
+	db.Create(&book)
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "DELETE")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if err := db.First(&Book{}, 1).Error; err == nil {
+		t.Error("Expected book to be deleted")
+	}
+}
+
+func TestMethodOverrideHeaderPut(t *testing.T) {
+	clearDB()
+	useMethodOverride(t)
+	router := setupRouter()
+
+	book := Book{Title: "Original", Author: "Author", ISBN: "1234567890227", Year: 2020}
+	db.Create(&book)
+
+	jsonData, _ := json.Marshal(Book{Title: "Overridden"})
+	req, _ := http.NewRequest("POST", "/api/v1/books/1", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-HTTP-Method-Override", "put")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+
+	var updated Book
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if updated.Title != "Overridden" {
+		t.Errorf("Expected title 'Overridden', got %s", updated.Title)
+	}
+}
+
+func TestMethodOverrideFormField(t *testing.T) {
+	clearDB()
+	useMethodOverride(t)
+	router := setupRouter()
+
+	book := Book{Title: "Form Delete", Author: "Author", ISBN: "1234567890333", Year: 2020}
+	db.Create(&book)
+
+	form := url.Values{"_method": {"DELETE"}}
+	req, _ := http.NewRequest("POST", "/api/v1/books/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+}
+
+func TestMethodOverrideRejectsUnsupportedMethod(t *testing.T) {
+	useMethodOverride(t)
+	router := setupRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "TRACE")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestMethodOverrideIgnoredOnGet(t *testing.T) {
+	clearDB()
+	useMethodOverride(t)
+	router := setupRouter()
+
+	book := Book{Title: "Keep Me", Author: "Author", ISBN: "1234567890449", Year: 2020}
+	db.Create(&book)
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "DELETE")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+	if err := db.First(&Book{}, 1).Error; err != nil {
+		t.Error("Expected book to survive a GET with an override header")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Media type of MessagePack responses. Requests still send JSON.
+const msgpackType = "application/msgpack"
+
+// Media types clients use for MessagePack
+func isMsgpackType(mediaType string) bool {
+	switch mediaType {
+	case msgpackType, "application/x-msgpack", "application/vnd.msgpack":
+		return true
+	}
+	return false
+}
+
+// Transcode a JSON document to MessagePack. Maps keep the JSON's member
+// order; whole numbers become the smallest integer format that holds
+// them and other numbers float64.
+func encodeMsgpack(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeOrderedJSON(dec)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, v); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpack(b *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if x {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		writeMsgpackNumber(b, x)
+	case string:
+		writeMsgpackHeader(b, len(x), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		b.WriteString(x)
+	case []interface{}:
+		writeMsgpackHeader(b, len(x), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range x {
+			if err := writeMsgpack(b, item); err != nil {
+				return err
+			}
+		}
+	case []orderedMember:
+		writeMsgpackHeader(b, len(x), 0x80, 15, 0, 0xde, 0xdf)
+		for _, m := range x {
+			writeMsgpack(b, m.key)
+			if err := writeMsgpack(b, m.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported value %T", v)
+	}
+	return nil
+}
+
+// Write a string, array or map header: the fix format when n fits in
+// fixMax, else the 8, 16 or 32 bit length format (arrays and maps have
+// no 8 bit one)
+func writeMsgpackHeader(b *bytes.Buffer, n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n <= fixMax:
+		b.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		b.Write([]byte{f8, byte(n)})
+	case n <= math.MaxUint16:
+		b.WriteByte(f16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(f32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackNumber(b *bytes.Buffer, n json.Number) {
+	if i, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+		switch {
+		case i >= 0 && i <= 127:
+			b.WriteByte(byte(i))
+		case i < 0 && i >= -32:
+			b.WriteByte(byte(int8(i)))
+		case i >= math.MinInt8 && i <= math.MaxInt8:
+			b.Write([]byte{0xd0, byte(int8(i))})
+		case i >= math.MinInt16 && i <= math.MaxInt16:
+			b.WriteByte(0xd1)
+			binary.Write(b, binary.BigEndian, int16(i))
+		case i >= math.MinInt32 && i <= math.MaxInt32:
+			b.WriteByte(0xd2)
+			binary.Write(b, binary.BigEndian, int32(i))
+		default:
+			b.WriteByte(0xd3)
+			binary.Write(b, binary.BigEndian, i)
+		}
+		return
+	}
+	if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+		b.WriteByte(0xcf)
+		binary.Write(b, binary.BigEndian, u)
+		return
+	}
+	f, _ := n.Float64()
+	b.WriteByte(0xcb)
+	binary.Write(b, binary.BigEndian, f)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncodeMsgpack(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"a":1,"b":[true,null],"c":"x"}`, "83a16101a16292c3c0a163a178"},
+		{`[0, 127, 128, -1, -32, -33, -129, 70000, 9780441013593]`, "99" + "00" + "7f" + "d10080" + "ff" + "e0" + "d0df" + "d1ff7f" + "d200011170" + "d3000008e52fb65d59"},
+		{`18446744073709551615`, "cfffffffffffffffff"},
+		{`1.5`, "cb3ff8000000000000"},
+		{`false`, "c2"},
+		{`{}`, "80"},
+		{`"` + strings.Repeat("x", 32) + `"`, "d920" + strings.Repeat("78", 32)},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := encodeMsgpack(&out, []byte(tt.json)); err != nil {
+			t.Errorf("encodeMsgpack(%s): %v", tt.json, err)
+			continue
+		}
+		if got := hex.EncodeToString(out.Bytes()); got != tt.want {
+			t.Errorf("encodeMsgpack(%s) = %s, want %s", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestMsgpackBooks(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/books?fields=title", nil)
+	req.Header.Set("Accept", "application/x-msgpack")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != msgpackType {
+		t.Fatalf("Expected a MessagePack response, got %d %q", response.Code, response.Header().Get("Content-Type"))
+	}
+	// [{"title": "Dune"}]
+	if got := hex.EncodeToString(response.Body.Bytes()); got != "9181a57469746c65a444756e65" {
+		t.Errorf("Unexpected MessagePack body %s", got)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Media type of XML responses and bodies
+const xmlType = "application/xml"
+
+// The response format the client prefers by its Accept header, by
+// q-value. JSON wins ties and is the fallback for anything unsupported,
+// so clients that send */* or nothing are unaffected. CSV is only
+// offered by the books listing, which checks for it itself.
+func negotiateFormat(accept string) string {
+	best, bestQ := "application/json", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			mediaType = "application/json"
+		case xmlType, "text/xml":
+			mediaType = xmlType
+		case csvType:
+		default:
+			switch {
+			case isYAMLType(mediaType):
+				mediaType = yamlType
+			case isMsgpackType(mediaType):
+				mediaType = msgpackType
+			case mediaType == jsonAPIType, mediaType == halType:
+			default:
+				continue
+			}
+		}
+		if q > bestQ || (q == bestQ && mediaType == "application/json") {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
+
+// Response encoders for the formats besides JSON, transcoding the JSON a
+// handler wrote
+var transcoders = map[string]func(io.Writer, []byte) error{
+	xmlType:     encodeXML,
+	yamlType:    encodeYAML,
+	msgpackType: encodeMsgpack,
+}
+
+// Response writers for the JSON dialects that reshape the JSON a handler
+// wrote into their own documents, with links that depend on the request
+var documentWriters = map[string]func(http.ResponseWriter, *http.Request, *bufferedResponse){
+	jsonAPIType: writeJSONAPI,
+	halType:     writeHAL,
+}
+
+// The format of a request body: XML or YAML when its Content-Type says
+// so, JSON otherwise. JSON:API documents count as JSON.
+func bodyFormat(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == xmlType || mediaType == "text/xml":
+		return "XML"
+	case isYAMLType(mediaType):
+		return "YAML"
+	}
+	return "JSON"
+}
+
+// Decode a request body into v in the format the client sent
+func decodeBody(r *http.Request, v interface{}) error {
+	switch bodyFormat(r) {
+	case "XML":
+		return decodeXML(r.Body, v)
+	case "YAML":
+		return decodeYAML(r.Body, v)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == jsonAPIType {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		return decodeJSONAPIBody(body, v)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// Reject a body decodeBody couldn't read, naming the format the client used
+func writeInvalidBody(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid "+bodyFormat(r))
+}
+
+// Serve the book endpoints in the format the client accepts. Handlers
+// always write JSON; when the client prefers XML, YAML or MessagePack the
+// JSON response is buffered and transcoded, so every endpoint gets the
+// same shapes. JSON:API and HAL reshape the response into their own
+// documents instead.
+func negotiateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(apiPath(r), "/books") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		format := negotiateFormat(r.Header.Get("Accept"))
+		encode, ok := transcoders[format]
+		writeDocument, reshape := documentWriters[format]
+		if !ok && !reshape {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if format == jsonAPIType {
+			jsonAPIQuery(r)
+		}
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+		if reshape {
+			writeDocument(w, r, buf)
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(buf.header.Get("Content-Type"))
+		if mediaType != "application/json" || buf.body.Len() == 0 {
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+			return
+		}
+		var out bytes.Buffer
+		if err := encode(&out, buf.body.Bytes()); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to encode response")
+			return
+		}
+		w.Header().Set("Content-Type", format)
+		w.Header().Del("Content-Length")
+		w.WriteHeader(buf.status)
+		w.Write(out.Bytes())
+	})
+}
+
+// The type of resource a book endpoint returns, named as its collection,
+// for formats that label resources
+func resourceType(path string) string {
+	if strings.HasSuffix(path, "/reviews") {
+		return "reviews"
+	}
+	return "books"
+}
+
+// bufferedResponse holds a handler's response for transcoding
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+
+// Transcode a JSON document to XML with the same shape: the root is
+// <response>, object members become elements named by their keys, in
+// order, and array elements become <item> elements. Null members are
+// left out.
+func encodeXML(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	enc := xml.NewEncoder(w)
+	io.WriteString(w, xml.Header)
+	if err := jsonToXML(dec, enc, "response"); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func jsonToXML(dec *json.Decoder, enc *xml.Encoder, name string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch t := tok.(type) {
+	case nil:
+		return nil
+	case json.Delim:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for dec.More() {
+			child := "item"
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = xmlElementName(key.(string))
+			}
+			if err := jsonToXML(dec, enc, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case json.Number:
+		return enc.EncodeElement(t.String(), start)
+	case bool:
+		return enc.EncodeElement(strconv.FormatBool(t), start)
+	default:
+		return enc.EncodeElement(t, start)
+	}
+}
+
+// A JSON key as an element name. Keys are nearly always plain field
+// names; anything else, such as a key starting with a digit, is patched
+// up with underscores.
+func xmlElementName(key string) string {
+	var b strings.Builder
+	for i, c := range key {
+		nameChar := unicode.IsDigit(c) || c == '-' || c == '.'
+		switch {
+		case c == '_' || unicode.IsLetter(c) || (i > 0 && nameChar):
+			b.WriteRune(c)
+		case i == 0 && nameChar:
+			b.WriteByte('_')
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// docNode is a parsed XML or YAML request body, before it is typed
+type docNode struct {
+	kind docKind
+	// Scalar text. Plain scalars are unquoted YAML, whose type depends on
+	// how the text reads when no Go type says otherwise.
+	text  string
+	plain bool
+	// Mapping keys, and the values for them or the list items
+	keys     []string
+	children []*docNode
+}
+
+type docKind int
+
+const (
+	docScalar docKind = iota
+	docMapping
+	docList
+)
+
+// An XML element as a document node: elements with children are
+// mappings, or lists when they are all <item>, and the rest text
+func xmlDocNode(el *xmlNode) *docNode {
+	node := &docNode{kind: docMapping}
+	allItems := true
+	for _, c := range el.children {
+		if child, ok := c.(*xmlNode); ok {
+			node.keys = append(node.keys, child.local)
+			node.children = append(node.children, xmlDocNode(child))
+			allItems = allItems && child.local == "item"
+		}
+	}
+	if len(node.children) == 0 {
+		return &docNode{kind: docScalar, text: el.text()}
+	}
+	if allItems {
+		node.kind = docList
+	}
+	return node
+}
+
+// Decode an XML body shaped like encodeXML's output into v
+func decodeXML(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return err
+	}
+	return decodeDoc(xmlDocNode(root), v)
+}
+
+// Decode a document into v. The document is turned into JSON using v's
+// type to tell numbers and booleans from strings, then decoded as JSON,
+// so v needs no tags beyond its JSON ones.
+func decodeDoc(node *docNode, v interface{}) error {
+	data, err := json.Marshal(docToJSON(node, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func docToJSON(node *docNode, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t == rawMessageType || t.Kind() == reflect.Interface {
+		return docToGeneric(node)
+	}
+	if node.kind == docScalar && node.isNull() {
+		return nil
+	}
+	text := strings.TrimSpace(node.text)
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.kind != docMapping {
+			break
+		}
+		fields := jsonFieldTypes(t)
+		obj := map[string]interface{}{}
+		for i, key := range node.keys {
+			if ft, ok := fields[key]; ok {
+				obj[key] = docToJSON(node.children[i], ft)
+			}
+		}
+		return obj
+	case reflect.Map:
+		if node.kind != docMapping {
+			break
+		}
+		obj := map[string]interface{}{}
+		for i, key := range node.keys {
+			obj[key] = docToJSON(node.children[i], t.Elem())
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return node.text
+		}
+		// An empty XML element is an empty list
+		if node.kind == docScalar && text != "" {
+			break
+		}
+		items := []interface{}{}
+		for _, child := range node.children {
+			items = append(items, docToJSON(child, t.Elem()))
+		}
+		return items
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if node.kind == docScalar && text == "" {
+			return nil
+		}
+		if _, err := strconv.ParseFloat(text, 64); err == nil && node.kind == docScalar {
+			return json.Number(text)
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(text); err == nil && node.kind == docScalar {
+			return b
+		}
+	default:
+		if node.kind == docScalar {
+			return node.text
+		}
+	}
+	// Doesn't fit the type: passed on untyped, so decoding reports it
+	return docToGeneric(node)
+}
+
+// Whether a scalar is null: only unquoted YAML can say so
+func (n *docNode) isNull() bool {
+	return n.plain && (n.text == "" || n.text == "~" || n.text == "null" || n.text == "Null" || n.text == "NULL")
+}
+
+// Without a type to follow, plain YAML scalars are typed by how they read
+// and everything else is a string
+func docToGeneric(node *docNode) interface{} {
+	switch node.kind {
+	case docMapping:
+		obj := map[string]interface{}{}
+		for i, key := range node.keys {
+			obj[key] = docToGeneric(node.children[i])
+		}
+		return obj
+	case docList:
+		items := make([]interface{}, len(node.children))
+		for i, child := range node.children {
+			items[i] = docToGeneric(child)
+		}
+		return items
+	}
+	if !node.plain {
+		return node.text
+	}
+	switch {
+	case node.isNull():
+		return nil
+	case node.text == "true" || node.text == "false":
+		return node.text == "true"
+	case json.Valid([]byte(node.text)) && strings.ContainsAny(node.text[:1], "-0123456789"):
+		return json.Number(node.text)
+	}
+	return node.text
+}
+
+// A struct's fields by JSON name, including promoted ones
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range jsonFieldTypes(ft) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", xmlType},
+		{"text/xml", xmlType},
+		{"application/json, application/xml", "application/json"},
+		{"application/json;q=0.5, application/xml", xmlType},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", xmlType},
+		{"text/html", "application/json"},
+		{"application/xml;q=0", "application/json"},
+		{"application/vnd.api+json", jsonAPIType},
+		{"application/hal+json", halType},
+	}
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestXMLElementName(t *testing.T) {
+	for key, want := range map[string]string{
+		"cover_url": "cover_url",
+		"2024":      "_2024",
+		"sci fi":    "sci_fi",
+		"":          "_",
+	} {
+		if got := xmlElementName(key); got != want {
+			t.Errorf("xmlElementName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestXMLBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", xmlType)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	created := send("POST", "/api/v1/books", xmlType, `<?xml version="1.0"?>
+		<book><title>Dune</title><author>Frank Herbert</author><isbn>9780441013593</isbn><year>1965</year></book>`)
+	if created.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", created.Code, created.Body.String())
+	}
+	if ct := created.Header().Get("Content-Type"); ct != xmlType {
+		t.Errorf("Expected an XML response, got %q", ct)
+	}
+	var book struct {
+		XMLName xml.Name `xml:"response"`
+		ID      uint     `xml:"id"`
+		Title   string   `xml:"title"`
+		Year    int      `xml:"year"`
+	}
+	if err := xml.Unmarshal(created.Body.Bytes(), &book); err != nil || book.ID == 0 || book.Title != "Dune" || book.Year != 1965 {
+		t.Fatalf("Unexpected XML book %+v (%v): %s", book, err, created.Body.String())
+	}
+
+	list := send("GET", "/api/v1/books?fields=id,title", "", "")
+	want := `<response><item><id>` + fmt.Sprint(book.ID) + `</id><title>Dune</title></item></response>`
+	if !strings.HasSuffix(list.Body.String(), want) {
+		t.Errorf("Expected the listing as %s, got %s", want, list.Body.String())
+	}
+	if vary := strings.Join(list.Header().Values("Vary"), ", "); vary != "X-Library, Accept" {
+		t.Errorf("Expected Vary: X-Library, Accept, got %q", vary)
+	}
+
+	// Errors keep their shape, and plain text errors pass through
+	invalid := send("POST", "/api/v1/books", xmlType, `<book><author>Nobody</author></book>`)
+	if invalid.Code != http.StatusBadRequest || !strings.Contains(invalid.Body.String(), "<field>title</field>") {
+		t.Errorf("Expected XML validation errors, got %d: %s", invalid.Code, invalid.Body.String())
+	}
+	if response := send("PUT", "/api/v1/books/"+fmt.Sprint(book.ID), xmlType, `<book><year>soon</year></book>`); response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "Invalid XML") {
+		t.Errorf("Expected Invalid XML, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// Without an XML Accept header nothing changes
+	req, _ := http.NewRequest("GET", "/api/v1/books/"+fmt.Sprint(book.ID), nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if ct := response.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON by default, got %q", ct)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notification event types
+const (
+	notifyBookCreated    = "book.created"
+	notifyImportFinished = "import.finished"
+	notifyJobFailed      = "job.failed"
+)
+
+var notificationEvents = []string{notifyBookCreated, notifyImportFinished, notifyJobFailed}
+
+// Notification is a short message for a chat channel
+type Notification struct {
+	Event  string
+	Title  string
+	Text   string
+	Fields []NotificationField
+	Error  bool // shown as a failure
+}
+
+// NotificationField is a labelled value shown under the text
+type NotificationField struct {
+	Name  string
+	Value string
+}
+
+// Notifier posts notifications to a chat service
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// A notifier and the events it receives
+type notificationChannel struct {
+	notifier Notifier
+	events   map[string]bool
+}
+
+// Active channels, configured by initNotifications
+var notificationChannels []notificationChannel
+
+func initNotifications() {
+	notificationChannels = nil
+	if cfg.SlackWebhookURL != "" {
+		addNotificationChannel(&slackNotifier{url: cfg.SlackWebhookURL, client: notificationClient}, cfg.SlackNotifyEvents)
+	}
+	if cfg.DiscordWebhookURL != "" {
+		addNotificationChannel(&discordNotifier{url: cfg.DiscordWebhookURL, client: notificationClient}, cfg.DiscordNotifyEvents)
+	}
+}
+
+// Subscribe n to a comma-separated list of events; empty means all of them
+func addNotificationChannel(n Notifier, events string) {
+	ch := notificationChannel{notifier: n, events: map[string]bool{}}
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		if !isNotificationEvent(e) {
+			log.Fatalf("Unknown %s notification event %q", n.Name(), e)
+		}
+		ch.events[e] = true
+	}
+	if len(ch.events) == 0 {
+		for _, e := range notificationEvents {
+			ch.events[e] = true
+		}
+	}
+	notificationChannels = append(notificationChannels, ch)
+}
+
+func isNotificationEvent(e string) bool {
+	for _, known := range notificationEvents {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Send n to every channel subscribed to its event, in the background.
+// Failures are logged by the job queue and never reach the caller.
+func notify(n *Notification) {
+	if jobs == nil {
+		return
+	}
+	for _, ch := range notificationChannels {
+		if !ch.events[n.Event] {
+			continue
+		}
+		notifier := ch.notifier
+		jobs.Enqueue("notify:"+notifier.Name(), func(ctx context.Context) error {
+			return notifier.Notify(ctx, n)
+		})
+	}
+}
+
+// Notifications for the events the API raises
+
+func notifyBookAdded(b *Book) {
+	fields := []NotificationField{{Name: "Author", Value: b.Author}, {Name: "ISBN", Value: b.ISBN}}
+	if b.Year != 0 {
+		fields = append(fields, NotificationField{Name: "Year", Value: fmt.Sprint(b.Year)})
+	}
+	notify(&Notification{Event: notifyBookCreated, Title: "New book: " + b.Title, Fields: fields})
+}
+
+func notifyImportDone(r *ImportReport) {
+	notify(&Notification{
+		Event: notifyImportFinished,
+		Title: "Library import finished",
+		Text:  fmt.Sprintf("Imported a %s export of %d rows.", r.Format, len(r.Rows)),
+		Fields: []NotificationField{
+			{Name: "Created", Value: fmt.Sprint(r.Created)},
+			{Name: "Updated", Value: fmt.Sprint(r.Updated)},
+			{Name: "Skipped", Value: fmt.Sprint(r.Skipped)},
+			{Name: "Errors", Value: fmt.Sprint(r.Errors)},
+		},
+		Error: r.Errors > 0,
+	})
+}
+
+func notifyJobFailure(job string, err error) {
+	notify(&Notification{
+		Event:  notifyJobFailed,
+		Title:  "Job failed: " + job,
+		Text:   err.Error(),
+		Fields: []NotificationField{{Name: "Instance", Value: instanceID}},
+		Error:  true,
+	})
+}
+
+// Shared client for chat webhooks
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// Sidebar colors
+const (
+	notifyColorInfo  = 0x2eb67d
+	notifyColorError = 0xe01e5a
+)
+
+func (n *Notification) color() int {
+	if n.Error {
+		return notifyColorError
+	}
+	return notifyColorInfo
+}
+
+// POST a JSON payload to a webhook
+func postWebhook(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slackNotifier) Name() string { return "slack" }
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Block Kit message with a colored attachment. text is the fallback shown
+// in notifications.
+func (s *slackNotifier) Notify(ctx context.Context, n *Notification) error {
+	blocks := []map[string]interface{}{{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": slackText(n)},
+	}}
+	if len(n.Fields) > 0 {
+		var fields []map[string]string
+		for _, f := range n.Fields {
+			fields = append(fields, map[string]string{"type": "mrkdwn", "text": "*" + slackEscaper.Replace(f.Name) + "*\n" + slackEscaper.Replace(f.Value)})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	return postWebhook(ctx, s.client, s.url, map[string]interface{}{
+		"text": slackEscaper.Replace(n.Title),
+		"attachments": []map[string]interface{}{{
+			"color":  fmt.Sprintf("#%06x", n.color()),
+			"blocks": blocks,
+		}},
+	})
+}
+
+func slackText(n *Notification) string {
+	text := "*" + slackEscaper.Replace(n.Title) + "*"
+	if n.Text != "" {
+		text += "\n" + slackEscaper.Replace(n.Text)
+	}
+	return text
+}
+
+// discordNotifier posts to a Discord channel webhook
+type discordNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (d *discordNotifier) Name() string { return "discord" }
+
+// A single embed. Mentions are disabled so text from book data can't ping
+// anyone.
+func (d *discordNotifier) Notify(ctx context.Context, n *Notification) error {
+	embed := map[string]interface{}{
+		"title": n.Title,
+		"color": n.color(),
+	}
+	if n.Text != "" {
+		embed["description"] = n.Text
+	}
+	var fields []map[string]interface{}
+	for _, f := range n.Fields {
+		fields = append(fields, map[string]interface{}{"name": f.Name, "value": f.Value, "inline": true})
+	}
+	if fields != nil {
+		embed["fields"] = fields
+	}
+	return postWebhook(ctx, d.client, d.url, map[string]interface{}{
+		"embeds":           []interface{}{embed},
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Fake chat webhook recording the payloads it receives
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	rec.mu.Lock()
+	rec.payloads = append(rec.payloads, payload)
+	rec.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rec *webhookRecorder) received() []map[string]interface{} {
+	jobs.Wait()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.payloads
+}
+
+func useNotificationChannels(t *testing.T) (slack, discord *webhookRecorder) {
+	slack, discord = &webhookRecorder{}, &webhookRecorder{}
+	slackSrv, discordSrv := httptest.NewServer(slack), httptest.NewServer(discord)
+	saved := notificationChannels
+	notificationChannels = nil
+	addNotificationChannel(&slackNotifier{url: slackSrv.URL, client: slackSrv.Client()}, "")
+	addNotificationChannel(&discordNotifier{url: discordSrv.URL, client: discordSrv.Client()}, "job.failed")
+	t.Cleanup(func() {
+		notificationChannels = saved
+		slackSrv.Close()
+		discordSrv.Close()
+	})
+	return slack, discord
+}
+
+func TestNotifyBookCreated(t *testing.T) {
+	clearDB()
+	slack, discord := useNotificationChannels(t)
+	router := setupRouter()
+
+	body := `{"title": "Dune <Deluxe>", "author": "Frank Herbert", "isbn": "9780441013593", "year": 1966}`
+	req, _ := http.NewRequest("POST", "/api/v1/books", bytes.NewBufferString(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	payloads := slack.received()
+	if len(payloads) != 1 {
+		t.Fatalf("Expected one Slack message, got %d", len(payloads))
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(payloads[0])
+	encoded := buf.String()
+	for _, want := range []string{`"text":"New book: Dune &lt;Deluxe&gt;"`, `*New book: Dune &lt;Deluxe&gt;*`, `*Author*\nFrank Herbert`, `"color":"#2eb67d"`} {
+		if !strings.Contains(encoded, want) {
+			t.Errorf("Expected %s in the Slack message, got %s", want, encoded)
+		}
+	}
+	if got := discord.received(); len(got) != 0 {
+		t.Errorf("Expected Discord, subscribed to job.failed only, to get nothing, got %v", got)
+	}
+}
+
+func TestNotifyJobFailed(t *testing.T) {
+	slack, discord := useNotificationChannels(t)
+
+	notifyJobFailure("backup", errors.New("disk full"))
+
+	if got := slack.received(); len(got) != 1 {
+		t.Errorf("Expected one Slack message, got %d", len(got))
+	}
+	payloads := discord.received()
+	if len(payloads) != 1 {
+		t.Fatalf("Expected one Discord message, got %d", len(payloads))
+	}
+	embed := payloads[0]["embeds"].([]interface{})[0].(map[string]interface{})
+	if embed["title"] != "Job failed: backup" || embed["description"] != "disk full" || embed["color"] != float64(notifyColorError) {
+		t.Errorf("Unexpected embed %v", embed)
+	}
+}
@@ -0,0 +1,1145 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3 document, limited to the parts this API uses
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+// jsonSchema is the subset of OpenAPI schema objects the spec needs
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	// Property order, for generators; not part of the document
+	order []string
+}
+
+// HealthStatus is the /health response
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// Prefix the API's paths are served under
+const apiPrefix = "/api/v1"
+
+// specBuilder collects operations and the schemas they reference
+type specBuilder struct {
+	doc *openAPIDoc
+}
+
+func newSpecBuilder() *specBuilder {
+	return &specBuilder{doc: &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "Books API", Version: "1.0.0"},
+		Servers: []openAPIServer{{URL: "http://localhost:8080"}},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas:         map[string]*jsonSchema{},
+			SecuritySchemes: map[string]openAPISecurityScheme{"bearerAuth": {Type: "http", Scheme: "bearer"}},
+		},
+	}}
+}
+
+// Schema for a Go value's JSON encoding. Named structs become components
+// referenced by $ref, so the spec follows the models as they change.
+func (b *specBuilder) schema(t reflect.Type) *jsonSchema {
+	if t.Kind() == reflect.Pointer {
+		s := b.schema(t.Elem())
+		if s.Ref != "" {
+			return s
+		}
+		s.Nullable = true
+		return s
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.doc.Components.Schemas[t.Name()]; !ok {
+			// Register first so self-referencing types terminate
+			b.doc.Components.Schemas[t.Name()] = &jsonSchema{}
+			*b.doc.Components.Schemas[t.Name()] = *b.object(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &jsonSchema{}
+}
+
+// Object schema from a struct's exported, JSON-visible fields
+func (b *specBuilder) object(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := b.object(f.Type)
+			for _, p := range embedded.order {
+				s.Properties[p] = embedded.Properties[p]
+				s.order = append(s.order, p)
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = b.schema(f.Type)
+		s.order = append(s.order, name)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+func (b *specBuilder) ref(v interface{}) *jsonSchema {
+	return b.schema(reflect.TypeOf(v))
+}
+
+func jsonContent(s *jsonSchema) map[string]openAPIMedia {
+	return map[string]openAPIMedia{"application/json": {Schema: s}}
+}
+
+// Plain text, the body of most errors
+func textContent() map[string]openAPIMedia {
+	return map[string]openAPIMedia{"text/plain": {Schema: &jsonSchema{Type: "string"}}}
+}
+
+// Raw bytes of the given media type, such as an image
+func binaryContent(mediaType string) map[string]openAPIMedia {
+	return map[string]openAPIMedia{mediaType: {Schema: &jsonSchema{Type: "string", Format: "binary"}}}
+}
+
+// A response with a plain text body
+func textResponse(description string) *openAPIResponse {
+	return &openAPIResponse{Description: description, Content: textContent()}
+}
+
+// Operations needing a bearer token when authentication is enabled
+var bearerAuth = []map[string][]string{{"bearerAuth": {}}}
+
+// Add an operation. A nil response schema means an empty body. Responses
+// already on o, such as other errors, are kept; the common ones are
+// added: 400 for invalid input, as field errors or text, 404 for paths
+// naming a resource and 401 and 403 for operations that need a token.
+// Text errors may also be problem details, as v2 and opted-in clients
+// receive them.
+func (b *specBuilder) op(method, path string, o openAPIOperation, status string, response *jsonSchema) {
+	for _, name := range pathParams(path) {
+		typ := "string"
+		if name == "id" || name == "number" || strings.HasSuffix(name, "_id") {
+			typ = "integer"
+		}
+		o.Parameters = append([]openAPIParameter{{Name: name, In: "path", Required: true, Schema: &jsonSchema{Type: typ}}}, o.Parameters...)
+	}
+	responses := map[string]*openAPIResponse{}
+	if status != "" {
+		resp := &openAPIResponse{Description: "OK"}
+		if response != nil {
+			resp.Content = jsonContent(response)
+		}
+		responses[status] = resp
+	}
+	if len(o.Parameters) > 0 || o.RequestBody != nil {
+		content := textContent()
+		content["application/json"] = openAPIMedia{Schema: b.ref(ValidationErrors{})}
+		responses["400"] = &openAPIResponse{Description: "Invalid request", Content: content}
+	}
+	if strings.Contains(path, "{") {
+		responses["404"] = textResponse("Not found")
+	}
+	if o.Security != nil {
+		responses["401"] = textResponse("Authentication required")
+		responses["403"] = textResponse("Missing role")
+	}
+	for code, resp := range o.Responses {
+		responses[code] = resp
+	}
+	for code, resp := range responses {
+		if _, ok := resp.Content["text/plain"]; ok && code >= "400" {
+			resp.Content[problemType] = openAPIMedia{Schema: b.ref(Problem{})}
+		}
+	}
+	o.Responses = responses
+
+	if b.doc.Paths[path] == nil {
+		b.doc.Paths[path] = map[string]*openAPIOperation{}
+	}
+	b.doc.Paths[path][strings.ToLower(method)] = &o
+}
+
+// Names of the {params} in a path, last first
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append([]string{strings.Trim(part, "{}")}, names...)
+		}
+	}
+	return names
+}
+
+func queryParam(name, typ, description string, required bool) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Required: required, Schema: &jsonSchema{Type: typ}}
+}
+
+func headerParam(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "header", Description: description, Schema: &jsonSchema{Type: "string"}}
+}
+
+func jsonBody(s *jsonSchema) *openAPIRequestBody {
+	return &openAPIRequestBody{Required: true, Content: jsonContent(s)}
+}
+
+// An object schema with the given properties, all required, in order
+func objectSchema(names []string, props ...*jsonSchema) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, Required: names, order: names}
+	for i, name := range names {
+		s.Properties[name] = props[i]
+	}
+	return s
+}
+
+// The OpenAPI description of every route. Clients, such as the
+// TypeScript client, are generated from it.
+func buildOpenAPISpec() *openAPIDoc {
+	b := newSpecBuilder()
+	book := b.ref(Book{})
+	books := &jsonSchema{Type: "array", Items: book}
+	limit := queryParam("limit", "integer", "Page size", false)
+	start := queryParam("start", "integer", "Results to skip", false)
+	filters := []openAPIParameter{
+		queryParam("author", "string", "Author contains, ignoring case", false),
+		queryParam("title", "string", "Title contains, ignoring case", false),
+		queryParam("year_min", "integer", "Earliest year", false),
+		queryParam("year_max", "integer", "Latest year", false),
+		queryParam("language", "string", "ISO 639-1 language code", false),
+		queryParam("status", "string", "available, on_loan, lost, archived or on_order", false),
+		queryParam("location", "string", "Shelf location code, from BOOK_LOCATIONS", false),
+		queryParam("created_after", "string", "Added after this RFC 3339 time", false),
+		queryParam("q", "string", `Query terms that must all match: words, "phrases", author:fowler, tag:scifi, year:>1995`, false),
+	}
+	sort := queryParam("sort", "string", "Fields to sort by, descending with a - prefix: -year,title", false)
+	includeDeleted := queryParam("include_deleted", "boolean", "Include books in the trash; admins only", false)
+	ifMatch := headerParam("If-Match", "ETag the book was read with; the write fails with 412 if it has changed since")
+	stale := map[string]*openAPIResponse{"412": textResponse("Book has changed since it was read")}
+
+	b.op("GET", apiPrefix+"/books", openAPIOperation{
+		OperationID: "listBooks", Summary: "List all books", Tags: []string{"books"},
+		Parameters: append(append([]openAPIParameter{}, filters...), sort, includeDeleted),
+	}, "200", books)
+	b.op("DELETE", apiPrefix+"/books", openAPIOperation{
+		OperationID: "bulkDeleteBooks", Summary: "Delete the books with the given IDs or matching the filters", Tags: []string{"books"},
+		Parameters: append([]openAPIParameter{queryParam("ids", "string", "Comma-separated book IDs", false)}, filters...),
+		Security:   bearerAuth,
+	}, "200", b.ref(BulkDeleteResult{}))
+	b.op("GET", apiPrefix+"/books/count", openAPIOperation{
+		OperationID: "countBooks", Summary: "Count the books matching the listing filters", Tags: []string{"books"},
+		Parameters: append(append([]openAPIParameter{}, filters...), includeDeleted),
+	}, "200", b.ref(BookCount{}))
+	b.op("GET", apiPrefix+"/books/export.csv", openAPIOperation{
+		OperationID: "exportBooksCSV", Summary: "Download the listing as CSV", Tags: []string{"books"},
+		Parameters: append(append([]openAPIParameter{}, filters...), sort),
+		Responses: map[string]*openAPIResponse{"200": {Description: "OK", Content: map[string]openAPIMedia{
+			csvType: {Schema: &jsonSchema{Type: "string"}},
+		}}},
+	}, "", nil)
+	b.op("POST", apiPrefix+"/books", openAPIOperation{
+		OperationID: "createBook", Summary: "Create a book", Tags: []string{"books"},
+		Parameters:  []openAPIParameter{queryParam("enrich", "boolean", "Fill in missing fields from the metadata provider", false)},
+		RequestBody: jsonBody(book),
+		Security:    bearerAuth,
+	}, "201", book)
+	bulkReport := b.ref(BulkReport{})
+	b.op("POST", apiPrefix+"/books/bulk", openAPIOperation{
+		OperationID: "bulkCreateBooks", Summary: "Create several books, reporting on each", Tags: []string{"books"},
+		RequestBody: jsonBody(books),
+		Security:    bearerAuth,
+	}, "200", bulkReport)
+	b.op("PUT", apiPrefix+"/books/bulk", openAPIOperation{
+		OperationID: "bulkUpdateBooks", Summary: "Merge fields into several books by ID, all or none", Tags: []string{"books"},
+		RequestBody: jsonBody(books),
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"400": {Description: "Some items are invalid; none were applied", Content: jsonContent(bulkReport)}},
+	}, "200", bulkReport)
+	b.op("POST", apiPrefix+"/books/validate", openAPIOperation{
+		OperationID: "validateBook", Summary: "Check a book for every error a write would report, without saving it", Tags: []string{"books"},
+		Parameters:  []openAPIParameter{queryParam("id", "integer", "Validate as an update of this book", false)},
+		RequestBody: jsonBody(book),
+		Security:    bearerAuth,
+	}, "200", b.ref(BookValidation{}))
+	b.op("GET", apiPrefix+"/books/search", openAPIOperation{
+		OperationID: "searchBooks", Summary: "Search books by title, author, ISBN or description", Tags: []string{"books"},
+		Parameters: []openAPIParameter{
+			queryParam("q", "string", "Search terms", true),
+			limit,
+			queryParam("offset", "integer", "Results to skip", false),
+		},
+	}, "200", books)
+	b.op("GET", apiPrefix+"/books/random", openAPIOperation{
+		OperationID: "getRandomBooks", Summary: "Pick random books matching the listing filters", Tags: []string{"books"},
+		Parameters: append([]openAPIParameter{queryParam("count", "integer", "Books to pick, 1 to 50 (default 1)", false)}, filters...),
+	}, "200", books)
+	b.op("GET", apiPrefix+"/books/changes", openAPIOperation{
+		OperationID: "listBookChanges", Summary: "Books created, updated or deleted since a checkpoint, for incremental sync", Tags: []string{"books"},
+		Parameters: []openAPIParameter{
+			queryParam("since", "string", "next from the previous response, or an RFC 3339 time", false),
+			queryParam("limit", "integer", "Most books to return, at most 1000", false),
+		},
+	}, "200", b.ref(ChangeFeed{}))
+	b.op("GET", apiPrefix+"/books/trash", openAPIOperation{
+		OperationID: "listTrash", Summary: "Deleted books that can still be restored, most recent first", Tags: []string{"books"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(TrashedBook{})})
+	b.op("GET", apiPrefix+"/books/isbn/{isbn}", openAPIOperation{
+		OperationID: "getBookByISBN", Summary: "Get a book by ISBN-10 or ISBN-13, with or without dashes", Tags: []string{"books"},
+	}, "200", book)
+	b.op("PUT", apiPrefix+"/books/isbn/{isbn}", openAPIOperation{
+		OperationID: "upsertBookByISBN", Summary: "Create the book with an ISBN, or update its non-empty fields if it exists", Tags: []string{"books"},
+		Parameters:  []openAPIParameter{ifMatch},
+		RequestBody: jsonBody(book),
+		Security:    bearerAuth,
+		Responses: map[string]*openAPIResponse{
+			"201": {Description: "Created", Content: jsonContent(book)},
+			"412": stale["412"],
+		},
+	}, "200", book)
+	b.op("GET", apiPrefix+"/books/{id}", openAPIOperation{
+		OperationID: "getBook", Summary: "Get a book by ID", Tags: []string{"books"},
+	}, "200", book)
+	b.op("PUT", apiPrefix+"/books/{id}", openAPIOperation{
+		OperationID: "updateBook", Summary: "Update a book's non-empty fields", Tags: []string{"books"},
+		Parameters:  []openAPIParameter{ifMatch},
+		RequestBody: jsonBody(book),
+		Security:    bearerAuth,
+		Responses:   stale,
+	}, "200", book)
+	b.op("PATCH", apiPrefix+"/books/{id}", openAPIOperation{
+		OperationID: "patchBook", Summary: "Set the named fields of a book (JSON Merge Patch); null clears one", Tags: []string{"books"},
+		Parameters:  []openAPIParameter{ifMatch},
+		RequestBody: jsonBody(book),
+		Security:    bearerAuth,
+		Responses:   stale,
+	}, "200", book)
+	b.op("DELETE", apiPrefix+"/books/{id}", openAPIOperation{
+		OperationID: "deleteBook", Summary: "Delete a book", Tags: []string{"books"},
+		Parameters: []openAPIParameter{ifMatch},
+		Security:   bearerAuth,
+		Responses:  stale,
+	}, "204", nil)
+	b.op("POST", apiPrefix+"/books/{id}/enrich", openAPIOperation{
+		OperationID: "enrichBook", Summary: "Fill in metadata from the provider", Tags: []string{"books"},
+		Parameters: []openAPIParameter{queryParam("overwrite", "boolean", "Replace fields that are already set", false)},
+		Security:   bearerAuth,
+	}, "200", book)
+	b.op("POST", apiPrefix+"/books/{id}/restore", openAPIOperation{
+		OperationID: "restoreBook", Summary: "Bring a deleted book back from the trash", Tags: []string{"books"},
+		Security: bearerAuth,
+	}, "200", book)
+	b.op("POST", apiPrefix+"/books/scan", openAPIOperation{
+		OperationID: "scanBook", Summary: "Create a book from a scanned EAN-13 or UPC-A barcode", Tags: []string{"books"},
+		RequestBody: jsonBody(b.ref(ScanRequest{})),
+		Responses: map[string]*openAPIResponse{
+			"404": textResponse("The metadata provider has no record for the ISBN"),
+			"409": textResponse("The ISBN is already in the catalog"),
+			"422": textResponse("The barcode isn't an ISBN"),
+		},
+		Security: bearerAuth,
+	}, "201", book)
+	b.op("POST", apiPrefix+"/books/from-google/{volumeId}", openAPIOperation{
+		OperationID: "createBookFromGoogle", Summary: "Create a book from a Google Books volume", Tags: []string{"books"},
+		Security: bearerAuth,
+	}, "201", book)
+	b.op("POST", apiPrefix+"/books/from-sru/{isbn}", openAPIOperation{
+		OperationID: "createBookFromSRU", Summary: "Create a book from the SRU catalog record for an ISBN", Tags: []string{"books"},
+		Security: bearerAuth,
+	}, "201", book)
+	b.op("GET", apiPrefix+"/books/{id}/reviews", openAPIOperation{
+		OperationID: "listBookReviews", Summary: "List reviews for a book", Tags: []string{"reviews"},
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(Review{})})
+	b.op("GET", apiPrefix+"/books/{id}/also-read", openAPIOperation{
+		OperationID: "listAlsoRead", Summary: "Books read by readers of this book", Tags: []string{"recommendations"},
+		Parameters: []openAPIParameter{limit},
+	}, "200", books)
+	b.op("GET", apiPrefix+"/books/{id}/related", openAPIOperation{
+		OperationID: "listRelatedBooks", Summary: "Books by the same author, with shared tags or from a similar year", Tags: []string{"recommendations"},
+		Parameters: []openAPIParameter{limit},
+	}, "200", books)
+	b.op("GET", apiPrefix+"/books/{id}/revisions", openAPIOperation{
+		OperationID: "listBookRevisions", Summary: "A book's earlier versions, newest first, with what each write changed", Tags: []string{"books"},
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(BookRevision{})})
+	b.op("POST", apiPrefix+"/books/{id}/revisions/{number}/rollback", openAPIOperation{
+		OperationID: "rollbackBook", Summary: "Put a book back as it was at a revision", Tags: []string{"books"},
+		Parameters: []openAPIParameter{ifMatch},
+		Security:   bearerAuth,
+		Responses:  stale,
+	}, "200", book)
+	b.op("GET", apiPrefix+"/books/{id}/editions", openAPIOperation{
+		OperationID: "listBookEditions", Summary: "Every edition of the book's work, oldest first", Tags: []string{"works"},
+	}, "200", books)
+
+	b.op("GET", apiPrefix+"/books/{id}/cover", openAPIOperation{
+		OperationID: "getCover", Summary: "Get a book's cover image", Tags: []string{"covers"},
+		Parameters: []openAPIParameter{queryParam("size", "string", "sm, md, lg or original", false)},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "OK", Content: binaryContent("image/*")},
+			"302": {Description: "The book's cover is hosted elsewhere"},
+		},
+	}, "", nil)
+	b.op("PUT", apiPrefix+"/books/{id}/cover", openAPIOperation{
+		OperationID: "uploadCover", Summary: "Upload a JPEG, PNG, GIF or WebP cover", Tags: []string{"covers"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: binaryContent("image/*")},
+		Security:    bearerAuth,
+		Responses: map[string]*openAPIResponse{
+			"413": textResponse("Cover image is too large"),
+			"415": textResponse("Not an accepted image type"),
+		},
+	}, "200", book)
+	b.op("DELETE", apiPrefix+"/books/{id}/cover", openAPIOperation{
+		OperationID: "deleteCover", Summary: "Remove a book's uploaded cover", Tags: []string{"covers"},
+		Security: bearerAuth,
+	}, "204", nil)
+	b.op("POST", apiPrefix+"/books/{id}/cover/upload-url", openAPIOperation{
+		OperationID: "createCoverUploadURL", Summary: "Get a presigned URL to upload a cover to directly", Tags: []string{"covers"},
+		RequestBody: jsonBody(b.ref(CoverUploadRequest{})),
+		Security:    bearerAuth,
+	}, "200", b.ref(CoverUpload{}))
+	b.op("POST", apiPrefix+"/books/{id}/cover/complete", openAPIOperation{
+		OperationID: "completeCoverUpload", Summary: "Attach a cover uploaded to a presigned URL", Tags: []string{"covers"},
+		Security: bearerAuth,
+		Responses: map[string]*openAPIResponse{
+			"409": textResponse("No uploaded cover found"),
+			"415": textResponse("Uploaded cover is not an accepted image"),
+		},
+	}, "200", book)
+
+	loan := b.ref(Loan{})
+	b.op("POST", apiPrefix+"/books/{id}/checkout", openAPIOperation{
+		OperationID: "checkoutBook", Summary: "Lend a book to a member", Tags: []string{"loans"},
+		RequestBody: jsonBody(b.ref(CheckoutRequest{})),
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("The book is already on loan or the membership isn't active")},
+	}, "201", loan)
+	b.op("GET", apiPrefix+"/loans/overdue", openAPIOperation{
+		OperationID: "listOverdueLoans", Summary: "Open loans past their due date, longest overdue first", Tags: []string{"loans"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(OverdueLoan{})})
+	b.op("GET", apiPrefix+"/loans/{id}", openAPIOperation{
+		OperationID: "getLoan", Summary: "Get a loan by ID", Tags: []string{"loans"},
+		Security: bearerAuth,
+	}, "200", loan)
+	b.op("POST", apiPrefix+"/loans/{id}/return", openAPIOperation{
+		OperationID: "returnLoan", Summary: "Return a lent book", Tags: []string{"loans"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The loan was already returned")},
+	}, "200", loan)
+	b.op("POST", apiPrefix+"/loans/{id}/renew", openAPIOperation{
+		OperationID: "renewLoan", Summary: "Extend a loan by another loan period", Tags: []string{"loans"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The loan was returned or can't be renewed again")},
+	}, "200", loan)
+	b.op("POST", apiPrefix+"/fines/{id}/payments", openAPIOperation{
+		OperationID: "payFine", Summary: "Record a payment towards a fine", Tags: []string{"loans"},
+		RequestBody: jsonBody(b.ref(FinePayment{})),
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("The payment is more than is outstanding")},
+	}, "200", b.ref(Fine{}))
+
+	member := b.ref(Member{})
+	memberRequest := jsonBody(b.ref(MemberRequest{}))
+	b.op("GET", apiPrefix+"/members", openAPIOperation{
+		OperationID: "getMembers", Summary: "List the members by name", Tags: []string{"members"},
+		Parameters: []openAPIParameter{queryParam("status", "string", "Only members with this status", false)},
+		Security:   bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: member})
+	b.op("POST", apiPrefix+"/members", openAPIOperation{
+		OperationID: "createMember", Summary: "Add a member", Tags: []string{"members"},
+		RequestBody: memberRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A member with this membership number already exists")},
+	}, "201", member)
+	b.op("GET", apiPrefix+"/members/{id}", openAPIOperation{
+		OperationID: "getMember", Summary: "Get a member by ID", Tags: []string{"members"},
+		Security: bearerAuth,
+	}, "200", member)
+	b.op("PUT", apiPrefix+"/members/{id}", openAPIOperation{
+		OperationID: "updateMember", Summary: "Replace a member's details", Tags: []string{"members"},
+		RequestBody: memberRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A member with this membership number already exists")},
+	}, "200", member)
+	b.op("DELETE", apiPrefix+"/members/{id}", openAPIOperation{
+		OperationID: "deleteMember", Summary: "Remove a member with no loans or fines outstanding", Tags: []string{"members"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The member has books on loan or owes fines")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/members/{id}/loans", openAPIOperation{
+		OperationID: "getMemberLoans", Summary: "A member's loans, open ones first", Tags: []string{"members"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(Loan{})})
+	b.op("GET", apiPrefix+"/members/{id}/fines", openAPIOperation{
+		OperationID: "getMemberFines", Summary: "A member's fines and what they still owe", Tags: []string{"members"},
+		Security: bearerAuth,
+	}, "200", b.ref(MemberFines{}))
+
+	acquisition := b.ref(Acquisition{})
+	acquisitionRequest := jsonBody(b.ref(AcquisitionRequest{}))
+	fiscalYear := queryParam("fiscal_year", "integer", "Only this fiscal year, named by the year it ends in", false)
+	b.op("GET", apiPrefix+"/acquisitions", openAPIOperation{
+		OperationID: "getAcquisitions", Summary: "List acquisitions, most recent first", Tags: []string{"acquisitions"},
+		Parameters: []openAPIParameter{
+			queryParam("vendor", "string", "Only this vendor's", false),
+			queryParam("method", "string", "purchase, donation, exchange or transfer", false),
+			queryParam("book_id", "integer", "Only this book's", false),
+			fiscalYear,
+		},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: acquisition})
+	b.op("POST", apiPrefix+"/acquisitions", openAPIOperation{
+		OperationID: "createAcquisition", Summary: "Record how a book was acquired", Tags: []string{"acquisitions"},
+		RequestBody: acquisitionRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("The book already has an acquisition record")},
+	}, "201", acquisition)
+	b.op("GET", apiPrefix+"/acquisitions/reports/vendors", openAPIOperation{
+		OperationID: "getVendorReport", Summary: "Books acquired and their cost per vendor", Tags: []string{"acquisitions"},
+		Parameters: []openAPIParameter{fiscalYear},
+		Security:   bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(VendorAcquisitions{})})
+	b.op("GET", apiPrefix+"/acquisitions/reports/fiscal-years", openAPIOperation{
+		OperationID: "getFiscalYearReport", Summary: "Books acquired and their cost per fiscal year", Tags: []string{"acquisitions"},
+		Parameters: []openAPIParameter{fiscalYear},
+		Security:   bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(FiscalYearAcquisitions{})})
+	b.op("GET", apiPrefix+"/acquisitions/{id}", openAPIOperation{
+		OperationID: "getAcquisition", Summary: "Get an acquisition by ID", Tags: []string{"acquisitions"},
+		Security: bearerAuth,
+	}, "200", acquisition)
+	b.op("PUT", apiPrefix+"/acquisitions/{id}", openAPIOperation{
+		OperationID: "updateAcquisition", Summary: "Correct an acquisition record", Tags: []string{"acquisitions"},
+		RequestBody: acquisitionRequest,
+		Security:    bearerAuth,
+	}, "200", acquisition)
+	b.op("DELETE", apiPrefix+"/acquisitions/{id}", openAPIOperation{
+		OperationID: "deleteAcquisition", Summary: "Delete an acquisition record", Tags: []string{"acquisitions"},
+		Security: bearerAuth,
+	}, "204", nil)
+
+	donor := b.ref(Donor{})
+	donorRequest := jsonBody(b.ref(DonorRequest{}))
+	b.op("GET", apiPrefix+"/donors", openAPIOperation{
+		OperationID: "getDonors", Summary: "List the donors by name", Tags: []string{"donations"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: donor})
+	b.op("POST", apiPrefix+"/donors", openAPIOperation{
+		OperationID: "createDonor", Summary: "Add a donor", Tags: []string{"donations"},
+		RequestBody: donorRequest,
+		Security:    bearerAuth,
+	}, "201", donor)
+	b.op("GET", apiPrefix+"/donors/{id}", openAPIOperation{
+		OperationID: "getDonor", Summary: "Get a donor by ID", Tags: []string{"donations"},
+		Security: bearerAuth,
+	}, "200", donor)
+	b.op("PUT", apiPrefix+"/donors/{id}", openAPIOperation{
+		OperationID: "updateDonor", Summary: "Replace a donor's details", Tags: []string{"donations"},
+		RequestBody: donorRequest,
+		Security:    bearerAuth,
+	}, "200", donor)
+	b.op("DELETE", apiPrefix+"/donors/{id}", openAPIOperation{
+		OperationID: "deleteDonor", Summary: "Remove a donor with no donations", Tags: []string{"donations"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The donor still has donations")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/donors/{id}/books", openAPIOperation{
+		OperationID: "getDonorBooks", Summary: "The books a donor gave, by title", Tags: []string{"donations"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: book})
+
+	donation := b.ref(Donation{})
+	donationRequest := jsonBody(b.ref(DonationRequest{}))
+	b.op("GET", apiPrefix+"/donations", openAPIOperation{
+		OperationID: "getDonations", Summary: "List donations, most recent first", Tags: []string{"donations"},
+		Parameters: []openAPIParameter{
+			queryParam("donor_id", "integer", "Only this donor's", false),
+			queryParam("acknowledged", "boolean", "Only those already thanked, or with false those still to be", false),
+		},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: donation})
+	b.op("POST", apiPrefix+"/donations", openAPIOperation{
+		OperationID: "createDonation", Summary: "Record a batch of books a donor gave", Tags: []string{"donations"},
+		RequestBody: donationRequest,
+		Security:    bearerAuth,
+	}, "201", donation)
+	acknowledgments := jsonContent(&jsonSchema{Type: "array", Items: b.ref(Acknowledgment{})})
+	acknowledgments[csvType] = openAPIMedia{Schema: &jsonSchema{Type: "string"}}
+	b.op("GET", apiPrefix+"/donations/acknowledgments", openAPIOperation{
+		OperationID: "getAcknowledgments", Summary: "Letter data for the donations not yet acknowledged, as JSON or CSV", Tags: []string{"donations"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"200": {Description: "OK", Content: acknowledgments}},
+	}, "", nil)
+	b.op("GET", apiPrefix+"/donations/{id}", openAPIOperation{
+		OperationID: "getDonation", Summary: "Get a donation by ID", Tags: []string{"donations"},
+		Security: bearerAuth,
+	}, "200", donation)
+	b.op("PUT", apiPrefix+"/donations/{id}", openAPIOperation{
+		OperationID: "updateDonation", Summary: "Replace a donation's donor, date and notes", Tags: []string{"donations"},
+		RequestBody: donationRequest,
+		Security:    bearerAuth,
+	}, "200", donation)
+	b.op("DELETE", apiPrefix+"/donations/{id}", openAPIOperation{
+		OperationID: "deleteDonation", Summary: "Delete a donation no book was acquired in", Tags: []string{"donations"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("Books were acquired in the donation")},
+	}, "204", nil)
+	b.op("POST", apiPrefix+"/donations/{id}/acknowledge", openAPIOperation{
+		OperationID: "acknowledgeDonation", Summary: "Mark a donation's thank-you letter sent", Tags: []string{"donations"},
+		Security: bearerAuth,
+	}, "200", donation)
+
+	author := b.ref(Author{})
+	authorRequest := jsonBody(b.ref(AuthorRequest{}))
+	b.op("GET", apiPrefix+"/authors", openAPIOperation{
+		OperationID: "getAuthors", Summary: "List the catalog's authors with their book counts", Tags: []string{"authors"},
+		Parameters: []openAPIParameter{queryParam("include_empty", "boolean", "Also list authors with no books outside the trash", false)},
+	}, "200", &jsonSchema{Type: "array", Items: author})
+	b.op("POST", apiPrefix+"/authors", openAPIOperation{
+		OperationID: "createAuthor", Summary: "Add an author", Tags: []string{"authors"},
+		RequestBody: authorRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("An author with this name already exists")},
+	}, "201", author)
+	b.op("GET", apiPrefix+"/authors/{id}", openAPIOperation{
+		OperationID: "getAuthor", Summary: "Get an author by ID", Tags: []string{"authors"},
+	}, "200", author)
+	b.op("PUT", apiPrefix+"/authors/{id}", openAPIOperation{
+		OperationID: "updateAuthor", Summary: "Replace an author's name and bio, renaming it on their books", Tags: []string{"authors"},
+		RequestBody: authorRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("An author with this name already exists")},
+	}, "200", author)
+	b.op("DELETE", apiPrefix+"/authors/{id}", openAPIOperation{
+		OperationID: "deleteAuthor", Summary: "Remove an author with no books", Tags: []string{"authors"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The author still has books")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/authors/{id}/books", openAPIOperation{
+		OperationID: "listAuthorBooks", Summary: "An author's books by title", Tags: []string{"authors"},
+	}, "200", books)
+
+	publisher := b.ref(Publisher{})
+	publisherRequest := jsonBody(b.ref(PublisherRequest{}))
+	b.op("GET", apiPrefix+"/publishers", openAPIOperation{
+		OperationID: "getPublishers", Summary: "List the publishers with their book counts", Tags: []string{"publishers"},
+	}, "200", &jsonSchema{Type: "array", Items: publisher})
+	b.op("POST", apiPrefix+"/publishers", openAPIOperation{
+		OperationID: "createPublisher", Summary: "Add a publisher", Tags: []string{"publishers"},
+		RequestBody: publisherRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A publisher with this name already exists")},
+	}, "201", publisher)
+	b.op("GET", apiPrefix+"/publishers/{id}", openAPIOperation{
+		OperationID: "getPublisher", Summary: "Get a publisher by ID", Tags: []string{"publishers"},
+	}, "200", publisher)
+	b.op("PUT", apiPrefix+"/publishers/{id}", openAPIOperation{
+		OperationID: "updatePublisher", Summary: "Replace a publisher's name, country and founding year", Tags: []string{"publishers"},
+		RequestBody: publisherRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A publisher with this name already exists")},
+	}, "200", publisher)
+	b.op("DELETE", apiPrefix+"/publishers/{id}", openAPIOperation{
+		OperationID: "deletePublisher", Summary: "Remove a publisher with no books", Tags: []string{"publishers"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The publisher still has books")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/publishers/{id}/books", openAPIOperation{
+		OperationID: "listPublisherBooks", Summary: "A publisher's books by title", Tags: []string{"publishers"},
+	}, "200", books)
+
+	series := b.ref(Series{})
+	seriesRequest := jsonBody(b.ref(SeriesRequest{}))
+	b.op("GET", apiPrefix+"/series", openAPIOperation{
+		OperationID: "getSeriesList", Summary: "List the series with their book counts", Tags: []string{"series"},
+	}, "200", &jsonSchema{Type: "array", Items: series})
+	b.op("POST", apiPrefix+"/series", openAPIOperation{
+		OperationID: "createSeries", Summary: "Add a series", Tags: []string{"series"},
+		RequestBody: seriesRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A series with this name already exists")},
+	}, "201", series)
+	b.op("GET", apiPrefix+"/series/{id}", openAPIOperation{
+		OperationID: "getSeries", Summary: "Get a series by ID", Tags: []string{"series"},
+	}, "200", series)
+	b.op("PUT", apiPrefix+"/series/{id}", openAPIOperation{
+		OperationID: "updateSeries", Summary: "Replace a series' name and description", Tags: []string{"series"},
+		RequestBody: seriesRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A series with this name already exists")},
+	}, "200", series)
+	b.op("DELETE", apiPrefix+"/series/{id}", openAPIOperation{
+		OperationID: "deleteSeries", Summary: "Remove a series with no books", Tags: []string{"series"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The series still has books")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/series/{id}/books", openAPIOperation{
+		OperationID: "listSeriesBooks", Summary: "A series' books by volume", Tags: []string{"series"},
+	}, "200", books)
+	work := b.ref(Work{})
+	b.op("GET", apiPrefix+"/works", openAPIOperation{
+		OperationID: "getWorks", Summary: "List the works with editions in the catalog", Tags: []string{"works"},
+	}, "200", &jsonSchema{Type: "array", Items: work})
+	b.op("GET", apiPrefix+"/works/{id}", openAPIOperation{
+		OperationID: "getWork", Summary: "Get a work with its editions", Tags: []string{"works"},
+	}, "200", work)
+	b.op("GET", apiPrefix+"/locations", openAPIOperation{
+		OperationID: "getLocations", Summary: "List the shelf locations with their book counts", Tags: []string{"locations"},
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(Location{})})
+	b.op("GET", apiPrefix+"/locations/{code}/books", openAPIOperation{
+		OperationID: "getLocationBooks", Summary: "The books at a location in call number order, for shelf reading", Tags: []string{"locations"},
+		Parameters: filters,
+	}, "200", books)
+	library := b.ref(Library{})
+	libraryRequest := jsonBody(b.ref(LibraryRequest{}))
+	b.op("GET", apiPrefix+"/libraries", openAPIOperation{
+		OperationID: "getLibraries", Summary: "List the libraries, whose codes pick a catalog in X-Library", Tags: []string{"libraries"},
+	}, "200", &jsonSchema{Type: "array", Items: library})
+	b.op("POST", apiPrefix+"/libraries", openAPIOperation{
+		OperationID: "createLibrary", Summary: "Add a library with an empty catalog", Tags: []string{"libraries"},
+		RequestBody: libraryRequest,
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A library with this code already exists")},
+	}, "201", library)
+	b.op("GET", apiPrefix+"/libraries/{code}", openAPIOperation{
+		OperationID: "getLibrary", Summary: "Get a library by code", Tags: []string{"libraries"},
+	}, "200", library)
+	b.op("PUT", apiPrefix+"/libraries/{code}", openAPIOperation{
+		OperationID: "updateLibrary", Summary: "Rename a library", Tags: []string{"libraries"},
+		RequestBody: libraryRequest,
+		Security:    bearerAuth,
+	}, "200", library)
+	b.op("DELETE", apiPrefix+"/libraries/{code}", openAPIOperation{
+		OperationID: "deleteLibrary", Summary: "Remove a library with no books", Tags: []string{"libraries"},
+		Security:  bearerAuth,
+		Responses: map[string]*openAPIResponse{"409": textResponse("The library still has books")},
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/stats", openAPIOperation{
+		OperationID: "getCatalogStats", Summary: "Total books, books per decade, top authors and newest additions", Tags: []string{"books"},
+	}, "200", b.ref(CatalogStats{}))
+	b.op("GET", apiPrefix+"/suggest", openAPIOperation{
+		OperationID: "suggest", Summary: "Titles or authors starting with the typed text, for typeahead", Tags: []string{"books"},
+		Parameters: []openAPIParameter{
+			queryParam("q", "string", "Text typed so far", true),
+			queryParam("field", "string", "title or author; both when absent", false),
+			queryParam("limit", "integer", "Most suggestions to return, at most 20", false),
+		},
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(Suggestion{})})
+
+	b.op("POST", apiPrefix+"/users/{id}/interactions", openAPIOperation{
+		OperationID: "createInteraction", Summary: "Record a loan, shelf or favorite for a member", Tags: []string{"recommendations"},
+		RequestBody: jsonBody(b.ref(Interaction{})), Security: bearerAuth,
+		Responses: map[string]*openAPIResponse{"403": textResponse("Not the member, and not staff")},
+	}, "201", b.ref(Interaction{}))
+	b.op("GET", apiPrefix+"/users/{id}/recommendations", openAPIOperation{
+		OperationID: "listUserRecommendations", Summary: "Personal recommendations", Tags: []string{"recommendations"},
+		Parameters: []openAPIParameter{limit},
+	}, "200", books)
+
+	readingList := b.ref(ReadingList{})
+	listRequest := jsonBody(b.ref(ListRequest{}))
+	b.op("GET", apiPrefix+"/lists", openAPIOperation{
+		OperationID: "getLists", Summary: "The signed-in reader's reading lists", Tags: []string{"lists"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: readingList})
+	b.op("POST", apiPrefix+"/lists", openAPIOperation{
+		OperationID: "createList", Summary: "Create a reading list", Tags: []string{"lists"},
+		RequestBody: listRequest,
+		Security:    bearerAuth,
+	}, "201", readingList)
+	b.op("GET", apiPrefix+"/lists/{id}", openAPIOperation{
+		OperationID: "getReadingList", Summary: "A reading list with its books in order", Tags: []string{"lists"},
+	}, "200", readingList)
+	b.op("PUT", apiPrefix+"/lists/{id}", openAPIOperation{
+		OperationID: "updateList", Summary: "Rename a reading list", Tags: []string{"lists"},
+		RequestBody: listRequest,
+		Security:    bearerAuth,
+	}, "200", readingList)
+	b.op("DELETE", apiPrefix+"/lists/{id}", openAPIOperation{
+		OperationID: "deleteList", Summary: "Delete a reading list", Tags: []string{"lists"},
+		Security: bearerAuth,
+	}, "204", nil)
+	b.op("POST", apiPrefix+"/lists/{id}/books", openAPIOperation{
+		OperationID: "addListBook", Summary: "Add a book to the end of a reading list", Tags: []string{"lists"},
+		RequestBody: jsonBody(b.ref(ListEntryRequest{})),
+		Security:    bearerAuth,
+		Responses:   map[string]*openAPIResponse{"409": textResponse("The book is already on the list")},
+	}, "201", readingList)
+	b.op("PUT", apiPrefix+"/lists/{id}/books", openAPIOperation{
+		OperationID: "reorderList", Summary: "Put a reading list's books in a new order", Tags: []string{"lists"},
+		RequestBody: jsonBody(b.ref(ListOrder{})),
+		Security:    bearerAuth,
+	}, "200", readingList)
+	b.op("DELETE", apiPrefix+"/lists/{id}/books/{book_id}", openAPIOperation{
+		OperationID: "removeListBook", Summary: "Take a book off a reading list", Tags: []string{"lists"},
+		Security: bearerAuth,
+	}, "204", nil)
+
+	b.op("POST", apiPrefix+"/import", openAPIOperation{
+		OperationID: "importCatalog", Summary: "Create or update books from a CSV or JSON file, matched by ISBN", Tags: []string{"import"},
+		Parameters: []openAPIParameter{queryParam("dry_run", "boolean", "Report what would change without changing anything", false)},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMedia{
+			csvType:               {Schema: &jsonSchema{Type: "string"}},
+			"application/json":    {Schema: &jsonSchema{Type: "array", Items: book}},
+			ndjsonType:            {Schema: &jsonSchema{Type: "string"}},
+			"multipart/form-data": {Schema: objectSchema([]string{"file"}, &jsonSchema{Type: "string", Format: "binary"})},
+		}},
+		Responses: map[string]*openAPIResponse{"413": textResponse("Upload is larger than 10 MB")},
+		Security:  bearerAuth,
+	}, "200", b.ref(ImportReport{}))
+	b.op("POST", apiPrefix+"/import/goodreads", openAPIOperation{
+		OperationID: "importGoodreads", Summary: "Import a Goodreads or StoryGraph library export", Tags: []string{"import"},
+		Parameters: []openAPIParameter{queryParam("dry_run", "boolean", "Report what would change without changing anything", false)},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMedia{
+			csvType:               {Schema: &jsonSchema{Type: "string"}},
+			"multipart/form-data": {Schema: objectSchema([]string{"file"}, &jsonSchema{Type: "string", Format: "binary"})},
+		}},
+		Security: bearerAuth,
+	}, "200", b.ref(ImportReport{}))
+
+	b.op("GET", apiPrefix+"/export", openAPIOperation{
+		OperationID: "exportDatabase", Summary: "Export every record for backups and migrations", Tags: []string{"import"},
+		Parameters: []openAPIParameter{queryParam("format", "string", "ndjson (default) or json, for a single array", false)},
+		Responses: map[string]*openAPIResponse{"200": {Description: "Records, the first naming the schema version", Content: map[string]openAPIMedia{
+			ndjsonType:         {Schema: &jsonSchema{Type: "string"}},
+			"application/json": {Schema: &jsonSchema{Type: "array", Items: b.ref(ExportRecord{})}},
+		}}},
+		Security: bearerAuth,
+	}, "", nil)
+
+	order := b.ref(Order{})
+	b.op("POST", apiPrefix+"/orders", openAPIOperation{
+		OperationID: "createOrder", Summary: "Place an order for priced books", Tags: []string{"orders"},
+		RequestBody: jsonBody(b.ref(OrderRequest{})),
+		Security:    bearerAuth,
+	}, "201", order)
+	b.op("GET", apiPrefix+"/orders/{id}", openAPIOperation{
+		OperationID: "getOrder", Summary: "Get an order by ID", Tags: []string{"orders"},
+		Security: bearerAuth,
+	}, "200", order)
+	b.op("POST", apiPrefix+"/orders/{id}/checkout", openAPIOperation{
+		OperationID: "checkoutOrder", Summary: "Open a hosted checkout for a pending order", Tags: []string{"orders"},
+		Security: bearerAuth,
+	}, "200", order)
+	b.op("POST", apiPrefix+"/orders/{id}/cancel", openAPIOperation{
+		OperationID: "cancelOrder", Summary: "Cancel a pending order", Tags: []string{"orders"},
+		Security: bearerAuth,
+	}, "200", order)
+	b.op("POST", apiPrefix+"/payments/webhook", openAPIOperation{
+		OperationID: "paymentWebhook", Summary: "Receive payment provider events, verified by their signature", Tags: []string{"orders"},
+		RequestBody: jsonBody(&jsonSchema{Type: "object"}),
+	}, "200", objectSchema([]string{"received"}, &jsonSchema{Type: "boolean"}))
+
+	webhook := b.ref(Webhook{})
+	webhookRequest := jsonBody(b.ref(WebhookRequest{}))
+	b.op("POST", apiPrefix+"/webhooks", openAPIOperation{
+		OperationID: "createWebhook", Summary: "Register a webhook for book changes", Tags: []string{"webhooks"},
+		RequestBody: webhookRequest,
+		Security:    bearerAuth,
+	}, "201", b.ref(NewWebhook{}))
+	b.op("GET", apiPrefix+"/webhooks", openAPIOperation{
+		OperationID: "listWebhooks", Summary: "Registered webhooks", Tags: []string{"webhooks"},
+		Security: bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: webhook})
+	b.op("GET", apiPrefix+"/webhooks/{id}", openAPIOperation{
+		OperationID: "getWebhook", Summary: "Get a webhook by ID", Tags: []string{"webhooks"},
+		Security: bearerAuth,
+	}, "200", webhook)
+	b.op("PUT", apiPrefix+"/webhooks/{id}", openAPIOperation{
+		OperationID: "updateWebhook", Summary: "Replace a webhook's URL, events and status", Tags: []string{"webhooks"},
+		RequestBody: webhookRequest,
+		Security:    bearerAuth,
+	}, "200", webhook)
+	b.op("DELETE", apiPrefix+"/webhooks/{id}", openAPIOperation{
+		OperationID: "deleteWebhook", Summary: "Remove a webhook and its deliveries", Tags: []string{"webhooks"},
+		Security: bearerAuth,
+	}, "204", nil)
+	b.op("GET", apiPrefix+"/webhooks/{id}/deliveries", openAPIOperation{
+		OperationID: "listWebhookDeliveries", Summary: "A webhook's latest deliveries, newest first", Tags: []string{"webhooks"},
+		Parameters: []openAPIParameter{queryParam("status", "string", "Only deliveries with this status", false)},
+		Security:   bearerAuth,
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(WebhookDelivery{})})
+
+	b.op("POST", apiPrefix+"/auth/login", openAPIOperation{
+		OperationID: "login", Summary: "Exchange a username and password for a token", Tags: []string{"auth"},
+		RequestBody: jsonBody(b.ref(LoginRequest{})),
+		Responses:   map[string]*openAPIResponse{"401": textResponse("Invalid username or password")},
+	}, "200", b.ref(LoginResponse{}))
+	b.op("GET", apiPrefix+"/auth/me", openAPIOperation{
+		OperationID: "getCurrentUser", Summary: "The signed-in user", Tags: []string{"auth"},
+		Security: bearerAuth,
+	}, "200", b.ref(Principal{}))
+	b.op("GET", apiPrefix+"/auth/saml/metadata", openAPIOperation{
+		OperationID: "getSAMLMetadata", Summary: "SAML service provider metadata", Tags: []string{"auth"},
+		Responses: map[string]*openAPIResponse{"200": {Description: "OK", Content: map[string]openAPIMedia{
+			"application/samlmetadata+xml": {Schema: &jsonSchema{Type: "string"}},
+		}}},
+	}, "", nil)
+	b.op("GET", apiPrefix+"/auth/saml/login", openAPIOperation{
+		OperationID: "startSAMLLogin", Summary: "Redirect to the identity provider to sign in", Tags: []string{"auth"},
+		Parameters: []openAPIParameter{queryParam("redirect", "string", "Frontend path to return to", false)},
+		Responses:  map[string]*openAPIResponse{"302": {Description: "Redirect to the identity provider"}},
+	}, "", nil)
+	b.op("POST", apiPrefix+"/auth/saml/acs", openAPIOperation{
+		OperationID: "samlACS", Summary: "Receive the identity provider's SAML response", Tags: []string{"auth"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMedia{
+			"application/x-www-form-urlencoded": {Schema: objectSchema([]string{"SAMLResponse"}, &jsonSchema{Type: "string"})},
+		}},
+		Responses: map[string]*openAPIResponse{"303": {Description: "Redirect to the frontend with a token in the fragment"}},
+	}, "", nil)
+
+	admin := func(method, path string, o openAPIOperation, status string, response *jsonSchema) {
+		o.Tags, o.Security = []string{"admin"}, bearerAuth
+		b.op(method, apiPrefix+"/admin"+path, o, status, response)
+	}
+	admin("GET", "/dashboard", openAPIOperation{
+		OperationID: "getDashboard", Summary: "Catalog, order and storage figures for the admin home screen",
+		Parameters: []openAPIParameter{queryParam("days", "integer", "Days of growth to chart", false)},
+	}, "200", b.ref(Dashboard{}))
+	admin("GET", "/scheduled-jobs", openAPIOperation{
+		OperationID: "listScheduledJobs", Summary: "Scheduled jobs with their schedules and latest runs",
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(ScheduledJobInfo{})})
+	run := b.ref(ScheduledRun{})
+	admin("GET", "/scheduled-jobs/{name}/runs", openAPIOperation{
+		OperationID: "listScheduledRuns", Summary: "A job's latest runs",
+	}, "200", &jsonSchema{Type: "array", Items: run})
+	admin("POST", "/scheduled-jobs/{name}/run", openAPIOperation{
+		OperationID: "runScheduledJob", Summary: "Run a job now",
+		Responses: map[string]*openAPIResponse{"409": textResponse("Job is already running")},
+	}, "202", run)
+	admin("GET", "/orders", openAPIOperation{
+		OperationID: "listOrders", Summary: "All orders, newest first",
+		Parameters: []openAPIParameter{queryParam("status", "string", "Only orders with this status", false)},
+	}, "200", &jsonSchema{Type: "array", Items: order})
+	admin("POST", "/orders/{id}/fulfill", openAPIOperation{
+		OperationID: "fulfillOrder", Summary: "Mark a paid order fulfilled",
+	}, "200", order)
+	admin("POST", "/search/reindex", openAPIOperation{
+		OperationID: "reindexSearch", Summary: "Rebuild the search index in the background",
+	}, "202", objectSchema([]string{"status"}, &jsonSchema{Type: "string"}))
+	job := b.ref(RefreshJob{})
+	admin("POST", "/metadata-refresh", openAPIOperation{
+		OperationID: "createRefreshJob", Summary: "Start a metadata refresh over all or some books",
+		RequestBody: &openAPIRequestBody{Content: jsonContent(b.ref(RefreshJobRequest{}))},
+		Responses:   map[string]*openAPIResponse{"409": textResponse("A refresh is already running")},
+	}, "202", job)
+	admin("GET", "/metadata-refresh", openAPIOperation{
+		OperationID: "listRefreshJobs", Summary: "Metadata refresh jobs",
+	}, "200", &jsonSchema{Type: "array", Items: job})
+	admin("GET", "/metadata-refresh/{id}", openAPIOperation{
+		OperationID: "getRefreshJob", Summary: "A metadata refresh job's progress",
+	}, "200", job)
+	admin("GET", "/metadata-refresh/{id}/conflicts", openAPIOperation{
+		OperationID: "listRefreshConflicts", Summary: "Fields the provider disagreed with and the job kept",
+	}, "200", &jsonSchema{Type: "array", Items: b.ref(RefreshConflict{})})
+	admin("POST", "/metadata-refresh/{id}/resume", openAPIOperation{
+		OperationID: "resumeRefreshJob", Summary: "Resume a stopped job from its checkpoint",
+	}, "202", job)
+	admin("POST", "/metadata-refresh/{id}/cancel", openAPIOperation{
+		OperationID: "cancelRefreshJob", Summary: "Stop a running job",
+		Responses: map[string]*openAPIResponse{"202": {Description: "Stopping"}},
+	}, "", nil)
+
+	b.op("GET", apiPrefix+"/lookup", openAPIOperation{
+		OperationID: "lookupISBN", Summary: "Look up an ISBN on Google Books to prefill a book", Tags: []string{"lookups"},
+		Parameters: []openAPIParameter{queryParam("isbn", "string", "ISBN-10 or ISBN-13, dashes optional", true)},
+		Responses:  map[string]*openAPIResponse{"404": textResponse("Google Books has no volume with the ISBN")},
+	}, "200", b.ref(GoogleVolume{}))
+	b.op("POST", apiPrefix+"/lookup/barcode-image", openAPIOperation{
+		OperationID: "lookupBarcodeImage", Summary: "Find the book for an ISBN barcode in a photo", Tags: []string{"lookups"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMedia{
+			"image/*":             binaryContent("image/*")["image/*"],
+			"multipart/form-data": {Schema: objectSchema([]string{"image"}, &jsonSchema{Type: "string", Format: "binary"})},
+		}},
+		Responses: map[string]*openAPIResponse{
+			"404": {Description: "No book has the ISBN", Content: jsonContent(objectSchema([]string{"isbn", "error"}, &jsonSchema{Type: "string"}, &jsonSchema{Type: "string"}))},
+			"413": textResponse("Image has too many pixels to decode"),
+			"415": textResponse("Not an accepted image type"),
+			"422": textResponse("No ISBN barcode found in the image"),
+		},
+	}, "200", b.ref(BarcodeLookup{}))
+	b.op("GET", apiPrefix+"/external/google-books", openAPIOperation{
+		OperationID: "searchGoogleBooks", Summary: "Search Google Books", Tags: []string{"lookups"},
+		Parameters: []openAPIParameter{queryParam("q", "string", "Search terms", true), start, limit},
+	}, "200", b.ref(GoogleBooksResults{}))
+	b.op("GET", apiPrefix+"/external/sru", openAPIOperation{
+		OperationID: "searchSRU", Summary: "Search the SRU catalog by ISBN or title", Tags: []string{"lookups"},
+		Parameters: []openAPIParameter{
+			queryParam("isbn", "string", "ISBN to find", false),
+			queryParam("title", "string", "Title to find, when no ISBN is given", false),
+			start, limit,
+		},
+	}, "200", b.ref(SRUResults{}))
+
+	graphQL := b.ref(GraphQLResponse{})
+	graphQLRequest := objectSchema([]string{"query", "variables", "operationName"},
+		&jsonSchema{Type: "string"}, &jsonSchema{Type: "object"}, &jsonSchema{Type: "string"})
+	graphQLRequest.Required = []string{"query"}
+	graphQLErrors := map[string]*openAPIResponse{"400": {Description: "The document could not run", Content: jsonContent(graphQL)}}
+	b.op("GET", "/graphql", openAPIOperation{
+		OperationID: "queryGraphQL", Summary: "Run a GraphQL query", Tags: []string{"graphql"},
+		Parameters: []openAPIParameter{
+			queryParam("query", "string", "GraphQL document", true),
+			queryParam("variables", "string", "Variables, as a JSON object", false),
+			queryParam("operationName", "string", "Operation to run, for documents with several", false),
+		},
+		Responses: graphQLErrors,
+	}, "200", graphQL)
+	b.op("POST", "/graphql", openAPIOperation{
+		OperationID: "postGraphQL", Summary: "Run a GraphQL query or mutation", Tags: []string{"graphql"},
+		RequestBody: jsonBody(graphQLRequest),
+		Responses:   graphQLErrors,
+	}, "200", graphQL)
+
+	b.op("GET", "/feeds/books.atom", openAPIOperation{
+		OperationID: "getBooksFeed", Summary: "Atom feed of the most recently added books", Tags: []string{"books"},
+		Parameters: []openAPIParameter{queryParam("limit", "integer", "Entries in the feed, up to 100 (default 20)", false)},
+		Responses: map[string]*openAPIResponse{"200": {Description: "OK", Content: map[string]openAPIMedia{
+			atomType: {Schema: &jsonSchema{Type: "string"}},
+		}}},
+	}, "", nil)
+
+	b.op("GET", "/health", openAPIOperation{
+		OperationID: "health", Summary: "Health check",
+	}, "200", b.ref(HealthStatus{}))
+	readiness := b.ref(Readiness{})
+	b.op("GET", "/readyz", openAPIOperation{
+		OperationID: "readiness", Summary: "Readiness: the database and replication checks",
+		Responses: map[string]*openAPIResponse{"503": {Description: "A check failed", Content: jsonContent(readiness)}},
+	}, "200", readiness)
+
+	return b.doc
+}
+
+// Serve the OpenAPI description, pointing its server at the host the
+// client reached, so "Try it out" in the docs calls this API
+func serveOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	spec := buildOpenAPISpec()
+	spec.Servers = []openAPIServer{{URL: requestOrigin(r)}}
+	writeJSON(w, http.StatusOK, spec)
+}
+
+// Swagger UI page for /openapi.json. The UI's script and styles come from
+// the swagger-ui-dist package on unpkg, pinned to a major version.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Books API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: '/openapi.json', dom_id: '#swagger-ui' });
+  </script>
+</body>
+</html>
+`
+
+// Serve the interactive API docs
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, swaggerUIPage)
+}
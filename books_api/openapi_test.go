@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Every operation in the spec must be served by the router, and every
+// route described by the spec
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	routes := map[string]bool{}
+	newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			routes[m+" "+path] = true
+		}
+		return nil
+	})
+
+	spec := buildOpenAPISpec()
+	ids := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method, op := range ops {
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("Spec has %s %s but the router does not", strings.ToUpper(method), path)
+			}
+			if ids[op.OperationID] {
+				t.Errorf("Duplicate operationId %s", op.OperationID)
+			}
+			ids[op.OperationID] = true
+		}
+	}
+
+	// Preflights and HEAD are implied; the spec and docs don't describe
+	// themselves, presigned blob URLs are handed out, not called, the
+	// WebSocket isn't a request OpenAPI can describe, and the spec
+	// describes v1, which later versions mirror
+	for route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if method == "OPTIONS" || method == "HEAD" || path == "/openapi.json" || path == "/docs" || strings.HasPrefix(path, "/blobs/") || strings.HasSuffix(path, "/ws") {
+			continue
+		}
+		if strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, apiPrefix+"/") {
+			continue
+		}
+		if spec.Paths[path][strings.ToLower(method)] == nil {
+			t.Errorf("Router serves %s but the spec does not describe it", route)
+		}
+	}
+}
+
+func TestServeOpenAPISpec(t *testing.T) {
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	req.Host = "books.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Servers []openAPIServer            `json:"servers"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &spec); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("Expected the spec, got %d: %s", response.Code, response.Body.String())
+	}
+	if spec.OpenAPI != "3.0.3" || len(spec.Servers) != 1 || spec.Servers[0].URL != "https://books.example.com" || spec.Paths["/api/v1/books"] == nil {
+		t.Errorf("Unexpected spec %s %+v", spec.OpenAPI, spec.Servers)
+	}
+
+	req, _ = http.NewRequest("GET", "/docs", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if !strings.HasPrefix(response.Header().Get("Content-Type"), "text/html") || !strings.Contains(response.Body.String(), "url: '/openapi.json'") {
+		t.Errorf("Expected the Swagger UI page, got %q: %s", response.Header().Get("Content-Type"), response.Body.String())
+	}
+}
+
+// Error responses carry their bodies' shapes
+func TestOpenAPIErrorShapes(t *testing.T) {
+	spec := buildOpenAPISpec()
+	create := spec.Paths["/api/v1/books"]["post"]
+	invalid := create.Responses["400"]
+	if invalid == nil || invalid.Content["application/json"].Schema.Ref != "#/components/schemas/ValidationErrors" || invalid.Content["text/plain"].Schema == nil {
+		t.Errorf("Expected 400 as field errors or text, got %+v", invalid)
+	}
+	if create.Responses["401"] == nil || len(create.Security) == 0 {
+		t.Error("Expected book writes to need a token")
+	}
+	if update := spec.Paths["/api/v1/books/{id}"]["put"]; update.Responses["412"] == nil || update.Responses["404"] == nil {
+		t.Errorf("Expected 404 and 412 on updates, got %v", update.Responses)
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	book := buildOpenAPISpec().Components.Schemas["Book"]
+	if book == nil {
+		t.Fatal("Expected a Book schema")
+	}
+	if _, ok := book.Properties["CoverKey"]; ok {
+		t.Error("Expected fields hidden from JSON to be left out")
+	}
+	if tags := book.Properties["tags"]; tags == nil || tags.Items.Ref != "#/components/schemas/Tag" {
+		t.Errorf("Expected tags to reference Tag, got %+v", tags)
+	}
+	for _, name := range book.Required {
+		if name == "tags" {
+			t.Error("Expected omitempty fields to be optional")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default back-off when Open Library throttles without a Retry-After
+const openLibraryDefaultBackoff = time.Minute
+
+// openLibraryProvider fetches metadata from the Open Library Books API
+type openLibraryProvider struct {
+	baseURL string
+	client  *http.Client
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+func newOpenLibraryProvider(baseURL string, timeout time.Duration) *openLibraryProvider {
+	return &openLibraryProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *openLibraryProvider) Name() string {
+	return "openlibrary"
+}
+
+// Subset of the jscmd=data response we use
+type openLibraryBook struct {
+	Title       string `json:"title"`
+	Subtitle    string `json:"subtitle"`
+	PublishDate string `json:"publish_date"`
+	Authors     []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	Cover struct {
+		Small  string `json:"small"`
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+	Notes    json.RawMessage `json:"notes"`
+	Excerpts []struct {
+		Text string `json:"text"`
+	} `json:"excerpts"`
+}
+
+func (p *openLibraryProvider) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	if wait := p.backoff(); wait > 0 {
+		return nil, &RateLimitError{Provider: p.Name(), RetryAfter: wait}
+	}
+
+	key := "ISBN:" + isbn
+	q := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/api/books?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "books_api (+https://github.com/adrian-delgado-q/Playwright-API-Frontend)")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := parseRetryAfter(resp.Header.Get("Retry-After"), openLibraryDefaultBackoff)
+		p.block(wait)
+		return nil, &RateLimitError{Provider: p.Name(), RetryAfter: wait}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("openlibrary: unexpected status %d", resp.StatusCode)
+	}
+
+	var result map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("openlibrary: decoding response: %w", err)
+	}
+	book, ok := result[key]
+	if !ok {
+		return nil, ErrMetadataNotFound
+	}
+
+	return book.metadata(), nil
+}
+
+func (b openLibraryBook) metadata() *BookMetadata {
+	meta := &BookMetadata{
+		Title: b.Title,
+		Year:  parseYear(b.PublishDate),
+	}
+	if b.Subtitle != "" {
+		meta.Title += ": " + b.Subtitle
+	}
+
+	names := make([]string, 0, len(b.Authors))
+	for _, a := range b.Authors {
+		names = append(names, a.Name)
+	}
+	meta.Author = strings.Join(names, ", ")
+	if len(b.Publishers) > 0 {
+		meta.Publisher = b.Publishers[0].Name
+	}
+
+	switch {
+	case b.Cover.Large != "":
+		meta.CoverURL = b.Cover.Large
+	case b.Cover.Medium != "":
+		meta.CoverURL = b.Cover.Medium
+	default:
+		meta.CoverURL = b.Cover.Small
+	}
+
+	// Notes are either a plain string or a {"type", "value"} text object
+	var notes string
+	if json.Unmarshal(b.Notes, &notes) != nil {
+		var text struct {
+			Value string `json:"value"`
+		}
+		json.Unmarshal(b.Notes, &text)
+		notes = text.Value
+	}
+	meta.Description = notes
+	if meta.Description == "" && len(b.Excerpts) > 0 {
+		meta.Description = b.Excerpts[0].Text
+	}
+
+	return meta
+}
+
+// Remaining time before we may call the API again
+func (p *openLibraryProvider) backoff() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Until(p.blockedUntil)
+}
+
+func (p *openLibraryProvider) block(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blockedUntil = time.Now().Add(d)
+}
+
+var yearPattern = regexp.MustCompile(`(?:^|\D)(\d{4})(?:\D|$)`)
+
+// Extract a four-digit year from free-form dates such as "March 2008"
+func parseYear(s string) int {
+	m := yearPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	year, _ := strconv.Atoi(m[1])
+	return year
+}
+
+// Parse a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(v string, def time.Duration) time.Duration {
+	if v == "" {
+		return def
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return def
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const openLibraryFixture = `{
+  "ISBN:9780132350884": {
+    "title": "Clean Code",
+    "subtitle": "A Handbook of Agile Software Craftsmanship",
+    "publish_date": "August 2008",
+    "authors": [{"name": "Robert C. Martin"}],
+    "publishers": [{"name": "Prentice Hall"}],
+    "cover": {"small": "https://covers.example/s.jpg", "large": "https://covers.example/l.jpg"},
+    "notes": {"type": "/type/text", "value": "Includes index."}
+  }
+}`
+
+func newOpenLibraryTestServer(t *testing.T, handler http.HandlerFunc) *openLibraryProvider {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return newOpenLibraryProvider(srv.URL, time.Second)
+}
+
+func TestOpenLibraryLookup(t *testing.T) {
+	p := newOpenLibraryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("bibkeys"); got != "ISBN:9780132350884" {
+			t.Errorf("Unexpected bibkeys %q", got)
+		}
+		w.Write([]byte(openLibraryFixture))
+	})
+
+	meta, err := p.LookupISBN(context.Background(), "9780132350884")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if meta.Title != "Clean Code: A Handbook of Agile Software Craftsmanship" {
+		t.Errorf("Unexpected title %q", meta.Title)
+	}
+	if meta.Author != "Robert C. Martin" {
+		t.Errorf("Unexpected author %q", meta.Author)
+	}
+	if meta.Year != 2008 {
+		t.Errorf("Expected year 2008, got %d", meta.Year)
+	}
+	if meta.CoverURL != "https://covers.example/l.jpg" {
+		t.Errorf("Expected large cover, got %q", meta.CoverURL)
+	}
+	if meta.Description != "Includes index." {
+		t.Errorf("Unexpected description %q", meta.Description)
+	}
+	if meta.Publisher != "Prentice Hall" {
+		t.Errorf("Unexpected publisher %q", meta.Publisher)
+	}
+}
+
+func TestOpenLibraryNotFound(t *testing.T) {
+	p := newOpenLibraryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+
+	if _, err := p.LookupISBN(context.Background(), "9780000000000"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected ErrMetadataNotFound, got %v", err)
+	}
+}
+
+func TestOpenLibraryRateLimitBacksOff(t *testing.T) {
+	calls := 0
+	p := newOpenLibraryTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := p.LookupISBN(context.Background(), "9780132350884")
+		var rle *RateLimitError
+		if !errors.As(err, &rle) {
+			t.Fatalf("Expected RateLimitError, got %v", err)
+		}
+		if rle.RetryAfter <= 0 || rle.RetryAfter > 30*time.Second {
+			t.Errorf("Unexpected retry-after %s", rle.RetryAfter)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the second lookup to be short-circuited, got %d upstream calls", calls)
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	cases := map[string]int{
+		"2008":          2008,
+		"August 2008":   2008,
+		"c1994.":        1994,
+		"":              0,
+		"no year given": 0,
+	}
+	for in, want := range cases {
+		if got := parseYear(in); got != want {
+			t.Errorf("parseYear(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
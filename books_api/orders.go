@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Order statuses
+const (
+	orderPending   = "pending" // waiting for payment
+	orderPaid      = "paid"
+	orderFulfilled = "fulfilled"
+	orderCancelled = "cancelled"
+	orderRefunded  = "refunded"
+)
+
+// Status changes an order may go through. Anything else is refused.
+var orderTransitions = map[string][]string{
+	orderPending:   {orderPaid, orderCancelled},
+	orderPaid:      {orderFulfilled, orderRefunded},
+	orderFulfilled: {orderRefunded},
+}
+
+// Domain event types for orders
+const (
+	eventOrderPaid      = "order.paid"
+	eventOrderFulfilled = "order.fulfilled"
+	eventOrderCancelled = "order.cancelled"
+	eventOrderRefunded  = "order.refunded"
+)
+
+var orderEvents = map[string]string{
+	orderPaid:      eventOrderPaid,
+	orderFulfilled: eventOrderFulfilled,
+	orderCancelled: eventOrderCancelled,
+	orderRefunded:  eventOrderRefunded,
+}
+
+// Most copies of one book in an order
+const maxOrderQuantity = 100
+
+// Order model, a purchase of one or more books. Prices are copied from the
+// catalog when the order is placed, so later price changes don't affect it.
+type Order struct {
+	ID              uint        `json:"id" gorm:"primaryKey"`
+	Status          string      `json:"status" gorm:"index;not null"`
+	Email           string      `json:"email,omitempty"`
+	Username        string      `json:"-" gorm:"index"`
+	Currency        string      `json:"currency"`
+	TotalCents      int64       `json:"total_cents"`
+	Items           []OrderItem `json:"items"`
+	PaymentProvider string      `json:"payment_provider,omitempty"`
+	PaymentRef      string      `json:"-" gorm:"index"`
+	PaymentID       string      `json:"-" gorm:"index"`
+	CheckoutURL     string      `json:"checkout_url,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	PaidAt          *time.Time  `json:"paid_at,omitempty"`
+}
+
+// OrderItem model, one line of an order
+type OrderItem struct {
+	ID             uint   `json:"-" gorm:"primaryKey"`
+	OrderID        uint   `json:"-" gorm:"index;not null"`
+	BookID         uint   `json:"book_id" gorm:"not null"`
+	Title          string `json:"title"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+	SubtotalCents  int64  `json:"subtotal_cents"`
+}
+
+// OrderRequest is the body of POST /orders
+type OrderRequest struct {
+	Email string             `json:"email,omitempty"`
+	Items []OrderItemRequest `json:"items"`
+}
+
+// OrderItemRequest asks for copies of one book
+type OrderItemRequest struct {
+	BookID   uint `json:"book_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// Whether the order may move to status
+func (o *Order) canTransition(status string) bool {
+	for _, s := range orderTransitions[o.Status] {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Move an order to status within tx, recording a domain event
+func transitionOrder(tx *gorm.DB, o *Order, status string) error {
+	if !o.canTransition(status) {
+		return fmt.Errorf("order %d is %s and can't become %s", o.ID, o.Status, status)
+	}
+	o.Status = status
+	if status == orderPaid {
+		now := time.Now()
+		o.PaidAt = &now
+	}
+	if err := tx.Omit("Items").Save(o).Error; err != nil {
+		return err
+	}
+	return recordEvent(tx, orderEvents[status], fmt.Sprintf("order:%d", o.ID), o)
+}
+
+// Build an order from a request, pricing each line from the catalog
+func buildOrder(ctx context.Context, req *OrderRequest) (*Order, []FieldError) {
+	var errs []FieldError
+	if req.Email != "" {
+		if _, err := mail.ParseAddress(req.Email); err != nil {
+			errs = append(errs, FieldError{Field: "email", Message: "is not a valid address"})
+		}
+	}
+	if len(req.Items) == 0 {
+		errs = append(errs, FieldError{Field: "items", Message: "must not be empty"})
+	}
+
+	order := &Order{Status: orderPending, Email: req.Email, Currency: cfg.StoreCurrency}
+	for i, item := range req.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if item.Quantity < 1 || item.Quantity > maxOrderQuantity {
+			errs = append(errs, FieldError{Field: field + ".quantity", Message: fmt.Sprintf("must be between 1 and %d", maxOrderQuantity)})
+			continue
+		}
+		var book Book
+		if err := db.WithContext(ctx).First(&book, item.BookID).Error; err != nil {
+			errs = append(errs, FieldError{Field: field + ".book_id", Message: "does not exist"})
+			continue
+		}
+		if book.PriceCents == 0 {
+			errs = append(errs, FieldError{Field: field + ".book_id", Message: "is not for sale"})
+			continue
+		}
+		line := OrderItem{
+			BookID:         book.ID,
+			Title:          book.Title,
+			Quantity:       item.Quantity,
+			UnitPriceCents: book.PriceCents,
+			SubtotalCents:  book.PriceCents * int64(item.Quantity),
+		}
+		order.Items = append(order.Items, line)
+		order.TotalCents += line.SubtotalCents
+	}
+	return order, errs
+}
+
+// Place an order. It stays pending until paid through checkout.
+func createOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	order, errs := buildOrder(r.Context(), &req)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if p := principalFrom(r); p != nil {
+		order.Username = p.Username
+		if order.Email == "" {
+			order.Email = p.Email
+		}
+	}
+
+	if err := db.Create(order).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create order")
+		return
+	}
+	writeJSON(w, http.StatusCreated, order)
+}
+
+// Load the order named in the URL. Orders belong to the user who placed
+// them; others get a 404, as if it didn't exist, unless they are staff.
+func loadOrder(w http.ResponseWriter, r *http.Request) (*Order, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_order_id", "Invalid order ID")
+		return nil, false
+	}
+	var order Order
+	if err := db.Preload("Items").First(&order, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "order_not_found", "Order not found")
+		return nil, false
+	}
+	if authEnabled() {
+		p := principalFrom(r)
+		if p == nil || (p.Username != order.Username && !p.HasRole(roleLibrarian)) {
+			writeError(w, r, http.StatusNotFound, "order_not_found", "Order not found")
+			return nil, false
+		}
+	}
+	return &order, true
+}
+
+// Get an order by ID
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if order, ok := loadOrder(w, r); ok {
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+// Start paying for a pending order. The response carries the provider's
+// checkout_url to send the customer to.
+func checkoutOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if paymentProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Payments are not enabled")
+		return
+	}
+	order, ok := loadOrder(w, r)
+	if !ok {
+		return
+	}
+	if order.Status != orderPending {
+		writeError(w, r, http.StatusConflict, "invalid_order_status", "Order is "+order.Status)
+		return
+	}
+
+	success, cancel := checkoutReturnURL(order.ID, "success"), checkoutReturnURL(order.ID, "cancel")
+	checkout, err := paymentProvider.CreateCheckout(r.Context(), order, success, cancel)
+	if err != nil {
+		log.Printf("Creating checkout for order %d failed: %v", order.ID, err)
+		writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Payment provider unavailable")
+		return
+	}
+	order.PaymentProvider = paymentProvider.Name()
+	order.PaymentRef = checkout.Ref
+	order.CheckoutURL = checkout.URL
+	db.Omit("Items").Save(order)
+	writeJSON(w, http.StatusOK, order)
+}
+
+// Page the customer returns to after checkout, with the order and outcome
+// added to the query
+func checkoutReturnURL(orderID uint, outcome string) string {
+	u, err := url.Parse(cfg.CheckoutReturnURL)
+	if err != nil {
+		return cfg.CheckoutReturnURL
+	}
+	q := u.Query()
+	q.Set("order", strconv.FormatUint(uint64(orderID), 10))
+	q.Set("checkout", outcome)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Cancel a pending order, closing any open checkout first so it can no
+// longer be paid
+func cancelOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	order, ok := loadOrder(w, r)
+	if !ok {
+		return
+	}
+	if !order.canTransition(orderCancelled) {
+		writeError(w, r, http.StatusConflict, "invalid_order_status", "Order is "+order.Status)
+		return
+	}
+	if order.PaymentRef != "" && paymentProvider != nil {
+		if err := paymentProvider.ExpireCheckout(r.Context(), order.PaymentRef); err != nil {
+			log.Printf("Closing checkout for order %d failed: %v", order.ID, err)
+			writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Payment provider unavailable")
+			return
+		}
+	}
+	order.PaymentRef, order.CheckoutURL = "", ""
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return transitionOrder(tx, order, orderCancelled)
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to cancel order")
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// List orders, newest first, optionally filtered by ?status=
+func getOrders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := db.Preload("Items").Order("id DESC")
+	if status := r.URL.Query().Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var orders []Order
+	q.Find(&orders)
+	writeList(w, r, orders)
+}
+
+// Mark a paid order as shipped or handed over
+func fulfillOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	order, ok := loadOrder(w, r)
+	if !ok {
+		return
+	}
+	if !order.canTransition(orderFulfilled) {
+		writeError(w, r, http.StatusConflict, "invalid_order_status", "Order is "+order.Status)
+		return
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return transitionOrder(tx, order, orderFulfilled)
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update order")
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// Apply a verified payment provider event to its order. Providers retry
+// deliveries, so applying the same event twice is harmless.
+func reconcilePayment(ev *PaymentEvent) error {
+	var order Order
+	var err error
+	if ev.OrderID != 0 {
+		err = db.First(&order, ev.OrderID).Error
+	} else {
+		err = db.First(&order, "payment_id = ?", ev.PaymentID).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Ignoring %s payment event for an unknown order", ev.Type)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		switch ev.Type {
+		case paymentSucceeded:
+			if order.Status != orderPending {
+				if order.PaymentID != ev.PaymentID {
+					log.Printf("Payment %s received for order %d, which is %s; refund it", ev.PaymentID, order.ID, order.Status)
+				}
+				return nil
+			}
+			if ev.AmountCents != order.TotalCents || !strings.EqualFold(ev.Currency, order.Currency) {
+				log.Printf("Payment %s for order %d is %d %s, expected %d %s; left pending",
+					ev.PaymentID, order.ID, ev.AmountCents, ev.Currency, order.TotalCents, order.Currency)
+				return nil
+			}
+			order.PaymentID = ev.PaymentID
+			order.CheckoutURL = ""
+			return transitionOrder(tx, &order, orderPaid)
+
+		case paymentExpired:
+			// Only the latest checkout counts; the customer can start another
+			if order.Status == orderPending && order.PaymentRef == ev.Ref {
+				order.PaymentRef, order.CheckoutURL = "", ""
+				return tx.Omit("Items").Save(&order).Error
+			}
+
+		case paymentRefunded:
+			if order.canTransition(orderRefunded) {
+				return transitionOrder(tx, &order, orderRefunded)
+			}
+		}
+		return nil
+	})
+}
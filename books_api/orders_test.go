@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createTestOrder(t *testing.T, router http.Handler, token string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/api/v1/orders", bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestCreateOrder(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	priced := Book{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884", PriceCents: 3499}
+	unpriced := Book{Title: "Refactoring", Author: "Martin Fowler", ISBN: "9780201485677"}
+	db.Create(&priced)
+	db.Create(&unpriced)
+
+	body := fmt.Sprintf(`{"email":"ann@example.com","items":[{"book_id":%d,"quantity":2}]}`, priced.ID)
+	response := createTestOrder(t, router, "", body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var order Order
+	json.Unmarshal(response.Body.Bytes(), &order)
+	if order.Status != orderPending || order.TotalCents != 6998 || order.Currency != "usd" {
+		t.Errorf("Expected a pending order for 6998 usd, got %+v", order)
+	}
+	if len(order.Items) != 1 || order.Items[0].Title != "Clean Code" || order.Items[0].SubtotalCents != 6998 {
+		t.Errorf("Unexpected items %+v", order.Items)
+	}
+
+	// Later price changes leave placed orders alone
+	db.Model(&priced).Update("price_cents", 999)
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/orders/%d", order.ID), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	json.Unmarshal(response.Body.Bytes(), &order)
+	if order.TotalCents != 6998 {
+		t.Errorf("Expected the order total to stay 6998, got %d", order.TotalCents)
+	}
+
+	for _, body := range []string{
+		`{"items":[]}`,
+		fmt.Sprintf(`{"items":[{"book_id":%d,"quantity":1}]}`, unpriced.ID),
+		fmt.Sprintf(`{"items":[{"book_id":%d,"quantity":0}]}`, priced.ID),
+		`{"items":[{"book_id":999,"quantity":1}]}`,
+		fmt.Sprintf(`{"email":"not an address","items":[{"book_id":%d,"quantity":1}]}`, priced.ID),
+	} {
+		if response := createTestOrder(t, router, "", body); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, response.Code)
+		}
+	}
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Order{Status: orderPending, Currency: "usd"})
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/api/v1/admin/orders/1/fulfill", http.StatusConflict},
+		{"/api/v1/orders/1/cancel", http.StatusOK},
+		{"/api/v1/orders/1/cancel", http.StatusConflict},
+		{"/api/v1/orders/1/checkout", http.StatusNotImplemented},
+	} {
+		req, _ := http.NewRequest("POST", tt.path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != tt.want {
+			t.Errorf("POST %s: expected status %d, got %d", tt.path, tt.want, response.Code)
+		}
+	}
+
+	var order Order
+	db.First(&order, 1)
+	if order.Status != orderCancelled {
+		t.Errorf("Expected the order to be cancelled, got %s", order.Status)
+	}
+}
+
+func TestOrdersBelongToTheirBuyer(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"ann:pw": {Username: "ann", Email: "ann@example.com", Roles: []string{roleReader}},
+		"bob:pw": {Username: "bob", Roles: []string{roleReader}},
+		"lib:pw": {Username: "lib", Roles: []string{roleLibrarian}},
+	})
+	router := setupRouter()
+	book := Book{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884", PriceCents: 3499}
+	db.Create(&book)
+
+	body := fmt.Sprintf(`{"items":[{"book_id":%d,"quantity":1}]}`, book.ID)
+	if response := createTestOrder(t, router, "", body); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous orders to be refused, got %d", response.Code)
+	}
+	response := createTestOrder(t, router, loginAs(t, router, "ann", "pw"), body)
+	var order Order
+	json.Unmarshal(response.Body.Bytes(), &order)
+	if order.Email != "ann@example.com" {
+		t.Errorf("Expected the buyer's email on the order, got %q", order.Email)
+	}
+
+	for _, tt := range []struct {
+		user string
+		want int
+	}{
+		{"ann", http.StatusOK},
+		{"bob", http.StatusNotFound},
+		{"lib", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/orders/%d", order.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+loginAs(t, router, tt.user, "pw"))
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.user, tt.want, response.Code)
+		}
+	}
+}
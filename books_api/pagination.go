@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Default and largest page size for cursor pagination
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// BookPage is one page of the books listing in cursor mode
+type BookPage struct {
+	Items []Book `json:"items"`
+	// Pass as ?cursor= for the next page; absent on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Pass as ?cursor= for the previous page; absent on the first page
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Value counts among all the filtered books, when ?facets= asks for them
+	Facets Facets `json:"facets,omitempty"`
+}
+
+// Position after the last book of a page, or before the first one for
+// paging back: its values for each sort key, and the sort they belong
+// to. Last instead points at the end of the listing, for its last page.
+// Encoded opaquely so clients don't build cursors themselves and the
+// format can change.
+type bookCursor struct {
+	Sort   string        `json:"sort"`
+	After  []interface{} `json:"after,omitempty"`
+	Before []interface{} `json:"before,omitempty"`
+	Last   bool          `json:"last,omitempty"`
+}
+
+// The cursor's position, whichever way it points
+func (c bookCursor) values() []interface{} {
+	if c.Before != nil {
+		return c.Before
+	}
+	return c.After
+}
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorSort    = errors.New("cursor belongs to a different sort")
+)
+
+func (c bookCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeBookCursor(s string) (bookCursor, error) {
+	var c bookCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return c, errInvalidCursor
+	}
+	positions := 0
+	for _, set := range []bool{c.After != nil, c.Before != nil, c.Last} {
+		if set {
+			positions++
+		}
+	}
+	if positions != 1 {
+		return c, errInvalidCursor
+	}
+	// Values are bound into the query, so only accept plain scalars
+	for _, v := range c.values() {
+		switch v.(type) {
+		case string, float64:
+		default:
+			return c, errInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+// BookConnection is a page of the books listing with the size of the
+// whole listing, as the GraphQL and gRPC interfaces return it
+type BookConnection struct {
+	Items      []Book
+	TotalCount int64
+	NextCursor string
+	PrevCursor string
+}
+
+// Read the page of up to limit books at q's cursor, narrowed and sorted by
+// q's listing parameters, with tags. Invalid parameters are codedErrors.
+func listBookPage(ctx context.Context, q url.Values, limit int) (*BookConnection, error) {
+	keys, err := parseBookSort(q.Get("sort"))
+	if err != nil {
+		return nil, &codedError{"invalid_sort", "Invalid sort: " + err.Error()}
+	}
+	filter, err := parseBookFilter(q)
+	if err != nil {
+		return nil, &codedError{"invalid_filter", err.Error()}
+	}
+	keys = withIDTiebreak(keys)
+	sort := formatBookSort(keys)
+	cursor, err := parseBookCursor(q.Get("cursor"), keys, sort)
+	if errors.Is(err, errCursorSort) {
+		return nil, &codedError{"invalid_cursor", "Cursor belongs to a different sort"}
+	}
+	if err != nil {
+		return nil, &codedError{"invalid_cursor", "Invalid cursor"}
+	}
+
+	conn := &BookConnection{}
+	if err := filter.apply(db.WithContext(ctx).Model(&Book{})).Count(&conn.TotalCount).Error; err != nil {
+		return nil, err
+	}
+	page, err := readBookPage(filter.apply(db.WithContext(ctx).Preload("Tags")), keys, sort, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	conn.Items, conn.NextCursor, conn.PrevCursor = page.Items, page.NextCursor, page.PrevCursor
+	return conn, nil
+}
+
+// Whether a listing request asks for cursor pagination. Without cursor or
+// limit the listing stays a plain array of every book.
+func wantsCursorPage(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("cursor") || q.Has("limit")
+}
+
+// List a page of the books query selects after the request's cursor, in
+// keys order. Keyset paging reads only the rows it returns and, unlike
+// offsets, doesn't skip or repeat books when others are added or deleted
+// between pages. A cursor pointing back reads the page before its
+// position in reverse order and flips it.
+func getBooksPage(w http.ResponseWriter, r *http.Request, query *gorm.DB, keys []bookSortKey, fields bookFields, total int64, facets Facets) {
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	keys = withIDTiebreak(keys)
+	sort := formatBookSort(keys)
+	cursor, err := parseBookCursor(r.URL.Query().Get("cursor"), keys, sort)
+	if err != nil {
+		msg := "Invalid cursor"
+		if errors.Is(err, errCursorSort) {
+			msg = "Cursor belongs to a different sort"
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_cursor", msg)
+		return
+	}
+	page, err := readBookPage(query, keys, sort, cursor, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list books")
+		return
+	}
+	setLinkHeader(w,
+		pageLink{"first", pageURL(r, "")},
+		pageLink{"prev", cursorURL(r, page.PrevCursor)},
+		pageLink{"next", cursorURL(r, page.NextCursor)},
+		pageLink{"last", pageURL(r, bookCursor{Sort: sort, Last: true}.encode())},
+	)
+
+	// In an envelope the cursors move to meta
+	meta := ListMeta{Total: &total, NextCursor: page.NextCursor, PrevCursor: page.PrevCursor, Facets: facets}
+	if env, ok := enveloped(r, fields.projectList(page.Items), meta).(Envelope); ok {
+		writeJSONConditional(w, r, env)
+		return
+	}
+	if fields != nil {
+		writeJSONConditional(w, r, sparseBookPage{Items: fields.projectList(page.Items), NextCursor: page.NextCursor, PrevCursor: page.PrevCursor, Facets: facets})
+		return
+	}
+	page.Facets = facets
+	writeJSONConditional(w, r, page)
+}
+
+// pageLink is a link to another page of a listing, by its relation
+type pageLink struct {
+	rel  string
+	href string
+}
+
+// Set an RFC 8288 Link header to a listing's other pages, so generic
+// clients can walk them without reading the body. Links without an href
+// are left out.
+func setLinkHeader(w http.ResponseWriter, links ...pageLink) {
+	var parts []string
+	for _, l := range links {
+		if l.href != "" {
+			parts = append(parts, "<"+l.href+`>; rel="`+l.rel+`"`)
+		}
+	}
+	if len(parts) > 0 {
+		w.Header().Set("Link", strings.Join(parts, ", "))
+	}
+}
+
+// The request's URL at cursor, or "" without one
+func cursorURL(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	return pageURL(r, cursor)
+}
+
+// Decode the ?cursor= of a listing sorted by keys, "" for the first page
+func parseBookCursor(v string, keys []bookSortKey, sort string) (bookCursor, error) {
+	if v == "" {
+		return bookCursor{}, nil
+	}
+	cursor, err := decodeBookCursor(v)
+	if err != nil || (!cursor.Last && len(cursor.values()) != len(keys)) {
+		return cursor, errInvalidCursor
+	}
+	if cursor.Sort != sort {
+		return cursor, errCursorSort
+	}
+	values := cursor.values()
+	for i, k := range keys {
+		if !k.timestamp() || cursor.Last {
+			continue
+		}
+		s, _ := values[i].(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return cursor, errInvalidCursor
+		}
+		values[i] = t
+	}
+	return cursor, nil
+}
+
+// Read the page of up to limit books query selects at cursor, in keys
+// order, with the cursors of the pages around it
+func readBookPage(query *gorm.DB, keys []bookSortKey, sort string, cursor bookCursor, limit int) (BookPage, error) {
+	back := cursor.Before != nil || cursor.Last
+	order := keys
+	if back {
+		order = reverseBookSort(keys)
+	}
+	query = orderBooks(query, order)
+	if values := cursor.values(); values != nil {
+		cond, args := keysetAfter(order, values)
+		query = query.Where(cond, args...)
+	}
+
+	// One extra row tells whether there is another page in the direction
+	// read
+	var books []Book
+	if err := query.Limit(limit + 1).Find(&books).Error; err != nil {
+		return BookPage{}, err
+	}
+	more := len(books) > limit
+	if more {
+		books = books[:limit]
+	}
+	if back {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+		}
+	}
+	page := BookPage{Items: listOf(books)}
+	if len(books) > 0 {
+		// Reading forward there is a page back whenever a cursor led here,
+		// and reading back there is always one forward, except from the end
+		if (more && !back) || cursor.Before != nil {
+			page.NextCursor = pageCursor(keys, sort, &books[len(books)-1], false)
+		}
+		if (more && back) || (!back && cursor.After != nil) {
+			page.PrevCursor = pageCursor(keys, sort, &books[0], true)
+		}
+	}
+	return page, nil
+}
+
+// A cursor after book, or before it for paging back
+func pageCursor(keys []bookSortKey, sort string, book *Book, before bool) string {
+	c := bookCursor{Sort: sort}
+	for _, k := range keys {
+		if before {
+			c.Before = append(c.Before, k.value(book))
+		} else {
+			c.After = append(c.After, k.value(book))
+		}
+	}
+	return c.encode()
+}
+
+// keys with every direction flipped, for reading a page backwards
+func reverseBookSort(keys []bookSortKey) []bookSortKey {
+	reversed := make([]bookSortKey, len(keys))
+	for i, k := range keys {
+		reversed[i] = bookSortKey{Field: k.Field, Desc: !k.Desc}
+	}
+	return reversed
+}
+
+// Condition for rows that sort after values, one term per key:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys
+func keysetAfter(keys []bookSortKey, values []interface{}) (string, []interface{}) {
+	var terms []string
+	var args []interface{}
+	for i, k := range keys {
+		var term []string
+		for j := 0; j < i; j++ {
+			term = append(term, keys[j].column()+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if k.Desc {
+			op = " < ?"
+		}
+		term = append(term, k.column()+op)
+		args = append(args, values[i])
+		terms = append(terms, "("+strings.Join(term, " AND ")+")")
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func fetchBookPage(t *testing.T, router http.Handler, query string) BookPage {
+	req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for %s, got %d: %s", query, response.Code, response.Body.String())
+	}
+	var page BookPage
+	json.Unmarshal(response.Body.Bytes(), &page)
+	return page
+}
+
+func TestBooksCursorPagination(t *testing.T) {
+	clearDB()
+	for i := 1; i <= 5; i++ {
+		db.Create(&Book{Title: fmt.Sprintf("Book %d", i), Author: "Author", ISBN: fmt.Sprintf("978000000000%d", i)})
+	}
+	router := setupRouter()
+
+	page := fetchBookPage(t, router, "limit=2")
+	if len(page.Items) != 2 || page.Items[0].Title != "Book 1" || page.NextCursor == "" {
+		t.Fatalf("Unexpected first page %+v", page)
+	}
+
+	// A book deleted between pages doesn't shift the next one
+	db.Delete(&Book{}, page.Items[0].ID)
+	page = fetchBookPage(t, router, "limit=2&cursor="+url.QueryEscape(page.NextCursor))
+	if len(page.Items) != 2 || page.Items[0].Title != "Book 3" {
+		t.Fatalf("Unexpected second page %+v", page)
+	}
+
+	page = fetchBookPage(t, router, "limit=2&cursor="+url.QueryEscape(page.NextCursor))
+	if len(page.Items) != 1 || page.Items[0].Title != "Book 5" || page.NextCursor != "" {
+		t.Errorf("Expected a last page without a cursor, got %+v", page)
+	}
+}
+
+func TestBooksCursorPrevPage(t *testing.T) {
+	clearDB()
+	for i := 1; i <= 5; i++ {
+		db.Create(&Book{Title: fmt.Sprintf("Book %d", i), Author: "Author", ISBN: fmt.Sprintf("978000000000%d", i)})
+	}
+	router := setupRouter()
+
+	first := fetchBookPage(t, router, "sort=-title&limit=2")
+	if first.PrevCursor != "" {
+		t.Errorf("Expected no previous page before the first, got %q", first.PrevCursor)
+	}
+	second := fetchBookPage(t, router, "sort=-title&limit=2&cursor="+url.QueryEscape(first.NextCursor))
+	third := fetchBookPage(t, router, "sort=-title&limit=2&cursor="+url.QueryEscape(second.NextCursor))
+	if len(third.Items) != 1 || third.Items[0].Title != "Book 1" || third.PrevCursor == "" {
+		t.Fatalf("Unexpected last page %+v", third)
+	}
+
+	// Paging back returns the same pages, in the same order
+	back := fetchBookPage(t, router, "sort=-title&limit=2&cursor="+url.QueryEscape(third.PrevCursor))
+	if len(back.Items) != 2 || back.Items[0].Title != "Book 3" || back.Items[1].Title != "Book 2" {
+		t.Fatalf("Unexpected previous page %+v", back)
+	}
+	if back.NextCursor == "" || back.PrevCursor == "" {
+		t.Errorf("Expected cursors both ways from the middle page, got %+v", back)
+	}
+	back = fetchBookPage(t, router, "sort=-title&limit=2&cursor="+url.QueryEscape(back.PrevCursor))
+	if len(back.Items) != 2 || back.Items[0].Title != "Book 5" || back.PrevCursor != "" || back.NextCursor == "" {
+		t.Errorf("Expected the first page without a previous cursor, got %+v", back)
+	}
+}
+
+func TestBooksCursorValidation(t *testing.T) {
+	router := setupRouter()
+	for _, query := range []string{"cursor=not-a-cursor", "limit=0", "limit=101"} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, response.Code)
+		}
+	}
+}
+
+func TestBooksLinkHeader(t *testing.T) {
+	clearDB()
+	for i := 1; i <= 5; i++ {
+		db.Create(&Book{Title: fmt.Sprintf("Book %d", i), Author: "Author", ISBN: fmt.Sprintf("978000000000%d", i)})
+	}
+	router := setupRouter()
+
+	// Follow a Link relation, returning the page and its links
+	links := regexp.MustCompile(`<([^>]*)>; rel="(\w+)"`)
+	follow := func(uri string) (BookPage, map[string]string) {
+		req, _ := http.NewRequest("GET", uri, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var page BookPage
+		json.Unmarshal(response.Body.Bytes(), &page)
+		rels := map[string]string{}
+		for _, m := range links.FindAllStringSubmatch(response.Header().Get("Link"), -1) {
+			rels[m[2]] = m[1]
+		}
+		return page, rels
+	}
+
+	page, rels := follow("/api/v1/books?limit=2")
+	if got := bookTitles(page.Items); fmt.Sprint(got) != "[Book 1 Book 2]" || rels["next"] == "" || rels["prev"] != "" {
+		t.Fatalf("Expected the first page with a next link, got %v %v", got, rels)
+	}
+	if rels["first"] != "/api/v1/books?limit=2" {
+		t.Errorf("Expected first to drop the cursor, got %s", rels["first"])
+	}
+
+	page, rels = follow(rels["last"])
+	if got := bookTitles(page.Items); fmt.Sprint(got) != "[Book 4 Book 5]" || rels["next"] != "" || rels["prev"] == "" {
+		t.Fatalf("Expected the last page with only a prev link, got %v %v", got, rels)
+	}
+
+	page, rels = follow(rels["prev"])
+	if got := bookTitles(page.Items); fmt.Sprint(got) != "[Book 2 Book 3]" || rels["next"] == "" {
+		t.Errorf("Expected the page before the last, got %v %v", got, rels)
+	}
+
+	_, rels = follow("/api/v1/books/search?q=book&limit=2&offset=2")
+	want := map[string]string{
+		"first": "/api/v1/books/search?limit=2&q=book",
+		"prev":  "/api/v1/books/search?limit=2&q=book",
+		"next":  "/api/v1/books/search?limit=2&offset=4&q=book",
+	}
+	if fmt.Sprint(rels) != fmt.Sprint(want) {
+		t.Errorf("Expected search links %v, got %v", want, rels)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Media type of an RFC 7386 JSON Merge Patch
+const mergePatchType = "application/merge-patch+json"
+
+// bookDocument is the part of a book a patch can change. Patches apply to
+// this document, then the result replaces the book's fields, so a field
+// is only touched when the patch names it.
+type bookDocument struct {
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	ISBN        string `json:"isbn"`
+	Year        int    `json:"year"`
+	Description string `json:"description"`
+	CoverURL    string `json:"cover_url"`
+	PriceCents  int64  `json:"price_cents"`
+	Language    string `json:"language"`
+	PageCount   int    `json:"page_count"`
+	Format      string `json:"format"`
+	Status      string `json:"status"`
+
+	PurchasePriceCents int64  `json:"purchase_price_cents"`
+	PurchaseCurrency   string `json:"purchase_currency"`
+	Location           string `json:"location"`
+	CallNumber         string `json:"call_number"`
+	// Never nil, so JSON Patch paths into it resolve
+	Attributes map[string]string `json:"attributes"`
+}
+
+func newBookDocument(b *Book) bookDocument {
+	d := bookDocument{
+		Title:       b.Title,
+		Author:      b.Author,
+		ISBN:        b.ISBN,
+		Year:        b.Year,
+		Description: b.Description,
+		CoverURL:    b.CoverURL,
+		PriceCents:  b.PriceCents,
+		Language:    b.Language,
+		PageCount:   b.PageCount,
+		Format:      b.Format,
+		Status:      b.Status,
+		Attributes:  maps.Clone(b.Attributes),
+
+		PurchasePriceCents: b.PurchasePriceCents,
+		PurchaseCurrency:   b.PurchaseCurrency,
+		Location:           b.Location,
+		CallNumber:         b.CallNumber,
+	}
+	if d.Attributes == nil {
+		d.Attributes = map[string]string{}
+	}
+	return d
+}
+
+func (d bookDocument) applyTo(b *Book) {
+	b.Title = d.Title
+	b.Author = d.Author
+	b.ISBN = d.ISBN
+	b.Year = d.Year
+	b.Description = d.Description
+	b.CoverURL = d.CoverURL
+	b.PriceCents = d.PriceCents
+	b.Language = d.Language
+	b.PageCount = d.PageCount
+	b.Format = d.Format
+	b.Status = d.Status
+	b.PurchasePriceCents = d.PurchasePriceCents
+	b.PurchaseCurrency = d.PurchaseCurrency
+	b.Location = d.Location
+	b.CallNumber = d.CallNumber
+	b.Attributes = d.Attributes
+}
+
+// The document as generic JSON values, for patching
+func (d bookDocument) value() map[string]interface{} {
+	data, _ := json.Marshal(d)
+	var v map[string]interface{}
+	decodeJSONNumbers(data, &v)
+	return v
+}
+
+// Read a patched document back. A removed member becomes the field's zero
+// value, so removing year marks it unknown.
+func parseBookDocument(obj map[string]interface{}) (bookDocument, []FieldError) {
+	var d bookDocument
+	fields := map[string]interface{}{
+		"title":       &d.Title,
+		"author":      &d.Author,
+		"isbn":        &d.ISBN,
+		"year":        &d.Year,
+		"description": &d.Description,
+		"cover_url":   &d.CoverURL,
+		"price_cents": &d.PriceCents,
+		"language":    &d.Language,
+		"page_count":  &d.PageCount,
+		"format":      &d.Format,
+		"status":      &d.Status,
+		"attributes":  &d.Attributes,
+
+		"purchase_price_cents": &d.PurchasePriceCents,
+		"purchase_currency":    &d.PurchaseCurrency,
+		"location":             &d.Location,
+		"call_number":          &d.CallNumber,
+	}
+	var errs []FieldError
+	for name, member := range obj {
+		dst, ok := fields[name]
+		if !ok {
+			errs = append(errs, FieldError{Field: name, Message: "cannot be patched"})
+			continue
+		}
+		data, _ := json.Marshal(member)
+		if err := json.Unmarshal(data, dst); err != nil {
+			errs = append(errs, FieldError{Field: name, Message: "has the wrong type"})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return d, errs
+}
+
+// Decode JSON keeping numbers exact, so 1e3 and 1999.5 aren't silently
+// rounded into integer fields
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// Apply an RFC 7386 merge patch: members of an object patch replace the
+// target's, recursively, and null removes them. Any other patch replaces
+// the target outright.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
+
+var errUnsupportedPatch = errors.New("unsupported patch media type")
+
+// Apply a patch body to a book's document according to its media type
+func applyBookPatch(doc map[string]interface{}, contentType string, body []byte) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case mergePatchType, "application/json", "":
+		var patch interface{}
+		if err := decodeJSONNumbers(body, &patch); err != nil {
+			return nil, err
+		}
+		return mergePatch(doc, patch), nil
+	case jsonPatchType:
+		return applyJSONPatch(doc, body)
+	}
+	return nil, errUnsupportedPatch
+}
+
+// Partially update a book with a merge patch or a JSON Patch. Members a
+// merge patch names are set as given, zero values included, and null
+// clears a field; PUT can do neither. A JSON Patch applies all of its
+// operations or none.
+func patchBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+	var book Book
+	if err := db.WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+
+	if !checkIfMatch(w, r, &book) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	patched, err := applyBookPatch(newBookDocument(&book).value(), r.Header.Get("Content-Type"), body)
+	var patchErr *jsonPatchError
+	if errors.Is(err, errUnsupportedPatch) {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Patch must be "+mergePatchType+" or "+jsonPatchType)
+		return
+	}
+	if errors.As(err, &patchErr) {
+		status := http.StatusBadRequest
+		if patchErr.Conflict {
+			status = http.StatusConflict
+		}
+		writeError(w, r, status, "patch_failed", "Patch failed: "+patchErr.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+
+	obj, ok := patched.(map[string]interface{})
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_patch", "Patch must leave the book a JSON object")
+		return
+	}
+	doc, errs := parseBookDocument(obj)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	doc.applyTo(&book)
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	if err := db.WithContext(r.Context()).Save(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update book")
+		}
+		return
+	}
+	setBookETag(w, book.ID)
+	writeJSON(w, http.StatusOK, book)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func patchRequest(router http.Handler, path, contentType, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PATCH", path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7386, appendix A
+	cases := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	}
+	for _, c := range cases {
+		var target, patch, want interface{}
+		json.Unmarshal([]byte(c.target), &target)
+		json.Unmarshal([]byte(c.patch), &patch)
+		json.Unmarshal([]byte(c.want), &want)
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("Patching %s with %s: expected %s, got %v", c.target, c.patch, c.want, got)
+		}
+	}
+}
+
+func TestPatchBook(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Description: "Desert planet", PriceCents: 999})
+	router := setupRouter()
+
+	response := patchRequest(router, "/api/v1/books/1", mergePatchType, `{"year": null, "price_cents": 0, "title": "Dune (1965)"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var book Book
+	db.First(&book, 1)
+	if book.Year != 0 || book.PriceCents != 0 || book.Title != "Dune (1965)" || book.Description != "Desert planet" {
+		t.Errorf("Expected only the named fields to change, got %+v", book)
+	}
+
+	checks := []struct {
+		contentType, body string
+		status            int
+		want              string
+	}{
+		{mergePatchType, `{"title": null}`, http.StatusBadRequest, `"title"`},
+		{mergePatchType, `{"year": "1965"}`, http.StatusBadRequest, `has the wrong type`},
+		{mergePatchType, `{"year": 1965.5}`, http.StatusBadRequest, `has the wrong type`},
+		{mergePatchType, `{"id": 7}`, http.StatusBadRequest, `cannot be patched`},
+		{mergePatchType, `["title"]`, http.StatusBadRequest, `JSON object`},
+		{"text/plain", `{}`, http.StatusUnsupportedMediaType, mergePatchType},
+	}
+	for _, c := range checks {
+		response := patchRequest(router, "/api/v1/books/1", c.contentType, c.body)
+		if response.Code != c.status || !strings.Contains(response.Body.String(), c.want) {
+			t.Errorf("Expected %d with %s for %s, got %d: %s", c.status, c.want, c.body, response.Code, response.Body.String())
+		}
+	}
+
+	if response := patchRequest(router, "/api/v1/books/99", mergePatchType, `{}`); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Checkout is a hosted payment page opened for an order
+type Checkout struct {
+	Ref string // provider's ID for the checkout
+	URL string
+}
+
+// Kinds of payment event
+const (
+	paymentSucceeded = "succeeded"
+	paymentExpired   = "expired" // checkout closed without paying
+	paymentRefunded  = "refunded"
+)
+
+// PaymentEvent is a provider notification, verified and normalized. Events
+// that don't concern orders are returned with an empty Type.
+type PaymentEvent struct {
+	Type        string
+	OrderID     uint   // set for checkout events
+	Ref         string // checkout the event belongs to
+	PaymentID   string // set for succeeded and refunded events
+	AmountCents int64
+	Currency    string
+}
+
+// PaymentProvider takes payments through hosted checkout pages and reports
+// their outcome by webhook
+type PaymentProvider interface {
+	Name() string
+	CreateCheckout(ctx context.Context, o *Order, successURL, cancelURL string) (*Checkout, error)
+	ExpireCheckout(ctx context.Context, ref string) error
+	ParseWebhook(r *http.Request) (*PaymentEvent, error)
+}
+
+// Active payment provider, chosen by initPayments. nil disables checkout.
+var paymentProvider PaymentProvider
+
+func initPayments() {
+	switch cfg.PaymentProvider {
+	case "stripe":
+		if cfg.Stripe.SecretKey == "" || cfg.Stripe.WebhookSecret == "" {
+			log.Fatal("PAYMENT_PROVIDER=stripe needs STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+		}
+		paymentProvider = newStripeProvider(cfg.Stripe)
+	case "", "none":
+		paymentProvider = nil
+	default:
+		log.Fatalf("Unknown PAYMENT_PROVIDER %q", cfg.PaymentProvider)
+	}
+}
+
+// Receive payment provider webhooks and bring orders up to date
+func paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if paymentProvider == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Payments are not enabled")
+		return
+	}
+	ev, err := paymentProvider.ParseWebhook(r)
+	if err != nil {
+		log.Printf("Rejected payment webhook: %v", err)
+		writeError(w, r, http.StatusBadRequest, "invalid_webhook", "Invalid webhook")
+		return
+	}
+	if ev.Type != "" {
+		if err := reconcilePayment(ev); err != nil {
+			// A failure response makes the provider deliver it again
+			log.Printf("Reconciling %s payment event failed: %v", ev.Type, err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to process webhook")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"received": true})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How old a webhook signature may be, against replays
+const stripeSignatureTolerance = 5 * time.Minute
+
+// Largest webhook body accepted
+const stripeMaxWebhookSize = 1 << 20
+
+// Stripe account settings
+type StripeConfig struct {
+	APIURL        string
+	SecretKey     string
+	WebhookSecret string
+}
+
+// stripeProvider takes payments through Stripe Checkout. It speaks the
+// REST API directly: form-encoded requests, JSON responses.
+type stripeProvider struct {
+	cfg    StripeConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func newStripeProvider(c StripeConfig) *stripeProvider {
+	c.APIURL = strings.TrimRight(c.APIURL, "/")
+	return &stripeProvider{cfg: c, client: &http.Client{Timeout: 30 * time.Second}, now: time.Now}
+}
+
+func (s *stripeProvider) Name() string { return "stripe" }
+
+// Wire format of a Checkout Session
+type stripeSession struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	PaymentStatus     string            `json:"payment_status"`
+	PaymentIntent     string            `json:"payment_intent"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// Wire format of a Charge, as sent with charge.refunded
+type stripeCharge struct {
+	PaymentIntent string `json:"payment_intent"`
+	Refunded      bool   `json:"refunded"`
+}
+
+// Issue a form-encoded POST and decode the JSON response into v
+func (s *stripeProvider) post(ctx context.Context, path string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.cfg.APIURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.SecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("stripe: %s: %s", resp.Status, body.Error.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *stripeProvider) CreateCheckout(ctx context.Context, o *Order, successURL, cancelURL string) (*Checkout, error) {
+	id := strconv.FormatUint(uint64(o.ID), 10)
+	form := url.Values{
+		"mode":                {"payment"},
+		"success_url":         {successURL},
+		"cancel_url":          {cancelURL},
+		"client_reference_id": {id},
+		"metadata[order_id]":  {id},
+	}
+	if o.Email != "" {
+		form.Set("customer_email", o.Email)
+	}
+	for i, item := range o.Items {
+		prefix := fmt.Sprintf("line_items[%d]", i)
+		form.Set(prefix+"[quantity]", strconv.Itoa(item.Quantity))
+		form.Set(prefix+"[price_data][currency]", strings.ToLower(o.Currency))
+		form.Set(prefix+"[price_data][unit_amount]", strconv.FormatInt(item.UnitPriceCents, 10))
+		form.Set(prefix+"[price_data][product_data][name]", item.Title)
+	}
+
+	var session stripeSession
+	if err := s.post(ctx, "/v1/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &Checkout{Ref: session.ID, URL: session.URL}, nil
+}
+
+func (s *stripeProvider) ExpireCheckout(ctx context.Context, ref string) error {
+	return s.post(ctx, "/v1/checkout/sessions/"+url.PathEscape(ref)+"/expire", nil, nil)
+}
+
+// Verify a webhook's Stripe-Signature and translate the event. Checkout
+// completions only count once the money has arrived; delayed methods
+// report it later with async_payment_succeeded.
+func (s *stripeProvider) ParseWebhook(r *http.Request) (*PaymentEvent, error) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, stripeMaxWebhookSize))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifySignature(payload, r.Header.Get("Stripe-Signature")); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded",
+		"checkout.session.expired", "checkout.session.async_payment_failed":
+		var session stripeSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, err
+		}
+		orderID, _ := strconv.ParseUint(session.ClientReferenceID, 10, 0)
+		ev := &PaymentEvent{
+			OrderID:     uint(orderID),
+			Ref:         session.ID,
+			PaymentID:   session.PaymentIntent,
+			AmountCents: session.AmountTotal,
+			Currency:    session.Currency,
+		}
+		switch {
+		case session.PaymentStatus == "paid":
+			ev.Type = paymentSucceeded
+		case event.Type == "checkout.session.expired" || event.Type == "checkout.session.async_payment_failed":
+			ev.Type = paymentExpired
+		}
+		return ev, nil
+
+	case "charge.refunded":
+		var charge stripeCharge
+		if err := json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return nil, err
+		}
+		// Partial refunds leave the order as it is
+		if charge.Refunded {
+			return &PaymentEvent{Type: paymentRefunded, PaymentID: charge.PaymentIntent}, nil
+		}
+	}
+	return &PaymentEvent{}, nil
+}
+
+// Check a "t=<unix>,v1=<hex>" header: an HMAC-SHA256 of "<t>.<payload>"
+// with the endpoint's signing secret
+func (s *stripeProvider) verifySignature(payload []byte, header string) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("malformed Stripe-Signature header")
+	}
+	if age := s.now().Sub(time.Unix(ts, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return errors.New("webhook timestamp outside the tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.cfg.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	want := hex.EncodeToString(mac.Sum(nil))
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(want)) {
+			return nil
+		}
+	}
+	return errors.New("webhook signature mismatch")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testStripeWebhookSecret = "whsec_test"
+
+// Point the payment provider at a fake Stripe API for one test
+func useStripeServer(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	saved := paymentProvider
+	paymentProvider = newStripeProvider(StripeConfig{APIURL: srv.URL, SecretKey: "sk_test", WebhookSecret: testStripeWebhookSecret})
+	t.Cleanup(func() {
+		paymentProvider = saved
+		srv.Close()
+	})
+}
+
+// Deliver a webhook signed the way Stripe signs them
+func postStripeWebhook(router http.Handler, eventType string, object interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(object)
+	payload := fmt.Sprintf(`{"id":"evt_1","type":%q,"data":{"object":%s}}`, eventType, data)
+	ts := fmt.Sprint(time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(testStripeWebhookSecret))
+	mac.Write([]byte(ts + "." + payload))
+
+	req, _ := http.NewRequest("POST", "/api/v1/payments/webhook", bytes.NewBufferString(payload))
+	req.Header.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestStripeCheckoutAndWebhooks(t *testing.T) {
+	clearDB()
+	useStripeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			t.Error("Expected the secret key to be sent")
+		}
+		r.ParseForm()
+		for field, want := range map[string]string{
+			"client_reference_id":                           "1",
+			"line_items[0][quantity]":                       "2",
+			"line_items[0][price_data][unit_amount]":        "3499",
+			"line_items[0][price_data][currency]":           "usd",
+			"line_items[0][price_data][product_data][name]": "Clean Code",
+		} {
+			if got := r.PostForm.Get(field); got != want {
+				t.Errorf("Expected %s=%q, got %q", field, want, got)
+			}
+		}
+		if !strings.Contains(r.PostForm.Get("success_url"), "checkout=success") {
+			t.Errorf("Unexpected success_url %q", r.PostForm.Get("success_url"))
+		}
+		w.Write([]byte(`{"id":"cs_1","url":"https://checkout.stripe.com/c/pay/cs_1"}`))
+	})
+	router := setupRouter()
+	book := Book{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884", PriceCents: 3499}
+	db.Create(&book)
+	createTestOrder(t, router, "", fmt.Sprintf(`{"items":[{"book_id":%d,"quantity":2}]}`, book.ID))
+
+	req, _ := http.NewRequest("POST", "/api/v1/orders/1/checkout", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var order Order
+	json.Unmarshal(response.Body.Bytes(), &order)
+	if response.Code != http.StatusOK || order.CheckoutURL != "https://checkout.stripe.com/c/pay/cs_1" {
+		t.Fatalf("Expected a checkout URL, got %d: %s", response.Code, response.Body.String())
+	}
+
+	status := func() string {
+		var o Order
+		db.First(&o, 1)
+		return o.Status
+	}
+	session := stripeSession{ID: "cs_1", ClientReferenceID: "1", PaymentStatus: "paid", PaymentIntent: "pi_1", AmountTotal: 6998, Currency: "usd"}
+
+	// A session paid for less than the order doesn't settle it
+	short := session
+	short.AmountTotal = 100
+	postStripeWebhook(router, "checkout.session.completed", short)
+	if got := status(); got != orderPending {
+		t.Errorf("Expected an underpaid order to stay pending, got %s", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := postStripeWebhook(router, "checkout.session.completed", session); response.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", response.Code)
+		}
+		if got := status(); got != orderPaid {
+			t.Fatalf("Expected the order to be paid, got %s", got)
+		}
+	}
+
+	postStripeWebhook(router, "charge.refunded", stripeCharge{PaymentIntent: "pi_1", Refunded: true})
+	if got := status(); got != orderRefunded {
+		t.Errorf("Expected the order to be refunded, got %s", got)
+	}
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	p := newStripeProvider(StripeConfig{WebhookSecret: testStripeWebhookSecret})
+	p.now = func() time.Time { return time.Unix(1700000000, 0) }
+	payload := []byte(`{"type":"checkout.session.completed"}`)
+	mac := hmac.New(sha256.New, []byte(testStripeWebhookSecret))
+	mac.Write([]byte("1700000000."))
+	mac.Write(payload)
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name, header string
+		ok           bool
+	}{
+		{"valid", "t=1700000000,v1=" + sig, true},
+		{"one of several", "t=1700000000,v1=00ff,v1=" + sig, true},
+		{"wrong signature", "t=1700000000,v1=00ff", false},
+		{"stale", "t=1699990000,v1=" + sig, false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		if err := p.verifySignature(payload, tt.header); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestStripeExpiredSessionReopensCheckout(t *testing.T) {
+	clearDB()
+	useStripeServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	router := setupRouter()
+	db.Create(&Order{Status: orderPending, Currency: "usd", PaymentRef: "cs_2", CheckoutURL: "https://checkout.stripe.com/c/pay/cs_2"})
+
+	// Expiry of an older session leaves the current one in place
+	postStripeWebhook(router, "checkout.session.expired", stripeSession{ID: "cs_1", ClientReferenceID: "1", PaymentStatus: "unpaid"})
+	var order Order
+	db.First(&order, 1)
+	if order.PaymentRef != "cs_2" {
+		t.Fatalf("Expected the current checkout to stay open, got %q", order.PaymentRef)
+	}
+
+	postStripeWebhook(router, "checkout.session.expired", stripeSession{ID: "cs_2", ClientReferenceID: "1", PaymentStatus: "unpaid"})
+	db.First(&order, 1)
+	if order.Status != orderPending || order.PaymentRef != "" || order.CheckoutURL != "" {
+		t.Errorf("Expected a pending order without a checkout, got %+v", order)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/payments/webhook", strings.NewReader(url.Values{"x": {"1"}}.Encode()))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unsigned webhook to be refused, got %d", response.Code)
+	}
+}
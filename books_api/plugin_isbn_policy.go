@@ -0,0 +1,37 @@
+//go:build isbn_policy
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Example plugin enforcing an institution's ISBN policy: only ISBN-13s
+// under the registrant prefixes in ISBN_ALLOWED_PREFIXES, such as
+// "978-0,978-1" for English-language books, are catalogued. With no
+// prefixes set any ISBN-13 is accepted. Build with -tags isbn_policy to
+// include it.
+func init() {
+	RegisterPlugin(&Plugin{
+		Name:         "isbn-policy",
+		BeforeCreate: checkISBNPolicy,
+		BeforeUpdate: checkISBNPolicy,
+	})
+}
+
+func checkISBNPolicy(tx *gorm.DB, b *Book) error {
+	isbn := cleanISBN(b.ISBN)
+	if len(isbn) != 13 {
+		return RejectField("isbn", "must be an ISBN-13")
+	}
+	prefixes := strings.Split(os.Getenv("ISBN_ALLOWED_PREFIXES"), ",")
+	for _, prefix := range prefixes {
+		if prefix = cleanISBN(strings.TrimSpace(prefix)); strings.HasPrefix(isbn, prefix) {
+			return nil
+		}
+	}
+	return RejectField("isbn", "is outside the prefixes this library catalogues")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Media type of RFC 7807 problem details
+const problemType = "application/problem+json"
+
+// Problem is an RFC 7807 error body. Code names the error for programs,
+// Detail explains it for people, and Fields lists the invalid fields of
+// a validation failure.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Fields   []FieldError `json:"fields,omitempty"`
+}
+
+// Whether to answer a request's errors as problems: always from versions
+// that use them, and from v1 when the client asks by accepting
+// application/problem+json
+func wantsProblem(r *http.Request) bool {
+	if versionFrom(r).problems {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == problemType {
+			return true
+		}
+	}
+	return false
+}
+
+// Write an error response: a problem with code, or for v1 clients the
+// plain text detail they have always received
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	if !wantsProblem(r) {
+		http.Error(w, detail, status)
+		return
+	}
+	writeProblem(w, r, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// Write p, filling in the members every problem has
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Instance = r.URL.Path
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", problemType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// codedError is a request error with the code its problem would carry,
+// for interfaces other than REST to report in their own way
+type codedError struct {
+	Code    string
+	Message string
+}
+
+func (e *codedError) Error() string { return e.Message }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemDetails(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	send := func(method, path, accept, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// v1 keeps plain text unless the client asks
+	response := send("GET", "/api/v1/books/99", "", "")
+	if response.Code != http.StatusNotFound || strings.TrimSpace(response.Body.String()) != "Book not found" {
+		t.Errorf("Expected a plain text 404, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for _, tc := range []struct{ path, accept string }{
+		{"/api/v2/books/99", ""},
+		{"/api/v1/books/99", problemType},
+	} {
+		response := send("GET", tc.path, tc.accept, "")
+		if ct := response.Header().Get("Content-Type"); ct != problemType {
+			t.Errorf("%s: expected %s, got %q", tc.path, problemType, ct)
+		}
+		var p Problem
+		json.Unmarshal(response.Body.Bytes(), &p)
+		want := Problem{
+			Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound,
+			Detail: "Book not found", Instance: tc.path, Code: "book_not_found",
+		}
+		if response.Code != http.StatusNotFound || p.Type != want.Type || p.Title != want.Title ||
+			p.Status != want.Status || p.Detail != want.Detail || p.Instance != want.Instance || p.Code != want.Code {
+			t.Errorf("%s: expected %+v, got %d: %s", tc.path, want, response.Code, response.Body.String())
+		}
+	}
+
+	// Validation failures carry their fields
+	response = send("POST", "/api/v2/books", "", `{"author":"Frank Herbert"}`)
+	var p Problem
+	json.Unmarshal(response.Body.Bytes(), &p)
+	if response.Code != http.StatusBadRequest || p.Code != "validation_failed" || len(p.Fields) == 0 {
+		t.Errorf("Expected a validation problem, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// Other representations read the problem's code
+	response = send("GET", "/api/v2/books/abc", jsonAPIType, "")
+	if !strings.Contains(response.Body.String(), `"code":"invalid_book_id"`) {
+		t.Errorf("Expected the code in the JSON:API errors, got %s", response.Body.String())
+	}
+}
+
+func TestHookErrorCode(t *testing.T) {
+	for _, tc := range []struct{ code, want string }{{"", "rejected"}, {"isbn_blocked", "isbn_blocked"}} {
+		req, _ := http.NewRequest("POST", "/api/v2/books", nil)
+		req = req.WithContext(context.WithValue(req.Context(), versionKey{}, apiV2))
+		response := httptest.NewRecorder()
+		writeHookError(response, req, &HookError{Status: http.StatusForbidden, Message: "Blocked", Code: tc.code})
+
+		var p Problem
+		json.Unmarshal(response.Body.Bytes(), &p)
+		if response.Code != http.StatusForbidden || p.Code != tc.want || p.Detail != "Blocked" {
+			t.Errorf("Expected code %s, got %d: %s", tc.want, response.Code, response.Body.String())
+		}
+	}
+}
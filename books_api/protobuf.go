@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The protocol buffers wire format
+// (https://protobuf.dev/programming-guides/encoding/), enough for the
+// messages of proto/books.proto: varints, strings and nested messages.
+
+// Wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+// protoEncoder appends fields to a message. Like proto3, it leaves out
+// scalars with their zero value.
+type protoEncoder struct {
+	b []byte
+}
+
+func (e *protoEncoder) tag(field, wireType int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *protoEncoder) uint(field int, v uint64) {
+	if v != 0 {
+		e.tag(field, protoVarint)
+		e.b = binary.AppendUvarint(e.b, v)
+	}
+}
+
+// Signed ints are encoded as their two's complement, as int32 and int64
+// fields are
+func (e *protoEncoder) int(field int, v int64) {
+	e.uint(field, uint64(v))
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	if s != "" {
+		e.bytes(field, []byte(s))
+	}
+}
+
+// A length-delimited field, written even when empty, as embedded
+// messages and repeated items are
+func (e *protoEncoder) bytes(field int, data []byte) {
+	e.tag(field, protoBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(data)))
+	e.b = append(e.b, data...)
+}
+
+// protoField is one field read from a message. Value holds varints and
+// Data length-delimited fields.
+type protoField struct {
+	Number   int
+	WireType int
+	Value    uint64
+	Data     []byte
+}
+
+func (f protoField) string() string { return string(f.Data) }
+
+// int32 fields are sign-extended to 64 bits on the wire
+func (f protoField) int() int64 { return int64(f.Value) }
+
+// Call fn for each field of a message in order. Fixed-width fields, which
+// no message here has, are skipped.
+func decodeProto(data []byte, fn func(f protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return errInvalidProto
+		}
+		data = data[n:]
+		f := protoField{Number: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case protoVarint:
+			f.Value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errInvalidProto
+			}
+			data = data[n:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errInvalidProto
+			}
+			f.Data = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if f.WireType == protoFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return errInvalidProto
+			}
+			data = data[size:]
+			continue
+		default:
+			return errInvalidProto
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
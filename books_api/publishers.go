@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Publisher is the house that published a book. Unlike authors,
+// publishers are managed directly: a book links to one by publisher_id,
+// which must name an existing publisher.
+type Publisher struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"uniqueIndex;not null"`
+	Country     string    `json:"country,omitempty"`
+	FoundedYear int       `json:"founded_year,omitempty"`
+	BookCount   int64     `json:"book_count" gorm:"->;-:migration"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PublisherRequest is the body of POST and PUT /publishers
+type PublisherRequest struct {
+	Name        string `json:"name"`
+	Country     string `json:"country"`
+	FoundedYear int    `json:"founded_year"`
+}
+
+// Publishers with the number of books outside the trash they published
+func publishersWithCounts(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Model(&Publisher{}).
+		Select("publishers.*, COUNT(books.id) AS book_count").
+		Scopes(joinBooks("books.publisher_id = publishers.id AND books.deleted_at IS NULL")).
+		Group("publishers.id")
+}
+
+// List the publishers alphabetically with their book counts
+func getPublishers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var publishers []Publisher
+	if err := publishersWithCounts(r.Context()).Order("publishers.name").Scan(&publishers).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list publishers")
+		return
+	}
+	writeList(w, r, publishers)
+}
+
+// Refuse a book write naming a publisher that doesn't exist. Runs in the
+// book's write transaction.
+func checkBookPublisher(tx *gorm.DB, b *Book) error {
+	if b.PublisherID == nil {
+		return nil
+	}
+	var count int64
+	err := tx.Session(&gorm.Session{NewDB: true}).Model(&Publisher{}).Where("id = ?", *b.PublisherID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return RejectField("publisher_id", "is not a known publisher")
+	}
+	return nil
+}
+
+func validatePublisher(req *PublisherRequest) []FieldError {
+	var errs []FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	req.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	if req.Country != "" && !isCountryCode(req.Country) {
+		errs = append(errs, FieldError{Field: "country", Message: "must be a two-letter ISO 3166-1 country code"})
+	}
+	if req.FoundedYear != 0 {
+		min, max := cfg.yearRange()
+		if req.FoundedYear < min || req.FoundedYear > max {
+			errs = append(errs, FieldError{
+				Field:   "founded_year",
+				Message: fmt.Sprintf("must be between %d and %d", min, max),
+			})
+		}
+	}
+	return errs
+}
+
+// Whether s has the shape of an ISO 3166-1 alpha-2 code, such as "GB"
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// Whether another publisher already has the name
+func publisherNameTaken(name string, id uint) bool {
+	var count int64
+	db.Model(&Publisher{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+	return count > 0
+}
+
+// Add a publisher
+func createPublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req PublisherRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validatePublisher(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if publisherNameTaken(req.Name, 0) {
+		writeError(w, r, http.StatusConflict, "publisher_exists", "A publisher with this name already exists")
+		return
+	}
+	publisher := Publisher{Name: req.Name, Country: req.Country, FoundedYear: req.FoundedYear}
+	if err := db.Create(&publisher).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create publisher")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(publisher.ID), 10))
+	writeJSON(w, http.StatusCreated, publisher)
+}
+
+// Load the publisher named in the URL, with its book count
+func loadPublisher(w http.ResponseWriter, r *http.Request) (*Publisher, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_publisher_id", "Invalid publisher ID")
+		return nil, false
+	}
+	var publisher Publisher
+	if err := publishersWithCounts(r.Context()).Where("publishers.id = ?", id).Take(&publisher).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "publisher_not_found", "Publisher not found")
+		return nil, false
+	}
+	return &publisher, true
+}
+
+// Get a publisher by ID
+func getPublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if publisher, ok := loadPublisher(w, r); ok {
+		writeJSON(w, http.StatusOK, publisher)
+	}
+}
+
+// List a publisher's books by title
+func getPublisherBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	publisher, ok := loadPublisher(w, r)
+	if !ok {
+		return
+	}
+	var books []Book
+	db.WithContext(r.Context()).Preload("Tags").Where("publisher_id = ?", publisher.ID).Order("title, id").Find(&books)
+	writeList(w, r, books)
+}
+
+// Replace a publisher's name, country and founding year
+func updatePublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	publisher, ok := loadPublisher(w, r)
+	if !ok {
+		return
+	}
+	var req PublisherRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validatePublisher(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if publisherNameTaken(req.Name, publisher.ID) {
+		writeError(w, r, http.StatusConflict, "publisher_exists", "A publisher with this name already exists")
+		return
+	}
+	publisher.Name, publisher.Country, publisher.FoundedYear = req.Name, req.Country, req.FoundedYear
+	if err := db.Select("Name", "Country", "FoundedYear", "UpdatedAt").Updates(publisher).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update publisher")
+		return
+	}
+	writeJSON(w, http.StatusOK, publisher)
+}
+
+// Returned when a publisher still has books
+var errPublisherHasBooks = errors.New("publisher has books")
+
+// Delete a publisher with no books, including books in the trash
+func deletePublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	publisher, ok := loadPublisher(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var books int64
+		if err := tx.Unscoped().Model(&Book{}).Where("publisher_id = ?", publisher.ID).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return errPublisherHasBooks
+		}
+		return tx.Delete(&Publisher{}, publisher.ID).Error
+	})
+	if errors.Is(err, errPublisherHasBooks) {
+		writeError(w, r, http.StatusConflict, "publisher_has_books", "Move or delete the publisher's books first")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete publisher")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
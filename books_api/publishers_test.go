@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublisherCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/publishers", `{"name":"Ace Books","country":"us","founded_year":1952}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Publisher
+	json.Unmarshal(response.Body.Bytes(), &created)
+	path := fmt.Sprintf("/api/v1/publishers/%d", created.ID)
+	if loc := response.Header().Get("Location"); loc != path {
+		t.Errorf("Expected Location %s, got %s", path, loc)
+	}
+	if created.Country != "US" || created.FoundedYear != 1952 {
+		t.Errorf("Expected the country upper-cased and the year kept, got %+v", created)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/publishers", `{"name":"Ace Books"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate name, got %d", response.Code)
+	}
+	for _, body := range []string{`{"name":" "}`, `{"name":"Gollancz","country":"GBR"}`, `{"name":"Gollancz","founded_year":3000}`} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/publishers", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, response.Code)
+		}
+	}
+
+	response = webhookRequest(t, router, "PUT", path, `{"name":"Ace","country":"US"}`)
+	var updated Publisher
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.Name != "Ace" || updated.FoundedYear != 0 {
+		t.Errorf("Expected the publisher replaced, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/publishers/abc", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestBooksLinkToPublishers(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	ace := Publisher{Name: "Ace Books"}
+	db.Create(&ace)
+
+	body := fmt.Sprintf(`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","publisher_id":%d}`, ace.ID)
+	if response := webhookRequest(t, router, "POST", "/api/v1/books", body); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	response := webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","publisher_id":999}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "publisher_id") {
+		t.Errorf("Expected status 400 for an unknown publisher, got %d: %s", response.Code, response.Body.String())
+	}
+
+	path := fmt.Sprintf("/api/v1/publishers/%d", ace.ID)
+	response = webhookRequest(t, router, "GET", path, "")
+	var got Publisher
+	json.Unmarshal(response.Body.Bytes(), &got)
+	if got.BookCount != 1 {
+		t.Errorf("Expected 1 book, got %s", response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", path+"/books", "")
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 1 || books[0].Title != "Dune" || books[0].PublisherID == nil || *books[0].PublisherID != ace.ID {
+		t.Errorf("Expected the publisher's book, got %s", response.Body.String())
+	}
+
+	// Publishers with books, even deleted ones, can't be removed
+	db.Delete(&books[0])
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 with a book in the trash, got %d", response.Code)
+	}
+}
+
+func TestPublisherWritesNeedLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"reader:pw": {Username: "reader", Roles: []string{roleReader}}})
+	router := setupRouter()
+
+	if response := webhookRequest(t, router, "GET", "/api/v1/publishers", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected reads to stay public, got %d", response.Code)
+	}
+	req, _ := http.NewRequest("POST", "/api/v1/publishers", strings.NewReader(`{"name":"Ace Books"}`))
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, router, "reader", "pw"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a reader, got %d", response.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Text fields a ?q= qualifier can name, mapped to their columns. Only
+// these names ever reach the WHERE clause.
+var queryTextColumns = map[string]string{
+	"title":       "title",
+	"author":      "author",
+	"isbn":        "isbn",
+	"description": "description",
+}
+
+// Numeric fields a ?q= qualifier can compare
+var queryNumberColumns = map[string]string{
+	"year":  "year",
+	"price": "price_cents",
+}
+
+// bookQueryTerm is one condition of a ?q= query
+type bookQueryTerm struct {
+	Field  string // "" for free text
+	Op     string // "=", ">", ">=", "<" or "<=" for numbers
+	Text   string
+	Number int
+}
+
+// Parse a ?q= query: space-separated terms that must all match. A term is
+// a word or "quoted phrase" matched anywhere in the title, author, ISBN or
+// description; field:value to match one text field, or tag:name for a tag;
+// or a numeric field compared with year:1965, year:>1995, price:<=1500.
+// Values may be quoted too: author:"le guin".
+func parseBookQuery(q string) ([]bookQueryTerm, error) {
+	var terms []bookQueryTerm
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		var token string
+		var err error
+		token, rest, err = nextQueryToken(rest)
+		if err != nil {
+			return nil, err
+		}
+		term, err := parseQueryTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		rest = strings.TrimSpace(rest)
+	}
+	return terms, nil
+}
+
+// Split the first term off s. Quotes group spaces into the term and are
+// kept, for parseQueryTerm to strip.
+func nextQueryToken(s string) (token, rest string, err error) {
+	quoted := false
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			return s[:i], s[i:], nil
+		}
+	}
+	if quoted {
+		return "", "", fmt.Errorf("q has an unclosed quote")
+	}
+	return s, "", nil
+}
+
+func parseQueryTerm(token string) (bookQueryTerm, error) {
+	field, value, qualified := strings.Cut(token, ":")
+	if !qualified || strings.HasPrefix(token, `"`) {
+		return bookQueryTerm{Text: unquote(token)}, nil
+	}
+	field = strings.ToLower(field)
+	value = unquote(value)
+	if value == "" {
+		return bookQueryTerm{}, fmt.Errorf("q: %s needs a value", field)
+	}
+	if _, ok := queryTextColumns[field]; ok || field == "tag" {
+		return bookQueryTerm{Field: field, Text: value}, nil
+	}
+	if _, ok := queryNumberColumns[field]; !ok {
+		return bookQueryTerm{}, fmt.Errorf("q: unknown field %s", field)
+	}
+	op := "="
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if v, ok := strings.CutPrefix(value, prefix); ok {
+			op, value = prefix, v
+			break
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return bookQueryTerm{}, fmt.Errorf("q: %s must be compared with an integer", field)
+	}
+	return bookQueryTerm{Field: field, Op: op, Number: n}, nil
+}
+
+func unquote(s string) string {
+	return strings.TrimSpace(strings.ReplaceAll(s, `"`, ""))
+}
+
+// Compose the term's condition onto query, binding its value
+func (t bookQueryTerm) apply(query *gorm.DB) *gorm.DB {
+	const like = `LOWER(%s) LIKE ? ESCAPE '\'`
+	switch {
+	case t.Field == "":
+		pattern := containsPattern(t.Text)
+		var conds []string
+		args := make([]interface{}, 0, len(queryTextColumns))
+		for _, field := range []string{"title", "author", "isbn", "description"} {
+			conds = append(conds, fmt.Sprintf(like, queryTextColumns[field]))
+			args = append(args, pattern)
+		}
+		return query.Where("("+strings.Join(conds, " OR ")+")", args...)
+	case t.Field == "tag":
+		return query.Where("id IN (SELECT book_tags.book_id FROM book_tags JOIN tags ON tags.id = book_tags.tag_id WHERE LOWER(tags.name) = ?)", strings.ToLower(t.Text))
+	case t.Op != "":
+		return query.Where(queryNumberColumns[t.Field]+" "+t.Op+" ?", t.Number)
+	}
+	return query.Where(fmt.Sprintf(like, queryTextColumns[t.Field]), containsPattern(t.Text))
+}
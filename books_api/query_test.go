@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestBooksQuery(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Refactoring", Author: "Martin Fowler", ISBN: "9780201485677", Year: 1999},
+		{Title: "Refactoring, 2nd Edition", Author: "Martin Fowler", ISBN: "9780134757599", Year: 2018, PriceCents: 4999},
+		{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884", Year: 2008, Description: "A handbook of agile software craftsmanship"},
+		{Title: "The Clean Coder", Author: "Robert C. Martin", ISBN: "9780137081073", Year: 2011},
+		{Title: "The Left Hand of Darkness", Author: "Ursula K. Le Guin", ISBN: "9780441478125", Year: 1969, Tags: []Tag{{Name: "scifi"}}},
+	} {
+		db.Create(&b)
+	}
+	router := setupRouter()
+
+	cases := map[string][]string{
+		`author:fowler year:>1995`:           {"Refactoring", "Refactoring, 2nd Edition"},
+		`author:fowler year:>=2000`:          {"Refactoring, 2nd Edition"},
+		`"clean code"`:                       {"Clean Code", "The Clean Coder"},
+		`"code clean"`:                       {},
+		`clean code year:<2010`:              {"Clean Code"},
+		`author:"le guin"`:                   {"The Left Hand of Darkness"},
+		`tag:SciFi`:                          {"The Left Hand of Darkness"},
+		`price:<5000 price:>0`:               {"Refactoring, 2nd Edition"},
+		`year:1969`:                          {"The Left Hand of Darkness"},
+		`craftsmanship`:                      {"Clean Code"},
+		`TITLE:coder`:                        {"The Clean Coder"},
+		`author:fowler year:>1995 "2nd"`:     {"Refactoring, 2nd Edition"},
+		`'; DROP TABLE books; --`:            {},
+		`author:fowler title:"%" year:<1990`: {},
+	}
+	for q, want := range cases {
+		req, _ := http.NewRequest("GET", "/api/v1/books?sort=id&q="+url.QueryEscape(q), nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if got := bookTitles(books); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v for %s, got %d: %v", want, q, response.Code, got)
+		}
+	}
+
+	for _, q := range []string{`"clean code`, `publisher:penguin`, `year:>recent`, `author:`} {
+		req, _ := http.NewRequest("GET", "/api/v1/books?q="+url.QueryEscape(q), nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", q, response.Code)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+const maxRandomBooks = 50
+
+// Pick up to n distinct books matching filter, uniformly. Rather than
+// sorting the whole table by RANDOM(), it counts the matches and reads
+// the books at n random positions in id order, so each pick walks the
+// primary key index and no row is scored or sorted.
+func randomBooks(ctx context.Context, filter bookFilter, n int) ([]Book, error) {
+	var total int64
+	if err := filter.apply(db.WithContext(ctx).Model(&Book{})).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	var offsets []int
+	if total <= int64(n) {
+		offsets = rand.Perm(int(total))
+	} else {
+		seen := make(map[int64]bool, n)
+		for len(offsets) < n {
+			if k := rand.Int63n(total); !seen[k] {
+				seen[k] = true
+				offsets = append(offsets, int(k))
+			}
+		}
+	}
+
+	books := make([]Book, 0, len(offsets))
+	for _, k := range offsets {
+		var book Book
+		err := filter.apply(db.WithContext(ctx).Preload("Tags")).Order("id").Offset(k).Limit(1).Find(&book).Error
+		if err != nil {
+			return nil, err
+		}
+		// A book deleted since the count leaves a gap at the end
+		if book.ID != 0 {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+// Random books for discovery, ?count=n of them (default 1), narrowed by
+// the listing filters
+func getRandomBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	count := 1
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRandomBooks {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "count must be between 1 and 50")
+			return
+		}
+		count = n
+	}
+	filter, err := parseBookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+
+	books, err := randomBooks(r.Context(), filter, count)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to pick books")
+		return
+	}
+	// Each request picks anew
+	w.Header().Set("Cache-Control", "no-store")
+	writeList(w, r, books)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRandomBooks(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965})
+	db.Create(&Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780593098233", Year: 1969})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815})
+	router := setupRouter()
+
+	get := func(query string) ([]Book, *httptest.ResponseRecorder) {
+		req, _ := http.NewRequest("GET", "/api/v1/books/random"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		return books, response
+	}
+
+	if books, response := get(""); len(books) != 1 {
+		t.Errorf("Expected one book, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// More than match returns every match, each once
+	books, _ := get("?count=5")
+	seen := map[uint]bool{}
+	for _, b := range books {
+		seen[b.ID] = true
+	}
+	if len(books) != 3 || len(seen) != 3 {
+		t.Errorf("Expected all 3 books once, got %+v", books)
+	}
+
+	for i := 0; i < 10; i++ {
+		books, _ := get("?count=2&author=herbert&year_max=1966")
+		if len(books) != 1 || books[0].Title != "Dune" {
+			t.Fatalf("Expected only Dune, got %+v", books)
+		}
+	}
+
+	if _, response := get("?count=51"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for count=51, got %d", response.Code)
+	}
+
+	clearDB()
+	if books, response := get(""); response.Code != http.StatusOK || len(books) != 0 {
+		t.Errorf("Expected an empty list, got %d: %s", response.Code, response.Body.String())
+	}
+}
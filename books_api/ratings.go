@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// A book's average_rating and review_count are stored on the book, so the
+// listing can sort by rating and page through it with cursors. Writing a
+// review recomputes them; book writes never touch them.
+
+// Sets books' ratings from their reviews, for the books the WHERE clause
+// it ends with selects
+const updateBookRatings = `UPDATE books SET
+	review_count = (SELECT COUNT(*) FROM reviews WHERE book_id = books.id),
+	average_rating = (SELECT COALESCE(AVG(rating), 0) FROM reviews WHERE book_id = books.id AND rating > 0)
+	WHERE `
+
+// Recompute a book's rating from its reviews. Reviews without a rating,
+// such as text-only imports, count as reviews but not towards the
+// average.
+func refreshBookRating(tx *gorm.DB, bookID uint) error {
+	err := tx.Session(&gorm.Session{NewDB: true}).Exec(updateBookRatings+"id = ?", bookID).Error
+	if err != nil {
+		return err
+	}
+	invalidateCache(bookCacheKey(bookID))
+	return nil
+}
+
+func (rv *Review) AfterCreate(tx *gorm.DB) error {
+	return refreshBookRating(tx, rv.BookID)
+}
+
+func (rv *Review) AfterDelete(tx *gorm.DB) error {
+	// Bulk deletes, such as purging a book's reviews, carry no review
+	if rv.BookID == 0 {
+		return nil
+	}
+	return refreshBookRating(tx, rv.BookID)
+}
+
+// Compute the ratings of books reviewed before ratings were stored. Does
+// nothing once they are.
+func backfillRatings() {
+	err := db.Exec(updateBookRatings + "review_count = 0 AND id IN (SELECT book_id FROM reviews)").Error
+	if err != nil {
+		log.Fatal("Failed to backfill ratings:", err)
+	}
+}
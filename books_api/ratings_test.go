@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBookRatings(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	db.Create(&dune)
+	db.Create(&emma)
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"})
+	for _, rv := range []Review{
+		{BookID: dune.ID, Rating: 5},
+		{BookID: dune.ID, Rating: 4},
+		{BookID: dune.ID, Body: "No rating, just words"},
+		{BookID: emma.ID, Rating: 3},
+	} {
+		db.Create(&rv)
+	}
+
+	response := webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/books/%d", dune.ID), "")
+	var got Book
+	json.Unmarshal(response.Body.Bytes(), &got)
+	if got.AverageRating != 4.5 || got.ReviewCount != 3 {
+		t.Errorf("Expected 4.5 over 3 reviews, got %s", response.Body.String())
+	}
+
+	if titles := getTitles(t, router, "/api/v1/books?sort=-rating"); !reflect.DeepEqual(titles, []string{"Dune", "Emma", "Neuromancer"}) {
+		t.Errorf("Expected best-rated first, got %v", titles)
+	}
+	if titles := getTitles(t, router, "/api/v1/books?sort=rating"); !reflect.DeepEqual(titles, []string{"Neuromancer", "Emma", "Dune"}) {
+		t.Errorf("Expected the unrated book first, got %v", titles)
+	}
+
+	// Book writes don't overwrite the rating
+	response = webhookRequest(t, router, "PUT", fmt.Sprintf("/api/v1/books/%d", emma.ID), `{"year":1815,"average_rating":5,"review_count":9}`)
+	var stored Book
+	db.First(&stored, emma.ID)
+	if response.Code != http.StatusOK || stored.AverageRating != 3 || stored.ReviewCount != 1 {
+		t.Errorf("Expected the rating kept, got %+v", stored)
+	}
+}
+
+func TestBackfillRatings(t *testing.T) {
+	clearDB()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&dune)
+	db.Create(&Review{BookID: dune.ID, Rating: 4})
+	db.Exec("UPDATE books SET average_rating = 0, review_count = 0")
+
+	backfillRatings()
+	var stored Book
+	db.First(&stored, dune.ID)
+	if stored.AverageRating != 4 || stored.ReviewCount != 1 {
+		t.Errorf("Expected the rating backfilled, got %v over %d", stored.AverageRating, stored.ReviewCount)
+	}
+}
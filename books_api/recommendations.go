@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of reader interaction, and how strongly each signals interest
+var interactionWeights = map[string]float64{
+	"favorite": 3,
+	"loan":     2,
+	"shelf":    1,
+}
+
+// Tuning for the co-occurrence job
+const (
+	// Neighbours kept per book
+	maxSimilarBooks = 20
+	// Readers with more books than this are left out of pair counting;
+	// they connect everything to everything and dominate the cost
+	maxReaderBooks = 500
+
+	// Page size of recommendation lists
+	defaultRecommendations = 10
+	maxRecommendations     = 50
+
+	// Related books: points for the same author, for each shared tag and
+	// for a publication year at most relatedYearSpan years apart
+	relatedAuthorScore = 4
+	relatedTagScore    = 2
+	relatedYearScore   = 1
+	relatedYearSpan    = 5
+)
+
+// Interaction records that a member borrowed, shelved or favorited a
+// book outside the catalog's own loans and reading lists, which count
+// without one
+type Interaction struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	BookID    uint      `json:"book_id" gorm:"index;not null"`
+	Kind      string    `json:"kind" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookSimilarity is a precomputed "readers of BookID also read OtherID" score
+type BookSimilarity struct {
+	BookID  uint `gorm:"primaryKey;autoIncrement:false"`
+	OtherID uint `gorm:"primaryKey;autoIncrement:false"`
+	Score   float64
+}
+
+// What readers did with books, as reader, book_id and kind rows: recorded
+// interactions, loans and the books on reading lists. A reader is the
+// member ("member:7"), or for a list whose owner has no card, the login
+// or the list itself.
+func readerActivity(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Raw(`SELECT 'member:' || user_id AS reader, book_id, kind FROM interactions
+		UNION ALL
+		SELECT 'member:' || members.id, loans.book_id, 'loan' FROM loans
+			JOIN members ON members.membership_number = loans.borrower
+		UNION ALL
+		SELECT COALESCE('member:' || members.id,
+				CASE WHEN reading_lists.username <> '' THEN 'user:' || reading_lists.username ELSE 'list:' || reading_lists.id END),
+			list_entries.book_id, 'shelf' FROM list_entries
+			JOIN reading_lists ON reading_lists.id = list_entries.list_id
+			LEFT JOIN members ON members.username = reading_lists.username AND reading_lists.username <> ''`)
+}
+
+// Record a reader interaction. {id} is a member's ID; readers may only
+// record their own.
+func createInteraction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	member, ok := loadMember(w, r)
+	if !ok {
+		return
+	}
+	if !canActForMember(r, member) {
+		writeError(w, r, http.StatusForbidden, "forbidden", "Only the member or staff can record their interactions")
+		return
+	}
+
+	var in Interaction
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	if _, ok := interactionWeights[in.Kind]; !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "kind must be one of favorite, loan or shelf")
+		return
+	}
+	if err := db.WithContext(r.Context()).First(&Book{}, in.BookID).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+		return
+	}
+
+	in.ID = 0
+	in.UserID = member.ID
+	if err := db.Create(&in).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record interaction")
+		return
+	}
+	writeJSON(w, http.StatusCreated, in)
+}
+
+// Parse ?limit= for recommendation lists
+func recommendationLimit(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultRecommendations, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxRecommendations {
+		return 0, false
+	}
+	return n, true
+}
+
+// Books most often read by readers of this book
+func getAlsoRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := recommendationLimit(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 50")
+		return
+	}
+
+	var books []Book
+	db.WithContext(r.Context()).Joins("JOIN book_similarities s ON s.other_id = books.id").
+		Where("s.book_id = ?", book.ID).
+		Order("s.score DESC, books.id").Limit(limit).Find(&books)
+	writeList(w, r, books)
+}
+
+// Books like this one by their catalog data alone: the same author,
+// shared tags (the catalog's genres) and a similar year. Unlike also-read
+// this needs no reader history, so new books have related books too.
+func getRelatedBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := recommendationLimit(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 50")
+		return
+	}
+
+	tags := db.Table("book_tags").Select("tag_id").Where("book_id = ?", book.ID)
+	shared := db.Table("book_tags").Select("COUNT(*)").Where("book_tags.book_id = books.id AND book_tags.tag_id IN (?)", tags)
+	score := "(CASE WHEN books.author = @author THEN @authorScore ELSE 0 END)" +
+		" + @tagScore * (@shared)" +
+		" + (CASE WHEN @year <> 0 AND books.year BETWEEN @year - @span AND @year + @span THEN @yearScore ELSE 0 END)"
+	args := map[string]interface{}{
+		"author": book.Author, "authorScore": relatedAuthorScore,
+		"tagScore": relatedTagScore, "shared": shared,
+		"year": book.Year, "span": relatedYearSpan, "yearScore": relatedYearScore,
+	}
+
+	var books []Book
+	err := db.WithContext(r.Context()).Preload("Tags").
+		Where("books.id <> ?", book.ID).
+		Where(score+" > 0", args).
+		Order(clause.OrderBy{Expression: clause.NamedExpr{SQL: score + " DESC, books.id", Vars: []interface{}{args}}}).
+		Limit(limit).Find(&books).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to find related books")
+		return
+	}
+	writeList(w, r, books)
+}
+
+// Personal recommendations from the books a member has interacted with,
+// borrowed or listed. Members with no history get the most popular books.
+func getUserRecommendations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		return
+	}
+	limit, ok := recommendationLimit(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 50")
+		return
+	}
+
+	seen := db.Table("(?) AS activity", readerActivity(r.Context())).Select("book_id").Where("reader = ?", fmt.Sprintf("member:%d", userID))
+
+	var books []Book
+	db.WithContext(r.Context()).Joins("JOIN book_similarities s ON s.other_id = books.id").
+		Where("s.book_id IN (?) AND books.id NOT IN (?)", seen, seen).
+		Group("books.id").Order("SUM(s.score) DESC, books.id").Limit(limit).Find(&books)
+
+	if len(books) == 0 {
+		db.WithContext(r.Context()).Joins("JOIN (?) AS activity ON activity.book_id = books.id", readerActivity(r.Context())).
+			Where("books.id NOT IN (?)", seen).
+			Group("books.id").Order("COUNT(*) DESC, books.id").Limit(limit).Find(&books)
+	}
+	writeList(w, r, books)
+}
+
+// Recompute every book's neighbours from reader activity. Each reader
+// counts once per book, at the strongest interaction they had with it, and
+// scores are cosine-normalised so popular books don't swamp the lists.
+func computeRecommendations(ctx context.Context) error {
+	var rows []struct {
+		Reader string
+		BookID uint
+		Kind   string
+	}
+	if err := readerActivity(ctx).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	readers := make(map[string]map[uint]float64)
+	for _, row := range rows {
+		books := readers[row.Reader]
+		if books == nil {
+			books = make(map[uint]float64)
+			readers[row.Reader] = books
+		}
+		if w := interactionWeights[row.Kind]; w > books[row.BookID] {
+			books[row.BookID] = w
+		}
+	}
+
+	type pair struct{ a, b uint }
+	co := make(map[pair]float64)
+	norms := make(map[uint]float64)
+	for _, books := range readers {
+		if len(books) > maxReaderBooks {
+			continue
+		}
+		for a, wa := range books {
+			norms[a] += wa * wa
+			for b, wb := range books {
+				if a != b {
+					co[pair{a, b}] += wa * wb
+				}
+			}
+		}
+	}
+
+	neighbours := make(map[uint][]BookSimilarity)
+	for p, dot := range co {
+		score := dot / math.Sqrt(norms[p.a]*norms[p.b])
+		neighbours[p.a] = append(neighbours[p.a], BookSimilarity{BookID: p.a, OtherID: p.b, Score: score})
+	}
+	var similarities []BookSimilarity
+	for _, list := range neighbours {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Score != list[j].Score {
+				return list[i].Score > list[j].Score
+			}
+			return list[i].OtherID < list[j].OtherID
+		})
+		if len(list) > maxSimilarBooks {
+			list = list[:maxSimilarBooks]
+		}
+		similarities = append(similarities, list...)
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&BookSimilarity{}).Error; err != nil {
+			return err
+		}
+		if len(similarities) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(similarities, 500).Error
+	})
+}
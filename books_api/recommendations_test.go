@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fetch a list of books and return their titles
+func getTitles(t *testing.T, router http.Handler, path string) []string {
+	req, _ := http.NewRequest("GET", path, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from %s, got %d: %s", path, response.Code, response.Body.String())
+	}
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	titles := []string{}
+	for _, b := range books {
+		titles = append(titles, b.Title)
+	}
+	return titles
+}
+
+func seedInteractions(t *testing.T) {
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+	} {
+		db.Create(&b)
+	}
+	for _, in := range []Interaction{
+		{UserID: 1, BookID: 1, Kind: "favorite"},
+		{UserID: 1, BookID: 2, Kind: "loan"},
+		{UserID: 2, BookID: 1, Kind: "loan"},
+		{UserID: 2, BookID: 2, Kind: "shelf"},
+		{UserID: 2, BookID: 3, Kind: "shelf"},
+		{UserID: 3, BookID: 4, Kind: "favorite"},
+	} {
+		db.Create(&in)
+	}
+	if err := computeRecommendations(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlsoRead(t *testing.T) {
+	clearDB()
+	seedInteractions(t)
+	router := setupRouter()
+
+	// Both readers of Dune read the Messiah; only one read Neuromancer
+	if titles := getTitles(t, router, "/api/v1/books/1/also-read"); strings.Join(titles, ",") != "Dune Messiah,Neuromancer" {
+		t.Errorf("Unexpected also-read list %v", titles)
+	}
+	if titles := getTitles(t, router, "/api/v1/books/4/also-read"); len(titles) != 0 {
+		t.Errorf("Expected no neighbours for a book read alone, got %v", titles)
+	}
+}
+
+func TestUserRecommendations(t *testing.T) {
+	clearDB()
+	seedInteractions(t)
+	router := setupRouter()
+
+	// Recommendations exclude what the user already has
+	if titles := getTitles(t, router, "/api/v1/users/1/recommendations"); strings.Join(titles, ",") != "Neuromancer" {
+		t.Errorf("Unexpected recommendations %v", titles)
+	}
+
+	// A reader with no history gets the most popular books
+	if titles := getTitles(t, router, "/api/v1/users/99/recommendations?limit=2"); strings.Join(titles, ",") != "Dune,Dune Messiah" {
+		t.Errorf("Unexpected cold-start recommendations %v", titles)
+	}
+}
+
+func TestCreateInteraction(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	member := addMember(t, "card-1001")
+	path := fmt.Sprintf("/api/v1/users/%d/interactions", member.ID)
+
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(`{"book_id":1,"kind":"favorite"}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var count int64
+	db.Model(&Interaction{}).Where("user_id = ? AND book_id = ?", member.ID, 1).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the interaction to be stored, got %d", count)
+	}
+
+	req, _ = http.NewRequest("POST", path, bytes.NewBufferString(`{"book_id":1,"kind":"liked"}`))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown kind, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/users/99/interactions", `{"book_id":1,"kind":"favorite"}`); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown member, got %d", response.Code)
+	}
+}
+
+func TestInteractionsNeedOwner(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"rita:pw": {Username: "rita", Roles: []string{roleReader}},
+		"sam:pw":  {Username: "sam", Roles: []string{roleReader}},
+		"lib:pw":  {Username: "lib", Roles: []string{roleLibrarian}},
+	})
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	rita := Member{Name: "Rita", MembershipNumber: "card-1001", Status: memberActive, Username: "rita"}
+	db.Create(&rita)
+	path := fmt.Sprintf("/api/v1/users/%d/interactions", rita.ID)
+
+	for _, tt := range []struct {
+		username string
+		want     int
+	}{
+		{"", http.StatusUnauthorized},
+		{"sam", http.StatusForbidden},
+		{"rita", http.StatusCreated},
+		{"lib", http.StatusCreated},
+	} {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"book_id":1,"kind":"shelf"}`))
+		if tt.username != "" {
+			req.Header.Set("Authorization", "Bearer "+loginAs(t, router, tt.username, "pw"))
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != tt.want {
+			t.Errorf("As %q: expected status %d, got %d", tt.username, tt.want, response.Code)
+		}
+	}
+}
+
+func TestRecommendationsFromLoansAndLists(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	var books []Book
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"},
+	} {
+		db.Create(&b)
+		books = append(books, b)
+	}
+	// One reader borrowed Dune and listed the Messiah; a list without a
+	// card pairs the Messiah with Emma
+	rita := Member{Name: "Rita", MembershipNumber: "card-1001", Status: memberActive, Username: "rita"}
+	db.Create(&rita)
+	db.Create(&Loan{BookID: books[0].ID, Borrower: "card-1001", CheckedOutAt: time.Now(), DueAt: time.Now().Add(time.Hour)})
+	ritas := ReadingList{Name: "Next", Username: "rita"}
+	anon := ReadingList{Name: "Austen and more"}
+	db.Create(&ritas)
+	db.Create(&anon)
+	db.Create(&ListEntry{ListID: ritas.ID, BookID: books[1].ID, Position: 1})
+	db.Create(&ListEntry{ListID: anon.ID, BookID: books[1].ID, Position: 1})
+	db.Create(&ListEntry{ListID: anon.ID, BookID: books[2].ID, Position: 2})
+	if err := computeRecommendations(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if titles := getTitles(t, router, fmt.Sprintf("/api/v1/books/%d/also-read", books[0].ID)); strings.Join(titles, ",") != "Dune Messiah" {
+		t.Errorf("Expected the loan and the list entry paired, got %v", titles)
+	}
+	// Rita has borrowed and listed both Dunes, so only Emma is left
+	if titles := getTitles(t, router, fmt.Sprintf("/api/v1/users/%d/recommendations", rita.ID)); strings.Join(titles, ",") != "Emma" {
+		t.Errorf("Unexpected recommendations %v", titles)
+	}
+}
+
+func TestRelatedBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Tags: []Tag{{Name: "sf"}, {Name: "classics"}}},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696", Year: 1969},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984, Tags: []Tag{{ID: 1, Name: "sf"}}},
+		{Title: "Foundation", Author: "Isaac Asimov", ISBN: "9780553293357", Year: 1951, Tags: []Tag{{ID: 1, Name: "sf"}, {ID: 2, Name: "classics"}}},
+		{Title: "Stand on Zanzibar", Author: "John Brunner", ISBN: "9780312861797", Year: 1968},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+	} {
+		db.Create(&b)
+	}
+
+	// The same author from the same decade outranks two shared tags, which
+	// outrank one; a similar year alone counts least and Emma not at all
+	if titles := getTitles(t, router, "/api/v1/books/1/related"); strings.Join(titles, ",") != "Dune Messiah,Foundation,Neuromancer,Stand on Zanzibar" {
+		t.Errorf("Unexpected related books %v", titles)
+	}
+	if titles := getTitles(t, router, "/api/v1/books/1/related?limit=1"); strings.Join(titles, ",") != "Dune Messiah" {
+		t.Errorf("Expected the limit applied, got %v", titles)
+	}
+	if titles := getTitles(t, router, "/api/v1/books/6/related"); len(titles) != 0 {
+		t.Errorf("Expected nothing related to Emma, got %v", titles)
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/books/99/related":         http.StatusNotFound,
+		"/api/v1/books/1/related?limit=51": http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != status {
+			t.Errorf("%s: expected %d, got %d", path, status, response.Code)
+		}
+	}
+}
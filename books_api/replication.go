@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Replication modes
+const (
+	replicationNone       = "none"
+	replicationLitestream = "litestream"
+	replicationLiteFS     = "litefs"
+)
+
+// LiteFS roles
+const (
+	rolePrimary = "primary"
+	roleReplica = "replica"
+)
+
+// ReplicationStatus is the replication part of /readyz
+type ReplicationStatus struct {
+	Mode        string `json:"mode"`
+	Healthy     bool   `json:"healthy"`
+	JournalMode string `json:"journal_mode"`
+	// LiteFS only: this node's role, and the primary when it is a replica
+	Role    string `json:"role,omitempty"`
+	Primary string `json:"primary,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Set the pragmas a replicated database needs. Litestream must be the only
+// thing that checkpoints the WAL, or it can miss frames; LiteFS replicates
+// the WAL itself, so both need WAL mode.
+func configureReplication(db *gorm.DB) {
+	switch cfg.Replication {
+	case "", replicationNone:
+		return
+	case replicationLitestream, replicationLiteFS:
+	default:
+		log.Fatalf("Unknown REPLICATION %q", cfg.Replication)
+	}
+
+	pragmas := []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000"}
+	if cfg.Replication == replicationLitestream {
+		pragmas = append(pragmas, "PRAGMA wal_autocheckpoint = 0")
+	}
+	for _, p := range pragmas {
+		if err := db.Exec(p).Error; err != nil {
+			log.Fatalf("Configuring %s replication: %s: %v", cfg.Replication, p, err)
+		}
+	}
+	// busy_timeout and wal_autocheckpoint are per connection, so keep to
+	// the one connection they were set on
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+}
+
+// Check that replication is working on this node
+func checkReplication(ctx context.Context) ReplicationStatus {
+	s := ReplicationStatus{Mode: cfg.Replication}
+	if s.Mode == "" {
+		s.Mode = replicationNone
+	}
+	db.WithContext(ctx).Raw("PRAGMA journal_mode").Scan(&s.JournalMode)
+
+	var err error
+	switch s.Mode {
+	case replicationNone:
+	case replicationLitestream:
+		err = checkLitestream(ctx, s.JournalMode)
+	case replicationLiteFS:
+		s.Role, s.Primary, err = liteFSRole(liteFSDir())
+		if err == nil && s.JournalMode != "wal" {
+			err = fmt.Errorf("journal mode is %s, not wal", s.JournalMode)
+		}
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.Healthy = err == nil
+	return s
+}
+
+// Litestream is attached once it has created its sequence table, and its
+// metrics endpoint answers when it is still running
+func checkLitestream(ctx context.Context, journalMode string) error {
+	if journalMode != "wal" {
+		return fmt.Errorf("journal mode is %s, not wal", journalMode)
+	}
+	var tables int64
+	db.WithContext(ctx).Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = '_litestream_seq'").Scan(&tables)
+	if tables == 0 {
+		return errors.New("litestream has not attached to the database")
+	}
+	if cfg.LitestreamMetricsURL == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.LitestreamMetricsURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("litestream is not responding: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("litestream metrics returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Directory LiteFS is mounted at
+func liteFSDir() string {
+	if cfg.LiteFSDir != "" {
+		return cfg.LiteFSDir
+	}
+	return filepath.Dir(cfg.DBPath)
+}
+
+// LiteFS writes a .primary file naming the primary on every replica; the
+// primary itself has none
+func liteFSRole(dir string) (role, primary string, err error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", "", fmt.Errorf("litefs mount: %w", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".primary"))
+	if errors.Is(err, os.ErrNotExist) {
+		return rolePrimary, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return roleReplica, strings.TrimSpace(string(data)), nil
+}
+
+// Report whether this node is a LiteFS replica, which can't write. Scheduled
+// jobs only run on the primary.
+func isReadOnlyReplica() (bool, string) {
+	if cfg.Replication != replicationLiteFS {
+		return false, ""
+	}
+	role, primary, err := liteFSRole(liteFSDir())
+	return err == nil && role == roleReplica, primary
+}
+
+// Readiness is the /readyz response: each check's result by name
+type Readiness struct {
+	Checks map[string]interface{} `json:"checks"`
+	Status string                 `json:"status"`
+}
+
+// Readiness: the database answers and, when configured, replication is
+// healthy. Unlike /health, a failing check returns 503 so load balancers
+// stop routing to the node.
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	checks := map[string]interface{}{}
+	ready := true
+
+	database := "ok"
+	if sqlDB, err := db.DB(); err != nil {
+		database = err.Error()
+	} else if err := sqlDB.PingContext(r.Context()); err != nil {
+		database = err.Error()
+	}
+	if database != "ok" {
+		ready = false
+	}
+	checks["database"] = database
+
+	replication := checkReplication(r.Context())
+	if !replication.Healthy {
+		ready = false
+	}
+	checks["replication"] = replication
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, Readiness{Status: status, Checks: checks})
+}
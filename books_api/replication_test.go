@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLiteFSRole(t *testing.T) {
+	dir := t.TempDir()
+	if role, _, err := liteFSRole(dir); err != nil || role != rolePrimary {
+		t.Errorf("Expected primary without a .primary file, got %q, %v", role, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, ".primary"), []byte("books-1\n"), 0o644)
+	if role, primary, err := liteFSRole(dir); err != nil || role != roleReplica || primary != "books-1" {
+		t.Errorf("Expected replica of books-1, got %q %q, %v", role, primary, err)
+	}
+
+	if _, _, err := liteFSRole(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing mount to be an error")
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 without replication, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// The in-memory test database is never attached to Litestream
+	saved := cfg.Replication
+	cfg.Replication = replicationLitestream
+	defer func() { cfg.Replication = saved }()
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got %d", response.Code)
+	}
+	var body struct {
+		Status string
+		Checks struct{ Replication ReplicationStatus }
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if body.Status != "unavailable" || body.Checks.Replication.Healthy || body.Checks.Replication.Error == "" {
+		t.Errorf("Unexpected readiness %s", response.Body.String())
+	}
+}
+
+func TestSchedulerSkipsLiteFSReplica(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".primary"), []byte("books-1"), 0o644)
+	savedMode, savedDir := cfg.Replication, cfg.LiteFSDir
+	cfg.Replication, cfg.LiteFSDir = replicationLiteFS, dir
+	defer func() { cfg.Replication, cfg.LiteFSDir = savedMode, savedDir }()
+
+	if replica, primary := isReadOnlyReplica(); !replica || primary != "books-1" {
+		t.Fatalf("Expected a replica of books-1, got %v %q", replica, primary)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/admin/scheduled-jobs/backup/run", nil)
+	response := httptest.NewRecorder()
+	setupRouter().ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 on a replica, got %d", response.Code)
+	}
+}
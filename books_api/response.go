@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Write v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Write a collection response. Collections are always encoded as a JSON
+// array, never null, so clients can iterate without checking.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	total := int64(len(items))
+	writeJSON(w, http.StatusOK, enveloped(r, listOf(items), ListMeta{Total: &total, Page: 1}))
+}
+
+// ListMeta describes the list in an Envelope
+type ListMeta struct {
+	// Items in the whole collection, when known
+	Total      *int64 `json:"total,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Requested IDs that matched nothing
+	Missing []uint `json:"missing,omitempty"`
+	// Value counts among the filtered items, when ?facets= asks for them
+	Facets Facets `json:"facets,omitempty"`
+}
+
+// Envelope wraps list responses in API versions that use one, so metadata
+// can travel with the items without changing their shape
+type Envelope struct {
+	Data interface{} `json:"data"`
+	Meta ListMeta    `json:"meta"`
+}
+
+// A list response for the request's version: data in an Envelope with
+// meta, or the bare data. JSON:API and HAL documents carry their own
+// metadata, so they are never wrapped.
+func enveloped(r *http.Request, data interface{}, meta ListMeta) interface{} {
+	if !versionFrom(r).envelope {
+		return data
+	}
+	if _, ok := documentWriters[negotiateFormat(r.Header.Get("Accept"))]; ok && strings.HasPrefix(apiPath(r), "/books") {
+		return data
+	}
+	return Envelope{Data: data, Meta: meta}
+}
+
+// Return items, or an empty non-nil slice when items is nil
+func listOf[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteListNilIsEmptyArray(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/books", nil)
+	writeList[Book](response, req, nil)
+
+	if body := strings.TrimSpace(response.Body.String()); body != "[]" {
+		t.Errorf("Expected [], got %s", body)
+	}
+	if ct := response.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %s", ct)
+	}
+}
+
+func TestGetBooksEmptyIsArrayNotNull(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/books", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if body := strings.TrimSpace(response.Body.String()); body != "[]" {
+		t.Errorf("Expected [], got %s", body)
+	}
+}
+
+func TestValidationErrorFieldsAlwaysArray(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/books", nil)
+	writeValidationErrors(response, req, nil)
+
+	if !strings.Contains(response.Body.String(), `"fields":[]`) {
+		t.Errorf("Expected fields to be an empty array, got %s", response.Body.String())
+	}
+}
+
+func TestListEnvelope(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+	router := setupRouter()
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// v1 lists stay bare arrays
+	if body := get("/api/v1/books", "").Body.String(); !strings.HasPrefix(body, "[") {
+		t.Errorf("Expected an array from v1, got %s", body)
+	}
+
+	var env struct {
+		Data []Book   `json:"data"`
+		Meta ListMeta `json:"meta"`
+	}
+	json.Unmarshal(get("/api/v2/books", "").Body.Bytes(), &env)
+	if len(env.Data) != 2 || env.Meta.Total == nil || *env.Meta.Total != 2 || env.Meta.Page != 1 {
+		t.Errorf("Expected 2 books with total 2 on page 1, got %+v", env)
+	}
+
+	// Cursors move to meta
+	env.Data, env.Meta = nil, ListMeta{}
+	json.Unmarshal(get("/api/v2/books?limit=1", "").Body.Bytes(), &env)
+	if len(env.Data) != 1 || env.Meta.NextCursor == "" || *env.Meta.Total != 2 {
+		t.Errorf("Expected one book and a next cursor, got %+v", env)
+	}
+
+	// HAL has its own envelope
+	if body := get("/api/v2/books", halType).Body.String(); strings.Contains(body, `"meta"`) || !strings.Contains(body, `"_embedded"`) {
+		t.Errorf("Expected a bare HAL collection, got %s", body)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// BookRevision is a book as it was before a write replaced it. Each is
+// numbered by the book's version at the time, so numbers grow with every
+// write but can skip, for example over deletes.
+type BookRevision struct {
+	ID     uint `json:"-" gorm:"primaryKey"`
+	BookID uint `json:"-" gorm:"index;not null"`
+	Number uint `json:"number" gorm:"not null"`
+	// The book's fields as JSON
+	Data string `json:"-" gorm:"type:text;not null"`
+	// When the revision was replaced
+	CreatedAt time.Time `json:"created_at"`
+
+	Book *Book `json:"book" gorm:"-"`
+	// What the write that replaced the revision changed
+	Changes []FieldChange `json:"changes" gorm:"-"`
+}
+
+// FieldChange is one field's value before and after a write
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Save the book as it is before a write, in the write's transaction.
+// Runs after the version is bumped, so the book's row is locked and its
+// old version is one less.
+func recordBookRevision(tx *gorm.DB, b *Book) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	var before Book
+	if err := tx.Unscoped().Take(&before, b.ID).Error; err != nil {
+		return err
+	}
+	data, err := json.Marshal(&before)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&BookRevision{BookID: b.ID, Number: b.Version - 1, Data: string(data)}).Error
+}
+
+// The fields a revision restores: those a patch can set, and the book's
+// links to its publisher, series and work
+func revisionFields(b *Book) map[string]interface{} {
+	fields := newBookDocument(b).value()
+	for name, id := range map[string]*uint{"publisher_id": b.PublisherID, "series_id": b.SeriesID, "work_id": b.WorkID} {
+		fields[name] = nil
+		if id != nil {
+			fields[name] = json.Number(strconv.FormatUint(uint64(*id), 10))
+		}
+	}
+	fields["series_volume"] = json.Number(strconv.Itoa(b.SeriesVolume))
+	return fields
+}
+
+// The fields that differ between two states of a book, by name
+func diffBooks(from, to *Book) []FieldChange {
+	a, b := revisionFields(from), revisionFields(to)
+	changes := []FieldChange{}
+	for field, value := range a {
+		if !reflect.DeepEqual(value, b[field]) {
+			changes = append(changes, FieldChange{Field: field, From: value, To: b[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// Set the fields a revision restores
+func (rev *BookRevision) applyTo(b *Book) {
+	newBookDocument(rev.Book).applyTo(b)
+	b.PublisherID, b.SeriesID, b.SeriesVolume, b.WorkID = rev.Book.PublisherID, rev.Book.SeriesID, rev.Book.SeriesVolume, rev.Book.WorkID
+}
+
+// A book's revisions, newest first, each with the changes made to it
+func bookRevisions(book *Book) ([]BookRevision, error) {
+	var revisions []BookRevision
+	if err := db.Where("book_id = ?", book.ID).Order("number DESC").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	after := book
+	for i := range revisions {
+		rev := &revisions[i]
+		rev.Book = &Book{}
+		if err := json.Unmarshal([]byte(rev.Data), rev.Book); err != nil {
+			return nil, err
+		}
+		rev.Changes = diffBooks(rev.Book, after)
+		after = rev.Book
+	}
+	return revisions, nil
+}
+
+// List a book's earlier versions, newest first, with what each write
+// changed
+func getBookRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := bookRevisions(book)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list revisions")
+		return
+	}
+	writeList(w, r, revisions)
+}
+
+// Put a book back as it was at a revision. The rollback is a write like
+// any other, so the version it replaces becomes a revision too and the
+// rollback can itself be undone.
+func rollbackBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_revision", "Invalid revision number")
+		return
+	}
+	var rev BookRevision
+	if err := db.Where("book_id = ? AND number = ?", book.ID, number).Take(&rev).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "revision_not_found", "Revision not found")
+		return
+	}
+	if !checkIfMatch(w, r, book) {
+		return
+	}
+	rev.Book = &Book{}
+	if err := json.Unmarshal([]byte(rev.Data), rev.Book); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read revision")
+		return
+	}
+
+	rev.applyTo(book)
+	if errs := validateBook(book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if err := db.WithContext(r.Context()).Save(book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to roll back book")
+		}
+		return
+	}
+	db.WithContext(r.Context()).Preload("Tags").First(book, book.ID)
+	setBookETag(w, book.ID)
+	writeJSON(w, http.StatusOK, book)
+}
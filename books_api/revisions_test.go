@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestBookRevisions(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","year":1965}`)
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	path := fmt.Sprintf("/api/v1/books/%d", book.ID)
+	webhookRequest(t, router, "PUT", path, `{"title":"Dune!","year":1966}`)
+	webhookRequest(t, router, "PUT", path, `{"description":"Spice"}`)
+
+	response = webhookRequest(t, router, "GET", path+"/revisions", "")
+	var revisions []BookRevision
+	json.Unmarshal(response.Body.Bytes(), &revisions)
+	if len(revisions) != 2 || revisions[0].Number <= revisions[1].Number {
+		t.Fatalf("Expected two revisions, newest first, got %s", response.Body.String())
+	}
+	latest, first := revisions[0], revisions[1]
+	if first.Book.Title != "Dune" || latest.Book.Title != "Dune!" || latest.Book.Description != "" {
+		t.Errorf("Expected the book as it was, got %+v and %+v", first.Book, latest.Book)
+	}
+	if fmt.Sprint(first.Changes) != "[{title Dune Dune!} {year 1965 1966}]" {
+		t.Errorf("Expected the first edit's changes, got %v", first.Changes)
+	}
+	if len(latest.Changes) != 1 || latest.Changes[0].Field != "description" {
+		t.Errorf("Expected the second edit's changes, got %v", latest.Changes)
+	}
+
+	// Rolling back is an edit of its own, so it can be undone too
+	response = webhookRequest(t, router, "POST", fmt.Sprintf("%s/revisions/%d/rollback", path, first.Number), "")
+	book = Book{}
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusOK || book.Title != "Dune" || book.Year != 1965 || book.Description != "" {
+		t.Fatalf("Expected the original book back, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", path+"/revisions", "")
+	revisions = nil
+	json.Unmarshal(response.Body.Bytes(), &revisions)
+	if len(revisions) != 3 || revisions[0].Book.Description != "Spice" {
+		t.Errorf("Expected the rolled back version kept, got %s", response.Body.String())
+	}
+
+	if response := webhookRequest(t, router, "POST", path+"/revisions/999/rollback", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown revision, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", path+"/revisions/x/rollback", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+
+	// A revision naming an ISBN another book took since can't come back
+	db.Create(&Book{Title: "Other", Author: "Someone", ISBN: "9780441172696"})
+	webhookRequest(t, router, "PUT", path, `{"isbn":"9780141439587"}`)
+	response = webhookRequest(t, router, "GET", path+"/revisions", "")
+	revisions = nil
+	json.Unmarshal(response.Body.Bytes(), &revisions)
+	db.Model(&Book{}).Where("title = ?", "Other").Update("isbn", "9780441013593")
+	if response := webhookRequest(t, router, "POST", fmt.Sprintf("%s/revisions/%d/rollback", path, revisions[0].Number), ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a taken ISBN, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SAML 2.0 namespaces and identifiers
+const (
+	samlNS          = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlpNS         = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlPOSTBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlRedirect    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlBearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlSuccess     = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+// SAMLConfig describes this service provider and the identity provider it
+// trusts
+type SAMLConfig struct {
+	// Public URL of the API, used in the ACS URL and default entity ID
+	BaseURL  string
+	EntityID string
+	// IdP metadata URL or file; alternatively its SSO URL and certificate
+	IdPMetadataURL string
+	IdPSSOURL      string
+	IdPCertFile    string
+	// Attribute holding the user's groups, and their role mappings as
+	// "role=group;role=group"
+	RoleAttribute string
+	RoleGroups    string
+	// Role for users in no mapped group; "none" refuses them
+	DefaultRole string
+	// Where the browser goes after signing in
+	RedirectURL string
+	ClockSkew   time.Duration
+}
+
+// samlServiceProvider handles SP-initiated SSO with one identity provider
+type samlServiceProvider struct {
+	cfg         SAMLConfig
+	idpEntityID string
+	idpSSOURL   string
+	idpCerts    []*x509.Certificate
+	mappings    []roleMapping
+	now         func() time.Time
+}
+
+// Active SAML service provider, set by initAuth when AUTH_BACKEND=saml
+var samlSP *samlServiceProvider
+
+// SAMLRequest is an outstanding AuthnRequest. Each response must answer one,
+// and using it up stops a captured response from being replayed.
+type SAMLRequest struct {
+	ID        string `gorm:"primaryKey"`
+	Redirect  string
+	ExpiresAt time.Time
+}
+
+// How long a user has to sign in at the IdP
+const samlRequestTTL = 10 * time.Minute
+
+func newSAMLServiceProvider(c SAMLConfig) (*samlServiceProvider, error) {
+	c.BaseURL = strings.TrimRight(c.BaseURL, "/")
+	if c.EntityID == "" {
+		c.EntityID = c.BaseURL + apiPrefix + "/auth/saml/metadata"
+	}
+	mappings, err := parseRoleMappings(c.RoleGroups)
+	if err != nil {
+		return nil, fmt.Errorf("SAML_ROLE_GROUPS: %w", err)
+	}
+	sp := &samlServiceProvider{cfg: c, idpSSOURL: c.IdPSSOURL, mappings: mappings, now: time.Now}
+
+	if c.IdPMetadataURL != "" {
+		data, err := readSAMLSource(c.IdPMetadataURL)
+		if err != nil {
+			return nil, fmt.Errorf("IdP metadata: %w", err)
+		}
+		if err := sp.loadIdPMetadata(data); err != nil {
+			return nil, fmt.Errorf("IdP metadata: %w", err)
+		}
+	}
+	if c.IdPCertFile != "" {
+		data, err := os.ReadFile(c.IdPCertFile)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := parsePEMCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.IdPCertFile, err)
+		}
+		sp.idpCerts = append(sp.idpCerts, certs...)
+	}
+	if sp.idpSSOURL == "" || len(sp.idpCerts) == 0 {
+		return nil, errors.New("set SAML_IDP_METADATA_URL, or SAML_IDP_SSO_URL and SAML_IDP_CERT_FILE")
+	}
+	return sp, nil
+}
+
+// Read metadata from a URL or a local file
+func readSAMLSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// Parts of IdP metadata the SP needs
+type samlIdPMetadata struct {
+	EntityID   string `xml:"entityID,attr"`
+	Descriptor struct {
+		Keys []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SSO []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+// Take the IdP's entity ID, redirect-binding SSO URL and signing
+// certificates from its metadata
+func (sp *samlServiceProvider) loadIdPMetadata(data []byte) error {
+	var md samlIdPMetadata
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return err
+	}
+	sp.idpEntityID = md.EntityID
+	for _, s := range md.Descriptor.SSO {
+		if s.Binding == samlRedirect && sp.idpSSOURL == "" {
+			sp.idpSSOURL = s.Location
+		}
+	}
+	for _, k := range md.Descriptor.Keys {
+		if k.Use == "encryption" {
+			continue
+		}
+		for _, c := range k.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c), ""))
+			if err != nil {
+				return fmt.Errorf("certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("certificate: %w", err)
+			}
+			sp.idpCerts = append(sp.idpCerts, cert)
+		}
+	}
+	if sp.idpSSOURL == "" {
+		return errors.New("no HTTP-Redirect SingleSignOnService")
+	}
+	return nil
+}
+
+func (sp *samlServiceProvider) acsURL() string {
+	return sp.cfg.BaseURL + apiPrefix + "/auth/saml/acs"
+}
+
+// SP metadata document for registering with the IdP
+type samlSPMetadata struct {
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID   string   `xml:"entityID,attr"`
+	Descriptor struct {
+		AuthnRequestsSigned bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertions      bool   `xml:"WantAssertionsSigned,attr"`
+		Protocols           string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat        string `xml:"NameIDFormat"`
+		ACS                 struct {
+			Binding   string `xml:"Binding,attr"`
+			Location  string `xml:"Location,attr"`
+			Index     int    `xml:"index,attr"`
+			IsDefault bool   `xml:"isDefault,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+func (sp *samlServiceProvider) metadata() []byte {
+	var md samlSPMetadata
+	md.EntityID = sp.cfg.EntityID
+	md.Descriptor.WantAssertions = true
+	md.Descriptor.Protocols = samlpNS
+	md.Descriptor.NameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	md.Descriptor.ACS.Binding = samlPOSTBinding
+	md.Descriptor.ACS.Location = sp.acsURL()
+	md.Descriptor.ACS.IsDefault = true
+	out, _ := xml.MarshalIndent(md, "", "  ")
+	return append([]byte(xml.Header), out...)
+}
+
+// URL sending the browser to the IdP with an AuthnRequest, using the
+// HTTP-Redirect binding
+func (sp *samlServiceProvider) authnRequestURL(id string) (string, error) {
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s"`,
+		samlpNS, samlNS, id, sp.now().UTC().Format(time.RFC3339))
+	req.WriteString(` Destination="`)
+	xml.EscapeText(&req, []byte(sp.idpSSOURL))
+	req.WriteString(`" AssertionConsumerServiceURL="`)
+	xml.EscapeText(&req, []byte(sp.acsURL()))
+	fmt.Fprintf(&req, `" ProtocolBinding="%s"><saml:Issuer>`, samlPOSTBinding)
+	xml.EscapeText(&req, []byte(sp.cfg.EntityID))
+	req.WriteString(`</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	fw, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	fw.Write(req.Bytes())
+	fw.Close()
+
+	u, err := url.Parse(sp.idpSSOURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	q.Set("RelayState", id)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Signed content of a Response, read back from its canonical form
+type samlResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	Destination  string   `xml:"Destination,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Status       struct {
+		Code struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status"`
+	Assertions []samlAssertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	Issuer  string   `xml:"Issuer"`
+	Subject struct {
+		NameID        string `xml:"NameID"`
+		Confirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				Recipient    string `xml:"Recipient,attr"`
+				InResponseTo string `xml:"InResponseTo,attr"`
+				NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore    string   `xml:"NotBefore,attr"`
+		NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+		Audiences    []string `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+// Values of the named attribute
+func (a *samlAssertion) attribute(name string) []string {
+	for _, attr := range a.Attributes {
+		if attr.Name == name {
+			return attr.Values
+		}
+	}
+	return nil
+}
+
+// Verify a base64 SAMLResponse and return its assertion and the request it
+// answers. Either the Response or its Assertion must carry a valid
+// signature from the IdP.
+func (sp *samlServiceProvider) parseResponse(encoded string) (*samlAssertion, string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, "", errors.New("SAMLResponse is not base64")
+	}
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if !root.is(samlpNS, "Response") {
+		return nil, "", errors.New("not a SAML Response")
+	}
+	if len(root.elements(samlNS, "EncryptedAssertion")) > 0 {
+		return nil, "", errors.New("encrypted assertions are not supported")
+	}
+
+	var resp samlResponse
+	var assertion samlAssertion
+	if root.element(dsigNS, "Signature") != nil {
+		canonical, err := verifyEnvelopedSignature(root, sp.idpCerts)
+		if err != nil {
+			return nil, "", fmt.Errorf("response signature: %w", err)
+		}
+		if err := xml.Unmarshal(canonical, &resp); err != nil {
+			return nil, "", err
+		}
+		if len(resp.Assertions) != 1 {
+			return nil, "", errors.New("expected exactly one assertion")
+		}
+		assertion = resp.Assertions[0]
+	} else {
+		el := root.element(samlNS, "Assertion")
+		if el == nil {
+			return nil, "", errors.New("expected exactly one assertion")
+		}
+		canonical, err := verifyEnvelopedSignature(el, sp.idpCerts)
+		if err != nil {
+			return nil, "", fmt.Errorf("assertion signature: %w", err)
+		}
+		if err := xml.Unmarshal(canonical, &assertion); err != nil {
+			return nil, "", err
+		}
+		// The envelope is unsigned; the checks below rely on the assertion
+		xml.Unmarshal(data, &resp)
+	}
+
+	if resp.Status.Code.Value != samlSuccess {
+		return nil, "", fmt.Errorf("IdP returned status %s", resp.Status.Code.Value)
+	}
+	if resp.Destination != "" && resp.Destination != sp.acsURL() {
+		return nil, "", fmt.Errorf("response is for %s", resp.Destination)
+	}
+	inResponseTo, err := sp.checkAssertion(&assertion)
+	if err != nil {
+		return nil, "", err
+	}
+	return &assertion, inResponseTo, nil
+}
+
+// Check the assertion's issuer, validity window, audience and bearer
+// confirmation, returning the request it answers
+func (sp *samlServiceProvider) checkAssertion(a *samlAssertion) (string, error) {
+	now := sp.now()
+	skew := sp.cfg.ClockSkew
+
+	if sp.idpEntityID != "" && a.Issuer != sp.idpEntityID {
+		return "", fmt.Errorf("unexpected issuer %s", a.Issuer)
+	}
+	if a.Conditions.NotBefore != "" {
+		if t, err := parseSAMLTime(a.Conditions.NotBefore); err != nil || now.Add(skew).Before(t) {
+			return "", errors.New("assertion is not yet valid")
+		}
+	}
+	if t, err := parseSAMLTime(a.Conditions.NotOnOrAfter); err != nil || !now.Add(-skew).Before(t) {
+		return "", errors.New("assertion has expired")
+	}
+	audience := false
+	for _, aud := range a.Conditions.Audiences {
+		audience = audience || aud == sp.cfg.EntityID
+	}
+	if !audience {
+		return "", errors.New("assertion is not addressed to this service provider")
+	}
+
+	for _, c := range a.Subject.Confirmations {
+		if c.Method != samlBearer || c.Data.Recipient != sp.acsURL() || c.Data.InResponseTo == "" {
+			continue
+		}
+		if t, err := parseSAMLTime(c.Data.NotOnOrAfter); err != nil || !now.Add(-skew).Before(t) {
+			continue
+		}
+		return c.Data.InResponseTo, nil
+	}
+	return "", errors.New("no valid bearer subject confirmation")
+}
+
+// Parse an xs:dateTime. A missing time is an error, so assertions must say
+// when they expire.
+func parseSAMLTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Principal for a verified assertion
+func (sp *samlServiceProvider) principal(a *samlAssertion) (*Principal, error) {
+	roles := mapRoles(a.attribute(sp.cfg.RoleAttribute), sp.mappings, sp.cfg.DefaultRole)
+	if len(roles) == 0 {
+		return nil, errNoRole
+	}
+	p := &Principal{Username: strings.TrimSpace(a.Subject.NameID), Roles: roles, Backend: "saml"}
+	for _, name := range []string{"displayName", "name", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"} {
+		if v := a.attribute(name); len(v) > 0 && p.Name == "" {
+			p.Name = v[0]
+		}
+	}
+	for _, name := range []string{"email", "mail", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"} {
+		if v := a.attribute(name); len(v) > 0 && p.Email == "" {
+			p.Email = v[0]
+		}
+	}
+	if p.Username == "" {
+		return nil, errors.New("assertion has no NameID")
+	}
+	return p, nil
+}
+
+// Redirect target after sign-in. Only URLs on the configured frontend's
+// origin are accepted, so the token can't be sent elsewhere.
+func samlRedirectTarget(base, requested string) string {
+	if requested == "" {
+		return base
+	}
+	b, err1 := url.Parse(base)
+	r, err2 := url.Parse(requested)
+	if err1 != nil || err2 != nil {
+		return base
+	}
+	r = b.ResolveReference(r)
+	if r.Scheme != b.Scheme || r.Host != b.Host {
+		return base
+	}
+	return r.String()
+}
+
+// SP metadata for registering the API with the IdP
+func getSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if samlSP == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "SAML is not enabled")
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(samlSP.metadata())
+}
+
+// Start SP-initiated sign-in: remember the request and send the browser
+// to the IdP
+func startSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if samlSP == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "SAML is not enabled")
+		return
+	}
+	req := SAMLRequest{
+		ID:        "_" + randomID() + randomID(),
+		Redirect:  samlRedirectTarget(samlSP.cfg.RedirectURL, r.URL.Query().Get("redirect")),
+		ExpiresAt: time.Now().Add(samlRequestTTL),
+	}
+	if err := db.Create(&req).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start sign-in")
+		return
+	}
+	db.Where("expires_at < ?", time.Now()).Delete(&SAMLRequest{})
+
+	target, err := samlSP.authnRequestURL(req.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start sign-in")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Assertion consumer service: verify the IdP's response, then send the
+// browser back to the frontend with a session token in the URL fragment
+func samlACS(w http.ResponseWriter, r *http.Request) {
+	if samlSP == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "SAML is not enabled")
+		return
+	}
+	assertion, requestID, err := samlSP.parseResponse(r.PostFormValue("SAMLResponse"))
+	if err != nil {
+		log.Printf("Rejected SAML response: %v", err)
+		writeError(w, r, http.StatusForbidden, "invalid_saml_response", "Invalid SAML response")
+		return
+	}
+
+	// Use up the request so the response can't be replayed
+	var req SAMLRequest
+	if db.Where("id = ? AND expires_at > ?", requestID, time.Now()).Limit(1).Find(&req).RowsAffected == 0 ||
+		db.Delete(&SAMLRequest{}, "id = ?", requestID).RowsAffected != 1 {
+		log.Printf("Rejected SAML response to unknown or used request %q", requestID)
+		writeError(w, r, http.StatusForbidden, "sign_in_expired", "Sign-in expired, please try again")
+		return
+	}
+
+	p, err := samlSP.principal(assertion)
+	if errors.Is(err, errNoRole) {
+		writeError(w, r, http.StatusForbidden, "no_role", "No role is assigned to this account")
+		return
+	}
+	if err != nil {
+		log.Printf("Rejected SAML response: %v", err)
+		writeError(w, r, http.StatusForbidden, "invalid_saml_response", "Invalid SAML response")
+		return
+	}
+	if err := runAuthHooks(r.Context(), p); err != nil {
+		writeAuthHookError(w, r, p.Username, err)
+		return
+	}
+
+	token, expires := issueToken(p, cfg.AuthTokenTTL)
+	fragment := url.Values{"token": {token}, "expires_at": {expires.UTC().Format(time.RFC3339)}}
+	target := req.Redirect
+	if i := strings.IndexByte(target, '#'); i >= 0 {
+		target = target[:i]
+	}
+	http.Redirect(w, r, target+"#"+fragment.Encode(), http.StatusSeeOther)
+}
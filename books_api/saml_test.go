@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Service provider trusting the IdP in testdata/saml, at a time inside the
+// validity window of testdata/saml/response.xml
+func newTestSAMLSP(t *testing.T) *samlServiceProvider {
+	sp, err := newSAMLServiceProvider(SAMLConfig{
+		BaseURL:        "http://localhost:8080",
+		IdPMetadataURL: "testdata/saml/idp-metadata.xml",
+		RoleAttribute:  "groups",
+		RoleGroups:     "librarian=Library Staff",
+		DefaultRole:    "none",
+		RedirectURL:    "http://localhost:3000/books_demo.html",
+		ClockSkew:      time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.now = func() time.Time { return time.Date(2026, 1, 1, 0, 1, 0, 0, time.UTC) }
+	return sp
+}
+
+func withSAML(t *testing.T, sp *samlServiceProvider) {
+	old, oldKey := samlSP, authKey
+	samlSP, authKey = sp, []byte("test-secret")
+	t.Cleanup(func() { samlSP, authKey = old, oldKey })
+}
+
+func testSAMLResponse(t *testing.T) string {
+	data, err := os.ReadFile("testdata/saml/response.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func postSAMLResponse(router http.Handler, response string) *httptest.ResponseRecorder {
+	form := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString([]byte(response))}}
+	req, _ := http.NewRequest("POST", "/api/v1/auth/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSAMLACS(t *testing.T) {
+	clearDB()
+	withSAML(t, newTestSAMLSP(t))
+	router := setupRouter()
+	db.Create(&SAMLRequest{ID: "_req1", Redirect: "http://localhost:3000/books_demo.html", ExpiresAt: time.Now().Add(time.Minute)})
+
+	response := postSAMLResponse(router, testSAMLResponse(t))
+	if response.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", response.Code, response.Body.String())
+	}
+	location, _ := url.Parse(response.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if location.Host != "localhost:3000" || fragment.Get("token") == "" {
+		t.Fatalf("Expected a redirect to the frontend with a token, got %s", location)
+	}
+	p, err := parseToken(fragment.Get("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Principal{Username: "ann@example.edu", Name: "Ann Archivist & Co", Email: "ann@example.edu", Roles: []string{roleLibrarian}, Backend: "saml"}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Expected %+v, got %+v", want, p)
+	}
+
+	if response := postSAMLResponse(router, testSAMLResponse(t)); response.Code != http.StatusForbidden {
+		t.Errorf("Expected a replayed response to be refused, got %d", response.Code)
+	}
+}
+
+func TestSAMLResponseRejected(t *testing.T) {
+	original := testSAMLResponse(t)
+	otherCert := func(sp *samlServiceProvider) {
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Other IdP"}, NotAfter: time.Now().Add(time.Hour)}
+		der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		cert, _ := x509.ParseCertificate(der)
+		sp.idpCerts = []*x509.Certificate{cert}
+	}
+
+	tests := []struct {
+		name     string
+		response string
+		setup    func(sp *samlServiceProvider)
+		want     string
+	}{
+		{"tampered attribute", strings.Replace(original, "Library Staff", "Library Admins", 1), nil, "digest mismatch"},
+		{"untrusted signer", original, otherCert, "signature verification failed"},
+		{"expired", original, func(sp *samlServiceProvider) {
+			sp.now = func() time.Time { return time.Date(2026, 1, 1, 0, 10, 0, 0, time.UTC) }
+		}, "expired"},
+		{"wrong audience", original, func(sp *samlServiceProvider) { sp.cfg.EntityID = "https://other.example.edu" }, "not addressed"},
+		{"wrong issuer", original, func(sp *samlServiceProvider) { sp.idpEntityID = "https://evil.example.com" }, "unexpected issuer"},
+		{"wrapped assertion", strings.Replace(original, "<samlp:Status>",
+			`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_evil"><saml:Subject><saml:NameID>admin</saml:NameID></saml:Subject></saml:Assertion><samlp:Status>`, 1),
+			nil, "exactly one assertion"},
+		{"SHA-1 signature", strings.Replace(original, dsigRSASHA256, dsigRSASHA1, 1), nil, "SHA-1 signatures"},
+		{"SHA-1 digest", strings.Replace(original, dsigDigestSHA256, dsigDigestSHA1, 1), nil, "SHA-1 digests"},
+		{"doctype", strings.Replace(original, "<samlp:Response", "<!DOCTYPE r [<!ENTITY x \"y\">]><samlp:Response", 1), nil, "DTD"},
+	}
+	for _, tt := range tests {
+		sp := newTestSAMLSP(t)
+		if tt.setup != nil {
+			tt.setup(sp)
+		}
+		_, _, err := sp.parseResponse(base64.StdEncoding.EncodeToString([]byte(tt.response)))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestSAMLLogin(t *testing.T) {
+	clearDB()
+	withSAML(t, newTestSAMLSP(t))
+	router := setupRouter()
+
+	for _, tt := range []struct{ redirect, want string }{
+		{"/books_demo.html?view=grid", "http://localhost:3000/books_demo.html?view=grid"},
+		{"https://evil.example.com/", "http://localhost:3000/books_demo.html"},
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/auth/saml/login?redirect="+url.QueryEscape(tt.redirect), nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusFound {
+			t.Fatalf("Expected status 302, got %d", response.Code)
+		}
+		location, _ := url.Parse(response.Header().Get("Location"))
+		if location.Host != "idp.example.edu" || location.Path != "/sso/redirect" {
+			t.Errorf("Expected a redirect to the IdP, got %s", location)
+		}
+
+		id := location.Query().Get("RelayState")
+		var stored SAMLRequest
+		db.First(&stored, "id = ?", id)
+		if stored.Redirect != tt.want {
+			t.Errorf("Redirect %q: expected to return to %q, got %q", tt.redirect, tt.want, stored.Redirect)
+		}
+
+		deflated, _ := base64.StdEncoding.DecodeString(location.Query().Get("SAMLRequest"))
+		authn, _ := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+		for _, part := range []string{`ID="` + id + `"`, `AssertionConsumerServiceURL="http://localhost:8080/api/v1/auth/saml/acs"`} {
+			if !strings.Contains(string(authn), part) {
+				t.Errorf("Expected %s in the AuthnRequest, got %s", part, authn)
+			}
+		}
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	withSAML(t, newTestSAMLSP(t))
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/auth/saml/metadata", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+	for _, part := range []string{
+		`entityID="http://localhost:8080/api/v1/auth/saml/metadata"`,
+		`Location="http://localhost:8080/api/v1/auth/saml/acs"`,
+	} {
+		if !strings.Contains(response.Body.String(), part) {
+			t.Errorf("Expected %s in the metadata, got %s", part, response.Body.String())
+		}
+	}
+}
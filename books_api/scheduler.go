@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+)
+
+// Scheduled run states
+const (
+	runRunning   = "running"
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+)
+
+// What started a run
+const (
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+)
+
+// ScheduledJob is a named task that can run on a schedule or on demand
+type ScheduledJob struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context) error
+}
+
+// Jobs that SCHEDULED_JOBS and the trigger endpoint can name
+var scheduledJobs = []ScheduledJob{
+	{Name: "backup", Description: "Snapshot the database and rotate old backups", Run: backupDatabase},
+	{Name: "loan-reminders", Description: "Email members whose loans fall due soon", Run: sendLoanReminders},
+	{Name: "recommendations", Description: "Recompute \"also read\" scores", Run: computeRecommendations},
+	{Name: "search-reindex", Description: "Rebuild the search index", Run: rebuildSearchIndex},
+	{Name: "trash-purge", Description: "Permanently remove books deleted longer ago than the retention", Run: purgeTrash},
+}
+
+func findScheduledJob(name string) (ScheduledJob, bool) {
+	for _, j := range scheduledJobs {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return ScheduledJob{}, false
+}
+
+// ScheduledRun records one execution of a scheduled job
+type ScheduledRun struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Job        string     `json:"job" gorm:"index;not null"`
+	Trigger    string     `json:"trigger"`
+	Instance   string     `json:"instance"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// JobLock keeps a job from running on two instances at once. A lock whose
+// holder died is taken over once it expires.
+type JobLock struct {
+	Name      string `gorm:"primaryKey"`
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// Returned when another run of the job holds its lock
+var errJobLocked = errors.New("job is already running")
+
+// Identifies this process in locks and run history
+var instanceID = randomID()
+
+// Runs started by this process, so tests and shutdown can wait for them
+var scheduledRuns sync.WaitGroup
+
+// Take the job's lock unless an unexpired one is held
+func acquireJobLock(name, owner string, ttl time.Duration) bool {
+	now := time.Now().UTC()
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"owner": owner, "expires_at": now.Add(ttl)}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Lt{Column: "job_locks.expires_at", Value: now}}},
+	}).Create(&JobLock{Name: name, Owner: owner, ExpiresAt: now.Add(ttl)})
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+func releaseJobLock(name, owner string) {
+	db.Where("name = ? AND owner = ?", name, owner).Delete(&JobLock{})
+}
+
+// Run a job in the background under its lock, recording the run
+func startScheduledRun(job ScheduledJob, trigger string) (*ScheduledRun, error) {
+	if !acquireJobLock(job.Name, instanceID, cfg.ScheduleLockTTL) {
+		return nil, errJobLocked
+	}
+	run := &ScheduledRun{Job: job.Name, Trigger: trigger, Instance: instanceID, Status: runRunning, StartedAt: time.Now()}
+	if err := db.Create(run).Error; err != nil {
+		releaseJobLock(job.Name, instanceID)
+		return nil, err
+	}
+
+	result := *run
+	scheduledRuns.Add(1)
+	go func() {
+		defer scheduledRuns.Done()
+		defer releaseJobLock(job.Name, instanceID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ScheduleLockTTL)
+		defer cancel()
+		err := func() (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("panic: %v", p)
+				}
+			}()
+			return job.Run(ctx)
+		}()
+
+		finished := time.Now()
+		result.FinishedAt = &finished
+		result.Status = runSucceeded
+		if err != nil {
+			result.Status = runFailed
+			result.Error = err.Error()
+			log.Printf("Scheduled job %s failed: %v", job.Name, err)
+			notifyJobFailure(job.Name, err)
+		}
+		db.Save(&result)
+	}()
+	return run, nil
+}
+
+type scheduleEntry struct {
+	job      ScheduledJob
+	spec     string
+	schedule Schedule
+	next     time.Time
+}
+
+// Active schedule, parsed from SCHEDULED_JOBS by initScheduler
+var schedule []*scheduleEntry
+
+// Parse "name=spec;name=spec" job schedules
+func parseScheduledJobs(value string, now time.Time) ([]*scheduleEntry, error) {
+	var entries []*scheduleEntry
+	for _, item := range strings.Split(value, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected name=schedule, got %q", item)
+		}
+		name = strings.TrimSpace(name)
+		job, ok := findScheduledJob(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown job %q", name)
+		}
+		s, err := parseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		entries = append(entries, &scheduleEntry{job: job, spec: strings.TrimSpace(spec), schedule: s, next: s.Next(now)})
+	}
+	return entries, nil
+}
+
+// SCHEDULED_JOBS, with recommendations every RECOMMENDATIONS_INTERVAL
+// unless it schedules them itself
+func withDefaultSchedules(value string) string {
+	for _, item := range strings.Split(value, ";") {
+		if name, _, _ := strings.Cut(item, "="); strings.TrimSpace(name) == "recommendations" {
+			return value
+		}
+	}
+	return value + ";recommendations=@every " + cfg.RecommendationsInterval.String()
+}
+
+func initScheduler() {
+	entries, err := parseScheduledJobs(withDefaultSchedules(cfg.ScheduledJobs), time.Now())
+	if err != nil {
+		log.Fatalf("Invalid SCHEDULED_JOBS: %v", err)
+	}
+	schedule = entries
+}
+
+// Start due jobs until ctx is cancelled. Every instance runs the schedule;
+// the job lock lets only one of them do the work. LiteFS replicas can't
+// write, so they leave scheduled jobs to the primary.
+func runScheduler(ctx context.Context) {
+	for {
+		var wake time.Time
+		for _, e := range schedule {
+			if !e.next.IsZero() && (wake.IsZero() || e.next.Before(wake)) {
+				wake = e.next
+			}
+		}
+		if wake.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		replica, _ := isReadOnlyReplica()
+		for _, e := range schedule {
+			if e.next.IsZero() || e.next.After(now) {
+				continue
+			}
+			if replica {
+				e.next = e.schedule.Next(now)
+				continue
+			}
+			if _, err := startScheduledRun(e.job, triggerSchedule); err != nil && !errors.Is(err, errJobLocked) {
+				log.Printf("Starting scheduled job %s failed: %v", e.job.Name, err)
+			}
+			e.next = e.schedule.Next(now)
+		}
+	}
+}
+
+// Write a consistent copy of the database to BACKUP_DIR, keeping the
+// newest BACKUP_KEEP files
+func backupDatabase(ctx context.Context) error {
+	if err := os.MkdirAll(cfg.BackupDir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(cfg.BackupDir, "books-"+time.Now().UTC().Format("20060102T150405Z")+".db")
+	if err := db.WithContext(ctx).Exec("VACUUM INTO ?", name).Error; err != nil {
+		return err
+	}
+	log.Printf("Database backed up to %s", name)
+	return rotateBackups(cfg.BackupDir, cfg.BackupKeep)
+}
+
+// Delete all but the newest keep backups
+func rotateBackups(dir string, keep int) error {
+	files, err := filepath.Glob(filepath.Join(dir, "books-*.db"))
+	if err != nil || keep < 1 || len(files) <= keep {
+		return err
+	}
+	// Timestamped names sort oldest first
+	sort.Strings(files)
+	for _, f := range files[:len(files)-keep] {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rebuildSearchIndex(ctx context.Context) error {
+	if searchIndex == nil {
+		return errors.New("search index is not enabled")
+	}
+	if !searchIndex.reindexing.TryLock() {
+		return errors.New("a reindex is already running")
+	}
+	defer searchIndex.reindexing.Unlock()
+	n, err := searchIndex.Backfill(ctx)
+	if err != nil {
+		return fmt.Errorf("reindex failed after %d books: %w", n, err)
+	}
+	return nil
+}
+
+// ScheduledJobInfo is a job as listed by the admin API
+type ScheduledJobInfo struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Schedule    string        `json:"schedule"`
+	NextRun     *time.Time    `json:"next_run"`
+	LastRun     *ScheduledRun `json:"last_run"`
+}
+
+// List every job with its schedule and latest run
+func getScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list := make([]ScheduledJobInfo, 0, len(scheduledJobs))
+	for _, job := range scheduledJobs {
+		info := ScheduledJobInfo{Name: job.Name, Description: job.Description}
+		for _, e := range schedule {
+			if e.job.Name == job.Name {
+				info.Schedule = e.spec
+				if !e.next.IsZero() {
+					next := e.next
+					info.NextRun = &next
+				}
+			}
+		}
+		var last ScheduledRun
+		if db.Where("job = ?", job.Name).Order("id DESC").Limit(1).Find(&last).RowsAffected > 0 {
+			info.LastRun = &last
+		}
+		list = append(list, info)
+	}
+	writeList(w, r, list)
+}
+
+// Run history for one job, newest first
+func getScheduledRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := findScheduledJob(mux.Vars(r)["name"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	var runs []ScheduledRun
+	db.Where("job = ?", job.Name).Order("id DESC").Limit(50).Find(&runs)
+	writeList(w, r, runs)
+}
+
+// Run a job now, outside its schedule
+func triggerScheduledJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := findScheduledJob(mux.Vars(r)["name"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	if replica, primary := isReadOnlyReplica(); replica {
+		writeError(w, r, http.StatusConflict, "not_primary", "Scheduled jobs run on the primary ("+primary+")")
+		return
+	}
+	run, err := startScheduledRun(job, triggerManual)
+	if errors.Is(err, errJobLocked) {
+		writeError(w, r, http.StatusConflict, "job_conflict", "Job is already running")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start job")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseScheduledJobs(t *testing.T) {
+	now := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	entries, err := parseScheduledJobs("backup=0 3 * * *; recommendations=@every 6h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].job.Name != "backup" || !entries[1].next.Equal(now.Add(6*time.Hour)) {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	if _, err := parseScheduledJobs("purge=@daily", now); err == nil {
+		t.Error("Expected an unknown job to be rejected")
+	}
+}
+
+func TestDefaultRecommendationsSchedule(t *testing.T) {
+	saved := cfg.RecommendationsInterval
+	cfg.RecommendationsInterval = 2 * time.Hour
+	defer func() { cfg.RecommendationsInterval = saved }()
+
+	entries, err := parseScheduledJobs(withDefaultSchedules("backup=@daily"), time.Now())
+	if err != nil || len(entries) != 2 || entries[1].job.Name != "recommendations" || entries[1].schedule != intervalSchedule(2*time.Hour) {
+		t.Errorf("Expected recommendations every RECOMMENDATIONS_INTERVAL, got %+v (%v)", entries, err)
+	}
+	// SCHEDULED_JOBS takes precedence
+	if value := withDefaultSchedules("recommendations=0 4 * * *"); value != "recommendations=0 4 * * *" {
+		t.Errorf("Expected the configured schedule kept, got %q", value)
+	}
+}
+
+func TestJobLock(t *testing.T) {
+	clearDB()
+
+	if !acquireJobLock("backup", "a", time.Hour) {
+		t.Fatal("Expected the first instance to take the lock")
+	}
+	if acquireJobLock("backup", "b", time.Hour) {
+		t.Error("Expected a held lock to be refused")
+	}
+	releaseJobLock("backup", "a")
+	if !acquireJobLock("backup", "b", -time.Minute) {
+		t.Fatal("Expected a released lock to be free")
+	}
+	// b's lock has already expired, as if b had died
+	if !acquireJobLock("backup", "a", time.Hour) {
+		t.Error("Expected an expired lock to be taken over")
+	}
+}
+
+func TestTriggerScheduledJob(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	ran := make(chan struct{})
+	release := make(chan struct{})
+	saved := scheduledJobs
+	scheduledJobs = []ScheduledJob{
+		{Name: "slow", Run: func(ctx context.Context) error {
+			close(ran)
+			<-release
+			return errors.New("boom")
+		}},
+	}
+	defer func() { scheduledJobs = saved }()
+
+	req, _ := http.NewRequest("POST", "/api/v1/admin/scheduled-jobs/slow/run", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", response.Code, response.Body.String())
+	}
+	<-ran
+
+	// The lock keeps a second run from starting
+	req, _ = http.NewRequest("POST", "/api/v1/admin/scheduled-jobs/slow/run", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while running, got %d", response.Code)
+	}
+
+	close(release)
+	scheduledRuns.Wait()
+
+	req, _ = http.NewRequest("GET", "/api/v1/admin/scheduled-jobs/slow/runs", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	var runs []ScheduledRun
+	json.Unmarshal(response.Body.Bytes(), &runs)
+	if len(runs) != 1 || runs[0].Status != runFailed || runs[0].Error != "boom" || runs[0].Trigger != triggerManual {
+		t.Errorf("Unexpected run history %+v", runs)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/admin/scheduled-jobs/missing/run", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown job, got %d", response.Code)
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	clearDB()
+	dir := t.TempDir()
+	for _, name := range []string{"books-20260101T000000Z.db", "books-20260102T000000Z.db", "books-20260103T000000Z.db"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644)
+	}
+	savedDir, savedKeep := cfg.BackupDir, cfg.BackupKeep
+	cfg.BackupDir, cfg.BackupKeep = dir, 2
+	defer func() { cfg.BackupDir, cfg.BackupKeep = savedDir, savedKeep }()
+
+	if err := backupDatabase(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "books-*.db"))
+	if len(files) != 2 || filepath.Base(files[0]) != "books-20260103T000000Z.db" {
+		t.Errorf("Expected the new backup and the newest old one, got %v", files)
+	}
+}
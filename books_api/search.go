@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Default and largest page size for search results
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Checkpoint records how far a background consumer has got, so it can
+// resume after a restart
+type Checkpoint struct {
+	Name      string `gorm:"primaryKey"`
+	Position  uint
+	UpdatedAt time.Time
+}
+
+func loadCheckpoint(name string) uint {
+	var c Checkpoint
+	db.Where("name = ?", name).Limit(1).Find(&c)
+	return c.Position
+}
+
+func saveCheckpoint(tx *gorm.DB, name string, position uint) error {
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Checkpoint{Name: name, Position: position}).Error
+}
+
+// Search books by title, author, ISBN or description
+func searchBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query parameter q is required")
+		return
+	}
+	limit, offset := defaultSearchLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "offset must be a non-negative integer")
+			return
+		}
+		offset = n
+	}
+
+	var books []Book
+	var err error
+	if searchIndex != nil {
+		books, err = indexSearchBooks(r.Context(), q, limit, offset)
+	} else {
+		books, err = sqlSearchBooks(r.Context(), q, limit, offset)
+	}
+	if err != nil {
+		log.Printf("Search for %q failed: %v", q, err)
+		writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Search failed")
+		return
+	}
+	if books, err = runSearchHooks(r, q, books); err != nil {
+		if !writeHookError(w, r, err) {
+			log.Printf("Search hook for %q failed: %v", q, err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Search failed")
+		}
+		return
+	}
+
+	// A full page may have more after it
+	links := []pageLink{{"first", offsetURL(r, 0)}}
+	if offset > 0 {
+		links = append(links, pageLink{"prev", offsetURL(r, max(offset-limit, 0))})
+	}
+	if len(books) == limit {
+		links = append(links, pageLink{"next", offsetURL(r, offset+limit)})
+	}
+	setLinkHeader(w, links...)
+	writeJSON(w, http.StatusOK, enveloped(r, listOf(books), ListMeta{Page: offset/limit + 1}))
+}
+
+// The request's URL at another offset
+func offsetURL(r *http.Request, offset int) string {
+	q := r.URL.Query()
+	q.Del("offset")
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.RequestURI()
+}
+
+// Match every word of q against the book's text columns, through the
+// full-text index when there is one
+func sqlSearchBooks(ctx context.Context, q string, limit, offset int) ([]Book, error) {
+	if ftsEnabled {
+		return ftsSearchBooks(ctx, q, limit, offset)
+	}
+	query := db.WithContext(ctx).Model(&Book{})
+	for _, word := range strings.Fields(strings.ToLower(q)) {
+		like := "%" + word + "%"
+		query = query.Where("LOWER(title) LIKE ? OR LOWER(author) LIKE ? OR isbn LIKE ? OR LOWER(description) LIKE ?", like, like, like, like)
+	}
+	var books []Book
+	err := query.Order("title").Limit(limit).Offset(offset).Find(&books).Error
+	return books, err
+}
+
+// Search the index, then load the matching books in ranked order
+func indexSearchBooks(ctx context.Context, q string, limit, offset int) ([]Book, error) {
+	ids, err := searchIndex.Search(ctx, q, limit, offset)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var found []Book
+	if err := db.WithContext(ctx).Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Book, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+
+	// Skip hits the index hasn't caught up on deleting yet
+	books := make([]Book, 0, len(ids))
+	for _, id := range ids {
+		if b, ok := byID[id]; ok {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+// Rebuild the search index from the database in the background
+func reindexSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if searchIndex == nil {
+		writeError(w, r, http.StatusNotImplemented, "not_enabled", "Search index is not enabled")
+		return
+	}
+	if !searchIndex.reindexing.TryLock() {
+		writeError(w, r, http.StatusConflict, "job_conflict", "A reindex is already running")
+		return
+	}
+
+	go func() {
+		defer searchIndex.reindexing.Unlock()
+		n, err := searchIndex.Backfill(context.Background())
+		if err != nil {
+			log.Printf("Search reindex failed after %d books: %v", n, err)
+			return
+		}
+		log.Printf("Search reindex finished, %d books indexed", n)
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "reindexing"})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Minimal Elasticsearch stand-in that keeps documents in memory
+type fakeES struct {
+	mu      sync.Mutex
+	exists  bool
+	docs    map[string]esBook
+	results []string
+}
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == "HEAD" && r.URL.Path == "/books":
+		if !f.exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "PUT" && r.URL.Path == "/books":
+		f.exists = true
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.Method == "POST" && r.URL.Path == "/_bulk":
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				ID string `json:"_id"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			if meta, ok := action["delete"]; ok {
+				delete(f.docs, meta.ID)
+				continue
+			}
+			scanner.Scan()
+			var doc esBook
+			json.Unmarshal(scanner.Bytes(), &doc)
+			f.docs[action["index"].ID] = doc
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	case r.Method == "POST" && r.URL.Path == "/books/_search":
+		var hits []string
+		for _, id := range f.results {
+			hits = append(hits, fmt.Sprintf(`{"_id":%q}`, id))
+		}
+		fmt.Fprintf(w, `{"hits":{"hits":[%s]}}`, strings.Join(hits, ","))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Point the search index at a fake Elasticsearch for one test
+func useFakeES(t *testing.T) *fakeES {
+	es := &fakeES{docs: make(map[string]esBook)}
+	server := httptest.NewServer(es)
+	saved := searchIndex
+	searchIndex = newESIndex(server.URL, "books", "")
+	t.Cleanup(func() {
+		searchIndex = saved
+		server.Close()
+	})
+	return es
+}
+
+func searchTitles(t *testing.T, router http.Handler, query string) []string {
+	req, _ := http.NewRequest("GET", "/api/v1/books/search?"+query, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	titles := []string{}
+	for _, b := range books {
+		titles = append(titles, b.Title)
+	}
+	return titles
+}
+
+func TestSQLSearch(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696"})
+	db.Create(&Book{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595"})
+
+	if titles := searchTitles(t, router, "q=dune"); strings.Join(titles, ",") != "Dune,Dune Messiah" {
+		t.Errorf("Unexpected results %v", titles)
+	}
+	if titles := searchTitles(t, router, "q=herbert+messiah"); strings.Join(titles, ",") != "Dune Messiah" {
+		t.Errorf("Expected every word to match, got %v", titles)
+	}
+	if titles := searchTitles(t, router, "q=dune&limit=1&offset=1"); strings.Join(titles, ",") != "Dune Messiah" {
+		t.Errorf("Unexpected page %v", titles)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/search", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without q, got %d", response.Code)
+	}
+}
+
+func TestSearchIndexFollowsEvents(t *testing.T) {
+	clearDB()
+	es := useFakeES(t)
+	ctx := context.Background()
+	if created, err := searchIndex.EnsureIndex(ctx); !created || err != nil {
+		t.Fatalf("Expected the index to be created, got %v %v", created, err)
+	}
+
+	// Books written before the backfill are indexed by it
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	if n, err := searchIndex.Backfill(ctx); n != 1 || err != nil {
+		t.Fatalf("Expected 1 book backfilled, got %d %v", n, err)
+	}
+
+	// Later writes arrive through the outbox
+	router := setupRouter()
+	body := []byte(`{"title":"Neuromancer","author":"William Gibson","isbn":"9780441569595"}`)
+	req, _ := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("DELETE", "/api/v1/books/1", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, err := searchIndex.Sync(ctx, 500); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := es.docs["1"]; ok {
+		t.Error("Expected the deleted book to be removed from the index")
+	}
+	if es.docs["2"].Author != "William Gibson" {
+		t.Errorf("Expected the new book to be indexed, got %+v", es.docs)
+	}
+	if n, _ := searchIndex.Sync(ctx, 500); n != 0 {
+		t.Errorf("Expected the checkpoint to skip consumed events, got %d", n)
+	}
+
+	// Searches are answered from the index, in its ranking order, skipping
+	// hits that no longer exist
+	db.Create(&Book{Title: "Count Zero", Author: "William Gibson", ISBN: "9780441117734"})
+	es.results = []string{"3", "1", "2"}
+	if titles := searchTitles(t, router, "q=gibson"); strings.Join(titles, ",") != "Count Zero,Neuromancer" {
+		t.Errorf("Unexpected results %v", titles)
+	}
+}
+
+func TestReindexRequiresSearchIndex(t *testing.T) {
+	router := setupRouter()
+	req, _ := http.NewRequest("POST", "/api/v1/admin/search/reindex", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501, got %d", response.Code)
+	}
+}
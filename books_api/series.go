@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Series group books that are read in order, such as a trilogy. A book
+// joins one with series_id and its place in it with series_volume, and
+// every book loaded from the database carries the series' name, so
+// clients needn't look it up.
+
+// Series is a run of books with a shared name
+type Series struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"uniqueIndex;not null"`
+	Description string    `json:"description,omitempty"`
+	BookCount   int64     `json:"book_count" gorm:"->;-:migration"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BookSeries is the series a book belongs to, as embedded in the book
+type BookSeries struct {
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Volume int    `json:"volume,omitempty"`
+}
+
+// SeriesRequest is the body of POST and PUT /series
+type SeriesRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Series with the number of books outside the trash in them
+func seriesWithCounts(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Model(&Series{}).
+		Select("series.*, COUNT(books.id) AS book_count").
+		Scopes(joinBooks("books.series_id = series.id AND books.deleted_at IS NULL")).
+		Group("series.id")
+}
+
+// Fill in the series of books a query loaded, in one query for all of
+// them. Queries that didn't read series_id, such as ?fields= listings
+// without it, are left alone.
+func registerSeriesCallbacks(db *gorm.DB) {
+	attach := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "books" {
+			return
+		}
+		var books []*Book
+		switch dest := tx.Statement.Dest.(type) {
+		case *Book:
+			books = append(books, dest)
+		case *[]Book:
+			for i := range *dest {
+				books = append(books, &(*dest)[i])
+			}
+		}
+		var ids []uint
+		for _, b := range books {
+			if b.SeriesID != nil {
+				ids = append(ids, *b.SeriesID)
+			}
+		}
+		if len(ids) == 0 {
+			return
+		}
+		var series []Series
+		if err := tx.Session(&gorm.Session{NewDB: true}).Select("id", "name").Where("id IN ?", ids).Find(&series).Error; err != nil {
+			tx.AddError(err)
+			return
+		}
+		names := make(map[uint]string, len(series))
+		for _, s := range series {
+			names[s.ID] = s.Name
+		}
+		for _, b := range books {
+			if b.SeriesID != nil {
+				b.Series = &BookSeries{ID: *b.SeriesID, Name: names[*b.SeriesID], Volume: b.SeriesVolume}
+			}
+		}
+	}
+	db.Callback().Query().After("gorm:after_query").Register("series:attach", attach)
+}
+
+// Refuse a book write naming a series that doesn't exist, or a volume
+// without a series. Runs in the book's write transaction.
+func checkBookSeries(tx *gorm.DB, b *Book) error {
+	if b.SeriesVolume < 0 {
+		return RejectField("series_volume", "must be positive")
+	}
+	if b.SeriesID == nil {
+		if b.SeriesVolume != 0 {
+			return RejectField("series_volume", "needs a series_id")
+		}
+		return nil
+	}
+	var count int64
+	err := tx.Session(&gorm.Session{NewDB: true}).Model(&Series{}).Where("id = ?", *b.SeriesID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return RejectField("series_id", "is not a known series")
+	}
+	return nil
+}
+
+// List the series alphabetically with their book counts
+func getSeriesList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var series []Series
+	if err := seriesWithCounts(r.Context()).Order("series.name").Scan(&series).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list series")
+		return
+	}
+	writeList(w, r, series)
+}
+
+func validateSeries(req *SeriesRequest) []FieldError {
+	var errs []FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	return errs
+}
+
+// Whether another series already has the name
+func seriesNameTaken(name string, id uint) bool {
+	var count int64
+	db.Model(&Series{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+	return count > 0
+}
+
+// Add a series
+func createSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req SeriesRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateSeries(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if seriesNameTaken(req.Name, 0) {
+		writeError(w, r, http.StatusConflict, "series_exists", "A series with this name already exists")
+		return
+	}
+	series := Series{Name: req.Name, Description: req.Description}
+	if err := db.Create(&series).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create series")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(series.ID), 10))
+	writeJSON(w, http.StatusCreated, series)
+}
+
+// Load the series named in the URL, with its book count
+func loadSeries(w http.ResponseWriter, r *http.Request) (*Series, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_series_id", "Invalid series ID")
+		return nil, false
+	}
+	var series Series
+	if err := seriesWithCounts(r.Context()).Where("series.id = ?", id).Take(&series).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "series_not_found", "Series not found")
+		return nil, false
+	}
+	return &series, true
+}
+
+// Get a series by ID
+func getSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if series, ok := loadSeries(w, r); ok {
+		writeJSON(w, http.StatusOK, series)
+	}
+}
+
+// List a series' books by volume. Books without a volume number come last.
+func getSeriesBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	series, ok := loadSeries(w, r)
+	if !ok {
+		return
+	}
+	var books []Book
+	db.WithContext(r.Context()).Preload("Tags").Where("series_id = ?", series.ID).Order("series_volume = 0, series_volume, title, id").Find(&books)
+	writeList(w, r, books)
+}
+
+// Replace a series' name and description
+func updateSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	series, ok := loadSeries(w, r)
+	if !ok {
+		return
+	}
+	var req SeriesRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	if errs := validateSeries(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	if seriesNameTaken(req.Name, series.ID) {
+		writeError(w, r, http.StatusConflict, "series_exists", "A series with this name already exists")
+		return
+	}
+	series.Name, series.Description = req.Name, req.Description
+	if err := db.Select("Name", "Description", "UpdatedAt").Updates(series).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update series")
+		return
+	}
+	// Cached books carry the old name
+	invalidateCache()
+	writeJSON(w, http.StatusOK, series)
+}
+
+// Returned when a series still has books
+var errSeriesHasBooks = errors.New("series has books")
+
+// Delete a series with no books, including books in the trash
+func deleteSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	series, ok := loadSeries(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var books int64
+		if err := tx.Unscoped().Model(&Book{}).Where("series_id = ?", series.ID).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return errSeriesHasBooks
+		}
+		return tx.Delete(&Series{}, series.ID).Error
+	})
+	if errors.Is(err, errSeriesHasBooks) {
+		writeError(w, r, http.StatusConflict, "series_has_books", "Move or delete the series' books first")
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete series")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSeriesCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/series", `{"name":" Dune Chronicles ","description":"Arrakis and after"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created Series
+	json.Unmarshal(response.Body.Bytes(), &created)
+	path := fmt.Sprintf("/api/v1/series/%d", created.ID)
+	if loc := response.Header().Get("Location"); loc != path || created.Name != "Dune Chronicles" {
+		t.Errorf("Expected the series at %s, got %s: %s", path, loc, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/series", `{"name":"Dune Chronicles"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate name, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "POST", "/api/v1/series", `{"name":" "}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a name, got %d", response.Code)
+	}
+
+	response = webhookRequest(t, router, "PUT", path, `{"name":"Dune"}`)
+	var updated Series
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.Name != "Dune" || updated.Description != "" {
+		t.Errorf("Expected the series replaced, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/series/abc", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestBooksInSeries(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Series{Name: "Dune Chronicles"}
+	db.Create(&dune)
+
+	for _, b := range []string{
+		`{"title":"Children of Dune","author":"Frank Herbert","isbn":"9780441104024","series_id":%d,"series_volume":3}`,
+		`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","series_id":%d,"series_volume":1}`,
+		`{"title":"Dune Messiah","author":"Frank Herbert","isbn":"9780441172696","series_id":%d,"series_volume":2}`,
+	} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/books", fmt.Sprintf(b, dune.ID)); response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+		}
+	}
+	for _, body := range []string{
+		`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","series_id":999}`,
+		`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","series_volume":2}`,
+		fmt.Sprintf(`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","series_id":%d,"series_volume":-1}`, dune.ID),
+	} {
+		response := webhookRequest(t, router, "POST", "/api/v1/books", body)
+		if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "series") {
+			t.Errorf("Expected status 400 for %s, got %d: %s", body, response.Code, response.Body.String())
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/series/%d", dune.ID)
+	response := webhookRequest(t, router, "GET", path+"/books", "")
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 3 || books[0].Title != "Dune" || books[1].Title != "Dune Messiah" || books[2].Title != "Children of Dune" {
+		t.Fatalf("Expected the books by volume, got %s", response.Body.String())
+	}
+
+	// Books carry their series, in lists and on their own
+	if s := books[1].Series; s == nil || s.ID != dune.ID || s.Name != "Dune Chronicles" || s.Volume != 2 {
+		t.Errorf("Expected the series embedded, got %+v", s)
+	}
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/books/%d", books[0].ID), "")
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if book.Series == nil || book.Series.Name != "Dune Chronicles" || book.Series.Volume != 1 {
+		t.Errorf("Expected the book's series, got %s", response.Body.String())
+	}
+
+	// A renamed series shows on cached books too
+	webhookRequest(t, router, "PUT", path, `{"name":"Dune Saga"}`)
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/books/%d", books[0].ID), "")
+	book = Book{}
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if book.Series == nil || book.Series.Name != "Dune Saga" {
+		t.Errorf("Expected the new series name, got %s", response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "GET", path, "")
+	var got Series
+	json.Unmarshal(response.Body.Bytes(), &got)
+	if got.BookCount != 3 {
+		t.Errorf("Expected 3 books, got %s", response.Body.String())
+	}
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 deleting a series with books, got %d", response.Code)
+	}
+}
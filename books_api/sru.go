@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Largest page requested from an SRU server
+const sruMaxRecords = 50
+
+// sruClient searches a library catalog over SRU (Search/Retrieve via URL),
+// the HTTP successor of Z39.50, asking for MARCXML records. It also
+// satisfies MetadataProvider so it can back enrichment.
+type sruClient struct {
+	baseURL    string
+	isbnIndex  string
+	titleIndex string
+	client     *http.Client
+}
+
+// Shared client, configured in initMetadata
+var sruCatalog *sruClient
+
+func newSRUClient(baseURL, isbnIndex, titleIndex string, timeout time.Duration) *sruClient {
+	return &sruClient{
+		baseURL:    baseURL,
+		isbnIndex:  isbnIndex,
+		titleIndex: titleIndex,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *sruClient) Name() string {
+	return "sru"
+}
+
+// SRURecord is a catalog record normalized to our field names, with the
+// MARC record it came from
+type SRURecord struct {
+	LCCN        string   `json:"lccn,omitempty"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	ISBNs       []string `json:"isbns"`
+	Year        int      `json:"year"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Subjects    []string `json:"subjects"`
+	MARCXML     string   `json:"marcxml"`
+}
+
+// SRUResults is a page of SRU search results
+type SRUResults struct {
+	Items []SRURecord `json:"items"`
+	Total int         `json:"total"`
+}
+
+// Wire format of a searchRetrieve response. Elements are matched by local
+// name so SRU 1.1 and 2.0 responses both decode.
+type sruResponse struct {
+	NumberOfRecords int `xml:"numberOfRecords"`
+	Records         []struct {
+		Data struct {
+			Record marcRecord `xml:"record"`
+		} `xml:"recordData"`
+	} `xml:"records>record"`
+	Diagnostics []struct {
+		Message string `xml:"message"`
+		Details string `xml:"details"`
+	} `xml:"diagnostics>diagnostic"`
+}
+
+// MARC 21 record in MARCXML
+type marcRecord struct {
+	XMLName       xml.Name           `xml:"http://www.loc.gov/MARC21/slim record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []marcControlField `xml:"controlfield"`
+	DataFields    []marcDataField    `xml:"datafield"`
+}
+
+type marcControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (m *marcRecord) control(tag string) string {
+	for _, f := range m.ControlFields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func (m *marcRecord) fields(tag string) []marcDataField {
+	var out []marcDataField
+	for _, f := range m.DataFields {
+		if f.Tag == tag {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// First subfield code of the first field tag, trimmed of ISBD punctuation
+func (m *marcRecord) subfield(tag, code string) string {
+	for _, f := range m.fields(tag) {
+		if v := f.subfield(code); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (f marcDataField) subfield(code string) string {
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			return trimISBD(s.Value)
+		}
+	}
+	return ""
+}
+
+// Drop the punctuation MARC puts between subfields, such as the " /"
+// before a statement of responsibility
+func trimISBD(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, " /:;,=")
+	// A final period ends the field, unless it closes an initial ("Jr.")
+	if strings.HasSuffix(s, ".") && !strings.HasSuffix(s, " Jr.") && (len(s) < 3 || s[len(s)-3] != ' ') {
+		s = strings.TrimSuffix(s, ".")
+	}
+	return strings.TrimSpace(s)
+}
+
+func (m *marcRecord) normalize() SRURecord {
+	r := SRURecord{
+		LCCN:        strings.TrimSpace(m.subfield("010", "a")),
+		Title:       m.subfield("245", "a"),
+		Description: m.subfield("520", "a"),
+		ISBNs:       []string{},
+		Subjects:    []string{},
+	}
+	// Re-encoded, since catalogs differ in how they prefix MARCXML
+	if data, err := xml.Marshal(m); err == nil {
+		r.MARCXML = string(data)
+	}
+	if sub := m.subfield("245", "b"); sub != "" {
+		r.Title += ": " + sub
+	}
+
+	// Personal names are entered "Surname, Forenames"
+	if author := m.fields("100"); len(author) > 0 {
+		r.Author = author[0].subfield("a")
+		if last, first, ok := strings.Cut(r.Author, ", "); ok && author[0].Ind1 == "1" {
+			r.Author = first + " " + last
+		}
+	} else {
+		r.Author = m.subfield("110", "a")
+	}
+
+	for _, f := range m.fields("020") {
+		// "9780441013593 (pbk.)"
+		if fields := strings.Fields(f.subfield("a")); len(fields) > 0 {
+			r.ISBNs = append(r.ISBNs, cleanISBN(fields[0]))
+		}
+	}
+
+	// RDA records use 264, older ones 260; 008 holds the date as a fallback
+	for _, tag := range []string{"264", "260"} {
+		if r.Year == 0 {
+			r.Year = parseYear(m.subfield(tag, "c"))
+		}
+		if r.Publisher == "" {
+			r.Publisher = m.subfield(tag, "b")
+		}
+	}
+	if f008 := m.control("008"); r.Year == 0 && len(f008) >= 11 {
+		r.Year, _ = strconv.Atoi(f008[7:11])
+	}
+
+	// Headings repeat with different subdivisions
+	seen := map[string]bool{}
+	for _, f := range m.fields("650") {
+		if s := f.subfield("a"); s != "" && !seen[s] {
+			seen[s] = true
+			r.Subjects = append(r.Subjects, s)
+		}
+	}
+	return r
+}
+
+// CQL term for index=value
+func cqlTerm(index, value string) string {
+	return index + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// Run a searchRetrieve with a CQL query. start counts from 0.
+func (s *sruClient) Search(ctx context.Context, query string, start, max int) ([]SRURecord, int, error) {
+	q := url.Values{
+		"version":        {"1.1"},
+		"operation":      {"searchRetrieve"},
+		"query":          {query},
+		"startRecord":    {strconv.Itoa(start + 1)},
+		"maximumRecords": {strconv.Itoa(max)},
+		"recordSchema":   {"marcxml"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sru: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("sru: unexpected status %d", resp.StatusCode)
+	}
+
+	var result sruResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("sru: decoding response: %w", err)
+	}
+	if len(result.Diagnostics) > 0 {
+		d := result.Diagnostics[0]
+		return nil, 0, fmt.Errorf("sru: %s %s", d.Message, d.Details)
+	}
+
+	records := make([]SRURecord, 0, len(result.Records))
+	for _, rec := range result.Records {
+		records = append(records, rec.Data.Record.normalize())
+	}
+	return records, result.NumberOfRecords, nil
+}
+
+// First record for an ISBN
+func (s *sruClient) Record(ctx context.Context, isbn string) (*SRURecord, error) {
+	records, _, err := s.Search(ctx, cqlTerm(s.isbnIndex, cleanISBN(isbn)), 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrMetadataNotFound
+	}
+	return &records[0], nil
+}
+
+func (s *sruClient) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	rec, err := s.Record(ctx, isbn)
+	if err != nil {
+		return nil, err
+	}
+	return &BookMetadata{
+		Title:       rec.Title,
+		Author:      rec.Author,
+		Year:        rec.Year,
+		Description: rec.Description,
+		Publisher:   rec.Publisher,
+	}, nil
+}
+
+// Search the library catalog by ISBN or title
+func searchSRU(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var query string
+	if isbn := strings.TrimSpace(r.URL.Query().Get("isbn")); isbn != "" {
+		query = cqlTerm(sruCatalog.isbnIndex, cleanISBN(isbn))
+	} else if title := strings.TrimSpace(r.URL.Query().Get("title")); title != "" {
+		query = cqlTerm(sruCatalog.titleIndex, title)
+	} else {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query parameter isbn or title is required")
+		return
+	}
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	if start < 0 {
+		start = 0
+	}
+	max, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if max <= 0 || max > sruMaxRecords {
+		max = 10
+	}
+
+	records, total, err := sruCatalog.Search(r.Context(), query, start, max)
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SRUResults{Total: total, Items: listOf(records)})
+}
+
+// Create a local book from the catalog record for an ISBN. Subject
+// headings become tags.
+func createBookFromSRU(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	isbn := cleanISBN(mux.Vars(r)["isbn"])
+	rec, err := sruCatalog.Record(r.Context(), isbn)
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+
+	book := Book{
+		Title:       rec.Title,
+		Author:      rec.Author,
+		ISBN:        isbn,
+		Description: rec.Description,
+	}
+	// Drop implausible dates rather than refusing the import
+	if min, max := cfg.yearRange(); rec.Year >= min && rec.Year <= max {
+		book.Year = rec.Year
+	}
+
+	if errs := validateBook(&book); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	var existing Book
+	if err := db.WithContext(r.Context()).Where("isbn = ?", book.ISBN).First(&existing).Error; err == nil {
+		writeError(w, r, http.StatusConflict, "isbn_exists", "A book with this ISBN already exists")
+		return
+	}
+
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		for _, subject := range rec.Subjects {
+			tag := Tag{Name: strings.ToLower(subject)}
+			if err := tx.Where(Tag{Name: tag.Name}).FirstOrCreate(&tag).Error; err != nil {
+				return err
+			}
+			book.Tags = append(book.Tags, tag)
+		}
+		return tx.Create(&book).Error
+	})
+	if err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create book")
+		}
+		return
+	}
+	notifyBookAdded(&book)
+
+	writeJSON(w, http.StatusCreated, book)
+}
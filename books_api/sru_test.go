@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Point the shared SRU client at a fake catalog for one test
+func useSRUServer(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	saved := sruCatalog
+	sruCatalog = newSRUClient(srv.URL, "bath.isbn", "dc.title", time.Second)
+	t.Cleanup(func() {
+		sruCatalog = saved
+		srv.Close()
+	})
+}
+
+func serveSRUFixture(t *testing.T, wantQuery string) http.HandlerFunc {
+	fixture, err := os.ReadFile("testdata/sru/dune.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("operation") != "searchRetrieve" || q.Get("recordSchema") != "marcxml" || q.Get("query") != wantQuery {
+			t.Errorf("Unexpected upstream request %s", r.URL)
+		}
+		w.Write(fixture)
+	}
+}
+
+func TestSearchSRU(t *testing.T) {
+	router := setupRouter()
+	useSRUServer(t, serveSRUFixture(t, `dc.title="dune \"deluxe\""`))
+
+	req, _ := http.NewRequest("GET", `/api/v1/external/sru?title=dune+"deluxe"`, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var body struct {
+		Total int         `json:"total"`
+		Items []SRURecord `json:"items"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if body.Total != 1 || len(body.Items) != 1 {
+		t.Fatalf("Expected one record, got %+v", body)
+	}
+	rec := body.Items[0]
+	if !strings.Contains(rec.MARCXML, `<datafield tag="245" ind1="1" ind2="0">`) {
+		t.Errorf("Expected the MARC record to be returned, got %s", rec.MARCXML)
+	}
+	rec.MARCXML = ""
+	want := SRURecord{
+		LCCN:        "2005299093",
+		Title:       "Dune",
+		Author:      "Frank Herbert",
+		ISBNs:       []string{"9780441013593", "0441013597"},
+		Year:        2005,
+		Publisher:   "Ace Books",
+		Description: "Paul Atreides and the desert planet Arrakis",
+		Subjects:    []string{"Science fiction", "Arrakis (Imaginary place)"},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("Expected %+v, got %+v", want, rec)
+	}
+}
+
+func TestCreateBookFromSRU(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	useSRUServer(t, serveSRUFixture(t, `bath.isbn="9780441013593"`))
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/from-sru/978-0441013593", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var book Book
+	db.Preload("Tags").First(&book, "isbn = ?", "9780441013593")
+	if book.Title != "Dune" || book.Author != "Frank Herbert" || book.Year != 2005 {
+		t.Errorf("Unexpected book %+v", book)
+	}
+	if len(book.Tags) != 2 || book.Tags[0].Name != "science fiction" {
+		t.Errorf("Expected subject headings as tags, got %+v", book.Tags)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a second import, got %d", response.Code)
+	}
+}
+
+func TestSRUDiagnostics(t *testing.T) {
+	router := setupRouter()
+	useSRUServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<searchRetrieveResponse xmlns="http://www.loc.gov/zing/srw/">
+  <numberOfRecords>0</numberOfRecords>
+  <diagnostics><diagnostic xmlns="http://www.loc.gov/zing/srw/diagnostic/">
+    <uri>info:srw/diagnostic/1/16</uri><message>Unsupported index</message><details>bath.isbn</details>
+  </diagnostic></diagnostics>
+</searchRetrieveResponse>`))
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/external/sru?isbn=9780441013593", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d", response.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Catalog statistics tuning
+const (
+	// Authors in the top authors list
+	statsTopAuthors = 10
+	// Books in the newest additions list
+	statsNewestBooks = 5
+)
+
+// CatalogStats is aggregate catalog data for public dashboards
+type CatalogStats struct {
+	GeneratedAt    time.Time     `json:"generated_at"`
+	TotalBooks     int64         `json:"total_books"`
+	TotalAuthors   int64         `json:"total_authors"`
+	BooksPerDecade []DecadeCount `json:"books_per_decade"`
+	// Books without a publication year, left out of books_per_decade
+	UnknownYear int64    `json:"unknown_year"`
+	TopAuthors  []Author `json:"top_authors"`
+	NewestBooks []Book   `json:"newest_books"`
+	// What the books the library holds cost, per purchase currency, for
+	// insurance reporting
+	CollectionValue []CurrencyValue `json:"collection_value"`
+	// Held books without a purchase price, left out of collection_value
+	UnvaluedBooks int64 `json:"unvalued_books"`
+}
+
+// CurrencyValue is the total purchase price of the books bought in a
+// currency, in its minor unit
+type CurrencyValue struct {
+	Currency   string `json:"currency"`
+	TotalCents int64  `json:"total_cents"`
+	Books      int64  `json:"books"`
+}
+
+// The statuses of books the library holds. Lost, archived and on order
+// books aren't part of the collection's value.
+var heldBookStatuses = []string{bookAvailable, bookOnLoan}
+
+// DecadeCount is the number of books published in a decade, e.g. 1960
+// for 1960 to 1969
+type DecadeCount struct {
+	Decade int   `json:"decade"`
+	Books  int64 `json:"books"`
+}
+
+// Aggregate catalog figures, computed by the database so clients don't
+// have to download every book
+func getCatalogStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	s, err := catalogStats(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to compute statistics")
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// One GROUP BY or COUNT query per figure
+func catalogStats(ctx context.Context) (*CatalogStats, error) {
+	s := &CatalogStats{GeneratedAt: time.Now().UTC()}
+	books := func() *gorm.DB { return db.WithContext(ctx).Model(&Book{}) }
+
+	if err := books().Count(&s.TotalBooks).Error; err != nil {
+		return nil, err
+	}
+	if err := books().Where("author <> ''").Distinct("author").Count(&s.TotalAuthors).Error; err != nil {
+		return nil, err
+	}
+	if err := books().Where("year = 0").Count(&s.UnknownYear).Error; err != nil {
+		return nil, err
+	}
+	err := books().
+		Select("(year / 10) * 10 AS decade, COUNT(*) AS books").
+		Where("year <> 0").
+		Group("decade").Order("decade").
+		Scan(&s.BooksPerDecade).Error
+	if err != nil {
+		return nil, err
+	}
+	err = authorsWithCounts(ctx).
+		Having("COUNT(books.id) > 0").
+		Order("book_count DESC, authors.name").
+		Limit(statsTopAuthors).Scan(&s.TopAuthors).Error
+	if err != nil {
+		return nil, err
+	}
+	err = books().
+		Select("purchase_currency AS currency, SUM(purchase_price_cents) AS total_cents, COUNT(*) AS books").
+		Where("status IN ? AND purchase_price_cents > 0", heldBookStatuses).
+		Group("purchase_currency").Order("purchase_currency").
+		Scan(&s.CollectionValue).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := books().Where("status IN ? AND purchase_price_cents = 0", heldBookStatuses).Count(&s.UnvaluedBooks).Error; err != nil {
+		return nil, err
+	}
+	if err := db.WithContext(ctx).Preload("Tags").Order("id DESC").Limit(statsNewestBooks).Find(&s.NewestBooks).Error; err != nil {
+		return nil, err
+	}
+
+	s.BooksPerDecade = listOf(s.BooksPerDecade)
+	s.TopAuthors = listOf(s.TopAuthors)
+	s.NewestBooks = listOf(s.NewestBooks)
+	s.CollectionValue = listOf(s.CollectionValue)
+	return s, nil
+}
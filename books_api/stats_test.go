@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatalogStats(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696", Year: 1969},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", Year: 1984},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Year: 1815},
+		{Title: "Untitled", Author: "Jane Austen", ISBN: "9780132350884"},
+	} {
+		db.Create(&b)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/stats", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var s CatalogStats
+	json.Unmarshal(response.Body.Bytes(), &s)
+	if s.TotalBooks != 5 || s.TotalAuthors != 3 || s.UnknownYear != 1 {
+		t.Errorf("Unexpected totals %+v", s)
+	}
+	want := []DecadeCount{{1810, 1}, {1960, 2}, {1980, 1}}
+	if len(s.BooksPerDecade) != len(want) {
+		t.Fatalf("Unexpected decades %+v", s.BooksPerDecade)
+	}
+	for i, d := range want {
+		if s.BooksPerDecade[i] != d {
+			t.Errorf("Expected %+v, got %+v", d, s.BooksPerDecade[i])
+		}
+	}
+	// Ties go to the author first in alphabetical order
+	top := func(i int) Author { return Author{Name: s.TopAuthors[i].Name, BookCount: s.TopAuthors[i].BookCount} }
+	if len(s.TopAuthors) != 3 || top(0) != (Author{Name: "Frank Herbert", BookCount: 2}) || top(1) != (Author{Name: "Jane Austen", BookCount: 2}) {
+		t.Errorf("Unexpected top authors %+v", s.TopAuthors)
+	}
+	if len(s.NewestBooks) != 5 || s.NewestBooks[0].Title != "Untitled" {
+		t.Errorf("Expected the newest book first, got %+v", s.NewestBooks)
+	}
+}
+
+func TestCatalogStatsCollectionValue(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", PurchasePriceCents: 1999, PurchaseCurrency: "USD"},
+		{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "9780441172696", PurchasePriceCents: 1500, PurchaseCurrency: "USD", Status: bookOnLoan},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", PurchasePriceCents: 899, PurchaseCurrency: "GBP"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "9780441569595", PurchasePriceCents: 2500, PurchaseCurrency: "USD", Status: bookLost},
+		{Title: "Untitled", Author: "Jane Austen", ISBN: "9780132350884"},
+	} {
+		// New books can't start on loan or lost
+		status := b.Status
+		b.Status = ""
+		db.Create(&b)
+		if status != "" {
+			db.Model(&b).UpdateColumn("status", status)
+		}
+	}
+
+	s, err := catalogStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lost book isn't held, so isn't counted
+	want := []CurrencyValue{{"GBP", 899, 1}, {"USD", 3499, 2}}
+	if len(s.CollectionValue) != len(want) || s.CollectionValue[0] != want[0] || s.CollectionValue[1] != want[1] {
+		t.Errorf("Expected %+v, got %+v", want, s.CollectionValue)
+	}
+	if s.UnvaluedBooks != 1 {
+		t.Errorf("Expected 1 unvalued book, got %d", s.UnvaluedBooks)
+	}
+}
+
+func TestCatalogStatsEmpty(t *testing.T) {
+	clearDB()
+	req, _ := http.NewRequest("GET", "/api/v1/stats", nil)
+	response := httptest.NewRecorder()
+	setupRouter().ServeHTTP(response, req)
+
+	var s map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &s)
+	for _, key := range []string{"books_per_decade", "top_authors", "newest_books", "collection_value"} {
+		if list, ok := s[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("Expected %s to be an empty list, got %v", key, s[key])
+		}
+	}
+}
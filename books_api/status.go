@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Book statuses: where a copy is in its life in the library
+const (
+	bookAvailable = "available" // on the shelf
+	bookOnLoan    = "on_loan"   // lent to a member
+	bookLost      = "lost"
+	bookArchived  = "archived" // withdrawn from circulation
+	bookOnOrder   = "on_order" // ordered but not yet received
+)
+
+var bookStatuses = setOf(bookAvailable, bookOnLoan, bookLost, bookArchived, bookOnOrder)
+
+// Status changes a book write may make, from "" for a new book. Anything
+// else is refused. Only loans move books between available and on_loan,
+// so a book is on loan exactly when it has an open loan.
+var bookStatusTransitions = map[string][]string{
+	"":            {bookAvailable, bookOnOrder},
+	bookAvailable: {bookLost, bookArchived},
+	bookOnLoan:    {bookLost},
+	bookLost:      {bookAvailable, bookArchived},
+	bookArchived:  {bookAvailable},
+	bookOnOrder:   {bookAvailable, bookArchived},
+}
+
+// A status as stored: trimmed and lowercase, available when not given
+func normalizeBookStatus(status string) string {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if status == "" {
+		return bookAvailable
+	}
+	return status
+}
+
+// Whether a book may move from one status to another. Staying put is
+// always allowed.
+func canChangeBookStatus(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, s := range bookStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Statement setting marking a status write made by checking a book out or
+// returning it
+const loanStatusWrite = "books:loan_status"
+
+// Set a book's status as a loan checks it out or returns it, within tx
+func setLoanStatus(tx *gorm.DB, b *Book, status string) error {
+	return tx.Set(loanStatusWrite, true).Model(b).Update("status", status).Error
+}
+
+// Refuse a book write that moves its status in a way the lifecycle
+// doesn't allow. Runs in the book's write transaction.
+func checkBookStatus(tx *gorm.DB, b *Book) error {
+	if _, ok := tx.Get(loanStatusWrite); ok {
+		return nil
+	}
+	if b.Status == "" {
+		// A new book takes the column's default; otherwise the write
+		// isn't of a whole book, such as an update of one column
+		return nil
+	}
+	var before Book
+	if b.ID != 0 {
+		err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Select("status").Limit(1).Find(&before, b.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	if !canChangeBookStatus(before.Status, b.Status) {
+		from := before.Status
+		if from == "" {
+			from = "new"
+		}
+		return RejectField("status", fmt.Sprintf("can't change from %s to %s", from, b.Status))
+	}
+	return nil
+}
+
+// Mark books with an open loan as on loan, for databases from before
+// books had a status, whose books all start available
+func backfillBookStatus() {
+	err := db.Unscoped().Model(&Book{}).
+		Where("status = ? AND id IN (?)", bookAvailable, db.Model(&Loan{}).Select("book_id").Where("returned_at IS NULL")).
+		UpdateColumn("status", bookOnLoan).Error
+	if err != nil {
+		log.Fatal("Failed to backfill book statuses:", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBookStatusTransitions(t *testing.T) {
+	for _, c := range []struct {
+		from, to string
+		ok       bool
+	}{
+		{"", bookAvailable, true},
+		{"", bookOnOrder, true},
+		{"", bookLost, false},
+		{bookOnOrder, bookAvailable, true},
+		{bookAvailable, bookOnLoan, false},
+		{bookOnLoan, bookAvailable, false},
+		{bookOnLoan, bookLost, true},
+		{bookAvailable, bookOnOrder, false},
+		{bookOnLoan, bookArchived, false},
+		{bookLost, bookAvailable, true},
+		{bookArchived, bookLost, false},
+		{bookArchived, bookArchived, true},
+	} {
+		if got := canChangeBookStatus(c.from, c.to); got != c.ok {
+			t.Errorf("%q to %q: expected %v, got %v", c.from, c.to, c.ok, got)
+		}
+	}
+}
+
+func TestBookStatus(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593"}`)
+	var dune Book
+	json.Unmarshal(response.Body.Bytes(), &dune)
+	if response.Code != http.StatusCreated || dune.Status != bookAvailable {
+		t.Fatalf("Expected a new book available, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","status":"On_Order"}`)
+	var emma Book
+	json.Unmarshal(response.Body.Bytes(), &emma)
+	if response.Code != http.StatusCreated || emma.Status != bookOnOrder {
+		t.Fatalf("Expected Emma on order, got %d: %s", response.Code, response.Body.String())
+	}
+	for _, body := range []string{
+		`{"title":"Lost","author":"A","isbn":"9780441569595","status":"lost"}`,
+		`{"title":"Odd","author":"A","isbn":"9780441569595","status":"misplaced"}`,
+	} {
+		response := webhookRequest(t, router, "POST", "/api/v1/books", body)
+		if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), `"field":"status"`) {
+			t.Errorf("Expected a status field error for %s, got %d: %s", body, response.Code, response.Body.String())
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/books/%d", dune.ID)
+	if response := webhookRequest(t, router, "PUT", path, `{"status":"lost"}`); response.Code != http.StatusOK {
+		t.Errorf("Expected available to lost, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "PUT", path, `{"status":"on_order"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "can't change from lost to on_order") {
+		t.Errorf("Expected lost to on_order refused, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for query, want := range map[string]string{"status=lost": "Dune", "status=ON_ORDER": "Emma", "status=available": ""} {
+		response := webhookRequest(t, router, "GET", "/api/v1/books?"+query, "")
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if got := strings.Join(bookTitles(books), ","); got != want {
+			t.Errorf("Expected %q for %s, got %q", want, query, got)
+		}
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/books?status=gone", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown status, got %d", response.Code)
+	}
+}
+
+func TestLoansMoveBookStatus(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Status: bookOnOrder}
+	db.Create(&dune)
+	db.Create(&emma)
+	addMember(t, "card-1001")
+
+	response := webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/books/%d/checkout", emma.ID), `{"borrower":"card-1001"}`)
+	if response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "The book is on_order") {
+		t.Errorf("Expected a book on order refused, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/books/%d/checkout", dune.ID), `{"borrower":"card-1001"}`)
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	db.First(&dune, dune.ID)
+	if response.Code != http.StatusCreated || dune.Status != bookOnLoan {
+		t.Fatalf("Expected the book on loan, got %q and %d: %s", dune.Status, response.Code, response.Body.String())
+	}
+	// Only the loan puts it back on the shelf
+	path := fmt.Sprintf("/api/v1/books/%d", dune.ID)
+	response = webhookRequest(t, router, "PUT", path, `{"status":"available"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "can't change from on_loan to available") {
+		t.Errorf("Expected a write refused while the loan is open, got %d: %s", response.Code, response.Body.String())
+	}
+	webhookRequest(t, router, "POST", fmt.Sprintf("/api/v1/loans/%d/return", loan.ID), "")
+	db.First(&dune, dune.ID)
+	if dune.Status != bookAvailable {
+		t.Errorf("Expected the returned book available, got %q", dune.Status)
+	}
+	response = webhookRequest(t, router, "PUT", path, `{"status":"on_loan"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "can't change from available to on_loan") {
+		t.Errorf("Expected on_loan without a loan refused, got %d: %s", response.Code, response.Body.String())
+	}
+}
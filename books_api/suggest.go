@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Suggestion tuning
+const (
+	defaultSuggestions = 8
+	maxSuggestions     = 20
+)
+
+// Fields the suggest endpoint completes, mapped to their columns
+var suggestColumns = map[string]string{
+	"title":  "title",
+	"author": "author",
+}
+
+// Case-insensitive indexes for prefix matching. SQLite's LIKE ignores
+// ASCII case, so it can only use an index with the same NOCASE collation.
+var suggestIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_books_title_nocase ON books(title COLLATE NOCASE)`,
+	`CREATE INDEX IF NOT EXISTS idx_books_author_nocase ON books(author COLLATE NOCASE)`,
+}
+
+// Suggestion is a title or author starting with what was typed
+type Suggestion struct {
+	Value string `json:"value"`
+	Field string `json:"field"`
+}
+
+// Create the prefix indexes on first start
+func initSuggestIndexes() {
+	for _, stmt := range suggestIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Creating a suggest index failed, suggestions scan the table: %v", err)
+		}
+	}
+}
+
+// LIKE pattern matching values that start with s, with the wildcards in s
+// taken literally
+func prefixPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// Distinct titles or authors starting with ?q=, for search box typeahead.
+// ?field= picks title or author; without it both are completed, titles
+// first.
+func getSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_parameter", "Query parameter q is required")
+		return
+	}
+	fields := []string{"title", "author"}
+	if f := r.URL.Query().Get("field"); f != "" {
+		if _, ok := suggestColumns[f]; !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "field must be title or author")
+			return
+		}
+		fields = []string{f}
+	}
+	limit := defaultSuggestions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestions {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 20")
+			return
+		}
+		limit = n
+	}
+
+	var suggestions []Suggestion
+	for _, f := range fields {
+		if len(suggestions) >= limit {
+			break
+		}
+		column := suggestColumns[f]
+		var values []string
+		err := db.WithContext(r.Context()).Model(&Book{}).
+			Where(column+` LIKE ? ESCAPE '\'`, prefixPattern(q)).
+			Distinct(column).Order(column+" COLLATE NOCASE").
+			Limit(limit-len(suggestions)).Pluck(column, &values).Error
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to find suggestions")
+			return
+		}
+		for _, v := range values {
+			suggestions = append(suggestions, Suggestion{Value: v, Field: f})
+		}
+	}
+	writeList(w, r, suggestions)
+}
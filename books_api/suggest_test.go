@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getSuggestionList(t *testing.T, router http.Handler, query string) []Suggestion {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/api/v1/suggest?"+query, nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for %s, got %d: %s", query, response.Code, response.Body.String())
+	}
+	var suggestions []Suggestion
+	json.Unmarshal(response.Body.Bytes(), &suggestions)
+	return suggestions
+}
+
+func TestSuggestions(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	for _, b := range []Book{
+		{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884"},
+		{Title: "Clean Architecture", Author: "Robert C. Martin", ISBN: "9780134494166"},
+		{Title: "The Clean Coder", Author: "Robert C. Martin", ISBN: "9780137081073"},
+		{Title: "Cleopatra", Author: "Stacy Schiff", ISBN: "9780316001922"},
+		{Title: "100% Pure", Author: "Clementine Cole", ISBN: "9780441013593"},
+	} {
+		db.Create(&b)
+	}
+
+	// Prefixes only, ignoring case, in alphabetical order
+	got := getSuggestionList(t, router, "q=cle&field=title")
+	want := []string{"Clean Architecture", "Clean Code", "Cleopatra"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %+v", want, got)
+	}
+	for i, v := range want {
+		if got[i] != (Suggestion{v, "title"}) {
+			t.Errorf("Expected %v, got %+v", want, got)
+		}
+	}
+
+	// Authors are distinct, and come after titles without a field
+	if got := getSuggestionList(t, router, "q=ROB&field=author"); len(got) != 1 || got[0] != (Suggestion{"Robert C. Martin", "author"}) {
+		t.Errorf("Unexpected author suggestions %+v", got)
+	}
+	if got := getSuggestionList(t, router, "q=cle"); len(got) != 4 || got[3] != (Suggestion{"Clementine Cole", "author"}) {
+		t.Errorf("Unexpected suggestions %+v", got)
+	}
+	if got := getSuggestionList(t, router, "q=cle&limit=2"); len(got) != 2 {
+		t.Errorf("Expected the limit applied, got %+v", got)
+	}
+
+	// Wildcards are literal
+	if got := getSuggestionList(t, router, "q=100%25"); len(got) != 1 {
+		t.Errorf("Expected one match for 100%%, got %+v", got)
+	}
+	if got := getSuggestionList(t, router, "q=_lean"); len(got) != 0 {
+		t.Errorf("Expected no match for _lean, got %+v", got)
+	}
+
+	for _, query := range []string{"", "q=cle&field=isbn", "q=cle&limit=21"} {
+		req, _ := http.NewRequest("GET", "/api/v1/suggest?"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", query, response.Code)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Cover rendition names and their maximum widths in pixels
+var coverSizes = []struct {
+	name  string
+	width int
+}{
+	{"sm", 160},
+	{"md", 320},
+	{"lg", 640},
+}
+
+// Refuse to decode images larger than this many pixels
+const maxCoverPixels = 40_000_000
+
+// Report whether name is a known rendition
+func validCoverSize(name string) bool {
+	for _, s := range coverSizes {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Blob key of a cover rendition
+func coverVariantKey(id uint, size string) string {
+	return fmt.Sprintf("covers/%d/%s", id, size)
+}
+
+// Queue rendition generation for a freshly stored cover
+func enqueueCoverThumbnails(id uint) {
+	jobs.Enqueue(fmt.Sprintf("cover-thumbnails:%d", id), func(ctx context.Context) error {
+		return generateCoverThumbnails(ctx, id)
+	})
+}
+
+// Render and store every rendition of a book's original cover
+func generateCoverThumbnails(ctx context.Context, id uint) error {
+	body, _, err := blobStore.Get(ctx, coverKey(id))
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	_, err = data.ReadFrom(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return fmt.Errorf("decoding cover %d: %w", id, err)
+	}
+	if conf.Width*conf.Height > maxCoverPixels {
+		return fmt.Errorf("cover %d is too large to resize (%dx%d)", id, conf.Width, conf.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return fmt.Errorf("decoding cover %d: %w", id, err)
+	}
+
+	for _, size := range coverSizes {
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, resizeToWidth(src, size.width), &jpeg.Options{Quality: 85}); err != nil {
+			return err
+		}
+		key := coverVariantKey(id, size.name)
+		if err := blobStore.Put(ctx, key, &out, int64(out.Len()), "image/jpeg"); err != nil {
+			return fmt.Errorf("storing %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Scale src down to width, keeping its aspect ratio. Images are never
+// enlarged, and transparency is flattened onto white for JPEG output.
+func resizeToWidth(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() < width {
+		width = b.Dx()
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
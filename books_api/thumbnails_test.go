@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResizeToWidth(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 1200))
+
+	b := resizeToWidth(src, 160).Bounds()
+	if b.Dx() != 160 || b.Dy() != 240 {
+		t.Errorf("Expected 160x240, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Small images are never enlarged
+	b = resizeToWidth(image.NewRGBA(image.Rect(0, 0, 100, 50)), 640).Bounds()
+	if b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("Expected 100x50, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestCoverThumbnailsGenerated(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Covered", Author: "Author", ISBN: "1234567890555"})
+
+	req, _ := http.NewRequest("PUT", "/api/v1/books/1/cover", bytes.NewReader(testPNG(400, 600)))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	jobs.Wait()
+
+	req, _ = http.NewRequest("GET", "/api/v1/books/1/cover?size=sm", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Expected a JPEG rendition, got %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+	img, err := jpeg.Decode(response.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode rendition: %v", err)
+	}
+	if w := img.Bounds().Dx(); w != 160 {
+		t.Errorf("Expected small rendition to be 160px wide, got %d", w)
+	}
+
+	// A new cover is served at every size until its renditions exist,
+	// rather than the old cover's
+	saved := jobs
+	jobs = newJobQueue(0, 0, time.Second)
+	replacement := testPNG(30, 30)
+	req, _ = http.NewRequest("PUT", "/api/v1/books/1/cover", bytes.NewReader(replacement))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	jobs = saved
+	req, _ = http.NewRequest("GET", "/api/v1/books/1/cover?size=sm", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if !bytes.Equal(response.Body.Bytes(), replacement) {
+		t.Errorf("Expected the new original while renditions are pending, got %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+
+	// Deleting the cover removes every rendition
+	req, _ = http.NewRequest("DELETE", "/api/v1/books/1/cover", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	for _, size := range coverSizes {
+		if _, err := blobStore.Stat(context.Background(), coverVariantKey(1, size.name)); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Expected %s rendition to be deleted, got %v", size.name, err)
+		}
+	}
+}
+
+func TestCoverSizeFallsBackToOriginal(t *testing.T) {
+	clearDB()
+	useLocalBlobStore(t)
+	router := setupRouter()
+	db.Create(&Book{Title: "Covered", Author: "Author", ISBN: "1234567890555"})
+
+	// Store the original without queuing renditions
+	img := testPNG(4, 4)
+	blobStore.Put(context.Background(), coverKey(1), bytes.NewReader(img), int64(len(img)), "image/png")
+	db.Model(&Book{}).Where("id = ?", 1).Updates(map[string]interface{}{"cover_key": coverKey(1), "cover_url": coverPath(1)})
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/1/cover?size=md", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if !bytes.Equal(response.Body.Bytes(), img) {
+		t.Errorf("Expected the original cover while renditions are pending, got %d", response.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/books/1/cover?size=xl", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Deleted books are soft-deleted: GORM hides rows with a deleted_at from
+// every query that doesn't ask for Unscoped. They keep their ISBN, tags,
+// reviews and cover until trash-purge removes them for good.
+
+// TrashedBook is a deleted book that can still be restored
+type TrashedBook struct {
+	Book
+	DeletedAt time.Time `json:"deleted_at"`
+	// When trash-purge may remove the book for good
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// Books in the trash
+func trashedBooks() *gorm.DB {
+	return db.Unscoped().Model(&Book{}).Where("deleted_at IS NOT NULL")
+}
+
+// The books a listing or lookup reads from: the live ones, or with
+// ?include_deleted=true the trash too. Only admins may include it when
+// authentication is on.
+func bookScope(w http.ResponseWriter, r *http.Request) (*gorm.DB, bool) {
+	switch r.URL.Query().Get("include_deleted") {
+	case "", "false":
+		return db.WithContext(r.Context()), true
+	case "true":
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "include_deleted must be true or false")
+		return nil, false
+	}
+	if authEnabled() {
+		p := principalFrom(r)
+		if p == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, "authentication_required", "Authentication required")
+			return nil, false
+		}
+		if !p.HasRole(roleAdmin) {
+			writeError(w, r, http.StatusForbidden, "forbidden", "Only admins can include deleted books")
+			return nil, false
+		}
+	}
+	return db.WithContext(r.Context()).Unscoped(), true
+}
+
+// List deleted books, most recently deleted first
+func getTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var books []Book
+	if err := trashedBooks().WithContext(r.Context()).Preload("Tags").Order("deleted_at DESC, id DESC").Find(&books).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list deleted books")
+		return
+	}
+	trash := make([]TrashedBook, len(books))
+	for i, b := range books {
+		trash[i] = TrashedBook{Book: b, DeletedAt: b.DeletedAt.Time.UTC(), PurgeAt: b.DeletedAt.Time.Add(cfg.TrashRetention).UTC()}
+	}
+	writeList(w, r, trash)
+}
+
+// Undo a delete. The book comes back with its tags, reviews and cover,
+// and the change is recorded as an update.
+func restoreBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+		return
+	}
+	var book Book
+	if err := trashedBooks().WithContext(r.Context()).First(&book, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "book_not_found", "No deleted book with this ID")
+		return
+	}
+
+	book.DeletedAt = gorm.DeletedAt{}
+	if err := db.WithContext(r.Context()).Unscoped().Model(&book).Select("DeletedAt").Updates(&book).Error; err != nil {
+		if !writeHookError(w, r, err) {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to restore book")
+		}
+		return
+	}
+	db.WithContext(r.Context()).Preload("Tags").First(&book, book.ID)
+	setBookETag(w, book.ID)
+	writeJSON(w, http.StatusOK, book)
+}
+
+// Remove a deleted book for good, with the rows that refer to it, and its
+// author when no other book is by them. Hooks and events already ran when
+// it was deleted.
+func purgeBookTx(tx *gorm.DB, book *Book) error {
+	tx = tx.Session(&gorm.Session{SkipHooks: true})
+	if err := tx.Model(book).Association("Tags").Clear(); err != nil {
+		return err
+	}
+	tx.Where("book_id = ?", book.ID).Delete(&Review{})
+	tx.Where("book_id = ?", book.ID).Delete(&Interaction{})
+	tx.Where("book_id = ?", book.ID).Delete(&ListEntry{})
+	tx.Where("book_id = ?", book.ID).Delete(&BookRevision{})
+	tx.Where("book_id = ?", book.ID).Delete(&Acquisition{})
+	tx.Where("book_id = ? OR other_id = ?", book.ID, book.ID).Delete(&BookSimilarity{})
+	if err := tx.Unscoped().Delete(book).Error; err != nil {
+		return err
+	}
+	if book.WorkID != nil {
+		if err := pruneWork(tx, *book.WorkID, book.ID); err != nil {
+			return err
+		}
+	}
+	if book.AuthorID == nil {
+		return nil
+	}
+	return pruneAuthor(tx, *book.AuthorID, book.ID)
+}
+
+// Permanently remove books deleted longer than TRASH_RETENTION ago, with
+// their covers
+func purgeTrash(ctx context.Context) error {
+	var books []Book
+	cutoff := time.Now().Add(-cfg.TrashRetention)
+	if err := trashedBooks().WithContext(ctx).Where("deleted_at < ?", cutoff).Order("id").Find(&books).Error; err != nil {
+		return err
+	}
+	for i := range books {
+		book := &books[i]
+		if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error { return purgeBookTx(tx, book) }); err != nil {
+			return err
+		}
+		if book.CoverKey != "" {
+			if err := deleteCoverBlobs(ctx, book.ID); err != nil {
+				log.Printf("Deleting cover for book %d failed: %v", book.ID, err)
+			}
+		}
+	}
+	if len(books) > 0 {
+		log.Printf("Purged %d deleted books", len(books))
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Tags: []Tag{{Name: "sf"}}})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+	db.Create(&Review{BookID: 1, Rating: 5})
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	if response := do("DELETE", "/api/v1/books/1"); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", response.Code)
+	}
+	if response := do("GET", "/api/v1/books/1"); response.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted book hidden, got %d", response.Code)
+	}
+
+	response := do("GET", "/api/v1/books/trash")
+	var trash []TrashedBook
+	json.Unmarshal(response.Body.Bytes(), &trash)
+	if response.Code != http.StatusOK || len(trash) != 1 || trash[0].Title != "Dune" || len(trash[0].Tags) != 1 {
+		t.Fatalf("Expected Dune in the trash, got %d: %s", response.Code, response.Body.String())
+	}
+	if got := trash[0].PurgeAt.Sub(trash[0].DeletedAt); got != cfg.TrashRetention {
+		t.Errorf("Expected the purge time one retention after the delete, got %v", got)
+	}
+
+	response = do("POST", "/api/v1/books/1/restore")
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusOK || book.Title != "Dune" || len(book.Tags) != 1 || response.Header().Get("ETag") == "" {
+		t.Fatalf("Expected Dune restored with its tags, got %d: %s", response.Code, response.Body.String())
+	}
+	var reviews []Review
+	json.Unmarshal(do("GET", "/api/v1/books/1/reviews").Body.Bytes(), &reviews)
+	if len(reviews) != 1 {
+		t.Errorf("Expected the review back with the book, got %v", reviews)
+	}
+	if titles := getTitles(t, router, "/api/v1/books"); len(titles) != 2 {
+		t.Errorf("Expected both books listed, got %v", titles)
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/books/1/restore":  http.StatusNotFound,
+		"/api/v1/books/99/restore": http.StatusNotFound,
+		"/api/v1/books/x/restore":  http.StatusBadRequest,
+	} {
+		if response := do("POST", path); response.Code != status {
+			t.Errorf("%s: expected %d, got %d", path, status, response.Code)
+		}
+	}
+}
+
+func TestRecreateTrashedISBN(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+	send("DELETE", "/api/v1/books/1", "")
+
+	// Creating the ISBN again points at the deleted book
+	response := send("POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593"}`)
+	if response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "deleted book 1") {
+		t.Errorf("Expected 409 naming the deleted book, got %d: %s", response.Code, response.Body.String())
+	}
+	response = send("PUT", "/api/v1/books/2", `{"isbn":"9780441013593"}`)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected 409 moving a book onto a trashed ISBN, got %d: %s", response.Code, response.Body.String())
+	}
+	response = send("POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"9780141439587"}`)
+	if response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "already exists") {
+		t.Errorf("Expected 409 for a live book's ISBN, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// An upsert restores the deleted book and applies the update
+	response = send("PUT", "/api/v1/books/isbn/0441013597", `{"year":1965}`)
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusOK || book.ID != 1 || book.Year != 1965 || book.Title != "Dune" {
+		t.Fatalf("Expected the deleted book restored, got %d: %s", response.Code, response.Body.String())
+	}
+	var trashed int64
+	trashedBooks().Count(&trashed)
+	if trashed != 0 {
+		t.Errorf("Expected the trash empty, got %d", trashed)
+	}
+	if titles := getTitles(t, router, "/api/v1/books"); len(titles) != 2 {
+		t.Errorf("Expected both books listed, got %v", titles)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	clearDB()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Tags: []Tag{{Name: "sf"}}})
+	db.Create(&Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"})
+	db.Create(&Review{BookID: 1, Rating: 5})
+	db.Create(&Interaction{UserID: 1, BookID: 1, Kind: "loan"})
+	db.Delete(&Book{}, 1)
+	db.Delete(&Book{}, 2)
+	db.Unscoped().Model(&Book{}).Where("id = ?", 1).Update("deleted_at", time.Now().Add(-cfg.TrashRetention-time.Hour))
+
+	// A deleted ISBN stays taken until it is purged
+	if errs, _ := checkBook(context.Background(), &Book{Title: "Dune", Author: "Frank Herbert", ISBN: "0441013597"}, 0); len(errs) != 1 || errs[0].Message != "belongs to a deleted book; restore it instead" {
+		t.Errorf("Expected the deleted book's ISBN reported, got %v", errs)
+	}
+
+	if err := purgeTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	db.Unscoped().Model(&Book{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("Expected only the book past the retention purged, left %v", ids)
+	}
+	for _, model := range []interface{}{&Review{}, &Interaction{}} {
+		var count int64
+		db.Model(model).Count(&count)
+		if count != 0 {
+			t.Errorf("Expected %T rows purged with the book, found %d", model, count)
+		}
+	}
+	var links int64
+	db.Table("book_tags").Count(&links)
+	if links != 0 {
+		t.Errorf("Expected the tag links purged, found %d", links)
+	}
+}
+
+func TestTrashNeedsLibrarian(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{"ann:pw": {Username: "ann", Roles: []string{roleReader}}})
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/books/trash", nil)
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, router, "ann", "pw"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a reader, got %d", response.Code)
+	}
+}
+
+func TestIncludeDeleted(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"ann:pw": {Username: "ann", Roles: []string{roleLibrarian}},
+		"bob:pw": {Username: "bob", Roles: []string{roleAdmin}},
+	})
+	router := setupRouter()
+	dune := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	emma := Book{Title: "Emma", Author: "Jane Austen", ISBN: "9780141439587"}
+	db.Create(&dune)
+	db.Create(&emma)
+	db.Delete(&emma)
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+	ann, bob := loginAs(t, router, "ann", "pw"), loginAs(t, router, "bob", "pw")
+	emmaPath := "/api/v1/books/" + strconv.Itoa(int(emma.ID))
+
+	response := request("/api/v1/books?include_deleted=true", bob)
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 2 || response.Header().Get("X-Total-Count") != "2" {
+		t.Errorf("Expected both books for an admin, got %s", response.Body.String())
+	}
+	if response := request(emmaPath+"?include_deleted=true", bob); response.Code != http.StatusOK {
+		t.Errorf("Expected the deleted book for an admin, got %d", response.Code)
+	}
+	// Without the parameter the deleted book stays hidden, cached or not
+	if response := request(emmaPath, bob); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without include_deleted, got %d", response.Code)
+	}
+	response = request("/api/v1/books", bob)
+	books = nil
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 1 || books[0].Title != "Dune" {
+		t.Errorf("Expected only Dune, got %s", response.Body.String())
+	}
+
+	if response := request("/api/v1/books?include_deleted=true", ann); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a librarian, got %d", response.Code)
+	}
+	if response := request("/api/v1/books?include_deleted=true", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token, got %d", response.Code)
+	}
+	if response := request("/api/v1/books?include_deleted=yes", bob); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preamble shared by every generated client
+const tsClientRuntime = `export class ApiError extends Error {
+  constructor(
+    public readonly status: number,
+    public readonly body: string
+  ) {
+    super(` + "`HTTP ${status}: ${body}`" + `);
+  }
+}
+
+export interface ClientOptions {
+  baseUrl?: string;
+  fetch?: typeof fetch;
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+export class BooksApiClient {
+  private readonly baseUrl: string;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(options: ClientOptions = {}) {
+    this.baseUrl = (options.baseUrl ?? '%s').replace(/\/$/, '');
+    this.fetchImpl = options.fetch ?? fetch;
+  }
+
+  private async request<T>(
+    method: string,
+    path: string,
+    query?: Query,
+    body?: unknown
+  ): Promise<T> {
+    const url = new URL(this.baseUrl + path);
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) {
+        url.searchParams.set(key, String(value));
+      }
+    }
+    const response = await this.fetchImpl(url.toString(), {
+      method,
+      headers:
+        body === undefined ? undefined : { 'Content-Type': 'application/json' },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const text = await response.text();
+    if (!response.ok) {
+      throw new ApiError(response.status, text);
+    }
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+`
+
+// Generate a typed TypeScript client from an OpenAPI document
+func generateTSClient(doc *openAPIDoc) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by `books_api gen ts-client`. DO NOT EDIT.\n\n")
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "export interface %s %s\n\n", name, tsObject(doc.Components.Schemas[name], ""))
+	}
+
+	baseURL := ""
+	if len(doc.Servers) > 0 {
+		baseURL = doc.Servers[0].URL
+	}
+	fmt.Fprintf(&b, tsClientRuntime, baseURL)
+
+	paths := make([]string, 0, len(doc.Paths))
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		methods := make([]string, 0, len(doc.Paths[p]))
+		for m := range doc.Paths[p] {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			if !tsSupported(doc.Paths[p][m]) {
+				continue
+			}
+			b.WriteString("\n")
+			writeTSMethod(&b, strings.ToUpper(m), p, doc.Paths[p][m])
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Whether the client can call an operation: it sends and reads JSON only,
+// so uploads, downloads (even those also offered as JSON) and redirects
+// are left to plain fetch
+func tsSupported(op *openAPIOperation) bool {
+	if op.RequestBody != nil {
+		if _, ok := op.RequestBody.Content["application/json"]; !ok {
+			return false
+		}
+	}
+	for status, resp := range op.Responses {
+		if strings.HasPrefix(status, "3") {
+			return false
+		}
+		if !strings.HasPrefix(status, "2") {
+			continue
+		}
+		for mediaType := range resp.Content {
+			if mediaType != "application/json" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TypeScript type for a schema
+func tsType(s *jsonSchema, indent string) string {
+	var t string
+	switch {
+	case s.Ref != "":
+		t = strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	case s.Type == "array":
+		t = tsType(s.Items, indent)
+		if strings.ContainsAny(t, " |") {
+			t = "(" + t + ")"
+		}
+		t += "[]"
+	case s.Type == "object" && s.AdditionalProperties == nil && len(s.Properties) == 0:
+		t = "Record<string, unknown>"
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		t = "Record<string, " + tsType(s.AdditionalProperties, indent) + ">"
+	case s.Type == "object":
+		t = tsObject(s, indent)
+	case s.Type == "integer" || s.Type == "number":
+		t = "number"
+	case s.Type == "string" || s.Type == "boolean":
+		t = s.Type
+	default:
+		t = "unknown"
+	}
+	if s.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+// Object literal type, one property per line
+func tsObject(s *jsonSchema, indent string) string {
+	required := make(map[string]bool, len(s.Required))
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	order := s.order
+	if len(order) == 0 {
+		for name := range s.Properties {
+			order = append(order, name)
+		}
+		sort.Strings(order)
+	}
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range order {
+		opt := "?"
+		if required[name] {
+			opt = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, name, opt, tsType(s.Properties[name], indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// One client method per operation
+func writeTSMethod(b *strings.Builder, method, path string, op *openAPIOperation) {
+	var args, query []string
+	tsPath := path
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			args = append(args, p.Name+": "+tsType(p.Schema, ""))
+			tsPath = strings.ReplaceAll(tsPath, "{"+p.Name+"}", "${encodeURIComponent("+p.Name+")}")
+		}
+	}
+	if op.RequestBody != nil {
+		// Every field may be left out, of each item for lists
+		body := op.RequestBody.Content["application/json"].Schema
+		if body.Type == "array" {
+			args = append(args, "body: Partial<"+tsType(body.Items, "")+">[]")
+		} else {
+			args = append(args, "body: Partial<"+tsType(body, "")+">")
+		}
+	}
+	queryRequired := false
+	for _, p := range op.Parameters {
+		if p.In == "query" {
+			opt := "?"
+			if p.Required {
+				opt, queryRequired = "", true
+			}
+			query = append(query, fmt.Sprintf("%s%s: %s", p.Name, opt, tsType(p.Schema, "")))
+		}
+	}
+	if len(query) > 0 {
+		arg := "query: { " + strings.Join(query, "; ") + " }"
+		if !queryRequired {
+			arg += " = {}"
+		}
+		args = append(args, arg)
+	}
+
+	result := "void"
+	for _, status := range []string{"200", "201", "202", "204"} {
+		if resp, ok := op.Responses[status]; ok {
+			if media, ok := resp.Content["application/json"]; ok {
+				result = tsType(media.Schema, "  ")
+			}
+			break
+		}
+	}
+
+	callArgs := []string{"'" + method + "'", "`" + tsPath + "`"}
+	switch {
+	case op.RequestBody != nil && len(query) > 0:
+		callArgs = append(callArgs, "query", "body")
+	case op.RequestBody != nil:
+		callArgs = append(callArgs, "undefined", "body")
+	case len(query) > 0:
+		callArgs = append(callArgs, "query")
+	}
+
+	fmt.Fprintf(b, "  /** %s */\n", op.Summary)
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(args, ", "), result)
+	fmt.Fprintf(b, "    return this.request(%s);\n", strings.Join(callArgs, ", "))
+	b.WriteString("  }\n")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// The committed client must match the current spec
+func TestTSClientUpToDate(t *testing.T) {
+	const path = "../tests/client/books-api.ts"
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := generateTSClient(buildOpenAPISpec()); got != string(want) {
+		t.Errorf("%s is out of date; run `go run . gen ts-client -o %s`", path, path)
+	}
+}
+
+func TestGenerateTSClient(t *testing.T) {
+	got := generateTSClient(buildOpenAPISpec())
+	for _, want := range []string{
+		"export interface Book {\n  id: number;\n  title: string;",
+		"  tags?: Tag[];\n",
+		"  created_at: string;\n",
+		"  getBook(id: number): Promise<Book> {\n    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}`);",
+		"searchBooks(query: { q: string; limit?: number; offset?: number }): Promise<Book[]>",
+		"deleteBook(id: number): Promise<void>",
+		"updateBook(id: number, body: Partial<Book>): Promise<Book>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected generated client to contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// FieldError describes a validation problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate a book before it is written, returning every problem found
+func validateBook(book *Book) []FieldError {
+	var errs []FieldError
+
+	if book.Title == "" {
+		errs = append(errs, FieldError{Field: "title", Message: "is required"})
+	}
+	if book.Author == "" {
+		errs = append(errs, FieldError{Field: "author", Message: "is required"})
+	}
+	// Stored as an ISBN-13 without separators, so lookups can match it
+	// in either form
+	book.ISBN = strings.ToUpper(cleanISBN(book.ISBN))
+	if book.ISBN == "" {
+		errs = append(errs, FieldError{Field: "isbn", Message: "is required"})
+	} else if !validISBN(book.ISBN) {
+		errs = append(errs, FieldError{Field: "isbn", Message: "is not a valid ISBN-10 or ISBN-13"})
+	} else {
+		book.ISBN = toISBN13(book.ISBN)
+	}
+
+	// Year is optional; zero means unknown
+	if book.Year != 0 {
+		min, max := cfg.yearRange()
+		if book.Year < min || book.Year > max {
+			errs = append(errs, FieldError{
+				Field:   "year",
+				Message: fmt.Sprintf("must be between %d and %d", min, max),
+			})
+		}
+	}
+
+	if book.PriceCents < 0 {
+		errs = append(errs, FieldError{Field: "price_cents", Message: "must not be negative"})
+	}
+
+	// Language is optional too, and stored lowercase
+	book.Language = normalizeLanguage(book.Language)
+	if book.Language != "" && !validLanguage(book.Language) {
+		errs = append(errs, FieldError{Field: "language", Message: "must be an ISO 639-1 code, such as en"})
+	}
+
+	if book.PageCount < 0 {
+		errs = append(errs, FieldError{Field: "page_count", Message: "must not be negative"})
+	}
+	book.Format = strings.ToLower(strings.TrimSpace(book.Format))
+	if book.Format != "" && !bookFormats[book.Format] {
+		errs = append(errs, FieldError{Field: "format", Message: "must be hardcover, paperback or ebook"})
+	}
+	book.Status = normalizeBookStatus(book.Status)
+	if !bookStatuses[book.Status] {
+		errs = append(errs, FieldError{Field: "status", Message: "must be available, on_loan, lost, archived or on_order"})
+	}
+
+	// A purchase price is optional, but needs the currency it was paid in
+	if book.PurchasePriceCents < 0 {
+		errs = append(errs, FieldError{Field: "purchase_price_cents", Message: "must not be negative"})
+	}
+	book.PurchaseCurrency = normalizeCurrency(book.PurchaseCurrency)
+	if book.PurchaseCurrency != "" && !validCurrency(book.PurchaseCurrency) {
+		errs = append(errs, FieldError{Field: "purchase_currency", Message: "must be an ISO 4217 code, such as USD"})
+	} else if book.PurchaseCurrency == "" && book.PurchasePriceCents > 0 {
+		errs = append(errs, FieldError{Field: "purchase_currency", Message: "is required with a purchase price"})
+	}
+	// Shelved at one of the configured locations, if anywhere, and stored
+	// under its configured code
+	book.Location = strings.TrimSpace(book.Location)
+	if book.Location != "" {
+		if location, ok := findLocation(book.Location); ok {
+			book.Location = location.Code
+		} else {
+			errs = append(errs, FieldError{Field: "location", Message: "is not a configured location"})
+		}
+	}
+	book.CallNumber = strings.TrimSpace(book.CallNumber)
+	errs = append(errs, validateAttributes(book)...)
+
+	return errs
+}
+
+// The formats a book can be published in
+var bookFormats = setOf("hardcover", "paperback", "ebook")
+
+// ValidationErrors is the body of a 400 for invalid fields
+type ValidationErrors struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// Write a 400 response listing the failed fields
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	if wantsProblem(r) {
+		writeProblem(w, r, Problem{
+			Status: http.StatusBadRequest, Code: "validation_failed",
+			Detail: "Validation failed", Fields: listOf(errs),
+		})
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, ValidationErrors{Error: "Validation failed", Fields: listOf(errs)})
+}
+
+// BookValidation is the result of checking a book without saving it
+type BookValidation struct {
+	Valid  bool         `json:"valid"`
+	Fields []FieldError `json:"fields"`
+}
+
+// Rolls back the transaction plugin hooks run in during a validation
+var errValidationOnly = errors.New("validation only")
+
+// Every check a write would make, and one it doesn't: whether the
+// catalog already has the ISBN in either form. The book is validated as
+// an update of the book with ID id, when not zero.
+func checkBook(ctx context.Context, book *Book, id uint) ([]FieldError, error) {
+	errs := validateBook(book)
+	if validISBN(book.ISBN) {
+		// Deleted books keep their ISBN until the trash is purged
+		var taken Book
+		err := db.WithContext(ctx).Unscoped().Where("isbn IN ? AND id <> ?", isbnForms(book.ISBN), id).Limit(1).Find(&taken).Error
+		switch {
+		case err != nil:
+			return nil, err
+		case taken.ID != 0 && taken.DeletedAt.Valid:
+			errs = append(errs, FieldError{Field: "isbn", Message: "belongs to a deleted book; restore it instead"})
+		case taken.ID != 0:
+			errs = append(errs, FieldError{Field: "isbn", Message: "is already in the catalog"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs, nil
+	}
+
+	// Plugins see the book as they would on the write, in a transaction
+	// that is always rolled back
+	stage := hookBeforeCreate
+	if id != 0 {
+		stage = hookBeforeUpdate
+	}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := runBookHooks(tx, stage, book); err != nil {
+			return err
+		}
+		return errValidationOnly
+	})
+	var he *HookError
+	switch {
+	case errors.As(err, &he) && len(he.Fields) > 0:
+		return he.Fields, nil
+	case errors.As(err, &he):
+		// A rejection not tied to a field
+		return []FieldError{{Message: he.Message}}, nil
+	case errors.Is(err, errValidationOnly):
+		return nil, nil
+	}
+	return nil, err
+}
+
+// Validate a book form before it is submitted, without saving anything.
+// ?id= validates it as the new version of that book, so its own ISBN
+// doesn't count as taken.
+func validateBookRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var book Book
+	if err := decodeBody(r, &book); err != nil {
+		writeInvalidBody(w, r)
+		return
+	}
+	var id uint
+	if v := r.URL.Query().Get("id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_book_id", "Invalid book ID")
+			return
+		}
+		if err := db.WithContext(r.Context()).First(&Book{}, n).Error; err != nil {
+			writeError(w, r, http.StatusNotFound, "book_not_found", "Book not found")
+			return
+		}
+		id = uint(n)
+	}
+	book.ID = id
+
+	errs, err := checkBook(r.Context(), &book, id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to validate book")
+		return
+	}
+	writeJSON(w, http.StatusOK, BookValidation{Valid: len(errs) == 0, Fields: listOf(errs)})
+}
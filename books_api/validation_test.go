@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestValidateBookYearRange(t *testing.T) {
+	nextYear := time.Now().Year() + 1
+
+	cases := []struct {
+		year  int
+		valid bool
+	}{
+		{0, true},
+		{1450, true},
+		{2008, true},
+		{nextYear, true},
+		{1449, false},
+		{nextYear + 1, false},
+		{99999, false},
+		{-5, false},
+	}
+
+	for _, c := range cases {
+		book := Book{Title: "T", Author: "A", ISBN: "9780441013593", Year: c.year}
+		errs := validateBook(&book)
+		if c.valid && len(errs) != 0 {
+			t.Errorf("Year %d: expected valid, got %v", c.year, errs)
+		}
+		if !c.valid && (len(errs) != 1 || errs[0].Field != "year") {
+			t.Errorf("Year %d: expected a single year error, got %v", c.year, errs)
+		}
+	}
+}
+
+func TestValidateBookConfiguredYearRange(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	cfg.MinYear = 1900
+	cfg.MaxYear = 1950
+
+	book := Book{Title: "T", Author: "A", ISBN: "9780441013593", Year: 1899}
+	if errs := validateBook(&book); len(errs) != 1 {
+		t.Errorf("Expected year error below configured minimum, got %v", errs)
+	}
+	book.Year = 1951
+	if errs := validateBook(&book); len(errs) != 1 {
+		t.Errorf("Expected year error above configured maximum, got %v", errs)
+	}
+}
+
+func TestValidateBookISBN(t *testing.T) {
+	for isbn, want := range map[string]string{
+		"9780441013593":     "9780441013593",
+		"978-0-441-01359-3": "9780441013593",
+		"0 441 01359 7":     "9780441013593",
+		"080442957x":        "9780804429573",
+		"9791000000015":     "9791000000015",
+	} {
+		book := Book{Title: "T", Author: "A", ISBN: isbn}
+		if errs := validateBook(&book); len(errs) != 0 || book.ISBN != want {
+			t.Errorf("ISBN %q: expected %q, got %q and %v", isbn, want, book.ISBN, errs)
+		}
+	}
+	for _, isbn := range []string{"9780441013594", "0441013598", "978044101359", "garbage", "97804410135X3"} {
+		book := Book{Title: "T", Author: "A", ISBN: isbn}
+		if errs := validateBook(&book); len(errs) != 1 || errs[0].Field != "isbn" {
+			t.Errorf("ISBN %q: expected a single isbn error, got %v", isbn, errs)
+		}
+	}
+}
+
+func TestCreateBookNormalizesISBN(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Dune","author":"Frank Herbert","isbn":"978-0-441-01359-3"}`)
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusCreated || book.ISBN != "9780441013593" {
+		t.Errorf("Expected the ISBN stored without hyphens, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Emma","author":"Jane Austen","isbn":"978-0-14-143958-8"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), `"field":"isbn"`) {
+		t.Errorf("Expected an isbn field error for a bad check digit, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "PUT", "/api/v1/books/"+strconv.Itoa(int(book.ID)), `{"isbn":"not-an-isbn"}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), `"field":"isbn"`) {
+		t.Errorf("Expected an isbn field error on update, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestValidateBookLanguage(t *testing.T) {
+	for code, want := range map[string]string{"": "", "es": "es", " PT ": "pt", "zh": "zh"} {
+		book := Book{Title: "T", Author: "A", ISBN: "9780441013593", Language: code}
+		if errs := validateBook(&book); len(errs) != 0 || book.Language != want {
+			t.Errorf("Language %q: expected %q, got %q and %v", code, want, book.Language, errs)
+		}
+	}
+	for _, code := range []string{"xx", "spa", "es-MX", "english"} {
+		book := Book{Title: "T", Author: "A", ISBN: "9780441013593", Language: code}
+		if errs := validateBook(&book); len(errs) != 1 || errs[0].Field != "language" {
+			t.Errorf("Language %q: expected a single language error, got %v", code, errs)
+		}
+	}
+}
+
+func TestValidateBookPageCountAndFormat(t *testing.T) {
+	book := Book{Title: "T", Author: "A", ISBN: "9780441013593", PageCount: 412, Format: " Paperback "}
+	if errs := validateBook(&book); len(errs) != 0 || book.Format != "paperback" {
+		t.Errorf("Expected a valid paperback, got %q and %v", book.Format, errs)
+	}
+	book = Book{Title: "T", Author: "A", ISBN: "9780441013593", PageCount: -1, Format: "scroll"}
+	errs := validateBook(&book)
+	if len(errs) != 2 || errs[0].Field != "page_count" || errs[1].Field != "format" {
+		t.Errorf("Expected page_count and format errors, got %v", errs)
+	}
+}
+
+func TestValidateBookPurchasePrice(t *testing.T) {
+	book := Book{Title: "T", Author: "A", ISBN: "9780441013593", PurchasePriceCents: 1999, PurchaseCurrency: " eur "}
+	if errs := validateBook(&book); len(errs) != 0 || book.PurchaseCurrency != "EUR" {
+		t.Errorf("Expected a valid price in EUR, got %q and %v", book.PurchaseCurrency, errs)
+	}
+	for _, b := range []Book{
+		{PurchasePriceCents: 1999},
+		{PurchasePriceCents: 1999, PurchaseCurrency: "XYZ"},
+		{PurchaseCurrency: "euro"},
+	} {
+		b.Title, b.Author, b.ISBN = "T", "A", "9780441013593"
+		if errs := validateBook(&b); len(errs) != 1 || errs[0].Field != "purchase_currency" {
+			t.Errorf("%d %q: expected a single purchase_currency error, got %v", b.PurchasePriceCents, b.PurchaseCurrency, errs)
+		}
+	}
+	book = Book{Title: "T", Author: "A", ISBN: "9780441013593", PurchasePriceCents: -1, PurchaseCurrency: "USD"}
+	if errs := validateBook(&book); len(errs) != 1 || errs[0].Field != "purchase_price_cents" {
+		t.Errorf("Expected a purchase_price_cents error, got %v", errs)
+	}
+}
+
+func TestBookCatalogFields(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	body := `{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","description":"Spice and sand","cover_url":"https://example.com/dune.jpg","page_count":412,"format":"paperback"}`
+	response := webhookRequest(t, router, "POST", "/api/v1/books", body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var book Book
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if book.PageCount != 412 || book.Format != "paperback" || book.Description != "Spice and sand" || book.CoverURL == "" {
+		t.Errorf("Expected the catalog fields back, got %s", response.Body.String())
+	}
+
+	path := "/api/v1/books/" + strconv.Itoa(int(book.ID))
+	if response := webhookRequest(t, router, "PUT", path, `{"format":"audiobook"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %d", response.Code)
+	}
+	req, _ := http.NewRequest("PATCH", path, strings.NewReader(`{"format":"ebook","page_count":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	book = Book{}
+	json.Unmarshal(response.Body.Bytes(), &book)
+	if response.Code != http.StatusOK || book.Format != "ebook" || book.PageCount != 0 {
+		t.Errorf("Expected the patch to apply, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestCreateBookBogusYear(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	book := Book{Title: "Test Book", Author: "Test Author", ISBN: "1234567890999", Year: 99999}
+	jsonData, _ := json.Marshal(book)
+	req, _ := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+
+	var body struct {
+		Fields []FieldError `json:"fields"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if len(body.Fields) != 1 || body.Fields[0].Field != "year" {
+		t.Errorf("Expected a year field error, got %v", body.Fields)
+	}
+
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no book to be stored, found %d", count)
+	}
+}
+
+func TestUpdateBookBogusYear(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	book := Book{Title: "Original", Author: "Author", ISBN: "1234567890998", Year: 2001}
+	db.Create(&book)
+
+	jsonData, _ := json.Marshal(Book{Year: 99999})
+	req, _ := http.NewRequest("PUT", "/api/v1/books/1", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+
+	var stored Book
+	db.First(&stored, 1)
+	if stored.Year != 2001 {
+		t.Errorf("Expected year to remain 2001, got %d", stored.Year)
+	}
+}
+
+func postValidation(t *testing.T, router http.Handler, query, body string) BookValidation {
+	t.Helper()
+	req, _ := http.NewRequest("POST", "/api/v1/books/validate"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var v BookValidation
+	json.Unmarshal(response.Body.Bytes(), &v)
+	return v
+}
+
+func TestValidateBookEndpoint(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+
+	for _, tc := range []struct {
+		query, body string
+		fields      string
+	}{
+		{"", `{"title": "Emma", "author": "Jane Austen", "isbn": "978-0-14-143958-7"}`, ""},
+		{"", `{"isbn": "9780141439587", "year": 99999}`, "title,author,year"},
+		{"", `{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439588"}`, "isbn"},
+		// Either form of a catalogued ISBN is taken, except by the book itself
+		{"", `{"title": "Dune", "author": "Frank Herbert", "isbn": "0441013597"}`, "isbn"},
+		{"?id=1", `{"title": "Dune", "author": "Frank Herbert", "isbn": "0441013597"}`, ""},
+	} {
+		v := postValidation(t, router, tc.query, tc.body)
+		var fields []string
+		for _, f := range v.Fields {
+			fields = append(fields, f.Field)
+		}
+		if got := strings.Join(fields, ","); got != tc.fields || v.Valid != (tc.fields == "") {
+			t.Errorf("%s %s: expected fields %q, got %+v", tc.query, tc.body, tc.fields, v)
+		}
+	}
+
+	var count int64
+	db.Model(&Book{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected nothing saved, found %d books", count)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/books/validate?id=99", strings.NewReader(`{}`))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown book, got %d", response.Code)
+	}
+}
+
+func TestValidateBookRunsHooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+	usePlugin(t, &Plugin{
+		Name: "policy",
+		BeforeCreate: func(tx *gorm.DB, b *Book) error {
+			tx.Create(&Tag{Name: "side-effect"})
+			if b.Year == 0 {
+				return RejectField("year", "is required here")
+			}
+			return nil
+		},
+	})
+
+	v := postValidation(t, router, "", `{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439587"}`)
+	if v.Valid || len(v.Fields) != 1 || v.Fields[0].Field != "year" {
+		t.Errorf("Expected the hook's error, got %+v", v)
+	}
+	if v := postValidation(t, router, "", `{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439587", "year": 1815}`); !v.Valid {
+		t.Errorf("Expected valid, got %+v", v)
+	}
+	var tags int64
+	db.Model(&Tag{}).Count(&tags)
+	if tags != 0 {
+		t.Errorf("Expected the hooks' writes rolled back, found %d tags", tags)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiVersion is one version of the API, served under its own prefix.
+// Versions share the models and the handlers; a version that changes an
+// endpoint in a breaking way registers its own handler for it, and
+// response shaping checks the request's version, so older clients keep
+// the shapes they were built against.
+type apiVersion struct {
+	Name   string
+	Prefix string
+	// Whether errors are always problem+json documents rather than plain
+	// text
+	problems bool
+	// Whether list responses are wrapped in an Envelope
+	envelope bool
+	// Registers the version's own handlers. They are added before the
+	// shared routes, so they take precedence for the same method and path.
+	routes func(api *mux.Router)
+}
+
+var (
+	apiV1 = &apiVersion{Name: "v1", Prefix: apiPrefix}
+	apiV2 = &apiVersion{Name: "v2", Prefix: "/api/v2", problems: true, envelope: true}
+
+	// Every version served, oldest first
+	apiVersions = []*apiVersion{apiV1, apiV2}
+)
+
+type versionKey struct{}
+
+// The API version a request was routed to; v1 outside the versioned
+// prefixes
+func versionFrom(r *http.Request) *apiVersion {
+	if v, ok := r.Context().Value(versionKey{}).(*apiVersion); ok {
+		return v
+	}
+	return apiV1
+}
+
+// A request's path within its version, such as /books/1
+func apiPath(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, versionFrom(r).Prefix)
+}
+
+// Record the version for handlers and middleware, and tell the client
+// which version answered
+func (v *apiVersion) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", v.Name)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, v)))
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIVersions(t *testing.T) {
+	clearDB()
+	book := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&book)
+	router := setupRouter()
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// Both versions serve the shared handlers from the same models
+	for _, v := range apiVersions {
+		response := get(fmt.Sprintf("%s/books/%d", v.Prefix, book.ID), "")
+		var got Book
+		json.Unmarshal(response.Body.Bytes(), &got)
+		if response.Code != http.StatusOK || got.Title != "Dune" {
+			t.Errorf("%s: expected the book, got %d: %s", v.Name, response.Code, response.Body.String())
+		}
+		if response.Header().Get("API-Version") != v.Name {
+			t.Errorf("%s: expected API-Version %s, got %q", v.Name, v.Name, response.Header().Get("API-Version"))
+		}
+	}
+
+	// Links stay within the version the client called
+	response := get(fmt.Sprintf("/api/v2/books/%d", book.ID), halType)
+	if want := fmt.Sprintf(`"self":{"href":"/api/v2/books/%d"}`, book.ID); !strings.Contains(response.Body.String(), want) {
+		t.Errorf("Expected %s in %s", want, response.Body.String())
+	}
+}
+
+func TestAPIVersionOwnHandlers(t *testing.T) {
+	clearDB()
+	saved := apiV2.routes
+	t.Cleanup(func() { apiV2.routes = saved })
+	apiV2.routes = func(api *mux.Router) {
+		api.HandleFunc("/books/count", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]string{"version": versionFrom(r).Name, "path": apiPath(r)})
+		}).Methods("GET")
+	}
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v2/books/count", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if body := strings.TrimSpace(response.Body.String()); body != `{"path":"/books/count","version":"v2"}` {
+		t.Errorf("Expected v2's own handler, got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/books/count", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if body := strings.TrimSpace(response.Body.String()); body != `{"count":0}` {
+		t.Errorf("Expected v1 to keep the shared handler, got %s", body)
+	}
+}
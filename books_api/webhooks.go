@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Outgoing webhooks. Operators register URLs that receive a signed POST
+// for each book change. Like the event outbox, deliveries are written in
+// the same transaction as the change and sent afterwards, retrying with
+// exponential backoff until the receiver accepts them or they run out of
+// attempts.
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// Events a webhook can subscribe to
+var webhookEvents = []string{eventBookCreated, eventBookUpdated, eventBookDeleted}
+
+// Longest wait between two attempts at a delivery
+const webhookMaxBackoff = 6 * time.Hour
+
+// Webhook model, a registered receiver. An empty Events list subscribes
+// to every event.
+type Webhook struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	URL       string    `json:"url" gorm:"not null"`
+	Events    []string  `json:"events" gorm:"serializer:json"`
+	Secret    string    `json:"-" gorm:"not null"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewWebhook is a created webhook. Its signing secret is only shown here.
+type NewWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookRequest is the body of POST and PUT /webhooks. A secret is
+// generated when none is given; on PUT, leaving it out keeps the old one.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+	Active *bool    `json:"active,omitempty"`
+}
+
+// WebhookDelivery model, one event on its way to one webhook
+type WebhookDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	WebhookID     uint       `json:"webhook_id" gorm:"index;not null"`
+	Type          string     `json:"type" gorm:"not null"`
+	Key           string     `json:"key"`
+	Payload       string     `json:"-" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"index;not null"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastStatus    int        `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Whether the webhook wants events of type typ
+func (h *Webhook) subscribes(typ string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue a delivery of an event to every active webhook subscribed to it,
+// within tx
+func recordWebhookDeliveries(tx *gorm.DB, typ, key string, data interface{}) error {
+	var hooks []Webhook
+	if err := tx.Session(&gorm.Session{NewDB: true}).Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return err
+	}
+	var payload []byte
+	for _, h := range hooks {
+		if !h.subscribes(typ) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(data); err != nil {
+				return err
+			}
+		}
+		d := WebhookDelivery{
+			WebhookID: h.ID, Type: typ, Key: key, Payload: string(payload),
+			Status: deliveryPending, NextAttemptAt: time.Now(),
+		}
+		if err := tx.Session(&gorm.Session{NewDB: true}).Create(&d).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait before the attempt after the given number of failures: the base
+// delay, doubling each time
+func webhookBackoff(failures int) time.Duration {
+	d := cfg.WebhookRetryBase
+	for i := 1; i < failures && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, webhookMaxBackoff)
+}
+
+// Value of the X-Books-Signature header: "t=<unix>,v1=<hex>", an
+// HMAC-SHA256 of "<t>.<body>" with the webhook's secret. Receivers check
+// it the same way Stripe's signatures are checked.
+func webhookSignature(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// POST a delivery to its webhook, returning the receiver's status code
+func sendWebhook(ctx context.Context, h *Webhook, d *WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Event{
+		ID:         d.ID,
+		Type:       d.Type,
+		Key:        d.Key,
+		OccurredAt: d.CreatedAt.UTC(),
+		Data:       json.RawMessage(d.Payload),
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "books-api-webhooks")
+	req.Header.Set("X-Books-Event", d.Type)
+	req.Header.Set("X-Books-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set("X-Books-Signature", webhookSignature(h.Secret, time.Now(), body))
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Attempt the deliveries that are due, oldest first. Returns how many were
+// attempted.
+func deliverWebhooks(ctx context.Context, batch int) (int, error) {
+	var due []WebhookDelivery
+	if err := db.Where("status = ? AND next_attempt_at <= ?", deliveryPending, time.Now()).
+		Order("id").Limit(batch).Find(&due).Error; err != nil {
+		return 0, err
+	}
+	hooks := map[uint]*Webhook{}
+	for i := range due {
+		d := &due[i]
+		h, ok := hooks[d.WebhookID]
+		if !ok {
+			h = &Webhook{}
+			if db.First(h, d.WebhookID).Error != nil {
+				h = nil
+			}
+			hooks[d.WebhookID] = h
+		}
+
+		if h == nil || !h.Active {
+			d.Status, d.LastError = deliveryFailed, "Webhook is disabled"
+			db.Save(d)
+			continue
+		}
+		status, err := sendWebhook(ctx, h, d)
+		d.Attempts++
+		d.LastStatus = status
+		switch {
+		case err == nil:
+			now := time.Now()
+			d.Status, d.DeliveredAt, d.LastError = deliveryDelivered, &now, ""
+		case d.Attempts >= cfg.WebhookMaxAttempts:
+			d.Status, d.LastError = deliveryFailed, err.Error()
+			log.Printf("Webhook %d: giving up on delivery %d after %d attempts: %v", h.ID, d.ID, d.Attempts, err)
+		default:
+			d.LastError = err.Error()
+			d.NextAttemptAt = time.Now().Add(webhookBackoff(d.Attempts))
+		}
+		db.Save(d)
+	}
+	return len(due), nil
+}
+
+// Send webhook deliveries until ctx is cancelled, pruning old delivered
+// ones as the outbox relay does
+func runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(cfg.WebhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := deliverWebhooks(ctx, 100)
+			if err != nil {
+				log.Printf("Webhook dispatcher: %v", err)
+				break
+			}
+			if n < 100 {
+				break
+			}
+		}
+
+		if cfg.EventRetention > 0 {
+			db.Where("status = ? AND delivered_at < ?", deliveryDelivered, time.Now().Add(-cfg.EventRetention)).Delete(&WebhookDelivery{})
+		}
+	}
+}
+
+// Check a webhook request, returning every problem found
+func validateWebhook(req *WebhookRequest) []FieldError {
+	var errs []FieldError
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	for _, e := range req.Events {
+		known := false
+		for _, w := range webhookEvents {
+			known = known || e == w
+		}
+		if !known {
+			errs = append(errs, FieldError{Field: "events", Message: fmt.Sprintf("unknown event %q", e)})
+		}
+	}
+	return errs
+}
+
+// A random signing secret
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Register a webhook. The response carries its signing secret, which is
+// not shown again.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	if errs := validateWebhook(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	hook := Webhook{URL: req.URL, Events: req.Events, Secret: req.Secret, Active: req.Active == nil || *req.Active}
+	if hook.Secret == "" {
+		hook.Secret = newWebhookSecret()
+	}
+	if err := db.Create(&hook).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create webhook")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.FormatUint(uint64(hook.ID), 10))
+	writeJSON(w, http.StatusCreated, NewWebhook{Webhook: hook, Secret: hook.Secret})
+}
+
+// List webhooks
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var hooks []Webhook
+	db.Order("id").Find(&hooks)
+	writeList(w, r, hooks)
+}
+
+// Load the webhook named in the URL
+func loadWebhook(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_webhook_id", "Invalid webhook ID")
+		return nil, false
+	}
+	var hook Webhook
+	if err := db.First(&hook, id).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+		return nil, false
+	}
+	return &hook, true
+}
+
+// Get a webhook by ID
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if hook, ok := loadWebhook(w, r); ok {
+		writeJSON(w, http.StatusOK, hook)
+	}
+}
+
+// Replace a webhook's URL, events and active flag, and its secret if one
+// is given
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid JSON")
+		return
+	}
+	if errs := validateWebhook(&req); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	hook.URL, hook.Events, hook.Active = req.URL, req.Events, req.Active == nil || *req.Active
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if err := db.Save(hook).Error; err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// Remove a webhook and its delivery history
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(hook).Error
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// A webhook's latest deliveries, newest first, optionally filtered by
+// ?status=
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	q := db.Where("webhook_id = ?", hook.ID).Order("id DESC").Limit(100)
+	if status := r.URL.Query().Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var deliveries []WebhookDelivery
+	q.Find(&deliveries)
+	writeList(w, r, deliveries)
+}
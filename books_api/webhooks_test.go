@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webhookRequest(t *testing.T, router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestWebhookCRUD(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	response := webhookRequest(t, router, "POST", "/api/v1/webhooks", `{"url":"https://example.com/hook","events":["book.created"]}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created NewWebhook
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.ID == 0 || !created.Active || !strings.HasPrefix(created.Secret, "whsec_") || len(created.Events) != 1 {
+		t.Errorf("Unexpected webhook %+v", created)
+	}
+	path := fmt.Sprintf("/api/v1/webhooks/%d", created.ID)
+	if loc := response.Header().Get("Location"); loc != path {
+		t.Errorf("Expected Location %s, got %s", path, loc)
+	}
+
+	// The secret is only shown on creation
+	response = webhookRequest(t, router, "GET", path, "")
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "secret") {
+		t.Errorf("Expected the webhook without its secret, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "PUT", path, `{"url":"https://example.com/v2","active":false}`)
+	var updated Webhook
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if response.Code != http.StatusOK || updated.URL != "https://example.com/v2" || updated.Active || len(updated.Events) != 0 {
+		t.Errorf("Unexpected update %d: %s", response.Code, response.Body.String())
+	}
+	var stored Webhook
+	db.First(&stored, created.ID)
+	if stored.Secret != created.Secret {
+		t.Error("Expected the secret kept when none is given")
+	}
+
+	for _, body := range []string{`{"url":"ftp://example.com"}`, `{"url":"/relative"}`, `{"url":"https://example.com","events":["book.shelved"]}`} {
+		if response := webhookRequest(t, router, "POST", "/api/v1/webhooks", body); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, response.Code)
+		}
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/webhooks", "")
+	var hooks []Webhook
+	json.Unmarshal(response.Body.Bytes(), &hooks)
+	if len(hooks) != 1 {
+		t.Errorf("Expected one webhook, got %s", response.Body.String())
+	}
+
+	if response := webhookRequest(t, router, "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", path, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+}
+
+func TestWebhooksNeedAdmin(t *testing.T) {
+	clearDB()
+	withAuth(t, stubAuthenticator{
+		"lib:pw":   {Username: "lib", Roles: []string{roleLibrarian}},
+		"admin:pw": {Username: "admin", Roles: []string{roleAdmin}},
+	})
+	router := setupRouter()
+
+	for user, want := range map[string]int{"": http.StatusUnauthorized, "lib": http.StatusForbidden, "admin": http.StatusOK} {
+		req, _ := http.NewRequest("GET", "/api/v1/webhooks", nil)
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+loginAs(t, router, user, "pw"))
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		if response.Code != want {
+			t.Errorf("%q: expected status %d, got %d", user, want, response.Code)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	clearDB()
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var got []received
+	fail := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, received{r.Header, body})
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	all := Webhook{URL: receiver.URL, Secret: "s3cret", Active: true}
+	deletesOnly := Webhook{URL: receiver.URL, Secret: "other", Active: true, Events: []string{eventBookDeleted}}
+	db.Create(&all)
+	db.Create(&deletesOnly)
+	book := Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}
+	db.Create(&book)
+
+	var deliveries []WebhookDelivery
+	db.Find(&deliveries)
+	if len(deliveries) != 1 || deliveries[0].WebhookID != all.ID || deliveries[0].Type != eventBookCreated {
+		t.Fatalf("Expected one delivery to the subscribed webhook, got %+v", deliveries)
+	}
+
+	// A failure is retried after the backoff
+	if n, err := deliverWebhooks(context.Background(), 100); n != 1 || err != nil {
+		t.Fatalf("Expected one attempt, got %d, %v", n, err)
+	}
+	var d WebhookDelivery
+	db.First(&d, deliveries[0].ID)
+	if d.Status != deliveryPending || d.Attempts != 1 || d.LastStatus != http.StatusServiceUnavailable || !d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected a pending delivery scheduled for later, got %+v", d)
+	}
+	if n, _ := deliverWebhooks(context.Background(), 100); n != 0 {
+		t.Error("Expected no attempt before the backoff has passed")
+	}
+
+	fail = false
+	db.Model(&d).Update("next_attempt_at", time.Now())
+	deliverWebhooks(context.Background(), 100)
+	db.First(&d, d.ID)
+	if d.Status != deliveryDelivered || d.Attempts != 2 || d.DeliveredAt == nil || d.LastError != "" {
+		t.Errorf("Expected the delivery to succeed, got %+v", d)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Expected two requests, got %d", len(got))
+	}
+	last := got[1]
+	var event Event
+	json.Unmarshal(last.body, &event)
+	if event.ID != d.ID || event.Type != eventBookCreated || event.Key != fmt.Sprintf("book:%d", book.ID) || !bytes.Contains(event.Data, []byte(`"Dune"`)) {
+		t.Errorf("Unexpected event %s", last.body)
+	}
+	if last.header.Get("X-Books-Event") != eventBookCreated || last.header.Get("X-Books-Delivery") != fmt.Sprint(d.ID) {
+		t.Errorf("Unexpected headers %v", last.header)
+	}
+	sig := last.header.Get("X-Books-Signature")
+	ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+	var unix int64
+	fmt.Sscan(ts, &unix)
+	if sig != webhookSignature("s3cret", time.Unix(unix, 0), last.body) {
+		t.Errorf("Signature %q does not match the body", sig)
+	}
+
+	response := webhookRequest(t, setupRouter(), "GET", fmt.Sprintf("/api/v1/webhooks/%d/deliveries?status=delivered", all.ID), "")
+	var listed []WebhookDelivery
+	json.Unmarshal(response.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != d.ID {
+		t.Errorf("Expected the delivery listed, got %s", response.Body.String())
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	clearDB()
+	old := cfg.WebhookMaxAttempts
+	cfg.WebhookMaxAttempts = 2
+	defer func() { cfg.WebhookMaxAttempts = old }()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	hook := Webhook{URL: receiver.URL, Secret: "s3cret", Active: true}
+	db.Create(&hook)
+	db.Create(&Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"})
+	for i := 0; i < 2; i++ {
+		db.Model(&WebhookDelivery{}).Where("1 = 1").Update("next_attempt_at", time.Now())
+		deliverWebhooks(context.Background(), 100)
+	}
+	var d WebhookDelivery
+	db.First(&d)
+	if d.Status != deliveryFailed || d.Attempts != 2 || !strings.Contains(d.LastError, "500") {
+		t.Errorf("Expected the delivery to fail after two attempts, got %+v", d)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	old := cfg.WebhookRetryBase
+	cfg.WebhookRetryBase = 30 * time.Second
+	defer func() { cfg.WebhookRetryBase = old }()
+
+	for failures, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: webhookMaxBackoff,
+	} {
+		if got := webhookBackoff(failures); got != want {
+			t.Errorf("After %d failures: expected %s, got %s", failures, want, got)
+		}
+	}
+}
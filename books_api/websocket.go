@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Live book updates over WebSocket (RFC 6455). Clients of /ws receive a
+// LiveEvent for every committed change to the books table, so pages can
+// update without polling. Messages from clients are read only to answer
+// pings and closes.
+
+// Appended to a client's key to prove the server speaks WebSocket
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close status codes
+const (
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsCloseTooBig   = 1009
+)
+
+// Largest frame accepted from a client
+const wsMaxClientPayload = 64 << 10
+
+// How often idle connections are pinged, so proxies keep them open and
+// dead clients are noticed
+const wsPingInterval = 30 * time.Second
+
+// Event sent for changes that don't name their books, such as bulk
+// updates: reload whatever is shown
+const eventBooksChanged = "books.changed"
+
+// LiveEvent is a message sent to WebSocket clients. Book is the book as
+// committed, left out for deletes.
+type LiveEvent struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id,omitempty"`
+	Book *Book  `json:"book,omitempty"`
+}
+
+// liveHub is the set of connected clients
+type liveHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]bool
+}
+
+var liveClients = &liveHub{clients: map[*wsClient]bool{}}
+
+func (h *liveHub) add(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = true
+}
+
+func (h *liveHub) remove(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+func (h *liveHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) > 0
+}
+
+// Queue an event for the clients of a library, or for every client when
+// it isn't scoped to one. A client too slow to keep up is disconnected
+// rather than holding up writes; it can reload and reconnect.
+func (h *liveHub) broadcast(e LiveEvent, library uint, scoped bool) {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if scoped && c.library != library {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			delete(h.clients, c)
+			c.conn.Close()
+		}
+	}
+}
+
+// Push committed book writes to live clients. Like the cache callbacks,
+// these run once the write's transaction has committed.
+func registerLiveCallbacks(db *gorm.DB) {
+	push := func(typ string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "books" || !liveClients.active() {
+				return
+			}
+			library, scoped := libraryFrom(tx.Statement.Context)
+			ids := statementIDs(tx)
+			if ids == nil {
+				liveClients.broadcast(LiveEvent{Type: eventBooksChanged}, library, scoped)
+				return
+			}
+			for _, id := range ids {
+				e := LiveEvent{Type: typ, ID: id}
+				if typ != eventBookDeleted {
+					// The statement may hold only the columns it changed
+					var book Book
+					if err := db.Preload("Tags").First(&book, id).Error; err != nil {
+						continue
+					}
+					e.Book = &book
+					liveClients.broadcast(e, book.LibraryID, true)
+					continue
+				}
+				liveClients.broadcast(e, library, scoped)
+			}
+		}
+	}
+
+	const after = "gorm:commit_or_rollback_transaction"
+	db.Callback().Create().After(after).Register("live:create", push(eventBookCreated))
+	db.Callback().Update().After(after).Register("live:update", push(eventBookUpdated))
+	db.Callback().Delete().After(after).Register("live:delete", push(eventBookDeleted))
+}
+
+// wsClient is one connected WebSocket
+type wsClient struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	send chan []byte
+	// The library whose books' events the client receives
+	library uint
+	// Serializes frames from the writer and the reader's replies
+	writeMu sync.Mutex
+}
+
+// The Sec-WebSocket-Accept for a handshake's Sec-WebSocket-Key
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Whether a comma-separated header lists token, ignoring case
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade to a WebSocket and stream live book events until the client
+// goes away
+func serveLiveUpdates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, r, http.StatusUpgradeRequired, "websocket_required", "This endpoint only accepts WebSocket connections")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, r, http.StatusBadRequest, "invalid_handshake", "Unsupported WebSocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		writeError(w, r, http.StatusBadRequest, "invalid_handshake", "Invalid Sec-WebSocket-Key")
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Connection cannot be upgraded")
+		return
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	library, _ := libraryFrom(r.Context())
+	c := &wsClient{conn: conn, rw: rw, send: make(chan []byte, 64), library: library}
+	liveClients.add(c)
+	done := make(chan struct{})
+	go c.writeLoop(done)
+	c.readLoop()
+	liveClients.remove(c)
+	close(done)
+	conn.Close()
+}
+
+// Send queued events, and a ping when there have been none for a while
+func (c *wsClient) writeLoop(done chan struct{}) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case msg := <-c.send:
+			err = c.writeFrame(wsText, msg)
+		case <-ping.C:
+			err = c.writeFrame(wsPing, nil)
+		case <-done:
+			return
+		}
+		if err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// Read frames until the connection closes, answering pings and closes.
+// Data frames are discarded.
+func (c *wsClient) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		switch {
+		case errors.Is(err, errWSTooBig):
+			c.close(wsCloseTooBig)
+			return
+		case errors.Is(err, errWSProtocol):
+			c.close(wsCloseProtocol)
+			return
+		case err != nil:
+			return
+		}
+		switch opcode {
+		case wsPing:
+			if c.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code)
+			return
+		}
+	}
+}
+
+var (
+	errWSProtocol = errors.New("websocket protocol error")
+	errWSTooBig   = errors.New("websocket frame too large")
+)
+
+// Read one frame from the client, unmasking its payload. Clients must
+// mask every frame.
+func (c *wsClient) readFrame() (int, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	size := uint64(head[1] & 0x7f)
+	if head[0]&0x70 != 0 || !masked {
+		return 0, nil, errWSProtocol
+	}
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+	case wsClose, wsPing, wsPong:
+		if size > 125 || head[0]&0x80 == 0 {
+			return 0, nil, errWSProtocol
+		}
+	default:
+		return 0, nil, errWSProtocol
+	}
+
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > wsMaxClientPayload {
+		return 0, nil, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Write one unfragmented, unmasked frame, as servers send them
+func (c *wsClient) writeFrame(opcode int, payload []byte) error {
+	frame := []byte{0x80 | byte(opcode)}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.rw.Write(frame)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// Send a close frame with code. The caller then closes the connection.
+func (c *wsClient) close(code int) {
+	c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, uint16(code)))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Open a WebSocket to the server's live updates, returning the connection
+// and a reader past the handshake
+func dialLiveUpdates(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /api/v1/ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept key for the RFC 6455 example nonce
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	// Wait for the server to register the client
+	for !liveClients.active() {
+		time.Sleep(time.Millisecond)
+	}
+	return conn, br
+}
+
+// Write a masked client frame
+func writeClientFrame(conn net.Conn, opcode byte, payload []byte) {
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	conn.Write(frame)
+}
+
+// Read an unmasked server frame
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func readLiveEvent(t *testing.T, br *bufio.Reader) LiveEvent {
+	t.Helper()
+	opcode, payload := readServerFrame(t, br)
+	var e LiveEvent
+	if opcode != wsText || json.Unmarshal(payload, &e) != nil {
+		t.Fatalf("Expected an event, got opcode %d: %q", opcode, payload)
+	}
+	return e
+}
+
+func TestLiveUpdates(t *testing.T) {
+	clearDB()
+	server := httptest.NewServer(setupRouter())
+	defer server.Close()
+	conn, br := dialLiveUpdates(t, server)
+
+	resp, err := http.Post(server.URL+"/api/v1/books", "application/json",
+		strings.NewReader(`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","year":1965}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Create failed: %v", err)
+	}
+	var book Book
+	json.NewDecoder(resp.Body).Decode(&book)
+	resp.Body.Close()
+	if e := readLiveEvent(t, br); e.Type != eventBookCreated || e.ID != book.ID || e.Book == nil || e.Book.Title != "Dune" {
+		t.Errorf("Unexpected create event %+v", e)
+	}
+
+	db.Model(&book).Update("year", 1966)
+	if e := readLiveEvent(t, br); e.Type != eventBookUpdated || e.Book == nil || e.Book.Year != 1966 || e.Book.Author != "Frank Herbert" {
+		t.Errorf("Expected the whole updated book, got %+v", e)
+	}
+	db.Model(&Book{}).Where("year < ?", 2000).Update("description", "Classic")
+	if e := readLiveEvent(t, br); e.Type != eventBooksChanged || e.ID != 0 {
+		t.Errorf("Expected a change without a book, got %+v", e)
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/v1/books/1", nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if e := readLiveEvent(t, br); e.Type != eventBookDeleted || e.ID != book.ID || e.Book != nil {
+		t.Errorf("Unexpected delete event %+v", e)
+	}
+
+	writeClientFrame(conn, wsPing, []byte("hi"))
+	if opcode, payload := readServerFrame(t, br); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("Expected the ping answered, got opcode %d: %q", opcode, payload)
+	}
+	writeClientFrame(conn, wsClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	if opcode, payload := readServerFrame(t, br); opcode != wsClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("Expected the close echoed, got opcode %d: %q", opcode, payload)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection closed, got %v", err)
+	}
+}
+
+func TestLiveUpdatesNeedWebSocket(t *testing.T) {
+	router := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/ws", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusUpgradeRequired || response.Header().Get("Upgrade") != "websocket" {
+		t.Errorf("Expected 426 with Upgrade, got %d %v", response.Code, response.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	if response.Code != http.StatusBadRequest || response.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("Expected 400 for an old version, got %d", response.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Work is what editions are editions of: the book as written, which the
+// catalog may hold as a paperback, a hardcover and an ebook. Each book in
+// the catalog is one edition and links to its work by work_id. Writing a
+// book without a work_id puts it with the work of the same title and
+// author, creating the work the first time; a work_id moves it to that
+// work instead, for editions published under another title.
+type Work struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Title        string    `json:"title" gorm:"not null;uniqueIndex:idx_works_title_author"`
+	Author       string    `json:"author" gorm:"not null;uniqueIndex:idx_works_title_author"`
+	EditionCount int64     `json:"edition_count" gorm:"->;-:migration"`
+	Editions     []Book    `json:"editions,omitempty" gorm:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Works with the number of editions outside the trash
+func worksWithCounts(ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Model(&Work{}).
+		Select("works.*, COUNT(books.id) AS edition_count").
+		Scopes(joinBooks("books.work_id = works.id AND books.deleted_at IS NULL")).
+		Group("works.id")
+}
+
+// Link a book to its work, refusing a work_id that doesn't exist. A work
+// the book leaves is removed if it has no other editions. Runs in the
+// book's write transaction.
+func resolveBookWork(tx *gorm.DB, b *Book) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	var before Book
+	if b.ID != 0 {
+		if err := tx.Unscoped().Select("work_id").Limit(1).Find(&before, b.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	if b.WorkID != nil {
+		var count int64
+		if err := tx.Model(&Work{}).Where("id = ?", *b.WorkID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return RejectField("work_id", "is not a known work")
+		}
+	} else if b.Title == "" {
+		// Not a whole book, such as a write of one column
+		return nil
+	} else {
+		var work Work
+		if err := tx.Where(Work{Title: b.Title, Author: b.Author}).FirstOrCreate(&work).Error; err != nil {
+			return err
+		}
+		b.WorkID = &work.ID
+	}
+	if before.WorkID != nil && *b.WorkID != *before.WorkID {
+		return pruneWork(tx, *before.WorkID, b.ID)
+	}
+	return nil
+}
+
+// Remove a work once no book but bookID is an edition of it, live or in
+// the trash
+func pruneWork(tx *gorm.DB, workID, bookID uint) error {
+	var books int64
+	if err := tx.Unscoped().Model(&Book{}).Where("work_id = ? AND id <> ?", workID, bookID).Count(&books).Error; err != nil {
+		return err
+	}
+	if books > 0 {
+		return nil
+	}
+	return tx.Delete(&Work{}, workID).Error
+}
+
+// Create works for the books from before editions were grouped, one per
+// title and author. Does nothing once every book has a work.
+func backfillWorks() {
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO works (title, author, created_at, updated_at)
+			SELECT DISTINCT title, author, ?, ? FROM books
+			WHERE work_id IS NULL AND NOT EXISTS (SELECT 1 FROM works WHERE works.title = books.title AND works.author = books.author)`, now, now).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE books SET work_id = (SELECT id FROM works WHERE works.title = books.title AND works.author = books.author)
+			WHERE work_id IS NULL`).Error
+	})
+	if err != nil {
+		log.Fatal("Failed to backfill works:", err)
+	}
+}
+
+// List the works with editions in the catalog, by title, so the catalog
+// can show one entry per work
+func getWorks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var works []Work
+	err := worksWithCounts(r.Context()).Having("COUNT(books.id) > 0").Order("works.title, works.author, works.id").Scan(&works).Error
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list works")
+		return
+	}
+	writeList(w, r, works)
+}
+
+// A work's editions, oldest first
+func workEditions(ctx context.Context, workID uint) ([]Book, error) {
+	var books []Book
+	err := db.WithContext(ctx).Preload("Tags").Where("work_id = ?", workID).Order("year, id").Find(&books).Error
+	return books, err
+}
+
+// Get a work with its editions
+func getWork(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_work_id", "Invalid work ID")
+		return
+	}
+	var work Work
+	if err := worksWithCounts(r.Context()).Where("works.id = ?", id).Take(&work).Error; err != nil {
+		writeError(w, r, http.StatusNotFound, "work_not_found", "Work not found")
+		return
+	}
+	if work.Editions, err = workEditions(r.Context(), work.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load editions")
+		return
+	}
+	writeJSON(w, http.StatusOK, work)
+}
+
+// List a book's editions, itself included, oldest first
+func getBookEditions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	book, ok := loadBook(w, r)
+	if !ok {
+		return
+	}
+	if book.WorkID == nil {
+		writeList(w, r, []Book{*book})
+		return
+	}
+	editions, err := workEditions(r.Context(), *book.WorkID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load editions")
+		return
+	}
+	writeList(w, r, editions)
+}
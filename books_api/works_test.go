@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEditionsShareAWork(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	var created []Book
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","isbn":"9780441013593","year":1990}`,
+		`{"title":"Dune","author":"Frank Herbert","isbn":"9780593099322","year":2019}`,
+		`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","year":2003}`,
+	} {
+		response := webhookRequest(t, router, "POST", "/api/v1/books", body)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+		}
+		var book Book
+		json.Unmarshal(response.Body.Bytes(), &book)
+		created = append(created, book)
+	}
+	dune, emma := created[0].WorkID, created[2].WorkID
+	if dune == nil || created[1].WorkID == nil || *created[1].WorkID != *dune || *emma == *dune {
+		t.Fatalf("Expected the Dunes to share a work, got %v, %v and %v", dune, created[1].WorkID, emma)
+	}
+
+	// An edition under another title joins the work by ID
+	body := fmt.Sprintf(`{"title":"Dune: Deluxe Edition","author":"Frank Herbert","isbn":"9780593201732","year":2019,"work_id":%d}`, *dune)
+	response := webhookRequest(t, router, "POST", "/api/v1/books", body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", response.Code, response.Body.String())
+	}
+	response = webhookRequest(t, router, "POST", "/api/v1/books", `{"title":"Persuasion","author":"Jane Austen","isbn":"9780141439686","work_id":999}`)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "work_id") {
+		t.Errorf("Expected status 400 for an unknown work, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/books/%d/editions", created[1].ID), "")
+	var editions []Book
+	json.Unmarshal(response.Body.Bytes(), &editions)
+	if len(editions) != 3 || editions[0].ID != created[0].ID || editions[2].Title != "Dune: Deluxe Edition" {
+		t.Errorf("Expected the three Dunes oldest first, got %s", response.Body.String())
+	}
+
+	response = webhookRequest(t, router, "GET", "/api/v1/works", "")
+	var works []Work
+	json.Unmarshal(response.Body.Bytes(), &works)
+	if len(works) != 2 || works[0].Title != "Dune" || works[0].EditionCount != 3 || works[1].EditionCount != 1 {
+		t.Errorf("Expected Dune and Emma, got %s", response.Body.String())
+	}
+	response = webhookRequest(t, router, "GET", fmt.Sprintf("/api/v1/works/%d", *dune), "")
+	var work Work
+	json.Unmarshal(response.Body.Bytes(), &work)
+	if response.Code != http.StatusOK || len(work.Editions) != 3 {
+		t.Errorf("Expected the work with its editions, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/works/999", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if response := webhookRequest(t, router, "GET", "/api/v1/works/abc", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+
+	// Moving Emma's only edition removes her work
+	body = fmt.Sprintf(`{"title":"Emma","author":"Jane Austen","isbn":"9780141439587","work_id":%d}`, *dune)
+	if response := webhookRequest(t, router, "PUT", fmt.Sprintf("/api/v1/books/%d", created[2].ID), body); response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var count int64
+	db.Model(&Work{}).Where("id = ?", *emma).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected Emma's work removed")
+	}
+}
+
+func TestBackfillWorks(t *testing.T) {
+	clearDB()
+	for _, b := range []Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"},
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780593099322"},
+	} {
+		db.Create(&b)
+	}
+	db.Exec("UPDATE books SET work_id = NULL")
+	db.Exec("DELETE FROM works")
+
+	backfillWorks()
+	var books []Book
+	db.Order("id").Find(&books)
+	if len(books) != 2 || books[0].WorkID == nil || books[1].WorkID == nil || *books[0].WorkID != *books[1].WorkID {
+		t.Errorf("Expected both editions in one work, got %+v", books)
+	}
+}
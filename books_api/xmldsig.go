@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// XML Signature algorithm identifiers
+const (
+	dsigNS           = "http://www.w3.org/2000/09/xmldsig#"
+	dsigExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	dsigEnveloped    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	dsigRSASHA1      = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	dsigRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	dsigDigestSHA1   = "http://www.w3.org/2000/09/xmldsig#sha1"
+	dsigDigestSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	xmlPrefixNS      = "http://www.w3.org/XML/1998/namespace"
+)
+
+// xmlNode is an element parsed with its prefixes as written, which
+// canonicalization needs and encoding/xml's Unmarshal discards
+type xmlNode struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr        // Name.Space holds the prefix
+	ns       map[string]string // namespaces declared here, by prefix
+	children []interface{}     // *xmlNode or string
+	parent   *xmlNode
+}
+
+// Parse a document into a tree. DTDs are refused, which also rules out
+// entity expansion attacks.
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *xmlNode
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{prefix: t.Name.Space, local: t.Name.Local, ns: map[string]string{}, parent: cur}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					n.ns[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					n.ns[""] = a.Value
+				default:
+					n.attrs = append(n.attrs, a)
+				}
+			}
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("xml: more than one root element")
+				}
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil {
+				return nil, errors.New("xml: unbalanced end element")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("xml: DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("xml: incomplete document")
+	}
+	return root, nil
+}
+
+// Namespace URI bound to prefix at this element
+func (n *xmlNode) lookupNS(prefix string) string {
+	if prefix == "xml" {
+		return xmlPrefixNS
+	}
+	for e := n; e != nil; e = e.parent {
+		if uri, ok := e.ns[prefix]; ok {
+			return uri
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) space() string {
+	return n.lookupNS(n.prefix)
+}
+
+func (n *xmlNode) is(space, local string) bool {
+	return n.local == local && n.space() == space
+}
+
+// Value of an unqualified attribute
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Child elements with the given name
+func (n *xmlNode) elements(space, local string) []*xmlNode {
+	var out []*xmlNode
+	for _, c := range n.children {
+		if e, ok := c.(*xmlNode); ok && e.is(space, local) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// The only child element with the given name, or nil
+func (n *xmlNode) element(space, local string) *xmlNode {
+	if list := n.elements(space, local); len(list) == 1 {
+		return list[0]
+	}
+	return nil
+}
+
+func (n *xmlNode) text() string {
+	var b strings.Builder
+	for _, c := range n.children {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// Exclusive XML canonicalization (without comments) of n, leaving out the
+// skip subtree. Prefixes in inclusive are rendered whenever they are in
+// scope, as the InclusiveNamespaces PrefixList asks.
+func canonicalXML(n, skip *xmlNode, inclusive []string) []byte {
+	var b bytes.Buffer
+	writeCanonical(&b, n, skip, map[string]string{}, inclusive)
+	return b.Bytes()
+}
+
+func writeCanonical(b *bytes.Buffer, n, skip *xmlNode, rendered map[string]string, inclusive []string) {
+	// Namespaces this element visibly uses
+	used := map[string]bool{n.prefix: true}
+	for _, a := range n.attrs {
+		if a.Name.Space != "" {
+			used[a.Name.Space] = true
+		}
+	}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		if p == "" || n.lookupNS(p) != "" {
+			used[p] = true
+		}
+	}
+
+	var prefixes []string
+	scope := rendered
+	for p := range used {
+		if p == "xml" {
+			continue
+		}
+		uri := n.lookupNS(p)
+		prev, ok := rendered[p]
+		if (ok && prev == uri) || (!ok && uri == "") {
+			continue
+		}
+		if len(prefixes) == 0 {
+			scope = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				scope[k] = v
+			}
+		}
+		prefixes = append(prefixes, p)
+		scope[p] = uri
+	}
+	sort.Strings(prefixes)
+
+	attrs := append([]xml.Attr(nil), n.attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		si, sj := n.attrNS(attrs[i]), n.attrNS(attrs[j])
+		if si != sj {
+			return si < sj
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	name := qualifiedName(n.prefix, n.local)
+	b.WriteString("<" + name)
+	for _, p := range prefixes {
+		if p == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + p + `="`)
+		}
+		b.WriteString(escapeC14NAttr(scope[p]) + `"`)
+	}
+	for _, a := range attrs {
+		b.WriteString(" " + qualifiedName(a.Name.Space, a.Name.Local) + `="` + escapeC14NAttr(a.Value) + `"`)
+	}
+	b.WriteString(">")
+	for _, c := range n.children {
+		switch c := c.(type) {
+		case string:
+			b.WriteString(escapeC14NText(c))
+		case *xmlNode:
+			if c != skip {
+				writeCanonical(b, c, skip, scope, inclusive)
+			}
+		}
+	}
+	b.WriteString("</" + name + ">")
+}
+
+func (n *xmlNode) attrNS(a xml.Attr) string {
+	if a.Name.Space == "" {
+		return ""
+	}
+	return n.lookupNS(a.Name.Space)
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+var c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+func escapeC14NAttr(s string) string { return c14nAttrEscaper.Replace(s) }
+func escapeC14NText(s string) string { return c14nTextEscaper.Replace(s) }
+
+// Verify the enveloped signature on el against any of certs, returning
+// el's canonical form. Callers should read signed data from that form
+// rather than the original document, so nothing outside the signature
+// can be slipped in.
+func verifyEnvelopedSignature(el *xmlNode, certs []*x509.Certificate) ([]byte, error) {
+	sig := el.element(dsigNS, "Signature")
+	if sig == nil {
+		return nil, errors.New("missing signature")
+	}
+	signedInfo := sig.element(dsigNS, "SignedInfo")
+	if signedInfo == nil {
+		return nil, errors.New("missing SignedInfo")
+	}
+	c14n := signedInfo.element(dsigNS, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != dsigExcC14N {
+		return nil, errors.New("unsupported canonicalization method")
+	}
+
+	refs := signedInfo.elements(dsigNS, "Reference")
+	if len(refs) != 1 {
+		return nil, errors.New("expected exactly one signature reference")
+	}
+	ref := refs[0]
+	if id := el.attr("ID"); id == "" || ref.attr("URI") != "#"+id {
+		return nil, errors.New("signature does not reference the signed element")
+	}
+	var inclusive []string
+	if transforms := ref.element(dsigNS, "Transforms"); transforms != nil {
+		for _, t := range transforms.elements(dsigNS, "Transform") {
+			switch t.attr("Algorithm") {
+			case dsigEnveloped:
+			case dsigExcC14N:
+				inclusive = inclusivePrefixes(t)
+			default:
+				return nil, fmt.Errorf("unsupported transform %s", t.attr("Algorithm"))
+			}
+		}
+	}
+
+	digestMethod := ref.element(dsigNS, "DigestMethod")
+	digestValue := ref.element(dsigNS, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return nil, errors.New("missing digest")
+	}
+	canonical := canonicalXML(el, sig, inclusive)
+	digest, err := xmlDigest(digestMethod.attr("Algorithm"), canonical)
+	if err != nil {
+		return nil, err
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(digestValue.text()), ""))
+	if err != nil || !bytes.Equal(digest, want) {
+		return nil, errors.New("digest mismatch")
+	}
+
+	method := signedInfo.element(dsigNS, "SignatureMethod")
+	value := sig.element(dsigNS, "SignatureValue")
+	if method == nil || value == nil {
+		return nil, errors.New("missing signature value")
+	}
+	var hash crypto.Hash
+	switch method.attr("Algorithm") {
+	case dsigRSASHA256:
+		hash = crypto.SHA256
+	case dsigRSASHA1:
+		return nil, errors.New("SHA-1 signatures are not accepted")
+	default:
+		return nil, fmt.Errorf("unsupported signature method %s", method.attr("Algorithm"))
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value.text()), ""))
+	if err != nil {
+		return nil, errors.New("invalid signature value")
+	}
+	h := hash.New()
+	h.Write(canonicalXML(signedInfo, nil, inclusivePrefixes(c14n)))
+	sum := h.Sum(nil)
+	for _, cert := range certs {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(key, hash, sum, signature) == nil {
+			return canonical, nil
+		}
+	}
+	return nil, errors.New("signature verification failed")
+}
+
+// PrefixList of a transform's InclusiveNamespaces
+func inclusivePrefixes(transform *xmlNode) []string {
+	if in := transform.element(dsigExcC14N, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attr("PrefixList"))
+	}
+	return nil
+}
+
+func xmlDigest(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case dsigDigestSHA256:
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	case dsigDigestSHA1:
+		// Collisions are practical, so a SHA-1 digest proves nothing
+		return nil, errors.New("SHA-1 digests are not accepted")
+	}
+	return nil, fmt.Errorf("unsupported digest method %s", algorithm)
+}
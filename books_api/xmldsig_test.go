@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestCanonicalXML(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<p:Root xmlns:p="urn:p" xmlns:q="urn:q" xmlns:xs="urn:xs" xmlns="urn:default">
+  <p:Signed ID="s1" z="1" q:a="2" b="&quot;&#9;">
+    <Plain>a &amp; b &gt; c</Plain><q:Empty/><!-- dropped -->
+    <p:Sig>skipped</p:Sig>
+    <Reset xmlns=""><Inner/></Reset>
+  </p:Signed>
+</p:Root>`
+	root, err := parseXMLTree([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := root.elements("urn:p", "Signed")[0]
+	skip := signed.element("urn:p", "Sig")
+
+	tests := []struct {
+		name      string
+		inclusive []string
+		want      string
+	}{
+		{"exclusive", nil, `<p:Signed xmlns:p="urn:p" xmlns:q="urn:q" ID="s1" b="&quot;&#x9;" z="1" q:a="2">
+    <Plain xmlns="urn:default">a &amp; b &gt; c</Plain><q:Empty></q:Empty>
+    
+    <Reset><Inner></Inner></Reset>
+  </p:Signed>`},
+		{"inclusive prefix", []string{"xs"}, `<p:Signed xmlns:p="urn:p" xmlns:q="urn:q" xmlns:xs="urn:xs" ID="s1" b="&quot;&#x9;" z="1" q:a="2">
+    <Plain xmlns="urn:default">a &amp; b &gt; c</Plain><q:Empty></q:Empty>
+    
+    <Reset><Inner></Inner></Reset>
+  </p:Signed>`},
+	}
+	for _, tt := range tests {
+		if got := string(canonicalXML(signed, skip, tt.inclusive)); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseXMLTreeRejectsDTD(t *testing.T) {
+	if _, err := parseXMLTree([]byte(`<!DOCTYPE a [<!ENTITY e "x">]><a>&e;</a>`)); err == nil {
+		t.Error("Expected documents with a DTD to be refused")
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// YAML support for the book endpoints, written against the standard
+// library. Responses are block-style YAML with the same shape as the
+// JSON. Request bodies may use block mappings and sequences, plain and
+// quoted scalars, literal and folded block scalars, and single-line flow
+// collections of scalars; anchors, aliases and tags are refused.
+
+const yamlType = "application/yaml"
+
+// Media types clients use for YAML
+func isYAMLType(mediaType string) bool {
+	switch mediaType {
+	case yamlType, "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// orderedMember is an object member, in document order
+type orderedMember struct {
+	key   string
+	value interface{}
+}
+
+// Decode JSON keeping object members in order, as []orderedMember
+func decodeOrderedJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	var members []orderedMember
+	items := []interface{}{}
+	for dec.More() {
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, orderedMember{key.(string), value})
+			continue
+		}
+		value, err := decodeOrderedJSON(dec)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if delim == '{' {
+		if members == nil {
+			members = []orderedMember{}
+		}
+		return members, nil
+	}
+	return items, nil
+}
+
+// Transcode a JSON document to YAML
+func encodeYAML(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeOrderedJSON(dec)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if inline, ok := yamlInline(v); ok {
+		b.WriteString(inline + "\n")
+	} else {
+		writeYAMLBlock(&b, v, 0)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// A scalar or empty collection as it is written on one line
+func yamlInline(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case []orderedMember:
+		return "{}", len(x) == 0
+	case []interface{}:
+		return "[]", len(x) == 0
+	case nil:
+		return "null", true
+	case bool:
+		return strconv.FormatBool(x), true
+	case json.Number:
+		return x.String(), true
+	case string:
+		return yamlString(x), true
+	}
+	return fmt.Sprint(v), true
+}
+
+func writeYAMLBlock(b *strings.Builder, v interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch x := v.(type) {
+	case []orderedMember:
+		for _, m := range x {
+			b.WriteString(pad + yamlString(m.key) + ":")
+			if inline, ok := yamlInline(m.value); ok {
+				b.WriteString(" " + inline + "\n")
+				continue
+			}
+			b.WriteString("\n")
+			writeYAMLBlock(b, m.value, indent+2)
+		}
+	case []interface{}:
+		for _, item := range x {
+			if inline, ok := yamlInline(item); ok {
+				b.WriteString(pad + "- " + inline + "\n")
+				continue
+			}
+			// The item's block, with its first line moved up beside the dash
+			var child strings.Builder
+			writeYAMLBlock(&child, item, indent+2)
+			b.WriteString(pad + "- " + child.String()[indent+2:])
+		}
+	}
+}
+
+// Words YAML 1.1 readers take as booleans or null
+var yamlReserved = map[string]bool{
+	"null": true, "~": true, "true": true, "false": true,
+	"yes": true, "no": true, "on": true, "off": true, "y": true, "n": true,
+}
+
+// A string as a YAML scalar: plain when it can only read as that string,
+// double-quoted otherwise
+func yamlString(s string) string {
+	needsQuotes := s == "" || yamlReserved[strings.ToLower(s)] ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`.+0123456789 ") ||
+		strings.HasSuffix(s, " ") || strings.HasSuffix(s, ":") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #")
+	for _, c := range s {
+		needsQuotes = needsQuotes || c < ' ' || c == 0x7f
+	}
+	if !needsQuotes {
+		return s
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Decode a YAML body into v
+func decodeYAML(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	root, err := parseYAML(string(data))
+	if err != nil {
+		return err
+	}
+	return decodeDoc(root, v)
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+// Parse a single YAML document
+func parseYAML(src string) (*docNode, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")}
+	for p.pos < len(p.lines) {
+		line := strings.TrimSpace(p.lines[p.pos])
+		if line == "---" || strings.HasPrefix(line, "%") || line == "" || strings.HasPrefix(line, "#") {
+			p.pos++
+			continue
+		}
+		break
+	}
+	root, err := p.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, text, ok := p.peek(); ok && text != "..." {
+		return nil, p.errorf("unexpected content")
+	}
+	return root, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// The next line holding content, as its indent and its text without the
+// comment, skipping blank and comment lines
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		text := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(text)
+		if strings.HasPrefix(text, "\t") && strings.TrimSpace(text) != "" {
+			return indent, "\t", true
+		}
+		if text = stripYAMLComment(text); text != "" {
+			return indent, text, true
+		}
+	}
+	return 0, "", false
+}
+
+// Cut a trailing comment: # at the start or after a space, outside
+// quoted scalars. Quotes only open a scalar at the start of a token, so
+// apostrophes in plain text don't count.
+func stripYAMLComment(s string) string {
+	var quote rune
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" [{,", rune(s[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return strings.TrimRight(s, " \t")
+}
+
+func isYAMLSeqEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// The index of the ": " (or final ":") separating a mapping key from its
+// value, outside quotes, or -1
+func yamlKeyEnd(text string) int {
+	var quote rune
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse the node starting at the next line, if it is indented at least
+// minIndent; otherwise the value is empty, which YAML reads as null
+func (p *yamlParser) parseBlock(minIndent int) (*docNode, error) {
+	indent, text, ok := p.peek()
+	if !ok || indent < minIndent {
+		return &docNode{kind: docScalar, plain: true}, nil
+	}
+	if text == "\t" {
+		return nil, p.errorf("tabs are not allowed for indentation")
+	}
+	switch {
+	case isYAMLSeqEntry(text):
+		return p.parseSequence(indent)
+	case yamlKeyEnd(text) >= 0:
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return parseYAMLScalar(text)
+}
+
+func (p *yamlParser) parseSequence(indent int) (*docNode, error) {
+	node := &docNode{kind: docList}
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent || (ind == indent && !isYAMLSeqEntry(text)) {
+			return node, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("expected a sequence entry")
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(text, "-"), " ")
+		var item *docNode
+		var err error
+		if rest == "" {
+			p.pos++
+			item, err = p.parseBlock(indent + 1)
+		} else {
+			// Parse what follows the dash as if it started its own line,
+			// so "- title: Dune" opens a mapping at that column
+			col := ind + len(text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", col) + rest
+			item, err = p.parseBlock(col)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (*docNode, error) {
+	node := &docNode{kind: docMapping}
+	seen := map[string]bool{}
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent {
+			return node, nil
+		}
+		if ind > indent || isYAMLSeqEntry(text) {
+			return nil, p.errorf("bad indentation")
+		}
+		end := yamlKeyEnd(text)
+		if end < 0 {
+			return nil, p.errorf("expected a mapping key")
+		}
+		keyNode, err := parseYAMLScalar(strings.TrimSpace(text[:end]))
+		if err != nil || keyNode.kind != docScalar {
+			return nil, p.errorf("invalid mapping key")
+		}
+		key := keyNode.text
+		if seen[key] {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+		rest := strings.TrimSpace(text[end+1:])
+		p.pos++
+
+		var value *docNode
+		switch {
+		case rest == "":
+			// A sequence may sit at the key's own indent
+			if ind2, text2, ok := p.peek(); ok && ind2 == indent && isYAMLSeqEntry(text2) {
+				value, err = p.parseSequence(indent)
+			} else {
+				value, err = p.parseBlock(indent + 1)
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.parseBlockScalar(indent, rest)
+		default:
+			value, err = parseYAMLScalar(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.keys = append(node.keys, key)
+		node.children = append(node.children, value)
+	}
+}
+
+// Read a | (literal) or > (folded) block scalar, with an optional - or +
+// chomping indicator
+func (p *yamlParser) parseBlockScalar(parentIndent int, header string) (*docNode, error) {
+	style, chomp := header[0], strings.TrimSpace(header[1:])
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, p.errorf("unsupported block scalar header %q", header)
+	}
+	var lines []string
+	indent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		ind := len(raw) - len(trimmed)
+		if indent < 0 {
+			indent = ind
+		}
+		if ind <= parentIndent || ind < indent {
+			break
+		}
+		lines = append(lines, raw[indent:])
+	}
+
+	// Trailing blank lines belong to the chomping, not the content
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	var text string
+	if style == '|' {
+		text = strings.Join(lines[:content], "\n")
+	} else {
+		var b strings.Builder
+		for i, line := range lines[:content] {
+			// Lines fold into spaces; a blank line is a line break
+			switch {
+			case i == 0 || lines[i-1] == "":
+			case line == "":
+				b.WriteString("\n")
+			default:
+				b.WriteString(" ")
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	}
+	switch chomp {
+	case "":
+		if content > 0 {
+			text += "\n"
+		}
+	case "+":
+		text += strings.Repeat("\n", len(lines)-content+1)
+	}
+	return &docNode{kind: docScalar, text: text}, nil
+}
+
+var errYAMLUnsupported = errors.New("yaml: anchors, aliases and tags are not supported")
+
+// Parse a scalar or single-line flow collection
+func parseYAMLScalar(s string) (*docNode, error) {
+	if s == "" {
+		return &docNode{kind: docScalar, plain: true}, nil
+	}
+	switch s[0] {
+	case '"':
+		var text string
+		if len(s) < 2 || s[len(s)-1] != '"' || json.Unmarshal([]byte(s), &text) != nil {
+			return nil, fmt.Errorf("yaml: invalid double-quoted scalar %s", s)
+		}
+		return &docNode{kind: docScalar, text: text}, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("yaml: invalid single-quoted scalar %s", s)
+		}
+		return &docNode{kind: docScalar, text: strings.ReplaceAll(s[1:len(s)-1], "''", "'")}, nil
+	case '[', '{':
+		return parseYAMLFlow(s)
+	case '&', '*', '!':
+		return nil, errYAMLUnsupported
+	}
+	return &docNode{kind: docScalar, text: s, plain: true}, nil
+}
+
+// Parse [a, b] or {k: v, ...} holding scalars only
+func parseYAMLFlow(s string) (*docNode, error) {
+	open, close := s[0], byte(']')
+	kind := docList
+	if open == '{' {
+		close, kind = '}', docMapping
+	}
+	if s[len(s)-1] != close {
+		return nil, fmt.Errorf("yaml: unterminated flow collection %s", s)
+	}
+	node := &docNode{kind: kind}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return node, nil
+	}
+	for _, part := range splitYAMLFlow(inner) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("yaml: empty entry in %s", s)
+		}
+		if kind == docList {
+			if strings.ContainsAny(part[:1], "[{") {
+				return nil, fmt.Errorf("yaml: nested flow collections are not supported")
+			}
+			item, err := parseYAMLScalar(part)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, item)
+			continue
+		}
+		end := yamlKeyEnd(part)
+		if end < 0 {
+			return nil, fmt.Errorf("yaml: expected key: value in %s", s)
+		}
+		key, err := parseYAMLScalar(strings.TrimSpace(part[:end]))
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseYAMLScalar(strings.TrimSpace(part[end+1:]))
+		if err != nil {
+			return nil, err
+		}
+		if value.kind != docScalar {
+			return nil, fmt.Errorf("yaml: nested flow collections are not supported")
+		}
+		node.keys = append(node.keys, key.text)
+		node.children = append(node.children, value)
+	}
+	return node, nil
+}
+
+// Split flow entries on commas outside quotes and brackets
+func splitYAMLFlow(s string) []string {
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
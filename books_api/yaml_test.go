@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeYAML(t *testing.T) {
+	var out bytes.Buffer
+	err := encodeYAML(&out, []byte(`{"id": 1, "title": "Dune: Messiah", "isbn": "9780441013593", "year": 1969,
+		"tags": [{"id": 2, "name": "sci-fi"}], "empty": [], "notes": "two\nlines", "flag": true, "none": null, "yes": "no"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `id: 1
+title: "Dune: Messiah"
+isbn: "9780441013593"
+year: 1969
+tags:
+  - id: 2
+    name: sci-fi
+empty: []
+notes: "two\nlines"
+flag: true
+none: null
+"yes": "no"
+`
+	if out.String() != want {
+		t.Errorf("Unexpected YAML:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// What the encoder writes, the parser reads back
+	root, err := parseYAML(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := decodeDoc(root, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["title"] != "Dune: Messiah" || got["isbn"] != "9780441013593" || got["yes"] != "no" || got["none"] != nil {
+		t.Errorf("Unexpected round trip %v", got)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"title: Dune\nyear: 1965", `{"title":"Dune","year":1965}`},
+		{"# a book\n---\ntitle: 'It''s here'  # comment\nauthor: \"A \\\"B\\\"\"", `{"author":"A \"B\"","title":"It's here"}`},
+		{"title: It's # mine", `{"title":"It's"}`},
+		{"tags:\n- a\n- b\nempty:", `{"empty":null,"tags":["a","b"]}`},
+		{"- title: Dune\n  year: 1965\n- title: Emma", `[{"title":"Dune","year":1965},{"title":"Emma"}]`},
+		{"- - 1\n  - 2", `[[1,2]]`},
+		{"tags: [a, 'b c', 3]\nmeta: {k: v}\nnone: {}", `{"meta":{"k":"v"},"none":{},"tags":["a","b c",3]}`},
+		{"description: |\n  line one\n  line two\n\nnext: x", `{"description":"line one\nline two\n","next":"x"}`},
+		{"description: >-\n  folded\n  text\n\n  para", `{"description":"folded text\npara"}`},
+		{"url: http://example.com/a", `{"url":"http://example.com/a"}`},
+	}
+	for _, tt := range tests {
+		root, err := parseYAML(tt.src)
+		if err != nil {
+			t.Errorf("parseYAML(%q): %v", tt.src, err)
+			continue
+		}
+		var got interface{}
+		decodeDoc(root, &got)
+		data, _ := json.Marshal(got)
+		if string(data) != tt.want {
+			t.Errorf("parseYAML(%q) = %s, want %s", tt.src, data, tt.want)
+		}
+	}
+
+	for _, src := range []string{
+		"title: Dune\n  year: 1965",
+		"title: a\ntitle: b",
+		"base: &anchor x",
+		"tags: [[a]]",
+		"title: \"open",
+		"\ttitle: Dune",
+	} {
+		if _, err := parseYAML(src); err == nil {
+			t.Errorf("parseYAML(%q): expected an error", src)
+		}
+	}
+}
+
+func TestYAMLBooks(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", yamlType)
+		req.Header.Set("Content-Type", yamlType)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	created := send("POST", "/api/v1/books", "title: Dune\nauthor: Frank Herbert\nisbn: 9780441013593\nyear: 1965\n")
+	if created.Code != http.StatusCreated || created.Header().Get("Content-Type") != yamlType {
+		t.Fatalf("Expected a 201 YAML response, got %d %q: %s", created.Code, created.Header().Get("Content-Type"), created.Body.String())
+	}
+	root, _ := parseYAML(created.Body.String())
+	var book Book
+	if err := decodeDoc(root, &book); err != nil || book.ID == 0 || book.ISBN != "9780441013593" || book.Year != 1965 {
+		t.Fatalf("Unexpected YAML book %+v (%v): %s", book, err, created.Body.String())
+	}
+
+	path := fmt.Sprintf("/api/v1/books/%d", book.ID)
+	updated := send("PUT", path, "description: |\n  Spice\n  and sand\n")
+	var stored Book
+	db.First(&stored, book.ID)
+	if updated.Code != http.StatusOK || stored.Description != "Spice\nand sand\n" {
+		t.Errorf("Expected the YAML update to apply, got %d %q", updated.Code, stored.Description)
+	}
+
+	if response := send("PUT", path, "year: [1965"); response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "Invalid YAML") {
+		t.Errorf("Expected Invalid YAML, got %d: %s", response.Code, response.Body.String())
+	}
+
+	list := send("GET", "/api/v1/books?fields=id,title", "")
+	var items []map[string]interface{}
+	root, _ = parseYAML(list.Body.String())
+	decodeDoc(root, &items)
+	if want := []map[string]interface{}{{"id": float64(book.ID), "title": "Dune"}}; !reflect.DeepEqual(items, want) {
+		t.Errorf("Expected %v, got %v from %s", want, items, list.Body.String())
+	}
+}
@@ -62,7 +62,7 @@ fi
 # Test Go build
 echo "🏗️  Testing Go build..."
 cd books_api
-if go build -o bin/books_api main.go >/dev/null 2>&1; then
+if go build -o bin/books_api . >/dev/null 2>&1; then
     echo "✅ Go build successful"
     rm -f bin/books_api
 else
@@ -1,4 +1,5 @@
 import { test, expect } from '@playwright/test';
+import { uniqueISBN } from './isbn';
 
 const API_BASE_URL = 'http://localhost:8080/api/v1';
 
@@ -57,11 +58,11 @@ test.describe('Books API E2E Tests @api @e2e', () => {
   });
 
   test('should create a new book @books @crud', async ({ request }) => {
-    const uniqueISBN = `978${Date.now().toString().slice(-9)}`;
+    const isbn = uniqueISBN();
     const newBook = {
       title: 'Test Driven Development',
       author: 'Kent Beck',
-      isbn: uniqueISBN,
+      isbn,
       year: 2002,
     };
 
@@ -105,11 +106,11 @@ test.describe('Books API E2E Tests @api @e2e', () => {
 
   test('should update an existing book @books @crud', async ({ request }) => {
     // First create a book
-    const uniqueISBN = `978${Date.now().toString().slice(-9)}`;
+    const isbn = uniqueISBN();
     const newBook = {
       title: 'Original Title',
       author: 'Original Author',
-      isbn: uniqueISBN,
+      isbn,
       year: 2020,
     };
 
@@ -141,11 +142,11 @@ test.describe('Books API E2E Tests @api @e2e', () => {
 
   test('should delete a book @books @crud', async ({ request }) => {
     // First create a book
-    const uniqueISBN = `978${Date.now().toString().slice(-9)}`;
+    const isbn = uniqueISBN();
     const newBook = {
       title: 'Book to Delete',
       author: 'Delete Author',
-      isbn: uniqueISBN,
+      isbn,
       year: 2023,
     };
 
@@ -213,11 +214,11 @@ test.describe('Books API E2E Tests @api @e2e', () => {
     request,
   }) => {
     // Create a unique book to avoid conflicts with other tests
-    const uniqueISBN = `978${Date.now().toString().slice(-9)}`;
+    const isbn = uniqueISBN();
     const newBook = {
       title: 'Consistency Test Book',
       author: 'Test Author',
-      isbn: uniqueISBN,
+      isbn,
       year: 2024,
     };
 
@@ -0,0 +1,29 @@
+import { test, expect } from '@playwright/test';
+import { ApiError, BooksApiClient } from './client/books-api';
+import { uniqueISBN } from './isbn';
+
+const client = new BooksApiClient({ baseUrl: 'http://localhost:8080' });
+
+test.describe('Generated TypeScript client @api @client', () => {
+  test('should list books with typed results @smoke', async () => {
+    const books = await client.listBooks();
+    expect(books.length).toBeGreaterThan(0);
+    expect(typeof books[0].title).toBe('string');
+  });
+
+  test('should create, update and delete a book @crud', async () => {
+    const created = await client.createBook({
+      title: 'Generated Client',
+      author: 'Books API',
+      isbn: uniqueISBN(),
+      year: 2024,
+    });
+    expect(created.id).toBeGreaterThan(0);
+
+    const updated = await client.updateBook(created.id, { year: 2025 });
+    expect(updated.year).toBe(2025);
+
+    await client.deleteBook(created.id);
+    await expect(client.getBook(created.id)).rejects.toBeInstanceOf(ApiError);
+  });
+});
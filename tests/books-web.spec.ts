@@ -1,4 +1,5 @@
 import { test, expect } from '@playwright/test';
+import { uniqueISBN } from './isbn';
 
 test.describe('Books Demo Web Interface E2E Tests @e2e @web', () => {
   test.beforeEach(async ({ page }) => {
@@ -48,11 +49,10 @@ test.describe('Books Demo Web Interface E2E Tests @e2e @web', () => {
   }) => {
     // Generate unique data for the test
     const timestamp = Date.now();
-    const randomSuffix = Math.floor(Math.random() * 10000);
     const bookData = {
       title: `E2E Test Book ${timestamp}`,
       author: `Test Author ${timestamp}`,
-      isbn: `978${timestamp.toString()}${randomSuffix}`,
+      isbn: uniqueISBN(),
       year: '2024',
     };
 
@@ -105,14 +105,38 @@ test.describe('Books Demo Web Interface E2E Tests @e2e @web', () => {
     expect(refreshedBooks).toBeGreaterThan(0);
   });
 
+  test('should show books added elsewhere without refreshing @web @books', async ({
+    page,
+    request,
+  }) => {
+    await expect(page.locator('.book-card').first()).toBeVisible({
+      timeout: 10000,
+    });
+
+    // Another client adds a book; the open page hears of it over WebSocket
+    const title = `Live Update Book ${Date.now()}`;
+    const response = await request.post('http://localhost:3000/api/v1/books', {
+      data: {
+        title,
+        author: 'Live Author',
+        isbn: uniqueISBN(),
+        year: 2024,
+      },
+    });
+    expect(response.status()).toBe(201);
+
+    await expect(page.locator(`text="${title}"`)).toBeVisible({
+      timeout: 10000,
+    });
+  });
+
   test('should delete a book @web @crud', async ({ page }) => {
     // First, add a book to delete
     const timestamp = Date.now();
-    const randomSuffix = Math.floor(Math.random() * 10000);
     const bookData = {
       title: `Book to Delete ${timestamp}`,
       author: `Delete Author ${timestamp}`,
-      isbn: `978${timestamp.toString()}${randomSuffix}`,
+      isbn: uniqueISBN(),
       year: '2024',
     };
 
@@ -0,0 +1,1507 @@
+// Code generated by `books_api gen ts-client`. DO NOT EDIT.
+
+export interface AcknowledgedBook {
+  book_id: number;
+  title: string;
+  author: string;
+  isbn: string;
+}
+
+export interface Acknowledgment {
+  donation_id: number;
+  donor_id: number;
+  name: string;
+  email?: string;
+  address?: string;
+  received_on: string;
+  books: AcknowledgedBook[];
+}
+
+export interface Acquisition {
+  id: number;
+  book_id: number;
+  method: string;
+  purchase_order?: string;
+  vendor?: string;
+  donation_id?: number | null;
+  cost_cents?: number;
+  currency?: string;
+  acquired_on: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AcquisitionRequest {
+  book_id: number;
+  method: string;
+  purchase_order: string;
+  vendor: string;
+  donation_id: number | null;
+  cost_cents: number;
+  currency: string;
+  acquired_on: string;
+}
+
+export interface ActivityItem {
+  type: string;
+  at: string;
+  book_id?: number;
+  user_id?: number;
+  summary: string;
+}
+
+export interface Author {
+  id: number;
+  name: string;
+  bio?: string;
+  book_count: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AuthorRequest {
+  name: string;
+  bio: string;
+}
+
+export interface BarcodeLookup {
+  book: Book;
+  isbn: string;
+  source: string;
+}
+
+export interface Book {
+  id: number;
+  title: string;
+  author: string;
+  author_id?: number | null;
+  publisher_id?: number | null;
+  isbn: string;
+  year: number;
+  description: string;
+  cover_url: string;
+  price_cents?: number;
+  language?: string;
+  page_count?: number;
+  format?: string;
+  status: string;
+  purchase_price_cents?: number;
+  purchase_currency?: string;
+  location?: string;
+  call_number?: string;
+  attributes?: Record<string, string>;
+  tags?: Tag[];
+  created_at: string;
+  updated_at: string;
+  average_rating?: number;
+  review_count?: number;
+  series_id?: number | null;
+  series_volume?: number;
+  series?: BookSeries;
+  work_id?: number | null;
+}
+
+export interface BookChangeEntry {
+  seq: number;
+  type: string;
+  book_id: number;
+  book?: Book;
+}
+
+export interface BookCount {
+  count: number;
+}
+
+export interface BookRevision {
+  number: number;
+  created_at: string;
+  book: Book;
+  changes: FieldChange[];
+}
+
+export interface BookSeries {
+  id: number;
+  name: string;
+  volume?: number;
+}
+
+export interface BookValidation {
+  valid: boolean;
+  fields: FieldError[];
+}
+
+export interface BorrowedBook {
+  book_id: number;
+  title: string;
+  author: string;
+  loans: number;
+}
+
+export interface BulkDeleteResult {
+  deleted: number;
+  ids: number[];
+}
+
+export interface BulkReport {
+  succeeded: number;
+  failed: number;
+  results: BulkResult[];
+}
+
+export interface BulkResult {
+  index: number;
+  status: string;
+  book?: Book;
+  errors?: FieldError[];
+  code?: string;
+}
+
+export interface CatalogStats {
+  generated_at: string;
+  total_books: number;
+  total_authors: number;
+  books_per_decade: DecadeCount[];
+  unknown_year: number;
+  top_authors: Author[];
+  newest_books: Book[];
+  collection_value: CurrencyValue[];
+  unvalued_books: number;
+}
+
+export interface ChangeFeed {
+  changes: BookChangeEntry[];
+  next: string;
+  has_more: boolean;
+}
+
+export interface CheckoutRequest {
+  borrower: string;
+  due_at?: string | null;
+}
+
+export interface CoverUpload {
+  expires_at: string;
+  headers: Record<string, string>;
+  method: string;
+  url: string;
+}
+
+export interface CoverUploadRequest {
+  content_type: string;
+}
+
+export interface CurrencyValue {
+  currency: string;
+  total_cents: number;
+  books: number;
+}
+
+export interface Dashboard {
+  generated_at: string;
+  totals: DashboardTotals;
+  recent_activity: ActivityItem[];
+  top_borrowed: BorrowedBook[];
+  errors: DashboardErrors;
+  storage: DashboardStorage;
+  reader_growth: ReaderGrowthDay[];
+}
+
+export interface DashboardErrors {
+  window: string;
+  error_rate: number;
+}
+
+export interface DashboardStorage {
+  database_bytes: number;
+  covers: number;
+  blob_bytes: number | null;
+  blob_files: number | null;
+}
+
+export interface DashboardTotals {
+  books: number;
+  reviews: number;
+  readers: number;
+  interactions: number;
+}
+
+export interface DecadeCount {
+  decade: number;
+  books: number;
+}
+
+export interface Donation {
+  id: number;
+  donor_id: number;
+  received_on: string;
+  notes?: string;
+  acknowledged_at?: string | null;
+  created_at: string;
+  updated_at: string;
+  book_count: number;
+}
+
+export interface DonationRequest {
+  donor_id: number;
+  received_on: string;
+  notes: string;
+}
+
+export interface Donor {
+  id: number;
+  name: string;
+  email?: string;
+  address?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface DonorRequest {
+  name: string;
+  email: string;
+  address: string;
+}
+
+export interface ExportRecord {
+  type: string;
+  data: unknown;
+}
+
+export interface FieldChange {
+  field: string;
+  from: unknown;
+  to: unknown;
+}
+
+export interface FieldError {
+  field: string;
+  message: string;
+}
+
+export interface Fine {
+  id: number;
+  loan_id: number;
+  borrower: string;
+  days_late: number;
+  amount_cents: number;
+  paid_cents: number;
+  created_at: string;
+  paid_at?: string | null;
+}
+
+export interface FinePayment {
+  amount_cents: number;
+}
+
+export interface FiscalYearAcquisitions {
+  fiscal_year: number;
+  starts_on: string;
+  ends_on: string;
+  books: number;
+  methods: Record<string, number>;
+  totals: CurrencyValue[];
+}
+
+export interface GoogleBooksResults {
+  items: GoogleVolume[];
+  total: number;
+}
+
+export interface GoogleVolume {
+  volume_id: string;
+  title: string;
+  author: string;
+  isbn: string;
+  year: number;
+  description: string;
+  cover_url: string;
+  publisher?: string;
+  page_count?: number;
+  language?: string;
+}
+
+export interface GraphQLError {
+  message: string;
+  path?: unknown[];
+  extensions?: Record<string, unknown>;
+}
+
+export interface GraphQLResponse {
+  data?: unknown;
+  errors?: GraphQLError[];
+}
+
+export interface HealthStatus {
+  status: string;
+}
+
+export interface ImportReport {
+  dry_run: boolean;
+  format: string;
+  created: number;
+  updated: number;
+  skipped: number;
+  errors: number;
+  rows: ImportRow[];
+}
+
+export interface ImportRow {
+  row: number;
+  title: string;
+  author: string;
+  isbn: string;
+  year: number;
+  tags: string[];
+  rating?: number;
+  review?: string;
+  action: string;
+  message?: string;
+}
+
+export interface Interaction {
+  id: number;
+  user_id: number;
+  book_id: number;
+  kind: string;
+  created_at: string;
+}
+
+export interface Library {
+  id: number;
+  code: string;
+  name: string;
+  book_count: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface LibraryRequest {
+  code: string;
+  name: string;
+}
+
+export interface ListEntry {
+  book_id: number;
+  added_at: string;
+  title: string;
+  author: string;
+}
+
+export interface ListEntryRequest {
+  book_id: number;
+}
+
+export interface ListOrder {
+  book_ids: number[];
+}
+
+export interface ListRequest {
+  name: string;
+  description: string;
+}
+
+export interface Loan {
+  id: number;
+  book_id: number;
+  borrower: string;
+  checked_out_at: string;
+  due_at: string;
+  renewals: number;
+  returned_at?: string | null;
+  reminded_at?: string | null;
+  fine?: Fine;
+}
+
+export interface Location {
+  code: string;
+  name: string;
+  book_count: number;
+}
+
+export interface LoginRequest {
+  username: string;
+  password: string;
+}
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string;
+  user: Principal;
+}
+
+export interface Member {
+  id: number;
+  name: string;
+  email?: string;
+  membership_number: string;
+  status: string;
+  username?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface MemberFines {
+  member_id: number;
+  borrower: string;
+  outstanding_cents: number;
+  fines: Fine[];
+}
+
+export interface MemberRequest {
+  name: string;
+  email: string;
+  membership_number: string;
+  status: string;
+  username: string;
+}
+
+export interface NewWebhook {
+  id: number;
+  url: string;
+  events: string[];
+  active: boolean;
+  created_at: string;
+  updated_at: string;
+  secret: string;
+}
+
+export interface Order {
+  id: number;
+  status: string;
+  email?: string;
+  currency: string;
+  total_cents: number;
+  items: OrderItem[];
+  payment_provider?: string;
+  checkout_url?: string;
+  created_at: string;
+  updated_at: string;
+  paid_at?: string | null;
+}
+
+export interface OrderItem {
+  book_id: number;
+  title: string;
+  quantity: number;
+  unit_price_cents: number;
+  subtotal_cents: number;
+}
+
+export interface OrderItemRequest {
+  book_id: number;
+  quantity: number;
+}
+
+export interface OrderRequest {
+  email?: string;
+  items: OrderItemRequest[];
+}
+
+export interface OverdueLoan {
+  id: number;
+  book_id: number;
+  borrower: string;
+  checked_out_at: string;
+  due_at: string;
+  renewals: number;
+  returned_at?: string | null;
+  reminded_at?: string | null;
+  fine?: Fine;
+  title: string;
+  author: string;
+  days_overdue: number;
+}
+
+export interface Principal {
+  username: string;
+  name?: string;
+  email?: string;
+  roles: string[];
+  backend: string;
+}
+
+export interface Problem {
+  type: string;
+  title: string;
+  status: number;
+  detail?: string;
+  instance?: string;
+  code: string;
+  fields?: FieldError[];
+}
+
+export interface Publisher {
+  id: number;
+  name: string;
+  country?: string;
+  founded_year?: number;
+  book_count: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface PublisherRequest {
+  name: string;
+  country: string;
+  founded_year: number;
+}
+
+export interface ReaderGrowthDay {
+  date: string;
+  new_readers: number;
+  total: number;
+}
+
+export interface Readiness {
+  checks: Record<string, unknown>;
+  status: string;
+}
+
+export interface ReadingList {
+  id: number;
+  name: string;
+  description?: string;
+  book_count: number;
+  books?: ListEntry[];
+  created_at: string;
+  updated_at: string;
+}
+
+export interface RefreshConflict {
+  id: number;
+  job_id: number;
+  book_id: number;
+  field: string;
+  current: string;
+  proposed: string;
+}
+
+export interface RefreshFilter {
+  ids?: number[];
+  author?: string;
+  year_min?: number;
+  year_max?: number;
+  missing_only?: boolean;
+}
+
+export interface RefreshJob {
+  id: number;
+  status: string;
+  filter: RefreshFilter;
+  rules: Record<string, string>;
+  total: number;
+  processed: number;
+  updated: number;
+  unchanged: number;
+  not_found: number;
+  failed: number;
+  conflicts: number;
+  last_book_id: number;
+  error?: string;
+  progress: number;
+  created_at: string;
+  started_at: string | null;
+  finished_at: string | null;
+}
+
+export interface RefreshJobRequest {
+  filter: RefreshFilter;
+  rules: Record<string, string>;
+}
+
+export interface Review {
+  id: number;
+  book_id: number;
+  rating: number;
+  body: string;
+  source: string;
+  created_at: string;
+}
+
+export interface SRURecord {
+  lccn?: string;
+  title: string;
+  author: string;
+  isbns: string[];
+  year: number;
+  publisher?: string;
+  description?: string;
+  subjects: string[];
+  marcxml: string;
+}
+
+export interface SRUResults {
+  items: SRURecord[];
+  total: number;
+}
+
+export interface ScanRequest {
+  barcode: string;
+}
+
+export interface ScheduledJobInfo {
+  name: string;
+  description: string;
+  schedule: string;
+  next_run: string | null;
+  last_run: ScheduledRun;
+}
+
+export interface ScheduledRun {
+  id: number;
+  job: string;
+  trigger: string;
+  instance: string;
+  status: string;
+  error?: string;
+  started_at: string;
+  finished_at: string | null;
+}
+
+export interface Series {
+  id: number;
+  name: string;
+  description?: string;
+  book_count: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface SeriesRequest {
+  name: string;
+  description: string;
+}
+
+export interface Suggestion {
+  value: string;
+  field: string;
+}
+
+export interface Tag {
+  id: number;
+  name: string;
+}
+
+export interface TrashedBook {
+  id: number;
+  title: string;
+  author: string;
+  author_id?: number | null;
+  publisher_id?: number | null;
+  isbn: string;
+  year: number;
+  description: string;
+  cover_url: string;
+  price_cents?: number;
+  language?: string;
+  page_count?: number;
+  format?: string;
+  status: string;
+  purchase_price_cents?: number;
+  purchase_currency?: string;
+  location?: string;
+  call_number?: string;
+  attributes?: Record<string, string>;
+  tags?: Tag[];
+  created_at: string;
+  updated_at: string;
+  average_rating?: number;
+  review_count?: number;
+  series_id?: number | null;
+  series_volume?: number;
+  series?: BookSeries;
+  work_id?: number | null;
+  deleted_at: string;
+  purge_at: string;
+}
+
+export interface ValidationErrors {
+  error: string;
+  fields: FieldError[];
+}
+
+export interface VendorAcquisitions {
+  vendor: string;
+  books: number;
+  totals: CurrencyValue[];
+  last_acquired_on: string;
+}
+
+export interface Webhook {
+  id: number;
+  url: string;
+  events: string[];
+  active: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface WebhookDelivery {
+  id: number;
+  webhook_id: number;
+  type: string;
+  key: string;
+  status: string;
+  attempts: number;
+  next_attempt_at: string;
+  last_status?: number;
+  last_error?: string;
+  created_at: string;
+  delivered_at?: string | null;
+}
+
+export interface WebhookRequest {
+  url: string;
+  events?: string[];
+  secret?: string;
+  active?: boolean | null;
+}
+
+export interface Work {
+  id: number;
+  title: string;
+  author: string;
+  edition_count: number;
+  editions?: Book[];
+  created_at: string;
+  updated_at: string;
+}
+
+export class ApiError extends Error {
+  constructor(
+    public readonly status: number,
+    public readonly body: string
+  ) {
+    super(`HTTP ${status}: ${body}`);
+  }
+}
+
+export interface ClientOptions {
+  baseUrl?: string;
+  fetch?: typeof fetch;
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+export class BooksApiClient {
+  private readonly baseUrl: string;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(options: ClientOptions = {}) {
+    this.baseUrl = (options.baseUrl ?? 'http://localhost:8080').replace(/\/$/, '');
+    this.fetchImpl = options.fetch ?? fetch;
+  }
+
+  private async request<T>(
+    method: string,
+    path: string,
+    query?: Query,
+    body?: unknown
+  ): Promise<T> {
+    const url = new URL(this.baseUrl + path);
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) {
+        url.searchParams.set(key, String(value));
+      }
+    }
+    const response = await this.fetchImpl(url.toString(), {
+      method,
+      headers:
+        body === undefined ? undefined : { 'Content-Type': 'application/json' },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const text = await response.text();
+    if (!response.ok) {
+      throw new ApiError(response.status, text);
+    }
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+
+  /** List acquisitions, most recent first */
+  getAcquisitions(query: { vendor?: string; method?: string; book_id?: number; fiscal_year?: number } = {}): Promise<Acquisition[]> {
+    return this.request('GET', `/api/v1/acquisitions`, query);
+  }
+
+  /** Record how a book was acquired */
+  createAcquisition(body: Partial<AcquisitionRequest>): Promise<Acquisition> {
+    return this.request('POST', `/api/v1/acquisitions`, undefined, body);
+  }
+
+  /** Books acquired and their cost per fiscal year */
+  getFiscalYearReport(query: { fiscal_year?: number } = {}): Promise<FiscalYearAcquisitions[]> {
+    return this.request('GET', `/api/v1/acquisitions/reports/fiscal-years`, query);
+  }
+
+  /** Books acquired and their cost per vendor */
+  getVendorReport(query: { fiscal_year?: number } = {}): Promise<VendorAcquisitions[]> {
+    return this.request('GET', `/api/v1/acquisitions/reports/vendors`, query);
+  }
+
+  /** Delete an acquisition record */
+  deleteAcquisition(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/acquisitions/${encodeURIComponent(id)}`);
+  }
+
+  /** Get an acquisition by ID */
+  getAcquisition(id: number): Promise<Acquisition> {
+    return this.request('GET', `/api/v1/acquisitions/${encodeURIComponent(id)}`);
+  }
+
+  /** Correct an acquisition record */
+  updateAcquisition(id: number, body: Partial<AcquisitionRequest>): Promise<Acquisition> {
+    return this.request('PUT', `/api/v1/acquisitions/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** Catalog, order and storage figures for the admin home screen */
+  getDashboard(query: { days?: number } = {}): Promise<Dashboard> {
+    return this.request('GET', `/api/v1/admin/dashboard`, query);
+  }
+
+  /** Metadata refresh jobs */
+  listRefreshJobs(): Promise<RefreshJob[]> {
+    return this.request('GET', `/api/v1/admin/metadata-refresh`);
+  }
+
+  /** Start a metadata refresh over all or some books */
+  createRefreshJob(body: Partial<RefreshJobRequest>): Promise<RefreshJob> {
+    return this.request('POST', `/api/v1/admin/metadata-refresh`, undefined, body);
+  }
+
+  /** A metadata refresh job's progress */
+  getRefreshJob(id: number): Promise<RefreshJob> {
+    return this.request('GET', `/api/v1/admin/metadata-refresh/${encodeURIComponent(id)}`);
+  }
+
+  /** Stop a running job */
+  cancelRefreshJob(id: number): Promise<void> {
+    return this.request('POST', `/api/v1/admin/metadata-refresh/${encodeURIComponent(id)}/cancel`);
+  }
+
+  /** Fields the provider disagreed with and the job kept */
+  listRefreshConflicts(id: number): Promise<RefreshConflict[]> {
+    return this.request('GET', `/api/v1/admin/metadata-refresh/${encodeURIComponent(id)}/conflicts`);
+  }
+
+  /** Resume a stopped job from its checkpoint */
+  resumeRefreshJob(id: number): Promise<RefreshJob> {
+    return this.request('POST', `/api/v1/admin/metadata-refresh/${encodeURIComponent(id)}/resume`);
+  }
+
+  /** All orders, newest first */
+  listOrders(query: { status?: string } = {}): Promise<Order[]> {
+    return this.request('GET', `/api/v1/admin/orders`, query);
+  }
+
+  /** Mark a paid order fulfilled */
+  fulfillOrder(id: number): Promise<Order> {
+    return this.request('POST', `/api/v1/admin/orders/${encodeURIComponent(id)}/fulfill`);
+  }
+
+  /** Scheduled jobs with their schedules and latest runs */
+  listScheduledJobs(): Promise<ScheduledJobInfo[]> {
+    return this.request('GET', `/api/v1/admin/scheduled-jobs`);
+  }
+
+  /** Run a job now */
+  runScheduledJob(name: string): Promise<ScheduledRun> {
+    return this.request('POST', `/api/v1/admin/scheduled-jobs/${encodeURIComponent(name)}/run`);
+  }
+
+  /** A job's latest runs */
+  listScheduledRuns(name: string): Promise<ScheduledRun[]> {
+    return this.request('GET', `/api/v1/admin/scheduled-jobs/${encodeURIComponent(name)}/runs`);
+  }
+
+  /** Rebuild the search index in the background */
+  reindexSearch(): Promise<{
+    status: string;
+  }> {
+    return this.request('POST', `/api/v1/admin/search/reindex`);
+  }
+
+  /** Exchange a username and password for a token */
+  login(body: Partial<LoginRequest>): Promise<LoginResponse> {
+    return this.request('POST', `/api/v1/auth/login`, undefined, body);
+  }
+
+  /** The signed-in user */
+  getCurrentUser(): Promise<Principal> {
+    return this.request('GET', `/api/v1/auth/me`);
+  }
+
+  /** List the catalog's authors with their book counts */
+  getAuthors(query: { include_empty?: boolean } = {}): Promise<Author[]> {
+    return this.request('GET', `/api/v1/authors`, query);
+  }
+
+  /** Add an author */
+  createAuthor(body: Partial<AuthorRequest>): Promise<Author> {
+    return this.request('POST', `/api/v1/authors`, undefined, body);
+  }
+
+  /** Remove an author with no books */
+  deleteAuthor(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/authors/${encodeURIComponent(id)}`);
+  }
+
+  /** Get an author by ID */
+  getAuthor(id: number): Promise<Author> {
+    return this.request('GET', `/api/v1/authors/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace an author's name and bio, renaming it on their books */
+  updateAuthor(id: number, body: Partial<AuthorRequest>): Promise<Author> {
+    return this.request('PUT', `/api/v1/authors/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** An author's books by title */
+  listAuthorBooks(id: number): Promise<Book[]> {
+    return this.request('GET', `/api/v1/authors/${encodeURIComponent(id)}/books`);
+  }
+
+  /** Delete the books with the given IDs or matching the filters */
+  bulkDeleteBooks(query: { ids?: string; author?: string; title?: string; year_min?: number; year_max?: number; language?: string; status?: string; location?: string; created_after?: string; q?: string } = {}): Promise<BulkDeleteResult> {
+    return this.request('DELETE', `/api/v1/books`, query);
+  }
+
+  /** List all books */
+  listBooks(query: { author?: string; title?: string; year_min?: number; year_max?: number; language?: string; status?: string; location?: string; created_after?: string; q?: string; sort?: string; include_deleted?: boolean } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books`, query);
+  }
+
+  /** Create a book */
+  createBook(body: Partial<Book>, query: { enrich?: boolean } = {}): Promise<Book> {
+    return this.request('POST', `/api/v1/books`, query, body);
+  }
+
+  /** Create several books, reporting on each */
+  bulkCreateBooks(body: Partial<Book>[]): Promise<BulkReport> {
+    return this.request('POST', `/api/v1/books/bulk`, undefined, body);
+  }
+
+  /** Merge fields into several books by ID, all or none */
+  bulkUpdateBooks(body: Partial<Book>[]): Promise<BulkReport> {
+    return this.request('PUT', `/api/v1/books/bulk`, undefined, body);
+  }
+
+  /** Books created, updated or deleted since a checkpoint, for incremental sync */
+  listBookChanges(query: { since?: string; limit?: number } = {}): Promise<ChangeFeed> {
+    return this.request('GET', `/api/v1/books/changes`, query);
+  }
+
+  /** Count the books matching the listing filters */
+  countBooks(query: { author?: string; title?: string; year_min?: number; year_max?: number; language?: string; status?: string; location?: string; created_after?: string; q?: string; include_deleted?: boolean } = {}): Promise<BookCount> {
+    return this.request('GET', `/api/v1/books/count`, query);
+  }
+
+  /** Create a book from a Google Books volume */
+  createBookFromGoogle(volumeId: string): Promise<Book> {
+    return this.request('POST', `/api/v1/books/from-google/${encodeURIComponent(volumeId)}`);
+  }
+
+  /** Create a book from the SRU catalog record for an ISBN */
+  createBookFromSRU(isbn: string): Promise<Book> {
+    return this.request('POST', `/api/v1/books/from-sru/${encodeURIComponent(isbn)}`);
+  }
+
+  /** Get a book by ISBN-10 or ISBN-13, with or without dashes */
+  getBookByISBN(isbn: string): Promise<Book> {
+    return this.request('GET', `/api/v1/books/isbn/${encodeURIComponent(isbn)}`);
+  }
+
+  /** Create the book with an ISBN, or update its non-empty fields if it exists */
+  upsertBookByISBN(isbn: string, body: Partial<Book>): Promise<Book> {
+    return this.request('PUT', `/api/v1/books/isbn/${encodeURIComponent(isbn)}`, undefined, body);
+  }
+
+  /** Pick random books matching the listing filters */
+  getRandomBooks(query: { count?: number; author?: string; title?: string; year_min?: number; year_max?: number; language?: string; status?: string; location?: string; created_after?: string; q?: string } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books/random`, query);
+  }
+
+  /** Create a book from a scanned EAN-13 or UPC-A barcode */
+  scanBook(body: Partial<ScanRequest>): Promise<Book> {
+    return this.request('POST', `/api/v1/books/scan`, undefined, body);
+  }
+
+  /** Search books by title, author, ISBN or description */
+  searchBooks(query: { q: string; limit?: number; offset?: number }): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books/search`, query);
+  }
+
+  /** Deleted books that can still be restored, most recent first */
+  listTrash(): Promise<TrashedBook[]> {
+    return this.request('GET', `/api/v1/books/trash`);
+  }
+
+  /** Check a book for every error a write would report, without saving it */
+  validateBook(body: Partial<Book>, query: { id?: number } = {}): Promise<BookValidation> {
+    return this.request('POST', `/api/v1/books/validate`, query, body);
+  }
+
+  /** Delete a book */
+  deleteBook(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/books/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a book by ID */
+  getBook(id: number): Promise<Book> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}`);
+  }
+
+  /** Set the named fields of a book (JSON Merge Patch); null clears one */
+  patchBook(id: number, body: Partial<Book>): Promise<Book> {
+    return this.request('PATCH', `/api/v1/books/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** Update a book's non-empty fields */
+  updateBook(id: number, body: Partial<Book>): Promise<Book> {
+    return this.request('PUT', `/api/v1/books/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** Books read by readers of this book */
+  listAlsoRead(id: number, query: { limit?: number } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}/also-read`, query);
+  }
+
+  /** Lend a book to a member */
+  checkoutBook(id: number, body: Partial<CheckoutRequest>): Promise<Loan> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/checkout`, undefined, body);
+  }
+
+  /** Remove a book's uploaded cover */
+  deleteCover(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/books/${encodeURIComponent(id)}/cover`);
+  }
+
+  /** Attach a cover uploaded to a presigned URL */
+  completeCoverUpload(id: number): Promise<Book> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/cover/complete`);
+  }
+
+  /** Get a presigned URL to upload a cover to directly */
+  createCoverUploadURL(id: number, body: Partial<CoverUploadRequest>): Promise<CoverUpload> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/cover/upload-url`, undefined, body);
+  }
+
+  /** Every edition of the book's work, oldest first */
+  listBookEditions(id: number): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}/editions`);
+  }
+
+  /** Fill in metadata from the provider */
+  enrichBook(id: number, query: { overwrite?: boolean } = {}): Promise<Book> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/enrich`, query);
+  }
+
+  /** Books by the same author, with shared tags or from a similar year */
+  listRelatedBooks(id: number, query: { limit?: number } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}/related`, query);
+  }
+
+  /** Bring a deleted book back from the trash */
+  restoreBook(id: number): Promise<Book> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/restore`);
+  }
+
+  /** List reviews for a book */
+  listBookReviews(id: number): Promise<Review[]> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}/reviews`);
+  }
+
+  /** A book's earlier versions, newest first, with what each write changed */
+  listBookRevisions(id: number): Promise<BookRevision[]> {
+    return this.request('GET', `/api/v1/books/${encodeURIComponent(id)}/revisions`);
+  }
+
+  /** Put a book back as it was at a revision */
+  rollbackBook(id: number, number: number): Promise<Book> {
+    return this.request('POST', `/api/v1/books/${encodeURIComponent(id)}/revisions/${encodeURIComponent(number)}/rollback`);
+  }
+
+  /** List donations, most recent first */
+  getDonations(query: { donor_id?: number; acknowledged?: boolean } = {}): Promise<Donation[]> {
+    return this.request('GET', `/api/v1/donations`, query);
+  }
+
+  /** Record a batch of books a donor gave */
+  createDonation(body: Partial<DonationRequest>): Promise<Donation> {
+    return this.request('POST', `/api/v1/donations`, undefined, body);
+  }
+
+  /** Delete a donation no book was acquired in */
+  deleteDonation(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/donations/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a donation by ID */
+  getDonation(id: number): Promise<Donation> {
+    return this.request('GET', `/api/v1/donations/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a donation's donor, date and notes */
+  updateDonation(id: number, body: Partial<DonationRequest>): Promise<Donation> {
+    return this.request('PUT', `/api/v1/donations/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** Mark a donation's thank-you letter sent */
+  acknowledgeDonation(id: number): Promise<Donation> {
+    return this.request('POST', `/api/v1/donations/${encodeURIComponent(id)}/acknowledge`);
+  }
+
+  /** List the donors by name */
+  getDonors(): Promise<Donor[]> {
+    return this.request('GET', `/api/v1/donors`);
+  }
+
+  /** Add a donor */
+  createDonor(body: Partial<DonorRequest>): Promise<Donor> {
+    return this.request('POST', `/api/v1/donors`, undefined, body);
+  }
+
+  /** Remove a donor with no donations */
+  deleteDonor(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/donors/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a donor by ID */
+  getDonor(id: number): Promise<Donor> {
+    return this.request('GET', `/api/v1/donors/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a donor's details */
+  updateDonor(id: number, body: Partial<DonorRequest>): Promise<Donor> {
+    return this.request('PUT', `/api/v1/donors/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** The books a donor gave, by title */
+  getDonorBooks(id: number): Promise<Book[]> {
+    return this.request('GET', `/api/v1/donors/${encodeURIComponent(id)}/books`);
+  }
+
+  /** Search Google Books */
+  searchGoogleBooks(query: { q: string; start?: number; limit?: number }): Promise<GoogleBooksResults> {
+    return this.request('GET', `/api/v1/external/google-books`, query);
+  }
+
+  /** Search the SRU catalog by ISBN or title */
+  searchSRU(query: { isbn?: string; title?: string; start?: number; limit?: number } = {}): Promise<SRUResults> {
+    return this.request('GET', `/api/v1/external/sru`, query);
+  }
+
+  /** Record a payment towards a fine */
+  payFine(id: number, body: Partial<FinePayment>): Promise<Fine> {
+    return this.request('POST', `/api/v1/fines/${encodeURIComponent(id)}/payments`, undefined, body);
+  }
+
+  /** Create or update books from a CSV or JSON file, matched by ISBN */
+  importCatalog(body: Partial<Book>[], query: { dry_run?: boolean } = {}): Promise<ImportReport> {
+    return this.request('POST', `/api/v1/import`, query, body);
+  }
+
+  /** List the libraries, whose codes pick a catalog in X-Library */
+  getLibraries(): Promise<Library[]> {
+    return this.request('GET', `/api/v1/libraries`);
+  }
+
+  /** Add a library with an empty catalog */
+  createLibrary(body: Partial<LibraryRequest>): Promise<Library> {
+    return this.request('POST', `/api/v1/libraries`, undefined, body);
+  }
+
+  /** Remove a library with no books */
+  deleteLibrary(code: string): Promise<void> {
+    return this.request('DELETE', `/api/v1/libraries/${encodeURIComponent(code)}`);
+  }
+
+  /** Get a library by code */
+  getLibrary(code: string): Promise<Library> {
+    return this.request('GET', `/api/v1/libraries/${encodeURIComponent(code)}`);
+  }
+
+  /** Rename a library */
+  updateLibrary(code: string, body: Partial<LibraryRequest>): Promise<Library> {
+    return this.request('PUT', `/api/v1/libraries/${encodeURIComponent(code)}`, undefined, body);
+  }
+
+  /** The signed-in reader's reading lists */
+  getLists(): Promise<ReadingList[]> {
+    return this.request('GET', `/api/v1/lists`);
+  }
+
+  /** Create a reading list */
+  createList(body: Partial<ListRequest>): Promise<ReadingList> {
+    return this.request('POST', `/api/v1/lists`, undefined, body);
+  }
+
+  /** Delete a reading list */
+  deleteList(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/lists/${encodeURIComponent(id)}`);
+  }
+
+  /** A reading list with its books in order */
+  getReadingList(id: number): Promise<ReadingList> {
+    return this.request('GET', `/api/v1/lists/${encodeURIComponent(id)}`);
+  }
+
+  /** Rename a reading list */
+  updateList(id: number, body: Partial<ListRequest>): Promise<ReadingList> {
+    return this.request('PUT', `/api/v1/lists/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** Add a book to the end of a reading list */
+  addListBook(id: number, body: Partial<ListEntryRequest>): Promise<ReadingList> {
+    return this.request('POST', `/api/v1/lists/${encodeURIComponent(id)}/books`, undefined, body);
+  }
+
+  /** Put a reading list's books in a new order */
+  reorderList(id: number, body: Partial<ListOrder>): Promise<ReadingList> {
+    return this.request('PUT', `/api/v1/lists/${encodeURIComponent(id)}/books`, undefined, body);
+  }
+
+  /** Take a book off a reading list */
+  removeListBook(id: number, book_id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/lists/${encodeURIComponent(id)}/books/${encodeURIComponent(book_id)}`);
+  }
+
+  /** Open loans past their due date, longest overdue first */
+  listOverdueLoans(): Promise<OverdueLoan[]> {
+    return this.request('GET', `/api/v1/loans/overdue`);
+  }
+
+  /** Get a loan by ID */
+  getLoan(id: number): Promise<Loan> {
+    return this.request('GET', `/api/v1/loans/${encodeURIComponent(id)}`);
+  }
+
+  /** Extend a loan by another loan period */
+  renewLoan(id: number): Promise<Loan> {
+    return this.request('POST', `/api/v1/loans/${encodeURIComponent(id)}/renew`);
+  }
+
+  /** Return a lent book */
+  returnLoan(id: number): Promise<Loan> {
+    return this.request('POST', `/api/v1/loans/${encodeURIComponent(id)}/return`);
+  }
+
+  /** List the shelf locations with their book counts */
+  getLocations(): Promise<Location[]> {
+    return this.request('GET', `/api/v1/locations`);
+  }
+
+  /** The books at a location in call number order, for shelf reading */
+  getLocationBooks(code: string, query: { author?: string; title?: string; year_min?: number; year_max?: number; language?: string; status?: string; location?: string; created_after?: string; q?: string } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/locations/${encodeURIComponent(code)}/books`, query);
+  }
+
+  /** Look up an ISBN on Google Books to prefill a book */
+  lookupISBN(query: { isbn: string }): Promise<GoogleVolume> {
+    return this.request('GET', `/api/v1/lookup`, query);
+  }
+
+  /** List the members by name */
+  getMembers(query: { status?: string } = {}): Promise<Member[]> {
+    return this.request('GET', `/api/v1/members`, query);
+  }
+
+  /** Add a member */
+  createMember(body: Partial<MemberRequest>): Promise<Member> {
+    return this.request('POST', `/api/v1/members`, undefined, body);
+  }
+
+  /** Remove a member with no loans or fines outstanding */
+  deleteMember(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/members/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a member by ID */
+  getMember(id: number): Promise<Member> {
+    return this.request('GET', `/api/v1/members/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a member's details */
+  updateMember(id: number, body: Partial<MemberRequest>): Promise<Member> {
+    return this.request('PUT', `/api/v1/members/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** A member's fines and what they still owe */
+  getMemberFines(id: number): Promise<MemberFines> {
+    return this.request('GET', `/api/v1/members/${encodeURIComponent(id)}/fines`);
+  }
+
+  /** A member's loans, open ones first */
+  getMemberLoans(id: number): Promise<Loan[]> {
+    return this.request('GET', `/api/v1/members/${encodeURIComponent(id)}/loans`);
+  }
+
+  /** Place an order for priced books */
+  createOrder(body: Partial<OrderRequest>): Promise<Order> {
+    return this.request('POST', `/api/v1/orders`, undefined, body);
+  }
+
+  /** Get an order by ID */
+  getOrder(id: number): Promise<Order> {
+    return this.request('GET', `/api/v1/orders/${encodeURIComponent(id)}`);
+  }
+
+  /** Cancel a pending order */
+  cancelOrder(id: number): Promise<Order> {
+    return this.request('POST', `/api/v1/orders/${encodeURIComponent(id)}/cancel`);
+  }
+
+  /** Open a hosted checkout for a pending order */
+  checkoutOrder(id: number): Promise<Order> {
+    return this.request('POST', `/api/v1/orders/${encodeURIComponent(id)}/checkout`);
+  }
+
+  /** Receive payment provider events, verified by their signature */
+  paymentWebhook(body: Partial<Record<string, unknown>>): Promise<{
+    received: boolean;
+  }> {
+    return this.request('POST', `/api/v1/payments/webhook`, undefined, body);
+  }
+
+  /** List the publishers with their book counts */
+  getPublishers(): Promise<Publisher[]> {
+    return this.request('GET', `/api/v1/publishers`);
+  }
+
+  /** Add a publisher */
+  createPublisher(body: Partial<PublisherRequest>): Promise<Publisher> {
+    return this.request('POST', `/api/v1/publishers`, undefined, body);
+  }
+
+  /** Remove a publisher with no books */
+  deletePublisher(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/publishers/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a publisher by ID */
+  getPublisher(id: number): Promise<Publisher> {
+    return this.request('GET', `/api/v1/publishers/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a publisher's name, country and founding year */
+  updatePublisher(id: number, body: Partial<PublisherRequest>): Promise<Publisher> {
+    return this.request('PUT', `/api/v1/publishers/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** A publisher's books by title */
+  listPublisherBooks(id: number): Promise<Book[]> {
+    return this.request('GET', `/api/v1/publishers/${encodeURIComponent(id)}/books`);
+  }
+
+  /** List the series with their book counts */
+  getSeriesList(): Promise<Series[]> {
+    return this.request('GET', `/api/v1/series`);
+  }
+
+  /** Add a series */
+  createSeries(body: Partial<SeriesRequest>): Promise<Series> {
+    return this.request('POST', `/api/v1/series`, undefined, body);
+  }
+
+  /** Remove a series with no books */
+  deleteSeries(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/series/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a series by ID */
+  getSeries(id: number): Promise<Series> {
+    return this.request('GET', `/api/v1/series/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a series' name and description */
+  updateSeries(id: number, body: Partial<SeriesRequest>): Promise<Series> {
+    return this.request('PUT', `/api/v1/series/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** A series' books by volume */
+  listSeriesBooks(id: number): Promise<Book[]> {
+    return this.request('GET', `/api/v1/series/${encodeURIComponent(id)}/books`);
+  }
+
+  /** Total books, books per decade, top authors and newest additions */
+  getCatalogStats(): Promise<CatalogStats> {
+    return this.request('GET', `/api/v1/stats`);
+  }
+
+  /** Titles or authors starting with the typed text, for typeahead */
+  suggest(query: { q: string; field?: string; limit?: number }): Promise<Suggestion[]> {
+    return this.request('GET', `/api/v1/suggest`, query);
+  }
+
+  /** Record a loan, shelf or favorite for a member */
+  createInteraction(id: number, body: Partial<Interaction>): Promise<Interaction> {
+    return this.request('POST', `/api/v1/users/${encodeURIComponent(id)}/interactions`, undefined, body);
+  }
+
+  /** Personal recommendations */
+  listUserRecommendations(id: number, query: { limit?: number } = {}): Promise<Book[]> {
+    return this.request('GET', `/api/v1/users/${encodeURIComponent(id)}/recommendations`, query);
+  }
+
+  /** Registered webhooks */
+  listWebhooks(): Promise<Webhook[]> {
+    return this.request('GET', `/api/v1/webhooks`);
+  }
+
+  /** Register a webhook for book changes */
+  createWebhook(body: Partial<WebhookRequest>): Promise<NewWebhook> {
+    return this.request('POST', `/api/v1/webhooks`, undefined, body);
+  }
+
+  /** Remove a webhook and its deliveries */
+  deleteWebhook(id: number): Promise<void> {
+    return this.request('DELETE', `/api/v1/webhooks/${encodeURIComponent(id)}`);
+  }
+
+  /** Get a webhook by ID */
+  getWebhook(id: number): Promise<Webhook> {
+    return this.request('GET', `/api/v1/webhooks/${encodeURIComponent(id)}`);
+  }
+
+  /** Replace a webhook's URL, events and status */
+  updateWebhook(id: number, body: Partial<WebhookRequest>): Promise<Webhook> {
+    return this.request('PUT', `/api/v1/webhooks/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** A webhook's latest deliveries, newest first */
+  listWebhookDeliveries(id: number, query: { status?: string } = {}): Promise<WebhookDelivery[]> {
+    return this.request('GET', `/api/v1/webhooks/${encodeURIComponent(id)}/deliveries`, query);
+  }
+
+  /** List the works with editions in the catalog */
+  getWorks(): Promise<Work[]> {
+    return this.request('GET', `/api/v1/works`);
+  }
+
+  /** Get a work with its editions */
+  getWork(id: number): Promise<Work> {
+    return this.request('GET', `/api/v1/works/${encodeURIComponent(id)}`);
+  }
+
+  /** Run a GraphQL query */
+  queryGraphQL(query: { query: string; variables?: string; operationName?: string }): Promise<GraphQLResponse> {
+    return this.request('GET', `/graphql`, query);
+  }
+
+  /** Run a GraphQL query or mutation */
+  postGraphQL(body: Partial<{
+  query: string;
+  variables?: Record<string, unknown>;
+  operationName?: string;
+}>): Promise<GraphQLResponse> {
+    return this.request('POST', `/graphql`, undefined, body);
+  }
+
+  /** Health check */
+  health(): Promise<HealthStatus> {
+    return this.request('GET', `/health`);
+  }
+
+  /** Readiness: the database and replication checks */
+  readiness(): Promise<Readiness> {
+    return this.request('GET', `/readyz`);
+  }
+}